@@ -372,6 +372,8 @@ export const SPECIAL_FILTER_ID = {
     PARENTAL: -3,
     SAFE_BROWSING: -4,
     SAFE_SEARCH: -5,
+    CLIENT_RULES: -6,
+    BLOCKED_SUFFIXES: -7,
 };
 
 export const BLOCK_ACTIONS = {
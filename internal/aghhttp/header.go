@@ -8,3 +8,7 @@ const (
 	HdrValStrictTransportSecurity = "max-age=31536000; includeSubDomains"
 	HdrValTextPlain               = "text/plain"
 )
+
+// SessionCookieName is the name of the cookie containing the session token of
+// the web interface.
+const SessionCookieName = "agh_session"
@@ -0,0 +1,206 @@
+package aghhttp
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/httphdr"
+	"golang.org/x/time/rate"
+)
+
+// RateLimiterConfig is the configuration structure for a [RateLimiter].
+type RateLimiterConfig struct {
+	// Logger is used to log the rate-limited requests.  It must not be nil.
+	Logger *slog.Logger
+
+	// IsExempt returns true if r must not be rate limited, for example because
+	// it's authenticated.  It may be nil.
+	IsExempt func(r *http.Request) (ok bool)
+
+	// RemoteIP returns the IP address of the client that has made r, for
+	// example resolved using the headers set by trusted proxies.  If it's nil,
+	// the address from [http.Request.RemoteAddr] is used.
+	RemoteIP func(r *http.Request) (ip netip.Addr, err error)
+
+	// MaxRequests is the maximum number of requests from a single IP address
+	// within Window.  If it's zero or negative, the rate limiting is disabled.
+	MaxRequests int
+
+	// Window is the duration of the window within which MaxRequests are
+	// allowed.  It must be positive if MaxRequests is positive.
+	Window time.Duration
+}
+
+// rateEntry is the limiter of a single IP address.
+type rateEntry struct {
+	// limiter is the token bucket of the address.
+	limiter *rate.Limiter
+
+	// lastSeen is the time of the latest request from the address.
+	lastSeen time.Time
+}
+
+// RateLimiter limits the number of HTTP requests from each IP address using a
+// token bucket for every address.  The buckets of the addresses that haven't
+// made any requests for two windows are removed.  It's safe for concurrent use.
+type RateLimiter struct {
+	// mu protects entries and lastEviction.
+	mu *sync.Mutex
+
+	// entries maps the IP addresses to their limiters.
+	entries map[netip.Addr]*rateEntry
+
+	// lastEviction is the time of the latest removal of the idle entries.
+	lastEviction time.Time
+
+	// logger is used to log the rate-limited requests.
+	logger *slog.Logger
+
+	// isExempt returns true if the request must not be rate limited.
+	isExempt func(r *http.Request) (ok bool)
+
+	// remoteIP returns the IP address of the client.
+	remoteIP func(r *http.Request) (ip netip.Addr, err error)
+
+	// now returns the current time.  It's replaced in tests.
+	now func() (t time.Time)
+
+	// limit is the rate at which the tokens are added to each bucket.
+	limit rate.Limit
+
+	// burst is the capacity of each bucket.
+	burst int
+
+	// window is the duration of the window.
+	window time.Duration
+}
+
+// NewRateLimiter returns a new properly initialized *RateLimiter.  conf must
+// not be nil.
+func NewRateLimiter(conf *RateLimiterConfig) (l *RateLimiter) {
+	l = &RateLimiter{
+		mu:       &sync.Mutex{},
+		entries:  map[netip.Addr]*rateEntry{},
+		logger:   conf.Logger,
+		isExempt: conf.IsExempt,
+		remoteIP: conf.RemoteIP,
+		now:      time.Now,
+		window:   conf.Window,
+	}
+
+	if l.remoteIP == nil {
+		l.remoteIP = remoteAddrIP
+	}
+
+	if conf.MaxRequests > 0 && conf.Window > 0 {
+		l.burst = conf.MaxRequests
+		l.limit = rate.Every(conf.Window / time.Duration(conf.MaxRequests))
+	}
+
+	return l
+}
+
+// remoteAddrIP returns the IP address from [http.Request.RemoteAddr] of r.
+func remoteAddrIP(r *http.Request) (ip netip.Addr, err error) {
+	addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return netip.Addr{}, err
+	}
+
+	return addrPort.Addr(), nil
+}
+
+// Wrap returns a handler that responds with HTTP 429 Too Many Requests to the
+// requests exceeding the limit and passes the rest to h.  Wrap is a middleware.
+func (l *RateLimiter) Wrap(h http.Handler) (wrapped http.Handler) {
+	if l.burst == 0 {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.isExempt != nil && l.isExempt(r) {
+			h.ServeHTTP(w, r)
+
+			return
+		}
+
+		ip, err := l.remoteIP(r)
+		if err != nil {
+			// Shouldn't happen, since the address is set by the server.
+			h.ServeHTTP(w, r)
+
+			return
+		}
+
+		ip = ip.Unmap()
+		retryAfter, ok := l.allow(ip)
+		if ok {
+			h.ServeHTTP(w, r)
+
+			return
+		}
+
+		l.logger.DebugContext(
+			r.Context(),
+			"rate limited",
+			"raddr", ip,
+			"method", r.Method,
+			"request_uri", r.RequestURI,
+		)
+
+		w.Header().Set(httphdr.RetryAfter, strconv.Itoa(retryAfter))
+		http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+	})
+}
+
+// allow returns true if the request from ip is allowed and consumes a token from
+// its bucket.  Otherwise, it returns the number of seconds after which the next
+// request is allowed.
+func (l *RateLimiter) allow(ip netip.Addr) (retryAfter int, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Sub(l.lastEviction) >= l.window {
+		l.evict(now)
+	}
+
+	e, ok := l.entries[ip]
+	if !ok {
+		e = &rateEntry{
+			limiter: rate.NewLimiter(l.limit, l.burst),
+		}
+		l.entries[ip] = e
+	}
+
+	e.lastSeen = now
+
+	res := e.limiter.ReserveN(now, 1)
+	delay := res.DelayFrom(now)
+	if delay == 0 {
+		return 0, true
+	}
+
+	// Don't consume the token that the client isn't allowed to use yet.
+	res.CancelAt(now)
+
+	return max(1, int(math.Ceil(delay.Seconds()))), false
+}
+
+// evict removes the entries of the addresses that haven't made any requests for
+// two windows.  l.mu is expected to be locked.
+func (l *RateLimiter) evict(now time.Time) {
+	for ip, e := range l.entries {
+		if now.Sub(e.lastSeen) >= 2*l.window {
+			delete(l.entries, ip)
+		}
+	}
+
+	l.lastEviction = now
+}
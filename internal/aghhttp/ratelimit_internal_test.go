@@ -0,0 +1,163 @@
+package aghhttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter(t *testing.T) {
+	t.Parallel()
+
+	const (
+		maxRequests = 3
+		window      = 1 * time.Minute
+
+		testCookie = "valid"
+	)
+
+	okHdlr := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	l := NewRateLimiter(&RateLimiterConfig{
+		Logger: slogutil.NewDiscardLogger(),
+		IsExempt: func(r *http.Request) (ok bool) {
+			return r.Header.Get(httphdr.Cookie) == testCookie
+		},
+		MaxRequests: maxRequests,
+		Window:      window,
+	})
+
+	now := time.Unix(0, 0)
+	l.now = func() (t time.Time) { return now }
+
+	h := l.Wrap(okHdlr)
+
+	serve := func(t *testing.T, raddr string, authenticated bool) (rw *httptest.ResponseRecorder) {
+		t.Helper()
+
+		r := httptest.NewRequest(http.MethodPost, "/control/test_upstream_dns", nil)
+		r.RemoteAddr = raddr
+		if authenticated {
+			r.Header.Set(httphdr.Cookie, testCookie)
+		}
+
+		rw = httptest.NewRecorder()
+		h.ServeHTTP(rw, r)
+
+		return rw
+	}
+
+	const (
+		raddr      = "192.0.2.1:12345"
+		otherRaddr = "192.0.2.2:12345"
+	)
+
+	t.Run("threshold", func(t *testing.T) {
+		for range maxRequests {
+			assert.Equal(t, http.StatusOK, serve(t, raddr, false).Code)
+		}
+
+		rw := serve(t, raddr, false)
+		require.Equal(t, http.StatusTooManyRequests, rw.Code)
+
+		// One token is added every window/maxRequests, which is 20 seconds.
+		assert.Equal(t, "20", rw.Header().Get(httphdr.RetryAfter))
+
+		assert.Equal(t, http.StatusOK, serve(t, otherRaddr, false).Code)
+	})
+
+	t.Run("authenticated", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve(t, raddr, true).Code)
+	})
+
+	t.Run("retry_after", func(t *testing.T) {
+		now = now.Add(15 * time.Second)
+
+		rw := serve(t, raddr, false)
+		require.Equal(t, http.StatusTooManyRequests, rw.Code)
+
+		assert.Equal(t, "5", rw.Header().Get(httphdr.RetryAfter))
+
+		now = now.Add(5 * time.Second)
+
+		assert.Equal(t, http.StatusOK, serve(t, raddr, false).Code)
+	})
+
+	t.Run("eviction", func(t *testing.T) {
+		now = now.Add(2 * window)
+
+		_, ok := l.allow(netip.MustParseAddr("192.0.2.3"))
+		require.True(t, ok)
+
+		assert.Len(t, l.entries, 1)
+	})
+}
+
+func TestRateLimiter_disabled(t *testing.T) {
+	t.Parallel()
+
+	okHdlr := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	l := NewRateLimiter(&RateLimiterConfig{
+		Logger:      slogutil.NewDiscardLogger(),
+		MaxRequests: 0,
+		Window:      time.Minute,
+	})
+
+	h := l.Wrap(okHdlr)
+
+	for range 10 {
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		assert.Equal(t, http.StatusOK, rw.Code)
+	}
+}
+
+func TestRateLimiter_remoteIP(t *testing.T) {
+	t.Parallel()
+
+	okHdlr := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	l := NewRateLimiter(&RateLimiterConfig{
+		Logger: slogutil.NewDiscardLogger(),
+		RemoteIP: func(r *http.Request) (ip netip.Addr, err error) {
+			return netip.ParseAddr(r.Header.Get(httphdr.XRealIP))
+		},
+		MaxRequests: 1,
+		Window:      time.Minute,
+	})
+
+	h := l.Wrap(okHdlr)
+
+	serve := func(t *testing.T, realIP string) (code int) {
+		t.Helper()
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = "192.0.2.1:12345"
+		r.Header.Set(httphdr.XRealIP, realIP)
+
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, r)
+
+		return rw.Code
+	}
+
+	// The clients behind the same proxy are limited separately.
+	assert.Equal(t, http.StatusOK, serve(t, "198.51.100.1"))
+	assert.Equal(t, http.StatusTooManyRequests, serve(t, "198.51.100.1"))
+	assert.Equal(t, http.StatusOK, serve(t, "198.51.100.2"))
+}
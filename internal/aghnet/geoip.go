@@ -0,0 +1,119 @@
+package aghnet
+
+import (
+	"io/fs"
+	"net/netip"
+	"sync"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/oschwald/geoip2-golang"
+)
+
+// geoIPPrefix is a prefix for logging in GeoIPTagger's methods.
+const geoIPPrefix = "geoip"
+
+// GeoIPTagger looks up the countries of IP addresses in a MaxMind
+// GeoLite2-Country database.  The database is loaded lazily on the first
+// lookup.  If the database file doesn't exist, lookups return no country.  It's
+// safe for concurrent use.
+type GeoIPTagger struct {
+	// mu protects reader and loaded.
+	mu *sync.RWMutex
+
+	// reader is the opened database.  It's nil if the database couldn't be
+	// loaded.
+	reader *geoip2.Reader
+
+	// path is the path to the database file.
+	path string
+
+	// loaded is true if loading of the database has been attempted since the
+	// creation or the last reload.
+	loaded bool
+}
+
+// NewGeoIPTagger returns a new *GeoIPTagger for the database located at path.
+// The database isn't opened until the first lookup.
+func NewGeoIPTagger(path string) (t *GeoIPTagger) {
+	return &GeoIPTagger{
+		mu:   &sync.RWMutex{},
+		path: path,
+	}
+}
+
+// Country returns the two-letter ISO 3166-1 code of the country of ip.  code
+// is empty if the database is unavailable or has no information about ip.
+func (t *GeoIPTagger) Country(ip netip.Addr) (code string) {
+	if !ip.IsValid() {
+		return ""
+	}
+
+	t.mu.RLock()
+	if !t.loaded {
+		t.mu.RUnlock()
+		t.load()
+		t.mu.RLock()
+	}
+
+	// Keep the lock during the lookup, since the reader mustn't be closed by
+	// [GeoIPTagger.Reload] while it's used.
+	defer t.mu.RUnlock()
+
+	if t.reader == nil {
+		return ""
+	}
+
+	c, err := t.reader.Country(ip.Unmap().AsSlice())
+	if err != nil {
+		log.Debug("%s: looking up %s: %s", geoIPPrefix, ip, err)
+
+		return ""
+	}
+
+	return c.Country.IsoCode
+}
+
+// load opens the database, unless it has already been attempted.
+func (t *GeoIPTagger) load() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.loaded {
+		return
+	}
+
+	t.loaded = true
+
+	r, err := geoip2.Open(t.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		log.Debug("%s: database %q not found; disabling", geoIPPrefix, t.path)
+
+		return
+	} else if err != nil {
+		log.Error("%s: opening database %q: %s", geoIPPrefix, t.path, err)
+
+		return
+	}
+
+	log.Debug("%s: loaded database %q", geoIPPrefix, t.path)
+
+	t.reader = r
+}
+
+// Reload closes the currently opened database, so that it's loaded again on
+// the next lookup.
+func (t *GeoIPTagger) Reload() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.reader != nil {
+		err := t.reader.Close()
+		if err != nil {
+			log.Debug("%s: closing database %q: %s", geoIPPrefix, t.path, err)
+		}
+	}
+
+	t.reader = nil
+	t.loaded = false
+}
@@ -0,0 +1,66 @@
+package aghnet_test
+
+import (
+	"net/netip"
+	"path/filepath"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/stretchr/testify/assert"
+)
+
+// testGeoIPDBPath is the path to the test GeoLite2-Country database.  It maps
+// 1.2.3.0/24 to AU and 192.0.2.0/24 to DE.
+var testGeoIPDBPath = filepath.Join("testdata", "GeoLite2-Country-Test.mmdb")
+
+func TestGeoIPTagger_Country(t *testing.T) {
+	tagger := aghnet.NewGeoIPTagger(testGeoIPDBPath)
+
+	testCases := []struct {
+		ip   netip.Addr
+		name string
+		want string
+	}{{
+		ip:   netip.MustParseAddr("1.2.3.4"),
+		name: "au",
+		want: "AU",
+	}, {
+		ip:   netip.MustParseAddr("192.0.2.1"),
+		name: "de",
+		want: "DE",
+	}, {
+		ip:   netip.MustParseAddr("::ffff:192.0.2.1"),
+		name: "de_mapped",
+		want: "DE",
+	}, {
+		ip:   netip.MustParseAddr("198.51.100.1"),
+		name: "unknown",
+		want: "",
+	}, {
+		ip:   netip.MustParseAddr("2001:db8::1"),
+		name: "ipv6",
+		want: "",
+	}, {
+		ip:   netip.Addr{},
+		name: "invalid",
+		want: "",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, tagger.Country(tc.ip))
+		})
+	}
+
+	t.Run("reload", func(t *testing.T) {
+		tagger.Reload()
+
+		assert.Equal(t, "AU", tagger.Country(netip.MustParseAddr("1.2.3.4")))
+	})
+
+	t.Run("no_database", func(t *testing.T) {
+		absent := aghnet.NewGeoIPTagger(filepath.Join(t.TempDir(), "absent.mmdb"))
+
+		assert.Empty(t, absent.Country(netip.MustParseAddr("1.2.3.4")))
+	})
+}
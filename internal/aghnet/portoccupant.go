@@ -0,0 +1,81 @@
+package aghnet
+
+import (
+	"net/netip"
+	"strings"
+)
+
+// PortOccupantHint is a machine-readable hint about the process occupying a
+// port, which the frontend maps to the instructions on freeing it.
+type PortOccupantHint string
+
+// Valid PortOccupantHint values.
+const (
+	// PortOccupantHintSystemdResolved means that the port is occupied by the
+	// DNS stub listener of systemd-resolved.
+	PortOccupantHintSystemdResolved PortOccupantHint = "systemd_resolved"
+
+	// PortOccupantHintDnsmasq means that the port is occupied by dnsmasq.
+	PortOccupantHintDnsmasq PortOccupantHint = "dnsmasq"
+
+	// PortOccupantHintBind means that the port is occupied by BIND.
+	PortOccupantHintBind PortOccupantHint = "bind"
+
+	// PortOccupantHintUnknown means that the port is occupied by a process,
+	// which isn't recognized.
+	PortOccupantHintUnknown PortOccupantHint = "unknown"
+)
+
+// PortOccupant is the process occupying a port.
+type PortOccupant struct {
+	// Process is the name of the process.
+	Process string `json:"process"`
+
+	// Hint is the hint about the process.
+	Hint PortOccupantHint `json:"hint"`
+
+	// PID is the identifier of the process.
+	PID int `json:"pid"`
+}
+
+// FindPortOccupant returns the process occupying the port of ipp for network,
+// which is expected to be one of "udp" and "tcp".  o is nil if the process
+// can't be found.  If the search isn't supported by the OS, err wraps
+// [errors.ErrUnsupported].
+func FindPortOccupant(network string, ipp netip.AddrPort) (o *PortOccupant, err error) {
+	o, err = findPortOccupant(network, ipp)
+	if o != nil {
+		o.Hint = portOccupantHint(o.Process)
+	}
+
+	return o, err
+}
+
+// portOccupantHint returns the hint for the process with name.
+func portOccupantHint(name string) (h PortOccupantHint) {
+	name = strings.TrimSuffix(strings.ToLower(name), ".exe")
+	switch name {
+	case "systemd-resolved", "systemd-resolve":
+		// The name in /proc/<pid>/comm is truncated to 15 characters.
+		return PortOccupantHintSystemdResolved
+	case "dnsmasq":
+		return PortOccupantHintDnsmasq
+	case "named":
+		return PortOccupantHintBind
+	default:
+		return PortOccupantHintUnknown
+	}
+}
+
+// portMatches returns true if the socket bound to addr occupies the port of
+// ipp.  The unspecified addresses occupy the port for any address of the same
+// family and vice versa.
+func portMatches(addr, want netip.AddrPort) (ok bool) {
+	if addr.Port() != want.Port() {
+		return false
+	}
+
+	a, w := addr.Addr().Unmap(), want.Addr().Unmap()
+
+	return a == w || a.IsUnspecified() || w.IsUnspecified()
+}
@@ -0,0 +1,15 @@
+//go:build darwin || freebsd || openbsd
+
+package aghnet
+
+import (
+	"net/netip"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghos"
+)
+
+// findPortOccupant implements the [FindPortOccupant] for BSD-based systems.
+// It's unsupported, since netstat doesn't show the processes on these systems.
+func findPortOccupant(_ string, _ netip.AddrPort) (o *PortOccupant, err error) {
+	return nil, aghos.Unsupported("finding port occupant")
+}
@@ -0,0 +1,175 @@
+//go:build linux
+
+package aghnet
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"net/netip"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/errors"
+)
+
+// procReadlink returns the destination of the symbolic link with name, which
+// is relative to the root directory.  It's replaced in tests, since [fs.FS]
+// doesn't support symbolic links.
+var procReadlink = func(name string) (dest string, err error) {
+	return os.Readlink("/" + name)
+}
+
+// tcpStateListen is the state of the listening TCP sockets in /proc/net/tcp.
+const tcpStateListen = "0A"
+
+// findPortOccupant implements the [FindPortOccupant] for Linux.  It finds the
+// inodes of the sockets in /proc/net and then the process having a descriptor
+// of one of them.
+func findPortOccupant(network string, ipp netip.AddrPort) (o *PortOccupant, err error) {
+	inodes := map[string]struct{}{}
+	for _, suffix := range []string{"", "6"} {
+		name := path.Join("proc/net", network+suffix)
+		err = procSocketInodes(name, network == "tcp", ipp, inodes)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", name, err)
+		}
+	}
+
+	if len(inodes) == 0 {
+		return nil, nil
+	}
+
+	procs, err := fs.ReadDir(rootDirFS, "proc")
+	if err != nil {
+		return nil, fmt.Errorf("reading proc: %w", err)
+	}
+
+	for _, p := range procs {
+		pid, pidErr := strconv.Atoi(p.Name())
+		if pidErr != nil || !procHasSocket(p.Name(), inodes) {
+			continue
+		}
+
+		comm, commErr := fs.ReadFile(rootDirFS, path.Join("proc", p.Name(), "comm"))
+		if commErr != nil {
+			return nil, fmt.Errorf("reading process name: %w", commErr)
+		}
+
+		return &PortOccupant{
+			Process: strings.TrimSpace(string(comm)),
+			PID:     pid,
+		}, nil
+	}
+
+	return nil, nil
+}
+
+// procSocketInodes adds the inodes of the sockets from the /proc/net table with
+// name, which occupy the port of ipp, into inodes.  If listening is true, only
+// the listening sockets are considered.  A missing table isn't an error, since
+// the IPv6 ones are absent when IPv6 is disabled.
+func procSocketInodes(
+	name string,
+	listening bool,
+	ipp netip.AddrPort,
+	inodes map[string]struct{},
+) (err error) {
+	f, err := rootDirFS.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+	defer func() { err = errors.WithDeferred(err, f.Close()) }()
+
+	s := bufio.NewScanner(f)
+
+	// Skip the header.
+	s.Scan()
+
+	for s.Scan() {
+		// The fields are: sl, local_address, rem_address, st, tx_queue:rx_queue,
+		// tr:tm->when, retrnsmt, uid, timeout, and inode.
+		fields := strings.Fields(s.Text())
+		if len(fields) < 10 || (listening && fields[3] != tcpStateListen) {
+			continue
+		}
+
+		addr, parseErr := parseProcAddrPort(fields[1])
+		if parseErr != nil {
+			return fmt.Errorf("parsing local address: %w", parseErr)
+		}
+
+		if portMatches(addr, ipp) {
+			inodes[fields[9]] = struct{}{}
+		}
+	}
+
+	return s.Err()
+}
+
+// parseProcAddrPort parses the address of the form "0100007F:0035" from the
+// /proc/net tables.  The address is printed as a sequence of 32-bit words in
+// the host byte order.
+func parseProcAddrPort(s string) (ipp netip.AddrPort, err error) {
+	addrStr, portStr, ok := strings.Cut(s, ":")
+	if !ok {
+		return ipp, fmt.Errorf("bad address %q", s)
+	}
+
+	port, err := strconv.ParseUint(portStr, 16, 16)
+	if err != nil {
+		return ipp, fmt.Errorf("bad port: %w", err)
+	}
+
+	data, err := hex.DecodeString(addrStr)
+	if err != nil {
+		return ipp, fmt.Errorf("bad address: %w", err)
+	}
+
+	for i := 0; i+4 <= len(data); i += 4 {
+		word := binary.BigEndian.Uint32(data[i:])
+		binary.NativeEndian.PutUint32(data[i:], word)
+	}
+
+	addr, ok := netip.AddrFromSlice(data)
+	if !ok {
+		return ipp, fmt.Errorf("bad address length %d", len(data))
+	}
+
+	return netip.AddrPortFrom(addr, uint16(port)), nil
+}
+
+// procHasSocket returns true if the process with pid has a descriptor of a
+// socket with one of inodes.  The inaccessible descriptors are ignored.
+func procHasSocket(pid string, inodes map[string]struct{}) (ok bool) {
+	fdDir := path.Join("proc", pid, "fd")
+	fds, err := fs.ReadDir(rootDirFS, fdDir)
+	if err != nil {
+		return false
+	}
+
+	for _, fd := range fds {
+		dest, linkErr := procReadlink(path.Join(fdDir, fd.Name()))
+		if linkErr != nil {
+			continue
+		}
+
+		inode, found := strings.CutPrefix(dest, "socket:[")
+		if !found {
+			continue
+		}
+
+		if _, ok = inodes[strings.TrimSuffix(inode, "]")]; ok {
+			return true
+		}
+	}
+
+	return false
+}
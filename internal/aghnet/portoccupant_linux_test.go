@@ -0,0 +1,152 @@
+//go:build linux
+
+package aghnet
+
+import (
+	"io/fs"
+	"net/netip"
+	"testing"
+	"testing/fstest"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// substProcReadlink replaces function reading the links in the proc
+// filesystem with one using links and restores it on cleanup.
+func substProcReadlink(t testing.TB, links map[string]string) {
+	t.Helper()
+
+	prev := procReadlink
+	t.Cleanup(func() { procReadlink = prev })
+	procReadlink = func(name string) (dest string, err error) {
+		dest, ok := links[name]
+		if !ok {
+			return "", fs.ErrNotExist
+		}
+
+		return dest, nil
+	}
+}
+
+func TestFindPortOccupant(t *testing.T) {
+	// The addresses in the tables are printed in the host byte order, so the
+	// test data is for the little-endian hosts.
+	const (
+		procNetHeader = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when ` +
+			`retrnsmt   uid  timeout inode` + nl
+
+		// 127.0.0.53:53, unconnected.
+		resolvedUDP = `   0: 3500007F:0035 00000000:0000 07 00000000:00000000 00:00000000 ` +
+			`00000000   991        0 18736 2 0000000000000000 0` + nl
+
+		// 0.0.0.0:53, listening.
+		dnsmasqTCP = `   0: 00000000:0035 00000000:0000 0A 00000000:00000000 00:00000000 ` +
+			`00000000     0        0 20000 1 0000000000000000 100 0 0 10 0` + nl
+
+		// 127.0.0.1:8080, established.
+		otherTCP = `   1: 0100007F:1F90 0100007F:D431 01 00000000:00000000 00:00000000 ` +
+			`00000000  1000        0 30000 1 0000000000000000 20 4 1 10 -1` + nl
+
+		// [::]:5353, unconnected.
+		mdnsUDP6 = `   0: 00000000000000000000000000000000:14E9 ` +
+			`00000000000000000000000000000000:0000 07 00000000:00000000 00:00000000 ` +
+			`00000000   101        0 40000 2 0000000000000000 0` + nl
+	)
+
+	substRootDirFS(t, fstest.MapFS{
+		"proc/net/udp":  &fstest.MapFile{Data: []byte(procNetHeader + resolvedUDP)},
+		"proc/net/udp6": &fstest.MapFile{Data: []byte(procNetHeader + mdnsUDP6)},
+		"proc/net/tcp":  &fstest.MapFile{Data: []byte(procNetHeader + dnsmasqTCP + otherTCP)},
+
+		"proc/101/comm": &fstest.MapFile{Data: []byte("systemd-resolve" + nl)},
+		"proc/101/fd/3": &fstest.MapFile{},
+		"proc/101/fd/4": &fstest.MapFile{},
+
+		"proc/202/comm": &fstest.MapFile{Data: []byte("dnsmasq" + nl)},
+		"proc/202/fd/5": &fstest.MapFile{},
+
+		"proc/303/comm": &fstest.MapFile{Data: []byte("avahi-daemon" + nl)},
+		"proc/303/fd/6": &fstest.MapFile{},
+
+		"proc/self/fd/0": &fstest.MapFile{},
+	})
+
+	substProcReadlink(t, map[string]string{
+		"proc/101/fd/3": "/dev/null",
+		"proc/101/fd/4": "socket:[18736]",
+		"proc/202/fd/5": "socket:[20000]",
+		"proc/303/fd/6": "socket:[40000]",
+	})
+
+	testCases := []struct {
+		want    *PortOccupant
+		ipp     netip.AddrPort
+		name    string
+		network string
+	}{{
+		want: &PortOccupant{
+			Process: "systemd-resolve",
+			Hint:    PortOccupantHintSystemdResolved,
+			PID:     101,
+		},
+		ipp:     netip.MustParseAddrPort("0.0.0.0:53"),
+		name:    "systemd_resolved",
+		network: "udp",
+	}, {
+		want:    nil,
+		ipp:     netip.MustParseAddrPort("192.0.2.1:53"),
+		name:    "other_address",
+		network: "udp",
+	}, {
+		want: &PortOccupant{
+			Process: "dnsmasq",
+			Hint:    PortOccupantHintDnsmasq,
+			PID:     202,
+		},
+		ipp:     netip.MustParseAddrPort("192.0.2.1:53"),
+		name:    "dnsmasq_unspecified",
+		network: "tcp",
+	}, {
+		want:    nil,
+		ipp:     netip.MustParseAddrPort("127.0.0.1:8080"),
+		name:    "not_listening",
+		network: "tcp",
+	}, {
+		want: &PortOccupant{
+			Process: "avahi-daemon",
+			Hint:    PortOccupantHintUnknown,
+			PID:     303,
+		},
+		ipp:     netip.MustParseAddrPort("[::1]:5353"),
+		name:    "unknown_ipv6",
+		network: "udp",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			o, err := FindPortOccupant(tc.network, tc.ipp)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.want, o)
+		})
+	}
+
+	t.Run("bad_table", func(t *testing.T) {
+		substRootDirFS(t, fstest.MapFS{
+			"proc/net/udp": &fstest.MapFile{Data: []byte(procNetHeader +
+				`   0: 3500007G:0035 00000000:0000 07 00000000:00000000 00:00000000 ` +
+				`00000000   991        0 18736 2 0000000000000000 0` + nl,
+			)},
+		})
+
+		_, err := FindPortOccupant("udp", netip.MustParseAddrPort("0.0.0.0:53"))
+		testutil.AssertErrorMsg(
+			t,
+			"reading proc/net/udp: parsing local address: bad address: "+
+				"encoding/hex: invalid byte: U+0047 'G'",
+			err,
+		)
+	})
+}
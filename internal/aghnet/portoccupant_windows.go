@@ -0,0 +1,79 @@
+//go:build windows
+
+package aghnet
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// findPortOccupant implements the [FindPortOccupant] for Windows.  It finds the
+// process in the output of netstat and then its name using tasklist.
+func findPortOccupant(network string, ipp netip.AddrPort) (o *PortOccupant, err error) {
+	// Don't use the -p flag, since it only shows the IPv4 sockets.
+	code, out, err := aghosRunCommand("netstat", "-a", "-n", "-o")
+	if err != nil {
+		return nil, fmt.Errorf("running netstat: %w", err)
+	} else if code != 0 {
+		return nil, fmt.Errorf("running netstat: unexpected exit code %d", code)
+	}
+
+	pid, ok := netstatPID(out, network, ipp)
+	if !ok {
+		return nil, nil
+	}
+
+	filter := fmt.Sprintf("PID eq %d", pid)
+	code, out, err = aghosRunCommand("tasklist", "/FI", filter, "/FO", "CSV", "/NH")
+	if err != nil {
+		return nil, fmt.Errorf("running tasklist: %w", err)
+	} else if code != 0 {
+		return nil, fmt.Errorf("running tasklist: unexpected exit code %d", code)
+	}
+
+	// The first field of the output is the image name of the process.
+	rec, err := csv.NewReader(bytes.NewReader(out)).Read()
+	if err != nil {
+		return nil, fmt.Errorf("parsing tasklist output: %w", err)
+	}
+
+	return &PortOccupant{
+		Process: rec[0],
+		PID:     pid,
+	}, nil
+}
+
+// netstatPID returns the identifier of the process occupying the port of ipp
+// from the output of "netstat -a -n -o".  Each line has the fields: the
+// protocol, the local address, the foreign address, the state, which is absent
+// for UDP, and the PID.
+func netstatPID(out []byte, network string, ipp netip.AddrPort) (pid int, ok bool) {
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 4 || !strings.EqualFold(fields[0], network) {
+			continue
+		}
+
+		if network == "tcp" && fields[3] != "LISTENING" {
+			continue
+		}
+
+		addr, err := netip.ParseAddrPort(fields[1])
+		if err != nil || !portMatches(addr, ipp) {
+			continue
+		}
+
+		pid, err = strconv.Atoi(fields[len(fields)-1])
+		if err == nil {
+			return pid, true
+		}
+	}
+
+	return 0, false
+}
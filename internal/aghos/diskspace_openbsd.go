@@ -0,0 +1,17 @@
+//go:build openbsd
+
+package aghos
+
+import "syscall"
+
+func diskSpace(path string) (avail, total uint64, err error) {
+	var st syscall.Statfs_t
+	err = syscall.Statfs(path, &st)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	bsize := uint64(st.F_bsize)
+
+	return uint64(st.F_bavail) * bsize, st.F_blocks * bsize, nil
+}
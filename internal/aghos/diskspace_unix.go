@@ -0,0 +1,19 @@
+//go:build darwin || freebsd || linux
+
+package aghos
+
+import "syscall"
+
+func diskSpace(path string) (avail, total uint64, err error) {
+	var st syscall.Statfs_t
+	err = syscall.Statfs(path, &st)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// The types of the fields differ between the operating systems and the
+	// architectures.
+	bsize := uint64(st.Bsize)
+
+	return uint64(st.Bavail) * bsize, uint64(st.Blocks) * bsize, nil
+}
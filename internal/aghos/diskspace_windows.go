@@ -0,0 +1,19 @@
+//go:build windows
+
+package aghos
+
+import "golang.org/x/sys/windows"
+
+func diskSpace(path string) (avail, total uint64, err error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	err = windows.GetDiskFreeSpaceEx(p, &avail, &total, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return avail, total, nil
+}
@@ -44,6 +44,12 @@ func HaveAdminRights() (bool, error) {
 	return haveAdminRights()
 }
 
+// DiskSpace returns the number of bytes available to the current user and the
+// total number of bytes on the filesystem containing path.
+func DiskSpace(path string) (avail, total uint64, err error) {
+	return diskSpace(path)
+}
+
 // MaxCmdOutputSize is the maximum length of performed shell command output in
 // bytes.
 const MaxCmdOutputSize = 64 * 1024
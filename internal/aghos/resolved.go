@@ -0,0 +1,452 @@
+package aghos
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+)
+
+// CommandRunner is the function running the shell commands.  [RunCommand] is
+// its default implementation.
+type CommandRunner func(command string, args ...string) (code int, output []byte, err error)
+
+// Paths of the files changed by [ResolvedStub] relative to the root directory.
+const (
+	resolvedConfPath   = "etc/systemd/resolved.conf"
+	resolvedDropInPath = "etc/systemd/resolved.conf.d/adguardhome.conf"
+	resolvConfPath     = "etc/resolv.conf"
+	resolvConfBakPath  = "etc/resolv.conf.backup"
+)
+
+// resolvedStubResolvConf is the resolv.conf file maintained by systemd-resolved,
+// which lists the actual upstream servers instead of the stub listener.
+const resolvedStubResolvConf = "/run/systemd/resolve/resolv.conf"
+
+// resolvedDropInData is the content of the drop-in configuration file, which
+// disables the stub listener of systemd-resolved.
+const resolvedDropInData = `[Resolve]
+DNS=127.0.0.1
+DNSStubListener=no
+`
+
+// ResolvedStubConfig is the configuration structure for a [ResolvedStub].
+type ResolvedStubConfig struct {
+	// Logger is used to log the performed actions.  It must not be nil.
+	Logger *slog.Logger
+
+	// RunCommand runs the systemctl and grep commands.  It must not be nil.
+	RunCommand CommandRunner
+
+	// RootDir is the directory, relative to which the system files are
+	// accessed.  It's "/" for the actual system.
+	RootDir string
+
+	// StatePath is the path to the file, in which the changes made by
+	// [ResolvedStub.Disable] are stored, so that [ResolvedStub.Restore] can
+	// revert them later.
+	StatePath string
+}
+
+// ResolvedStub disables and restores the DNS stub listener of systemd-resolved,
+// which occupies port 53 on many Linux systems.
+type ResolvedStub struct {
+	logger     *slog.Logger
+	runCommand CommandRunner
+	rootDir    string
+	statePath  string
+}
+
+// NewResolvedStub returns a new properly initialized *ResolvedStub.  c must not
+// be nil.
+func NewResolvedStub(c *ResolvedStubConfig) (r *ResolvedStub) {
+	return &ResolvedStub{
+		logger:     c.Logger,
+		runCommand: c.RunCommand,
+		rootDir:    c.RootDir,
+		statePath:  c.StatePath,
+	}
+}
+
+// ErrResolvedStubDisabled is returned by [ResolvedStub.Disable] if the stub
+// listener has already been disabled by it and hasn't been restored since.
+const ErrResolvedStubDisabled errors.Error = "resolved stub listener is already disabled"
+
+// resolvedStubState is the record of the changes made by
+// [ResolvedStub.Disable].
+type resolvedStubState struct {
+	// PrevDropIn is the previous content of the drop-in configuration file.
+	// It's only used if DropInExisted is true.
+	PrevDropIn []byte `json:"prev_drop_in,omitempty"`
+
+	// ResolvConfBackup is the path to the backup of the resolv.conf file.  It's
+	// empty if the file hasn't been a regular file.
+	ResolvConfBackup string `json:"resolv_conf_backup,omitempty"`
+
+	// ResolvConfLink is the previous target of the resolv.conf file.  It's
+	// empty if the file hasn't been a symbolic link.
+	ResolvConfLink string `json:"resolv_conf_link,omitempty"`
+
+	// DropInExisted is true if the drop-in configuration file has existed
+	// before.
+	DropInExisted bool `json:"drop_in_existed"`
+
+	// DropInChanged is true if the drop-in configuration file has been
+	// written.
+	DropInChanged bool `json:"drop_in_changed"`
+
+	// ResolvConfChanged is true if the resolv.conf file has been replaced.
+	ResolvConfChanged bool `json:"resolv_conf_changed"`
+}
+
+// path returns the absolute path to the system file with the path rel relative
+// to the root directory.
+func (r *ResolvedStub) path(rel string) (p string) {
+	return filepath.Join(r.rootDir, rel)
+}
+
+// run runs the command and returns an error if it fails or exits with a
+// non-zero code.
+func (r *ResolvedStub) run(ctx context.Context, command string, args ...string) (err error) {
+	r.logger.DebugContext(ctx, "executing", "cmd", command, "args", args)
+
+	code, out, err := r.runCommand(command, args...)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	} else if code != 0 {
+		return fmt.Errorf("%s: unexpected exit code %d: %s", command, code, out)
+	}
+
+	return nil
+}
+
+// CanDisable returns true if the stub listener of systemd-resolved is active
+// and can be disabled.  It's false if the stub listener has already been
+// disabled by the drop-in configuration file.
+func (r *ResolvedStub) CanDisable(ctx context.Context) (ok bool) {
+	if runtime.GOOS != "linux" {
+		return false
+	}
+
+	if r.dropInDisablesStub(ctx) {
+		return false
+	}
+
+	err := r.run(ctx, "systemctl", "is-enabled", "systemd-resolved")
+	if err != nil {
+		r.logger.InfoContext(ctx, "checking systemd-resolved", slogutil.KeyError, err)
+
+		return false
+	}
+
+	err = r.run(ctx, "grep", "-E", "#?DNSStubListener=yes", r.path(resolvedConfPath))
+	if err != nil {
+		r.logger.InfoContext(ctx, "checking stub listener", slogutil.KeyError, err)
+
+		return false
+	}
+
+	return true
+}
+
+// dropInDisablesStub returns true if the drop-in configuration file exists and
+// disables the stub listener.
+func (r *ResolvedStub) dropInDisablesStub(ctx context.Context) (ok bool) {
+	dropIn := r.path(resolvedDropInPath)
+
+	// #nosec G304 -- Trust the path, since it's a constant relative to the root
+	// directory set by the program itself.
+	data, err := os.ReadFile(dropIn)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			r.logger.InfoContext(ctx, "checking drop-in", slogutil.KeyError, err)
+		}
+
+		return false
+	}
+
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		if bytes.Equal(bytes.TrimSpace(s.Bytes()), []byte("DNSStubListener=no")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Disable disables the stub listener of systemd-resolved by writing the
+// drop-in configuration file, points resolv.conf to the one maintained by
+// systemd-resolved, and restarts it.  The changes are stored to the state file
+// before restarting.  If any step fails, the changes made are reverted.  err
+// wraps [errors.ErrUnsupported] if systemd-resolved isn't used on the system
+// and is [ErrResolvedStubDisabled] if the state file already exists, so that
+// the changes recorded in it aren't lost.
+func (r *ResolvedStub) Disable(ctx context.Context) (err error) {
+	defer func() { err = errors.Annotate(err, "disabling resolved stub listener: %w") }()
+
+	_, err = os.Stat(r.statePath)
+	if err == nil {
+		return ErrResolvedStubDisabled
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("checking state: %w", err)
+	}
+
+	if !r.CanDisable(ctx) {
+		return fmt.Errorf("systemd-resolved stub listener is not active: %w", errors.ErrUnsupported)
+	}
+
+	state := &resolvedStubState{}
+	err = r.disable(ctx, state)
+	if err != nil {
+		revertErr := r.revert(ctx, state)
+		if revertErr != nil {
+			r.logger.ErrorContext(ctx, "reverting changes", slogutil.KeyError, revertErr)
+		}
+
+		rmErr := os.Remove(r.statePath)
+		if rmErr != nil && !errors.Is(rmErr, fs.ErrNotExist) {
+			r.logger.ErrorContext(ctx, "removing state", slogutil.KeyError, rmErr)
+		}
+
+		// Don't wrap the error since it's informative enough as is and there is
+		// an annotation deferred already.
+		return err
+	}
+
+	r.logger.InfoContext(ctx, "disabled resolved stub listener", "state", r.statePath)
+
+	return nil
+}
+
+// disable performs the steps of [ResolvedStub.Disable] recording them into
+// state.
+func (r *ResolvedStub) disable(ctx context.Context, state *resolvedStubState) (err error) {
+	dropIn := r.path(resolvedDropInPath)
+	state.PrevDropIn, err = os.ReadFile(dropIn)
+	if err == nil {
+		state.DropInExisted = true
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("reading drop-in: %w", err)
+	}
+
+	// Use the permissions of the system configuration files.
+	err = os.MkdirAll(filepath.Dir(dropIn), 0o755)
+	if err != nil {
+		return fmt.Errorf("creating drop-in dir: %w", err)
+	}
+
+	state.DropInChanged = true
+
+	// #nosec G306 -- The configuration of systemd-resolved must be readable by
+	// its user.
+	err = os.WriteFile(dropIn, []byte(resolvedDropInData), 0o644)
+	if err != nil {
+		return fmt.Errorf("writing drop-in: %w", err)
+	}
+
+	r.logger.InfoContext(ctx, "wrote drop-in", "path", dropIn)
+
+	err = r.replaceResolvConf(ctx, state)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	err = r.writeState(state)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	return r.run(ctx, "systemctl", "reload-or-restart", "systemd-resolved")
+}
+
+// replaceResolvConf replaces resolv.conf with the symbolic link to the one
+// maintained by systemd-resolved and records the previous one into state.
+func (r *ResolvedStub) replaceResolvConf(
+	ctx context.Context,
+	state *resolvedStubState,
+) (err error) {
+	resolvConf := r.path(resolvConfPath)
+	fi, err := os.Lstat(resolvConf)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		// Go on.
+	case err != nil:
+		return fmt.Errorf("inspecting resolv.conf: %w", err)
+	case fi.Mode()&fs.ModeSymlink != 0:
+		state.ResolvConfLink, err = os.Readlink(resolvConf)
+		if err != nil {
+			return fmt.Errorf("reading resolv.conf link: %w", err)
+		}
+
+		err = os.Remove(resolvConf)
+		if err != nil {
+			return fmt.Errorf("removing resolv.conf link: %w", err)
+		}
+	default:
+		state.ResolvConfBackup = r.path(resolvConfBakPath)
+		err = os.Rename(resolvConf, state.ResolvConfBackup)
+		if err != nil {
+			state.ResolvConfBackup = ""
+
+			return fmt.Errorf("backing up resolv.conf: %w", err)
+		}
+	}
+
+	state.ResolvConfChanged = true
+
+	err = os.Symlink(resolvedStubResolvConf, resolvConf)
+	if err != nil {
+		return fmt.Errorf("linking resolv.conf: %w", err)
+	}
+
+	r.logger.InfoContext(
+		ctx,
+		"replaced resolv.conf",
+		"target", resolvedStubResolvConf,
+		"prev_link", state.ResolvConfLink,
+		"backup", state.ResolvConfBackup,
+	)
+
+	return nil
+}
+
+// writeState stores state to the state file.
+func (r *ResolvedStub) writeState(state *resolvedStubState) (err error) {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("encoding state: %w", err)
+	}
+
+	err = os.WriteFile(r.statePath, data, DefaultPermFile)
+	if err != nil {
+		return fmt.Errorf("writing state: %w", err)
+	}
+
+	return nil
+}
+
+// Restore reverts the changes made by [ResolvedStub.Disable] and restarts
+// systemd-resolved.  It does nothing if there are no stored changes.
+func (r *ResolvedStub) Restore(ctx context.Context) (err error) {
+	defer func() { err = errors.Annotate(err, "restoring resolved stub listener: %w") }()
+
+	if runtime.GOOS != "linux" {
+		return Unsupported("restoring resolved stub listener")
+	}
+
+	// #nosec G304 -- Trust the path, since it's set by the program itself.
+	data, err := os.ReadFile(r.statePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		r.logger.DebugContext(ctx, "no changes to restore", "state", r.statePath)
+
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("reading state: %w", err)
+	}
+
+	state := &resolvedStubState{}
+	err = json.Unmarshal(data, state)
+	if err != nil {
+		return fmt.Errorf("decoding state: %w", err)
+	}
+
+	err = r.revert(ctx, state)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is and there is
+		// an annotation deferred already.
+		return err
+	}
+
+	err = r.run(ctx, "systemctl", "reload-or-restart", "systemd-resolved")
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is and there is
+		// an annotation deferred already.
+		return err
+	}
+
+	err = os.Remove(r.statePath)
+	if err != nil {
+		return fmt.Errorf("removing state: %w", err)
+	}
+
+	r.logger.InfoContext(ctx, "restored resolved stub listener")
+
+	return nil
+}
+
+// revert reverts the changes recorded in state.
+func (r *ResolvedStub) revert(ctx context.Context, state *resolvedStubState) (err error) {
+	var errs []error
+
+	if state.ResolvConfChanged {
+		errs = append(errs, r.revertResolvConf(ctx, state))
+	}
+
+	if state.DropInChanged {
+		errs = append(errs, r.revertDropIn(ctx, state))
+	}
+
+	return errors.Join(errs...)
+}
+
+// revertDropIn restores the drop-in configuration file recorded in state.
+func (r *ResolvedStub) revertDropIn(ctx context.Context, state *resolvedStubState) (err error) {
+	dropIn := r.path(resolvedDropInPath)
+	if state.DropInExisted {
+		// #nosec G306 -- The configuration of systemd-resolved must be
+		// readable by its user.
+		err = os.WriteFile(dropIn, state.PrevDropIn, 0o644)
+	} else {
+		err = os.Remove(dropIn)
+		if errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("restoring drop-in: %w", err)
+	}
+
+	r.logger.InfoContext(ctx, "restored drop-in", "path", dropIn)
+
+	return nil
+}
+
+// revertResolvConf restores the resolv.conf file recorded in state.
+func (r *ResolvedStub) revertResolvConf(ctx context.Context, state *resolvedStubState) (err error) {
+	resolvConf := r.path(resolvConfPath)
+	err = os.Remove(resolvConf)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("removing resolv.conf link: %w", err)
+	}
+
+	switch {
+	case state.ResolvConfLink != "":
+		err = os.Symlink(state.ResolvConfLink, resolvConf)
+	case state.ResolvConfBackup != "":
+		err = os.Rename(state.ResolvConfBackup, resolvConf)
+	default:
+		// There has been no resolv.conf.
+	}
+
+	if err != nil {
+		return fmt.Errorf("restoring resolv.conf: %w", err)
+	}
+
+	r.logger.InfoContext(ctx, "restored resolv.conf", "path", resolvConf)
+
+	return nil
+}
@@ -0,0 +1,214 @@
+//go:build linux
+
+package aghos_test
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghos"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testResolvConf is the content of the resolv.conf file used in tests.
+const testResolvConf = "nameserver 127.0.0.53\n"
+
+// newFakeRunner returns a fake [aghos.CommandRunner], which records the
+// executed commands into cmds and fails the command, which starts with
+// failCmd, if it's not empty.
+func newFakeRunner(cmds *[]string, failCmd string) (run aghos.CommandRunner) {
+	return func(command string, args ...string) (code int, out []byte, err error) {
+		cmd := strings.Join(append([]string{command}, args...), " ")
+		*cmds = append(*cmds, cmd)
+
+		if failCmd != "" && strings.HasPrefix(cmd, failCmd) {
+			return 1, []byte("failed"), nil
+		}
+
+		return 0, nil, nil
+	}
+}
+
+// newTestResolvedStub returns a new *aghos.ResolvedStub using a temporary root
+// directory.
+func newTestResolvedStub(
+	t *testing.T,
+	run aghos.CommandRunner,
+) (r *aghos.ResolvedStub, root, statePath string) {
+	t.Helper()
+
+	root = t.TempDir()
+	statePath = filepath.Join(t.TempDir(), "resolved_stub.json")
+
+	err := os.MkdirAll(filepath.Join(root, "etc", "systemd"), 0o755)
+	require.NoError(t, err)
+
+	r = aghos.NewResolvedStub(&aghos.ResolvedStubConfig{
+		Logger:     slogutil.NewDiscardLogger(),
+		RunCommand: run,
+		RootDir:    root,
+		StatePath:  statePath,
+	})
+
+	return r, root, statePath
+}
+
+func TestResolvedStub_regularFile(t *testing.T) {
+	var cmds []string
+	r, root, statePath := newTestResolvedStub(t, newFakeRunner(&cmds, ""))
+
+	resolvConf := filepath.Join(root, "etc", "resolv.conf")
+	err := os.WriteFile(resolvConf, []byte(testResolvConf), 0o644)
+	require.NoError(t, err)
+
+	dropIn := filepath.Join(root, "etc", "systemd", "resolved.conf.d", "adguardhome.conf")
+	ctx := context.Background()
+
+	require.NoError(t, r.Disable(ctx))
+
+	assert.FileExists(t, statePath)
+	assert.FileExists(t, filepath.Join(root, "etc", "resolv.conf.backup"))
+
+	data, err := os.ReadFile(dropIn)
+	require.NoError(t, err)
+
+	assert.Contains(t, string(data), "DNSStubListener=no")
+
+	target, err := os.Readlink(resolvConf)
+	require.NoError(t, err)
+
+	assert.Equal(t, "/run/systemd/resolve/resolv.conf", target)
+
+	require.NoError(t, r.Restore(ctx))
+
+	assert.NoFileExists(t, statePath)
+	assert.NoFileExists(t, dropIn)
+	assert.NoFileExists(t, filepath.Join(root, "etc", "resolv.conf.backup"))
+
+	data, err = os.ReadFile(resolvConf)
+	require.NoError(t, err)
+
+	assert.Equal(t, testResolvConf, string(data))
+
+	assert.Equal(t, []string{
+		"systemctl is-enabled systemd-resolved",
+		"grep -E #?DNSStubListener=yes " + filepath.Join(root, "etc", "systemd", "resolved.conf"),
+		"systemctl reload-or-restart systemd-resolved",
+		"systemctl reload-or-restart systemd-resolved",
+	}, cmds)
+
+	// Restoring again must be a no-op.
+	require.NoError(t, r.Restore(ctx))
+}
+
+func TestResolvedStub_symlink(t *testing.T) {
+	const prevTarget = "../run/systemd/resolve/stub-resolv.conf"
+
+	var cmds []string
+	r, root, _ := newTestResolvedStub(t, newFakeRunner(&cmds, ""))
+
+	resolvConf := filepath.Join(root, "etc", "resolv.conf")
+	err := os.Symlink(prevTarget, resolvConf)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, r.Disable(ctx))
+	require.NoError(t, r.Restore(ctx))
+
+	target, err := os.Readlink(resolvConf)
+	require.NoError(t, err)
+
+	assert.Equal(t, prevTarget, target)
+}
+
+func TestResolvedStub_Disable_twice(t *testing.T) {
+	var cmds []string
+	r, root, statePath := newTestResolvedStub(t, newFakeRunner(&cmds, ""))
+
+	resolvConf := filepath.Join(root, "etc", "resolv.conf")
+	err := os.WriteFile(resolvConf, []byte(testResolvConf), 0o644)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, r.Disable(ctx))
+
+	state, err := os.ReadFile(statePath)
+	require.NoError(t, err)
+
+	// The drop-in disables the stub listener, so it can't be disabled again.
+	assert.False(t, r.CanDisable(ctx))
+
+	err = r.Disable(ctx)
+	assert.ErrorIs(t, err, aghos.ErrResolvedStubDisabled)
+
+	gotState, err := os.ReadFile(statePath)
+	require.NoError(t, err)
+
+	assert.Equal(t, state, gotState)
+
+	require.NoError(t, r.Restore(ctx))
+
+	assert.NoFileExists(t, statePath)
+	assert.NoFileExists(t, filepath.Join(root, "etc", "resolv.conf.backup"))
+
+	data, err := os.ReadFile(resolvConf)
+	require.NoError(t, err)
+
+	assert.Equal(t, testResolvConf, string(data))
+	assert.True(t, r.CanDisable(ctx))
+}
+
+func TestResolvedStub_Disable_errors(t *testing.T) {
+	testCases := []struct {
+		name            string
+		failCmd         string
+		wantUnsupported bool
+	}{{
+		name:            "not_enabled",
+		failCmd:         "systemctl is-enabled",
+		wantUnsupported: true,
+	}, {
+		name:            "no_stub_listener",
+		failCmd:         "grep",
+		wantUnsupported: true,
+	}, {
+		name:            "restart",
+		failCmd:         "systemctl reload-or-restart",
+		wantUnsupported: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var cmds []string
+			r, root, statePath := newTestResolvedStub(t, newFakeRunner(&cmds, tc.failCmd))
+
+			resolvConf := filepath.Join(root, "etc", "resolv.conf")
+			err := os.WriteFile(resolvConf, []byte(testResolvConf), 0o644)
+			require.NoError(t, err)
+
+			err = r.Disable(context.Background())
+			require.Error(t, err)
+
+			assert.Equal(t, tc.wantUnsupported, errors.Is(err, errors.ErrUnsupported))
+
+			// The changes must be reverted.
+			assert.NoFileExists(t, statePath)
+
+			dropIn := filepath.Join(root, "etc", "systemd", "resolved.conf.d", "adguardhome.conf")
+			_, err = os.Stat(dropIn)
+			assert.ErrorIs(t, err, fs.ErrNotExist)
+
+			data, err := os.ReadFile(resolvConf)
+			require.NoError(t, err)
+
+			assert.Equal(t, testResolvConf, string(data))
+		})
+	}
+}
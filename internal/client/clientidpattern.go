@@ -0,0 +1,107 @@
+package client
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/errors"
+)
+
+const (
+	// clientIDWildcard is the suffix of the ClientID patterns matching all
+	// ClientIDs with the given prefix, for example "device-*".
+	clientIDWildcard = "*"
+
+	// clientIDRegexpDelim is the delimiter of the ClientID patterns containing
+	// RE2 regular expressions, for example "/^device-[0-9]+$/".
+	clientIDRegexpDelim = "/"
+)
+
+// ClientIDPattern is a pattern matching a family of ClientIDs.  It's either a
+// prefix wildcard, like "device-*", or an RE2 regular expression enclosed in
+// slashes, like "/^device-[0-9]+$/".
+type ClientIDPattern struct {
+	// re is the regular expression of the pattern.  It's nil for the prefix
+	// wildcards.
+	re *regexp.Regexp
+
+	// prefix is the lowercased prefix of the ClientIDs matching the wildcard.
+	prefix string
+
+	// text is the original text of the pattern.
+	text string
+}
+
+// isClientIDPattern returns true if id looks like a ClientID pattern.
+func isClientIDPattern(id string) (ok bool) {
+	return strings.HasSuffix(id, clientIDWildcard) || isClientIDRegexp(id)
+}
+
+// isClientIDRegexp returns true if id looks like a ClientID pattern containing a
+// regular expression.
+func isClientIDRegexp(id string) (ok bool) {
+	return len(id) > 2 &&
+		strings.HasPrefix(id, clientIDRegexpDelim) &&
+		strings.HasSuffix(id, clientIDRegexpDelim)
+}
+
+// ParseClientIDPattern parses a ClientID pattern from s.
+func ParseClientIDPattern(s string) (p *ClientIDPattern, err error) {
+	if isClientIDRegexp(s) {
+		var re *regexp.Regexp
+		re, err = regexp.Compile(s[1 : len(s)-1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid clientid pattern %q: %w", s, err)
+		}
+
+		return &ClientIDPattern{
+			re:   re,
+			text: s,
+		}, nil
+	}
+
+	prefix, ok := strings.CutSuffix(s, clientIDWildcard)
+	if !ok {
+		return nil, fmt.Errorf("invalid clientid pattern %q: not a wildcard or regexp", s)
+	} else if prefix == "" {
+		return nil, fmt.Errorf("invalid clientid pattern %q: %w", s, errors.ErrEmptyValue)
+	}
+
+	// A prefix of a valid ClientID is itself valid, except for the trailing
+	// hyphen, which is allowed here.
+	err = ValidateClientID(strings.TrimRight(prefix, "-"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid clientid pattern %q: %w", s, err)
+	}
+
+	return &ClientIDPattern{
+		prefix: strings.ToLower(prefix),
+		text:   s,
+	}, nil
+}
+
+// Match returns true if id matches the pattern.  id must be lowercased.
+func (p *ClientIDPattern) Match(id string) (ok bool) {
+	if p.re != nil {
+		return p.re.MatchString(id)
+	}
+
+	return strings.HasPrefix(id, p.prefix)
+}
+
+// String implements the [fmt.Stringer] interface for *ClientIDPattern.
+func (p *ClientIDPattern) String() (s string) {
+	return p.text
+}
+
+// compareClientIDPatterns is a comparison function for the two ClientID
+// patterns.  It compares their texts.
+func compareClientIDPatterns(a, b *ClientIDPattern) (res int) {
+	return strings.Compare(a.text, b.text)
+}
+
+// equalClientIDPatterns returns true if a and b have the same text.
+func equalClientIDPatterns(a, b *ClientIDPattern) (ok bool) {
+	return a.text == b.text
+}
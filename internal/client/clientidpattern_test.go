@@ -0,0 +1,76 @@
+package client_test
+
+import (
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/client"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseClientIDPattern(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name       string
+		in         string
+		wantErrMsg string
+		match      []string
+		noMatch    []string
+	}{{
+		name:       "prefix",
+		in:         "Device-*",
+		wantErrMsg: "",
+		match:      []string{"device-1", "device-abc"},
+		noMatch:    []string{"device", "other-1"},
+	}, {
+		name:       "regexp",
+		in:         "/^device-[0-9]+$/",
+		wantErrMsg: "",
+		match:      []string{"device-1", "device-123"},
+		noMatch:    []string{"device-abc", "other-1"},
+	}, {
+		name:       "empty_prefix",
+		in:         "*",
+		wantErrMsg: `invalid clientid pattern "*": empty value`,
+	}, {
+		name: "bad_prefix",
+		in:   "dev_ice*",
+		wantErrMsg: `invalid clientid pattern "dev_ice*": invalid clientid "dev_ice": ` +
+			`bad hostname label rune '_'`,
+	}, {
+		name: "bad_regexp",
+		in:   "/device-[0-9/",
+		wantErrMsg: `invalid clientid pattern "/device-[0-9/": error parsing regexp: ` +
+			"missing closing ]: `[0-9`",
+	}, {
+		name:       "not_pattern",
+		in:         "device",
+		wantErrMsg: `invalid clientid pattern "device": not a wildcard or regexp`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			p, err := client.ParseClientIDPattern(tc.in)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+			if tc.wantErrMsg != "" {
+				return
+			}
+
+			require.NotNil(t, p)
+
+			assert.Equal(t, tc.in, p.String())
+
+			for _, id := range tc.match {
+				assert.Truef(t, p.Match(id), "id %q", id)
+			}
+
+			for _, id := range tc.noMatch {
+				assert.Falsef(t, p.Match(id), "id %q", id)
+			}
+		})
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"strings"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghalg"
+	"github.com/AdguardTeam/dnsproxy/proxy"
 	"github.com/AdguardTeam/golibs/errors"
 )
 
@@ -30,6 +31,16 @@ func macToKey(mac net.HardwareAddr) (key macKey) {
 	}
 }
 
+// clientIDPatternUID is a ClientID pattern of the persistent client with the
+// UID.
+type clientIDPatternUID struct {
+	// pattern is the ClientID pattern.
+	pattern *ClientIDPattern
+
+	// uid is the UID of the persistent client.
+	uid UID
+}
+
 // index stores all information about persistent clients.
 type index struct {
 	// nameToUID maps client name to UID.
@@ -38,6 +49,10 @@ type index struct {
 	// clientIDToUID maps client ID to UID.
 	clientIDToUID map[string]UID
 
+	// clientIDPatterns are the ClientID patterns of all clients in the order
+	// of addition.  They are only checked if there are no exact matches.
+	clientIDPatterns []clientIDPatternUID
+
 	// ipToUID maps IP address to UID.
 	ipToUID map[netip.Addr]UID
 
@@ -76,6 +91,13 @@ func (ci *index) add(c *Persistent) {
 		ci.clientIDToUID[id] = c.UID
 	}
 
+	for _, p := range c.ClientIDPatterns {
+		ci.clientIDPatterns = append(ci.clientIDPatterns, clientIDPatternUID{
+			pattern: p,
+			uid:     c.UID,
+		})
+	}
+
 	for _, ip := range c.IPs {
 		ci.ipToUID[ip] = c.UID
 	}
@@ -119,6 +141,11 @@ func (ci *index) clashes(c *Persistent) (err error) {
 		}
 	}
 
+	p, pat := ci.clashesClientIDPattern(c)
+	if p != nil {
+		return fmt.Errorf("another client %q uses the same ClientID pattern %q", p.Name, pat)
+	}
+
 	p, ip := ci.clashesIP(c)
 	if p != nil {
 		return fmt.Errorf("another client %q uses the same IP %q", p.Name, ip)
@@ -152,6 +179,20 @@ func (ci *index) clashesName(c *Persistent) (existing *Persistent) {
 	return nil
 }
 
+// clashesClientIDPattern returns a previous client with the same ClientID
+// pattern as c.  c must be non-nil.
+func (ci *index) clashesClientIDPattern(c *Persistent) (p *Persistent, pat *ClientIDPattern) {
+	for _, pat = range c.ClientIDPatterns {
+		for _, existing := range ci.clientIDPatterns {
+			if existing.uid != c.UID && equalClientIDPatterns(existing.pattern, pat) {
+				return ci.uidToClient[existing.uid], pat
+			}
+		}
+	}
+
+	return nil, nil
+}
+
 // clashesIP returns a previous client with the same IP address as c.  c must be
 // non-nil.
 func (ci *index) clashesIP(c *Persistent) (p *Persistent, ip netip.Addr) {
@@ -206,7 +247,8 @@ func (ci *index) clashesMAC(c *Persistent) (p *Persistent, mac net.HardwareAddr)
 }
 
 // find finds persistent client by string representation of the client ID, IP
-// address, or MAC.
+// address, or MAC.  The ClientID patterns are only checked if there are no
+// other matches.
 func (ci *index) find(id string) (c *Persistent, ok bool) {
 	uid, found := ci.clientIDToUID[id]
 	if found {
@@ -224,7 +266,27 @@ func (ci *index) find(id string) (c *Persistent, ok bool) {
 
 	mac, err := net.ParseMAC(id)
 	if err == nil {
-		return ci.findByMAC(mac)
+		c, found = ci.findByMAC(mac)
+		if found {
+			return c, true
+		}
+	}
+
+	return ci.findByClientIDPattern(id)
+}
+
+// findByClientIDPattern finds persistent client with the first ClientID pattern
+// matching id.
+func (ci *index) findByClientIDPattern(id string) (c *Persistent, found bool) {
+	if len(ci.clientIDPatterns) == 0 {
+		return nil, false
+	}
+
+	id = strings.ToLower(id)
+	for _, p := range ci.clientIDPatterns {
+		if p.pattern.Match(id) {
+			return ci.uidToClient[p.uid], true
+		}
 	}
 
 	return nil, false
@@ -306,6 +368,11 @@ func (ci *index) remove(c *Persistent) {
 		delete(ci.clientIDToUID, id)
 	}
 
+	ci.clientIDPatterns = slices.DeleteFunc(
+		ci.clientIDPatterns,
+		func(p clientIDPatternUID) (ok bool) { return p.uid == c.UID },
+	)
+
 	for _, ip := range c.IPs {
 		delete(ci.ipToUID, ip)
 	}
@@ -345,14 +412,20 @@ func (ci *index) rangeByName(f func(c *Persistent) (cont bool)) {
 }
 
 // closeUpstreams closes upstream configurations of persistent clients.
-func (ci *index) closeUpstreams() (err error) {
+// onClosed, if not nil, is called with each closed configuration.
+func (ci *index) closeUpstreams(onClosed func(conf *proxy.CustomUpstreamConfig)) (err error) {
 	var errs []error
 	ci.rangeByName(func(c *Persistent) (cont bool) {
+		conf := c.UpstreamConfig
 		err = c.CloseUpstreams()
 		if err != nil {
 			errs = append(errs, err)
 		}
 
+		if conf != nil && onClosed != nil {
+			onClosed(conf)
+		}
+
 		return true
 	})
 
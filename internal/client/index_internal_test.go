@@ -124,6 +124,91 @@ func TestClientIndex_Find(t *testing.T) {
 	})
 }
 
+func TestClientIndex_Find_clientIDPattern(t *testing.T) {
+	const (
+		exactID     = "device-exact"
+		prefixID    = "device-1"
+		regexpID    = "sensor-42"
+		unmatchedID = "other-1"
+	)
+
+	clientWithExact := &Persistent{
+		Name: "client_with_exact",
+	}
+	require.NoError(t, clientWithExact.SetIDs([]string{exactID}))
+
+	clientWithPrefix := &Persistent{
+		Name: "client_with_prefix",
+	}
+	require.NoError(t, clientWithPrefix.SetIDs([]string{"device-*"}))
+
+	clientWithRegexp := &Persistent{
+		Name: "client_with_regexp",
+	}
+	require.NoError(t, clientWithRegexp.SetIDs([]string{"/^sensor-[0-9]+$/"}))
+
+	// Add the pattern first to make sure that the exact ClientID takes
+	// precedence regardless of the order.
+	ci := newIDIndex([]*Persistent{clientWithPrefix, clientWithRegexp, clientWithExact})
+
+	testCases := []struct {
+		want *Persistent
+		name string
+		id   string
+	}{{
+		want: clientWithExact,
+		name: "exact_precedence",
+		id:   exactID,
+	}, {
+		want: clientWithPrefix,
+		name: "prefix",
+		id:   prefixID,
+	}, {
+		want: clientWithPrefix,
+		name: "prefix_case",
+		id:   "DEVICE-2",
+	}, {
+		want: clientWithRegexp,
+		name: "regexp",
+		id:   regexpID,
+	}, {
+		want: nil,
+		name: "not_found",
+		id:   unmatchedID,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, ok := ci.find(tc.id)
+			assert.Equal(t, tc.want != nil, ok)
+			assert.Equal(t, tc.want, c)
+		})
+	}
+
+	t.Run("clashes", func(t *testing.T) {
+		dup := &Persistent{
+			Name: "dup",
+			UID:  MustNewUID(),
+		}
+		require.NoError(t, dup.SetIDs([]string{"device-*"}))
+
+		err := ci.clashes(dup)
+		assert.Error(t, err)
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		ci.remove(clientWithPrefix)
+
+		_, ok := ci.find(prefixID)
+		assert.False(t, ok)
+
+		c, ok := ci.find(regexpID)
+		require.True(t, ok)
+
+		assert.Equal(t, clientWithRegexp, c)
+	})
+}
+
 func TestClientIndex_Clashes(t *testing.T) {
 	const (
 		cliIP1      = "1.1.1.1"
@@ -9,6 +9,7 @@ import (
 	"net/netip"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
 	"github.com/AdguardTeam/dnsproxy/proxy"
@@ -16,6 +17,7 @@ import (
 	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/logutil/slogutil"
 	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/AdguardTeam/golibs/timeutil"
 	"github.com/google/uuid"
 )
 
@@ -71,6 +73,15 @@ type Persistent struct {
 	// must not be nil after initialization.
 	BlockedServices *filtering.BlockedServices
 
+	// ClientRules are the compiled custom filtering rules of the client.  It is
+	// nil if there are no rules.  See [Persistent.SetRules].
+	ClientRules *filtering.ClientRules
+
+	// BlockedSuffixSet is the compiled set of the blocked suffixes of the
+	// client.  It is nil if there are none.  See
+	// [Persistent.SetBlockedSuffixes].
+	BlockedSuffixSet *filtering.SuffixSet
+
 	// Name of the persistent client.  Must not be empty.
 	Name string
 
@@ -80,6 +91,14 @@ type Persistent struct {
 	// Upstreams is a list of custom upstream DNS servers for the client.
 	Upstreams []string
 
+	// Rules is a list of custom filtering rules, which are only applied to the
+	// requests of this client.
+	Rules []string
+
+	// BlockedSuffixes is a list of the blocked domain name suffixes of the
+	// client, which is only used if UseOwnBlockedSuffixes is true.
+	BlockedSuffixes []string
+
 	// IPs is a list of IP addresses that identify the client.  The client must
 	// have at least one ID (IP, subnet, MAC, or ClientID).
 	IPs []netip.Addr
@@ -98,9 +117,21 @@ type Persistent struct {
 	// (IP, subnet, MAC, or ClientID).
 	ClientIDs []string
 
+	// ClientIDPatterns are the patterns matching the ClientIDs identifying the
+	// client.  The exact ClientIDs of all clients take precedence over them.
+	ClientIDPatterns []*ClientIDPattern
+
 	// UID is the unique identifier of the persistent client.
 	UID UID
 
+	// QueryLogRetention is the retention period of the query log entries of
+	// the client.  If zero, the global query log rotation interval is used.
+	QueryLogRetention time.Duration
+
+	// StatsRetention is the retention period of the statistics of the client.
+	// If zero, the global statistics interval is used.
+	StatsRetention time.Duration
+
 	// UpstreamsCacheSize is the cache size for custom upstreams.
 	UpstreamsCacheSize uint32
 
@@ -122,6 +153,10 @@ type Persistent struct {
 	// UseOwnBlockedServices specifies whether custom services are blocked.
 	UseOwnBlockedServices bool
 
+	// UseOwnBlockedSuffixes specifies whether the client's own blocked
+	// suffixes are used instead of the global ones.
+	UseOwnBlockedSuffixes bool
+
 	// IgnoreQueryLog specifies whether the client requests are logged.
 	IgnoreQueryLog bool
 
@@ -146,6 +181,16 @@ func (c *Persistent) validate(ctx context.Context, l *slog.Logger, allTags []str
 		return errors.Error("uid required")
 	}
 
+	err = validateRetention(c.QueryLogRetention)
+	if err != nil {
+		return fmt.Errorf("query log retention: %w", err)
+	}
+
+	err = validateRetention(c.StatsRetention)
+	if err != nil {
+		return fmt.Errorf("statistics retention: %w", err)
+	}
+
 	conf, err := proxy.ParseUpstreamsConfig(c.Upstreams, &upstream.Options{})
 	if err != nil {
 		return fmt.Errorf("invalid upstream servers: %w", err)
@@ -169,6 +214,23 @@ func (c *Persistent) validate(ctx context.Context, l *slog.Logger, allTags []str
 	return nil
 }
 
+// validateRetention returns an error if ivl isn't a valid retention period of
+// a client.  Zero means that the global retention period is used.
+func validateRetention(ivl time.Duration) (err error) {
+	const maxRetention = timeutil.Day * 365
+
+	switch {
+	case ivl == 0:
+		return nil
+	case ivl < time.Hour:
+		return fmt.Errorf("less than an hour: %s", ivl)
+	case ivl > maxRetention:
+		return fmt.Errorf("more than a year: %s", ivl)
+	default:
+		return nil
+	}
+}
+
 // SetIDs parses a list of strings into typed fields and returns an error if
 // there is one.
 func (c *Persistent) SetIDs(ids []string) (err error) {
@@ -185,6 +247,35 @@ func (c *Persistent) SetIDs(ids []string) (err error) {
 	slices.SortFunc(c.Subnets, subnetCompare)
 	slices.SortFunc(c.MACs, slices.Compare[net.HardwareAddr])
 	slices.Sort(c.ClientIDs)
+	slices.SortFunc(c.ClientIDPatterns, compareClientIDPatterns)
+
+	return nil
+}
+
+// SetRules compiles the custom filtering rules of the client and returns an
+// error if there is one.
+func (c *Persistent) SetRules(ruleTexts []string) (err error) {
+	cr, err := filtering.NewClientRules(ruleTexts)
+	if err != nil {
+		return fmt.Errorf("invalid rules: %w", err)
+	}
+
+	c.Rules = ruleTexts
+	c.ClientRules = cr
+
+	return nil
+}
+
+// SetBlockedSuffixes compiles the blocked suffixes of the client and returns an
+// error if there is one.
+func (c *Persistent) SetBlockedSuffixes(suffixes []string) (err error) {
+	s, err := filtering.NewSuffixSet(suffixes)
+	if err != nil {
+		return fmt.Errorf("invalid blocked suffixes: %w", err)
+	}
+
+	c.BlockedSuffixes = suffixes
+	c.BlockedSuffixSet = s
 
 	return nil
 }
@@ -237,6 +328,19 @@ func (c *Persistent) setID(id string) (err error) {
 		return nil
 	}
 
+	if isClientIDPattern(id) {
+		var p *ClientIDPattern
+		p, err = ParseClientIDPattern(id)
+		if err != nil {
+			// Don't wrap the error, because it's informative enough as is.
+			return err
+		}
+
+		c.ClientIDPatterns = append(c.ClientIDPatterns, p)
+
+		return nil
+	}
+
 	err = ValidateClientID(id)
 	if err != nil {
 		// Don't wrap the error, because it's informative enough as is.
@@ -278,12 +382,22 @@ func (c *Persistent) IDs() (ids []string) {
 		ids = append(ids, mac.String())
 	}
 
-	return append(ids, c.ClientIDs...)
+	ids = append(ids, c.ClientIDs...)
+
+	for _, p := range c.ClientIDPatterns {
+		ids = append(ids, p.String())
+	}
+
+	return ids
 }
 
 // IDsLen returns a length of client ids.
 func (c *Persistent) IDsLen() (n int) {
-	return len(c.IPs) + len(c.Subnets) + len(c.MACs) + len(c.ClientIDs)
+	return len(c.IPs) +
+		len(c.Subnets) +
+		len(c.MACs) +
+		len(c.ClientIDs) +
+		len(c.ClientIDPatterns)
 }
 
 // EqualIDs returns true if the ids of the current and previous clients are the
@@ -292,7 +406,8 @@ func (c *Persistent) EqualIDs(prev *Persistent) (equal bool) {
 	return slices.Equal(c.IPs, prev.IPs) &&
 		slices.Equal(c.Subnets, prev.Subnets) &&
 		slices.EqualFunc(c.MACs, prev.MACs, slices.Equal[net.HardwareAddr]) &&
-		slices.Equal(c.ClientIDs, prev.ClientIDs)
+		slices.Equal(c.ClientIDs, prev.ClientIDs) &&
+		slices.EqualFunc(c.ClientIDPatterns, prev.ClientIDPatterns, equalClientIDPatterns)
 }
 
 // ShallowClone returns a deep copy of the client, except upstreamConfig,
@@ -304,11 +419,14 @@ func (c *Persistent) ShallowClone() (clone *Persistent) {
 	clone.BlockedServices = c.BlockedServices.Clone()
 	clone.Tags = slices.Clone(c.Tags)
 	clone.Upstreams = slices.Clone(c.Upstreams)
+	clone.Rules = slices.Clone(c.Rules)
+	clone.BlockedSuffixes = slices.Clone(c.BlockedSuffixes)
 
 	clone.IPs = slices.Clone(c.IPs)
 	clone.Subnets = slices.Clone(c.Subnets)
 	clone.MACs = slices.Clone(c.MACs)
 	clone.ClientIDs = slices.Clone(c.ClientIDs)
+	clone.ClientIDPatterns = slices.Clone(c.ClientIDPatterns)
 
 	return clone
 }
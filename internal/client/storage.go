@@ -13,6 +13,7 @@ import (
 	"github.com/AdguardTeam/AdGuardHome/internal/arpdb"
 	"github.com/AdguardTeam/AdGuardHome/internal/dhcpsvc"
 	"github.com/AdguardTeam/AdGuardHome/internal/whois"
+	"github.com/AdguardTeam/dnsproxy/proxy"
 	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/hostsfile"
 	"github.com/AdguardTeam/golibs/logutil/slogutil"
@@ -82,6 +83,18 @@ type HostsContainer interface {
 	Upd() (updates <-chan *hostsfile.DefaultStorage)
 }
 
+// GeoIP is an interface for looking up the countries of the clients' IP
+// addresses.
+type GeoIP interface {
+	// Country returns the two-letter ISO 3166-1 code of the country of ip.
+	// code is empty if the country is unknown.
+	Country(ip netip.Addr) (code string)
+}
+
+// CountryTagPrefix is the prefix of the tag containing the country of a
+// runtime client.
+const CountryTagPrefix = "country:"
+
 // StorageConfig is the client storage configuration structure.
 type StorageConfig struct {
 	// Logger is used for logging the operation of the client storage.  It must
@@ -98,6 +111,10 @@ type StorageConfig struct {
 	// ARPDB is used to update [SourceARP] runtime client information.
 	ARPDB arpdb.Interface
 
+	// GeoIP is used to tag runtime clients with their countries.  If it's nil,
+	// runtime clients aren't tagged.
+	GeoIP GeoIP
+
 	// InitialClients is a list of persistent clients parsed from the
 	// configuration file.  Each client must not be nil.
 	InitialClients []*Persistent
@@ -106,6 +123,11 @@ type StorageConfig struct {
 	// information is updated.
 	ARPClientsUpdatePeriod time.Duration
 
+	// OnUpstreamsClosed, if not nil, is called with the custom upstream
+	// configuration of a persistent client after it has been closed, so that
+	// the data associated with it can be dropped.
+	OnUpstreamsClosed func(conf *proxy.CustomUpstreamConfig)
+
 	// RuntimeSourceDHCP specifies whether to update [SourceDHCP] information
 	// of runtime clients.
 	RuntimeSourceDHCP bool
@@ -135,7 +157,15 @@ type Storage struct {
 	// arpDB is used to update [SourceARP] runtime client information.
 	arpDB arpdb.Interface
 
-	// done is the shutdown signaling channel.
+	// geoIP is used to tag runtime clients with their countries.  It may be
+	// nil.
+	geoIP GeoIP
+
+	// onUpstreamsClosed is called with the closed custom upstream
+	// configurations of persistent clients.  It may be nil.
+	onUpstreamsClosed func(conf *proxy.CustomUpstreamConfig)
+
+	// done is the shutdown signaling channel.  It's protected by mu.
 	done chan struct{}
 
 	// allowedTags is a sorted list of all allowed tags.  It must not be
@@ -166,6 +196,8 @@ func NewStorage(ctx context.Context, conf *StorageConfig) (s *Storage, err error
 		dhcp:                   conf.DHCP,
 		etcHosts:               conf.EtcHosts,
 		arpDB:                  conf.ARPDB,
+		geoIP:                  conf.GeoIP,
+		onUpstreamsClosed:      conf.OnUpstreamsClosed,
 		done:                   make(chan struct{}),
 		allowedTags:            tags,
 		arpClientsUpdatePeriod: conf.ARPClientsUpdatePeriod,
@@ -185,11 +217,22 @@ func NewStorage(ctx context.Context, conf *StorageConfig) (s *Storage, err error
 }
 
 // Start starts the goroutines for updating the runtime client information.
+// The storage may be started again after it has been shut down.
 //
 // TODO(s.chzhen):  Pass context.
 func (s *Storage) Start(ctx context.Context) (err error) {
-	go s.periodicARPUpdate(ctx)
-	go s.handleHostsUpdates(ctx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.done:
+		s.done = make(chan struct{})
+	default:
+		// Go on.
+	}
+
+	go s.periodicARPUpdate(ctx, s.done)
+	go s.handleHostsUpdates(ctx, s.done)
 
 	return nil
 }
@@ -198,14 +241,16 @@ func (s *Storage) Start(ctx context.Context) (err error) {
 //
 // TODO(s.chzhen):  Pass context.
 func (s *Storage) Shutdown(_ context.Context) (err error) {
+	s.mu.Lock()
 	close(s.done)
+	s.mu.Unlock()
 
 	return s.closeUpstreams()
 }
 
 // periodicARPUpdate periodically reloads runtime clients from ARP.  It is
-// intended to be used as a goroutine.
-func (s *Storage) periodicARPUpdate(ctx context.Context) {
+// intended to be used as a goroutine.  done is the shutdown signaling channel.
+func (s *Storage) periodicARPUpdate(ctx context.Context, done <-chan struct{}) {
 	defer slogutil.RecoverAndLog(ctx, s.logger)
 
 	t := time.NewTicker(s.arpClientsUpdatePeriod)
@@ -214,7 +259,7 @@ func (s *Storage) periodicARPUpdate(ctx context.Context) {
 		select {
 		case <-t.C:
 			s.ReloadARP(ctx)
-		case <-s.done:
+		case <-done:
 			return
 		}
 	}
@@ -265,8 +310,9 @@ func (s *Storage) addFromSystemARP(ctx context.Context) {
 }
 
 // handleHostsUpdates receives the updates from the hosts container and adds
-// them to the clients storage.  It is intended to be used as a goroutine.
-func (s *Storage) handleHostsUpdates(ctx context.Context) {
+// them to the clients storage.  It is intended to be used as a goroutine.  done
+// is the shutdown signaling channel.
+func (s *Storage) handleHostsUpdates(ctx context.Context, done <-chan struct{}) {
 	if s.etcHosts == nil {
 		return
 	}
@@ -281,7 +327,7 @@ func (s *Storage) handleHostsUpdates(ctx context.Context) {
 			}
 
 			s.addFromHostsFile(ctx, upd)
-		case <-s.done:
+		case <-done:
 			return
 		}
 	}
@@ -428,6 +474,71 @@ func (s *Storage) Add(ctx context.Context, p *Persistent) (err error) {
 	return nil
 }
 
+// Reload replaces all the stored persistent clients with clients, preserving
+// the runtime ones.  If any of clients is invalid or clashes with another one,
+// Reload returns the errors for each of them and keeps the stored persistent
+// clients intact.  Each client must not be nil.
+func (s *Storage) Reload(ctx context.Context, clients []*Persistent) (err error) {
+	defer func() { err = errors.Annotate(err, "reloading clients: %w") }()
+
+	idx := newIndex()
+
+	var errs []error
+	for i, p := range clients {
+		err = s.addToIndex(ctx, idx, p)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("client %q at index %d: %w", p.Name, i, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		// Don't wrap the error since there is already an annotation deferred.
+		return errors.Join(errs...)
+	}
+
+	s.mu.Lock()
+	prev := s.index
+	s.index = idx
+	s.mu.Unlock()
+
+	// Close the previous upstreams only after the new clients are in place and
+	// without holding the lock, since closing may take a while.
+	err = prev.closeUpstreams(s.onUpstreamsClosed)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "closing previous upstreams", slogutil.KeyError, err)
+	}
+
+	s.logger.DebugContext(ctx, "clients reloaded", "clients_count", idx.size())
+
+	return nil
+}
+
+// addToIndex validates p and adds it to idx if it doesn't clash with the
+// clients already stored there.  idx must not be shared with other goroutines.
+func (s *Storage) addToIndex(ctx context.Context, idx *index, p *Persistent) (err error) {
+	err = p.validate(ctx, s.logger, s.allowedTags)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	err = idx.clashesUID(p)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	err = idx.clashes(p)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	idx.add(p)
+
+	return nil
+}
+
 // FindByName finds persistent client by name.  And returns its shallow copy.
 func (s *Storage) FindByName(name string) (p *Persistent, ok bool) {
 	s.mu.Lock()
@@ -450,11 +561,22 @@ func (s *Storage) Find(id string) (p *Persistent, ok bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	p, ok = s.index.find(id)
+	p, ok = s.find(id)
 	if ok {
 		return p.ShallowClone(), ok
 	}
 
+	return nil, false
+}
+
+// find returns the stored persistent client by id or, if id is an IP address,
+// by the MAC address of its DHCP lease.  s.mu is expected to be locked.
+func (s *Storage) find(id string) (p *Persistent, ok bool) {
+	p, ok = s.index.find(id)
+	if ok {
+		return p, ok
+	}
+
 	ip, err := netip.ParseAddr(id)
 	if err != nil {
 		return nil, false
@@ -462,12 +584,58 @@ func (s *Storage) Find(id string) (p *Persistent, ok bool) {
 
 	foundMAC := s.dhcp.MACByIP(ip)
 	if foundMAC != nil {
-		return s.FindByMAC(foundMAC)
+		return s.index.findByMAC(foundMAC)
 	}
 
 	return nil, false
 }
 
+// UpstreamConfig returns the custom upstream configuration of the persistent
+// client found by id.  If it isn't initialized yet, it's created with newConf
+// and stored under the same lock as the lookup, so that the concurrent updates
+// of the client aren't lost.  conf is nil if there is no such client or newConf
+// returns nil.  newConf must not call the methods of s.
+func (s *Storage) UpstreamConfig(
+	id string,
+	newConf func(c *Persistent) (conf *proxy.CustomUpstreamConfig, err error),
+) (conf *proxy.CustomUpstreamConfig, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.find(id)
+	if !ok {
+		return nil, nil
+	} else if p.UpstreamConfig != nil {
+		return p.UpstreamConfig, nil
+	}
+
+	conf, err = newConf(p.ShallowClone())
+	if err != nil || conf == nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+
+	p.UpstreamConfig = conf
+
+	return conf, nil
+}
+
+// RuntimeTags returns the tags of the runtime client with ip.  Currently, the
+// only such tag is the country of the client in the form "country:XX", if it's
+// known.
+func (s *Storage) RuntimeTags(ip netip.Addr) (tags []string) {
+	if s.geoIP == nil {
+		return nil
+	}
+
+	code := s.geoIP.Country(ip)
+	if code == "" {
+		return nil
+	}
+
+	return []string{CountryTagPrefix + code}
+}
+
 // FindLoose is like [Storage.Find] but it also tries to find a persistent
 // client by IP address without zone.  It strips the IPv6 zone index from the
 // stored IP addresses before comparing, because querylog entries don't have it.
@@ -514,7 +682,7 @@ func (s *Storage) RemoveByName(ctx context.Context, name string) (ok bool) {
 		return false
 	}
 
-	if err := p.CloseUpstreams(); err != nil {
+	if err := s.closeClientUpstreams(p); err != nil {
 		s.logger.ErrorContext(ctx, "removing client", "name", p.Name, slogutil.KeyError, err)
 	}
 
@@ -534,12 +702,33 @@ func (s *Storage) Update(ctx context.Context, name string, p *Persistent) (err e
 		return err
 	}
 
+	stored, err := s.replace(name, p)
+	if err != nil {
+		// Don't wrap the error since there is already an annotation deferred.
+		return err
+	}
+
+	// Close the upstreams of the replaced client only after p is in place and
+	// without holding the lock, since closing may take a while.
+	if stored.UpstreamConfig != p.UpstreamConfig {
+		err = s.closeClientUpstreams(stored)
+		if err != nil {
+			s.logger.ErrorContext(ctx, "updating client", "name", name, slogutil.KeyError, err)
+		}
+	}
+
+	return nil
+}
+
+// replace replaces the stored persistent client with name by p and returns the
+// replaced one.
+func (s *Storage) replace(name string, p *Persistent) (stored *Persistent, err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	stored, ok := s.index.findByName(name)
 	if !ok {
-		return fmt.Errorf("client %q is not found", name)
+		return nil, fmt.Errorf("client %q is not found", name)
 	}
 
 	// Client p has a newly generated UID, so replace it with the stored one.
@@ -549,14 +738,14 @@ func (s *Storage) Update(ctx context.Context, name string, p *Persistent) (err e
 
 	err = s.index.clashes(p)
 	if err != nil {
-		// Don't wrap the error since there is already an annotation deferred.
-		return err
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
 	}
 
 	s.index.remove(stored)
 	s.index.add(p)
 
-	return nil
+	return stored, nil
 }
 
 // RangeByName calls f for each persistent client sorted by name, unless cont is
@@ -581,7 +770,20 @@ func (s *Storage) closeUpstreams() (err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	return s.index.closeUpstreams()
+	return s.index.closeUpstreams(s.onUpstreamsClosed)
+}
+
+// closeClientUpstreams closes the custom upstream configuration of p, if any,
+// and reports it to s.onUpstreamsClosed.
+func (s *Storage) closeClientUpstreams(p *Persistent) (err error) {
+	conf := p.UpstreamConfig
+	err = p.CloseUpstreams()
+	if conf != nil && s.onUpstreamsClosed != nil {
+		s.onUpstreamsClosed(conf)
+	}
+
+	// Don't wrap the error since it's informative enough as is.
+	return err
 }
 
 // ClientRuntime returns a copy of the saved runtime client by ip.  If no such
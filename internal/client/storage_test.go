@@ -1,11 +1,13 @@
 package client_test
 
 import (
+	"fmt"
 	"net"
 	"net/netip"
 	"runtime"
 	"slices"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -14,6 +16,8 @@ import (
 	"github.com/AdguardTeam/AdGuardHome/internal/dhcpd"
 	"github.com/AdguardTeam/AdGuardHome/internal/dhcpsvc"
 	"github.com/AdguardTeam/AdGuardHome/internal/whois"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
 	"github.com/AdguardTeam/golibs/hostsfile"
 	"github.com/AdguardTeam/golibs/logutil/slogutil"
 	"github.com/AdguardTeam/golibs/testutil"
@@ -850,6 +854,102 @@ func TestStorage_Find(t *testing.T) {
 	})
 }
 
+func TestStorage_Find_clientIDPattern(t *testing.T) {
+	const (
+		exactID  = "device-exact"
+		prefixID = "device-1"
+	)
+
+	clientWithPattern := &client.Persistent{
+		Name: "client_with_pattern",
+	}
+	require.NoError(t, clientWithPattern.SetIDs([]string{"device-*"}))
+
+	clientWithExact := &client.Persistent{
+		Name:      "client_with_exact",
+		ClientIDs: []string{exactID},
+	}
+
+	s := newStorage(t, []*client.Persistent{clientWithPattern, clientWithExact})
+
+	c, ok := s.Find(exactID)
+	require.True(t, ok)
+
+	assert.Equal(t, clientWithExact, c)
+
+	c, ok = s.Find(prefixID)
+	require.True(t, ok)
+
+	assert.Equal(t, clientWithPattern, c)
+}
+
+// newBenchStorage returns a storage with n persistent clients with ClientIDs.
+// If withPatterns is true, the same number of clients with ClientID patterns is
+// added.
+func newBenchStorage(b *testing.B, n int, withPatterns bool) (s *client.Storage) {
+	b.Helper()
+
+	clients := make([]*client.Persistent, 0, 2*n)
+	for i := range n {
+		clients = append(clients, &client.Persistent{
+			Name:      fmt.Sprintf("client_%d", i),
+			ClientIDs: []string{fmt.Sprintf("client-%d", i)},
+		})
+
+		if !withPatterns {
+			continue
+		}
+
+		p := &client.Persistent{
+			Name: fmt.Sprintf("pattern_%d", i),
+		}
+		require.NoError(b, p.SetIDs([]string{fmt.Sprintf("device%d-*", i)}))
+
+		clients = append(clients, p)
+	}
+
+	return newStorage(b, clients)
+}
+
+func BenchmarkStorage_Find(b *testing.B) {
+	const clientsNum = 100
+
+	benchCases := []struct {
+		name         string
+		id           string
+		withPatterns bool
+	}{{
+		name:         "exact",
+		id:           "client-50",
+		withPatterns: false,
+	}, {
+		name:         "exact_with_patterns",
+		id:           "client-50",
+		withPatterns: true,
+	}, {
+		name:         "pattern",
+		id:           "device50-serial",
+		withPatterns: true,
+	}}
+
+	for _, bc := range benchCases {
+		s := newBenchStorage(b, clientsNum, bc.withPatterns)
+
+		b.Run(bc.name, func(b *testing.B) {
+			var ok bool
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for range b.N {
+				_, ok = s.Find(bc.id)
+			}
+
+			require.True(b, ok)
+		})
+	}
+}
+
 func TestStorage_FindLoose(t *testing.T) {
 	const (
 		nonExistingClientID = "client_id"
@@ -1121,6 +1221,176 @@ func TestStorage_Update(t *testing.T) {
 	}
 }
 
+// closeTrackingUpstream is an [upstream.Upstream] that only records whether it
+// has been closed.
+type closeTrackingUpstream struct {
+	upstream.Upstream
+
+	closed *atomic.Bool
+}
+
+// Close implements the [upstream.Upstream] interface for
+// *closeTrackingUpstream.
+func (u *closeTrackingUpstream) Close() (err error) {
+	u.closed.Store(true)
+
+	return nil
+}
+
+// newTestUpstreamConfig returns a custom upstream configuration, which stores
+// true into closed when it's closed.
+func newTestUpstreamConfig(closed *atomic.Bool) (conf *proxy.CustomUpstreamConfig) {
+	return proxy.NewCustomUpstreamConfig(&proxy.UpstreamConfig{
+		Upstreams: []upstream.Upstream{&closeTrackingUpstream{closed: closed}},
+	}, false, 0, false)
+}
+
+func TestStorage_UpstreamConfig(t *testing.T) {
+	const (
+		cliName = "client"
+		cliIP   = "1.1.1.1"
+	)
+
+	ctx := testutil.ContextWithTimeout(t, testTimeout)
+	s := newStorage(t, []*client.Persistent{{
+		Name:      cliName,
+		IPs:       []netip.Addr{netip.MustParseAddr(cliIP)},
+		Upstreams: []string{"1.2.3.4"},
+	}})
+
+	closed := &atomic.Bool{}
+	var calls int
+	newConf := func(c *client.Persistent) (conf *proxy.CustomUpstreamConfig, err error) {
+		calls++
+
+		return newTestUpstreamConfig(closed), nil
+	}
+
+	conf, err := s.UpstreamConfig(cliIP, newConf)
+	require.NoError(t, err)
+	require.NotNil(t, conf)
+
+	got, err := s.UpstreamConfig(cliIP, newConf)
+	require.NoError(t, err)
+
+	assert.Same(t, conf, got)
+	assert.Equal(t, 1, calls)
+
+	t.Run("not_found", func(t *testing.T) {
+		got, err = s.UpstreamConfig("2.2.2.2", newConf)
+		require.NoError(t, err)
+
+		assert.Nil(t, got)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("update", func(t *testing.T) {
+		err = s.Update(ctx, cliName, &client.Persistent{
+			Name:      cliName,
+			IPs:       []netip.Addr{netip.MustParseAddr(cliIP)},
+			Upstreams: []string{"5.6.7.8"},
+			UID:       client.MustNewUID(),
+		})
+		require.NoError(t, err)
+
+		// The configuration of the replaced client is closed and the new one
+		// is created from the updated client.
+		assert.True(t, closed.Load())
+
+		var upstreams []string
+		got, err = s.UpstreamConfig(cliIP, func(c *client.Persistent) (
+			conf *proxy.CustomUpstreamConfig,
+			err error,
+		) {
+			upstreams = c.Upstreams
+
+			return newTestUpstreamConfig(&atomic.Bool{}), nil
+		})
+		require.NoError(t, err)
+
+		assert.NotSame(t, conf, got)
+		assert.Equal(t, []string{"5.6.7.8"}, upstreams)
+	})
+
+	t.Run("reload", func(t *testing.T) {
+		reloadClosed := &atomic.Bool{}
+		_, err = s.UpstreamConfig(cliIP, func(_ *client.Persistent) (
+			conf *proxy.CustomUpstreamConfig,
+			err error,
+		) {
+			panic("must not be called")
+		})
+		require.NoError(t, err)
+
+		p, ok := s.FindByName(cliName)
+		require.True(t, ok)
+
+		p.UpstreamConfig = newTestUpstreamConfig(reloadClosed)
+		require.NoError(t, s.Update(ctx, cliName, p))
+
+		err = s.Reload(ctx, []*client.Persistent{{
+			Name: cliName,
+			IPs:  []netip.Addr{netip.MustParseAddr(cliIP)},
+			UID:  client.MustNewUID(),
+		}})
+		require.NoError(t, err)
+
+		assert.True(t, reloadClosed.Load())
+
+		got, err = s.UpstreamConfig(cliIP, func(_ *client.Persistent) (
+			conf *proxy.CustomUpstreamConfig,
+			err error,
+		) {
+			return nil, nil
+		})
+		require.NoError(t, err)
+
+		assert.Nil(t, got)
+	})
+}
+
+func TestStorage_OnUpstreamsClosed(t *testing.T) {
+	const (
+		cliName = "client"
+		cliIP   = "1.1.1.1"
+	)
+
+	var closedConfs []*proxy.CustomUpstreamConfig
+
+	ctx := testutil.ContextWithTimeout(t, testTimeout)
+	s, err := client.NewStorage(ctx, &client.StorageConfig{
+		Logger: slogutil.NewDiscardLogger(),
+		DHCP:   client.EmptyDHCP{},
+		InitialClients: []*client.Persistent{{
+			Name:      cliName,
+			IPs:       []netip.Addr{netip.MustParseAddr(cliIP)},
+			Upstreams: []string{"1.2.3.4"},
+			UID:       client.MustNewUID(),
+		}},
+		OnUpstreamsClosed: func(conf *proxy.CustomUpstreamConfig) {
+			closedConfs = append(closedConfs, conf)
+		},
+	})
+	require.NoError(t, err)
+
+	closed := &atomic.Bool{}
+	conf, err := s.UpstreamConfig(cliIP, func(_ *client.Persistent) (
+		conf *proxy.CustomUpstreamConfig,
+		err error,
+	) {
+		return newTestUpstreamConfig(closed), nil
+	})
+	require.NoError(t, err)
+	require.NotNil(t, conf)
+
+	require.True(t, s.RemoveByName(ctx, cliName))
+
+	assert.True(t, closed.Load())
+	require.Len(t, closedConfs, 1)
+
+	assert.Same(t, conf, closedConfs[0])
+}
+
 func TestStorage_RangeByName(t *testing.T) {
 	sortedClients := []*client.Persistent{{
 		Name:      "clientA",
@@ -1171,3 +1441,165 @@ func TestStorage_RangeByName(t *testing.T) {
 		})
 	}
 }
+
+func TestStorage_Reload(t *testing.T) {
+	const (
+		oldName     = "old_client"
+		newName     = "new_client"
+		runtimeHost = "runtime.host"
+	)
+
+	var (
+		oldIP     = netip.MustParseAddr("1.1.1.1")
+		newIP     = netip.MustParseAddr("2.2.2.2")
+		runtimeIP = netip.MustParseAddr("3.3.3.3")
+	)
+
+	testCases := []struct {
+		name       string
+		clients    []*client.Persistent
+		wantName   string
+		wantErrMsg string
+	}{{
+		name: "success",
+		clients: []*client.Persistent{{
+			Name: newName,
+			IPs:  []netip.Addr{newIP},
+			UID:  client.MustNewUID(),
+		}},
+		wantName:   newName,
+		wantErrMsg: "",
+	}, {
+		name: "duplicate_ip",
+		clients: []*client.Persistent{{
+			Name: newName,
+			IPs:  []netip.Addr{newIP},
+			UID:  client.MustNewUID(),
+		}, {
+			Name: "duplicate_ip",
+			IPs:  []netip.Addr{newIP},
+			UID:  client.MustNewUID(),
+		}},
+		wantName: oldName,
+		wantErrMsg: `reloading clients: client "duplicate_ip" at index 1: ` +
+			`another client "new_client" uses the same IP "2.2.2.2"`,
+	}, {
+		name: "invalid",
+		clients: []*client.Persistent{{
+			Name: newName,
+			IPs:  []netip.Addr{newIP},
+		}, {
+			Name: "no_id",
+			UID:  client.MustNewUID(),
+		}},
+		wantName: oldName,
+		wantErrMsg: `reloading clients: client "new_client" at index 0: uid required` +
+			"\n" + `client "no_id" at index 1: id required`,
+	}, {
+		name:       "empty",
+		clients:    nil,
+		wantName:   "",
+		wantErrMsg: "",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := testutil.ContextWithTimeout(t, testTimeout)
+			s := newStorage(t, []*client.Persistent{{
+				Name: oldName,
+				IPs:  []netip.Addr{oldIP},
+			}})
+
+			s.UpdateAddress(ctx, runtimeIP, runtimeHost, nil)
+
+			err := s.Reload(ctx, tc.clients)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+
+			var names []string
+			s.RangeByName(func(c *client.Persistent) (cont bool) {
+				names = append(names, c.Name)
+
+				return true
+			})
+
+			if tc.wantName == "" {
+				assert.Empty(t, names)
+			} else {
+				assert.Equal(t, []string{tc.wantName}, names)
+			}
+
+			rc := s.ClientRuntime(runtimeIP)
+			require.NotNil(t, rc)
+
+			assert.True(t, compareRuntimeInfo(rc, client.SourceRDNS, runtimeHost))
+		})
+	}
+}
+
+func TestStorage_Reload_concurrent(t *testing.T) {
+	const (
+		reloadsNum   = 100
+		lookupersNum = 4
+		runtimeHost  = "runtime.host"
+	)
+
+	var (
+		cliIP     = netip.MustParseAddr("1.1.1.1")
+		runtimeIP = netip.MustParseAddr("2.2.2.2")
+	)
+
+	ctx := testutil.ContextWithTimeout(t, testTimeout)
+	s := newTestStorage(t)
+	s.UpdateAddress(ctx, runtimeIP, runtimeHost, nil)
+
+	done := make(chan struct{})
+	wg := &sync.WaitGroup{}
+
+	lookupErrs := make(chan string, lookupersNum)
+	for range lookupersNum {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				rc := s.ClientRuntime(runtimeIP)
+				if rc == nil || !compareRuntimeInfo(rc, client.SourceRDNS, runtimeHost) {
+					lookupErrs <- "runtime client info lost"
+
+					return
+				}
+
+				_, _ = s.Find(cliIP.String())
+				runtime.Gosched()
+			}
+		}()
+	}
+
+	for i := range reloadsNum {
+		err := s.Reload(ctx, []*client.Persistent{{
+			Name: fmt.Sprintf("client_%d", i),
+			IPs:  []netip.Addr{cliIP},
+			UID:  client.MustNewUID(),
+		}})
+		require.NoError(t, err)
+	}
+
+	close(done)
+	wg.Wait()
+	close(lookupErrs)
+
+	for msg := range lookupErrs {
+		t.Error(msg)
+	}
+
+	p, ok := s.Find(cliIP.String())
+	require.True(t, ok)
+
+	assert.Equal(t, fmt.Sprintf("client_%d", reloadsNum-1), p.Name)
+}
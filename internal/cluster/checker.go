@@ -0,0 +1,361 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/ioutil"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/AdguardTeam/golibs/service"
+	"github.com/c2h5oh/datasize"
+)
+
+// FingerprintPath is the path of the HTTP API returning the fingerprint of an
+// instance relative to its base URL.
+const FingerprintPath = "control/cluster/fingerprint"
+
+// maxFingerprintSize is the maximum size of the fingerprint response of a
+// peer.
+const maxFingerprintSize = 1 * datasize.MB
+
+// PeerConfig is the configuration of a single peer.
+type PeerConfig struct {
+	// URL is the base URL of the web interface of the peer.  It must not be
+	// nil.
+	URL *url.URL
+
+	// Name is the unique name of the peer.
+	Name string
+
+	// Username is the name of the user to authenticate with on the peer.  If
+	// empty, no authentication is used.
+	Username string
+
+	// Password is the password of the user to authenticate with on the peer.
+	Password string
+}
+
+// CheckerConfig is the configuration structure for [Checker].
+type CheckerConfig struct {
+	// Logger is used for logging the checks.  It must not be nil.
+	Logger *slog.Logger
+
+	// HTTPClient is used to fetch the fingerprints of the peers.  It must not
+	// be nil.
+	HTTPClient *http.Client
+
+	// Fingerprint returns the local fingerprint.  It must not be nil.
+	Fingerprint func() (fp *Fingerprint)
+
+	// Peers are the peers to check.  Each peer must not be nil.
+	Peers []*PeerConfig
+
+	// Interval is the interval between the checks.  It must be positive.
+	Interval time.Duration
+
+	// Timeout is the timeout of a single check of a peer.  It must be
+	// positive.
+	Timeout time.Duration
+}
+
+// Status is the state of the cluster as seen by the local instance.
+type Status struct {
+	// Local is the local fingerprint.
+	Local *Fingerprint `json:"local"`
+
+	// Peers are the states of the peers in the configuration order.
+	Peers []*PeerStatus `json:"peers"`
+}
+
+// PeerStatus is the state of a single peer.
+type PeerStatus struct {
+	// LastCheck is the time of the last check of the peer.  It's zero if the
+	// peer hasn't been checked yet.
+	LastCheck time.Time `json:"last_check"`
+
+	// Fingerprint is the fingerprint of the peer as of the last successful
+	// check.  It's nil if the last check has failed.
+	Fingerprint *Fingerprint `json:"fingerprint,omitempty"`
+
+	// Name is the name of the peer.
+	Name string `json:"name"`
+
+	// URL is the base URL of the peer.
+	URL string `json:"url"`
+
+	// Error is the error of the last check, if any.
+	Error string `json:"error,omitempty"`
+
+	// Sections are the states of the configuration sections of the peer.
+	Sections []*SectionDrift `json:"sections"`
+
+	// Filters are the drifted filter lists of the peer.
+	Filters []*FilterDrift `json:"filters"`
+
+	// LatencyMs is the duration of the last check, in milliseconds.
+	LatencyMs int64 `json:"latency_ms"`
+
+	// Healthy is true if the last check has succeeded.
+	Healthy bool `json:"healthy"`
+}
+
+// peerState is the result of the last check of a peer.
+type peerState struct {
+	// lastCheck is the time of the last check.
+	lastCheck time.Time
+
+	// fingerprint is the fingerprint fetched during the last check.  It's nil
+	// if the check has failed.
+	fingerprint *Fingerprint
+
+	// err is the error of the last check, if any.
+	err error
+
+	// latency is the duration of the last check.
+	latency time.Duration
+}
+
+// Checker periodically fetches the fingerprints of the peers and reports the
+// drift of their configurations from the local one.
+type Checker struct {
+	// logger is used for logging the checks.
+	logger *slog.Logger
+
+	// client is used to fetch the fingerprints of the peers.
+	client *http.Client
+
+	// fingerprint returns the local fingerprint.
+	fingerprint func() (fp *Fingerprint)
+
+	// mu protects states and done.
+	mu *sync.RWMutex
+
+	// states are the results of the last checks of the peers by their names.
+	states map[string]*peerState
+
+	// done is closed to stop the periodic checks.  It's nil if the checks
+	// aren't running.
+	done chan struct{}
+
+	// now returns the current time.  It's replaced in tests.
+	now func() (t time.Time)
+
+	// peers are the peers to check.
+	peers []*PeerConfig
+
+	// interval is the interval between the checks.
+	interval time.Duration
+
+	// timeout is the timeout of a single check.
+	timeout time.Duration
+}
+
+// NewChecker returns a new properly initialized *Checker.  conf must not be
+// nil.
+func NewChecker(conf *CheckerConfig) (c *Checker) {
+	return &Checker{
+		logger:      conf.Logger,
+		client:      conf.HTTPClient,
+		fingerprint: conf.Fingerprint,
+		mu:          &sync.RWMutex{},
+		states:      make(map[string]*peerState, len(conf.Peers)),
+		now:         time.Now,
+		peers:       conf.Peers,
+		interval:    conf.Interval,
+		timeout:     conf.Timeout,
+	}
+}
+
+// type check
+var _ service.Interface = (*Checker)(nil)
+
+// Start implements the [service.Interface] for *Checker.  It checks the peers
+// in a separate goroutine until [Checker.Shutdown] is called.
+func (c *Checker) Start(ctx context.Context) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.done != nil {
+		return errors.Error("already started")
+	}
+
+	c.done = make(chan struct{})
+
+	go c.run(ctx, c.done)
+
+	return nil
+}
+
+// Shutdown implements the [service.Interface] for *Checker.
+func (c *Checker) Shutdown(_ context.Context) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.done != nil {
+		close(c.done)
+		c.done = nil
+	}
+
+	return nil
+}
+
+// run checks the peers periodically until done is closed.  It is intended to
+// be used as a goroutine.
+func (c *Checker) run(ctx context.Context, done <-chan struct{}) {
+	defer slogutil.RecoverAndLog(ctx, c.logger)
+
+	t := time.NewTicker(c.interval)
+	defer t.Stop()
+
+	for {
+		c.Refresh(ctx)
+
+		select {
+		case <-done:
+			return
+		case <-t.C:
+			// Go on.
+		}
+	}
+}
+
+// Refresh checks all the peers concurrently and updates their states.  A
+// single check never takes longer than the configured timeout.
+func (c *Checker) Refresh(ctx context.Context) {
+	wg := &sync.WaitGroup{}
+	for _, p := range c.peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer slogutil.RecoverAndLog(ctx, c.logger)
+
+			c.refreshPeer(ctx, p)
+		}()
+	}
+
+	wg.Wait()
+}
+
+// refreshPeer checks p and updates its state.
+func (c *Checker) refreshPeer(ctx context.Context, p *PeerConfig) {
+	start := c.now()
+	fp, err := c.fetch(ctx, p)
+	st := &peerState{
+		lastCheck:   start,
+		fingerprint: fp,
+		err:         err,
+		latency:     c.now().Sub(start),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	prev := c.states[p.Name]
+	if err != nil {
+		c.logger.DebugContext(ctx, "checking peer", "peer", p.Name, slogutil.KeyError, err)
+
+		if prev == nil || prev.err == nil {
+			c.logger.WarnContext(ctx, "peer is unavailable", "peer", p.Name)
+		}
+	} else if prev != nil && prev.err != nil {
+		c.logger.InfoContext(ctx, "peer is available again", "peer", p.Name)
+	}
+
+	c.states[p.Name] = st
+}
+
+// fetch returns the fingerprint of p.
+func (c *Checker) fetch(ctx context.Context, p *PeerConfig) (fp *Fingerprint, err error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	u := p.URL.JoinPath(FingerprintPath)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	if p.Username != "" {
+		req.SetBasicAuth(p.Username, p.Password)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+	defer func() { err = errors.WithDeferred(err, resp.Body.Close()) }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	fp = &Fingerprint{}
+	err = json.NewDecoder(ioutil.LimitReader(resp.Body, maxFingerprintSize.Bytes())).Decode(fp)
+	if err != nil {
+		return nil, fmt.Errorf("decoding fingerprint: %w", err)
+	}
+
+	return fp, nil
+}
+
+// Status returns the current state of the cluster.  It never blocks on the
+// peers, since it only uses the results of the previous checks.
+func (c *Checker) Status() (s *Status) {
+	local := c.fingerprint()
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	s = &Status{
+		Local: local,
+		Peers: make([]*PeerStatus, 0, len(c.peers)),
+	}
+
+	for _, p := range c.peers {
+		s.Peers = append(s.Peers, c.peerStatus(local, p))
+	}
+
+	return s
+}
+
+// peerStatus returns the state of p compared to local.  c.mu is expected to
+// be locked.
+func (c *Checker) peerStatus(local *Fingerprint, p *PeerConfig) (ps *PeerStatus) {
+	ps = &PeerStatus{
+		Name:    p.Name,
+		URL:     p.URL.Redacted(),
+		Filters: []*FilterDrift{},
+	}
+
+	st := c.states[p.Name]
+	if st == nil {
+		ps.Error = "not checked yet"
+		ps.Sections = compareSections(local, nil)
+
+		return ps
+	}
+
+	ps.LastCheck = st.lastCheck
+	ps.LatencyMs = st.latency.Milliseconds()
+	ps.Fingerprint = st.fingerprint
+	ps.Sections = compareSections(local, st.fingerprint)
+
+	if st.err != nil {
+		ps.Error = st.err.Error()
+
+		return ps
+	}
+
+	ps.Healthy = true
+	ps.Filters = compareFilters(local, st.fingerprint)
+
+	return ps
+}
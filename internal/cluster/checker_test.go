@@ -0,0 +1,200 @@
+package cluster_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/cluster"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testTimeout is the common timeout for tests.
+const testTimeout = 1 * time.Second
+
+// Common filter list URLs for tests.
+const (
+	testListURL1 = "https://filters.example/1.txt"
+	testListURL2 = "https://filters.example/2.txt"
+	testListURL3 = "https://filters.example/3.txt"
+)
+
+// newTestFingerprint returns a fingerprint with the given section hash of the
+// "dns" section and the given checksum of the first filter list.
+func newTestFingerprint(dnsHash string, checksum uint32) (fp *cluster.Fingerprint) {
+	return &cluster.Fingerprint{
+		Sections: map[string]string{
+			"clients": "abcd",
+			"dns":     dnsHash,
+		},
+		Version: "v0.0.0",
+		Filters: []*cluster.FilterVersion{{
+			URL:      testListURL1,
+			Checksum: checksum,
+			Enabled:  true,
+		}, {
+			URL:      testListURL2,
+			Checksum: 2,
+			Enabled:  true,
+		}},
+		Running: true,
+	}
+}
+
+// newPeer starts a test server serving fp at [cluster.FingerprintPath] and
+// returns its configuration.  Unless the server receives the user and the
+// password of the configuration, it responds with 401.
+func newPeer(t *testing.T, name string, fp *cluster.Fingerprint) (p *cluster.PeerConfig) {
+	t.Helper()
+
+	const user, pass = "user", "pass"
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pt := testutil.PanicT{}
+
+		require.Equal(pt, "/"+cluster.FingerprintPath, r.URL.Path)
+
+		if u, p, ok := r.BasicAuth(); !ok || u != user || p != pass {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		require.NoError(pt, json.NewEncoder(w).Encode(fp))
+	}))
+	t.Cleanup(srv.Close)
+
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	return &cluster.PeerConfig{
+		URL:      u,
+		Name:     name,
+		Username: user,
+		Password: pass,
+	}
+}
+
+func TestChecker(t *testing.T) {
+	local := newTestFingerprint("1234", 1)
+
+	inSync := newPeer(t, "in_sync", newTestFingerprint("1234", 1))
+
+	driftedFP := newTestFingerprint("5678", 10)
+	driftedFP.Filters[1].Enabled = false
+	driftedFP.Filters = append(driftedFP.Filters, &cluster.FilterVersion{
+		URL:      testListURL3,
+		Checksum: 3,
+		Enabled:  true,
+	})
+	drifted := newPeer(t, "drifted", driftedFP)
+
+	unauthorized := newPeer(t, "unauthorized", local)
+	unauthorized.Password = "bad"
+
+	unblock := make(chan struct{})
+	deadSrv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+		<-unblock
+	}))
+	t.Cleanup(deadSrv.Close)
+	t.Cleanup(func() { close(unblock) })
+
+	deadURL, err := url.Parse(deadSrv.URL)
+	require.NoError(t, err)
+
+	dead := &cluster.PeerConfig{
+		URL:  deadURL,
+		Name: "dead",
+	}
+
+	c := cluster.NewChecker(&cluster.CheckerConfig{
+		Logger:      slogutil.NewDiscardLogger(),
+		HTTPClient:  &http.Client{},
+		Fingerprint: func() (fp *cluster.Fingerprint) { return local },
+		Peers:       []*cluster.PeerConfig{inSync, drifted, unauthorized, dead},
+		Interval:    time.Hour,
+		Timeout:     100 * time.Millisecond,
+	})
+
+	t.Run("not_checked", func(t *testing.T) {
+		s := c.Status()
+		require.Len(t, s.Peers, 4)
+
+		for _, p := range s.Peers {
+			assert.False(t, p.Healthy)
+			assert.Equal(t, []*cluster.SectionDrift{{
+				Name:  "clients",
+				State: cluster.SectionStateUnknown,
+			}, {
+				Name:  "dns",
+				State: cluster.SectionStateUnknown,
+			}}, p.Sections)
+		}
+	})
+
+	start := time.Now()
+	c.Refresh(testutil.ContextWithTimeout(t, testTimeout))
+	assert.Less(t, time.Since(start), testTimeout)
+
+	s := c.Status()
+	require.Len(t, s.Peers, 4)
+
+	assert.Same(t, local, s.Local)
+
+	t.Run("in_sync", func(t *testing.T) {
+		p := s.Peers[0]
+		assert.True(t, p.Healthy)
+		assert.Empty(t, p.Error)
+		assert.Empty(t, p.Filters)
+		assert.Equal(t, []*cluster.SectionDrift{{
+			Name:  "clients",
+			State: cluster.SectionStateInSync,
+		}, {
+			Name:  "dns",
+			State: cluster.SectionStateInSync,
+		}}, p.Sections)
+	})
+
+	t.Run("drifted", func(t *testing.T) {
+		p := s.Peers[1]
+		assert.True(t, p.Healthy)
+		assert.Equal(t, []*cluster.SectionDrift{{
+			Name:  "clients",
+			State: cluster.SectionStateInSync,
+		}, {
+			Name:  "dns",
+			State: cluster.SectionStateDrift,
+		}}, p.Sections)
+
+		require.Len(t, p.Filters, 3)
+
+		assert.Equal(t, testListURL1, p.Filters[0].URL)
+		assert.Equal(t, cluster.FilterStateVersionMismatch, p.Filters[0].State)
+		assert.Equal(t, testListURL2, p.Filters[1].URL)
+		assert.Equal(t, cluster.FilterStateEnabledMismatch, p.Filters[1].State)
+		assert.Equal(t, testListURL3, p.Filters[2].URL)
+		assert.Equal(t, cluster.FilterStateMissingLocally, p.Filters[2].State)
+		assert.Nil(t, p.Filters[2].Local)
+	})
+
+	t.Run("unauthorized", func(t *testing.T) {
+		p := s.Peers[2]
+		assert.False(t, p.Healthy)
+		assert.Equal(t, "unexpected status code 401", p.Error)
+		assert.Nil(t, p.Fingerprint)
+	})
+
+	t.Run("dead", func(t *testing.T) {
+		p := s.Peers[3]
+		assert.False(t, p.Healthy)
+		assert.NotEmpty(t, p.Error)
+		assert.False(t, p.LastCheck.IsZero())
+		assert.Equal(t, cluster.SectionStateUnknown, p.Sections[0].State)
+	})
+}
@@ -0,0 +1,196 @@
+// Package cluster detects the configuration drift between AdGuard Home
+// instances running as the members of a cluster.
+package cluster
+
+import (
+	"cmp"
+	"slices"
+	"time"
+)
+
+// Fingerprint is the summary of the configuration of an instance, which is
+// compared between the members of a cluster to detect the drift.
+type Fingerprint struct {
+	// Sections are the hex-encoded hashes of the configuration sections by
+	// their names.
+	Sections map[string]string `json:"sections"`
+
+	// Version is the version of AdGuard Home.
+	Version string `json:"version"`
+
+	// Filters are the versions of the filter lists.
+	Filters []*FilterVersion `json:"filters"`
+
+	// Running is true if the DNS server is running.
+	Running bool `json:"running"`
+}
+
+// FilterVersion is the version of a filter list.
+type FilterVersion struct {
+	// LastUpdated is the time of the last update of the list.
+	LastUpdated time.Time `json:"last_updated"`
+
+	// URL is the URL or the file path of the list.
+	URL string `json:"url"`
+
+	// RulesCount is the number of rules in the list.
+	RulesCount int `json:"rules_count"`
+
+	// Checksum is the checksum of the contents of the list.  It's zero if the
+	// list hasn't been loaded.
+	Checksum uint32 `json:"checksum"`
+
+	// Enabled is true if the list is enabled.
+	Enabled bool `json:"enabled"`
+}
+
+// SectionState is the state of a configuration section of a peer compared to
+// the local one.
+type SectionState string
+
+// Section states.
+const (
+	// SectionStateInSync means that the section of the peer is the same as
+	// the local one.
+	SectionStateInSync SectionState = "in_sync"
+
+	// SectionStateDrift means that the section of the peer differs from the
+	// local one.
+	SectionStateDrift SectionState = "drift"
+
+	// SectionStateUnknown means that the section of the peer isn't known,
+	// since the peer is unavailable or doesn't report it.
+	SectionStateUnknown SectionState = "unknown"
+)
+
+// SectionDrift is the state of a single configuration section of a peer.
+type SectionDrift struct {
+	// Name is the name of the section.
+	Name string `json:"name"`
+
+	// State is the state of the section compared to the local one.
+	State SectionState `json:"state"`
+}
+
+// FilterState is the state of a filter list of a peer compared to the local
+// one.
+type FilterState string
+
+// Filter states.
+const (
+	// FilterStateMissingOnPeer means that the list is only present locally.
+	FilterStateMissingOnPeer FilterState = "missing_on_peer"
+
+	// FilterStateMissingLocally means that the list is only present on the
+	// peer.
+	FilterStateMissingLocally FilterState = "missing_locally"
+
+	// FilterStateEnabledMismatch means that the list is enabled on only one of
+	// the instances.
+	FilterStateEnabledMismatch FilterState = "enabled_mismatch"
+
+	// FilterStateVersionMismatch means that the contents of the list differ.
+	FilterStateVersionMismatch FilterState = "version_mismatch"
+)
+
+// FilterDrift is the drift of a single filter list of a peer.
+type FilterDrift struct {
+	// Local is the local version of the list.  It's nil if the state is
+	// [FilterStateMissingLocally].
+	Local *FilterVersion `json:"local,omitempty"`
+
+	// Peer is the version of the list on the peer.  It's nil if the state is
+	// [FilterStateMissingOnPeer].
+	Peer *FilterVersion `json:"peer,omitempty"`
+
+	// URL is the URL or the file path of the list.
+	URL string `json:"url"`
+
+	// State is the state of the list compared to the local one.
+	State FilterState `json:"state"`
+}
+
+// compareSections returns the states of the sections of peer compared to the
+// ones of local sorted by name.  peer may be nil, in which case all the
+// states are [SectionStateUnknown].
+func compareSections(local, peer *Fingerprint) (drifts []*SectionDrift) {
+	drifts = make([]*SectionDrift, 0, len(local.Sections))
+	for name, hash := range local.Sections {
+		state := SectionStateUnknown
+		if peer != nil {
+			peerHash, ok := peer.Sections[name]
+			switch {
+			case !ok:
+				// Go on.
+			case peerHash == hash:
+				state = SectionStateInSync
+			default:
+				state = SectionStateDrift
+			}
+		}
+
+		drifts = append(drifts, &SectionDrift{
+			Name:  name,
+			State: state,
+		})
+	}
+
+	slices.SortFunc(drifts, func(a, b *SectionDrift) (res int) {
+		return cmp.Compare(a.Name, b.Name)
+	})
+
+	return drifts
+}
+
+// compareFilters returns the filter lists of peer, which differ from the ones
+// of local, sorted by URL.  peer must not be nil.
+func compareFilters(local, peer *Fingerprint) (drifts []*FilterDrift) {
+	peerFilters := make(map[string]*FilterVersion, len(peer.Filters))
+	for _, f := range peer.Filters {
+		peerFilters[f.URL] = f
+	}
+
+	drifts = []*FilterDrift{}
+	seen := make(map[string]struct{}, len(local.Filters))
+	for _, l := range local.Filters {
+		seen[l.URL] = struct{}{}
+
+		p, ok := peerFilters[l.URL]
+		var state FilterState
+		switch {
+		case !ok:
+			state = FilterStateMissingOnPeer
+		case l.Enabled != p.Enabled:
+			state = FilterStateEnabledMismatch
+		case l.Checksum != p.Checksum:
+			state = FilterStateVersionMismatch
+		default:
+			continue
+		}
+
+		drifts = append(drifts, &FilterDrift{
+			Local: l,
+			Peer:  p,
+			URL:   l.URL,
+			State: state,
+		})
+	}
+
+	for _, p := range peer.Filters {
+		if _, ok := seen[p.URL]; ok {
+			continue
+		}
+
+		drifts = append(drifts, &FilterDrift{
+			Peer:  p,
+			URL:   p.URL,
+			State: FilterStateMissingLocally,
+		})
+	}
+
+	slices.SortStableFunc(drifts, func(a, b *FilterDrift) (res int) {
+		return cmp.Compare(a.URL, b.URL)
+	})
+
+	return drifts
+}
@@ -1729,3 +1729,385 @@ func TestUpgradeSchema27to28(t *testing.T) {
 		})
 	}
 }
+
+func TestUpgradeSchema29to30(t *testing.T) {
+	const newSchemaVer = 30
+
+	testCases := []struct {
+		in   yobj
+		want yobj
+		name string
+	}{{
+		name: "empty",
+		in:   yobj{},
+		want: yobj{
+			"schema_version": newSchemaVer,
+		},
+	}, {
+		name: "ok",
+		in: yobj{
+			"auth_attempts":  5,
+			"block_auth_min": 15,
+		},
+		want: yobj{
+			"auth": yobj{
+				"attempts_limit": 5,
+				"block_duration": "15m",
+			},
+			"schema_version": newSchemaVer,
+		},
+	}, {
+		name: "attempts_only",
+		in: yobj{
+			"auth_attempts": 3,
+		},
+		want: yobj{
+			"auth": yobj{
+				"attempts_limit": 3,
+			},
+			"schema_version": newSchemaVer,
+		},
+	}, {
+		name: "disabled",
+		in: yobj{
+			"auth_attempts":  5,
+			"block_auth_min": 0,
+		},
+		want: yobj{
+			"auth": yobj{
+				"attempts_limit": 0,
+				"block_duration": "0s",
+			},
+			"schema_version": newSchemaVer,
+		},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := migrateTo30(tc.in)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.want, tc.in)
+		})
+	}
+}
+
+func TestUpgradeSchema30to31(t *testing.T) {
+	const newSchemaVer = 31
+
+	testCases := []struct {
+		in   yobj
+		want yobj
+		name string
+	}{{
+		name: "empty",
+		in:   yobj{},
+		want: yobj{
+			"schema_version": newSchemaVer,
+		},
+	}, {
+		name: "ok",
+		in: yobj{
+			"filtering": yobj{
+				"safe_search": yobj{
+					"enabled": true,
+					"bing":    true,
+				},
+			},
+			"clients": yobj{
+				"persistent": yarr{yobj{
+					"name": "client",
+					"safe_search": yobj{
+						"enabled": false,
+						"google":  false,
+					},
+				}, yobj{
+					"name": "client-without-safe-search",
+				}},
+			},
+		},
+		want: yobj{
+			"filtering": yobj{
+				"safe_search": yobj{
+					"enabled": true,
+					"bing":    true,
+					"brave":   true,
+					"ecosia":  true,
+				},
+			},
+			"clients": yobj{
+				"persistent": yarr{yobj{
+					"name": "client",
+					"safe_search": yobj{
+						"enabled": false,
+						"google":  false,
+						"brave":   true,
+						"ecosia":  true,
+					},
+				}, yobj{
+					"name": "client-without-safe-search",
+				}},
+			},
+			"schema_version": newSchemaVer,
+		},
+	}, {
+		name: "keep_existing",
+		in: yobj{
+			"filtering": yobj{
+				"safe_search": yobj{
+					"enabled": true,
+					"ecosia":  false,
+				},
+			},
+		},
+		want: yobj{
+			"filtering": yobj{
+				"safe_search": yobj{
+					"enabled": true,
+					"brave":   true,
+					"ecosia":  false,
+				},
+			},
+			"schema_version": newSchemaVer,
+		},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := migrateTo31(tc.in)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.want, tc.in)
+		})
+	}
+}
+
+func TestUpgradeSchema31to32(t *testing.T) {
+	const newSchemaVer = 32
+
+	testCases := []struct {
+		in   yobj
+		want yobj
+		name string
+	}{{
+		name: "empty",
+		in:   yobj{},
+		want: yobj{
+			"schema_version": newSchemaVer,
+		},
+	}, {
+		name: "ok",
+		in: yobj{
+			"clients": yobj{
+				"persistent": yarr{yobj{
+					"name": "client",
+				}, yobj{
+					"name":  "client-with-rules",
+					"rules": yarr{"||example.org^"},
+				}},
+			},
+		},
+		want: yobj{
+			"clients": yobj{
+				"persistent": yarr{yobj{
+					"name":  "client",
+					"rules": yarr{},
+				}, yobj{
+					"name":  "client-with-rules",
+					"rules": yarr{"||example.org^"},
+				}},
+			},
+			"schema_version": newSchemaVer,
+		},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := migrateTo32(tc.in)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.want, tc.in)
+		})
+	}
+}
+
+func TestUpgradeSchema32to33(t *testing.T) {
+	const newSchemaVer = 33
+
+	testCases := []struct {
+		in   yobj
+		want yobj
+		name string
+	}{{
+		name: "empty",
+		in:   yobj{},
+		want: yobj{
+			"schema_version": newSchemaVer,
+		},
+	}, {
+		name: "ok",
+		in: yobj{
+			"dns": yobj{
+				"upstream_mode": "failover",
+			},
+		},
+		want: yobj{
+			"dns": yobj{
+				"upstream_mode":              "failover",
+				"failover_recovery_interval": "1m",
+			},
+			"schema_version": newSchemaVer,
+		},
+	}, {
+		name: "existing",
+		in: yobj{
+			"dns": yobj{
+				"failover_recovery_interval": "5m",
+			},
+		},
+		want: yobj{
+			"dns": yobj{
+				"failover_recovery_interval": "5m",
+			},
+			"schema_version": newSchemaVer,
+		},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := migrateTo33(tc.in)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.want, tc.in)
+		})
+	}
+}
+
+func TestUpgradeSchema33to34(t *testing.T) {
+	const newSchemaVer = 34
+
+	testCases := []struct {
+		in   yobj
+		want yobj
+		name string
+	}{{
+		name: "empty",
+		in:   yobj{},
+		want: yobj{
+			"schema_version": newSchemaVer,
+		},
+	}, {
+		name: "ok",
+		in: yobj{
+			"filters": yarr{yobj{
+				"url": "https://example.com/filter.txt",
+			}, yobj{
+				"url":      "https://example.com/ads.txt",
+				"category": "Ads",
+			}},
+			"whitelist_filters": yarr{yobj{
+				"url": "https://example.com/allowlist.txt",
+			}},
+		},
+		want: yobj{
+			"filters": yarr{yobj{
+				"url":      "https://example.com/filter.txt",
+				"category": "Custom",
+			}, yobj{
+				"url":      "https://example.com/ads.txt",
+				"category": "Ads",
+			}},
+			"whitelist_filters": yarr{yobj{
+				"url":      "https://example.com/allowlist.txt",
+				"category": "Custom",
+			}},
+			"schema_version": newSchemaVer,
+		},
+	}, {
+		name: "null",
+		in: yobj{
+			"filters":           nil,
+			"whitelist_filters": yarr{},
+		},
+		want: yobj{
+			"filters":           nil,
+			"whitelist_filters": yarr{},
+			"schema_version":    newSchemaVer,
+		},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := migrateTo34(tc.in)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.want, tc.in)
+		})
+	}
+}
+
+func TestUpgradeSchema34to35(t *testing.T) {
+	const newSchemaVer = 35
+
+	testCases := []struct {
+		in   yobj
+		want yobj
+		name string
+	}{{
+		name: "empty",
+		in:   yobj{},
+		want: yobj{
+			"schema_version": newSchemaVer,
+		},
+	}, {
+		name: "paused",
+		in: yobj{
+			"filtering": yobj{
+				"protection_enabled":        false,
+				"protection_disabled_until": "2026-01-01T00:00:00Z",
+			},
+		},
+		want: yobj{
+			"filtering": yobj{
+				"protection_enabled": true,
+			},
+			"schema_version": newSchemaVer,
+		},
+	}, {
+		name: "not_paused",
+		in: yobj{
+			"filtering": yobj{
+				"protection_enabled":        false,
+				"protection_disabled_until": nil,
+			},
+		},
+		want: yobj{
+			"filtering": yobj{
+				"protection_enabled": false,
+			},
+			"schema_version": newSchemaVer,
+		},
+	}, {
+		name: "no_field",
+		in: yobj{
+			"filtering": yobj{
+				"protection_enabled": false,
+			},
+		},
+		want: yobj{
+			"filtering": yobj{
+				"protection_enabled": false,
+			},
+			"schema_version": newSchemaVer,
+		},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := migrateTo35(tc.in)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.want, tc.in)
+		})
+	}
+}
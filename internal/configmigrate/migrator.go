@@ -125,6 +125,12 @@ func (m *Migrator) upgradeConfigSchema(current, target uint, diskConf yobj) (err
 		26: migrateTo27,
 		27: migrateTo28,
 		28: m.migrateTo29,
+		29: migrateTo30,
+		30: migrateTo31,
+		31: migrateTo32,
+		32: migrateTo33,
+		33: migrateTo34,
+		34: migrateTo35,
 	}
 
 	for i, migrate := range upgrades[current:target] {
@@ -0,0 +1,94 @@
+package configmigrate
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghos"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/google/renameio/v2/maybe"
+)
+
+// BackupExt is the extension added to the path of the configuration file to
+// get the path of its pre-migration backup.
+const BackupExt = ".bak"
+
+// ErrNoBackup is returned by [MigratorWithRollback.Rollback] when there is no
+// backup of the configuration file.
+const ErrNoBackup errors.Error = "no backup of configuration file"
+
+// MigratorWithRollback is a [Migrator] which backs the configuration file up
+// before upgrading it, so that the configuration can be rolled back in case the
+// upgraded one breaks the startup.
+type MigratorWithRollback struct {
+	migrator *Migrator
+
+	// confPath is the path to the configuration file.
+	confPath string
+
+	// backupPath is the path to the backup of the configuration file.
+	backupPath string
+}
+
+// NewWithRollback creates a new MigratorWithRollback for the configuration
+// file located at confPath.
+func NewWithRollback(c *Config, confPath string) (m *MigratorWithRollback) {
+	return &MigratorWithRollback{
+		migrator:   New(c),
+		confPath:   confPath,
+		backupPath: confPath + BackupExt,
+	}
+}
+
+// BackupPath returns the path to the backup of the configuration file.
+func (m *MigratorWithRollback) BackupPath() (p string) {
+	return m.backupPath
+}
+
+// Migrate is like [Migrator.Migrate], but it also writes body to the backup
+// file if it has been upgraded.  Failing to write the backup doesn't prevent
+// the upgrade, but makes the rollback impossible.
+func (m *MigratorWithRollback) Migrate(
+	body []byte,
+	target uint,
+) (newBody []byte, upgraded bool, err error) {
+	newBody, upgraded, err = m.migrator.Migrate(body, target)
+	if err != nil || !upgraded {
+		// Don't wrap the error, since it's informative enough as is.
+		return newBody, upgraded, err
+	}
+
+	err = maybe.WriteFile(m.backupPath, body, aghos.DefaultPermFile)
+	if err != nil {
+		log.Error("configmigrate: writing backup to %q: %s", m.backupPath, err)
+	} else {
+		log.Info("configmigrate: wrote pre-migration backup to %q", m.backupPath)
+	}
+
+	return newBody, true, nil
+}
+
+// Rollback replaces the configuration file with its pre-migration backup and
+// returns the restored body.  It returns [ErrNoBackup] if there is no backup.
+func (m *MigratorWithRollback) Rollback() (body []byte, err error) {
+	// #nosec G304 -- Trust the path, since it's constructed from the path to
+	// the configuration file.
+	body, err = os.ReadFile(m.backupPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNoBackup
+		}
+
+		return nil, fmt.Errorf("reading backup: %w", err)
+	}
+
+	err = maybe.WriteFile(m.confPath, body, aghos.DefaultPermFile)
+	if err != nil {
+		return nil, fmt.Errorf("restoring backup: %w", err)
+	}
+
+	log.Info("configmigrate: restored %q from %q", m.confPath, m.backupPath)
+
+	return body, nil
+}
@@ -0,0 +1,84 @@
+package configmigrate_test
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/configmigrate"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// startWithConfig is a stub of the startup, which fails unless the
+// configuration file at confPath is valid.
+func startWithConfig(confPath string) (err error) {
+	body, err := os.ReadFile(confPath)
+	if err != nil {
+		return err
+	}
+
+	conf := &struct {
+		SchemaVersion uint `yaml:"schema_version"`
+	}{}
+	err = yaml.Unmarshal(body, conf)
+	if err != nil {
+		return err
+	} else if conf.SchemaVersion == 0 {
+		return errors.Error("no schema version")
+	}
+
+	return nil
+}
+
+func TestMigratorWithRollback(t *testing.T) {
+	const targetVersion = 27
+
+	body, err := fs.ReadFile(testdata, path.Join("TestMigrateConfig_Migrate", "v27", "input.yml"))
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	confPath := filepath.Join(dir, "AdGuardHome.yaml")
+	err = os.WriteFile(confPath, body, 0o600)
+	require.NoError(t, err)
+
+	m := configmigrate.NewWithRollback(&configmigrate.Config{
+		WorkingDir: dir,
+		DataDir:    filepath.Join(dir, "data"),
+	}, confPath)
+
+	t.Run("no_backup", func(t *testing.T) {
+		_, rbErr := m.Rollback()
+		assert.ErrorIs(t, rbErr, configmigrate.ErrNoBackup)
+	})
+
+	_, upgraded, err := m.Migrate(body, targetVersion)
+	require.NoError(t, err)
+	require.True(t, upgraded)
+
+	backup, err := os.ReadFile(m.BackupPath())
+	require.NoError(t, err)
+
+	assert.Equal(t, body, backup)
+
+	// Write an intentionally corrupt migration result.
+	err = os.WriteFile(confPath, []byte("schema_version: [\n"), 0o600)
+	require.NoError(t, err)
+
+	require.Error(t, startWithConfig(confPath))
+
+	restored, err := m.Rollback()
+	require.NoError(t, err)
+
+	assert.Equal(t, body, restored)
+
+	got, err := os.ReadFile(confPath)
+	require.NoError(t, err)
+
+	assert.Equal(t, body, got)
+	assert.NoError(t, startWithConfig(confPath))
+}
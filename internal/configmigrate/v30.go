@@ -0,0 +1,58 @@
+package configmigrate
+
+import (
+	"time"
+
+	"github.com/AdguardTeam/golibs/timeutil"
+)
+
+// migrateTo30 performs the following changes:
+//
+//	# BEFORE:
+//	'auth_attempts': 5
+//	'block_auth_min': 15
+//	# …
+//
+//	# AFTER:
+//	'auth':
+//	  'attempts_limit': 5
+//	  'block_duration': '15m'
+//	# …
+func migrateTo30(diskConf yobj) (err error) {
+	diskConf["schema_version"] = 30
+
+	attempts, hasAttempts, err := fieldVal[int](diskConf, "auth_attempts")
+	if err != nil {
+		return err
+	}
+
+	blockMin, hasBlockMin, err := fieldVal[int](diskConf, "block_auth_min")
+	if err != nil {
+		return err
+	}
+
+	if !hasAttempts && !hasBlockMin {
+		return nil
+	}
+
+	auth := yobj{}
+	if hasAttempts {
+		auth["attempts_limit"] = attempts
+	}
+
+	if hasBlockMin && blockMin == 0 {
+		// Previously, zero block duration disabled the login throttling.
+		auth["attempts_limit"] = 0
+	}
+
+	if hasBlockMin {
+		auth["block_duration"] = timeutil.Duration(time.Duration(blockMin) * time.Minute).String()
+	}
+
+	diskConf["auth"] = auth
+
+	delete(diskConf, "auth_attempts")
+	delete(diskConf, "block_auth_min")
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package configmigrate
+
+// migrateTo31 performs the following changes:
+//
+//	# BEFORE:
+//	'schema_version': 30
+//	'filtering':
+//	  'safe_search':
+//	    'enabled': true
+//	    'bing': true
+//	    # …
+//	  # …
+//	'clients':
+//	  'persistent':
+//	  - 'name': 'client-name'
+//	    'safe_search':
+//	      'enabled': true
+//	      'bing': true
+//	      # …
+//	    # …
+//	  # …
+//	# …
+//
+//	# AFTER:
+//	'schema_version': 31
+//	'filtering':
+//	  'safe_search':
+//	    'enabled': true
+//	    'bing': true
+//	    'brave': true
+//	    'ecosia': true
+//	    # …
+//	  # …
+//	'clients':
+//	  'persistent':
+//	  - 'name': 'client-name'
+//	    'safe_search':
+//	      'enabled': true
+//	      'bing': true
+//	      'brave': true
+//	      'ecosia': true
+//	      # …
+//	    # …
+//	  # …
+//	# …
+//
+// The existing values of the new services are kept.
+func migrateTo31(diskConf yobj) (err error) {
+	diskConf["schema_version"] = 31
+
+	filtering, ok, err := fieldVal[yobj](diskConf, "filtering")
+	if err != nil {
+		return err
+	} else if ok {
+		err = addSafeSearchServices(filtering)
+		if err != nil {
+			return err
+		}
+	}
+
+	clients, ok, err := fieldVal[yobj](diskConf, "clients")
+	if !ok {
+		return err
+	}
+
+	persistent, ok, err := fieldVal[yarr](clients, "persistent")
+	if !ok {
+		return err
+	}
+
+	for _, p := range persistent {
+		c, isObj := p.(yobj)
+		if !isObj {
+			continue
+		}
+
+		err = addSafeSearchServices(c)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addSafeSearchServices enables the safe search services added in schema
+// version 31 in the safe search object of obj, if there is one and they aren't
+// set already.
+func addSafeSearchServices(obj yobj) (err error) {
+	safeSearch, ok, err := fieldVal[yobj](obj, "safe_search")
+	if !ok {
+		return err
+	}
+
+	for _, key := range []string{"brave", "ecosia"} {
+		if _, ok = safeSearch[key]; !ok {
+			safeSearch[key] = true
+		}
+	}
+
+	return nil
+}
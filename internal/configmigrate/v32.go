@@ -0,0 +1,50 @@
+package configmigrate
+
+// migrateTo32 performs the following changes:
+//
+//	# BEFORE:
+//	'schema_version': 31
+//	'clients':
+//	  'persistent':
+//	  - 'name': 'client-name'
+//	    # …
+//	  # …
+//	# …
+//
+//	# AFTER:
+//	'schema_version': 32
+//	'clients':
+//	  'persistent':
+//	  - 'name': 'client-name'
+//	    'rules': []
+//	    # …
+//	  # …
+//	# …
+//
+// The existing rules are kept.
+func migrateTo32(diskConf yobj) (err error) {
+	diskConf["schema_version"] = 32
+
+	clients, ok, err := fieldVal[yobj](diskConf, "clients")
+	if !ok {
+		return err
+	}
+
+	persistent, ok, err := fieldVal[yarr](clients, "persistent")
+	if !ok {
+		return err
+	}
+
+	for _, p := range persistent {
+		c, isObj := p.(yobj)
+		if !isObj {
+			continue
+		}
+
+		if _, ok = c["rules"]; !ok {
+			c["rules"] = yarr{}
+		}
+	}
+
+	return nil
+}
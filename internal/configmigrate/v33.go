@@ -0,0 +1,34 @@
+package configmigrate
+
+// migrateTo33 performs the following changes:
+//
+//	# BEFORE:
+//	'schema_version': 32
+//	'dns':
+//	  'upstream_mode': 'load_balance'
+//	  # …
+//	# …
+//
+//	# AFTER:
+//	'schema_version': 33
+//	'dns':
+//	  'upstream_mode': 'load_balance'
+//	  'failover_recovery_interval': '1m'
+//	  # …
+//	# …
+//
+// The existing interval is kept.
+func migrateTo33(diskConf yobj) (err error) {
+	diskConf["schema_version"] = 33
+
+	dns, ok, err := fieldVal[yobj](diskConf, "dns")
+	if !ok {
+		return err
+	}
+
+	if _, ok = dns["failover_recovery_interval"]; !ok {
+		dns["failover_recovery_interval"] = "1m"
+	}
+
+	return nil
+}
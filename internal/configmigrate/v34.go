@@ -0,0 +1,50 @@
+package configmigrate
+
+// migrateTo34 performs the following changes:
+//
+//	# BEFORE:
+//	'schema_version': 33
+//	'filters':
+//	- 'url': 'https://example.com/filter.txt'
+//	  # …
+//	'whitelist_filters':
+//	- 'url': 'https://example.com/allowlist.txt'
+//	  # …
+//	# …
+//
+//	# AFTER:
+//	'schema_version': 34
+//	'filters':
+//	- 'url': 'https://example.com/filter.txt'
+//	  'category': 'Custom'
+//	  # …
+//	'whitelist_filters':
+//	- 'url': 'https://example.com/allowlist.txt'
+//	  'category': 'Custom'
+//	  # …
+//	# …
+//
+// The existing categories are kept.
+func migrateTo34(diskConf yobj) (err error) {
+	diskConf["schema_version"] = 34
+
+	for _, key := range []string{"filters", "whitelist_filters"} {
+		filters, _, fltErr := fieldVal[yarr](diskConf, key)
+		if fltErr != nil {
+			return fltErr
+		}
+
+		for _, f := range filters {
+			fltObj, isObj := f.(yobj)
+			if !isObj {
+				continue
+			}
+
+			if _, ok := fltObj["category"]; !ok {
+				fltObj["category"] = "Custom"
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,41 @@
+package configmigrate
+
+// migrateTo35 performs the following changes:
+//
+//	# BEFORE:
+//	'schema_version': 34
+//	'filtering':
+//	  'protection_enabled': false
+//	  'protection_disabled_until': '2026-01-01T00:00:00Z'
+//	  # …
+//	# …
+//
+//	# AFTER:
+//	'schema_version': 35
+//	'filtering':
+//	  'protection_enabled': true
+//	  # …
+//	# …
+//
+// Pauses of the protection aren't stored in the configuration anymore, so a
+// paused protection is enabled back.
+func migrateTo35(diskConf yobj) (err error) {
+	diskConf["schema_version"] = 35
+
+	filtering, ok, err := fieldVal[yobj](diskConf, "filtering")
+	if !ok {
+		return err
+	}
+
+	disabledUntil, ok := filtering["protection_disabled_until"]
+	if !ok {
+		return nil
+	}
+
+	delete(filtering, "protection_disabled_until")
+	if disabledUntil != nil {
+		filtering["protection_enabled"] = true
+	}
+
+	return nil
+}
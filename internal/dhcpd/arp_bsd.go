@@ -0,0 +1,11 @@
+//go:build darwin || freebsd || openbsd
+
+package dhcpd
+
+import "time"
+
+// newARPProber returns nil and false, since ARP probes aren't supported on
+// this platform yet.
+func newARPProber(_ string, _ time.Duration) (p addrProber, ok bool) {
+	return nil, false
+}
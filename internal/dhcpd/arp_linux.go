@@ -0,0 +1,146 @@
+//go:build linux
+
+package dhcpd
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/mdlayher/ethernet"
+	"github.com/mdlayher/packet"
+)
+
+// arpBufLen is the size of the buffer for the received ARP packets, which is
+// enough for an Ethernet frame containing an ARP packet for IPv4.
+const arpBufLen = 128
+
+// arpProber is the [addrProber] that sends ARP probes as described in RFC 5227.
+type arpProber struct {
+	// ifaceName is the name of the network interface to send probes from.
+	ifaceName string
+
+	// timeout is the time to wait for the replies.
+	timeout time.Duration
+}
+
+// type check
+var _ addrProber = (*arpProber)(nil)
+
+// newARPProber returns a new ARP prober for the network interface with the
+// given name.  ok is always true on Linux.
+func newARPProber(ifaceName string, timeout time.Duration) (p addrProber, ok bool) {
+	return &arpProber{
+		ifaceName: ifaceName,
+		timeout:   timeout,
+	}, true
+}
+
+// probe implements the [addrProber] interface for *arpProber.
+func (p *arpProber) probe(target netip.Addr) (inUse bool, err error) {
+	iface, err := net.InterfaceByName(p.ifaceName)
+	if err != nil {
+		return false, fmt.Errorf("getting interface %q: %w", p.ifaceName, err)
+	}
+
+	conn, err := packet.Listen(iface, packet.Raw, int(ethernet.EtherTypeARP), nil)
+	if err != nil {
+		return false, fmt.Errorf("creating raw arp connection: %w", err)
+	}
+	defer func() { err = errors.WithDeferred(err, conn.Close()) }()
+
+	pkt, err := newARPProbe(iface.HardwareAddr, target)
+	if err != nil {
+		return false, fmt.Errorf("building arp probe: %w", err)
+	}
+
+	_, err = conn.WriteTo(pkt, &packet.Addr{HardwareAddr: layers.EthernetBroadcast})
+	if err != nil {
+		return false, fmt.Errorf("sending arp probe: %w", err)
+	}
+
+	err = conn.SetReadDeadline(time.Now().Add(p.timeout))
+	if err != nil {
+		return false, fmt.Errorf("setting deadline: %w", err)
+	}
+
+	buf := make([]byte, arpBufLen)
+	for {
+		var n int
+		n, _, err = conn.ReadFrom(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return false, nil
+		} else if err != nil {
+			return false, fmt.Errorf("reading arp packet: %w", err)
+		}
+
+		if isARPConflict(buf[:n], iface.HardwareAddr, target) {
+			return true, nil
+		}
+	}
+}
+
+// newARPProbe returns an Ethernet frame containing the ARP probe for target
+// sent from the hardware address srcMAC.  The sender protocol address of the
+// probe is all zeroes, see RFC 5227, Section 2.1.1.
+func newARPProbe(srcMAC net.HardwareAddr, target netip.Addr) (pkt []byte, err error) {
+	eth := &layers.Ethernet{
+		SrcMAC:       srcMAC,
+		DstMAC:       layers.EthernetBroadcast,
+		EthernetType: layers.EthernetTypeARP,
+	}
+
+	arp := &layers.ARP{
+		AddrType:          layers.LinkTypeEthernet,
+		Protocol:          layers.EthernetTypeIPv4,
+		HwAddressSize:     6,
+		ProtAddressSize:   4,
+		Operation:         layers.ARPRequest,
+		SourceHwAddress:   srcMAC,
+		SourceProtAddress: net.IPv4zero.To4(),
+		DstHwAddress:      make(net.HardwareAddr, 6),
+		DstProtAddress:    target.AsSlice(),
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	err = gopacket.SerializeLayers(buf, gopacket.SerializeOptions{}, eth, arp)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// isARPConflict returns true if frame contains an ARP packet which shows that
+// target is used or probed by another device, see RFC 5227, Section 2.1.1.
+// ownMAC is the hardware address of the interface the probe has been sent
+// from.
+func isARPConflict(frame []byte, ownMAC net.HardwareAddr, target netip.Addr) (ok bool) {
+	p := gopacket.NewPacket(frame, layers.LayerTypeEthernet, gopacket.DecodeOptions{
+		Lazy:   true,
+		NoCopy: true,
+	})
+
+	arp, ok := p.Layer(layers.LayerTypeARP).(*layers.ARP)
+	if !ok || bytes.Equal(arp.SourceHwAddress, ownMAC) {
+		return false
+	}
+
+	srcIP, _ := netip.AddrFromSlice(arp.SourceProtAddress)
+	if srcIP == target {
+		// Either a reply or a request from the device which uses the address.
+		return true
+	}
+
+	dstIP, _ := netip.AddrFromSlice(arp.DstProtAddress)
+
+	// Another device is probing the same address.
+	return arp.Operation == layers.ARPRequest && srcIP.IsUnspecified() && dstIP == target
+}
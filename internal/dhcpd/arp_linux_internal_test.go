@@ -0,0 +1,123 @@
+//go:build linux
+
+package dhcpd
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestARPFrame returns an Ethernet frame containing the ARP packet with the
+// given parameters.
+func newTestARPFrame(
+	t *testing.T,
+	op uint16,
+	srcMAC net.HardwareAddr,
+	srcIP netip.Addr,
+	dstIP netip.Addr,
+) (frame []byte) {
+	t.Helper()
+
+	eth := &layers.Ethernet{
+		SrcMAC:       srcMAC,
+		DstMAC:       layers.EthernetBroadcast,
+		EthernetType: layers.EthernetTypeARP,
+	}
+
+	arp := &layers.ARP{
+		AddrType:          layers.LinkTypeEthernet,
+		Protocol:          layers.EthernetTypeIPv4,
+		HwAddressSize:     6,
+		ProtAddressSize:   4,
+		Operation:         op,
+		SourceHwAddress:   srcMAC,
+		SourceProtAddress: srcIP.AsSlice(),
+		DstHwAddress:      make(net.HardwareAddr, 6),
+		DstProtAddress:    dstIP.AsSlice(),
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{}, eth, arp)
+	require.NoError(t, err)
+
+	return buf.Bytes()
+}
+
+func TestNewARPProbe(t *testing.T) {
+	srcMAC := net.HardwareAddr{1, 2, 3, 4, 5, 6}
+	target := netip.MustParseAddr("192.168.10.100")
+
+	pkt, err := newARPProbe(srcMAC, target)
+	require.NoError(t, err)
+
+	p := gopacket.NewPacket(pkt, layers.LayerTypeEthernet, gopacket.Default)
+
+	eth, ok := p.Layer(layers.LayerTypeEthernet).(*layers.Ethernet)
+	require.True(t, ok)
+
+	assert.Equal(t, layers.EthernetBroadcast, eth.DstMAC)
+	assert.Equal(t, srcMAC, eth.SrcMAC)
+
+	arp, ok := p.Layer(layers.LayerTypeARP).(*layers.ARP)
+	require.True(t, ok)
+
+	assert.Equal(t, uint16(layers.ARPRequest), arp.Operation)
+	assert.Equal(t, []byte(srcMAC), arp.SourceHwAddress)
+	assert.Equal(t, []byte{0, 0, 0, 0}, arp.SourceProtAddress)
+	assert.Equal(t, target.AsSlice(), arp.DstProtAddress)
+}
+
+func TestIsARPConflict(t *testing.T) {
+	ownMAC := net.HardwareAddr{1, 2, 3, 4, 5, 6}
+	otherMAC := net.HardwareAddr{6, 5, 4, 3, 2, 1}
+
+	target := netip.MustParseAddr("192.168.10.100")
+	otherIP := netip.MustParseAddr("192.168.10.101")
+	zeroIP := netip.IPv4Unspecified()
+
+	testCases := []struct {
+		frame []byte
+		name  string
+		want  bool
+	}{{
+		frame: newTestARPFrame(t, layers.ARPReply, otherMAC, target, zeroIP),
+		name:  "reply",
+		want:  true,
+	}, {
+		frame: newTestARPFrame(t, layers.ARPRequest, otherMAC, target, otherIP),
+		name:  "request_from_target",
+		want:  true,
+	}, {
+		frame: newTestARPFrame(t, layers.ARPRequest, otherMAC, zeroIP, target),
+		name:  "probe_from_other",
+		want:  true,
+	}, {
+		frame: newTestARPFrame(t, layers.ARPRequest, ownMAC, zeroIP, target),
+		name:  "own_probe",
+		want:  false,
+	}, {
+		frame: newTestARPFrame(t, layers.ARPReply, otherMAC, otherIP, zeroIP),
+		name:  "other_reply",
+		want:  false,
+	}, {
+		frame: newTestARPFrame(t, layers.ARPRequest, otherMAC, otherIP, target),
+		name:  "request_for_target",
+		want:  false,
+	}, {
+		frame: []byte{1, 2, 3},
+		name:  "garbage",
+		want:  false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, isARPConflict(tc.frame, ownMAC, target))
+		})
+	}
+}
@@ -1,5 +1,7 @@
 package dhcpd
 
+import "maps"
+
 const bitsPerWord = 64
 
 // bitSet is a sparse bitSet.  A nil *bitSet is an empty bitSet.
@@ -47,3 +49,14 @@ func (s *bitSet) set(n uint64, ok bool) {
 
 	s.words[wordIdx] = word
 }
+
+// clone returns a deep copy of s.
+func (s *bitSet) clone() (c *bitSet) {
+	if s == nil {
+		return nil
+	}
+
+	return &bitSet{
+		words: maps.Clone(s.words),
+	}
+}
@@ -3,7 +3,9 @@ package dhcpd
 import (
 	"fmt"
 	"net"
+	"net/http"
 	"net/netip"
+	"slices"
 	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
@@ -21,6 +23,15 @@ type ServerConfig struct {
 	// Register an HTTP handler
 	HTTPRegister aghhttp.RegisterFunc `yaml:"-"`
 
+	// RequestUser returns the name of the user that has made the HTTP request.
+	// It's used to log the actions performed on the devices.  It may be nil.
+	RequestUser func(r *http.Request) (name string) `yaml:"-"`
+
+	// OnPoolExhausted is called when the DHCPv4 server has no free addresses
+	// left to offer.  It's called with the leases locked, so it must not call
+	// the server.  It may be nil.
+	OnPoolExhausted func() `yaml:"-"`
+
 	Enabled       bool   `yaml:"enabled"`
 	InterfaceName string `yaml:"interface_name"`
 
@@ -35,6 +46,28 @@ type ServerConfig struct {
 	Conf4 V4ServerConf `yaml:"dhcpv4"`
 	Conf6 V6ServerConf `yaml:"dhcpv6"`
 
+	// DDNS is the configuration of the dynamic updates of an external DNS
+	// server with the hostnames of the leases.
+	DDNS DDNSConfig `yaml:"ddns"`
+
+	// MQTTBroker is the address of the MQTT broker to publish the changes of
+	// the DHCPv4 leases to, for example "192.0.2.1:1883".  If the port is
+	// omitted, 1883 is used.  If empty, the notifications are disabled.
+	MQTTBroker string `yaml:"mqtt_broker"`
+
+	// MQTTTopic is the topic of the lease notifications.  If empty,
+	// "adguardhome/dhcp/leases" is used.
+	MQTTTopic string `yaml:"mqtt_topic"`
+
+	// MQTTClientID is the MQTT client identifier.  If empty, "adguardhome" is
+	// used.
+	MQTTClientID string `yaml:"mqtt_client_id"`
+
+	// Relays are the network interfaces, the DHCPv4 messages of the clients
+	// on which are relayed to the upstream DHCP servers.  The bindings made by
+	// the servers are recorded, but can't be changed.
+	Relays []*RelayConfig `yaml:"relays"`
+
 	// WorkDir is used to store DHCP leases.
 	//
 	// Deprecated:  Remove it when migration of DHCP leases will not be needed.
@@ -61,6 +94,11 @@ type DHCPServer interface {
 	// UpdateStaticLease updates IP, hostname of the lease.
 	UpdateStaticLease(l *dhcpsvc.Lease) (err error)
 
+	// MakeStaticLease converts the dynamic lease with the IP address ip into a
+	// static one and returns a clone of the resulting lease.  If ip is not
+	// valid, the lease is looked up by mac instead.
+	MakeStaticLease(ip netip.Addr, mac net.HardwareAddr) (l *dhcpsvc.Lease, err error)
+
 	// FindMACbyIP returns a MAC address by the IP address of its lease, if
 	// there is one.
 	FindMACbyIP(ip netip.Addr) (mac net.HardwareAddr)
@@ -78,6 +116,14 @@ type DHCPServer interface {
 	// WriteDiskConfig6 - copy disk configuration
 	WriteDiskConfig6(c *V6ServerConf)
 
+	// ReloadFingerprints reloads the DHCP fingerprint database, if it's
+	// configured.
+	ReloadFingerprints() (err error)
+
+	// NAKCounts returns the numbers of the DHCPNAK messages sent by the server
+	// for each reason.
+	NAKCounts() (counts map[string]uint64)
+
 	// Start - start server
 	Start() (err error)
 	// Stop - stop server
@@ -101,12 +147,35 @@ type V4ServerConf struct {
 	RangeStart netip.Addr `yaml:"range_start" json:"range_start"`
 	RangeEnd   netip.Addr `yaml:"range_end" json:"range_end"`
 
+	// AdditionalRanges are the pools of IP addresses for dynamic leases used
+	// in addition to the one from RangeStart to RangeEnd.  The addresses are
+	// leased from the first pool with free addresses, starting with the main
+	// one.  The pools must be within the subnet and must not overlap.
+	AdditionalRanges []V4Range `yaml:"additional_ranges" json:"-"`
+
 	LeaseDuration uint32 `yaml:"lease_duration" json:"lease_duration"` // in seconds
 
 	// IP conflict detector: time (ms) to wait for ICMP reply
 	// 0: disable
 	ICMPTimeout uint32 `yaml:"icmp_timeout_msec" json:"-"`
 
+	// ConflictDetection is the method of detecting whether an address about
+	// to be leased is already used by another device.  It must be one of
+	// [ConflictDetectionPing], [ConflictDetectionARP], [ConflictDetectionBoth],
+	// or [ConflictDetectionNone].  An empty value means
+	// [ConflictDetectionPing].  The ICMPTimeout is used as the timeout for
+	// both methods.
+	ConflictDetection string `yaml:"conflict_detection" json:"-"`
+
+	// PresenceSweepInterval is the interval between the ICMP sweeps of the
+	// active dynamic leases, in seconds, which detect whether the clients are
+	// online.  0 disables the sweeps.
+	PresenceSweepInterval uint32 `yaml:"presence_sweep_interval_sec" json:"-"`
+
+	// PresenceSweepTimeout is the time to wait for an ICMP reply during the
+	// sweeps, in milliseconds.  0 means [defaultPresenceSweepTimeout].
+	PresenceSweepTimeout uint32 `yaml:"presence_sweep_timeout_msec" json:"-"`
+
 	// Custom Options.
 	//
 	// Option with arbitrary hexadecimal data:
@@ -117,7 +186,24 @@ type V4ServerConf struct {
 	//     DEC_CODE ip IP_ADDR
 	Options []string `yaml:"options" json:"-"`
 
-	ipRange *ipRange
+	// VendorSpecificOptions are the payloads of DHCP option 43 sent to the
+	// clients, which vendor class identifiers, DHCP option 60, start with the
+	// configured vendor classes.  If several vendor classes match, the longest
+	// one is used.
+	VendorSpecificOptions []VendorOption `yaml:"vendor_specific_options" json:"vendor_specific_options"`
+
+	// FingerprintDB is the path to the JSON file with the DHCP fingerprints
+	// used to detect the operating systems of the clients.  If empty, the
+	// detection is disabled.  The file is reloaded on SIGHUP.
+	FingerprintDB string `yaml:"fingerprint_db" json:"-"`
+
+	// ipRanges are the pools of IP addresses for dynamic leases.  The first
+	// one is the range from RangeStart to RangeEnd, and the rest are the
+	// AdditionalRanges in the same order.
+	ipRanges ipRanges
+
+	// vendorOpts are the parsed VendorSpecificOptions.
+	vendorOpts []*vendorOption
 
 	leaseTime  time.Duration // the time during which a dynamic lease is considered valid
 	dnsIPAddrs []netip.Addr  // IPv4 addresses to return to DHCP clients as DNS server addresses
@@ -133,8 +219,39 @@ type V4ServerConf struct {
 	// TODO(a.garipov): This is utter madness and must be refactored.  It just
 	// begs for deadlock bugs and other nastiness.
 	notify func(uint32)
+
+	// poolExhausted is called when there are no free addresses left in the
+	// range.  It may be nil.
+	poolExhausted func()
 }
 
+// V4Range is an inclusive range of IPv4 addresses for dynamic leases.
+type V4Range struct {
+	// Start is the first IP address of the range.
+	Start netip.Addr `yaml:"start"`
+
+	// End is the last IP address of the range.
+	End netip.Addr `yaml:"end"`
+}
+
+// Address conflict detection methods.
+const (
+	// ConflictDetectionPing means that the address is checked by sending an
+	// ICMP echo request.
+	ConflictDetectionPing = "ping"
+
+	// ConflictDetectionARP means that the address is checked by sending an
+	// ARP probe as described in RFC 5227.  It's only supported on Linux.
+	ConflictDetectionARP = "arp"
+
+	// ConflictDetectionBoth means that the address is checked using both
+	// methods.
+	ConflictDetectionBoth = "both"
+
+	// ConflictDetectionNone disables the address conflict detection.
+	ConflictDetectionNone = "none"
+)
+
 // errNilConfig is an error returned by validation method if the config is nil.
 const errNilConfig errors.Error = "nil config"
 
@@ -178,49 +295,123 @@ func (c *V4ServerConf) Validate() (err error) {
 	c.subnet = netip.PrefixFrom(gatewayIP, maskLen)
 	c.broadcastIP = aghnet.BroadcastFromPref(c.subnet)
 
-	rangeStart, err := ensureV4(c.RangeStart, "address")
+	mainRange, err := c.validateRange(V4Range{Start: c.RangeStart, End: c.RangeEnd}, gatewayIP)
 	if err != nil {
 		// Don't wrap the error since it's informative enough as is and there is
 		// an annotation deferred already.
 		return err
 	}
 
-	rangeEnd, err := ensureV4(c.RangeEnd, "address")
+	c.ipRanges = ipRanges{mainRange}
+	for i, ar := range c.AdditionalRanges {
+		var r *ipRange
+		r, err = c.validateRange(ar, gatewayIP)
+		if err != nil {
+			return fmt.Errorf("additional range at index %d: %w", i, err)
+		}
+
+		c.ipRanges = append(c.ipRanges, r)
+	}
+
+	err = validateRangesOverlap(append([]V4Range{{
+		Start: c.RangeStart,
+		End:   c.RangeEnd,
+	}}, c.AdditionalRanges...))
 	if err != nil {
 		// Don't wrap the error since it's informative enough as is and there is
 		// an annotation deferred already.
 		return err
 	}
 
-	c.ipRange, err = newIPRange(rangeStart.AsSlice(), rangeEnd.AsSlice())
+	switch c.ConflictDetection {
+	case
+		"",
+		ConflictDetectionPing,
+		ConflictDetectionARP,
+		ConflictDetectionBoth,
+		ConflictDetectionNone:
+		// Go on.
+	default:
+		return fmt.Errorf("conflict detection: unsupported value %q", c.ConflictDetection)
+	}
+
+	c.vendorOpts, err = parseVendorOptions(c.VendorSpecificOptions)
 	if err != nil {
 		// Don't wrap the error since it's informative enough as is and there is
 		// an annotation deferred already.
 		return err
 	}
 
-	if c.ipRange.contains(gatewayIP.AsSlice()) {
-		return fmt.Errorf("gateway ip %v in the ip range: %v-%v",
+	return nil
+}
+
+// validateRange returns the IP range for ar or an error if ar isn't a valid
+// range of addresses for dynamic leases within c.subnet.  c.subnet must be set.
+func (c *V4ServerConf) validateRange(ar V4Range, gatewayIP netip.Addr) (r *ipRange, err error) {
+	rangeStart, err := ensureV4(ar.Start, "address")
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+
+	rangeEnd, err := ensureV4(ar.End, "address")
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+
+	r, err = newIPRange(rangeStart.AsSlice(), rangeEnd.AsSlice())
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+
+	if r.contains(gatewayIP.AsSlice()) {
+		return nil, fmt.Errorf("gateway ip %v in the ip range: %v-%v",
 			gatewayIP,
-			c.RangeStart,
-			c.RangeEnd,
+			ar.Start,
+			ar.End,
 		)
 	}
 
 	if !c.subnet.Contains(rangeStart) {
-		return fmt.Errorf("range start %v is outside network %v",
-			c.RangeStart,
+		return nil, fmt.Errorf("range start %v is outside network %v",
+			ar.Start,
 			c.subnet,
 		)
 	}
 
 	if !c.subnet.Contains(rangeEnd) {
-		return fmt.Errorf("range end %v is outside network %v",
-			c.RangeEnd,
+		return nil, fmt.Errorf("range end %v is outside network %v",
+			ar.End,
 			c.subnet,
 		)
 	}
 
+	return r, nil
+}
+
+// validateRangesOverlap returns an error if any of the valid IPv4 ranges in
+// ranges overlap.
+func validateRangesOverlap(ranges []V4Range) (err error) {
+	sorted := slices.Clone(ranges)
+	slices.SortFunc(sorted, func(a, b V4Range) (res int) {
+		return a.Start.Unmap().Compare(b.Start.Unmap())
+	})
+
+	for i := 1; i < len(sorted); i++ {
+		prev, cur := sorted[i-1], sorted[i]
+		if prev.End.Unmap().Compare(cur.Start.Unmap()) >= 0 {
+			return fmt.Errorf(
+				"ip range %v-%v overlaps with %v-%v",
+				prev.Start,
+				prev.End,
+				cur.Start,
+				cur.End,
+			)
+		}
+	}
+
 	return nil
 }
 
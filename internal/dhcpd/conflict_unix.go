@@ -0,0 +1,102 @@
+//go:build darwin || freebsd || linux || openbsd
+
+package dhcpd
+
+import (
+	"fmt"
+	"net/netip"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+
+	//lint:ignore SA1019 See the TODO in go.mod.
+	"github.com/go-ping/ping"
+)
+
+// addrProber checks if an IP address is already used by another device.
+type addrProber interface {
+	// probe returns true if target replied within the timeout.
+	probe(target netip.Addr) (inUse bool, err error)
+}
+
+// icmpProber is the [addrProber] that sends ICMP echo requests.
+type icmpProber struct {
+	// timeout is the time to wait for the reply.
+	timeout time.Duration
+}
+
+// type check
+var _ addrProber = (*icmpProber)(nil)
+
+// probe implements the [addrProber] interface for *icmpProber.
+func (p *icmpProber) probe(target netip.Addr) (inUse bool, err error) {
+	pinger, err := ping.NewPinger(target.String())
+	if err != nil {
+		return false, fmt.Errorf("creating pinger: %w", err)
+	}
+
+	pinger.SetPrivileged(true)
+	pinger.Timeout = p.timeout
+	pinger.Count = 1
+	pinger.OnRecv = func(_ *ping.Packet) {
+		inUse = true
+	}
+
+	log.Debug("dhcpv4: sending icmp echo to %s", target)
+
+	err = pinger.Run()
+	if err != nil {
+		return false, fmt.Errorf("sending icmp echo: %w", err)
+	}
+
+	return inUse, nil
+}
+
+// initProbers sets the address conflict detection method and the probers
+// according to the configuration.  If ARP probes aren't supported on the
+// current platform, it falls back to [ConflictDetectionPing].
+func (s *v4Server) initProbers() {
+	timeout := time.Duration(s.conf.ICMPTimeout) * time.Millisecond
+
+	s.conflictDetection = s.conf.ConflictDetection
+	if s.conflictDetection == "" {
+		s.conflictDetection = ConflictDetectionPing
+	}
+
+	s.icmpProber = &icmpProber{
+		timeout: timeout,
+	}
+
+	if s.conflictDetection != ConflictDetectionARP && s.conflictDetection != ConflictDetectionBoth {
+		return
+	}
+
+	var ok bool
+	s.arpProber, ok = newARPProber(s.conf.InterfaceName, timeout)
+	if !ok {
+		log.Info(
+			"dhcpv4: warning: conflict detection %q is not supported on this platform; using %q",
+			s.conflictDetection,
+			ConflictDetectionPing,
+		)
+
+		s.conflictDetection = ConflictDetectionPing
+	}
+}
+
+// probeAddr returns true if target is reported to be in use by p.  kind is
+// used for logging.
+func probeAddr(p addrProber, kind string, target netip.Addr) (inUse bool) {
+	inUse, err := p.probe(target)
+	if err != nil {
+		log.Error("dhcpv4: %s probe for %s: %s", kind, target, err)
+
+		return false
+	}
+
+	if inUse {
+		log.Info("dhcpv4: ip conflict: %s is already used by another device (%s)", target, kind)
+	}
+
+	return inUse
+}
@@ -38,11 +38,41 @@ type dataLeases struct {
 
 // dbLease is the structure of stored lease.
 type dbLease struct {
-	Expiry   string     `json:"expires"`
-	IP       netip.Addr `json:"ip"`
-	Hostname string     `json:"hostname"`
-	HWAddr   string     `json:"mac"`
-	IsStatic bool       `json:"static"`
+	LeaseDuration *uint32    `json:"lease_duration,omitempty"`
+	Expiry        string     `json:"expires"`
+	IP            netip.Addr `json:"ip"`
+	Hostname      string     `json:"hostname"`
+	HWAddr        string     `json:"mac"`
+	OSName        string     `json:"os_name,omitempty"`
+	IsStatic      bool       `json:"static"`
+}
+
+// leaseDurationToSeconds converts the lease duration of a static lease into
+// its serialized form.  nil means the server's default lease duration and 0
+// means the infinite one.
+func leaseDurationToSeconds(d time.Duration) (sec *uint32) {
+	switch d {
+	case 0:
+		return nil
+	case dhcpsvc.LeaseDurationInfinite:
+		return new(uint32)
+	default:
+		s := uint32(d / time.Second)
+
+		return &s
+	}
+}
+
+// leaseDurationFromSeconds is the inverse of [leaseDurationToSeconds].
+func leaseDurationFromSeconds(sec *uint32) (d time.Duration) {
+	switch {
+	case sec == nil:
+		return 0
+	case *sec == 0:
+		return dhcpsvc.LeaseDurationInfinite
+	default:
+		return time.Duration(*sec) * time.Second
+	}
 }
 
 // fromLease converts *dhcpsvc.Lease to *dbLease.
@@ -57,11 +87,13 @@ func fromLease(l *dhcpsvc.Lease) (dl *dbLease) {
 	}
 
 	return &dbLease{
-		Expiry:   expiryStr,
-		Hostname: l.Hostname,
-		HWAddr:   l.HWAddr.String(),
-		IP:       l.IP,
-		IsStatic: l.IsStatic,
+		LeaseDuration: leaseDurationToSeconds(l.LeaseDuration),
+		Expiry:        expiryStr,
+		Hostname:      l.Hostname,
+		HWAddr:        l.HWAddr.String(),
+		IP:            l.IP,
+		OSName:        l.OSName,
+		IsStatic:      l.IsStatic,
 	}
 }
 
@@ -81,11 +113,13 @@ func (dl *dbLease) toLease() (l *dhcpsvc.Lease, err error) {
 	}
 
 	return &dhcpsvc.Lease{
-		Expiry:   expiry,
-		IP:       dl.IP,
-		Hostname: dl.Hostname,
-		HWAddr:   mac,
-		IsStatic: dl.IsStatic,
+		Expiry:        expiry,
+		IP:            dl.IP,
+		Hostname:      dl.Hostname,
+		HWAddr:        mac,
+		OSName:        dl.OSName,
+		LeaseDuration: leaseDurationFromSeconds(dl.LeaseDuration),
+		IsStatic:      dl.IsStatic,
 	}, nil
 }
 
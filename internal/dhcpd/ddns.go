@@ -0,0 +1,386 @@
+package dhcpd
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/dhcpsvc"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/miekg/dns"
+)
+
+// DDNSConfig is the configuration of the dynamic updates of an external
+// authoritative DNS server with the hostnames of the DHCPv4 leases as
+// described in RFC 2136.
+type DDNSConfig struct {
+	// Server is the address of the authoritative DNS server, for example
+	// "192.0.2.53:53".  If the port is omitted, 53 is used.  If empty, the
+	// updates are disabled.
+	Server string `yaml:"server"`
+
+	// Zone is the forward zone, in which the A records of the leases are
+	// updated, for example "home.example".
+	Zone string `yaml:"zone"`
+
+	// ReverseZone is the reverse zone, in which the PTR records of the leases
+	// are updated, for example "2.0.192.in-addr.arpa".  If empty, the PTR
+	// records aren't updated.
+	ReverseZone string `yaml:"reverse_zone"`
+
+	// TSIGKeyName is the name of the TSIG key used to sign the updates.  If
+	// empty, the updates aren't signed.
+	TSIGKeyName string `yaml:"tsig_key_name"`
+
+	// TSIGAlgorithm is the algorithm of the TSIG key, for example
+	// "hmac-sha256".  If empty, "hmac-sha256" is used.
+	TSIGAlgorithm string `yaml:"tsig_algorithm"`
+
+	// TSIGSecret is the base64-encoded secret of the TSIG key.
+	TSIGSecret string `yaml:"tsig_secret"`
+
+	// TTL is the TTL of the records, in seconds.  If zero, [defaultDDNSTTL]
+	// is used.
+	TTL uint32 `yaml:"ttl"`
+}
+
+// defaultDDNSTTL is the default TTL of the records, in seconds.
+const defaultDDNSTTL = 300
+
+// ddnsTimeout is the timeout of a single update exchange.
+const ddnsTimeout = 5 * time.Second
+
+// validate returns an error if c is not a valid configuration of the enabled
+// updates.
+func (c *DDNSConfig) validate() (err error) {
+	if c.Zone == "" {
+		return errors.Error("zone: empty value")
+	}
+
+	err = netutil.ValidateDomainName(strings.TrimSuffix(c.Zone, "."))
+	if err != nil {
+		return fmt.Errorf("zone: %w", err)
+	}
+
+	if c.ReverseZone != "" && !strings.HasSuffix(dns.Fqdn(c.ReverseZone), ".in-addr.arpa.") {
+		return fmt.Errorf("reverse_zone: %q is not an ipv4 reverse zone", c.ReverseZone)
+	}
+
+	if c.TSIGKeyName == "" {
+		return nil
+	}
+
+	_, err = base64.StdEncoding.DecodeString(c.TSIGSecret)
+	if err != nil {
+		return fmt.Errorf("tsig_secret: %w", err)
+	}
+
+	switch dns.Fqdn(c.tsigAlgorithm()) {
+	case dns.HmacSHA1, dns.HmacSHA224, dns.HmacSHA256, dns.HmacSHA384, dns.HmacSHA512:
+		return nil
+	default:
+		return fmt.Errorf("tsig_algorithm: unsupported algorithm %q", c.TSIGAlgorithm)
+	}
+}
+
+// tsigAlgorithm returns the TSIG algorithm of c.
+func (c *DDNSConfig) tsigAlgorithm() (alg string) {
+	if c.TSIGAlgorithm == "" {
+		return dns.HmacSHA256
+	}
+
+	return c.TSIGAlgorithm
+}
+
+// ddnsUpdater publishes the hostnames of the leases in an external
+// authoritative DNS server.
+type ddnsUpdater struct {
+	// client is used to send the updates.
+	client *dns.Client
+
+	// leases returns the current leases.
+	leases func() (leases []*dhcpsvc.Lease)
+
+	// mu serializes the synchronizations and protects published.
+	mu *sync.Mutex
+
+	// published maps the FQDNs of the hostnames to the addresses, which have
+	// been successfully published.
+	published map[string]netip.Addr
+
+	// server is the address of the authoritative DNS server.
+	server string
+
+	// zone is the forward zone as an FQDN.
+	zone string
+
+	// reverseZone is the reverse zone as an FQDN.  It's empty if the PTR
+	// records aren't updated.
+	reverseZone string
+
+	// tsigKeyName is the name of the TSIG key as an FQDN.  It's empty if the
+	// updates aren't signed.
+	tsigKeyName string
+
+	// tsigAlgorithm is the algorithm of the TSIG key as an FQDN.
+	tsigAlgorithm string
+
+	// ttl is the TTL of the records.
+	ttl uint32
+}
+
+// newDDNSUpdater returns a new updater for the leases returned by leases.
+// conf must be valid.
+func newDDNSUpdater(conf *DDNSConfig, leases func() (leases []*dhcpsvc.Lease)) (u *ddnsUpdater) {
+	server := conf.Server
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = netutil.JoinHostPort(server, 53)
+	}
+
+	u = &ddnsUpdater{
+		client: &dns.Client{
+			Net:     "udp",
+			Timeout: ddnsTimeout,
+		},
+		leases:        leases,
+		mu:            &sync.Mutex{},
+		published:     map[string]netip.Addr{},
+		server:        server,
+		zone:          dns.Fqdn(strings.ToLower(conf.Zone)),
+		tsigAlgorithm: dns.Fqdn(conf.tsigAlgorithm()),
+		ttl:           conf.TTL,
+	}
+
+	if conf.ReverseZone != "" {
+		u.reverseZone = dns.Fqdn(strings.ToLower(conf.ReverseZone))
+	}
+
+	if conf.TSIGKeyName != "" {
+		u.tsigKeyName = dns.Fqdn(conf.TSIGKeyName)
+		u.client.TsigSecret = map[string]string{u.tsigKeyName: conf.TSIGSecret}
+	}
+
+	if u.ttl == 0 {
+		u.ttl = defaultDDNSTTL
+	}
+
+	return u
+}
+
+// onLeaseChanged is the [OnLeaseChangedT] of u.  It synchronizes the records
+// in a separate goroutine, since the callback is called by the DHCP servers
+// while handling the requests.
+func (u *ddnsUpdater) onLeaseChanged(flags int) {
+	switch flags {
+	case LeaseChangedAdded, LeaseChangedAddedStatic, LeaseChangedRemovedStatic:
+		go u.syncAsync(false)
+	case LeaseChangedRemovedAll:
+		go u.syncAsync(true)
+	default:
+		// Go on.
+	}
+}
+
+// syncAsync synchronizes the records.  It is intended to be used as a
+// goroutine.
+func (u *ddnsUpdater) syncAsync(removeAll bool) {
+	defer log.OnPanic("dhcpd: ddns")
+
+	u.sync(removeAll)
+}
+
+// sync brings the published records in accordance with the current leases.
+// If removeAll is true, all the published records are removed instead.  The
+// records that failed to update are retried during the next synchronization.
+func (u *ddnsUpdater) sync(removeAll bool) {
+	wanted := map[string]netip.Addr{}
+	if !removeAll {
+		wanted = u.wantedRecords()
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for fqdn, addr := range u.published {
+		if wantAddr, ok := wanted[fqdn]; ok && wantAddr == addr {
+			continue
+		}
+
+		err := u.remove(fqdn, addr)
+		if err != nil {
+			log.Error("dhcpd: ddns: removing %s %s: %s", fqdn, addr, err)
+
+			continue
+		}
+
+		log.Debug("dhcpd: ddns: removed %s %s", fqdn, addr)
+
+		delete(u.published, fqdn)
+	}
+
+	for fqdn, addr := range wanted {
+		if pubAddr, ok := u.published[fqdn]; ok && pubAddr == addr {
+			continue
+		}
+
+		err := u.add(fqdn, addr)
+		if err != nil {
+			log.Error("dhcpd: ddns: adding %s %s: %s", fqdn, addr, err)
+
+			continue
+		}
+
+		log.Debug("dhcpd: ddns: added %s %s", fqdn, addr)
+
+		u.published[fqdn] = addr
+	}
+}
+
+// wantedRecords returns the records which should be published for the current
+// DHCPv4 leases with hostnames.
+func (u *ddnsUpdater) wantedRecords() (wanted map[string]netip.Addr) {
+	wanted = map[string]netip.Addr{}
+	for _, l := range u.leases() {
+		if l.Hostname == "" || !l.IP.Is4() {
+			continue
+		}
+
+		wanted[strings.ToLower(l.Hostname)+"."+u.zone] = l.IP
+	}
+
+	return wanted
+}
+
+// add publishes the A and the PTR records of addr for fqdn.  The previous
+// records of fqdn are replaced, so that repeated additions are idempotent.
+func (u *ddnsUpdater) add(fqdn string, addr netip.Addr) (err error) {
+	msg := &dns.Msg{}
+	msg.SetUpdate(u.zone)
+	msg.RemoveRRset([]dns.RR{&dns.A{
+		Hdr: dns.RR_Header{Name: fqdn, Rrtype: dns.TypeA},
+	}})
+	msg.Insert([]dns.RR{u.newA(fqdn, addr)})
+
+	err = u.exchange(msg)
+	if err != nil {
+		return fmt.Errorf("updating a: %w", err)
+	}
+
+	ptr, ok := u.newPTR(fqdn, addr)
+	if !ok {
+		return nil
+	}
+
+	msg = &dns.Msg{}
+	msg.SetUpdate(u.reverseZone)
+	msg.RemoveRRset([]dns.RR{&dns.PTR{
+		Hdr: dns.RR_Header{Name: ptr.Hdr.Name, Rrtype: dns.TypePTR},
+	}})
+	msg.Insert([]dns.RR{ptr})
+
+	err = u.exchange(msg)
+	if err != nil {
+		return fmt.Errorf("updating ptr: %w", err)
+	}
+
+	return nil
+}
+
+// remove deletes the A and the PTR records of addr for fqdn.  Only the
+// records pointing to addr are deleted, so that the records added by other
+// parties aren't affected.
+func (u *ddnsUpdater) remove(fqdn string, addr netip.Addr) (err error) {
+	msg := &dns.Msg{}
+	msg.SetUpdate(u.zone)
+	msg.Remove([]dns.RR{u.newA(fqdn, addr)})
+
+	err = u.exchange(msg)
+	if err != nil {
+		return fmt.Errorf("updating a: %w", err)
+	}
+
+	ptr, ok := u.newPTR(fqdn, addr)
+	if !ok {
+		return nil
+	}
+
+	msg = &dns.Msg{}
+	msg.SetUpdate(u.reverseZone)
+	msg.Remove([]dns.RR{ptr})
+
+	err = u.exchange(msg)
+	if err != nil {
+		return fmt.Errorf("updating ptr: %w", err)
+	}
+
+	return nil
+}
+
+// newA returns the A record of addr for fqdn.
+func (u *ddnsUpdater) newA(fqdn string, addr netip.Addr) (rr *dns.A) {
+	return &dns.A{
+		Hdr: dns.RR_Header{
+			Name:   fqdn,
+			Rrtype: dns.TypeA,
+			Class:  dns.ClassINET,
+			Ttl:    u.ttl,
+		},
+		A: addr.AsSlice(),
+	}
+}
+
+// newPTR returns the PTR record of addr pointing to fqdn.  ok is false if the
+// PTR records aren't updated or addr doesn't belong to the reverse zone.
+func (u *ddnsUpdater) newPTR(fqdn string, addr netip.Addr) (rr *dns.PTR, ok bool) {
+	if u.reverseZone == "" {
+		return nil, false
+	}
+
+	arpa, err := netutil.IPToReversedAddr(addr.AsSlice())
+	if err != nil {
+		log.Debug("dhcpd: ddns: reversing %s: %s", addr, err)
+
+		return nil, false
+	}
+
+	arpa = dns.Fqdn(arpa)
+	if !dns.IsSubDomain(u.reverseZone, arpa) {
+		log.Debug("dhcpd: ddns: %s is not in reverse zone %s", addr, u.reverseZone)
+
+		return nil, false
+	}
+
+	return &dns.PTR{
+		Hdr: dns.RR_Header{
+			Name:   arpa,
+			Rrtype: dns.TypePTR,
+			Class:  dns.ClassINET,
+			Ttl:    u.ttl,
+		},
+		Ptr: fqdn,
+	}, true
+}
+
+// exchange signs msg, if needed, sends it to the server, and checks the
+// response code.
+func (u *ddnsUpdater) exchange(msg *dns.Msg) (err error) {
+	if u.tsigKeyName != "" {
+		msg.SetTsig(u.tsigKeyName, u.tsigAlgorithm, 300, time.Now().Unix())
+	}
+
+	resp, _, err := u.client.Exchange(msg, u.server)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	} else if resp.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("server responded with %s", dns.RcodeToString[resp.Rcode])
+	}
+
+	return nil
+}
@@ -0,0 +1,303 @@
+package dhcpd
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/dhcpsvc"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testTimeout is the common timeout for tests.
+const testTimeout = 1 * time.Second
+
+// Common DDNS values for tests.
+const (
+	testDDNSZone        = "home.example."
+	testDDNSReverseZone = "2.0.192.in-addr.arpa."
+	testDDNSKeyName     = "ddns-key."
+)
+
+// testDDNSSecret is the TSIG secret for tests.
+var testDDNSSecret = base64.StdEncoding.EncodeToString([]byte("ddns-secret-for-tests"))
+
+// testUpdateReceiver is a mock authoritative DNS server accepting the dynamic
+// updates.
+type testUpdateReceiver struct {
+	// mu protects updates.
+	mu *sync.Mutex
+
+	// updates are the summaries of the update sections of the received
+	// messages.
+	updates []string
+
+	// refuse makes the receiver respond with REFUSED.
+	refuse atomic.Bool
+}
+
+// startUpdateReceiver starts a mock update receiver requiring the updates to
+// be signed with the test TSIG key and returns it with its address.
+func startUpdateReceiver(t *testing.T) (r *testUpdateReceiver, addr string) {
+	t.Helper()
+
+	r = &testUpdateReceiver{
+		mu: &sync.Mutex{},
+	}
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	started := make(chan struct{})
+	srv := &dns.Server{
+		PacketConn:        pc,
+		Handler:           dns.HandlerFunc(r.handle),
+		TsigSecret:        map[string]string{testDDNSKeyName: testDDNSSecret},
+		NotifyStartedFunc: func() { close(started) },
+		// The default function rejects the messages with the UPDATE opcode.
+		MsgAcceptFunc: func(_ dns.Header) (action dns.MsgAcceptAction) {
+			return dns.MsgAccept
+		},
+	}
+
+	go func() { _ = srv.ActivateAndServe() }()
+	testutil.CleanupAndRequireSuccess(t, srv.Shutdown)
+
+	_, _ = testutil.RequireReceive(t, started, testTimeout)
+
+	return r, pc.LocalAddr().String()
+}
+
+// handle is the [dns.HandlerFunc] of r.
+func (r *testUpdateReceiver) handle(w dns.ResponseWriter, req *dns.Msg) {
+	pt := testutil.PanicT{}
+
+	require.Equal(pt, dns.OpcodeUpdate, req.Opcode)
+	require.NoError(pt, w.TsigStatus())
+	require.Len(pt, req.Question, 1)
+
+	resp := (&dns.Msg{}).SetReply(req)
+	resp.SetTsig(testDDNSKeyName, dns.HmacSHA256, 300, time.Now().Unix())
+
+	if r.refuse.Load() {
+		resp.Rcode = dns.RcodeRefused
+	} else {
+		zone := req.Question[0].Name
+
+		r.mu.Lock()
+		for _, rr := range req.Ns {
+			r.updates = append(r.updates, summarizeUpdate(zone, rr))
+		}
+		r.mu.Unlock()
+	}
+
+	require.NoError(pt, w.WriteMsg(resp))
+}
+
+// flush returns the updates received since the previous call.
+func (r *testUpdateReceiver) flush() (updates []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	updates, r.updates = r.updates, nil
+
+	return updates
+}
+
+// summarizeUpdate returns a human-readable summary of rr from the update
+// section of a message for zone.
+func summarizeUpdate(zone string, rr dns.RR) (s string) {
+	hdr := rr.Header()
+
+	var data string
+	switch rr := rr.(type) {
+	case *dns.A:
+		// The deletions of the RRsets have no data.
+		if rr.A != nil {
+			data = rr.A.String()
+		}
+	case *dns.PTR:
+		data = rr.Ptr
+	}
+
+	s = fmt.Sprintf(
+		"%s %s %s %s %s",
+		zone,
+		dns.ClassToString[hdr.Class],
+		dns.TypeToString[hdr.Rrtype],
+		hdr.Name,
+		data,
+	)
+
+	return strings.TrimSpace(s)
+}
+
+func TestDDNSUpdater_sync(t *testing.T) {
+	r, addr := startUpdateReceiver(t)
+
+	var leases []*dhcpsvc.Lease
+	u := newDDNSUpdater(&DDNSConfig{
+		Server:      addr,
+		Zone:        "home.example",
+		ReverseZone: "2.0.192.in-addr.arpa",
+		TSIGKeyName: "ddns-key",
+		TSIGSecret:  testDDNSSecret,
+		TTL:         60,
+	}, func() (ls []*dhcpsvc.Lease) { return leases })
+
+	leases = []*dhcpsvc.Lease{{
+		Hostname: "host1",
+		IP:       netip.MustParseAddr("192.0.2.10"),
+	}, {
+		Hostname: "Host2",
+		IP:       netip.MustParseAddr("192.0.2.11"),
+	}, {
+		Hostname: "",
+		IP:       netip.MustParseAddr("192.0.2.12"),
+	}, {
+		Hostname: "outside",
+		IP:       netip.MustParseAddr("198.51.100.1"),
+	}}
+
+	t.Run("add", func(t *testing.T) {
+		u.sync(false)
+
+		assert.ElementsMatch(t, []string{
+			testDDNSZone + " ANY A host1.home.example.",
+			testDDNSZone + " IN A host1.home.example. 192.0.2.10",
+			testDDNSReverseZone + " ANY PTR 10.2.0.192.in-addr.arpa.",
+			testDDNSReverseZone + " IN PTR 10.2.0.192.in-addr.arpa. host1.home.example.",
+			testDDNSZone + " ANY A host2.home.example.",
+			testDDNSZone + " IN A host2.home.example. 192.0.2.11",
+			testDDNSReverseZone + " ANY PTR 11.2.0.192.in-addr.arpa.",
+			testDDNSReverseZone + " IN PTR 11.2.0.192.in-addr.arpa. host2.home.example.",
+			testDDNSZone + " ANY A outside.home.example.",
+			testDDNSZone + " IN A outside.home.example. 198.51.100.1",
+		}, r.flush())
+	})
+
+	t.Run("idempotent", func(t *testing.T) {
+		u.sync(false)
+
+		assert.Empty(t, r.flush())
+	})
+
+	t.Run("change", func(t *testing.T) {
+		leases = []*dhcpsvc.Lease{{
+			Hostname: "Host2",
+			IP:       netip.MustParseAddr("192.0.2.13"),
+		}, {
+			Hostname: "outside",
+			IP:       netip.MustParseAddr("198.51.100.1"),
+		}}
+
+		u.sync(false)
+
+		assert.ElementsMatch(t, []string{
+			testDDNSZone + " NONE A host1.home.example. 192.0.2.10",
+			testDDNSReverseZone + " NONE PTR 10.2.0.192.in-addr.arpa. host1.home.example.",
+			testDDNSZone + " NONE A host2.home.example. 192.0.2.11",
+			testDDNSReverseZone + " NONE PTR 11.2.0.192.in-addr.arpa. host2.home.example.",
+			testDDNSZone + " ANY A host2.home.example.",
+			testDDNSZone + " IN A host2.home.example. 192.0.2.13",
+			testDDNSReverseZone + " ANY PTR 13.2.0.192.in-addr.arpa.",
+			testDDNSReverseZone + " IN PTR 13.2.0.192.in-addr.arpa. host2.home.example.",
+		}, r.flush())
+	})
+
+	t.Run("refused", func(t *testing.T) {
+		leases = nil
+
+		r.refuse.Store(true)
+		u.sync(false)
+		r.refuse.Store(false)
+
+		assert.Empty(t, r.flush())
+
+		// The failed removals are retried.
+		u.sync(false)
+
+		assert.ElementsMatch(t, []string{
+			testDDNSZone + " NONE A host2.home.example. 192.0.2.13",
+			testDDNSReverseZone + " NONE PTR 13.2.0.192.in-addr.arpa. host2.home.example.",
+			testDDNSZone + " NONE A outside.home.example. 198.51.100.1",
+		}, r.flush())
+	})
+
+	t.Run("remove_all", func(t *testing.T) {
+		leases = []*dhcpsvc.Lease{{
+			Hostname: "host3",
+			IP:       netip.MustParseAddr("192.0.2.14"),
+		}}
+
+		u.sync(false)
+		require.Len(t, r.flush(), 4)
+
+		u.sync(true)
+
+		assert.ElementsMatch(t, []string{
+			testDDNSZone + " NONE A host3.home.example. 192.0.2.14",
+			testDDNSReverseZone + " NONE PTR 14.2.0.192.in-addr.arpa. host3.home.example.",
+		}, r.flush())
+	})
+}
+
+func TestDDNSConfig_validate(t *testing.T) {
+	testCases := []struct {
+		conf       *DDNSConfig
+		name       string
+		wantErrMsg string
+	}{{
+		conf: &DDNSConfig{
+			Zone:        "home.example",
+			ReverseZone: "2.0.192.in-addr.arpa",
+			TSIGKeyName: "key",
+			TSIGSecret:  testDDNSSecret,
+		},
+		name:       "valid",
+		wantErrMsg: "",
+	}, {
+		conf:       &DDNSConfig{},
+		name:       "no_zone",
+		wantErrMsg: "zone: empty value",
+	}, {
+		conf: &DDNSConfig{
+			Zone:        "home.example",
+			ReverseZone: "home.example",
+		},
+		name:       "bad_reverse_zone",
+		wantErrMsg: `reverse_zone: "home.example" is not an ipv4 reverse zone`,
+	}, {
+		conf: &DDNSConfig{
+			Zone:        "home.example",
+			TSIGKeyName: "key",
+			TSIGSecret:  "!!!",
+		},
+		name:       "bad_secret",
+		wantErrMsg: "tsig_secret: illegal base64 data at input byte 0",
+	}, {
+		conf: &DDNSConfig{
+			Zone:          "home.example",
+			TSIGKeyName:   "key",
+			TSIGSecret:    testDDNSSecret,
+			TSIGAlgorithm: "hmac-md5",
+		},
+		name:       "bad_algorithm",
+		wantErrMsg: `tsig_algorithm: unsupported algorithm "hmac-md5"`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, tc.conf.validate())
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package dhcpd
+
+import (
+	"sync"
+	"time"
+)
+
+// deviceActionIvl is the minimum interval between two actions, such as wakes
+// and pings, targeting the same device.
+const deviceActionIvl = 5 * time.Second
+
+// deviceActionLimiter limits the rate of the actions targeting the same device.
+// It's safe for concurrent use.
+type deviceActionLimiter struct {
+	// mu protects last.
+	mu *sync.Mutex
+
+	// last contains the times of the last actions by the target.
+	last map[string]time.Time
+
+	// ivl is the minimum interval between the actions targeting the same
+	// device.
+	ivl time.Duration
+}
+
+// newDeviceActionLimiter returns a new properly initialized
+// *deviceActionLimiter.
+func newDeviceActionLimiter(ivl time.Duration) (l *deviceActionLimiter) {
+	return &deviceActionLimiter{
+		mu:   &sync.Mutex{},
+		last: map[string]time.Time{},
+		ivl:  ivl,
+	}
+}
+
+// allow returns true if an action targeting target is allowed at now, and
+// records it if so.
+func (l *deviceActionLimiter) allow(target string, now time.Time) (ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for k, t := range l.last {
+		if now.Sub(t) >= l.ivl {
+			delete(l.last, k)
+		}
+	}
+
+	if _, ok = l.last[target]; ok {
+		return false
+	}
+
+	l.last[target] = now
+
+	return true
+}
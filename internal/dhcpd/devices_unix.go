@@ -0,0 +1,257 @@
+//go:build darwin || freebsd || linux || openbsd
+
+package dhcpd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/AdGuardHome/internal/dhcpsvc"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+
+	//lint:ignore SA1019 See the TODO in go.mod.
+	"github.com/go-ping/ping"
+)
+
+const (
+	// wolPort is the UDP port the Wake-on-LAN magic packets are sent to.
+	wolPort = 9
+
+	// pingTimeout is the maximum duration of a reachability check.
+	pingTimeout = 1 * time.Second
+)
+
+// errNoRawSockets is returned when the process has no permissions to open raw
+// sockets required to send ICMP packets.
+const errNoRawSockets errors.Error = "no permission to open raw sockets; " +
+	"run as root or grant the CAP_NET_RAW capability"
+
+// newMagicPacket returns the Wake-on-LAN magic packet for the device with the
+// given MAC address, which is six bytes of 0xFF followed by sixteen repetitions
+// of mac.  mac must be an EUI-48 address.
+func newMagicPacket(mac net.HardwareAddr) (pkt []byte, err error) {
+	const (
+		syncLen = 6
+		macReps = 16
+	)
+
+	if len(mac) != 6 {
+		return nil, fmt.Errorf("bad mac address %q: only eui-48 addresses are supported", mac)
+	}
+
+	pkt = make([]byte, 0, syncLen+macReps*len(mac))
+	pkt = append(pkt, bytes.Repeat([]byte{0xFF}, syncLen)...)
+	for range macReps {
+		pkt = append(pkt, mac...)
+	}
+
+	return pkt, nil
+}
+
+// sendMagicPacket sends the Wake-on-LAN magic packet for mac to the broadcast
+// address bcast.
+func sendMagicPacket(bcast netip.Addr, mac net.HardwareAddr) (err error) {
+	pkt, err := newMagicPacket(mac)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	raddr := net.UDPAddrFromAddrPort(netip.AddrPortFrom(bcast, wolPort))
+	conn, err := net.DialUDP("udp4", nil, raddr)
+	if err != nil {
+		return fmt.Errorf("dialing %s: %w", raddr, err)
+	}
+	defer func() { err = errors.WithDeferred(err, conn.Close()) }()
+
+	_, err = conn.Write(pkt)
+	if err != nil {
+		return fmt.Errorf("writing magic packet: %w", err)
+	}
+
+	return nil
+}
+
+// pingAddr checks the reachability of ip by sending an ICMP echo request.  rtt
+// is the round-trip time of the reply, if reachable is true.
+func pingAddr(ip netip.Addr) (rtt time.Duration, reachable bool, err error) {
+	pinger, err := ping.NewPinger(ip.String())
+	if err != nil {
+		return 0, false, fmt.Errorf("creating pinger: %w", err)
+	}
+
+	pinger.SetPrivileged(true)
+	pinger.Timeout = pingTimeout
+	pinger.Count = 1
+
+	err = pinger.Run()
+	if errors.Is(err, os.ErrPermission) {
+		return 0, false, errNoRawSockets
+	} else if err != nil {
+		return 0, false, fmt.Errorf("sending icmp echo: %w", err)
+	}
+
+	st := pinger.Statistics()
+	if st.PacketsRecv == 0 {
+		return 0, false, nil
+	}
+
+	return st.MinRtt, true, nil
+}
+
+// hasLeaseWith returns true if any of the current leases satisfies cond.
+func (s *server) hasLeaseWith(cond func(l *dhcpsvc.Lease) (ok bool)) (ok bool) {
+	return slices.ContainsFunc(s.Leases(), cond)
+}
+
+// auditLog logs the action requested by the user of r.
+func (s *server) auditLog(r *http.Request, format string, args ...any) {
+	user := ""
+	if s.conf.RequestUser != nil {
+		user = s.conf.RequestUser(r)
+	}
+
+	msg := fmt.Sprintf(format, args...)
+	log.Info("dhcpd: audit: user %q from %s: %s", user, r.RemoteAddr, msg)
+}
+
+// wakeReq is the request for the POST /control/dhcp/wake HTTP API.
+type wakeReq struct {
+	// MAC is the hardware address of the device to wake.
+	MAC string `json:"mac"`
+
+	// AllowAny, if true, allows waking devices that have no DHCP lease.
+	AllowAny bool `json:"allow_any"`
+}
+
+// handleDHCPWake is the handler for the POST /control/dhcp/wake HTTP API.
+func (s *server) handleDHCPWake(w http.ResponseWriter, r *http.Request) {
+	req := &wakeReq{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding json: %s", err)
+
+		return
+	}
+
+	mac, err := net.ParseMAC(req.MAC)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "parsing mac: %s", err)
+
+		return
+	}
+
+	if !req.AllowAny && !s.hasLeaseWith(func(l *dhcpsvc.Lease) (ok bool) {
+		return bytes.Equal(l.HWAddr, mac)
+	}) {
+		aghhttp.Error(r, w, http.StatusBadRequest, "no lease for mac %s", mac)
+
+		return
+	}
+
+	v4, ok := s.srv4.(*v4Server)
+	if !ok || !v4.conf.broadcastIP.IsValid() {
+		aghhttp.Error(r, w, http.StatusBadRequest, "dhcpv4 server is not configured")
+
+		return
+	}
+
+	if !s.actionLimiter.allow(mac.String(), time.Now()) {
+		aghhttp.Error(r, w, http.StatusTooManyRequests, "too many requests for %s", mac)
+
+		return
+	}
+
+	s.auditLog(r, "sending wake-on-lan packet to %s", mac)
+
+	err = sendMagicPacket(v4.conf.broadcastIP, mac)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "waking %s: %s", mac, err)
+
+		return
+	}
+
+	aghhttp.OK(w)
+}
+
+// pingReq is the request for the POST /control/dhcp/ping HTTP API.
+type pingReq struct {
+	// IP is the address of the device to check.
+	IP netip.Addr `json:"ip"`
+
+	// AllowAny, if true, allows checking devices that have no DHCP lease.
+	AllowAny bool `json:"allow_any"`
+}
+
+// pingResp is the response for the POST /control/dhcp/ping HTTP API.
+type pingResp struct {
+	// RTT is the round-trip time in milliseconds.  It's only set if the device
+	// is reachable.
+	RTT float64 `json:"rtt_ms,omitempty"`
+
+	// Reachable is true if the device has replied in time.
+	Reachable bool `json:"reachable"`
+}
+
+// handleDHCPPing is the handler for the POST /control/dhcp/ping HTTP API.
+func (s *server) handleDHCPPing(w http.ResponseWriter, r *http.Request) {
+	req := &pingReq{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding json: %s", err)
+
+		return
+	}
+
+	if !req.IP.IsValid() {
+		aghhttp.Error(r, w, http.StatusBadRequest, "invalid ip")
+
+		return
+	}
+
+	ip := req.IP.Unmap()
+	if !req.AllowAny && !s.hasLeaseWith(func(l *dhcpsvc.Lease) (ok bool) {
+		return l.IP == ip
+	}) {
+		aghhttp.Error(r, w, http.StatusBadRequest, "no lease for ip %s", ip)
+
+		return
+	}
+
+	if !s.actionLimiter.allow(ip.String(), time.Now()) {
+		aghhttp.Error(r, w, http.StatusTooManyRequests, "too many requests for %s", ip)
+
+		return
+	}
+
+	s.auditLog(r, "pinging %s", ip)
+
+	rtt, reachable, err := pingAddr(ip)
+	if errors.Is(err, errNoRawSockets) {
+		aghhttp.Error(r, w, http.StatusServiceUnavailable, "pinging %s: %s", ip, err)
+
+		return
+	} else if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "pinging %s: %s", ip, err)
+
+		return
+	}
+
+	resp := &pingResp{
+		Reachable: reachable,
+	}
+	if reachable {
+		resp.RTT = float64(rtt) / float64(time.Millisecond)
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
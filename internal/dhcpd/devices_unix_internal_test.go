@@ -0,0 +1,112 @@
+//go:build darwin || freebsd || linux || openbsd
+
+package dhcpd
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewMagicPacket(t *testing.T) {
+	mac := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+
+	pkt, err := newMagicPacket(mac)
+	require.NoError(t, err)
+	require.Len(t, pkt, 6+16*len(mac))
+
+	assert.Equal(t, bytes.Repeat([]byte{0xFF}, 6), pkt[:6])
+	assert.Equal(t, bytes.Repeat(mac, 16), pkt[6:])
+
+	t.Run("eui64", func(t *testing.T) {
+		eui64 := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77}
+
+		_, err = newMagicPacket(eui64)
+		testutil.AssertErrorMsg(
+			t,
+			`bad mac address "00:11:22:33:44:55:66:77": only eui-48 addresses are supported`,
+			err,
+		)
+	})
+}
+
+func TestDeviceActionLimiter_allow(t *testing.T) {
+	const (
+		target = "00:11:22:33:44:55"
+		other  = "192.168.10.10"
+	)
+
+	l := newDeviceActionLimiter(deviceActionIvl)
+	now := time.Now()
+
+	assert.True(t, l.allow(target, now))
+	assert.False(t, l.allow(target, now.Add(deviceActionIvl/2)))
+	assert.True(t, l.allow(other, now.Add(deviceActionIvl/2)))
+	assert.True(t, l.allow(target, now.Add(deviceActionIvl)))
+}
+
+func TestServer_deviceHandlers(t *testing.T) {
+	const knownMAC = "aa:aa:aa:aa:aa:aa"
+
+	s, err := Create(&ServerConfig{
+		Enabled:        true,
+		Conf4:          *defaultV4ServerConf(),
+		DataDir:        t.TempDir(),
+		ConfigModified: func() {},
+	})
+	require.NoError(t, err)
+
+	w := handleLease(t, &leaseStatic{
+		HWAddr:   knownMAC,
+		IP:       netip.MustParseAddr("192.168.10.10"),
+		Hostname: "nas",
+	}, s.handleDHCPAddStaticLease)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	testCases := []struct {
+		name     string
+		handler  http.HandlerFunc
+		body     string
+		wantBody string
+	}{{
+		name:     "wake_bad_mac",
+		handler:  s.handleDHCPWake,
+		body:     `{"mac":"bad"}`,
+		wantBody: "parsing mac: address bad: invalid MAC address\n",
+	}, {
+		name:     "wake_unknown_mac",
+		handler:  s.handleDHCPWake,
+		body:     `{"mac":"bb:bb:bb:bb:bb:bb"}`,
+		wantBody: "no lease for mac bb:bb:bb:bb:bb:bb\n",
+	}, {
+		name:     "ping_invalid_ip",
+		handler:  s.handleDHCPPing,
+		body:     `{}`,
+		wantBody: "invalid ip\n",
+	}, {
+		name:     "ping_unknown_ip",
+		handler:  s.handleDHCPPing,
+		body:     `{"ip":"192.168.10.20"}`,
+		wantBody: "no lease for ip 192.168.10.20\n",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tc.body))
+			rw := httptest.NewRecorder()
+
+			tc.handler(rw, r)
+			assert.Equal(t, http.StatusBadRequest, rw.Code)
+			assert.Equal(t, tc.wantBody, rw.Body.String())
+		})
+	}
+}
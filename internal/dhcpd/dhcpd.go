@@ -3,9 +3,11 @@ package dhcpd
 
 import (
 	"fmt"
+	"io"
 	"net"
 	"net/netip"
 	"path/filepath"
+	"slices"
 	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/dhcpsvc"
@@ -78,10 +80,19 @@ type Interface interface {
 	// due to an assumption that a DHCP client must always have an IP address.
 	HostByIP(ip netip.Addr) (host string)
 
-	// IPByHost returns the IP address of the DHCP client with the given
-	// hostname.  The address will be netip.Addr{} if there is no such client,
-	// due to an assumption that a DHCP client must always have an IP address.
-	IPByHost(host string) (ip netip.Addr)
+	// IPByHost returns the IP addresses of the DHCP client with the given
+	// hostname, the IPv4 one first, if any.  ips is empty if there is no such
+	// client.
+	IPByHost(host string) (ips []netip.Addr)
+
+	// ImportDnsmasqHosts adds the static leases described in r in the format
+	// of the dnsmasq dhcp-host option.  added is the number of added leases.
+	// lineErrs contains the errors for the lines that couldn't be imported.
+	ImportDnsmasqHosts(r io.Reader) (added int, lineErrs []*LineError, err error)
+
+	// ReloadFingerprints reloads the database of the DHCP fingerprints used to
+	// detect the operating systems of the clients.
+	ReloadFingerprints() (err error)
 
 	WriteDiskConfig(c *ServerConfig)
 }
@@ -95,8 +106,25 @@ type server struct {
 	// just put the config values into Server.
 	conf *ServerConfig
 
+	// actionLimiter limits the rate of the wakes and pings of the devices.
+	actionLimiter *deviceActionLimiter
+
 	// Called when the leases DB is modified
 	onLeaseChanged []OnLeaseChangedT
+
+	// mqtt publishes the changes of the leases to an MQTT broker.  It's nil if
+	// the notifications are disabled.
+	mqtt *mqttNotifier
+
+	// relayLeases are the bindings made by the upstream DHCP servers for the
+	// relayed clients.
+	relayLeases *relayLeases
+
+	// relays relay the DHCPv4 messages to the upstream DHCP servers.
+	relays []*v4Relay
+
+	// now returns the current time.  It's replaced in tests.
+	now func() (t time.Time)
 }
 
 // type check
@@ -109,15 +137,28 @@ func Create(conf *ServerConfig) (s *server, err error) {
 		conf: &ServerConfig{
 			ConfigModified: conf.ConfigModified,
 
-			HTTPRegister: conf.HTTPRegister,
+			HTTPRegister:    conf.HTTPRegister,
+			RequestUser:     conf.RequestUser,
+			OnPoolExhausted: conf.OnPoolExhausted,
 
 			Enabled:       conf.Enabled,
 			InterfaceName: conf.InterfaceName,
 
 			LocalDomainName: conf.LocalDomainName,
 
+			DDNS: conf.DDNS,
+
+			MQTTBroker:   conf.MQTTBroker,
+			MQTTTopic:    conf.MQTTTopic,
+			MQTTClientID: conf.MQTTClientID,
+
+			Relays: conf.Relays,
+
 			dbFilePath: filepath.Join(conf.DataDir, dataFilename),
 		},
+		actionLimiter: newDeviceActionLimiter(deviceActionIvl),
+		relayLeases:   newRelayLeases(),
+		now:           time.Now,
 	}
 
 	// TODO(e.burkov):  Don't register handlers, see TODO on
@@ -137,6 +178,27 @@ func Create(conf *ServerConfig) (s *server, err error) {
 		return nil, fmt.Errorf("neither dhcpv4 nor dhcpv6 srv is configured")
 	}
 
+	if s.conf.DDNS.Server != "" {
+		err = s.conf.DDNS.validate()
+		if err != nil {
+			return nil, fmt.Errorf("ddns: %w", err)
+		}
+
+		u := newDDNSUpdater(&s.conf.DDNS, s.servedLeases)
+		s.onLeaseChanged = append(s.onLeaseChanged, u.onLeaseChanged)
+	}
+
+	if s.conf.MQTTBroker != "" {
+		s.mqtt = newMQTTNotifier(s.conf, s.servedLeases)
+		s.onLeaseChanged = append(s.onLeaseChanged, s.mqtt.onLeaseChanged)
+	}
+
+	err = s.initRelays()
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+
 	// Migrate leases db if needed.
 	err = migrateDB(conf)
 	if err != nil {
@@ -154,6 +216,25 @@ func Create(conf *ServerConfig) (s *server, err error) {
 	return s, nil
 }
 
+// initRelays validates the relays configuration and creates the relays.
+func (s *server) initRelays() (err error) {
+	servedIface := ""
+	if s.conf.Enabled {
+		servedIface = s.conf.InterfaceName
+	}
+
+	err = validateRelays(s.conf.Relays, servedIface)
+	if err != nil {
+		return fmt.Errorf("relays: %w", err)
+	}
+
+	for _, r := range s.conf.Relays {
+		s.relays = append(s.relays, newV4Relay(r, s.relayLeases, s.notify))
+	}
+
+	return nil
+}
+
 // setServers updates DHCPv4 and DHCPv6 servers created from the provided
 // configuration conf.  It returns the status of both the DHCPv4 and the DHCPv6
 // servers, which is always false for corresponding server on any error.
@@ -161,6 +242,7 @@ func (s *server) setServers(conf *ServerConfig) (v4Enabled, v6Enabled bool, err
 	v4conf := conf.Conf4
 	v4conf.InterfaceName = s.conf.InterfaceName
 	v4conf.notify = s.onNotify
+	v4conf.poolExhausted = s.conf.OnPoolExhausted
 	v4conf.Enabled = s.conf.Enabled && v4conf.RangeStart.IsValid()
 
 	s.srv4, err = v4Create(&v4conf)
@@ -232,11 +314,21 @@ func (s *server) WriteDiskConfig(c *ServerConfig) {
 	c.Enabled = s.conf.Enabled
 	c.InterfaceName = s.conf.InterfaceName
 	c.LocalDomainName = s.conf.LocalDomainName
+	c.DDNS = s.conf.DDNS
+	c.MQTTBroker = s.conf.MQTTBroker
+	c.MQTTTopic = s.conf.MQTTTopic
+	c.MQTTClientID = s.conf.MQTTClientID
+	c.Relays = s.conf.Relays
 
 	s.srv4.WriteDiskConfig4(&c.Conf4)
 	s.srv6.WriteDiskConfig6(&c.Conf6)
 }
 
+// ReloadFingerprints implements the [Interface] for *server.
+func (s *server) ReloadFingerprints() (err error) {
+	return s.srv4.ReloadFingerprints()
+}
+
 // Start will listen on port 67 and serve DHCP requests.
 func (s *server) Start() (err error) {
 	err = s.srv4.Start()
@@ -249,11 +341,34 @@ func (s *server) Start() (err error) {
 		return err
 	}
 
+	if s.mqtt != nil {
+		s.mqtt.start()
+	}
+
+	for _, r := range s.relays {
+		err = r.start()
+		if err != nil {
+			// Don't wrap the error since it's informative enough as is.
+			return err
+		}
+	}
+
 	return nil
 }
 
 // Stop closes the listening UDP socket
 func (s *server) Stop() (err error) {
+	if s.mqtt != nil {
+		s.mqtt.stop()
+	}
+
+	for _, r := range s.relays {
+		err = r.stop()
+		if err != nil {
+			return err
+		}
+	}
+
 	err = s.srv4.Stop()
 	if err != nil {
 		return err
@@ -267,8 +382,14 @@ func (s *server) Stop() (err error) {
 	return nil
 }
 
-// Leases returns the list of active DHCP leases.
+// Leases returns the list of active DHCP leases, including the ones of the
+// relayed clients.
 func (s *server) Leases() (leases []*dhcpsvc.Lease) {
+	return append(s.servedLeases(), s.relayLeases.all()...)
+}
+
+// servedLeases returns the list of active DHCP leases served by AdGuard Home.
+func (s *server) servedLeases() (leases []*dhcpsvc.Lease) {
 	return append(s.srv4.GetLeases(LeasesAll), s.srv6.GetLeases(LeasesAll)...)
 }
 
@@ -276,28 +397,44 @@ func (s *server) Leases() (leases []*dhcpsvc.Lease) {
 // one.
 func (s *server) MACByIP(ip netip.Addr) (mac net.HardwareAddr) {
 	if ip.Is4() {
-		return s.srv4.FindMACbyIP(ip)
+		mac = s.srv4.FindMACbyIP(ip)
+		if mac == nil {
+			if l := s.relayLeases.find(ip); l != nil {
+				mac = slices.Clone(l.HWAddr)
+			}
+		}
+
+		return mac
 	}
 
 	return s.srv6.FindMACbyIP(ip)
 }
 
 // HostByIP implements the [Interface] interface for *server.
-//
-// TODO(e.burkov):  Implement this method for DHCPv6.
 func (s *server) HostByIP(ip netip.Addr) (host string) {
-	if ip.Is4() {
-		return s.srv4.HostByIP(ip)
+	if !ip.Is4() {
+		return s.srv6.HostByIP(ip)
+	}
+
+	host = s.srv4.HostByIP(ip)
+	if host == "" {
+		if l := s.relayLeases.find(ip); l != nil {
+			host = l.Hostname
+		}
 	}
 
-	return ""
+	return host
 }
 
 // IPByHost implements the [Interface] interface for *server.
-//
-// TODO(e.burkov):  Implement this method for DHCPv6.
-func (s *server) IPByHost(host string) (ip netip.Addr) {
-	return s.srv4.IPByHost(host)
+func (s *server) IPByHost(host string) (ips []netip.Addr) {
+	for _, srv := range []DHCPServer{s.srv4, s.srv6} {
+		if ip := srv.IPByHost(host); ip.IsValid() {
+			ips = append(ips, ip)
+		}
+	}
+
+	return ips
 }
 
 // AddStaticLease - add static v4 lease
@@ -137,3 +137,64 @@ func cloneUDPAddr(a *net.UDPAddr) (clone *net.UDPAddr) {
 		Zone: a.Zone,
 	}
 }
+
+func TestServer_IPByHost(t *testing.T) {
+	var err error
+	s := server{}
+
+	s.srv4, err = v4Create(&V4ServerConf{
+		Enabled:    true,
+		RangeStart: netip.MustParseAddr("192.168.10.100"),
+		RangeEnd:   netip.MustParseAddr("192.168.10.200"),
+		GatewayIP:  netip.MustParseAddr("192.168.10.1"),
+		SubnetMask: netip.MustParseAddr("255.255.255.0"),
+		notify:     testNotify,
+	})
+	require.NoError(t, err)
+
+	s.srv6, err = v6Create(V6ServerConf{
+		Enabled:    true,
+		RangeStart: net.ParseIP("2001::1"),
+		notify:     testNotify,
+	})
+	require.NoError(t, err)
+
+	ipv4 := netip.MustParseAddr("192.168.10.101")
+	ipv6 := netip.MustParseAddr("2001::2")
+	ipv6Only := netip.MustParseAddr("2001::3")
+
+	err = s.srv4.AddStaticLease(&dhcpsvc.Lease{
+		Hostname: "dual",
+		HWAddr:   net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA},
+		IP:       ipv4,
+	})
+	require.NoError(t, err)
+
+	err = s.srv6.AddStaticLease(&dhcpsvc.Lease{
+		Hostname: "dual",
+		HWAddr:   net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA},
+		IP:       ipv6,
+	})
+	require.NoError(t, err)
+
+	v6Lease := &dhcpsvc.Lease{
+		Hostname: "v6-only",
+		HWAddr:   net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xBB},
+		IP:       ipv6Only,
+	}
+	err = s.srv6.AddStaticLease(v6Lease)
+	require.NoError(t, err)
+
+	assert.Equal(t, []netip.Addr{ipv4, ipv6}, s.IPByHost("dual"))
+	assert.Equal(t, []netip.Addr{ipv6Only}, s.IPByHost("v6-only"))
+	assert.Empty(t, s.IPByHost("unknown"))
+
+	assert.Equal(t, "dual", s.HostByIP(ipv6))
+	assert.Equal(t, "v6-only", s.HostByIP(ipv6Only))
+
+	err = s.srv6.RemoveStaticLease(v6Lease)
+	require.NoError(t, err)
+
+	assert.Empty(t, s.IPByHost("v6-only"))
+	assert.Empty(t, s.HostByIP(ipv6Only))
+}
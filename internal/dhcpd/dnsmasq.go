@@ -0,0 +1,238 @@
+package dhcpd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/dhcpsvc"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// dnsmasqHostKey is the name of the dnsmasq option describing a static lease.
+const dnsmasqHostKey = "dhcp-host"
+
+// LineError is an error related to a particular line of an imported file.
+type LineError struct {
+	// Err is the underlying error.
+	Err error
+
+	// Line is the number of the line, starting from 1.
+	Line int
+}
+
+// type check
+var _ errors.Wrapper = (*LineError)(nil)
+
+// Error implements the [error] interface for *LineError.
+func (e *LineError) Error() (msg string) {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+}
+
+// Unwrap implements the [errors.Wrapper] interface for *LineError.
+func (e *LineError) Unwrap() (unwrapped error) {
+	return e.Err
+}
+
+// parseDnsmasqHosts parses the static leases from r, which should contain
+// either the dnsmasq configuration with dhcp-host options or a dhcp-hostsfile.
+// Empty lines, comments, other options, and hosts with the "ignore" keyword are
+// skipped.  lineErrs contains the errors of the lines that couldn't be parsed,
+// err is only returned if r couldn't be read.
+func parseDnsmasqHosts(r io.Reader) (hosts []*importedLease, lineErrs []*LineError, err error) {
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		key, val, ok := strings.Cut(line, "=")
+		if !ok {
+			if !strings.Contains(line, ",") {
+				// An option without a value, such as dhcp-authoritative.
+				continue
+			}
+
+			// Lines of dhcp-hostsfile have no option name.
+			val = line
+		} else if strings.TrimSpace(key) != dnsmasqHostKey {
+			continue
+		}
+
+		var l *dhcpsvc.Lease
+		l, err = parseDnsmasqHost(val)
+		if err != nil {
+			lineErrs = append(lineErrs, &LineError{Err: err, Line: n})
+		} else if l != nil {
+			hosts = append(hosts, &importedLease{lease: l, line: n})
+		}
+	}
+
+	err = s.Err()
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading: %w", err)
+	}
+
+	return hosts, lineErrs, nil
+}
+
+// parseDnsmasqHost parses the value of the dhcp-host option.  l is nil if the
+// host should be ignored.
+func parseDnsmasqHost(val string) (l *dhcpsvc.Lease, err error) {
+	l = &dhcpsvc.Lease{
+		IsStatic: true,
+	}
+
+	for _, f := range strings.Split(val, ",") {
+		f = strings.TrimSpace(f)
+
+		var ignore bool
+		ignore, err = setDnsmasqHostField(l, f)
+		if err != nil {
+			// Don't wrap the error since it's informative enough as is.
+			return nil, err
+		} else if ignore {
+			return nil, nil
+		}
+	}
+
+	if l.HWAddr == nil {
+		return nil, errors.Error("no mac address")
+	} else if !l.IP.IsValid() {
+		return nil, errors.Error("no ip address")
+	}
+
+	return l, nil
+}
+
+// setDnsmasqHostField sets the value of a single field of the dhcp-host option
+// into l.  ignore is true if the host should be ignored.
+func setDnsmasqHostField(l *dhcpsvc.Lease, f string) (ignore bool, err error) {
+	switch {
+	case f == "":
+		return false, errors.Error("empty field")
+	case f == "ignore":
+		return true, nil
+	case
+		strings.HasPrefix(f, "set:"),
+		strings.HasPrefix(f, "tag:"),
+		strings.HasPrefix(f, "id:"):
+		// Tags and client identifiers don't affect the static leases.
+		log.Debug("dhcpd: dnsmasq: skipping field %q", f)
+
+		return false, nil
+	}
+
+	if ip, ipErr := netip.ParseAddr(strings.Trim(f, "[]")); ipErr == nil {
+		if l.IP.IsValid() {
+			return false, fmt.Errorf("unexpected ip address %q", f)
+		}
+
+		l.IP = ip.Unmap()
+
+		return false, nil
+	}
+
+	if mac, macErr := net.ParseMAC(f); macErr == nil {
+		if l.HWAddr != nil {
+			return false, fmt.Errorf("unexpected mac address %q: multiple macs aren't supported", f)
+		}
+
+		l.HWAddr = mac
+
+		return false, nil
+	}
+
+	if strings.Contains(f, ":") {
+		return false, fmt.Errorf("bad mac address %q", f)
+	}
+
+	if d, ok, durErr := parseDnsmasqLeaseTime(f); ok {
+		if durErr != nil {
+			return false, fmt.Errorf("bad lease time %q: %w", f, durErr)
+		}
+
+		l.LeaseDuration = d
+
+		return false, nil
+	}
+
+	if l.Hostname != "" {
+		return false, fmt.Errorf("unexpected field %q", f)
+	}
+
+	l.Hostname = f
+
+	return false, nil
+}
+
+// parseDnsmasqLeaseTime parses the dnsmasq lease time, which is either
+// "infinite" or a number of seconds with an optional unit suffix.  ok is false
+// if s doesn't look like a lease time.
+func parseDnsmasqLeaseTime(s string) (d time.Duration, ok bool, err error) {
+	if s == "infinite" {
+		return dhcpsvc.LeaseDurationInfinite, true, nil
+	}
+
+	unit := time.Second
+	numStr := s
+	switch s[len(s)-1] {
+	case 's', 'S':
+		numStr = s[:len(s)-1]
+	case 'm', 'M':
+		unit, numStr = time.Minute, s[:len(s)-1]
+	case 'h', 'H':
+		unit, numStr = time.Hour, s[:len(s)-1]
+	case 'd', 'D':
+		unit, numStr = 24*time.Hour, s[:len(s)-1]
+	case 'w', 'W':
+		unit, numStr = 7*24*time.Hour, s[:len(s)-1]
+	}
+
+	if numStr == "" || strings.Trim(numStr, "0123456789") != "" {
+		return 0, false, nil
+	}
+
+	n, err := strconv.ParseUint(numStr, 10, 32)
+	if err != nil {
+		return 0, true, err
+	} else if n == 0 {
+		return 0, true, errors.Error("must be positive")
+	}
+
+	// Check the value before multiplying to avoid an overflow.
+	if n >= uint64(dhcpsvc.LeaseDurationInfinite/unit) {
+		return 0, true, errors.Error("too large")
+	}
+
+	return time.Duration(n) * unit, true, nil
+}
+
+// ImportDnsmasqHosts adds the static leases described in r in the dnsmasq
+// format, see [parseDnsmasqHosts].  added is the number of added leases.
+// lineErrs contains the errors for the lines that couldn't be parsed or whose
+// leases couldn't be added.  err is only returned if r couldn't be read.
+func (s *server) ImportDnsmasqHosts(r io.Reader) (added int, lineErrs []*LineError, err error) {
+	results, err := s.ImportStaticLeases(r, LeasesFormatDnsmasq)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return 0, nil, err
+	}
+
+	for _, res := range results {
+		if res.Err != nil {
+			lineErrs = append(lineErrs, &LineError{Err: res.Err, Line: res.Line})
+		} else {
+			added++
+		}
+	}
+
+	return added, lineErrs, nil
+}
@@ -0,0 +1,166 @@
+package dhcpd
+
+import (
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/dhcpsvc"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testDnsmasqConf is a representative dnsmasq configuration for tests.  The
+// addresses are chosen to fit [defaultV4ServerConf].
+const testDnsmasqConf = `# Static leases imported from dnsmasq.
+domain=lan
+dhcp-authoritative
+
+dhcp-host=aa:aa:aa:aa:aa:01,192.168.10.10,printer,infinite
+dhcp-host=aa:aa:aa:aa:aa:02,set:red,192.168.10.11,laptop,12h
+dhcp-host=aa:aa:aa:aa:aa:03,ignore
+dhcp-host=aa:aa:aa:aa:aa:04,192.168.10.1,gateway
+dhcp-host=aa:aa:aa:aa:aa:05,10.0.0.5,outside
+dhcp-host=aa:aa:*:*:*:*,192.168.10.12
+dhcp-host=aa:aa:aa:aa:aa:06,tablet
+aa:aa:aa:aa:aa:07,192.168.10.13
+dhcp-host=aa:aa:aa:aa:aa:08,192.168.10.14,phone,0
+`
+
+func TestParseDnsmasqHosts(t *testing.T) {
+	hosts, lineErrs, err := parseDnsmasqHosts(strings.NewReader(testDnsmasqConf))
+	require.NoError(t, err)
+
+	wantHosts := []*importedLease{{
+		lease: &dhcpsvc.Lease{
+			IP:            netip.MustParseAddr("192.168.10.10"),
+			Hostname:      "printer",
+			HWAddr:        net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0x01},
+			LeaseDuration: dhcpsvc.LeaseDurationInfinite,
+			IsStatic:      true,
+		},
+		line: 5,
+	}, {
+		lease: &dhcpsvc.Lease{
+			IP:            netip.MustParseAddr("192.168.10.11"),
+			Hostname:      "laptop",
+			HWAddr:        net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0x02},
+			LeaseDuration: 12 * time.Hour,
+			IsStatic:      true,
+		},
+		line: 6,
+	}, {
+		lease: &dhcpsvc.Lease{
+			IP:       netip.MustParseAddr("192.168.10.1"),
+			Hostname: "gateway",
+			HWAddr:   net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0x04},
+			IsStatic: true,
+		},
+		line: 8,
+	}, {
+		lease: &dhcpsvc.Lease{
+			IP:       netip.MustParseAddr("10.0.0.5"),
+			Hostname: "outside",
+			HWAddr:   net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0x05},
+			IsStatic: true,
+		},
+		line: 9,
+	}, {
+		lease: &dhcpsvc.Lease{
+			IP:       netip.MustParseAddr("192.168.10.13"),
+			HWAddr:   net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0x07},
+			IsStatic: true,
+		},
+		line: 12,
+	}}
+	assert.Equal(t, wantHosts, hosts)
+
+	require.Len(t, lineErrs, 3)
+
+	testutil.AssertErrorMsg(t, `line 10: bad mac address "aa:aa:*:*:*:*"`, lineErrs[0])
+	testutil.AssertErrorMsg(t, `line 11: no ip address`, lineErrs[1])
+	testutil.AssertErrorMsg(t, `line 13: bad lease time "0": must be positive`, lineErrs[2])
+}
+
+func TestParseDnsmasqLeaseTime(t *testing.T) {
+	testCases := []struct {
+		name       string
+		in         string
+		wantErrMsg string
+		want       time.Duration
+		wantOK     bool
+	}{{
+		name:       "seconds",
+		in:         "3600",
+		wantErrMsg: "",
+		want:       time.Hour,
+		wantOK:     true,
+	}, {
+		name:       "seconds_suffix",
+		in:         "90s",
+		wantErrMsg: "",
+		want:       90 * time.Second,
+		wantOK:     true,
+	}, {
+		name:       "minutes",
+		in:         "45m",
+		wantErrMsg: "",
+		want:       45 * time.Minute,
+		wantOK:     true,
+	}, {
+		name:       "days",
+		in:         "2d",
+		wantErrMsg: "",
+		want:       48 * time.Hour,
+		wantOK:     true,
+	}, {
+		name:       "weeks",
+		in:         "1w",
+		wantErrMsg: "",
+		want:       7 * 24 * time.Hour,
+		wantOK:     true,
+	}, {
+		name:       "infinite",
+		in:         "infinite",
+		wantErrMsg: "",
+		want:       dhcpsvc.LeaseDurationInfinite,
+		wantOK:     true,
+	}, {
+		name:       "zero",
+		in:         "0",
+		wantErrMsg: "must be positive",
+		want:       0,
+		wantOK:     true,
+	}, {
+		name:       "too_large",
+		in:         "100000000w",
+		wantErrMsg: "too large",
+		want:       0,
+		wantOK:     true,
+	}, {
+		name:       "hostname",
+		in:         "host",
+		wantErrMsg: "",
+		want:       0,
+		wantOK:     false,
+	}, {
+		name:       "unit_only",
+		in:         "h",
+		wantErrMsg: "",
+		want:       0,
+		wantOK:     false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d, ok, err := parseDnsmasqLeaseTime(tc.in)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+
+			assert.Equal(t, tc.wantOK, ok)
+			assert.Equal(t, tc.want, d)
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package dhcpd
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// fingerprintEntry is an entry of the DHCP fingerprint database file.  The
+// database file is a JSON array of such entries.
+type fingerprintEntry struct {
+	// OSName is the name of the operating system the fingerprint belongs to.
+	// It must not be empty.
+	OSName string `json:"os_name"`
+
+	// ParameterRequestList is the comma-separated list of the decimal option
+	// codes of the parameter request list option (55) in the order they're
+	// sent by the client, for example "1,3,6,15,119,252".
+	ParameterRequestList string `json:"parameter_request_list"`
+
+	// VendorClass is the value of the vendor class identifier option (60).  It
+	// may be empty.
+	VendorClass string `json:"vendor_class"`
+}
+
+// fingerprintDB is a database of DHCP fingerprints.  It's immutable and thus
+// safe for concurrent use.  A nil *fingerprintDB is an empty database.
+type fingerprintDB struct {
+	// osNames maps the hashes of the fingerprints to the names of the
+	// operating systems.
+	osNames map[uint64]string
+}
+
+// loadFingerprintDB loads the DHCP fingerprint database from the JSON file at
+// path.
+func loadFingerprintDB(path string) (db *fingerprintDB, err error) {
+	// #nosec G304 -- Trust the path from the configuration file.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading: %w", err)
+	}
+
+	var entries []*fingerprintEntry
+	err = json.Unmarshal(data, &entries)
+	if err != nil {
+		return nil, fmt.Errorf("decoding: %w", err)
+	}
+
+	db = &fingerprintDB{
+		osNames: make(map[uint64]string, len(entries)),
+	}
+
+	for i, e := range entries {
+		err = db.add(e)
+		if err != nil {
+			return nil, fmt.Errorf("entry at index %d: %w", i, err)
+		}
+	}
+
+	return db, nil
+}
+
+// add adds the fingerprint described by e to db.
+func (db *fingerprintDB) add(e *fingerprintEntry) (err error) {
+	if e == nil {
+		return errors.ErrNoValue
+	} else if e.OSName == "" {
+		return fmt.Errorf("os_name: %w", errors.ErrEmptyValue)
+	}
+
+	prl, err := parseParameterRequestList(e.ParameterRequestList)
+	if err != nil {
+		return fmt.Errorf("parameter_request_list: %w", err)
+	}
+
+	db.osNames[fingerprintHash(prl, e.VendorClass)] = e.OSName
+
+	return nil
+}
+
+// parseParameterRequestList parses the comma-separated list of decimal option
+// codes into the wire form of the parameter request list option.
+func parseParameterRequestList(s string) (prl []byte, err error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	for _, f := range strings.Split(s, ",") {
+		var code uint64
+		code, err = strconv.ParseUint(strings.TrimSpace(f), 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("bad option code %q: %w", f, err)
+		}
+
+		prl = append(prl, byte(code))
+	}
+
+	return prl, nil
+}
+
+// fingerprintHash returns the hash of the fingerprint consisting of the
+// parameter request list prl in the wire form and the vendor class identifier
+// vendor.
+func fingerprintHash(prl []byte, vendor string) (h uint64) {
+	f := fnv.New64a()
+
+	// Write the length first to separate the list from the vendor class.  The
+	// length of an option is never greater than 255.
+	_, _ = f.Write([]byte{byte(len(prl))})
+	_, _ = f.Write(prl)
+	_, _ = f.Write([]byte(vendor))
+
+	return f.Sum64()
+}
+
+// osName returns the name of the operating system of the client that sent req
+// or an empty string if its fingerprint isn't known.
+func (db *fingerprintDB) osName(req *dhcpv4.DHCPv4) (name string) {
+	if db == nil {
+		return ""
+	}
+
+	prl := req.Options.Get(dhcpv4.OptionParameterRequestList)
+	if len(prl) == 0 {
+		// Clients that don't request any parameters can't be distinguished.
+		return ""
+	}
+
+	return db.osNames[fingerprintHash(prl, req.ClassIdentifier())]
+}
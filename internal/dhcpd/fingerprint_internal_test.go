@@ -0,0 +1,129 @@
+package dhcpd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testFingerprintsJSON is a test DHCP fingerprint database.
+const testFingerprintsJSON = `[{
+	"os_name": "Windows",
+	"parameter_request_list": "1,3,6,15,31,33,43,44,46,47,119,121,249,252",
+	"vendor_class": "MSFT 5.0"
+}, {
+	"os_name": "Linux",
+	"parameter_request_list": "1, 28, 2, 3, 15, 6, 119, 12, 44, 47, 26, 121, 42",
+	"vendor_class": ""
+}]`
+
+// writeTestFile writes data into a temporary file and returns its path.
+func writeTestFile(t *testing.T, data string) (path string) {
+	t.Helper()
+
+	path = filepath.Join(t.TempDir(), "fingerprints.json")
+	err := os.WriteFile(path, []byte(data), 0o600)
+	require.NoError(t, err)
+
+	return path
+}
+
+func TestFingerprintDB_osName(t *testing.T) {
+	db, err := loadFingerprintDB(writeTestFile(t, testFingerprintsJSON))
+	require.NoError(t, err)
+
+	var windowsPRL []dhcpv4.OptionCode
+	for _, c := range []byte{1, 3, 6, 15, 31, 33, 43, 44, 46, 47, 119, 121, 249, 252} {
+		windowsPRL = append(windowsPRL, dhcpv4.GenericOptionCode(c))
+	}
+
+	testCases := []struct {
+		name   string
+		opts   []dhcpv4.Modifier
+		wantOS string
+	}{{
+		name: "windows",
+		opts: []dhcpv4.Modifier{
+			dhcpv4.WithOption(dhcpv4.OptParameterRequestList(windowsPRL...)),
+			dhcpv4.WithOption(dhcpv4.OptClassIdentifier("MSFT 5.0")),
+		},
+		wantOS: "Windows",
+	}, {
+		name: "other_vendor",
+		opts: []dhcpv4.Modifier{
+			dhcpv4.WithOption(dhcpv4.OptParameterRequestList(windowsPRL...)),
+			dhcpv4.WithOption(dhcpv4.OptClassIdentifier("android-dhcp-13")),
+		},
+		wantOS: "",
+	}, {
+		name: "other_order",
+		opts: []dhcpv4.Modifier{
+			dhcpv4.WithOption(dhcpv4.OptParameterRequestList(windowsPRL[1:]...)),
+			dhcpv4.WithOption(dhcpv4.OptClassIdentifier("MSFT 5.0")),
+		},
+		wantOS: "",
+	}, {
+		name:   "no_prl",
+		opts:   []dhcpv4.Modifier{dhcpv4.WithOption(dhcpv4.OptClassIdentifier("MSFT 5.0"))},
+		wantOS: "",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var req *dhcpv4.DHCPv4
+			req, err = dhcpv4.New(tc.opts...)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantOS, db.osName(req))
+		})
+	}
+
+	t.Run("nil_db", func(t *testing.T) {
+		var nilDB *fingerprintDB
+
+		req, reqErr := dhcpv4.New(
+			dhcpv4.WithOption(dhcpv4.OptParameterRequestList(windowsPRL...)),
+			dhcpv4.WithOption(dhcpv4.OptClassIdentifier("MSFT 5.0")),
+		)
+		require.NoError(t, reqErr)
+
+		assert.Empty(t, nilDB.osName(req))
+	})
+}
+
+func TestLoadFingerprintDB_errors(t *testing.T) {
+	testCases := []struct {
+		name       string
+		data       string
+		wantErrMsg string
+	}{{
+		name:       "empty_os_name",
+		data:       `[{"os_name":"","parameter_request_list":"1,3"}]`,
+		wantErrMsg: "entry at index 0: os_name: empty value",
+	}, {
+		name:       "null_entry",
+		data:       `[{"os_name":"Linux","parameter_request_list":"1,3"},null]`,
+		wantErrMsg: "entry at index 1: no value",
+	}, {
+		name: "bad_code",
+		data: `[{"os_name":"Linux","parameter_request_list":"1,256"}]`,
+		wantErrMsg: `entry at index 0: parameter_request_list: bad option code "256": ` +
+			`strconv.ParseUint: parsing "256": value out of range`,
+	}, {
+		name:       "bad_json",
+		data:       `{}`,
+		wantErrMsg: "decoding: json: cannot unmarshal object into Go value of type []*dhcpd.fingerprintEntry",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := loadFingerprintDB(writeTestFile(t, tc.data))
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
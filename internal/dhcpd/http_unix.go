@@ -3,6 +3,7 @@
 package dhcpd
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -11,6 +12,7 @@ import (
 	"net/netip"
 	"os"
 	"slices"
+	"strings"
 	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghalg"
@@ -18,6 +20,7 @@ import (
 	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
 	"github.com/AdguardTeam/AdGuardHome/internal/dhcpsvc"
 	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/httphdr"
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/AdguardTeam/golibs/netutil"
 )
@@ -28,6 +31,10 @@ type v4ServerConfJSON struct {
 	RangeStart    netip.Addr `json:"range_start"`
 	RangeEnd      netip.Addr `json:"range_end"`
 	LeaseDuration uint32     `json:"lease_duration"`
+
+	// VendorSpecificOptions are the payloads of DHCP option 43 for the vendor
+	// classes.  If nil, the current ones are kept.
+	VendorSpecificOptions []VendorOption `json:"vendor_specific_options"`
 }
 
 func (j *v4ServerConfJSON) toServerConf() *V4ServerConf {
@@ -41,6 +48,8 @@ func (j *v4ServerConfJSON) toServerConf() *V4ServerConf {
 		RangeStart:    j.RangeStart,
 		RangeEnd:      j.RangeEnd,
 		LeaseDuration: j.LeaseDuration,
+
+		VendorSpecificOptions: j.VendorSpecificOptions,
 	}
 }
 
@@ -67,14 +76,26 @@ type dhcpStatusResponse struct {
 	V6           V6ServerConf    `json:"v6"`
 	Leases       []*leaseDynamic `json:"leases"`
 	StaticLeases []*leaseStatic  `json:"static_leases"`
-	Enabled      bool            `json:"enabled"`
+
+	// NAKCounts are the numbers of the DHCPNAK messages sent by the DHCPv4
+	// server for each reason.
+	NAKCounts map[string]uint64 `json:"nak_counts"`
+
+	Enabled bool `json:"enabled"`
 }
 
 // leaseStatic is the JSON form of static DHCP lease.
 type leaseStatic struct {
-	HWAddr   string     `json:"mac"`
-	IP       netip.Addr `json:"ip"`
-	Hostname string     `json:"hostname"`
+	// LeaseDuration is the lease time in seconds.  nil means the server's
+	// default lease time and 0 means the infinite one.
+	LeaseDuration *uint32    `json:"lease_duration,omitempty"`
+	HWAddr        string     `json:"mac"`
+	IP            netip.Addr `json:"ip"`
+	Hostname      string     `json:"hostname"`
+
+	// OSName is the detected operating system of the client.  It's only set in
+	// responses.
+	OSName string `json:"os_name,omitempty"`
 }
 
 // leasesToStatic converts list of leases to their JSON form.
@@ -83,9 +104,11 @@ func leasesToStatic(leases []*dhcpsvc.Lease) (static []*leaseStatic) {
 
 	for i, l := range leases {
 		static[i] = &leaseStatic{
-			HWAddr:   l.HWAddr.String(),
-			IP:       l.IP,
-			Hostname: l.Hostname,
+			LeaseDuration: leaseDurationToSeconds(l.LeaseDuration),
+			HWAddr:        l.HWAddr.String(),
+			IP:            l.IP,
+			Hostname:      l.Hostname,
+			OSName:        l.OSName,
 		}
 	}
 
@@ -100,10 +123,11 @@ func (l *leaseStatic) toLease() (lease *dhcpsvc.Lease, err error) {
 	}
 
 	return &dhcpsvc.Lease{
-		HWAddr:   addr,
-		IP:       l.IP,
-		Hostname: l.Hostname,
-		IsStatic: true,
+		HWAddr:        addr,
+		IP:            l.IP,
+		Hostname:      l.Hostname,
+		LeaseDuration: leaseDurationFromSeconds(l.LeaseDuration),
+		IsStatic:      true,
 	}, nil
 }
 
@@ -113,22 +137,56 @@ type leaseDynamic struct {
 	IP       netip.Addr `json:"ip"`
 	Hostname string     `json:"hostname"`
 	Expiry   string     `json:"expires"`
+	OSName   string     `json:"os_name,omitempty"`
+
+	// RenewalTime is the time at which the client should renew the lease
+	// (T1), in RFC 3339 format.
+	RenewalTime string `json:"renewal_time"`
+
+	// RebindingTime is the time at which the client should rebind the lease
+	// (T2), in RFC 3339 format.
+	RebindingTime string `json:"rebinding_time"`
+
+	// State is the renewal state of the lease.
+	State dhcpsvc.LeaseState `json:"state"`
+
+	// RemainingSec is the number of seconds until the lease expires.  It's
+	// zero if the lease has already expired.
+	RemainingSec int64 `json:"remaining_sec"`
+
+	// Online is true if the client has replied to the latest presence probe.
+	// It's always false if the presence sweep is disabled.
+	Online bool `json:"online"`
 }
 
-// leasesToDynamic converts list of leases to their JSON form.
-func leasesToDynamic(leases []*dhcpsvc.Lease) (dynamic []*leaseDynamic) {
+// leasesToDynamic converts list of leases to their JSON form.  dur returns the
+// duration for which a lease has been granted, now is the current time.
+func leasesToDynamic(
+	leases []*dhcpsvc.Lease,
+	dur func(l *dhcpsvc.Lease) (d time.Duration),
+	now time.Time,
+) (dynamic []*leaseDynamic) {
 	dynamic = make([]*leaseDynamic, len(leases))
 
 	for i, l := range leases {
+		d := dur(l)
+		t1, t2 := l.RenewalTimes(d)
+
 		dynamic[i] = &leaseDynamic{
 			HWAddr:   l.HWAddr.String(),
 			IP:       l.IP,
 			Hostname: l.Hostname,
+			OSName:   l.OSName,
 			// The front-end is waiting for RFC 3999 format of the time
 			// value.
 			//
 			// See https://github.com/AdguardTeam/AdGuardHome/issues/2692.
-			Expiry: l.Expiry.Format(time.RFC3339),
+			Expiry:        l.Expiry.Format(time.RFC3339),
+			RenewalTime:   t1.Format(time.RFC3339),
+			RebindingTime: t2.Format(time.RFC3339),
+			State:         l.State(d, now),
+			RemainingSec:  int64(max(l.Expiry.Sub(now), 0) / time.Second),
+			Online:        l.Online,
 		}
 	}
 
@@ -145,6 +203,7 @@ func (s *server) handleDHCPStatus(w http.ResponseWriter, r *http.Request) {
 
 	s.srv4.WriteDiskConfig4(&status.V4)
 	s.srv6.WriteDiskConfig6(&status.V6)
+	status.NAKCounts = s.srv4.NAKCounts()
 
 	leases := s.Leases()
 	slices.SortFunc(leases, func(a, b *dhcpsvc.Lease) (res int) {
@@ -165,7 +224,19 @@ func (s *server) handleDHCPStatus(w http.ResponseWriter, r *http.Request) {
 		dynamicIdx = len(leases)
 	}
 
-	status.Leases = leasesToDynamic(leases[dynamicIdx:])
+	v4Dur := time.Duration(status.V4.LeaseDuration) * time.Second
+	v6Dur := time.Duration(status.V6.LeaseDuration) * time.Second
+	status.Leases = leasesToDynamic(
+		leases[dynamicIdx:],
+		func(l *dhcpsvc.Lease) (d time.Duration) {
+			if l.IP.Is4() {
+				return v4Dur
+			}
+
+			return v6Dur
+		},
+		s.now(),
+	)
 	status.StaticLeases = leasesToStatic(leases[:dynamicIdx])
 
 	aghhttp.WriteJSONResponseOK(w, r, status)
@@ -242,15 +313,28 @@ func (s *server) handleDHCPSetConfigV4(
 
 	// Set the default values for the fields not configurable via web API.
 	c4 := &V4ServerConf{
-		notify:      s.onNotify,
-		ICMPTimeout: s.conf.Conf4.ICMPTimeout,
-		Options:     s.conf.Conf4.Options,
+		notify:                s.onNotify,
+		ICMPTimeout:           s.conf.Conf4.ICMPTimeout,
+		ConflictDetection:     s.conf.Conf4.ConflictDetection,
+		PresenceSweepInterval: s.conf.Conf4.PresenceSweepInterval,
+		PresenceSweepTimeout:  s.conf.Conf4.PresenceSweepTimeout,
+		Options:               s.conf.Conf4.Options,
+		VendorSpecificOptions: s.conf.Conf4.VendorSpecificOptions,
+		AdditionalRanges:      s.conf.Conf4.AdditionalRanges,
 	}
 
 	s.srv4.WriteDiskConfig4(c4)
 	v4Conf.notify = c4.notify
+	v4Conf.poolExhausted = s.conf.OnPoolExhausted
 	v4Conf.ICMPTimeout = c4.ICMPTimeout
+	v4Conf.ConflictDetection = c4.ConflictDetection
+	v4Conf.PresenceSweepInterval = c4.PresenceSweepInterval
+	v4Conf.PresenceSweepTimeout = c4.PresenceSweepTimeout
 	v4Conf.Options = c4.Options
+	v4Conf.AdditionalRanges = c4.AdditionalRanges
+	if conf.V4.VendorSpecificOptions == nil {
+		v4Conf.VendorSpecificOptions = c4.VendorSpecificOptions
+	}
 
 	srv4, err := v4Create(v4Conf)
 
@@ -665,6 +749,365 @@ func (s *server) handleDHCPUpdateStaticLease(w http.ResponseWriter, r *http.Requ
 	}
 }
 
+// makeStaticReq is the request for the POST /control/dhcp/make_static HTTP
+// API.
+type makeStaticReq struct {
+	// HWAddr is the MAC address of the dynamic lease.  It's only used if IP is
+	// not set.
+	HWAddr string `json:"mac"`
+
+	// IP is the IP address of the dynamic lease.
+	IP netip.Addr `json:"ip"`
+}
+
+// handleDHCPMakeStatic is the handler for the POST /control/dhcp/make_static
+// HTTP API.
+func (s *server) handleDHCPMakeStatic(w http.ResponseWriter, r *http.Request) {
+	req := &makeStaticReq{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding json: %s", err)
+
+		return
+	}
+
+	l, err := s.makeStaticLease(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, leasesToStatic([]*dhcpsvc.Lease{l})[0])
+}
+
+// makeStaticLease converts the dynamic lease described by req into a static
+// one.  If req only contains the MAC address, the DHCPv4 leases are looked up
+// first.
+func (s *server) makeStaticLease(req *makeStaticReq) (l *dhcpsvc.Lease, err error) {
+	ip := req.IP.Unmap()
+	if ip.IsValid() {
+		if ip.Is4() {
+			return s.srv4.MakeStaticLease(ip, nil)
+		} else if s.srv6 == nil {
+			return nil, errLeaseNotFound
+		}
+
+		return s.srv6.MakeStaticLease(ip, nil)
+	}
+
+	mac, err := net.ParseMAC(req.HWAddr)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse MAC address: %w", err)
+	}
+
+	l, err = s.srv4.MakeStaticLease(netip.Addr{}, mac)
+	if !errors.Is(err, errLeaseNotFound) || s.srv6 == nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return l, err
+	}
+
+	return s.srv6.MakeStaticLease(netip.Addr{}, mac)
+}
+
+// importDnsmasqReq is the request for the POST /control/dhcp/import_dnsmasq
+// HTTP API.
+type importDnsmasqReq struct {
+	// Data is the dnsmasq configuration containing the dhcp-host options or
+	// the contents of a dhcp-hostsfile.
+	Data string `json:"data"`
+}
+
+// importDnsmasqError is the error for a single line of the imported data.
+type importDnsmasqError struct {
+	// Message is the error message.
+	Message string `json:"message"`
+
+	// Line is the number of the line, starting from 1.
+	Line int `json:"line"`
+}
+
+// importDnsmasqResp is the response for the POST /control/dhcp/import_dnsmasq
+// HTTP API.
+type importDnsmasqResp struct {
+	// Errors are the errors for the lines that couldn't be imported.
+	Errors []*importDnsmasqError `json:"errors"`
+
+	// Added is the number of the added static leases.
+	Added int `json:"added"`
+}
+
+// handleDHCPImportDnsmasq is the handler for the POST
+// /control/dhcp/import_dnsmasq HTTP API.
+func (s *server) handleDHCPImportDnsmasq(w http.ResponseWriter, r *http.Request) {
+	req := &importDnsmasqReq{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding json: %s", err)
+
+		return
+	}
+
+	added, lineErrs, err := s.ImportDnsmasqHosts(strings.NewReader(req.Data))
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
+	resp := &importDnsmasqResp{
+		Errors: make([]*importDnsmasqError, 0, len(lineErrs)),
+		Added:  added,
+	}
+	for _, e := range lineErrs {
+		resp.Errors = append(resp.Errors, &importDnsmasqError{
+			Message: e.Err.Error(),
+			Line:    e.Line,
+		})
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
+
+// importStaticLeasesReq is the request for the POST
+// /control/dhcp/import_static_leases HTTP API.
+type importStaticLeasesReq struct {
+	// Format is the format of Data.
+	Format LeasesFormat `json:"format"`
+
+	// Data is the contents of the imported file.
+	Data string `json:"data"`
+}
+
+// importStaticLeasesResult is the result of importing a single line.
+type importStaticLeasesResult struct {
+	// Lease is the parsed lease.  It's nil if the line couldn't be parsed.
+	Lease *leaseStatic `json:"lease,omitempty"`
+
+	// Error is the error message.  It's empty if the lease has been added.
+	Error string `json:"error,omitempty"`
+
+	// Line is the number of the line, starting from 1.
+	Line int `json:"line"`
+}
+
+// importStaticLeasesResp is the response for the POST
+// /control/dhcp/import_static_leases HTTP API.
+type importStaticLeasesResp struct {
+	// Results are the results for each line containing a lease.
+	Results []*importStaticLeasesResult `json:"results"`
+
+	// Added is the number of the added static leases.
+	Added int `json:"added"`
+
+	// Failed is the number of the lines that couldn't be imported.
+	Failed int `json:"failed"`
+}
+
+// handleDHCPImportStaticLeases is the handler for the POST
+// /control/dhcp/import_static_leases HTTP API.
+func (s *server) handleDHCPImportStaticLeases(w http.ResponseWriter, r *http.Request) {
+	req := &importStaticLeasesReq{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding json: %s", err)
+
+		return
+	}
+
+	results, err := s.ImportStaticLeases(strings.NewReader(req.Data), req.Format)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
+	resp := &importStaticLeasesResp{
+		Results: make([]*importStaticLeasesResult, 0, len(results)),
+	}
+	for _, res := range results {
+		rr := &importStaticLeasesResult{
+			Line: res.Line,
+		}
+
+		if res.Lease != nil {
+			rr.Lease = leasesToStatic([]*dhcpsvc.Lease{res.Lease})[0]
+		}
+
+		if res.Err != nil {
+			rr.Error = res.Err.Error()
+			resp.Failed++
+		} else {
+			resp.Added++
+		}
+
+		resp.Results = append(resp.Results, rr)
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
+
+// handleDHCPExportStaticLeases is the handler for the GET
+// /control/dhcp/export_static_leases HTTP API.
+func (s *server) handleDHCPExportStaticLeases(w http.ResponseWriter, r *http.Request) {
+	f := LeasesFormat(r.URL.Query().Get("format"))
+
+	var contType, contDisp string
+	switch f {
+	case LeasesFormatDnsmasq:
+		contType = aghhttp.HdrValTextPlain
+		contDisp = `attachment; filename=static_leases.conf`
+	case LeasesFormatCSV:
+		contType = "text/csv"
+		contDisp = `attachment; filename=static_leases.csv`
+	default:
+		aghhttp.Error(r, w, http.StatusBadRequest, "bad leases format %q", f)
+
+		return
+	}
+
+	b := &bytes.Buffer{}
+	err := s.ExportStaticLeases(b, f)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "exporting leases: %s", err)
+
+		return
+	}
+
+	h := w.Header()
+	h.Set(httphdr.ContentType, contType)
+	h.Set(httphdr.ContentDisposition, contDisp)
+
+	_, err = w.Write(b.Bytes())
+	if err != nil {
+		log.Debug("dhcpd: writing exported leases: %s", err)
+	}
+}
+
+// staticLeaseOpJSON is the JSON form of a single change of a static lease.
+type staticLeaseOpJSON struct {
+	// Lease is the added, updated, or removed lease.
+	Lease *leaseStatic `json:"lease"`
+
+	// Action is the kind of the change.
+	Action StaticLeaseAction `json:"action"`
+}
+
+// toOp converts the JSON form of the change into a *StaticLeaseOp.
+func (o *staticLeaseOpJSON) toOp() (op *StaticLeaseOp, err error) {
+	if o == nil {
+		return nil, errors.Error("no operation")
+	} else if o.Lease == nil {
+		return nil, errors.Error("no lease")
+	} else if !o.Lease.IP.IsValid() {
+		return nil, errors.Error("invalid ip")
+	}
+
+	o.Lease.IP = o.Lease.IP.Unmap()
+
+	l, err := o.Lease.toLease()
+	if err != nil {
+		return nil, fmt.Errorf("parsing: %w", err)
+	}
+
+	return &StaticLeaseOp{
+		Lease:  l,
+		Action: o.Action,
+	}, nil
+}
+
+// staticLeasesBatchReq is the request for the POST
+// /control/dhcp/static_leases/batch HTTP API.
+type staticLeasesBatchReq struct {
+	// Operations are the changes to apply in order.
+	Operations []*staticLeaseOpJSON `json:"operations"`
+
+	// ValidateOnly, if true, means that the operations should only be
+	// validated.
+	ValidateOnly bool `json:"validate_only"`
+}
+
+// staticLeasesBatchResult is the result of a single operation of a batch.
+type staticLeasesBatchResult struct {
+	// Error is the error message.  It's empty if the operation is valid.
+	Error string `json:"error,omitempty"`
+
+	// Index is the index of the operation in the request.
+	Index int `json:"index"`
+}
+
+// staticLeasesBatchResp is the response for the POST
+// /control/dhcp/static_leases/batch HTTP API.
+type staticLeasesBatchResp struct {
+	// Results are the results of the operations in the order of the request.
+	Results []*staticLeasesBatchResult `json:"results"`
+
+	// Applied is true if the operations have been applied.
+	Applied bool `json:"applied"`
+}
+
+// handleDHCPStaticLeasesBatch is the handler for the POST
+// /control/dhcp/static_leases/batch HTTP API.
+func (s *server) handleDHCPStaticLeasesBatch(w http.ResponseWriter, r *http.Request) {
+	req := &staticLeasesBatchReq{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding json: %s", err)
+
+		return
+	}
+
+	v4, ok := s.srv4.(*v4Server)
+	if !ok {
+		aghhttp.Error(r, w, http.StatusBadRequest, "dhcpv4 server is not configured")
+
+		return
+	}
+
+	resp := &staticLeasesBatchResp{
+		Results: make([]*staticLeasesBatchResult, len(req.Operations)),
+	}
+
+	// Operations that couldn't be parsed are reported along with the results
+	// of the validation of the other ones, but nothing is applied then.
+	var ops []*StaticLeaseOp
+	var opIdxs []int
+	for i, o := range req.Operations {
+		resp.Results[i] = &staticLeasesBatchResult{Index: i}
+
+		op, opErr := o.toOp()
+		if opErr != nil {
+			resp.Results[i].Error = opErr.Error()
+
+			continue
+		}
+
+		ops = append(ops, op)
+		opIdxs = append(opIdxs, i)
+	}
+
+	validateOnly := req.ValidateOnly || len(ops) < len(req.Operations)
+	errs, applied, err := v4.ApplyStaticLeases(ops, validateOnly)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
+	for i, opErr := range errs {
+		if opErr != nil {
+			resp.Results[opIdxs[i]].Error = opErr.Error()
+		}
+	}
+
+	resp.Applied = applied
+	if applied {
+		s.auditLog(r, "applied %d static lease changes", len(ops))
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
+
 func (s *server) handleReset(w http.ResponseWriter, r *http.Request) {
 	err := s.Stop()
 	if err != nil {
@@ -682,6 +1125,7 @@ func (s *server) handleReset(w http.ResponseWriter, r *http.Request) {
 		ConfigModified: s.conf.ConfigModified,
 
 		HTTPRegister: s.conf.HTTPRegister,
+		RequestUser:  s.conf.RequestUser,
 
 		LocalDomainName: s.conf.LocalDomainName,
 
@@ -690,9 +1134,11 @@ func (s *server) handleReset(w http.ResponseWriter, r *http.Request) {
 	}
 
 	v4conf := &V4ServerConf{
-		LeaseDuration: DefaultDHCPLeaseTTL,
-		ICMPTimeout:   DefaultDHCPTimeoutICMP,
-		notify:        s.onNotify,
+		LeaseDuration:     DefaultDHCPLeaseTTL,
+		ICMPTimeout:       DefaultDHCPTimeoutICMP,
+		ConflictDetection: ConflictDetectionPing,
+		notify:            s.onNotify,
+		poolExhausted:     s.conf.OnPoolExhausted,
 	}
 	s.srv4, _ = v4Create(v4conf)
 
@@ -727,6 +1173,13 @@ func (s *server) registerHandlers() {
 	s.conf.HTTPRegister(http.MethodPost, "/control/dhcp/add_static_lease", s.handleDHCPAddStaticLease)
 	s.conf.HTTPRegister(http.MethodPost, "/control/dhcp/remove_static_lease", s.handleDHCPRemoveStaticLease)
 	s.conf.HTTPRegister(http.MethodPost, "/control/dhcp/update_static_lease", s.handleDHCPUpdateStaticLease)
+	s.conf.HTTPRegister(http.MethodPost, "/control/dhcp/make_static", s.handleDHCPMakeStatic)
+	s.conf.HTTPRegister(http.MethodPost, "/control/dhcp/import_dnsmasq", s.handleDHCPImportDnsmasq)
+	s.conf.HTTPRegister(http.MethodPost, "/control/dhcp/import_static_leases", s.handleDHCPImportStaticLeases)
+	s.conf.HTTPRegister(http.MethodGet, "/control/dhcp/export_static_leases", s.handleDHCPExportStaticLeases)
+	s.conf.HTTPRegister(http.MethodPost, "/control/dhcp/static_leases/batch", s.handleDHCPStaticLeasesBatch)
 	s.conf.HTTPRegister(http.MethodPost, "/control/dhcp/reset", s.handleReset)
 	s.conf.HTTPRegister(http.MethodPost, "/control/dhcp/reset_leases", s.handleResetLeases)
+	s.conf.HTTPRegister(http.MethodPost, "/control/dhcp/wake", s.handleDHCPWake)
+	s.conf.HTTPRegister(http.MethodPost, "/control/dhcp/ping", s.handleDHCPPing)
 }
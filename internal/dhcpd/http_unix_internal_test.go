@@ -5,11 +5,15 @@ package dhcpd
 import (
 	"bytes"
 	"encoding/json"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/netip"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/AdguardTeam/AdGuardHome/internal/dhcpsvc"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -25,6 +29,7 @@ func defaultResponse() *dhcpStatusResponse {
 		V6:           V6ServerConf{},
 		Leases:       []*leaseDynamic{},
 		StaticLeases: []*leaseStatic{},
+		NAKCounts:    map[string]uint64{},
 		Enabled:      true,
 	}
 
@@ -147,6 +152,60 @@ func TestServer_handleDHCPStatus(t *testing.T) {
 	require.True(t, ok)
 }
 
+func TestLeasesToDynamic(t *testing.T) {
+	const dur = 8 * time.Hour
+
+	now := time.Date(2024, time.January, 1, 12, 0, 0, 0, time.UTC)
+	durFunc := func(_ *dhcpsvc.Lease) (d time.Duration) { return dur }
+
+	testCases := []struct {
+		expiry        time.Time
+		name          string
+		wantState     dhcpsvc.LeaseState
+		wantRemaining int64
+	}{{
+		expiry:        now.Add(dur),
+		name:          "just_renewed",
+		wantState:     dhcpsvc.LeaseStateActive,
+		wantRemaining: int64(dur / time.Second),
+	}, {
+		expiry:        now.Add(3 * time.Hour),
+		name:          "past_t1",
+		wantState:     dhcpsvc.LeaseStatePastT1,
+		wantRemaining: 3 * 60 * 60,
+	}, {
+		expiry:        now.Add(30 * time.Minute),
+		name:          "past_t2",
+		wantState:     dhcpsvc.LeaseStatePastT2,
+		wantRemaining: 30 * 60,
+	}, {
+		expiry:        now.Add(-time.Minute),
+		name:          "expired",
+		wantState:     dhcpsvc.LeaseStateExpired,
+		wantRemaining: 0,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			leases := []*dhcpsvc.Lease{{
+				IP:     netip.MustParseAddr("192.168.10.100"),
+				Expiry: tc.expiry,
+			}}
+
+			dynamic := leasesToDynamic(leases, durFunc, now)
+			require.Len(t, dynamic, 1)
+
+			l := dynamic[0]
+			assert.Equal(t, tc.wantState, l.State)
+			assert.Equal(t, tc.wantRemaining, l.RemainingSec)
+
+			renewed := tc.expiry.Add(-dur)
+			assert.Equal(t, renewed.Add(4*time.Hour).Format(time.RFC3339), l.RenewalTime)
+			assert.Equal(t, renewed.Add(7*time.Hour).Format(time.RFC3339), l.RebindingTime)
+		})
+	}
+}
+
 func TestServer_HandleUpdateStaticLease(t *testing.T) {
 	const (
 		leaseV4Name = "static-client-v4"
@@ -317,3 +376,303 @@ func TestServer_HandleUpdateStaticLease_validation(t *testing.T) {
 		})
 	}
 }
+
+func TestServer_handleDHCPImportDnsmasq(t *testing.T) {
+	s, err := Create(&ServerConfig{
+		Enabled:        true,
+		Conf4:          *defaultV4ServerConf(),
+		DataDir:        t.TempDir(),
+		ConfigModified: func() {},
+	})
+	require.NoError(t, err)
+
+	b := &bytes.Buffer{}
+	err = json.NewEncoder(b).Encode(&importDnsmasqReq{Data: testDnsmasqConf})
+	require.NoError(t, err)
+
+	r, err := http.NewRequest(http.MethodPost, "", b)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	s.handleDHCPImportDnsmasq(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	resp := &importDnsmasqResp{}
+	err = json.NewDecoder(w.Body).Decode(resp)
+	require.NoError(t, err)
+
+	assert.Equal(t, 3, resp.Added)
+
+	wantErrs := []*importDnsmasqError{{
+		Message: `dhcpv4: adding static lease: ` +
+			`can't assign the gateway IP "192.168.10.1" to the lease`,
+		Line: 8,
+	}, {
+		Message: `dhcpv4: adding static lease: ` +
+			`adding static lease for 10.0.0.5 (aa:aa:aa:aa:aa:05): ` +
+			`subnet 192.168.10.1/24 does not contain the ip "10.0.0.5"`,
+		Line: 9,
+	}, {
+		Message: `bad mac address "aa:aa:*:*:*:*"`,
+		Line:    10,
+	}, {
+		Message: `no ip address`,
+		Line:    11,
+	}, {
+		Message: `bad lease time "0": must be positive`,
+		Line:    13,
+	}}
+	assert.Equal(t, wantErrs, resp.Errors)
+
+	leases := s.srv4.GetLeases(LeasesStatic)
+	require.Len(t, leases, 3)
+
+	assert.Equal(t, "printer", leases[0].Hostname)
+	assert.Equal(t, "laptop", leases[1].Hostname)
+	assert.Equal(t, netip.MustParseAddr("192.168.10.13"), leases[2].IP)
+}
+
+// newTestImportServer returns a new DHCP server with the default DHCPv4
+// configuration for the static leases import tests.
+func newTestImportServer(t *testing.T) (s *server) {
+	t.Helper()
+
+	s, err := Create(&ServerConfig{
+		Enabled:        true,
+		Conf4:          *defaultV4ServerConf(),
+		DataDir:        t.TempDir(),
+		ConfigModified: func() {},
+	})
+	require.NoError(t, err)
+
+	return s
+}
+
+func TestServer_handleDHCPImportStaticLeases(t *testing.T) {
+	s := newTestImportServer(t)
+
+	b := &bytes.Buffer{}
+	err := json.NewEncoder(b).Encode(&importStaticLeasesReq{
+		Format: LeasesFormatCSV,
+		Data:   testLeasesCSV,
+	})
+	require.NoError(t, err)
+
+	r, err := http.NewRequest(http.MethodPost, "", b)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	s.handleDHCPImportStaticLeases(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	resp := &importStaticLeasesResp{}
+	err = json.NewDecoder(w.Body).Decode(resp)
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, resp.Added)
+	assert.Equal(t, 8, resp.Failed)
+
+	wantErrs := map[int]string{
+		2: "",
+		3: "",
+		6: `dhcpv4: adding static lease: ` +
+			`can't assign the gateway IP "192.168.10.1" to the lease`,
+		7: `dhcpv4: adding static lease: ` +
+			`adding static lease for 10.0.0.5 (aa:aa:aa:aa:aa:04): ` +
+			`subnet 192.168.10.1/24 does not contain the ip "10.0.0.5"`,
+		8: `dhcpv4: adding static lease: ` +
+			`adding static lease for 192.168.10.12 (aa:aa:aa:aa:aa:05): ` +
+			`hostname is not unique`,
+		9: `dhcpv4: adding static lease: ` +
+			`removing dynamic leases for 192.168.10.10 (aa:aa:aa:aa:aa:06): ` +
+			`static lease already exists`,
+		10: `bad mac address "bad"`,
+		11: `want 2 or 3 fields, got 1`,
+		12: `bad ip address "192.168.10.300"`,
+		13: `extraneous or missing " in quoted-field`,
+	}
+
+	gotErrs := make(map[int]string, len(resp.Results))
+	for _, res := range resp.Results {
+		gotErrs[res.Line] = res.Error
+	}
+	assert.Equal(t, wantErrs, gotErrs)
+
+	require.Len(t, resp.Results, 10)
+
+	assert.Equal(t, "printer", resp.Results[0].Lease.Hostname)
+	assert.Equal(t, "aa:aa:aa:aa:aa:06", resp.Results[5].Lease.HWAddr)
+	assert.Nil(t, resp.Results[6].Lease)
+
+	leases := s.srv4.GetLeases(LeasesStatic)
+	require.Len(t, leases, 2)
+
+	assert.Equal(t, "printer", leases[0].Hostname)
+	assert.Equal(t, netip.MustParseAddr("192.168.10.11"), leases[1].IP)
+}
+
+func TestServer_ExportStaticLeases_roundTrip(t *testing.T) {
+	src := newTestImportServer(t)
+
+	_, err := src.ImportStaticLeases(strings.NewReader(testDnsmasqConf), LeasesFormatDnsmasq)
+	require.NoError(t, err)
+
+	want := src.srv4.GetLeases(LeasesStatic)
+	require.Len(t, want, 3)
+
+	for _, f := range []LeasesFormat{LeasesFormatDnsmasq, LeasesFormatCSV} {
+		t.Run(string(f), func(t *testing.T) {
+			b := &bytes.Buffer{}
+			err = src.ExportStaticLeases(b, f)
+			require.NoError(t, err)
+
+			dst := newTestImportServer(t)
+
+			var results []*ImportResult
+			results, err = dst.ImportStaticLeases(b, f)
+			require.NoError(t, err)
+			require.Len(t, results, len(want))
+
+			for _, res := range results {
+				assert.NoError(t, res.Err)
+			}
+
+			got := dst.srv4.GetLeases(LeasesStatic)
+			require.Len(t, got, len(want))
+
+			for i, l := range got {
+				assert.Equal(t, want[i].HWAddr, l.HWAddr)
+				assert.Equal(t, want[i].IP, l.IP)
+				assert.Equal(t, want[i].Hostname, l.Hostname)
+
+				if f == LeasesFormatDnsmasq {
+					assert.Equal(t, want[i].LeaseDuration, l.LeaseDuration)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_handleDHCPExportStaticLeases_badFormat(t *testing.T) {
+	s := newTestImportServer(t)
+
+	r, err := http.NewRequest(http.MethodGet, "/control/dhcp/export_static_leases?format=xml", nil)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	s.handleDHCPExportStaticLeases(w, r)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestServer_handleDHCPStaticLeasesBatch(t *testing.T) {
+	s := newTestImportServer(t)
+
+	const reqBody = `{
+  "operations": [
+    {"action": "add", "lease": {"mac": "aa:aa:aa:aa:aa:01", "ip": "192.168.10.10", "hostname": "printer"}},
+    {"action": "add", "lease": {"mac": "bad", "ip": "192.168.10.11", "hostname": "laptop"}},
+    {"action": "add", "lease": {"mac": "aa:aa:aa:aa:aa:03", "ip": "192.168.10.12", "hostname": "printer"}}
+  ]
+}`
+
+	r, err := http.NewRequest(http.MethodPost, "", strings.NewReader(reqBody))
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	s.handleDHCPStaticLeasesBatch(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	resp := &staticLeasesBatchResp{}
+	err = json.NewDecoder(w.Body).Decode(resp)
+	require.NoError(t, err)
+
+	assert.False(t, resp.Applied)
+
+	wantResults := []*staticLeasesBatchResult{{
+		Error: "",
+		Index: 0,
+	}, {
+		Error: "parsing: couldn't parse MAC address: address bad: invalid MAC address",
+		Index: 1,
+	}, {
+		Error: "adding static lease: adding static lease for 192.168.10.12 " +
+			"(aa:aa:aa:aa:aa:03): hostname is not unique",
+		Index: 2,
+	}}
+	assert.Equal(t, wantResults, resp.Results)
+
+	assert.Empty(t, s.srv4.GetLeases(LeasesStatic))
+}
+
+func TestServer_handleDHCPMakeStatic(t *testing.T) {
+	s := newTestImportServer(t)
+
+	s4, ok := s.srv4.(*v4Server)
+	require.True(t, ok)
+
+	firstMAC := net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0x01}
+	first := newDynamicLease(t, s4, firstMAC, "first-client")
+
+	secondMAC := net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0x02}
+	second := newDynamicLease(t, s4, secondMAC, "second-client")
+
+	testCases := []struct {
+		want     *leaseStatic
+		name     string
+		body     string
+		wantCode int
+	}{{
+		want: &leaseStatic{
+			HWAddr:   firstMAC.String(),
+			IP:       first.IP,
+			Hostname: "first-client",
+		},
+		name:     "by_ip",
+		body:     `{"ip":"` + first.IP.String() + `"}`,
+		wantCode: http.StatusOK,
+	}, {
+		want: &leaseStatic{
+			HWAddr:   secondMAC.String(),
+			IP:       second.IP,
+			Hostname: "second-client",
+		},
+		name:     "by_mac",
+		body:     `{"mac":"` + secondMAC.String() + `"}`,
+		wantCode: http.StatusOK,
+	}, {
+		want:     nil,
+		name:     "already_static",
+		body:     `{"ip":"` + first.IP.String() + `"}`,
+		wantCode: http.StatusBadRequest,
+	}, {
+		want:     nil,
+		name:     "bad_mac",
+		body:     `{"mac":"bad"}`,
+		wantCode: http.StatusBadRequest,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			body := strings.NewReader(tc.body)
+			r := httptest.NewRequest(http.MethodPost, "/control/dhcp/make_static", body)
+			w := httptest.NewRecorder()
+
+			s.handleDHCPMakeStatic(w, r)
+			require.Equal(t, tc.wantCode, w.Code)
+
+			if tc.want == nil {
+				return
+			}
+
+			got := &leaseStatic{}
+			err := json.NewDecoder(w.Body).Decode(got)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.want, got)
+		})
+	}
+
+	assert.Len(t, s.srv4.GetLeases(LeasesStatic), 2)
+	assert.Empty(t, s.srv4.GetLeases(LeasesDynamic))
+}
@@ -44,6 +44,13 @@ func (s *server) registerHandlers() {
 	s.conf.HTTPRegister(http.MethodPost, "/control/dhcp/add_static_lease", s.notImplemented)
 	s.conf.HTTPRegister(http.MethodPost, "/control/dhcp/remove_static_lease", s.notImplemented)
 	s.conf.HTTPRegister(http.MethodPost, "/control/dhcp/update_static_lease", s.notImplemented)
+	s.conf.HTTPRegister(http.MethodPost, "/control/dhcp/make_static", s.notImplemented)
+	s.conf.HTTPRegister(http.MethodPost, "/control/dhcp/import_dnsmasq", s.notImplemented)
+	s.conf.HTTPRegister(http.MethodPost, "/control/dhcp/import_static_leases", s.notImplemented)
+	s.conf.HTTPRegister(http.MethodGet, "/control/dhcp/export_static_leases", s.notImplemented)
+	s.conf.HTTPRegister(http.MethodPost, "/control/dhcp/static_leases/batch", s.notImplemented)
 	s.conf.HTTPRegister(http.MethodPost, "/control/dhcp/reset", s.notImplemented)
 	s.conf.HTTPRegister(http.MethodPost, "/control/dhcp/reset_leases", s.notImplemented)
+	s.conf.HTTPRegister(http.MethodPost, "/control/dhcp/wake", s.notImplemented)
+	s.conf.HTTPRegister(http.MethodPost, "/control/dhcp/ping", s.notImplemented)
 }
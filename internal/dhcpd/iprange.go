@@ -117,3 +117,62 @@ func (r *ipRange) offset(ip net.IP) (offset uint64, ok bool) {
 func (r *ipRange) String() (s string) {
 	return fmt.Sprintf("%s-%s", r.start, r.end)
 }
+
+// len returns the number of IP addresses in r.
+func (r *ipRange) len() (n uint64) {
+	if r == nil {
+		return 0
+	}
+
+	// Assume that the range was checked against maxRangeLen during
+	// construction.
+	return (&big.Int{}).Sub(r.end, r.start).Uint64() + 1
+}
+
+// ipRanges is a list of non-overlapping IP address ranges, which are used as
+// a single range.  The offsets of the IP addresses are counted through all the
+// ranges in order, so that the offset of the first address of a range follows
+// the offset of the last address of the previous one.
+//
+// It is safe for concurrent use.
+type ipRanges []*ipRange
+
+// contains returns true if one of the ranges of rs contains ip.
+func (rs ipRanges) contains(ip net.IP) (ok bool) {
+	for _, r := range rs {
+		if r.contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// find finds the first IP address in the first range of rs for which p returns
+// true.  ip is in the 16-byte form.
+func (rs ipRanges) find(p ipPredicate) (ip net.IP) {
+	for _, r := range rs {
+		ip = r.find(p)
+		if ip != nil {
+			return ip
+		}
+	}
+
+	return nil
+}
+
+// offset returns the offset of ip from the beginning of the first range of rs.
+// It returns 0 and false if ip is not in any of the ranges.
+func (rs ipRanges) offset(ip net.IP) (offset uint64, ok bool) {
+	var base uint64
+	for _, r := range rs {
+		offset, ok = r.offset(ip)
+		if ok {
+			return base + offset, true
+		}
+
+		base += r.len()
+	}
+
+	return 0, false
+}
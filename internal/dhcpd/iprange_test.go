@@ -147,3 +147,64 @@ func TestIPRange_Offset(t *testing.T) {
 		})
 	}
 }
+
+func TestIPRanges(t *testing.T) {
+	first, err := newIPRange(net.IP{0, 0, 0, 1}, net.IP{0, 0, 0, 3})
+	require.NoError(t, err)
+
+	second, err := newIPRange(net.IP{0, 0, 0, 10}, net.IP{0, 0, 0, 12})
+	require.NoError(t, err)
+
+	rs := ipRanges{first, second}
+
+	testCases := []struct {
+		ip         net.IP
+		name       string
+		wantOffset uint64
+		wantOK     bool
+	}{{
+		ip:         net.IP{0, 0, 0, 1},
+		name:       "first_start",
+		wantOffset: 0,
+		wantOK:     true,
+	}, {
+		ip:         net.IP{0, 0, 0, 3},
+		name:       "first_end",
+		wantOffset: 2,
+		wantOK:     true,
+	}, {
+		ip:         net.IP{0, 0, 0, 10},
+		name:       "second_start",
+		wantOffset: 3,
+		wantOK:     true,
+	}, {
+		ip:         net.IP{0, 0, 0, 12},
+		name:       "second_end",
+		wantOffset: 5,
+		wantOK:     true,
+	}, {
+		ip:         net.IP{0, 0, 0, 5},
+		name:       "between",
+		wantOffset: 0,
+		wantOK:     false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			offset, ok := rs.offset(tc.ip)
+			assert.Equal(t, tc.wantOK, ok)
+			assert.Equal(t, tc.wantOffset, offset)
+			assert.Equal(t, tc.wantOK, rs.contains(tc.ip))
+		})
+	}
+
+	t.Run("find", func(t *testing.T) {
+		ip := rs.find(func(ip net.IP) (ok bool) {
+			return ip[net.IPv6len-1] > 3
+		})
+		assert.Equal(t, net.IP{0, 0, 0, 10}.To16(), ip)
+
+		ip = rs.find(func(_ net.IP) (ok bool) { return false })
+		assert.Nil(t, ip)
+	})
+}
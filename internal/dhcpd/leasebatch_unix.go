@@ -0,0 +1,162 @@
+//go:build darwin || freebsd || linux || openbsd
+
+package dhcpd
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/dhcpsvc"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// StaticLeaseAction is the kind of a change of a static lease in a batch.
+type StaticLeaseAction string
+
+// StaticLeaseAction values.
+const (
+	StaticLeaseActionAdd    StaticLeaseAction = "add"
+	StaticLeaseActionUpdate StaticLeaseAction = "update"
+	StaticLeaseActionRemove StaticLeaseAction = "remove"
+)
+
+// StaticLeaseOp is a single change of a static lease in a batch.
+type StaticLeaseOp struct {
+	// Lease is the added, updated, or removed lease.  It must not be nil.
+	Lease *dhcpsvc.Lease
+
+	// Action is the kind of the change.
+	Action StaticLeaseAction
+}
+
+// ApplyStaticLeases validates ops as a whole and, unless validateOnly is true,
+// applies them atomically.  The operations are validated in order, so that each
+// one is checked against the leases changed by the previous ones.  errs
+// contains an error for each operation, nil if the operation is valid.
+// applied is false if any of errs isn't nil, in which case no leases are
+// changed.  The leases database is stored once after applying.  It is safe for
+// concurrent use.
+func (s *v4Server) ApplyStaticLeases(
+	ops []*StaticLeaseOp,
+	validateOnly bool,
+) (errs []error, applied bool, err error) {
+	defer func() { err = errors.Annotate(err, "dhcpv4: applying static leases: %w") }()
+
+	if s.conf == nil {
+		return nil, false, ErrUnconfigured
+	}
+
+	errs, applied, err = s.applyStaticLeases(ops, validateOnly)
+	if err != nil || !applied {
+		return errs, false, err
+	}
+
+	log.Info("dhcpv4: applied %d static lease changes", len(ops))
+
+	s.conf.notify(LeaseChangedDBStore)
+
+	var added, removed bool
+	for _, op := range ops {
+		if op.Action == StaticLeaseActionRemove {
+			removed = true
+		} else {
+			added = true
+		}
+	}
+
+	if added {
+		s.conf.notify(LeaseChangedAddedStatic)
+	}
+
+	if removed {
+		s.conf.notify(LeaseChangedRemovedStatic)
+	}
+
+	return errs, true, nil
+}
+
+// applyStaticLeases applies ops to a copy of the leases of s and replaces the
+// leases of s with it, if all operations are valid and validateOnly is false.
+func (s *v4Server) applyStaticLeases(
+	ops []*StaticLeaseOp,
+	validateOnly bool,
+) (errs []error, applied bool, err error) {
+	s.leasesLock.Lock()
+	defer s.leasesLock.Unlock()
+
+	c := s.cloneLeases()
+
+	valid := true
+	errs = make([]error, len(ops))
+	for i, op := range ops {
+		errs[i] = c.applyStaticLeaseOp(op)
+		valid = valid && errs[i] == nil
+	}
+
+	if !valid || validateOnly {
+		return errs, false, nil
+	}
+
+	s.leases = c.leases
+	s.hostsIndex = c.hostsIndex
+	s.ipIndex = c.ipIndex
+	s.leasedOffsets = c.leasedOffsets
+
+	return errs, true, nil
+}
+
+// cloneLeases returns a server with the same configuration as s and deep
+// clones of its leases and indexes, which can be changed without affecting s.
+// s.leasesLock is expected to be locked.
+func (s *v4Server) cloneLeases() (c *v4Server) {
+	c = &v4Server{
+		conf:          s.conf,
+		leasedOffsets: s.leasedOffsets.clone(),
+		leases:        make([]*dhcpsvc.Lease, 0, len(s.leases)),
+		hostsIndex:    make(map[string]*dhcpsvc.Lease, len(s.hostsIndex)),
+		ipIndex:       make(map[netip.Addr]*dhcpsvc.Lease, len(s.ipIndex)),
+	}
+
+	for _, l := range s.leases {
+		cl := l.Clone()
+		c.leases = append(c.leases, cl)
+
+		if s.hostsIndex[l.Hostname] == l {
+			c.hostsIndex[l.Hostname] = cl
+		}
+
+		if s.ipIndex[l.IP] == l {
+			c.ipIndex[l.IP] = cl
+		}
+	}
+
+	return c
+}
+
+// applyStaticLeaseOp applies a single change of a static lease to s.
+// s.leasesLock is expected to be locked, if necessary.
+func (s *v4Server) applyStaticLeaseOp(op *StaticLeaseOp) (err error) {
+	l := op.Lease
+
+	switch op.Action {
+	case StaticLeaseActionAdd:
+		err = s.prepareStaticLease(l)
+		if err != nil {
+			return fmt.Errorf("adding static lease: %w", err)
+		}
+
+		return errors.Annotate(s.updateStaticLease(l), "adding static lease: %w")
+	case StaticLeaseActionUpdate:
+		return errors.Annotate(s.replaceStaticLease(l), "updating static lease: %w")
+	case StaticLeaseActionRemove:
+		err = validateRemovedLease(l)
+		if err != nil {
+			return fmt.Errorf("removing static lease: %w", err)
+		}
+
+		return errors.Annotate(s.rmLease(l), "removing static lease: %w")
+	default:
+		return fmt.Errorf("bad action %q", op.Action)
+	}
+}
@@ -0,0 +1,160 @@
+//go:build darwin || freebsd || linux || openbsd
+
+package dhcpd
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/dhcpsvc"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestBatchServer returns a new DHCPv4 server with a single static lease
+// and a pointer to the number of the database stores.
+func newTestBatchServer(t *testing.T) (s *v4Server, stores *int) {
+	t.Helper()
+
+	stores = new(int)
+	conf := defaultV4ServerConf()
+	conf.notify = func(flags uint32) {
+		if flags == LeaseChangedDBStore {
+			*stores++
+		}
+	}
+
+	s, err := v4Create(conf)
+	require.NoError(t, err)
+
+	err = s.AddStaticLease(&dhcpsvc.Lease{
+		Hostname: "printer",
+		HWAddr:   net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0x01},
+		IP:       netip.MustParseAddr("192.168.10.10"),
+	})
+	require.NoError(t, err)
+
+	*stores = 0
+
+	return s, stores
+}
+
+// newTestOp is a helper that returns a new operation for the static lease with
+// the given properties.
+func newTestOp(action StaticLeaseAction, macLast byte, ip, host string) (op *StaticLeaseOp) {
+	return &StaticLeaseOp{
+		Lease: &dhcpsvc.Lease{
+			Hostname: host,
+			HWAddr:   net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, macLast},
+			IP:       netip.MustParseAddr(ip),
+			IsStatic: true,
+		},
+		Action: action,
+	}
+}
+
+func TestV4Server_ApplyStaticLeases(t *testing.T) {
+	validOps := func() (ops []*StaticLeaseOp) {
+		return []*StaticLeaseOp{
+			newTestOp(StaticLeaseActionRemove, 0x01, "192.168.10.10", "printer"),
+			newTestOp(StaticLeaseActionAdd, 0x02, "192.168.10.10", "Laptop"),
+			newTestOp(StaticLeaseActionAdd, 0x03, "192.168.10.11", "printer"),
+			newTestOp(StaticLeaseActionUpdate, 0x03, "192.168.10.12", "printer"),
+		}
+	}
+
+	t.Run("apply", func(t *testing.T) {
+		s, stores := newTestBatchServer(t)
+
+		errs, applied, err := s.ApplyStaticLeases(validOps(), false)
+		require.NoError(t, err)
+
+		assert.True(t, applied)
+		assert.Equal(t, []error{nil, nil, nil, nil}, errs)
+		assert.Equal(t, 1, *stores)
+
+		leases := s.GetLeases(LeasesStatic)
+		require.Len(t, leases, 2)
+
+		assert.Equal(t, "laptop", leases[0].Hostname)
+		assert.Equal(t, netip.MustParseAddr("192.168.10.10"), leases[0].IP)
+		assert.Equal(t, "printer", leases[1].Hostname)
+		assert.Equal(t, netip.MustParseAddr("192.168.10.12"), leases[1].IP)
+
+		assert.Equal(t, "laptop", s.HostByIP(netip.MustParseAddr("192.168.10.10")))
+		assert.Equal(t, netip.MustParseAddr("192.168.10.12"), s.IPByHost("printer"))
+	})
+
+	t.Run("validate_only", func(t *testing.T) {
+		s, stores := newTestBatchServer(t)
+
+		errs, applied, err := s.ApplyStaticLeases(validOps(), true)
+		require.NoError(t, err)
+
+		assert.False(t, applied)
+		assert.Equal(t, []error{nil, nil, nil, nil}, errs)
+		assert.Zero(t, *stores)
+
+		leases := s.GetLeases(LeasesStatic)
+		require.Len(t, leases, 1)
+
+		assert.Equal(t, "printer", leases[0].Hostname)
+		assert.Equal(t, "printer", s.HostByIP(netip.MustParseAddr("192.168.10.10")))
+	})
+
+	t.Run("conflicts", func(t *testing.T) {
+		s, stores := newTestBatchServer(t)
+
+		ops := []*StaticLeaseOp{
+			newTestOp(StaticLeaseActionAdd, 0x02, "192.168.10.20", "phone"),
+			newTestOp(StaticLeaseActionAdd, 0x03, "192.168.10.20", "tablet"),
+			newTestOp(StaticLeaseActionAdd, 0x04, "192.168.10.21", "phone"),
+			newTestOp(StaticLeaseActionAdd, 0x05, "192.168.10.1", "router"),
+			newTestOp(StaticLeaseActionUpdate, 0x06, "192.168.10.22", "tv"),
+			newTestOp(StaticLeaseActionRemove, 0x07, "192.168.10.23", "tv"),
+			newTestOp("rename", 0x08, "192.168.10.24", "tv"),
+		}
+
+		errs, applied, err := s.ApplyStaticLeases(ops, false)
+		require.NoError(t, err)
+
+		assert.False(t, applied)
+		assert.Zero(t, *stores)
+
+		require.Len(t, errs, len(ops))
+
+		assert.NoError(t, errs[0])
+		testutil.AssertErrorMsg(
+			t,
+			"adding static lease: removing dynamic leases for 192.168.10.20 "+
+				"(aa:aa:aa:aa:aa:03): static lease already exists",
+			errs[1],
+		)
+		testutil.AssertErrorMsg(
+			t,
+			"adding static lease: adding static lease for 192.168.10.21 "+
+				"(aa:aa:aa:aa:aa:04): hostname is not unique",
+			errs[2],
+		)
+		testutil.AssertErrorMsg(
+			t,
+			`adding static lease: can't assign the gateway IP "192.168.10.1" to the lease`,
+			errs[3],
+		)
+		testutil.AssertErrorMsg(
+			t,
+			"updating static lease: can't find lease aa:aa:aa:aa:aa:06",
+			errs[4],
+		)
+		testutil.AssertErrorMsg(t, "removing static lease: lease not found", errs[5])
+		testutil.AssertErrorMsg(t, `bad action "rename"`, errs[6])
+
+		leases := s.GetLeases(LeasesStatic)
+		require.Len(t, leases, 1)
+
+		assert.Equal(t, "printer", leases[0].Hostname)
+		assert.False(t, s.IPByHost("phone").IsValid())
+	})
+}
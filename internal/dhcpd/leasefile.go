@@ -0,0 +1,264 @@
+package dhcpd
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/dhcpsvc"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// LeasesFormat is the format of a file with static leases.
+type LeasesFormat string
+
+// LeasesFormat values.
+const (
+	// LeasesFormatDnsmasq is the format of the dnsmasq dhcp-host options, see
+	// [parseDnsmasqHosts].
+	LeasesFormatDnsmasq LeasesFormat = "dnsmasq"
+
+	// LeasesFormatCSV is the CSV format with the "mac,ip,hostname" records,
+	// see [parseCSVLeases].
+	LeasesFormatCSV LeasesFormat = "csv"
+)
+
+// csvLeasesHeader is the header of the CSV file with static leases.
+var csvLeasesHeader = []string{"mac", "ip", "hostname"}
+
+// importedLease is a static lease parsed from an imported file.
+type importedLease struct {
+	// lease is the parsed static lease.
+	lease *dhcpsvc.Lease
+
+	// line is the number of the line the lease is defined on.
+	line int
+}
+
+// ImportResult is the result of importing a single line of a file with static
+// leases.
+type ImportResult struct {
+	// Lease is the parsed lease.  It's nil if the line couldn't be parsed.
+	Lease *dhcpsvc.Lease
+
+	// Err is the error of parsing or adding the lease.  It's nil if the lease
+	// has been added.
+	Err error
+
+	// Line is the number of the line, starting from 1.
+	Line int
+}
+
+// parseLeasesFile parses the static leases from r in the format f.
+func parseLeasesFile(
+	r io.Reader,
+	f LeasesFormat,
+) (leases []*importedLease, lineErrs []*LineError, err error) {
+	switch f {
+	case LeasesFormatDnsmasq:
+		return parseDnsmasqHosts(r)
+	case LeasesFormatCSV:
+		return parseCSVLeases(r)
+	default:
+		return nil, nil, fmt.Errorf("bad leases format %q", f)
+	}
+}
+
+// parseCSVLeases parses the static leases from r, which should contain the
+// records with the MAC address, the IP address, and the optional hostname.
+// Empty lines, comments, and the header are skipped.  lineErrs contains the
+// errors of the lines that couldn't be parsed, err is only returned if r
+// couldn't be read.
+func parseCSVLeases(r io.Reader) (leases []*importedLease, lineErrs []*LineError, err error) {
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		var l *dhcpsvc.Lease
+		l, err = parseCSVLease(line)
+		if err != nil {
+			lineErrs = append(lineErrs, &LineError{Err: err, Line: n})
+		} else if l != nil {
+			leases = append(leases, &importedLease{lease: l, line: n})
+		}
+	}
+
+	err = s.Err()
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading: %w", err)
+	}
+
+	return leases, lineErrs, nil
+}
+
+// parseCSVLease parses a single CSV record with a static lease.  Each line is
+// parsed separately so that a malformed one doesn't affect the others.  l is
+// nil if line is a header.
+func parseCSVLease(line string) (l *dhcpsvc.Lease, err error) {
+	cr := csv.NewReader(strings.NewReader(line))
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	rec, err := cr.Read()
+	if err != nil {
+		// Use the underlying error, since the position reported by the reader
+		// is always on the first line.
+		parseErr := &csv.ParseError{}
+		if errors.As(err, &parseErr) {
+			return nil, parseErr.Err
+		}
+
+		return nil, err
+	}
+
+	if strings.EqualFold(strings.TrimSpace(rec[0]), csvLeasesHeader[0]) {
+		return nil, nil
+	}
+
+	if len(rec) < 2 || len(rec) > len(csvLeasesHeader) {
+		return nil, fmt.Errorf("want 2 or 3 fields, got %d", len(rec))
+	}
+
+	macStr, ipStr := strings.TrimSpace(rec[0]), strings.TrimSpace(rec[1])
+
+	mac, err := net.ParseMAC(macStr)
+	if err != nil {
+		return nil, fmt.Errorf("bad mac address %q", macStr)
+	}
+
+	ip, err := netip.ParseAddr(ipStr)
+	if err != nil {
+		return nil, fmt.Errorf("bad ip address %q", ipStr)
+	}
+
+	l = &dhcpsvc.Lease{
+		HWAddr:   mac,
+		IP:       ip.Unmap(),
+		IsStatic: true,
+	}
+
+	if len(rec) == len(csvLeasesHeader) {
+		l.Hostname = strings.TrimSpace(rec[2])
+	}
+
+	return l, nil
+}
+
+// ImportStaticLeases adds the static leases described in r in the format f.
+// The leases are validated the same way as in [server.AddStaticLease] and the
+// invalid ones don't prevent adding the others.  results contain a result for
+// each lease line, sorted by the line number.  err is only returned if r
+// couldn't be read or f is invalid.
+func (s *server) ImportStaticLeases(r io.Reader, f LeasesFormat) (results []*ImportResult, err error) {
+	leases, lineErrs, err := parseLeasesFile(r, f)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s leases: %w", f, err)
+	}
+
+	results = make([]*ImportResult, 0, len(leases)+len(lineErrs))
+	for _, e := range lineErrs {
+		results = append(results, &ImportResult{Err: e.Err, Line: e.Line})
+	}
+
+	added := 0
+	for _, l := range leases {
+		err = s.AddStaticLease(l.lease)
+		if err == nil {
+			added++
+		}
+
+		results = append(results, &ImportResult{Lease: l.lease, Err: err, Line: l.line})
+	}
+
+	slices.SortStableFunc(results, func(a, b *ImportResult) (res int) {
+		return cmp.Compare(a.Line, b.Line)
+	})
+
+	log.Info(
+		"dhcpd: imported %d static leases in %s format, %d errors",
+		added,
+		f,
+		len(results)-added,
+	)
+
+	return results, nil
+}
+
+// ExportStaticLeases writes the current static leases into w in the format f.
+func (s *server) ExportStaticLeases(w io.Writer, f LeasesFormat) (err error) {
+	leases := append(s.srv4.GetLeases(LeasesStatic), s.srv6.GetLeases(LeasesStatic)...)
+
+	switch f {
+	case LeasesFormatDnsmasq:
+		return writeDnsmasqHosts(w, leases)
+	case LeasesFormatCSV:
+		return writeCSVLeases(w, leases)
+	default:
+		return fmt.Errorf("bad leases format %q", f)
+	}
+}
+
+// writeDnsmasqHosts writes leases into w as dnsmasq dhcp-host options.
+func writeDnsmasqHosts(w io.Writer, leases []*dhcpsvc.Lease) (err error) {
+	bw := bufio.NewWriter(w)
+	for _, l := range leases {
+		ipStr := l.IP.String()
+		if l.IP.Is6() {
+			ipStr = "[" + ipStr + "]"
+		}
+
+		fields := []string{l.HWAddr.String(), ipStr}
+		if l.Hostname != "" {
+			fields = append(fields, l.Hostname)
+		}
+
+		switch d := l.LeaseDuration; {
+		case d == dhcpsvc.LeaseDurationInfinite:
+			fields = append(fields, "infinite")
+		case d >= time.Second:
+			fields = append(fields, strconv.FormatInt(int64(d/time.Second), 10))
+		default:
+			// Use the default lease duration of the server.
+		}
+
+		_, err = fmt.Fprintf(bw, "%s=%s\n", dnsmasqHostKey, strings.Join(fields, ","))
+		if err != nil {
+			return fmt.Errorf("writing dnsmasq host: %w", err)
+		}
+	}
+
+	return bw.Flush()
+}
+
+// writeCSVLeases writes leases into w as CSV records with a header.
+func writeCSVLeases(w io.Writer, leases []*dhcpsvc.Lease) (err error) {
+	cw := csv.NewWriter(w)
+
+	err = cw.Write(csvLeasesHeader)
+	if err != nil {
+		return fmt.Errorf("writing csv header: %w", err)
+	}
+
+	for _, l := range leases {
+		err = cw.Write([]string{l.HWAddr.String(), l.IP.String(), l.Hostname})
+		if err != nil {
+			return fmt.Errorf("writing csv lease: %w", err)
+		}
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
@@ -0,0 +1,141 @@
+package dhcpd
+
+import (
+	"bytes"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/dhcpsvc"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testLeasesCSV is a representative CSV file with static leases for tests.  The
+// addresses are chosen to fit [defaultV4ServerConf].
+const testLeasesCSV = `mac,ip,hostname
+aa:aa:aa:aa:aa:01,192.168.10.10,printer
+aa:aa:aa:aa:aa:02, 192.168.10.11
+
+# Comment.
+aa:aa:aa:aa:aa:03,192.168.10.1,gateway
+aa:aa:aa:aa:aa:04,10.0.0.5,outside
+aa:aa:aa:aa:aa:05,192.168.10.12,printer
+aa:aa:aa:aa:aa:06,192.168.10.10,copier
+bad,192.168.10.13
+aa:aa:aa:aa:aa:07
+aa:aa:aa:aa:aa:08,192.168.10.300
+"aa:aa:aa:aa:aa:09,192.168.10.14
+`
+
+func TestParseCSVLeases(t *testing.T) {
+	leases, lineErrs, err := parseCSVLeases(strings.NewReader(testLeasesCSV))
+	require.NoError(t, err)
+
+	wantLeases := []*importedLease{{
+		lease: &dhcpsvc.Lease{
+			IP:       netip.MustParseAddr("192.168.10.10"),
+			Hostname: "printer",
+			HWAddr:   net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0x01},
+			IsStatic: true,
+		},
+		line: 2,
+	}, {
+		lease: &dhcpsvc.Lease{
+			IP:       netip.MustParseAddr("192.168.10.11"),
+			HWAddr:   net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0x02},
+			IsStatic: true,
+		},
+		line: 3,
+	}, {
+		lease: &dhcpsvc.Lease{
+			IP:       netip.MustParseAddr("192.168.10.1"),
+			Hostname: "gateway",
+			HWAddr:   net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0x03},
+			IsStatic: true,
+		},
+		line: 6,
+	}, {
+		lease: &dhcpsvc.Lease{
+			IP:       netip.MustParseAddr("10.0.0.5"),
+			Hostname: "outside",
+			HWAddr:   net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0x04},
+			IsStatic: true,
+		},
+		line: 7,
+	}, {
+		lease: &dhcpsvc.Lease{
+			IP:       netip.MustParseAddr("192.168.10.12"),
+			Hostname: "printer",
+			HWAddr:   net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0x05},
+			IsStatic: true,
+		},
+		line: 8,
+	}, {
+		lease: &dhcpsvc.Lease{
+			IP:       netip.MustParseAddr("192.168.10.10"),
+			Hostname: "copier",
+			HWAddr:   net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0x06},
+			IsStatic: true,
+		},
+		line: 9,
+	}}
+	assert.Equal(t, wantLeases, leases)
+
+	require.Len(t, lineErrs, 4)
+
+	testutil.AssertErrorMsg(t, `line 10: bad mac address "bad"`, lineErrs[0])
+	testutil.AssertErrorMsg(t, `line 11: want 2 or 3 fields, got 1`, lineErrs[1])
+	testutil.AssertErrorMsg(t, `line 12: bad ip address "192.168.10.300"`, lineErrs[2])
+	testutil.AssertErrorMsg(
+		t,
+		`line 13: extraneous or missing " in quoted-field`,
+		lineErrs[3],
+	)
+}
+
+func TestWriteLeases(t *testing.T) {
+	leases := []*dhcpsvc.Lease{{
+		IP:            netip.MustParseAddr("192.168.10.10"),
+		Hostname:      "printer",
+		HWAddr:        net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0x01},
+		LeaseDuration: dhcpsvc.LeaseDurationInfinite,
+		IsStatic:      true,
+	}, {
+		IP:            netip.MustParseAddr("192.168.10.11"),
+		HWAddr:        net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0x02},
+		LeaseDuration: 12 * time.Hour,
+		IsStatic:      true,
+	}, {
+		IP:       netip.MustParseAddr("2001:db8::1"),
+		Hostname: "laptop",
+		HWAddr:   net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0x03},
+		IsStatic: true,
+	}}
+
+	t.Run("dnsmasq", func(t *testing.T) {
+		b := &bytes.Buffer{}
+		err := writeDnsmasqHosts(b, leases)
+		require.NoError(t, err)
+
+		want := "dhcp-host=aa:aa:aa:aa:aa:01,192.168.10.10,printer,infinite\n" +
+			"dhcp-host=aa:aa:aa:aa:aa:02,192.168.10.11,43200\n" +
+			"dhcp-host=aa:aa:aa:aa:aa:03,[2001:db8::1],laptop\n"
+		assert.Equal(t, want, b.String())
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		b := &bytes.Buffer{}
+		err := writeCSVLeases(b, leases)
+		require.NoError(t, err)
+
+		want := "mac,ip,hostname\n" +
+			"aa:aa:aa:aa:aa:01,192.168.10.10,printer\n" +
+			"aa:aa:aa:aa:aa:02,192.168.10.11,\n" +
+			"aa:aa:aa:aa:aa:03,2001:db8::1,laptop\n"
+		assert.Equal(t, want, b.String())
+	})
+}
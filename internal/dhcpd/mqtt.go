@@ -0,0 +1,352 @@
+package dhcpd
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/dhcpsvc"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// MQTT constants.
+const (
+	// defaultMQTTPort is the default port of an MQTT broker.
+	defaultMQTTPort = 1883
+
+	// defaultMQTTTopic is the topic used when [ServerConfig.MQTTTopic] is
+	// empty.
+	defaultMQTTTopic = "adguardhome/dhcp/leases"
+
+	// defaultMQTTClientID is the client identifier used when
+	// [ServerConfig.MQTTClientID] is empty.
+	defaultMQTTClientID = "adguardhome"
+
+	// mqttTimeout is the timeout of connecting to the broker and of
+	// publishing a single event.
+	mqttTimeout = 5 * time.Second
+
+	// mqttKeepAlive is the keep alive interval sent to the broker.
+	mqttKeepAlive = 30 * time.Second
+
+	// mqttRetryInterval is the delay between the attempts to establish the
+	// initial connection to the broker.
+	mqttRetryInterval = 1 * time.Second
+
+	// mqttMaxBackoff is the upper bound of the delay between the attempts to
+	// reconnect to the broker.
+	mqttMaxBackoff = 2 * time.Minute
+
+	// mqttQuiesce is the time to wait for the pending work to complete when
+	// disconnecting from the broker.
+	mqttQuiesce = 250 * time.Millisecond
+)
+
+// MQTT lease events.
+const (
+	mqttEventAdd    = "add"
+	mqttEventUpdate = "update"
+	mqttEventExpire = "expire"
+)
+
+// mqttLeaseJSON is the lease part of the payload of an MQTT lease event.
+type mqttLeaseJSON struct {
+	// IP is the leased address.
+	IP string `json:"ip"`
+
+	// HWAddr is the hardware address of the client.
+	HWAddr string `json:"mac"`
+
+	// Hostname is the hostname of the client.
+	Hostname string `json:"hostname"`
+
+	// Expiry is the expiration time of the lease in RFC 3339 format.  It's
+	// empty for static leases.
+	Expiry string `json:"expires,omitempty"`
+
+	// IsStatic is true if the lease is static.
+	IsStatic bool `json:"static"`
+}
+
+// mqttEventJSON is the payload of an MQTT lease event.
+type mqttEventJSON struct {
+	// Lease is the added, updated, or expired lease.
+	Lease *mqttLeaseJSON `json:"lease"`
+
+	// Event is the type of the event, one of mqttEvent constants.
+	Event string `json:"event"`
+}
+
+// newMQTTLeaseJSON converts l into the lease part of an MQTT payload.
+func newMQTTLeaseJSON(l *dhcpsvc.Lease) (lj *mqttLeaseJSON) {
+	lj = &mqttLeaseJSON{
+		IP:       l.IP.String(),
+		HWAddr:   l.HWAddr.String(),
+		Hostname: l.Hostname,
+		IsStatic: l.IsStatic,
+	}
+
+	if !l.IsStatic {
+		lj.Expiry = l.Expiry.Format(time.RFC3339)
+	}
+
+	return lj
+}
+
+// mqttNotifier publishes the changes of the DHCPv4 leases to an MQTT broker.
+// The connection to the broker is maintained by the MQTT client, which
+// reconnects with an exponential backoff, when the connection is lost.  The
+// events failed to publish are published after reconnecting.
+type mqttNotifier struct {
+	// leases returns the current leases.
+	leases func() (leases []*dhcpsvc.Lease)
+
+	// changed is signaled when the leases have changed.  It has a buffer of
+	// one, so that the pending signals are coalesced.
+	changed chan struct{}
+
+	// mu protects done.
+	mu *sync.Mutex
+
+	// done is closed to stop the notifier.  It's nil when the notifier isn't
+	// running.
+	done chan struct{}
+
+	// wg is used to wait for the notifier to stop.
+	wg *sync.WaitGroup
+
+	// opts are the options of the MQTT clients created on start.
+	opts *mqtt.ClientOptions
+
+	// published maps the hardware addresses of the leases to the last
+	// published states of them.  It's only accessed by the running loop.
+	published map[string]*mqttLeaseJSON
+
+	// topic is the topic to publish the events to.
+	topic string
+}
+
+// newMQTTNotifier returns a new notifier for the leases returned by leases.
+// conf.MQTTBroker must not be empty.
+func newMQTTNotifier(
+	conf *ServerConfig,
+	leases func() (leases []*dhcpsvc.Lease),
+) (n *mqttNotifier) {
+	broker := conf.MQTTBroker
+	if _, _, err := net.SplitHostPort(broker); err != nil {
+		broker = netutil.JoinHostPort(broker, defaultMQTTPort)
+	}
+
+	clientID := conf.MQTTClientID
+	if clientID == "" {
+		clientID = defaultMQTTClientID
+	}
+
+	n = &mqttNotifier{
+		leases:    leases,
+		changed:   make(chan struct{}, 1),
+		mu:        &sync.Mutex{},
+		wg:        &sync.WaitGroup{},
+		published: map[string]*mqttLeaseJSON{},
+		topic:     conf.MQTTTopic,
+	}
+
+	if n.topic == "" {
+		n.topic = defaultMQTTTopic
+	}
+
+	n.opts = mqtt.NewClientOptions().
+		AddBroker("tcp://" + broker).
+		SetClientID(clientID).
+		SetCleanSession(true).
+		SetKeepAlive(mqttKeepAlive).
+		SetConnectTimeout(mqttTimeout).
+		SetWriteTimeout(mqttTimeout).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(mqttRetryInterval).
+		SetAutoReconnect(true).
+		SetMaxReconnectInterval(mqttMaxBackoff).
+		SetOnConnectHandler(func(_ mqtt.Client) {
+			log.Info("dhcpd: mqtt: connected to %s", broker)
+
+			// Publish the changes that have happened while disconnected.
+			n.signal()
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Error("dhcpd: mqtt: connection to %s lost: %s; reconnecting", broker, err)
+		})
+
+	return n
+}
+
+// onLeaseChanged is the [OnLeaseChangedT] of n.  It only signals the running
+// loop, since the callback is called by the DHCP servers while handling the
+// requests.
+func (n *mqttNotifier) onLeaseChanged(flags int) {
+	switch flags {
+	case
+		LeaseChangedAdded,
+		LeaseChangedAddedStatic,
+		LeaseChangedRemovedStatic,
+		LeaseChangedRemovedAll:
+		n.signal()
+	default:
+		// Go on.
+	}
+}
+
+// signal signals the running loop that the leases have changed.
+func (n *mqttNotifier) signal() {
+	select {
+	case n.changed <- struct{}{}:
+	default:
+		// The signal is already pending.
+	}
+}
+
+// start connects to the broker and starts the publishing loop of n.  It does
+// nothing if n is already running.
+func (n *mqttNotifier) start() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.done != nil {
+		return
+	}
+
+	client := mqtt.NewClient(n.opts)
+
+	// Since the connection is retried until it succeeds or the client is
+	// disconnected, don't wait for the token.
+	_ = client.Connect()
+
+	n.done = make(chan struct{})
+	n.wg.Add(1)
+
+	go n.run(client, n.done)
+}
+
+// stop stops the publishing loop of n and disconnects from the broker.  It
+// does nothing if n isn't running.
+func (n *mqttNotifier) stop() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.done == nil {
+		return
+	}
+
+	close(n.done)
+	n.wg.Wait()
+
+	n.done = nil
+}
+
+// run publishes the events using client until done is closed and then
+// disconnects client.  It is intended to be used as a goroutine.
+func (n *mqttNotifier) run(client mqtt.Client, done <-chan struct{}) {
+	defer n.wg.Done()
+	defer log.OnPanic("dhcpd: mqtt")
+	defer client.Disconnect(uint(mqttQuiesce.Milliseconds()))
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-n.changed:
+			err := n.sync(client)
+			if err != nil {
+				log.Error("dhcpd: mqtt: %s", err)
+			}
+		}
+	}
+}
+
+// sync publishes the events for the differences between the published and the
+// current DHCPv4 leases.  The events failed to publish are published during
+// the next synchronization.  It does nothing if client isn't connected.
+func (n *mqttNotifier) sync(client mqtt.Client) (err error) {
+	if !client.IsConnectionOpen() {
+		return nil
+	}
+
+	current := map[string]*mqttLeaseJSON{}
+	for _, l := range n.leases() {
+		if l.IP.Is4() {
+			current[l.HWAddr.String()] = newMQTTLeaseJSON(l)
+		}
+	}
+
+	for _, mac := range slices.Sorted(maps.Keys(n.published)) {
+		if _, ok := current[mac]; ok {
+			continue
+		}
+
+		err = n.publish(client, mqttEventExpire, n.published[mac])
+		if err != nil {
+			return err
+		}
+
+		delete(n.published, mac)
+	}
+
+	for _, mac := range slices.Sorted(maps.Keys(current)) {
+		lj := current[mac]
+
+		event := mqttEventAdd
+		if pub, ok := n.published[mac]; ok {
+			if *pub == *lj {
+				continue
+			}
+
+			event = mqttEventUpdate
+		}
+
+		err = n.publish(client, event, lj)
+		if err != nil {
+			return err
+		}
+
+		n.published[mac] = lj
+	}
+
+	return nil
+}
+
+// errMQTTTimeout is returned by [mqttNotifier.publish] when the event isn't
+// published in time.
+const errMQTTTimeout errors.Error = "timed out"
+
+// publish publishes the event for lj using client with QoS 0.
+func (n *mqttNotifier) publish(client mqtt.Client, event string, lj *mqttLeaseJSON) (err error) {
+	payload, err := json.Marshal(&mqttEventJSON{
+		Lease: lj,
+		Event: event,
+	})
+	if err != nil {
+		// Should not happen.
+		return fmt.Errorf("encoding %s event: %w", event, err)
+	}
+
+	tok := client.Publish(n.topic, 0, false, payload)
+	if !tok.WaitTimeout(mqttTimeout) {
+		err = errMQTTTimeout
+	} else {
+		err = tok.Error()
+	}
+
+	if err != nil {
+		return fmt.Errorf("publishing %s event for %s: %w", event, lj.HWAddr, err)
+	}
+
+	log.Debug("dhcpd: mqtt: published %s event for %s", event, lj.HWAddr)
+
+	return nil
+}
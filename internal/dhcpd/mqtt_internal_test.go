@@ -0,0 +1,248 @@
+package dhcpd
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/dhcpsvc"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testMQTTTopic is the MQTT topic for tests.
+const testMQTTTopic = "test/leases"
+
+// MQTT control packet types used by [testMQTTBroker], see MQTT 3.1.1, section
+// 2.2.1.
+const (
+	testMQTTPacketConnect    byte = 0x10
+	testMQTTPacketConnAck    byte = 0x20
+	testMQTTPacketPublish    byte = 0x30
+	testMQTTPacketPingReq    byte = 0xC0
+	testMQTTPacketPingResp   byte = 0xD0
+	testMQTTPacketDisconnect byte = 0xE0
+)
+
+// testMQTTMessage is a message published to [testMQTTBroker].
+type testMQTTMessage struct {
+	topic   string
+	payload []byte
+}
+
+// testMQTTBroker is a mock MQTT broker accepting the QoS 0 messages.
+type testMQTTBroker struct {
+	// msgs receives the published messages.
+	msgs chan *testMQTTMessage
+
+	// conns receives the accepted connections.
+	conns chan net.Conn
+}
+
+// startMQTTBroker starts a mock MQTT broker and returns it with its address.
+func startMQTTBroker(t *testing.T) (b *testMQTTBroker, addr string) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, l.Close)
+
+	b = &testMQTTBroker{
+		msgs:  make(chan *testMQTTMessage, 16),
+		conns: make(chan net.Conn, 16),
+	}
+
+	go func() {
+		for {
+			conn, acceptErr := l.Accept()
+			if acceptErr != nil {
+				return
+			}
+
+			b.conns <- conn
+			go b.handle(conn)
+		}
+	}()
+
+	return b, l.Addr().String()
+}
+
+// handle reads the packets from conn until it's closed.
+func (b *testMQTTBroker) handle(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+
+	r := bufio.NewReader(conn)
+	for {
+		typ, body, err := readTestMQTTPacket(r)
+		if err != nil {
+			return
+		}
+
+		switch typ & 0xF0 {
+		case testMQTTPacketConnect:
+			_, _ = conn.Write([]byte{testMQTTPacketConnAck, 2, 0, 0})
+		case testMQTTPacketPingReq:
+			_, _ = conn.Write([]byte{testMQTTPacketPingResp, 0})
+		case testMQTTPacketPublish:
+			l := binary.BigEndian.Uint16(body)
+			b.msgs <- &testMQTTMessage{
+				topic:   string(body[2 : 2+l]),
+				payload: body[2+l:],
+			}
+		case testMQTTPacketDisconnect:
+			return
+		default:
+			// Go on.
+		}
+	}
+}
+
+// readTestMQTTPacket reads a single MQTT packet from r.
+func readTestMQTTPacket(r *bufio.Reader) (typ byte, body []byte, err error) {
+	typ, err = r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	l, mul := 0, 1
+	for {
+		var digit byte
+		digit, err = r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		l += int(digit&0x7F) * mul
+		mul *= 128
+		if digit&0x80 == 0 {
+			break
+		}
+	}
+
+	body = make([]byte, l)
+	_, err = io.ReadFull(r, body)
+
+	return typ, body, err
+}
+
+// testLeases is a mutable set of leases for tests.
+type testLeases struct {
+	mu     *sync.Mutex
+	leases []*dhcpsvc.Lease
+}
+
+// set replaces the leases.
+func (tl *testLeases) set(leases ...*dhcpsvc.Lease) {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	tl.leases = leases
+}
+
+// get returns the current leases.
+func (tl *testLeases) get() (leases []*dhcpsvc.Lease) {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	return tl.leases
+}
+
+func TestMQTTNotifier(t *testing.T) {
+	b, addr := startMQTTBroker(t)
+
+	tl := &testLeases{mu: &sync.Mutex{}}
+	n := newMQTTNotifier(&ServerConfig{
+		MQTTBroker:   addr,
+		MQTTTopic:    testMQTTTopic,
+		MQTTClientID: "test",
+	}, tl.get)
+	n.opts.SetConnectRetryInterval(10 * time.Millisecond)
+
+	n.start()
+	t.Cleanup(n.stop)
+
+	expiry := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	dynLease := &dhcpsvc.Lease{
+		IP:       netip.MustParseAddr("192.0.2.10"),
+		Expiry:   expiry,
+		Hostname: "host",
+		HWAddr:   net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+	}
+
+	requireEvent := func(t *testing.T, wantEvent string, want *mqttLeaseJSON) {
+		t.Helper()
+
+		msg, _ := testutil.RequireReceive(t, b.msgs, testTimeout)
+		assert.Equal(t, testMQTTTopic, msg.topic)
+
+		got := &mqttEventJSON{}
+		require.NoError(t, json.Unmarshal(msg.payload, got))
+
+		assert.Equal(t, wantEvent, got.Event)
+		assert.Equal(t, want, got.Lease)
+	}
+
+	t.Run("add", func(t *testing.T) {
+		tl.set(dynLease)
+		n.onLeaseChanged(LeaseChangedAdded)
+
+		requireEvent(t, mqttEventAdd, &mqttLeaseJSON{
+			IP:       "192.0.2.10",
+			HWAddr:   "00:11:22:33:44:55",
+			Hostname: "host",
+			Expiry:   "2025-01-01T00:00:00Z",
+		})
+	})
+
+	t.Run("update", func(t *testing.T) {
+		statLease := dynLease.Clone()
+		statLease.IsStatic = true
+		statLease.Hostname = "static-host"
+
+		tl.set(statLease)
+		n.onLeaseChanged(LeaseChangedAddedStatic)
+
+		requireEvent(t, mqttEventUpdate, &mqttLeaseJSON{
+			IP:       "192.0.2.10",
+			HWAddr:   "00:11:22:33:44:55",
+			Hostname: "static-host",
+			IsStatic: true,
+		})
+	})
+
+	t.Run("expire", func(t *testing.T) {
+		tl.set()
+		n.onLeaseChanged(LeaseChangedRemovedStatic)
+
+		requireEvent(t, mqttEventExpire, &mqttLeaseJSON{
+			IP:       "192.0.2.10",
+			HWAddr:   "00:11:22:33:44:55",
+			Hostname: "static-host",
+			IsStatic: true,
+		})
+	})
+
+	t.Run("reconnect", func(t *testing.T) {
+		conn, _ := testutil.RequireReceive(t, b.conns, testTimeout)
+		require.NoError(t, conn.Close())
+
+		_, _ = testutil.RequireReceive(t, b.conns, testTimeout)
+
+		tl.set(dynLease)
+		n.onLeaseChanged(LeaseChangedAdded)
+
+		requireEvent(t, mqttEventAdd, &mqttLeaseJSON{
+			IP:       "192.0.2.10",
+			HWAddr:   "00:11:22:33:44:55",
+			Hostname: "host",
+			Expiry:   "2025-01-01T00:00:00Z",
+		})
+	})
+}
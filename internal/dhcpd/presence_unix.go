@@ -0,0 +1,162 @@
+//go:build darwin || freebsd || linux || openbsd
+
+package dhcpd
+
+import (
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// defaultPresenceSweepTimeout is the default time to wait for an ICMP reply
+// during the presence sweeps.
+const defaultPresenceSweepTimeout = 1 * time.Second
+
+// maxPresenceProbes is the maximum number of the concurrent probes during a
+// presence sweep.
+const maxPresenceProbes = 16
+
+// initPresenceProber sets the prober used by the presence sweeps, if they're
+// enabled.
+func (s *v4Server) initPresenceProber() {
+	if s.conf.PresenceSweepInterval == 0 {
+		return
+	}
+
+	timeout := time.Duration(s.conf.PresenceSweepTimeout) * time.Millisecond
+	if timeout == 0 {
+		timeout = defaultPresenceSweepTimeout
+	}
+
+	s.presenceProber = &icmpProber{
+		timeout: timeout,
+	}
+}
+
+// runPresenceSweeps sweeps the active dynamic leases every ivl until done is
+// closed.  Each sweep must finish within ivl, so the sweeps never overlap.  It
+// is intended to be used as a goroutine.  wg is marked done on return.
+func (s *v4Server) runPresenceSweeps(done <-chan struct{}, wg *sync.WaitGroup, ivl time.Duration) {
+	defer wg.Done()
+	defer log.OnPanic("dhcpv4: presence sweep")
+
+	ticker := time.NewTicker(ivl)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			s.sweepPresence(done, time.Now().Add(ivl))
+		}
+	}
+}
+
+// sweepPresence probes the clients of the active dynamic leases, at most
+// [maxPresenceProbes] at a time, and updates their online statuses.  The probes
+// are sent without holding s.leasesLock.  No more probes are sent once done is
+// closed or deadline has passed, and the statuses of the clients not probed
+// are kept.  Nothing is updated if done is closed.
+func (s *v4Server) sweepPresence(done <-chan struct{}, deadline time.Time) {
+	ips := s.activeDynamicIPs()
+
+	online := make(map[netip.Addr]bool, len(ips))
+	mu := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
+	sem := make(chan struct{}, maxPresenceProbes)
+	for i, ip := range ips {
+		if !acquireProbe(done, sem, deadline) {
+			log.Debug("dhcpv4: presence sweep: %d of %d leases probed", i, len(ips))
+
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			defer log.OnPanic("dhcpv4: presence probe")
+
+			inUse, err := s.presenceProber.probe(ip)
+			if err != nil {
+				log.Debug("dhcpv4: presence probe for %s: %s", ip, err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			online[ip] = inUse
+		}()
+	}
+
+	wg.Wait()
+
+	select {
+	case <-done:
+		return
+	default:
+		// Go on.
+	}
+
+	s.leasesLock.Lock()
+	defer s.leasesLock.Unlock()
+
+	for ip, isOnline := range online {
+		// The lease may have been removed or replaced while probing.
+		l := s.ipIndex[ip]
+		if l == nil || l.IsStatic || l.Online == isOnline {
+			continue
+		}
+
+		log.Debug("dhcpv4: lease %s for %s: online: %t", ip, l.HWAddr, isOnline)
+
+		l.Online = isOnline
+	}
+}
+
+// acquireProbe blocks until a slot in sem is free and acquires it.  ok is false
+// if done is closed or deadline has passed before that.
+func acquireProbe(done <-chan struct{}, sem chan<- struct{}, deadline time.Time) (ok bool) {
+	select {
+	case <-done:
+		return false
+	default:
+		// Go on.
+	}
+
+	ivl := time.Until(deadline)
+	if ivl <= 0 {
+		return false
+	}
+
+	timer := time.NewTimer(ivl)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		return false
+	case <-timer.C:
+		return false
+	case sem <- struct{}{}:
+		return true
+	}
+}
+
+// activeDynamicIPs returns the IP addresses of the dynamic leases, which
+// haven't expired yet.
+func (s *v4Server) activeDynamicIPs() (ips []netip.Addr) {
+	s.leasesLock.Lock()
+	defer s.leasesLock.Unlock()
+
+	now := time.Now()
+	for _, l := range s.leases {
+		if !l.IsStatic && l.Expiry.After(now) && !s.isBlocklisted(l) {
+			ips = append(ips, l.IP)
+		}
+	}
+
+	return ips
+}
@@ -0,0 +1,169 @@
+package dhcpd
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/dhcpsvc"
+	"github.com/AdguardTeam/golibs/container"
+	"github.com/AdguardTeam/golibs/errors"
+)
+
+// RelayConfig is the configuration of relaying the DHCPv4 messages of the
+// clients on a network interface to an upstream DHCP server.
+type RelayConfig struct {
+	// Interface is the name of the network interface to relay the messages
+	// from.  It must not be the interface served by AdGuard Home.
+	Interface string `yaml:"interface"`
+
+	// Server is the IPv4 address of the upstream DHCP server.
+	Server netip.Addr `yaml:"server"`
+}
+
+// validate returns an error if c isn't a valid relay configuration.
+func (c *RelayConfig) validate() (err error) {
+	if c == nil {
+		return errNilConfig
+	}
+
+	if c.Interface == "" {
+		return fmt.Errorf("interface: %w", errors.ErrEmptyValue)
+	}
+
+	srv := c.Server.Unmap()
+	bcast := netip.AddrFrom4([4]byte{255, 255, 255, 255})
+	if !srv.Is4() || srv.IsUnspecified() || srv.IsMulticast() || srv == bcast {
+		return fmt.Errorf("server: %v is not a unicast ipv4 address", c.Server)
+	}
+
+	c.Server = srv
+
+	return nil
+}
+
+// validateRelays returns an error if relays aren't valid.  servedIface is the
+// name of the interface served by AdGuard Home, if the server is enabled, and
+// it can't be used for relaying.
+func validateRelays(relays []*RelayConfig, servedIface string) (err error) {
+	ifaces := container.NewMapSet[string]()
+	for i, r := range relays {
+		err = r.validate()
+		if err != nil {
+			return fmt.Errorf("relay at index %d: %w", i, err)
+		}
+
+		if r.Interface == servedIface {
+			return fmt.Errorf(
+				"relay at index %d: interface %q is served by the dhcp server",
+				i,
+				r.Interface,
+			)
+		} else if ifaces.Has(r.Interface) {
+			return fmt.Errorf("relay at index %d: duplicate interface %q", i, r.Interface)
+		}
+
+		ifaces.Add(r.Interface)
+	}
+
+	return nil
+}
+
+// relayLeases is the read-only view of the bindings made by the upstream DHCP
+// servers for the relayed clients.  It's safe for concurrent use.
+type relayLeases struct {
+	// mu protects leases.
+	mu *sync.RWMutex
+
+	// leases are the recorded bindings by the string form of the hardware
+	// address.
+	leases map[string]*dhcpsvc.Lease
+
+	// now returns the current time.  It's replaced in tests.
+	now func() (t time.Time)
+}
+
+// newRelayLeases returns a new properly initialized *relayLeases.
+func newRelayLeases() (rl *relayLeases) {
+	return &relayLeases{
+		mu:     &sync.RWMutex{},
+		leases: map[string]*dhcpsvc.Lease{},
+		now:    time.Now,
+	}
+}
+
+// set records the binding of ip to mac for dur.  It returns true if the
+// recorded bindings have been changed.
+func (rl *relayLeases) set(
+	mac net.HardwareAddr,
+	ip netip.Addr,
+	hostname string,
+	dur time.Duration,
+) (changed bool) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	key := mac.String()
+	prev, ok := rl.leases[key]
+	changed = !ok || prev.IP != ip || prev.Hostname != hostname
+
+	rl.leases[key] = &dhcpsvc.Lease{
+		IP:            ip,
+		Expiry:        rl.now().Add(dur),
+		Hostname:      hostname,
+		HWAddr:        slices.Clone(mac),
+		LeaseDuration: dur,
+	}
+
+	return changed
+}
+
+// remove removes the binding for mac.  It returns true if there has been one.
+func (rl *relayLeases) remove(mac net.HardwareAddr) (ok bool) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	key := mac.String()
+	_, ok = rl.leases[key]
+	delete(rl.leases, key)
+
+	return ok
+}
+
+// all returns the clones of the unexpired bindings.
+func (rl *relayLeases) all() (leases []*dhcpsvc.Lease) {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+
+	now := rl.now()
+	for _, l := range rl.leases {
+		if l.Expiry.After(now) {
+			leases = append(leases, l.Clone())
+		}
+	}
+
+	slices.SortFunc(leases, func(a, b *dhcpsvc.Lease) (res int) {
+		return a.IP.Compare(b.IP)
+	})
+
+	return leases
+}
+
+// find returns the unexpired binding for ip, if any.  The returned lease must
+// not be modified.
+func (rl *relayLeases) find(ip netip.Addr) (l *dhcpsvc.Lease) {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+
+	now := rl.now()
+	for _, l = range rl.leases {
+		if l.IP == ip && l.Expiry.After(now) {
+			return l
+		}
+	}
+
+	return nil
+}
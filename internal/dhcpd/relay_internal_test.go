@@ -0,0 +1,109 @@
+package dhcpd
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateRelays(t *testing.T) {
+	const servedIface = "eth0"
+
+	srvAddr := netip.MustParseAddr("192.0.2.1")
+
+	testCases := []struct {
+		name       string
+		wantErrMsg string
+		relays     []*RelayConfig
+	}{{
+		name:       "success",
+		wantErrMsg: "",
+		relays: []*RelayConfig{{
+			Interface: "eth1",
+			Server:    srvAddr,
+		}, {
+			Interface: "eth2",
+			Server:    netip.MustParseAddr("::ffff:192.0.2.2"),
+		}},
+	}, {
+		name:       "nil",
+		wantErrMsg: "relay at index 0: nil config",
+		relays:     []*RelayConfig{nil},
+	}, {
+		name:       "no_interface",
+		wantErrMsg: "relay at index 0: interface: empty value",
+		relays: []*RelayConfig{{
+			Interface: "",
+			Server:    srvAddr,
+		}},
+	}, {
+		name:       "ipv6_server",
+		wantErrMsg: "relay at index 0: server: 2001:db8::1 is not a unicast ipv4 address",
+		relays: []*RelayConfig{{
+			Interface: "eth1",
+			Server:    netip.MustParseAddr("2001:db8::1"),
+		}},
+	}, {
+		name:       "broadcast_server",
+		wantErrMsg: "relay at index 0: server: 255.255.255.255 is not a unicast ipv4 address",
+		relays: []*RelayConfig{{
+			Interface: "eth1",
+			Server:    netip.MustParseAddr("255.255.255.255"),
+		}},
+	}, {
+		name:       "served",
+		wantErrMsg: `relay at index 0: interface "eth0" is served by the dhcp server`,
+		relays: []*RelayConfig{{
+			Interface: servedIface,
+			Server:    srvAddr,
+		}},
+	}, {
+		name:       "duplicate",
+		wantErrMsg: `relay at index 1: duplicate interface "eth1"`,
+		relays: []*RelayConfig{{
+			Interface: "eth1",
+			Server:    srvAddr,
+		}, {
+			Interface: "eth1",
+			Server:    srvAddr,
+		}},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateRelays(tc.relays, servedIface)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
+
+func TestRelayLeases(t *testing.T) {
+	rl := newRelayLeases()
+
+	now := time.Now()
+	rl.now = func() (t time.Time) { return now }
+
+	mac := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+	ip := netip.MustParseAddr("192.0.2.100")
+
+	require.True(t, rl.set(mac, ip, "host", time.Hour))
+	require.False(t, rl.set(mac, ip, "host", time.Hour))
+
+	l := rl.find(ip)
+	require.NotNil(t, l)
+
+	assert.Equal(t, "host", l.Hostname)
+	assert.Equal(t, mac, l.HWAddr)
+
+	now = now.Add(2 * time.Hour)
+	assert.Nil(t, rl.find(ip))
+	assert.Empty(t, rl.all())
+
+	assert.True(t, rl.remove(mac))
+	assert.False(t, rl.remove(mac))
+}
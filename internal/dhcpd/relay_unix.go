@@ -0,0 +1,320 @@
+//go:build darwin || freebsd || linux || openbsd
+
+package dhcpd
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv4/server4"
+)
+
+// maxRelayHops is the maximum number of the relay agents a message may pass,
+// as recommended by RFC 1542.
+const maxRelayHops = 16
+
+// relayReadBufSize is the size of the buffer for reading the relayed messages.
+const relayReadBufSize = 4096
+
+// v4Relay relays the DHCPv4 messages of the clients on a network interface to
+// an upstream DHCP server and the replies back, recording the bindings made by
+// the server.
+type v4Relay struct {
+	// conf is the configuration of the relay.
+	conf *RelayConfig
+
+	// leases is the view of the bindings, which is updated with the
+	// acknowledgments of the upstream server.
+	leases *relayLeases
+
+	// notify is called when the bindings are changed.
+	notify func(flags int)
+
+	// wg is used to wait for the reading goroutines to finish.
+	wg *sync.WaitGroup
+
+	// hostnamesMu protects hostnames.
+	hostnamesMu *sync.Mutex
+
+	// hostnames are the hostnames sent by the clients in their latest
+	// requests by the string forms of their hardware addresses.  The servers
+	// don't necessarily send them back.
+	hostnames map[string]string
+
+	// clientConn receives the messages of the clients on the interface and
+	// sends the replies to them.
+	clientConn net.PacketConn
+
+	// serverConn exchanges the messages with the upstream server.
+	serverConn net.PacketConn
+
+	// serverAddr is the address of the upstream server.
+	serverAddr net.Addr
+
+	// clientAddr is the address the replies are sent to.
+	clientAddr net.Addr
+
+	// giaddr is the IPv4 address of the interface set as the relay agent
+	// address of the relayed messages.
+	giaddr net.IP
+}
+
+// newV4Relay returns a new properly initialized *v4Relay.  conf must be valid.
+func newV4Relay(conf *RelayConfig, leases *relayLeases, notify func(flags int)) (r *v4Relay) {
+	return &v4Relay{
+		conf:        conf,
+		leases:      leases,
+		notify:      notify,
+		wg:          &sync.WaitGroup{},
+		hostnamesMu: &sync.Mutex{},
+		hostnames:   map[string]string{},
+	}
+}
+
+// start opens the connections and starts relaying the messages.
+func (r *v4Relay) start() (err error) {
+	defer func() { err = errors.Annotate(err, "dhcpv4: relay on %s: %w", r.conf.Interface) }()
+
+	iface, err := net.InterfaceByName(r.conf.Interface)
+	if err != nil {
+		return fmt.Errorf("finding interface: %w", err)
+	}
+
+	addrs, err := aghnet.IfaceDNSIPAddrs(
+		iface,
+		aghnet.IPVersion4,
+		defaultMaxAttempts,
+		defaultBackoff,
+	)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is and there is
+		// an annotation deferred already.
+		return err
+	} else if len(addrs) == 0 {
+		return errors.Error("no ipv4 addresses")
+	}
+
+	giaddr := addrs[0]
+
+	clientConn, err := server4.NewIPv4UDPConn(iface.Name, &net.UDPAddr{
+		IP:   net.IPv4zero,
+		Port: dhcpv4.ServerPort,
+	})
+	if err != nil {
+		return fmt.Errorf("creating client connection: %w", err)
+	}
+
+	// The upstream server sends the replies to the relay agent address, so
+	// the connection isn't bound to the interface, since the server may be
+	// reachable through another one.
+	serverConn, err := server4.NewIPv4UDPConn("", &net.UDPAddr{
+		IP:   giaddr,
+		Port: dhcpv4.ServerPort,
+	})
+	if err != nil {
+		return fmt.Errorf(
+			"creating server connection: %w",
+			errors.WithDeferred(err, clientConn.Close()),
+		)
+	}
+
+	r.serve(
+		clientConn,
+		serverConn,
+		giaddr,
+		&net.UDPAddr{IP: r.conf.Server.AsSlice(), Port: dhcpv4.ServerPort},
+		&net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort},
+	)
+
+	log.Info("dhcpv4: relaying from %s to %s", r.conf.Interface, r.conf.Server)
+
+	return nil
+}
+
+// serve starts relaying the messages using the connections.  The replies are
+// always broadcast to clientAddr, since the clients may not have the addresses
+// yet.
+func (r *v4Relay) serve(
+	clientConn net.PacketConn,
+	serverConn net.PacketConn,
+	giaddr net.IP,
+	serverAddr net.Addr,
+	clientAddr net.Addr,
+) {
+	r.clientConn, r.serverConn = clientConn, serverConn
+	r.giaddr = giaddr
+	r.serverAddr, r.clientAddr = serverAddr, clientAddr
+
+	r.wg.Add(2)
+	go r.read(clientConn, r.handleClient)
+	go r.read(serverConn, r.handleServer)
+}
+
+// stop closes the connections and waits for the relaying to finish.
+func (r *v4Relay) stop() (err error) {
+	if r.clientConn == nil {
+		return nil
+	}
+
+	err = errors.Join(r.clientConn.Close(), r.serverConn.Close())
+	r.wg.Wait()
+
+	r.clientConn, r.serverConn = nil, nil
+
+	if err != nil {
+		return fmt.Errorf("dhcpv4: relay on %s: closing: %w", r.conf.Interface, err)
+	}
+
+	return nil
+}
+
+// read reads the messages from conn and handles them until conn is closed.
+// It's intended to be used as a goroutine.
+func (r *v4Relay) read(conn net.PacketConn, handle func(msg *dhcpv4.DHCPv4)) {
+	defer r.wg.Done()
+	defer log.OnPanic("dhcpv4: relay")
+
+	buf := make([]byte, relayReadBufSize)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		} else if err != nil {
+			log.Debug("dhcpv4: relay: reading: %s", err)
+
+			continue
+		}
+
+		msg, err := dhcpv4.FromBytes(buf[:n])
+		if err != nil {
+			log.Debug("dhcpv4: relay: parsing message: %s", err)
+
+			continue
+		}
+
+		handle(msg)
+	}
+}
+
+// handleClient relays the message from a client to the upstream server.
+func (r *v4Relay) handleClient(req *dhcpv4.DHCPv4) {
+	log.Debug("dhcpv4: relay: received message from client: %s", req.Summary())
+
+	if req.OpCode != dhcpv4.OpcodeBootRequest {
+		return
+	} else if req.HopCount >= maxRelayHops {
+		log.Debug("dhcpv4: relay: too many hops: %d", req.HopCount)
+
+		return
+	}
+
+	err := netutil.ValidateMAC(req.ClientHWAddr)
+	if err != nil {
+		log.Debug("dhcpv4: relay: invalid ClientHWAddr: %s", err)
+
+		return
+	}
+
+	r.inspectRequest(req)
+
+	req.HopCount++
+	if req.GatewayIPAddr == nil || req.GatewayIPAddr.IsUnspecified() {
+		req.GatewayIPAddr = r.giaddr
+	}
+
+	_, err = r.serverConn.WriteTo(req.ToBytes(), r.serverAddr)
+	if err != nil {
+		log.Error("dhcpv4: relay: sending to server: %s", err)
+	}
+}
+
+// inspectRequest remembers the hostname of the client from req and removes its
+// binding, if req releases it.
+func (r *v4Relay) inspectRequest(req *dhcpv4.DHCPv4) {
+	switch req.MessageType() {
+	case dhcpv4.MessageTypeDiscover, dhcpv4.MessageTypeRequest:
+		hostname, err := normalizeHostname(req.HostName())
+		if err != nil {
+			log.Debug("dhcpv4: relay: %s", err)
+		}
+
+		if hostname == "" {
+			return
+		}
+
+		r.hostnamesMu.Lock()
+		defer r.hostnamesMu.Unlock()
+
+		r.hostnames[req.ClientHWAddr.String()] = hostname
+	case dhcpv4.MessageTypeRelease:
+		if r.leases.remove(req.ClientHWAddr) {
+			r.notify(LeaseChangedAdded)
+		}
+	default:
+		// Go on.
+	}
+}
+
+// handleServer relays the reply of the upstream server to the client.
+func (r *v4Relay) handleServer(resp *dhcpv4.DHCPv4) {
+	log.Debug("dhcpv4: relay: received message from server: %s", resp.Summary())
+
+	if resp.OpCode != dhcpv4.OpcodeBootReply {
+		return
+	} else if !resp.GatewayIPAddr.Equal(r.giaddr) {
+		log.Debug("dhcpv4: relay: reply for another agent %s", resp.GatewayIPAddr)
+
+		return
+	}
+
+	switch resp.MessageType() {
+	case dhcpv4.MessageTypeAck:
+		r.recordBinding(resp)
+	case dhcpv4.MessageTypeNak:
+		if r.leases.remove(resp.ClientHWAddr) {
+			r.notify(LeaseChangedAdded)
+		}
+	default:
+		// Go on.
+	}
+
+	_, err := r.clientConn.WriteTo(resp.ToBytes(), r.clientAddr)
+	if err != nil {
+		log.Error("dhcpv4: relay: sending to client: %s", err)
+	}
+}
+
+// recordBinding records the binding acknowledged by resp.
+func (r *v4Relay) recordBinding(resp *dhcpv4.DHCPv4) {
+	ip, err := netutil.IPToAddr(resp.YourIPAddr, netutil.AddrFamilyIPv4)
+	if err != nil || ip.IsUnspecified() {
+		// An acknowledgment of a DHCPINFORM doesn't contain an address.
+		return
+	}
+
+	hostname, err := normalizeHostname(resp.HostName())
+	if err != nil {
+		log.Debug("dhcpv4: relay: %s", err)
+	}
+
+	mac := resp.ClientHWAddr
+	if hostname == "" {
+		r.hostnamesMu.Lock()
+		hostname = r.hostnames[mac.String()]
+		r.hostnamesMu.Unlock()
+	}
+
+	dur := resp.IPAddressLeaseTime(time.Duration(DefaultDHCPLeaseTTL) * time.Second)
+	if r.leases.set(mac, ip, hostname, dur) {
+		log.Debug("dhcpv4: relay: recorded binding of %s to %s", ip, mac)
+
+		r.notify(LeaseChangedAdded)
+	}
+}
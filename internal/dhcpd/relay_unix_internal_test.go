@@ -0,0 +1,155 @@
+//go:build darwin || freebsd || linux || openbsd
+
+package dhcpd
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testRelayTimeout is the common timeout for the relay tests.
+const testRelayTimeout = 1 * time.Second
+
+// newLocalPacketConn returns a new UDP connection listening on the loopback
+// interface and closes it on cleanup.
+func newLocalPacketConn(t testing.TB) (conn net.PacketConn) {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, conn.Close)
+
+	return conn
+}
+
+// sendMsg sends msg from conn to addr.
+func sendMsg(t testing.TB, conn net.PacketConn, addr net.Addr, msg *dhcpv4.DHCPv4) {
+	t.Helper()
+
+	_, err := conn.WriteTo(msg.ToBytes(), addr)
+	require.NoError(t, err)
+}
+
+// receiveMsg receives a message from conn.
+func receiveMsg(t testing.TB, conn net.PacketConn) (msg *dhcpv4.DHCPv4) {
+	t.Helper()
+
+	err := conn.SetReadDeadline(time.Now().Add(testRelayTimeout))
+	require.NoError(t, err)
+
+	buf := make([]byte, relayReadBufSize)
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+
+	msg, err = dhcpv4.FromBytes(buf[:n])
+	require.NoError(t, err)
+
+	return msg
+}
+
+func TestV4Relay(t *testing.T) {
+	var (
+		giaddr = net.IP{192, 0, 2, 1}
+		yiaddr = net.IP{192, 0, 2, 100}
+		mac    = net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+	)
+
+	notifyCh := make(chan struct{}, 1)
+	leases := newRelayLeases()
+	r := newV4Relay(&RelayConfig{
+		Interface: "eth1",
+		Server:    netip.MustParseAddr("192.0.2.2"),
+	}, leases, func(_ int) {
+		testutil.RequireSend(testutil.PanicT{}, notifyCh, struct{}{}, testRelayTimeout)
+	})
+
+	// cliConn is the connection of the client and upConn is the one of the
+	// upstream server.
+	cliConn, upConn := newLocalPacketConn(t), newLocalPacketConn(t)
+
+	// The connections of the relay are closed by it.
+	relayCliConn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	relaySrvConn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	r.serve(relayCliConn, relaySrvConn, giaddr, upConn.LocalAddr(), cliConn.LocalAddr())
+	testutil.CleanupAndRequireSuccess(t, r.stop)
+
+	discover, err := dhcpv4.NewDiscovery(mac, dhcpv4.WithOption(dhcpv4.OptHostName("Laptop")))
+	require.NoError(t, err)
+
+	sendMsg(t, cliConn, relayCliConn.LocalAddr(), discover)
+
+	relayed := receiveMsg(t, upConn)
+	require.Equal(t, discover.TransactionID, relayed.TransactionID)
+
+	assert.Equal(t, giaddr, relayed.GatewayIPAddr.To4())
+	assert.Equal(t, uint8(1), relayed.HopCount)
+
+	offer, err := dhcpv4.NewReplyFromRequest(
+		relayed,
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeOffer),
+		dhcpv4.WithYourIP(yiaddr),
+	)
+	require.NoError(t, err)
+
+	sendMsg(t, upConn, relaySrvConn.LocalAddr(), offer)
+
+	relayed = receiveMsg(t, cliConn)
+	require.Equal(t, dhcpv4.MessageTypeOffer, relayed.MessageType())
+
+	assert.Equal(t, yiaddr, relayed.YourIPAddr.To4())
+
+	request, err := dhcpv4.NewRequestFromOffer(relayed)
+	require.NoError(t, err)
+
+	// The relay agent address of the offer is copied into the request.
+	request.GatewayIPAddr = net.IPv4zero
+	sendMsg(t, cliConn, relayCliConn.LocalAddr(), request)
+
+	relayed = receiveMsg(t, upConn)
+	require.Equal(t, dhcpv4.MessageTypeRequest, relayed.MessageType())
+
+	ack, err := dhcpv4.NewReplyFromRequest(
+		relayed,
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeAck),
+		dhcpv4.WithYourIP(yiaddr),
+		dhcpv4.WithLeaseTime(uint32(time.Hour.Seconds())),
+	)
+	require.NoError(t, err)
+
+	sendMsg(t, upConn, relaySrvConn.LocalAddr(), ack)
+
+	relayed = receiveMsg(t, cliConn)
+	require.Equal(t, dhcpv4.MessageTypeAck, relayed.MessageType())
+
+	testutil.RequireReceive(t, notifyCh, testRelayTimeout)
+
+	got := leases.all()
+	require.Len(t, got, 1)
+
+	assert.Equal(t, netip.AddrFrom4([4]byte(yiaddr)), got[0].IP)
+	assert.Equal(t, mac, got[0].HWAddr)
+	assert.Equal(t, "laptop", got[0].Hostname)
+	assert.Equal(t, time.Hour, got[0].LeaseDuration)
+
+	release, err := dhcpv4.NewReleaseFromACK(relayed)
+	require.NoError(t, err)
+
+	sendMsg(t, cliConn, relayCliConn.LocalAddr(), release)
+
+	relayed = receiveMsg(t, upConn)
+	require.Equal(t, dhcpv4.MessageTypeRelease, relayed.MessageType())
+
+	testutil.RequireReceive(t, notifyCh, testRelayTimeout)
+	assert.Empty(t, leases.all())
+}
@@ -0,0 +1,19 @@
+//go:build windows
+
+package dhcpd
+
+import "github.com/AdguardTeam/AdGuardHome/internal/aghos"
+
+// v4Relay is the stub of the DHCPv4 relay, which isn't supported on Windows.
+type v4Relay struct{}
+
+// newV4Relay returns a new *v4Relay.
+func newV4Relay(_ *RelayConfig, _ *relayLeases, _ func(flags int)) (r *v4Relay) {
+	return &v4Relay{}
+}
+
+// start always returns an error, since relaying isn't supported on Windows.
+func (*v4Relay) start() (err error) { return aghos.Unsupported("dhcp relay") }
+
+// stop does nothing.
+func (*v4Relay) stop() (err error) { return nil }
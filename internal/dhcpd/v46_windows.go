@@ -25,10 +25,19 @@ func (winServer) UpdateStaticLease(_ *dhcpsvc.Lease) (err error)       { return
 func (winServer) FindMACbyIP(_ netip.Addr) (mac net.HardwareAddr)      { return nil }
 func (winServer) WriteDiskConfig4(_ *V4ServerConf)                     {}
 func (winServer) WriteDiskConfig6(_ *V6ServerConf)                     {}
+func (winServer) ReloadFingerprints() (err error)                      { return nil }
+func (winServer) NAKCounts() (counts map[string]uint64)                { return nil }
 func (winServer) Start() (err error)                                   { return nil }
 func (winServer) Stop() (err error)                                    { return nil }
 func (winServer) HostByIP(_ netip.Addr) (host string)                  { return "" }
 func (winServer) IPByHost(_ string) (ip netip.Addr)                    { return netip.Addr{} }
 
+func (winServer) MakeStaticLease(
+	_ netip.Addr,
+	_ net.HardwareAddr,
+) (l *dhcpsvc.Lease, err error) {
+	return nil, nil
+}
+
 func v4Create(_ *V4ServerConf) (s DHCPServer, err error) { return winServer{}, nil }
 func v6Create(_ V6ServerConf) (s DHCPServer, err error)  { return winServer{}, nil }
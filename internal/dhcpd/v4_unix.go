@@ -10,6 +10,7 @@ import (
 	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
@@ -20,9 +21,6 @@ import (
 	"github.com/AdguardTeam/golibs/timeutil"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv4/server4"
-
-	//lint:ignore SA1019 See the TODO in go.mod.
-	"github.com/go-ping/ping"
 )
 
 // v4Server is a DHCPv4 server.
@@ -44,7 +42,13 @@ type v4Server struct {
 	// leasesLock protects leases, hostsIndex, ipIndex, and leasedOffsets.
 	leasesLock sync.Mutex
 
-	// leasedOffsets contains offsets from conf.ipRange.start that have been
+	// nakCountsLock protects nakCounts.
+	nakCountsLock sync.Mutex
+
+	// nakCounts are the numbers of the DHCPNAK messages sent for each reason.
+	nakCounts map[nakReason]uint64
+
+	// leasedOffsets contains offsets within conf.ipRanges that have been
 	// leased.
 	leasedOffsets *bitSet
 
@@ -56,6 +60,35 @@ type v4Server struct {
 
 	// ipIndex is an index of leases by their IP addresses.
 	ipIndex map[netip.Addr]*dhcpsvc.Lease
+
+	// icmpProber detects the address conflicts using ICMP echo requests.
+	icmpProber addrProber
+
+	// arpProber detects the address conflicts using ARP probes.  It's nil
+	// unless conflictDetection requires it.
+	arpProber addrProber
+
+	// presenceProber checks whether the clients of the active dynamic leases
+	// are online.  It's nil if the presence sweep is disabled.
+	presenceProber addrProber
+
+	// presenceDone is closed to stop the presence sweeps.  It's nil if the
+	// server isn't running or the presence sweep is disabled.
+	presenceDone chan struct{}
+
+	// presenceWG is used to wait for the presence sweeps to stop, so that the
+	// sweeps of a restarted server don't overlap with the previous ones.
+	presenceWG sync.WaitGroup
+
+	// fingerprints is the database of the DHCP fingerprints used to detect the
+	// operating systems of the clients.  It's nil if the detection is
+	// disabled.
+	fingerprints atomic.Pointer[fingerprintDB]
+
+	// conflictDetection is the effective address conflict detection method.
+	// It may differ from the configured one, if the latter isn't supported on
+	// the current platform.
+	conflictDetection string
 }
 
 func (s *v4Server) enabled() (ok bool) {
@@ -73,6 +106,24 @@ func (s *v4Server) WriteDiskConfig4(c *V4ServerConf) {
 func (s *v4Server) WriteDiskConfig6(c *V6ServerConf) {
 }
 
+// ReloadFingerprints implements the [DHCPServer] interface for *v4Server.
+func (s *v4Server) ReloadFingerprints() (err error) {
+	if s.conf == nil || s.conf.FingerprintDB == "" {
+		return nil
+	}
+
+	db, err := loadFingerprintDB(s.conf.FingerprintDB)
+	if err != nil {
+		return fmt.Errorf("dhcpv4: loading fingerprints from %q: %w", s.conf.FingerprintDB, err)
+	}
+
+	s.fingerprints.Store(db)
+
+	log.Info("dhcpv4: loaded %d fingerprints", len(db.osNames))
+
+	return nil
+}
+
 // normalizeHostname normalizes a hostname sent by the client.  If err is not
 // nil, norm is an empty string.
 func normalizeHostname(hostname string) (norm string, err error) {
@@ -270,7 +321,7 @@ func (s *v4Server) rmLeaseByIndex(i int) {
 	l := s.leases[i]
 	s.leases = append(s.leases[:i], s.leases[i+1:]...)
 
-	r := s.conf.ipRange
+	r := s.conf.ipRanges
 	leaseIP := net.IP(l.IP.AsSlice())
 	offset, ok := r.offset(leaseIP)
 	if ok {
@@ -324,7 +375,7 @@ const (
 
 // addLease adds a dynamic or static lease.
 func (s *v4Server) addLease(l *dhcpsvc.Lease) (err error) {
-	r := s.conf.ipRange
+	r := s.conf.ipRanges
 	leaseIP := net.IP(l.IP.AsSlice())
 	offset, inOffset := r.offset(leaseIP)
 
@@ -349,7 +400,9 @@ func (s *v4Server) addLease(l *dhcpsvc.Lease) (err error) {
 	s.ipIndex[l.IP] = l
 
 	s.leases = append(s.leases, l)
-	s.leasedOffsets.set(offset, true)
+	if inOffset {
+		s.leasedOffsets.set(offset, true)
+	}
 
 	return nil
 }
@@ -388,6 +441,29 @@ func (s *v4Server) AddStaticLease(l *dhcpsvc.Lease) (err error) {
 		return ErrUnconfigured
 	}
 
+	err = s.prepareStaticLease(l)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	s.leasesLock.Lock()
+	err = s.updateStaticLease(l)
+	s.leasesLock.Unlock()
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	s.conf.notify(LeaseChangedDBStore)
+	s.conf.notify(LeaseChangedAddedStatic)
+
+	return nil
+}
+
+// prepareStaticLease validates the static lease l before adding it and
+// normalizes its fields.
+func (s *v4Server) prepareStaticLease(l *dhcpsvc.Lease) (err error) {
 	l.IP = l.IP.Unmap()
 
 	if !l.IP.Is4() {
@@ -417,23 +493,14 @@ func (s *v4Server) AddStaticLease(l *dhcpsvc.Lease) (err error) {
 		}
 
 		// Don't check for hostname uniqueness, since we try to emulate dnsmasq
-		// here, which means that rmDynamicLease below will simply empty the
-		// hostname of the dynamic lease if there even is one.  In case a static
-		// lease with the same name already exists, addLease will return an
-		// error and the lease won't be added.
+		// here, which means that rmDynamicLease in updateStaticLease will simply
+		// empty the hostname of the dynamic lease if there even is one.  In
+		// case a static lease with the same name already exists, addLease will
+		// return an error and the lease won't be added.
 
 		l.Hostname = hostname
 	}
 
-	err = s.updateStaticLease(l)
-	if err != nil {
-		// Don't wrap the error, because it's informative enough as is.
-		return err
-	}
-
-	s.conf.notify(LeaseChangedDBStore)
-	s.conf.notify(LeaseChangedAddedStatic)
-
 	return nil
 }
 
@@ -453,6 +520,12 @@ func (s *v4Server) UpdateStaticLease(l *dhcpsvc.Lease) (err error) {
 	s.leasesLock.Lock()
 	defer s.leasesLock.Unlock()
 
+	return s.replaceStaticLease(l)
+}
+
+// replaceStaticLease replaces the static lease with the same MAC address as l
+// with l.  s.leasesLock is expected to be locked.
+func (s *v4Server) replaceStaticLease(l *dhcpsvc.Lease) (err error) {
 	found := s.findLease(l.HWAddr)
 	if found == nil {
 		return fmt.Errorf("can't find lease %s", l.HWAddr)
@@ -476,6 +549,90 @@ func (s *v4Server) UpdateStaticLease(l *dhcpsvc.Lease) (err error) {
 	return nil
 }
 
+// Errors returned by MakeStaticLease.
+const (
+	// errLeaseNotFound is returned when there is no lease with the requested
+	// address.
+	errLeaseNotFound errors.Error = "lease not found"
+
+	// errLeaseIsStatic is returned when the requested lease is already static.
+	errLeaseIsStatic errors.Error = "lease is already static"
+)
+
+// MakeStaticLease implements the [DHCPServer] interface for *v4Server.  It is
+// safe for concurrent use.
+func (s *v4Server) MakeStaticLease(
+	ip netip.Addr,
+	mac net.HardwareAddr,
+) (l *dhcpsvc.Lease, err error) {
+	defer func() { err = errors.Annotate(err, "dhcpv4: making static lease: %w") }()
+
+	if s.conf == nil {
+		return nil, ErrUnconfigured
+	}
+
+	s.leasesLock.Lock()
+	l, err = s.makeStaticLease(ip.Unmap(), mac)
+	s.leasesLock.Unlock()
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	s.conf.notify(LeaseChangedDBStore)
+	s.conf.notify(LeaseChangedAddedStatic)
+
+	return l, nil
+}
+
+// makeStaticLease replaces the dynamic lease with ip or, if ip is not valid,
+// with mac by a static one with the same properties and returns its clone.
+// The whole conversion is made under the lock, so that the lease can't be
+// renewed in between.  s.leasesLock is expected to be locked.
+func (s *v4Server) makeStaticLease(
+	ip netip.Addr,
+	mac net.HardwareAddr,
+) (l *dhcpsvc.Lease, err error) {
+	var found *dhcpsvc.Lease
+	if ip.IsValid() {
+		found = s.ipIndex[ip]
+	} else {
+		found = s.findLease(mac)
+	}
+
+	if found == nil {
+		return nil, errLeaseNotFound
+	} else if found.IsStatic {
+		return nil, errLeaseIsStatic
+	}
+
+	l = &dhcpsvc.Lease{
+		HWAddr:   slices.Clone(found.HWAddr),
+		IP:       found.IP,
+		Hostname: found.Hostname,
+		OSName:   found.OSName,
+		IsStatic: true,
+	}
+
+	if l.Hostname == "" {
+		l.Hostname = aghnet.GenerateHostname(l.IP)
+	}
+
+	err = s.validateStaticLease(l)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	err = s.updateStaticLease(l)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	return l.Clone(), nil
+}
+
 // validateStaticLease returns an error if the static lease is invalid.
 func (s *v4Server) validateStaticLease(l *dhcpsvc.Lease) (err error) {
 	hostname, err := normalizeHostname(l.Hostname)
@@ -513,11 +670,8 @@ func (s *v4Server) validateStaticLease(l *dhcpsvc.Lease) (err error) {
 }
 
 // updateStaticLease safe removes dynamic lease with the same properties and
-// then adds a static lease l.
+// then adds a static lease l.  s.leasesLock is expected to be locked.
 func (s *v4Server) updateStaticLease(l *dhcpsvc.Lease) (err error) {
-	s.leasesLock.Lock()
-	defer s.leasesLock.Unlock()
-
 	err = s.rmDynamicLease(l)
 	if err != nil {
 		return fmt.Errorf("removing dynamic leases for %s (%s): %w", l.IP, l.HWAddr, err)
@@ -539,13 +693,10 @@ func (s *v4Server) RemoveStaticLease(l *dhcpsvc.Lease) (err error) {
 		return ErrUnconfigured
 	}
 
-	if !l.IP.Is4() {
-		return fmt.Errorf("invalid IP")
-	}
-
-	err = netutil.ValidateMAC(l.HWAddr)
+	err = validateRemovedLease(l)
 	if err != nil {
-		return fmt.Errorf("validating lease: %w", err)
+		// Don't wrap the error, because it's informative enough as is.
+		return err
 	}
 
 	defer func() {
@@ -563,49 +714,47 @@ func (s *v4Server) RemoveStaticLease(l *dhcpsvc.Lease) (err error) {
 	return s.rmLease(l)
 }
 
-// addrAvailable sends an ICP request to the specified IP address.  It returns
-// true if the remote host doesn't reply, which probably means that the IP
-// address is available.
-//
-// TODO(a.garipov): I'm not sure that this is the best way to do this.
-func (s *v4Server) addrAvailable(target net.IP) (avail bool) {
-	if s.conf.ICMPTimeout == 0 {
-		return true
+// validateRemovedLease returns an error if l can't describe a static lease to
+// remove.
+func validateRemovedLease(l *dhcpsvc.Lease) (err error) {
+	if !l.IP.Is4() {
+		return fmt.Errorf("invalid IP")
 	}
 
-	pinger, err := ping.NewPinger(target.String())
+	err = netutil.ValidateMAC(l.HWAddr)
 	if err != nil {
-		log.Error("dhcpv4: ping.NewPinger(): %s", err)
-
-		return true
-	}
-
-	pinger.SetPrivileged(true)
-	pinger.Timeout = time.Duration(s.conf.ICMPTimeout) * time.Millisecond
-	pinger.Count = 1
-	reply := false
-	pinger.OnRecv = func(_ *ping.Packet) {
-		reply = true
+		return fmt.Errorf("validating lease: %w", err)
 	}
 
-	log.Debug("dhcpv4: sending icmp echo to %s", target)
-
-	err = pinger.Run()
-	if err != nil {
-		log.Error("dhcpv4: pinger.Run(): %s", err)
+	return nil
+}
 
+// addrAvailable probes the specified IP address using the configured conflict
+// detection method.  It returns true if the remote host doesn't reply, which
+// probably means that the IP address is available.
+//
+// TODO(a.garipov): I'm not sure that this is the best way to do this.
+func (s *v4Server) addrAvailable(target netip.Addr) (avail bool) {
+	if s.conf.ICMPTimeout == 0 {
 		return true
 	}
 
-	if reply {
-		log.Info("dhcpv4: ip conflict: %s is already used by another device", target)
-
-		return false
+	switch s.conflictDetection {
+	case ConflictDetectionPing:
+		avail = !probeAddr(s.icmpProber, "icmp", target)
+	case ConflictDetectionARP:
+		avail = !probeAddr(s.arpProber, "arp", target)
+	case ConflictDetectionBoth:
+		avail = !probeAddr(s.icmpProber, "icmp", target) &&
+			!probeAddr(s.arpProber, "arp", target)
+	default:
+		// ConflictDetectionNone.
+		return true
 	}
 
-	log.Debug("dhcpv4: icmp procedure is complete: %q", target)
+	log.Debug("dhcpv4: conflict detection for %s is complete: available: %t", target, avail)
 
-	return true
+	return avail
 }
 
 // findLease finds a lease by its MAC-address.
@@ -621,7 +770,7 @@ func (s *v4Server) findLease(mac net.HardwareAddr) (l *dhcpsvc.Lease) {
 
 // nextIP generates a new free IP.
 func (s *v4Server) nextIP() (ip net.IP) {
-	r := s.conf.ipRange
+	r := s.conf.ipRanges
 	ip = r.find(func(next net.IP) (ok bool) {
 		offset, ok := r.offset(next)
 		if !ok {
@@ -639,7 +788,7 @@ func (s *v4Server) nextIP() (ip net.IP) {
 func (s *v4Server) findExpiredLease() int {
 	now := time.Now()
 	for i, lease := range s.leases {
-		if !lease.IsStatic && lease.Expiry.Before(now) {
+		if lease.State(s.leaseTime(lease), now) == dhcpsvc.LeaseStateExpired {
 			return i
 		}
 	}
@@ -718,11 +867,14 @@ func (s *v4Server) allocateLease(mac net.HardwareAddr) (l *dhcpsvc.Lease, err er
 		if err != nil {
 			return nil, fmt.Errorf("reserving a lease: %w", err)
 		} else if l == nil {
+			if s.conf.poolExhausted != nil {
+				s.conf.poolExhausted()
+			}
+
 			return nil, nil
 		}
 
-		leaseIP := l.IP.AsSlice()
-		if s.addrAvailable(leaseIP) {
+		if s.addrAvailable(l.IP) {
 			return l, nil
 		}
 
@@ -821,12 +973,92 @@ func (s *v4Server) checkLease(mac net.HardwareAddr, ip net.IP) (l *dhcpsvc.Lease
 	return nil, false
 }
 
+// requestState is the state of the client, in which it sends a DHCPREQUEST.
+//
+// See https://datatracker.ietf.org/doc/html/rfc2131#section-4.3.2.
+type requestState string
+
+// Valid request states.
+const (
+	requestStateSelecting  requestState = "selecting"
+	requestStateInitReboot requestState = "init-reboot"
+	requestStateRenewing   requestState = "renewing"
+)
+
+// nakReason is the reason of replying to a DHCPREQUEST with a DHCPNAK.
+type nakReason string
+
+// Valid NAK reasons.
+const (
+	// nakReasonWrongSubnet means that the requested address doesn't belong to
+	// the subnet of the server, for example, because the client has moved to
+	// another network.
+	nakReasonWrongSubnet nakReason = "wrong_subnet"
+
+	// nakReasonLeaseMismatch means that the client has a lease for another
+	// address.
+	nakReasonLeaseMismatch nakReason = "lease_mismatch"
+
+	// nakReasonNoOffer means that the address requested in response to the
+	// DHCPOFFER isn't reserved for the client.
+	nakReasonNoOffer nakReason = "no_offer"
+)
+
+// message returns the explanation of r sent to the client in the Message
+// option.
+func (r nakReason) message() (msg string) {
+	switch r {
+	case nakReasonWrongSubnet:
+		return "requested address is not on the network of the server"
+	case nakReasonLeaseMismatch:
+		return "requested address is not leased to the client"
+	case nakReasonNoOffer:
+		return "requested address is not offered to the client"
+	default:
+		return string(r)
+	}
+}
+
+// countNAK increments the number of the DHCPNAK messages sent for reason.
+func (s *v4Server) countNAK(reason nakReason) {
+	s.nakCountsLock.Lock()
+	defer s.nakCountsLock.Unlock()
+
+	if s.nakCounts == nil {
+		s.nakCounts = map[nakReason]uint64{}
+	}
+
+	s.nakCounts[reason]++
+}
+
+// NAKCounts implements the [DHCPServer] interface for *v4Server.
+func (s *v4Server) NAKCounts() (counts map[string]uint64) {
+	s.nakCountsLock.Lock()
+	defer s.nakCountsLock.Unlock()
+
+	counts = make(map[string]uint64, len(s.nakCounts))
+	for r, n := range s.nakCounts {
+		counts[string(r)] = n
+	}
+
+	return counts
+}
+
+// inSubnet returns true if ip is an IPv4 address within the subnet of the
+// server.
+func (s *v4Server) inSubnet(ip net.IP) (ok bool) {
+	ip4 := ip.To4()
+
+	return ip4 != nil && s.conf.subnet.Contains(netip.AddrFrom4([4]byte(ip4)))
+}
+
 // handleSelecting handles the DHCPREQUEST generated during SELECTING state.
+// If l is nil and nak is empty, the request should be dropped.
 func (s *v4Server) handleSelecting(
 	req *dhcpv4.DHCPv4,
 	reqIP net.IP,
 	sid net.IP,
-) (l *dhcpsvc.Lease, needsReply bool) {
+) (l *dhcpsvc.Lease, nak nakReason) {
 	// Client inserts the address of the selected server in server identifier,
 	// ciaddr MUST be zero.
 	mac := req.ClientHWAddr
@@ -834,11 +1066,11 @@ func (s *v4Server) handleSelecting(
 	if !sid.Equal(s.conf.dnsIPAddrs[0].AsSlice()) {
 		log.Debug("dhcpv4: bad server identifier in req msg for %s: %s", mac, sid)
 
-		return nil, false
+		return nil, ""
 	} else if ciaddr := req.ClientIPAddr; ciaddr != nil && !ciaddr.IsUnspecified() {
 		log.Debug("dhcpv4: non-zero ciaddr in selecting req msg for %s", mac)
 
-		return nil, false
+		return nil, ""
 	}
 
 	// Requested IP address MUST be filled in with the yiaddr value from the
@@ -846,31 +1078,36 @@ func (s *v4Server) handleSelecting(
 	if ip4 := reqIP.To4(); ip4 == nil {
 		log.Debug("dhcpv4: bad requested address in req msg for %s: %s", mac, reqIP)
 
-		return nil, false
+		return nil, ""
+	} else if !s.inSubnet(ip4) {
+		return nil, nakReasonWrongSubnet
 	}
 
 	var mismatch bool
 	if l, mismatch = s.checkLease(mac, reqIP); mismatch {
-		return nil, true
+		return nil, nakReasonLeaseMismatch
 	} else if l == nil {
 		log.Debug("dhcpv4: no reserved lease for %s", mac)
+
+		return nil, nakReasonNoOffer
 	}
 
-	return l, true
+	return l, ""
 }
 
 // handleInitReboot handles the DHCPREQUEST generated during INIT-REBOOT state.
+// If l is nil and nak is empty, the request should be dropped.
 func (s *v4Server) handleInitReboot(
 	req *dhcpv4.DHCPv4,
 	reqIP net.IP,
-) (l *dhcpsvc.Lease, needsReply bool) {
+) (l *dhcpsvc.Lease, nak nakReason) {
 	mac := req.ClientHWAddr
 
 	ip4 := reqIP.To4()
 	if ip4 == nil {
 		log.Debug("dhcpv4: bad requested address in req msg for %s: %s", mac, reqIP)
 
-		return nil, false
+		return nil, ""
 	}
 
 	// ciaddr MUST be zero.  The client is seeking to verify a previously
@@ -878,34 +1115,32 @@ func (s *v4Server) handleInitReboot(
 	if ciaddr := req.ClientIPAddr; ciaddr != nil && !ciaddr.IsUnspecified() {
 		log.Debug("dhcpv4: non-zero ciaddr in init-reboot req msg for %s", mac)
 
-		return nil, false
+		return nil, ""
 	}
 
-	if !s.conf.subnet.Contains(netip.AddrFrom4([4]byte(ip4))) {
+	if !s.inSubnet(ip4) {
 		// If the DHCP server detects that the client is on the wrong net then
 		// the server SHOULD send a DHCPNAK message to the client.
-		log.Debug("dhcpv4: wrong subnet in init-reboot req msg for %s: %s", mac, reqIP)
-
-		return nil, true
+		return nil, nakReasonWrongSubnet
 	}
 
 	var mismatch bool
 	if l, mismatch = s.checkLease(mac, reqIP); mismatch {
-		return nil, true
+		return nil, nakReasonLeaseMismatch
 	} else if l == nil {
 		// If the DHCP server has no record of this client, then it MUST remain
 		// silent, and MAY output a warning to the network administrator.
 		log.Info("dhcpv4: warning: no existing lease for %s", mac)
 
-		return nil, false
+		return nil, ""
 	}
 
-	return l, true
+	return l, ""
 }
 
 // handleRenew handles the DHCPREQUEST generated during RENEWING or REBINDING
-// state.
-func (s *v4Server) handleRenew(req *dhcpv4.DHCPv4) (l *dhcpsvc.Lease, needsReply bool) {
+// state.  If l is nil and nak is empty, the request should be dropped.
+func (s *v4Server) handleRenew(req *dhcpv4.DHCPv4) (l *dhcpsvc.Lease, nak nakReason) {
 	mac := req.ClientHWAddr
 
 	// ciaddr MUST be filled in with client's IP address.
@@ -913,59 +1148,102 @@ func (s *v4Server) handleRenew(req *dhcpv4.DHCPv4) (l *dhcpsvc.Lease, needsReply
 	if ciaddr == nil || ciaddr.IsUnspecified() || ciaddr.To4() == nil {
 		log.Debug("dhcpv4: bad ciaddr in renew req msg for %s: %s", mac, ciaddr)
 
-		return nil, false
+		return nil, ""
+	}
+
+	if !s.inSubnet(ciaddr) {
+		// A rebinding client has moved to another network.
+		return nil, nakReasonWrongSubnet
 	}
 
 	var mismatch bool
 	if l, mismatch = s.checkLease(mac, ciaddr); mismatch {
-		return nil, true
+		return nil, nakReasonLeaseMismatch
 	} else if l == nil {
 		// If the DHCP server has no record of this client, then it MUST remain
 		// silent, and MAY output a warning to the network administrator.
 		log.Info("dhcpv4: warning: no existing lease for %s", mac)
 
-		return nil, false
+		return nil, ""
 	}
 
-	return l, true
+	return l, ""
 }
 
 // handleByRequestType handles the DHCPREQUEST according to the state during
-// which it's generated by client.
-func (s *v4Server) handleByRequestType(req *dhcpv4.DHCPv4) (lease *dhcpsvc.Lease, needsReply bool) {
+// which it's generated by client.  reqIP is the address requested by the
+// client in that state.
+func (s *v4Server) handleByRequestType(req *dhcpv4.DHCPv4) (
+	state requestState,
+	reqIP net.IP,
+	lease *dhcpsvc.Lease,
+	nak nakReason,
+) {
 	reqIP, sid := req.RequestedIPAddress(), req.ServerIdentifier()
 
 	if sid != nil && !sid.IsUnspecified() {
 		// If the DHCPREQUEST message contains a server identifier option, the
 		// message is in response to a DHCPOFFER message.  Otherwise, the
 		// message is a request to verify or extend an existing lease.
-		return s.handleSelecting(req, reqIP, sid)
+		lease, nak = s.handleSelecting(req, reqIP, sid)
+
+		return requestStateSelecting, reqIP, lease, nak
 	}
 
 	if reqIP != nil && !reqIP.IsUnspecified() {
 		// Requested IP address option MUST be filled in with client's notion of
 		// its previously assigned address.
-		return s.handleInitReboot(req, reqIP)
+		lease, nak = s.handleInitReboot(req, reqIP)
+
+		return requestStateInitReboot, reqIP, lease, nak
 	}
 
 	// Server identifier MUST NOT be filled in, requested IP address option MUST
 	// NOT be filled in.
-	return s.handleRenew(req)
+	lease, nak = s.handleRenew(req)
+
+	return requestStateRenewing, req.ClientIPAddr, lease, nak
 }
 
-// handleRequest is the handler for a DHCPREQUEST message.
+// handleRequest is the handler for a DHCPREQUEST message.  If lease is nil and
+// nak is empty, the request should be dropped.  Otherwise, if lease is nil,
+// resp contains the Message option explaining nak.
 //
 // See https://datatracker.ietf.org/doc/html/rfc2131#section-4.3.2.
-func (s *v4Server) handleRequest(req, resp *dhcpv4.DHCPv4) (lease *dhcpsvc.Lease, needsReply bool) {
-	lease, needsReply = s.handleByRequestType(req)
-	if lease == nil {
-		return nil, needsReply
+func (s *v4Server) handleRequest(req, resp *dhcpv4.DHCPv4) (lease *dhcpsvc.Lease, nak nakReason) {
+	state, reqIP, lease, nak := s.handleByRequestType(req)
+
+	decision := "ack"
+	if nak != "" {
+		decision = "nak"
+	} else if lease == nil {
+		decision = "drop"
+	}
+
+	log.Debug(
+		"dhcpv4: request: state=%s mac=%s requested_ip=%s decision=%s reason=%s",
+		state,
+		req.ClientHWAddr,
+		reqIP,
+		decision,
+		nak,
+	)
+
+	if nak != "" {
+		s.countNAK(nak)
+		resp.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeNak))
+		resp.UpdateOption(dhcpv4.OptMessage(nak.message()))
+
+		return nil, nak
+	} else if lease == nil {
+		return nil, ""
 	}
 
 	resp.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeAck))
 
 	hostname := req.HostName()
 	isRequested := hostname != "" || req.ParameterRequestList().Has(dhcpv4.OptionHostName)
+	osName := s.fingerprints.Load().osName(req)
 
 	defer func() {
 		s.conf.notify(LeaseChangedAdded)
@@ -975,6 +1253,17 @@ func (s *v4Server) handleRequest(req, resp *dhcpv4.DHCPv4) (lease *dhcpsvc.Lease
 	s.leasesLock.Lock()
 	defer s.leasesLock.Unlock()
 
+	// The lease could have been replaced with the same one since it was looked
+	// up, for example converted into a static one, so use the current one to
+	// not put the stale lease back into the indexes.
+	if cur := s.ipIndex[lease.IP]; cur != nil && bytes.Equal(cur.HWAddr, lease.HWAddr) {
+		lease = cur
+	}
+
+	if osName != "" {
+		lease.OSName = osName
+	}
+
 	if lease.IsStatic {
 		if lease.Hostname != "" {
 			// TODO(e.burkov):  This option is used to update the server's DNS
@@ -983,7 +1272,7 @@ func (s *v4Server) handleRequest(req, resp *dhcpv4.DHCPv4) (lease *dhcpsvc.Lease
 			resp.UpdateOption(OptionFQDN(lease.Hostname))
 		}
 
-		return lease, needsReply
+		return lease, ""
 	}
 
 	s.commitLease(lease, hostname)
@@ -992,7 +1281,7 @@ func (s *v4Server) handleRequest(req, resp *dhcpv4.DHCPv4) (lease *dhcpsvc.Lease
 		resp.UpdateOption(dhcpv4.OptHostName(lease.Hostname))
 	}
 
-	return lease, needsReply
+	return lease, ""
 }
 
 // handleDecline is the handler for the DHCP Decline request.
@@ -1142,10 +1431,10 @@ var messageHandlers = map[dhcpv4.MessageType]messageHandler{
 		req *dhcpv4.DHCPv4,
 		resp *dhcpv4.DHCPv4,
 	) (rCode int, l *dhcpsvc.Lease, err error) {
-		var toReply bool
-		l, toReply = s.handleRequest(req, resp)
+		var nak nakReason
+		l, nak = s.handleRequest(req, resp)
 		if l == nil {
-			if toReply {
+			if nak != "" {
 				return 0, nil, nil
 			}
 
@@ -1198,7 +1487,7 @@ func (s *v4Server) handle(req, resp *dhcpv4.DHCPv4) (rCode int) {
 
 	handler := messageHandlers[req.MessageType()]
 	if handler == nil {
-		s.updateOptions(req, resp)
+		s.updateOptions(req, resp, nil)
 
 		return 1
 	}
@@ -1218,21 +1507,32 @@ func (s *v4Server) handle(req, resp *dhcpv4.DHCPv4) (rCode int) {
 		resp.YourIPAddr = l.IP.AsSlice()
 	}
 
-	s.updateOptions(req, resp)
+	s.updateOptions(req, resp, l)
 
 	return 1
 }
 
+// leaseTime returns the lease time for l, which is either the one overridden by
+// the static lease or the configured default.  l may be nil.  The static leases
+// never expire on the server side, so it's only used for the lease time option
+// sent to the client.
+func (s *v4Server) leaseTime(l *dhcpsvc.Lease) (d time.Duration) {
+	if l != nil && l.IsStatic && l.LeaseDuration > 0 {
+		return l.LeaseDuration
+	}
+
+	return s.conf.leaseTime
+}
+
 // updateOptions updates the options of the response in accordance with the
-// request and RFC 2131.
+// request and RFC 2131.  l is the lease the response is prepared for, it may be
+// nil.
 //
 // See https://datatracker.ietf.org/doc/html/rfc2131#section-4.3.1.
-func (s *v4Server) updateOptions(req, resp *dhcpv4.DHCPv4) {
+func (s *v4Server) updateOptions(req, resp *dhcpv4.DHCPv4, l *dhcpsvc.Lease) {
 	// Set IP address lease time for all DHCPOFFER messages and DHCPACK messages
 	// replied for DHCPREQUEST.
-	//
-	// TODO(e.burkov):  Inspect why this is always set to configured value.
-	resp.UpdateOption(dhcpv4.OptIPAddressLeaseTime(s.conf.leaseTime))
+	resp.UpdateOption(dhcpv4.OptIPAddressLeaseTime(s.leaseTime(l)))
 
 	// If the server recognizes the parameter as a parameter defined in the Host
 	// Requirements Document, the server MUST include the default value for that
@@ -1254,6 +1554,11 @@ func (s *v4Server) updateOptions(req, resp *dhcpv4.DHCPv4) {
 			delete(resp.Options, code)
 		}
 	}
+
+	data := matchVendorOption(s.conf.vendorOpts, req.ClassIdentifier())
+	if data != nil {
+		resp.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionVendorSpecificInformation, data))
+	}
 }
 
 // client(0.0.0.0:68) -> (Request:ClientMAC,Type=Discover,ClientID,ReqIP,HostName) -> server(255.255.255.255:67)
@@ -1359,6 +1664,14 @@ func (s *v4Server) Start() (err error) {
 		}
 	}()
 
+	if s.presenceProber != nil {
+		s.presenceDone = make(chan struct{})
+		ivl := time.Duration(s.conf.PresenceSweepInterval) * time.Second
+
+		s.presenceWG.Add(1)
+		go s.runPresenceSweeps(s.presenceDone, &s.presenceWG, ivl)
+	}
+
 	// Signal to the clients containers in packages home and dnsforward that
 	// it should reload the DHCP clients.
 	s.conf.notify(LeaseChangedAdded)
@@ -1395,6 +1708,16 @@ func (s *v4Server) Stop() (err error) {
 	}
 
 	log.Debug("dhcpv4: stopping")
+
+	if s.presenceDone != nil {
+		close(s.presenceDone)
+		s.presenceDone = nil
+
+		// The current probes are bounded by the timeout, so it doesn't take
+		// long.
+		s.presenceWG.Wait()
+	}
+
 	err = s.srv.Close()
 	if err != nil {
 		return fmt.Errorf("closing dhcpv4 srv: %w", err)
@@ -1437,6 +1760,15 @@ func v4Create(conf *V4ServerConf) (srv *v4Server, err error) {
 	}
 
 	s.prepareOptions()
+	s.initProbers()
+	s.initPresenceProber()
+
+	err = s.ReloadFingerprints()
+	if err != nil {
+		// Don't prevent the server from working, since the fingerprints are
+		// only used for informational purposes.
+		log.Error("%s", err)
+	}
 
 	return s, nil
 }
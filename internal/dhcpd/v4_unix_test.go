@@ -6,7 +6,10 @@ import (
 	"fmt"
 	"net"
 	"net/netip"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -15,6 +18,7 @@ import (
 	"github.com/AdguardTeam/golibs/netutil"
 	"github.com/AdguardTeam/golibs/stringutil"
 	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/AdguardTeam/golibs/timeutil"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -489,7 +493,7 @@ func TestV4Server_updateOptions(t *testing.T) {
 		require.IsType(t, (*v4Server)(nil), s)
 
 		t.Run(tc.name, func(t *testing.T) {
-			s.updateOptions(req, resp)
+			s.updateOptions(req, resp, nil)
 
 			for c, v := range tc.wantOpts {
 				if v == nil {
@@ -596,6 +600,75 @@ func TestV4StaticLease_Get(t *testing.T) {
 	})
 }
 
+func TestV4StaticLease_leaseDuration(t *testing.T) {
+	mac := net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA}
+
+	testCases := []struct {
+		name     string
+		duration time.Duration
+		want     time.Duration
+	}{{
+		name:     "default",
+		duration: 0,
+		want:     timeutil.Day,
+	}, {
+		name:     "custom",
+		duration: time.Hour,
+		want:     time.Hour,
+	}, {
+		name:     "infinite",
+		duration: dhcpsvc.LeaseDurationInfinite,
+		want:     dhcpsvc.LeaseDurationInfinite,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sIface := defaultSrv(t)
+
+			s, ok := sIface.(*v4Server)
+			require.True(t, ok)
+
+			err := s.AddStaticLease(&dhcpsvc.Lease{
+				Hostname:      "static-1.local",
+				HWAddr:        mac,
+				IP:            netip.MustParseAddr("192.168.10.150"),
+				LeaseDuration: tc.duration,
+			})
+			require.NoError(t, err)
+
+			req, err := dhcpv4.NewDiscovery(mac)
+			require.NoError(t, err)
+
+			resp, err := dhcpv4.NewReplyFromRequest(req)
+			require.NoError(t, err)
+
+			require.Equal(t, 1, s.handle(req, resp))
+			require.Equal(t, dhcpv4.MessageTypeOffer, resp.MessageType())
+
+			assert.Equal(t, tc.want, resp.IPAddressLeaseTime(-1))
+
+			req, err = dhcpv4.NewRequestFromOffer(resp)
+			require.NoError(t, err)
+
+			resp, err = dhcpv4.NewReplyFromRequest(req)
+			require.NoError(t, err)
+
+			require.Equal(t, 1, s.handle(req, resp))
+			require.Equal(t, dhcpv4.MessageTypeAck, resp.MessageType())
+
+			assert.Equal(t, tc.want, resp.IPAddressLeaseTime(-1))
+
+			// The static lease itself must not be committed.
+			leases := s.GetLeases(LeasesStatic)
+			require.Len(t, leases, 1)
+
+			assert.True(t, leases[0].IsStatic)
+			assert.Equal(t, tc.duration, leases[0].LeaseDuration)
+			assert.True(t, leases[0].Expiry.IsZero())
+		})
+	}
+}
+
 func TestV4DynamicLease_Get(t *testing.T) {
 	conf := defaultV4ServerConf()
 	conf.Options = []string{
@@ -914,3 +987,932 @@ func TestV4Server_handleRelease(t *testing.T) {
 
 	require.Equal(t, wantResp, resp)
 }
+
+func TestV4Server_handleRequest_states(t *testing.T) {
+	leaseIP := netip.MustParseAddr("192.168.10.150")
+	leaseMAC := net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA}
+	unknownMAC := net.HardwareAddr{0xBB, 0xBB, 0xBB, 0xBB, 0xBB, 0xBB}
+
+	otherIP := net.IP{192, 168, 10, 151}
+	wrongNetIP := net.IP{10, 0, 0, 1}
+	ip := net.IP(leaseIP.AsSlice())
+	sid := net.IP(DefaultSelfIP.AsSlice())
+
+	s := defaultSrv(t)
+
+	s4, ok := s.(*v4Server)
+	require.True(t, ok)
+
+	err := s.AddStaticLease(&dhcpsvc.Lease{
+		Hostname: "static-client",
+		HWAddr:   leaseMAC,
+		IP:       leaseIP,
+		IsStatic: true,
+	})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		mac      net.HardwareAddr
+		ciaddr   net.IP
+		reqIP    net.IP
+		sid      net.IP
+		name     string
+		wantNAK  nakReason
+		wantCode int
+	}{{
+		mac:      leaseMAC,
+		ciaddr:   nil,
+		reqIP:    ip,
+		sid:      sid,
+		name:     "selecting_match",
+		wantNAK:  "",
+		wantCode: 1,
+	}, {
+		mac:      leaseMAC,
+		ciaddr:   nil,
+		reqIP:    otherIP,
+		sid:      sid,
+		name:     "selecting_mismatch",
+		wantNAK:  nakReasonLeaseMismatch,
+		wantCode: 0,
+	}, {
+		mac:      unknownMAC,
+		ciaddr:   nil,
+		reqIP:    otherIP,
+		sid:      sid,
+		name:     "selecting_no_offer",
+		wantNAK:  nakReasonNoOffer,
+		wantCode: 0,
+	}, {
+		mac:      leaseMAC,
+		ciaddr:   nil,
+		reqIP:    wrongNetIP,
+		sid:      sid,
+		name:     "selecting_wrong_subnet",
+		wantNAK:  nakReasonWrongSubnet,
+		wantCode: 0,
+	}, {
+		mac:      leaseMAC,
+		ciaddr:   nil,
+		reqIP:    ip,
+		sid:      net.IP{192, 168, 10, 3},
+		name:     "selecting_other_server",
+		wantNAK:  "",
+		wantCode: -1,
+	}, {
+		mac:      leaseMAC,
+		ciaddr:   nil,
+		reqIP:    ip,
+		sid:      nil,
+		name:     "init_reboot_match",
+		wantNAK:  "",
+		wantCode: 1,
+	}, {
+		mac:      leaseMAC,
+		ciaddr:   nil,
+		reqIP:    otherIP,
+		sid:      nil,
+		name:     "init_reboot_mismatch",
+		wantNAK:  nakReasonLeaseMismatch,
+		wantCode: 0,
+	}, {
+		mac:      leaseMAC,
+		ciaddr:   nil,
+		reqIP:    wrongNetIP,
+		sid:      nil,
+		name:     "init_reboot_wrong_subnet",
+		wantNAK:  nakReasonWrongSubnet,
+		wantCode: 0,
+	}, {
+		mac:      unknownMAC,
+		ciaddr:   nil,
+		reqIP:    otherIP,
+		sid:      nil,
+		name:     "init_reboot_unknown",
+		wantNAK:  "",
+		wantCode: -1,
+	}, {
+		mac:      leaseMAC,
+		ciaddr:   ip,
+		reqIP:    nil,
+		sid:      nil,
+		name:     "renewing_match",
+		wantNAK:  "",
+		wantCode: 1,
+	}, {
+		mac:      leaseMAC,
+		ciaddr:   otherIP,
+		reqIP:    nil,
+		sid:      nil,
+		name:     "renewing_mismatch",
+		wantNAK:  nakReasonLeaseMismatch,
+		wantCode: 0,
+	}, {
+		mac:      leaseMAC,
+		ciaddr:   wrongNetIP,
+		reqIP:    nil,
+		sid:      nil,
+		name:     "renewing_wrong_subnet",
+		wantNAK:  nakReasonWrongSubnet,
+		wantCode: 0,
+	}, {
+		mac:      unknownMAC,
+		ciaddr:   otherIP,
+		reqIP:    nil,
+		sid:      nil,
+		name:     "renewing_unknown",
+		wantNAK:  "",
+		wantCode: -1,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			modifiers := []dhcpv4.Modifier{
+				dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest),
+				dhcpv4.WithHwAddr(tc.mac),
+				dhcpv4.WithClientIP(tc.ciaddr),
+			}
+
+			if tc.reqIP != nil {
+				modifiers = append(
+					modifiers,
+					dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(tc.reqIP)),
+				)
+			}
+
+			if tc.sid != nil {
+				modifiers = append(
+					modifiers,
+					dhcpv4.WithOption(dhcpv4.OptServerIdentifier(tc.sid)),
+				)
+			}
+
+			req, reqErr := dhcpv4.New(modifiers...)
+			require.NoError(t, reqErr)
+
+			resp, respErr := dhcpv4.NewReplyFromRequest(req)
+			require.NoError(t, respErr)
+
+			require.Equal(t, tc.wantCode, s4.handle(req, resp))
+
+			if tc.wantCode == 1 {
+				assert.Equal(t, dhcpv4.MessageTypeAck, resp.MessageType())
+				assert.Equal(t, ip, resp.YourIPAddr)
+				assert.Empty(t, resp.Message())
+
+				return
+			}
+
+			if tc.wantNAK == "" {
+				assert.Empty(t, resp.Message())
+
+				return
+			}
+
+			assert.Equal(t, dhcpv4.MessageTypeNak, resp.MessageType())
+			assert.Equal(t, tc.wantNAK.message(), resp.Message())
+		})
+	}
+
+	assert.Equal(t, map[string]uint64{
+		string(nakReasonWrongSubnet):   3,
+		string(nakReasonLeaseMismatch): 3,
+		string(nakReasonNoOffer):       1,
+	}, s4.NAKCounts())
+}
+
+// fakeProber is an [addrProber] implementation for tests.
+type fakeProber struct {
+	onProbe func(target netip.Addr) (inUse bool, err error)
+}
+
+// type check
+var _ addrProber = (*fakeProber)(nil)
+
+// probe implements the [addrProber] interface for *fakeProber.
+func (p *fakeProber) probe(target netip.Addr) (inUse bool, err error) {
+	return p.onProbe(target)
+}
+
+// newFakeProber returns a *fakeProber which reports every address as used if
+// inUse is true.
+func newFakeProber(inUse bool) (p *fakeProber) {
+	return &fakeProber{
+		onProbe: func(_ netip.Addr) (ok bool, err error) {
+			return inUse, nil
+		},
+	}
+}
+
+func TestV4Server_addrAvailable(t *testing.T) {
+	target := netip.MustParseAddr("192.168.10.100")
+
+	testCases := []struct {
+		name      string
+		detection string
+		icmpReply bool
+		arpReply  bool
+		want      bool
+	}{{
+		name:      "both_no_reply",
+		detection: ConflictDetectionBoth,
+		icmpReply: false,
+		arpReply:  false,
+		want:      true,
+	}, {
+		name:      "both_icmp_only",
+		detection: ConflictDetectionBoth,
+		icmpReply: true,
+		arpReply:  false,
+		want:      false,
+	}, {
+		name:      "both_arp_only",
+		detection: ConflictDetectionBoth,
+		icmpReply: false,
+		arpReply:  true,
+		want:      false,
+	}, {
+		name:      "both_replies",
+		detection: ConflictDetectionBoth,
+		icmpReply: true,
+		arpReply:  true,
+		want:      false,
+	}, {
+		name:      "ping_arp_only",
+		detection: ConflictDetectionPing,
+		icmpReply: false,
+		arpReply:  true,
+		want:      true,
+	}, {
+		name:      "arp_icmp_only",
+		detection: ConflictDetectionARP,
+		icmpReply: true,
+		arpReply:  false,
+		want:      true,
+	}, {
+		name:      "arp_reply",
+		detection: ConflictDetectionARP,
+		icmpReply: false,
+		arpReply:  true,
+		want:      false,
+	}, {
+		name:      "none",
+		detection: ConflictDetectionNone,
+		icmpReply: true,
+		arpReply:  true,
+		want:      true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &v4Server{
+				conf: &V4ServerConf{
+					ICMPTimeout: DefaultDHCPTimeoutICMP,
+				},
+				icmpProber:        newFakeProber(tc.icmpReply),
+				arpProber:         newFakeProber(tc.arpReply),
+				conflictDetection: tc.detection,
+			}
+
+			assert.Equal(t, tc.want, s.addrAvailable(target))
+		})
+	}
+
+	t.Run("probe_error", func(t *testing.T) {
+		s := &v4Server{
+			conf: &V4ServerConf{
+				ICMPTimeout: DefaultDHCPTimeoutICMP,
+			},
+			icmpProber: &fakeProber{
+				onProbe: func(_ netip.Addr) (ok bool, err error) {
+					return false, assert.AnError
+				},
+			},
+			conflictDetection: ConflictDetectionPing,
+		}
+
+		assert.True(t, s.addrAvailable(target))
+	})
+}
+
+func TestV4Server_allocateLease_conflict(t *testing.T) {
+	mac := net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA}
+
+	s := defaultSrv(t)
+
+	s4, ok := s.(*v4Server)
+	require.True(t, ok)
+
+	s4.conf.ICMPTimeout = DefaultDHCPTimeoutICMP
+	s4.conflictDetection = ConflictDetectionBoth
+	s4.icmpProber = newFakeProber(false)
+	s4.arpProber = &fakeProber{
+		onProbe: func(target netip.Addr) (ok bool, err error) {
+			return target == DefaultRangeStart, nil
+		},
+	}
+
+	l, err := s4.allocateLease(mac)
+	require.NoError(t, err)
+	require.NotNil(t, l)
+
+	assert.Equal(t, DefaultRangeStart.Next(), l.IP)
+
+	blocked := s4.ipIndex[DefaultRangeStart]
+	require.NotNil(t, blocked)
+
+	assert.True(t, s4.isBlocklisted(blocked))
+}
+
+func TestV4ServerConf_Validate_conflictDetection(t *testing.T) {
+	testCases := []struct {
+		name       string
+		detection  string
+		wantErrMsg string
+	}{{
+		name:       "empty",
+		detection:  "",
+		wantErrMsg: "",
+	}, {
+		name:       "both",
+		detection:  ConflictDetectionBoth,
+		wantErrMsg: "",
+	}, {
+		name:      "bad",
+		detection: "dad",
+		wantErrMsg: `dhcpv4: conflict detection: ` +
+			`unsupported value "dad"`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conf := defaultV4ServerConf()
+			conf.ConflictDetection = tc.detection
+
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, conf.Validate())
+		})
+	}
+}
+
+func TestV4Server_sweepPresence(t *testing.T) {
+	s := defaultSrv(t)
+
+	s4, ok := s.(*v4Server)
+	require.True(t, ok)
+
+	var (
+		reachableIP   = netip.MustParseAddr("192.168.10.150")
+		unreachableIP = netip.MustParseAddr("192.168.10.151")
+		staticIP      = netip.MustParseAddr("192.168.10.10")
+	)
+
+	expiry := time.Now().Add(time.Hour)
+	err := s4.addLease(&dhcpsvc.Lease{
+		Expiry: expiry,
+		HWAddr: net.HardwareAddr{0x11, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA},
+		IP:     reachableIP,
+	})
+	require.NoError(t, err)
+
+	err = s4.addLease(&dhcpsvc.Lease{
+		Expiry: expiry,
+		HWAddr: net.HardwareAddr{0x22, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA},
+		IP:     unreachableIP,
+	})
+	require.NoError(t, err)
+
+	err = s4.AddStaticLease(&dhcpsvc.Lease{
+		HWAddr: net.HardwareAddr{0x33, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA},
+		IP:     staticIP,
+	})
+	require.NoError(t, err)
+
+	reachable := true
+	var probed []netip.Addr
+	mu := &sync.Mutex{}
+	s4.presenceProber = &fakeProber{
+		onProbe: func(target netip.Addr) (ok bool, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			probed = append(probed, target)
+
+			return target == reachableIP && reachable, nil
+		},
+	}
+
+	onlineByIP := func() (online map[netip.Addr]bool) {
+		online = map[netip.Addr]bool{}
+		for _, l := range s4.GetLeases(LeasesAll) {
+			online[l.IP] = l.Online
+		}
+
+		return online
+	}
+
+	deadline := time.Now().Add(time.Hour)
+	s4.sweepPresence(nil, deadline)
+
+	// Static leases aren't probed.
+	assert.ElementsMatch(t, []netip.Addr{reachableIP, unreachableIP}, probed)
+	assert.Equal(t, map[netip.Addr]bool{
+		reachableIP:   true,
+		unreachableIP: false,
+		staticIP:      false,
+	}, onlineByIP())
+
+	reachable = false
+	s4.sweepPresence(nil, deadline)
+
+	assert.Equal(t, map[netip.Addr]bool{
+		reachableIP:   false,
+		unreachableIP: false,
+		staticIP:      false,
+	}, onlineByIP())
+
+	reachable = true
+	s4.sweepPresence(nil, deadline)
+
+	assert.True(t, onlineByIP()[reachableIP])
+
+	probed = nil
+	reachable = false
+	s4.sweepPresence(nil, time.Now())
+
+	assert.Empty(t, probed)
+	assert.True(t, onlineByIP()[reachableIP])
+
+	done := make(chan struct{})
+	close(done)
+	s4.sweepPresence(done, deadline)
+
+	assert.Empty(t, probed)
+	assert.True(t, onlineByIP()[reachableIP])
+}
+
+func TestV4Server_sweepPresence_concurrency(t *testing.T) {
+	s := defaultSrv(t)
+
+	s4, ok := s.(*v4Server)
+	require.True(t, ok)
+
+	const leasesNum = 3 * maxPresenceProbes
+
+	expiry := time.Now().Add(time.Hour)
+	ip := DefaultRangeStart
+	for i := range leasesNum {
+		err := s4.addLease(&dhcpsvc.Lease{
+			Expiry: expiry,
+			HWAddr: net.HardwareAddr{0x11, 0xAA, 0xAA, 0xAA, 0xAA, byte(i)},
+			IP:     ip,
+		})
+		require.NoError(t, err)
+
+		ip = ip.Next()
+	}
+
+	var cur, maxCur, total atomic.Int32
+	s4.presenceProber = &fakeProber{
+		onProbe: func(_ netip.Addr) (ok bool, err error) {
+			n := cur.Add(1)
+			defer cur.Add(-1)
+
+			for m := maxCur.Load(); n > m && !maxCur.CompareAndSwap(m, n); {
+				m = maxCur.Load()
+			}
+
+			total.Add(1)
+			time.Sleep(time.Millisecond)
+
+			return true, nil
+		},
+	}
+
+	s4.sweepPresence(nil, time.Now().Add(time.Hour))
+
+	assert.Equal(t, int32(leasesNum), total.Load())
+	assert.LessOrEqual(t, maxCur.Load(), int32(maxPresenceProbes))
+}
+
+func TestV4Server_updateOptions_vendorSpecific(t *testing.T) {
+	conf := defaultV4ServerConf()
+	conf.VendorSpecificOptions = []VendorOption{{
+		VendorClass: "Cisco Systems, Inc.",
+		Data:        "0102",
+	}, {
+		VendorClass: "Cisco Systems, Inc. IP Phone",
+		Data:        "0a0b0c",
+	}, {
+		VendorClass: "MSFT 5.0",
+		Data:        "ff",
+	}}
+
+	s, err := v4Create(conf)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name     string
+		class    string
+		wantData []byte
+	}{{
+		name:     "exact",
+		class:    "MSFT 5.0",
+		wantData: []byte{0xff},
+	}, {
+		name:     "prefix",
+		class:    "Cisco Systems, Inc. Switch",
+		wantData: []byte{0x01, 0x02},
+	}, {
+		name:     "longest_prefix",
+		class:    "Cisco Systems, Inc. IP Phone CP-7960",
+		wantData: []byte{0x0a, 0x0b, 0x0c},
+	}, {
+		name:     "no_match",
+		class:    "udhcp 1.36.1",
+		wantData: nil,
+	}, {
+		name:     "no_class",
+		class:    "",
+		wantData: nil,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var mods []dhcpv4.Modifier
+			if tc.class != "" {
+				mods = append(mods, dhcpv4.WithOption(dhcpv4.OptClassIdentifier(tc.class)))
+			}
+
+			req, reqErr := dhcpv4.New(mods...)
+			require.NoError(t, reqErr)
+
+			resp, respErr := dhcpv4.NewReplyFromRequest(req)
+			require.NoError(t, respErr)
+
+			s.updateOptions(req, resp, nil)
+
+			assert.Equal(t, tc.wantData, resp.GetOneOption(dhcpv4.OptionVendorSpecificInformation))
+		})
+	}
+}
+
+func TestV4ServerConf_Validate_vendorSpecificOptions(t *testing.T) {
+	testCases := []struct {
+		name       string
+		wantErrMsg string
+		opts       []VendorOption
+	}{{
+		name:       "valid",
+		wantErrMsg: "",
+		opts:       []VendorOption{{VendorClass: "MSFT 5.0", Data: "0102"}},
+	}, {
+		name: "malformed_hex",
+		wantErrMsg: "dhcpv4: vendor specific option at index 0: data: " +
+			"encoding/hex: invalid byte: U+007A 'z'",
+		opts: []VendorOption{{VendorClass: "MSFT 5.0", Data: "zz"}},
+	}, {
+		name: "odd_length",
+		wantErrMsg: "dhcpv4: vendor specific option at index 0: data: " +
+			"encoding/hex: odd length hex string",
+		opts: []VendorOption{{VendorClass: "MSFT 5.0", Data: "010"}},
+	}, {
+		name:       "empty_class",
+		wantErrMsg: "dhcpv4: vendor specific option at index 0: vendor_class: empty value",
+		opts:       []VendorOption{{VendorClass: "", Data: "01"}},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conf := defaultV4ServerConf()
+			conf.VendorSpecificOptions = tc.opts
+
+			_, err := v4Create(conf)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
+
+func TestV4Server_handle_vendorSpecificAck(t *testing.T) {
+	const phoneClass = "Cisco Systems, Inc. IP Phone"
+
+	leaseIP := netip.MustParseAddr("192.168.10.150")
+	leaseMAC := net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA}
+
+	conf := defaultV4ServerConf()
+	conf.VendorSpecificOptions = []VendorOption{{
+		VendorClass: phoneClass,
+		Data:        "010474667470",
+	}}
+
+	s, err := v4Create(conf)
+	require.NoError(t, err)
+
+	err = s.AddStaticLease(&dhcpsvc.Lease{
+		Hostname: "phone",
+		HWAddr:   leaseMAC,
+		IP:       leaseIP,
+		IsStatic: true,
+	})
+	require.NoError(t, err)
+
+	req, err := dhcpv4.New(
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest),
+		dhcpv4.WithHwAddr(leaseMAC),
+		dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(leaseIP.AsSlice())),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(DefaultSelfIP.AsSlice())),
+		dhcpv4.WithOption(dhcpv4.OptClassIdentifier(phoneClass)),
+	)
+	require.NoError(t, err)
+
+	resp, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+
+	require.Equal(t, 1, s.handle(req, resp))
+	require.Equal(t, dhcpv4.MessageTypeAck, resp.MessageType())
+
+	assert.Equal(
+		t,
+		[]byte{0x01, 0x04, 't', 'f', 't', 'p'},
+		resp.GetOneOption(dhcpv4.OptionVendorSpecificInformation),
+	)
+}
+
+func TestV4Server_reserveLease_additionalRanges(t *testing.T) {
+	conf := defaultV4ServerConf()
+	conf.RangeStart = netip.MustParseAddr("192.168.10.100")
+	conf.RangeEnd = netip.MustParseAddr("192.168.10.101")
+	conf.AdditionalRanges = []V4Range{{
+		Start: netip.MustParseAddr("192.168.10.150"),
+		End:   netip.MustParseAddr("192.168.10.151"),
+	}}
+
+	s, err := v4Create(conf)
+	require.NoError(t, err)
+
+	// A static lease outside of all the pools must be allowed and mustn't
+	// take any of the pool addresses.
+	err = s.AddStaticLease(&dhcpsvc.Lease{
+		Hostname: "static.local",
+		HWAddr:   net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0x00},
+		IP:       netip.MustParseAddr("192.168.10.50"),
+	})
+	require.NoError(t, err)
+
+	wantIPs := []netip.Addr{
+		netip.MustParseAddr("192.168.10.100"),
+		netip.MustParseAddr("192.168.10.101"),
+		netip.MustParseAddr("192.168.10.150"),
+		netip.MustParseAddr("192.168.10.151"),
+	}
+
+	for i, want := range wantIPs {
+		mac := net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, byte(i + 1)}
+
+		l, resErr := s.reserveLease(mac)
+		require.NoError(t, resErr)
+		require.NotNil(t, l)
+
+		assert.Equal(t, want, l.IP)
+
+		l.Expiry = time.Now().Add(time.Hour)
+	}
+
+	// All the pools are exhausted and there are no expired leases.
+	l, err := s.reserveLease(net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xFF})
+	require.NoError(t, err)
+
+	assert.Nil(t, l)
+
+	// Freeing an address in the first pool makes it available again.
+	i := slices.IndexFunc(s.leases, func(l *dhcpsvc.Lease) (ok bool) {
+		return l.IP == wantIPs[1]
+	})
+	require.NotEqual(t, -1, i)
+
+	s.rmLeaseByIndex(i)
+
+	l, err = s.reserveLease(net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xFE})
+	require.NoError(t, err)
+	require.NotNil(t, l)
+
+	assert.Equal(t, wantIPs[1], l.IP)
+}
+
+func TestV4ServerConf_Validate_additionalRanges(t *testing.T) {
+	testCases := []struct {
+		name       string
+		wantErrMsg string
+		ranges     []V4Range
+	}{{
+		name:       "none",
+		wantErrMsg: "",
+		ranges:     nil,
+	}, {
+		name:       "valid",
+		wantErrMsg: "",
+		ranges: []V4Range{{
+			Start: netip.MustParseAddr("192.168.10.10"),
+			End:   netip.MustParseAddr("192.168.10.20"),
+		}, {
+			Start: netip.MustParseAddr("192.168.10.220"),
+			End:   netip.MustParseAddr("192.168.10.250"),
+		}},
+	}, {
+		name: "overlaps_main",
+		wantErrMsg: "dhcpv4: ip range 192.168.10.100-192.168.10.200 overlaps with " +
+			"192.168.10.150-192.168.10.250",
+		ranges: []V4Range{{
+			Start: netip.MustParseAddr("192.168.10.150"),
+			End:   netip.MustParseAddr("192.168.10.250"),
+		}},
+	}, {
+		name: "overlap_each_other",
+		wantErrMsg: "dhcpv4: ip range 192.168.10.10-192.168.10.20 overlaps with " +
+			"192.168.10.20-192.168.10.30",
+		ranges: []V4Range{{
+			Start: netip.MustParseAddr("192.168.10.20"),
+			End:   netip.MustParseAddr("192.168.10.30"),
+		}, {
+			Start: netip.MustParseAddr("192.168.10.10"),
+			End:   netip.MustParseAddr("192.168.10.20"),
+		}},
+	}, {
+		name: "outside_subnet",
+		wantErrMsg: "dhcpv4: additional range at index 0: " +
+			"range end 192.168.11.20 is outside network 192.168.10.1/24",
+		ranges: []V4Range{{
+			Start: netip.MustParseAddr("192.168.10.210"),
+			End:   netip.MustParseAddr("192.168.11.20"),
+		}},
+	}, {
+		name: "gateway",
+		wantErrMsg: "dhcpv4: additional range at index 0: " +
+			"gateway ip 192.168.10.1 in the ip range: 192.168.10.1-192.168.10.20",
+		ranges: []V4Range{{
+			Start: netip.MustParseAddr("192.168.10.1"),
+			End:   netip.MustParseAddr("192.168.10.20"),
+		}},
+	}, {
+		name:       "bad_address",
+		wantErrMsg: "dhcpv4: additional range at index 0: invalid IP is not an IPv4 address",
+		ranges: []V4Range{{
+			End: netip.MustParseAddr("192.168.10.20"),
+		}},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conf := defaultV4ServerConf()
+			conf.AdditionalRanges = tc.ranges
+
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, conf.Validate())
+		})
+	}
+}
+
+// newDynamicLease reserves and commits a dynamic lease with hostname for mac in
+// s.
+func newDynamicLease(
+	t *testing.T,
+	s *v4Server,
+	mac net.HardwareAddr,
+	hostname string,
+) (l *dhcpsvc.Lease) {
+	t.Helper()
+
+	s.leasesLock.Lock()
+	defer s.leasesLock.Unlock()
+
+	l, err := s.reserveLease(mac)
+	require.NoError(t, err)
+	require.NotNil(t, l)
+
+	s.commitLease(l, hostname)
+
+	return l
+}
+
+func TestV4Server_MakeStaticLease(t *testing.T) {
+	s := defaultSrv(t)
+
+	s4, ok := s.(*v4Server)
+	require.True(t, ok)
+
+	firstMAC := net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0x01}
+	first := newDynamicLease(t, s4, firstMAC, "first-client")
+
+	secondMAC := net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0x02}
+	second := newDynamicLease(t, s4, secondMAC, "second-client")
+
+	testCases := []struct {
+		ip            netip.Addr
+		mac           net.HardwareAddr
+		wantLease     *dhcpsvc.Lease
+		name          string
+		wantErrMsg    string
+		wantStaticNum int
+	}{{
+		ip:  first.IP,
+		mac: nil,
+		wantLease: &dhcpsvc.Lease{
+			HWAddr:   firstMAC,
+			IP:       first.IP,
+			Hostname: "first-client",
+			IsStatic: true,
+		},
+		name:          "by_ip",
+		wantErrMsg:    "",
+		wantStaticNum: 1,
+	}, {
+		ip:            first.IP,
+		mac:           nil,
+		wantLease:     nil,
+		name:          "already_static",
+		wantErrMsg:    "dhcpv4: making static lease: lease is already static",
+		wantStaticNum: 1,
+	}, {
+		ip:  netip.Addr{},
+		mac: secondMAC,
+		wantLease: &dhcpsvc.Lease{
+			HWAddr:   secondMAC,
+			IP:       second.IP,
+			Hostname: "second-client",
+			IsStatic: true,
+		},
+		name:          "by_mac",
+		wantErrMsg:    "",
+		wantStaticNum: 2,
+	}, {
+		ip:            netip.MustParseAddr("192.168.10.199"),
+		mac:           nil,
+		wantLease:     nil,
+		name:          "not_found",
+		wantErrMsg:    "dhcpv4: making static lease: lease not found",
+		wantStaticNum: 2,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			l, err := s.MakeStaticLease(tc.ip, tc.mac)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+
+			assert.Equal(t, tc.wantLease, l)
+			assert.Len(t, s.GetLeases(LeasesStatic), tc.wantStaticNum)
+			assert.Len(t, s.GetLeases(LeasesDynamic), 2-tc.wantStaticNum)
+		})
+	}
+}
+
+func TestV4Server_MakeStaticLease_concurrentRenew(t *testing.T) {
+	const (
+		hostname = "renewing-client"
+		renewNum = 100
+	)
+
+	s := defaultSrv(t)
+
+	s4, ok := s.(*v4Server)
+	require.True(t, ok)
+
+	mac := net.HardwareAddr{0xAA, 0xAA, 0xAA, 0xAA, 0xAA, 0xAA}
+	dyn := newDynamicLease(t, s4, mac, hostname)
+	ip := net.IP(dyn.IP.AsSlice())
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		for range renewNum {
+			req, reqErr := dhcpv4.New(
+				dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest),
+				dhcpv4.WithHwAddr(mac),
+				dhcpv4.WithClientIP(ip),
+				dhcpv4.WithOption(dhcpv4.OptHostName(hostname)),
+			)
+			require.NoError(testutil.PanicT{}, reqErr)
+
+			resp, respErr := dhcpv4.NewReplyFromRequest(req)
+			require.NoError(testutil.PanicT{}, respErr)
+
+			assert.Equal(t, 1, s4.handle(req, resp))
+		}
+	}()
+
+	l, err := s.MakeStaticLease(dyn.IP, nil)
+	require.NoError(t, err)
+
+	<-done
+
+	assert.True(t, l.IsStatic)
+	assert.Equal(t, hostname, l.Hostname)
+
+	s4.leasesLock.Lock()
+	defer s4.leasesLock.Unlock()
+
+	require.Len(t, s4.leases, 1)
+
+	cur := s4.leases[0]
+	assert.True(t, cur.IsStatic)
+	assert.Same(t, cur, s4.ipIndex[dyn.IP])
+	assert.Same(t, cur, s4.hostsIndex[hostname])
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net"
 	"net/netip"
+	"slices"
 	"sync"
 	"time"
 
@@ -32,7 +33,12 @@ type v6Server struct {
 	sid  dhcpv6.DUID
 	srv  *server6.Server
 
-	leases     []*dhcpsvc.Lease
+	leases []*dhcpsvc.Lease
+
+	// hostsIndex is an index of leases by their hostnames.
+	hostsIndex map[string]*dhcpsvc.Lease
+
+	// leasesLock protects leases, hostsIndex, and ipAddrs.
 	leasesLock sync.Mutex
 	ipAddrs    [256]byte
 }
@@ -46,6 +52,18 @@ func (s *v6Server) WriteDiskConfig6(c *V6ServerConf) {
 	*c = s.conf
 }
 
+// ReloadFingerprints implements the [DHCPServer] interface for *v6Server.  The
+// fingerprints are only supported for DHCPv4, so it does nothing.
+func (s *v6Server) ReloadFingerprints() (err error) {
+	return nil
+}
+
+// NAKCounts implements the [DHCPServer] interface for *v6Server.  DHCPv6 has no
+// DHCPNAK messages, so it always returns nil.
+func (s *v6Server) NAKCounts() (counts map[string]uint64) {
+	return nil
+}
+
 // Return TRUE if IP address is within range [start..0xff]
 func ip6InRange(start, ip net.IP) bool {
 	if len(start) != 16 {
@@ -78,10 +96,8 @@ func (s *v6Server) IPByHost(host string) (ip netip.Addr) {
 	s.leasesLock.Lock()
 	defer s.leasesLock.Unlock()
 
-	for _, l := range s.leases {
-		if l.Hostname == host {
-			return l.IP
-		}
+	if l, ok := s.hostsIndex[host]; ok {
+		return l.IP
 	}
 
 	return netip.Addr{}
@@ -95,6 +111,7 @@ func (s *v6Server) ResetLeases(leases []*dhcpsvc.Lease) (err error) {
 	defer s.leasesLock.Unlock()
 
 	s.leases = nil
+	s.hostsIndex = make(map[string]*dhcpsvc.Lease, len(leases))
 	for _, l := range leases {
 		ip := net.IP(l.IP.AsSlice())
 		if !l.IsStatic && !ip6InRange(s.conf.ipStart, ip) {
@@ -164,9 +181,14 @@ func (s *v6Server) FindMACbyIP(ip netip.Addr) (mac net.HardwareAddr) {
 
 // Remove (swap) lease by index
 func (s *v6Server) leaseRemoveSwapByIndex(i int) {
-	leaseIP := s.leases[i].IP.As16()
+	l := s.leases[i]
+	leaseIP := l.IP.As16()
 	s.ipAddrs[leaseIP[15]] = 0
-	log.Debug("dhcpv6: removed lease %s", s.leases[i].HWAddr)
+	if s.hostsIndex[l.Hostname] == l {
+		delete(s.hostsIndex, l.Hostname)
+	}
+
+	log.Debug("dhcpv6: removed lease %s", l.HWAddr)
 
 	n := len(s.leases)
 	if i != n-1 {
@@ -269,6 +291,70 @@ func (s *v6Server) UpdateStaticLease(l *dhcpsvc.Lease) (err error) {
 	return nil
 }
 
+// MakeStaticLease implements the [DHCPServer] interface for *v6Server.  It is
+// safe for concurrent use.
+func (s *v6Server) MakeStaticLease(
+	ip netip.Addr,
+	mac net.HardwareAddr,
+) (l *dhcpsvc.Lease, err error) {
+	defer func() { err = errors.Annotate(err, "dhcpv6: making static lease: %w") }()
+
+	s.leasesLock.Lock()
+	l, err = s.makeStaticLease(ip, mac)
+	s.leasesLock.Unlock()
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	s.conf.notify(LeaseChangedDBStore)
+	s.conf.notify(LeaseChangedAddedStatic)
+
+	return l, nil
+}
+
+// makeStaticLease replaces the dynamic lease with ip or, if ip is not valid,
+// with mac by a static one with the same properties and returns its clone.
+// s.leasesLock is expected to be locked.
+func (s *v6Server) makeStaticLease(
+	ip netip.Addr,
+	mac net.HardwareAddr,
+) (l *dhcpsvc.Lease, err error) {
+	var found *dhcpsvc.Lease
+	if ip.IsValid() {
+		i := slices.IndexFunc(s.leases, func(l *dhcpsvc.Lease) (ok bool) { return l.IP == ip })
+		if i != -1 {
+			found = s.leases[i]
+		}
+	} else {
+		found = s.findLease(mac)
+	}
+
+	if found == nil {
+		return nil, errLeaseNotFound
+	} else if found.IsStatic {
+		return nil, errLeaseIsStatic
+	}
+
+	l = &dhcpsvc.Lease{
+		HWAddr:   slices.Clone(found.HWAddr),
+		IP:       found.IP,
+		Hostname: found.Hostname,
+		OSName:   found.OSName,
+		IsStatic: true,
+	}
+
+	err = s.rmDynamicLease(l)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	s.addLease(l)
+
+	return l.Clone(), nil
+}
+
 // RemoveStaticLease removes a static lease.  It is safe for concurrent use.
 func (s *v6Server) RemoveStaticLease(l *dhcpsvc.Lease) (err error) {
 	defer func() { err = errors.Annotate(err, "dhcpv6: %w") }()
@@ -299,6 +385,9 @@ func (s *v6Server) addLease(l *dhcpsvc.Lease) {
 	s.leases = append(s.leases, l)
 	ip := l.IP.As16()
 	s.ipAddrs[ip[15]] = 1
+	if l.Hostname != "" {
+		s.hostsIndex[l.Hostname] = l
+	}
 	log.Debug("dhcpv6: added lease %s <-> %s", l.IP, l.HWAddr)
 }
 
@@ -788,7 +877,9 @@ func (s *v6Server) Stop() (err error) {
 
 // Create DHCPv6 server
 func v6Create(conf V6ServerConf) (DHCPServer, error) {
-	s := &v6Server{}
+	s := &v6Server{
+		hostsIndex: map[string]*dhcpsvc.Lease{},
+	}
 	s.conf = conf
 
 	if !conf.Enabled {
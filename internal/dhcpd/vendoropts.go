@@ -0,0 +1,99 @@
+package dhcpd
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/errors"
+)
+
+// VendorOption is the vendor-specific information, DHCP option 43, sent to the
+// DHCPv4 clients of a particular vendor class.
+type VendorOption struct {
+	// VendorClass is the prefix of the vendor class identifier, DHCP option 60,
+	// of the clients.  It must not be empty.
+	VendorClass string `yaml:"vendor_class" json:"vendor_class"`
+
+	// Data is the hex-encoded payload of DHCP option 43.  It must not be
+	// empty.
+	Data string `yaml:"data" json:"data"`
+}
+
+// maxVendorOptionLen is the maximum length of the payload of a DHCP option.
+const maxVendorOptionLen = 255
+
+// vendorOption is the parsed [VendorOption].
+type vendorOption struct {
+	// class is the prefix of the vendor class identifier.
+	class string
+
+	// data is the decoded payload of DHCP option 43.
+	data []byte
+}
+
+// parseVendorOptions validates and decodes opts.
+func parseVendorOptions(opts []VendorOption) (parsed []*vendorOption, err error) {
+	var errs []error
+	for i, o := range opts {
+		var data []byte
+		data, err = parseVendorOption(o)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("vendor specific option at index %d: %w", i, err))
+
+			continue
+		}
+
+		parsed = append(parsed, &vendorOption{
+			class: o.VendorClass,
+			data:  data,
+		})
+	}
+
+	err = errors.Join(errs...)
+	if err != nil {
+		return nil, err
+	}
+
+	return parsed, nil
+}
+
+// parseVendorOption returns the decoded payload of o.
+func parseVendorOption(o VendorOption) (data []byte, err error) {
+	if o.VendorClass == "" {
+		return nil, fmt.Errorf("vendor_class: %w", errors.ErrEmptyValue)
+	}
+
+	data, err = hex.DecodeString(o.Data)
+	if err != nil {
+		return nil, fmt.Errorf("data: %w", err)
+	} else if len(data) == 0 {
+		return nil, fmt.Errorf("data: %w", errors.ErrEmptyValue)
+	} else if len(data) > maxVendorOptionLen {
+		return nil, fmt.Errorf("data: too long: got %d bytes, max %d", len(data), maxVendorOptionLen)
+	}
+
+	return data, nil
+}
+
+// matchVendorOption returns the payload of the option with the longest vendor
+// class, which is a prefix of class.  data is nil if class is empty or there
+// is no such option.
+func matchVendorOption(opts []*vendorOption, class string) (data []byte) {
+	if class == "" {
+		return nil
+	}
+
+	var matched *vendorOption
+	for _, o := range opts {
+		if strings.HasPrefix(class, o.class) && (matched == nil || len(o.class) > len(matched.class)) {
+			matched = o
+		}
+	}
+
+	if matched == nil {
+		return nil
+	}
+
+	return matched.data
+}
@@ -1,6 +1,7 @@
 package dhcpsvc
 
 import (
+	"math"
 	"net"
 	"net/netip"
 	"slices"
@@ -26,8 +27,78 @@ type Lease struct {
 	// HWAddr is the physical hardware address (MAC address).
 	HWAddr net.HardwareAddr
 
+	// OSName is the name of the client's operating system detected by its DHCP
+	// fingerprint.  It's empty if the operating system isn't known.
+	OSName string
+
+	// LeaseDuration is the lease time offered to the client instead of the
+	// server's default one.  Zero means the default should be used.  It's only
+	// meaningful for static leases.
+	LeaseDuration time.Duration
+
 	// IsStatic defines if the lease is static.
 	IsStatic bool
+
+	// Online is true if the client has replied to the latest presence probe.
+	// It's only set for the dynamic leases and only if the presence sweep is
+	// enabled.
+	Online bool
+}
+
+// LeaseDurationInfinite is the value of [Lease.LeaseDuration] meaning that the
+// lease never expires.  It's encoded as the DHCP infinite lease time, see RFC
+// 2131, section 3.3.
+const LeaseDurationInfinite time.Duration = math.MaxUint32 * time.Second
+
+// LeaseState is the renewal state of a lease.
+type LeaseState string
+
+// Lease states.
+const (
+	// LeaseStateActive means that the lease hasn't reached its renewal time
+	// (T1) yet.  Static leases are always active.
+	LeaseStateActive LeaseState = "active"
+
+	// LeaseStatePastT1 means that the lease has reached its renewal time (T1)
+	// without being renewed.
+	LeaseStatePastT1 LeaseState = "past_t1"
+
+	// LeaseStatePastT2 means that the lease has reached its rebinding time
+	// (T2) without being renewed, so the client is likely gone.
+	LeaseStatePastT2 LeaseState = "past_t2"
+
+	// LeaseStateExpired means that the lease has expired.
+	LeaseStateExpired LeaseState = "expired"
+)
+
+// RenewalTimes returns the renewal (T1) and the rebinding (T2) times of l,
+// which has been granted for dur.  The lease is assumed to be renewed last at
+// its expiry time minus dur.  The times are the default ones from RFC 2131,
+// section 4.4.5, that is 0.5 and 0.875 of dur.
+func (l *Lease) RenewalTimes(dur time.Duration) (t1, t2 time.Time) {
+	renewed := l.Expiry.Add(-dur)
+
+	return renewed.Add(dur / 2), renewed.Add(dur - dur/8)
+}
+
+// State returns the renewal state of l, which has been granted for dur, at
+// now.
+func (l *Lease) State(dur time.Duration, now time.Time) (s LeaseState) {
+	if l.IsStatic {
+		return LeaseStateActive
+	}
+
+	t1, t2 := l.RenewalTimes(dur)
+	switch {
+	case !now.Before(l.Expiry):
+		return LeaseStateExpired
+	case !now.Before(t2):
+		return LeaseStatePastT2
+	case !now.Before(t1):
+		return LeaseStatePastT1
+	default:
+		return LeaseStateActive
+	}
 }
 
 // Clone returns a deep copy of l.
@@ -37,10 +108,13 @@ func (l *Lease) Clone() (clone *Lease) {
 	}
 
 	return &Lease{
-		Expiry:   l.Expiry,
-		Hostname: l.Hostname,
-		HWAddr:   slices.Clone(l.HWAddr),
-		IP:       l.IP,
-		IsStatic: l.IsStatic,
+		Expiry:        l.Expiry,
+		Hostname:      l.Hostname,
+		HWAddr:        slices.Clone(l.HWAddr),
+		IP:            l.IP,
+		OSName:        l.OSName,
+		LeaseDuration: l.LeaseDuration,
+		IsStatic:      l.IsStatic,
+		Online:        l.Online,
 	}
 }
@@ -0,0 +1,73 @@
+package dhcpsvc_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/dhcpsvc"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLease_State(t *testing.T) {
+	const dur = 8 * time.Hour
+
+	renewed := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	l := &dhcpsvc.Lease{
+		Expiry: renewed.Add(dur),
+	}
+
+	t1, t2 := l.RenewalTimes(dur)
+	assert.Equal(t, renewed.Add(4*time.Hour), t1)
+	assert.Equal(t, renewed.Add(7*time.Hour), t2)
+
+	testCases := []struct {
+		now      time.Time
+		name     string
+		want     dhcpsvc.LeaseState
+		isStatic bool
+	}{{
+		now:      renewed,
+		name:     "renewed",
+		want:     dhcpsvc.LeaseStateActive,
+		isStatic: false,
+	}, {
+		now:      t1.Add(-time.Second),
+		name:     "before_t1",
+		want:     dhcpsvc.LeaseStateActive,
+		isStatic: false,
+	}, {
+		now:      t1,
+		name:     "t1",
+		want:     dhcpsvc.LeaseStatePastT1,
+		isStatic: false,
+	}, {
+		now:      t2,
+		name:     "t2",
+		want:     dhcpsvc.LeaseStatePastT2,
+		isStatic: false,
+	}, {
+		now:      l.Expiry.Add(-time.Second),
+		name:     "before_expiry",
+		want:     dhcpsvc.LeaseStatePastT2,
+		isStatic: false,
+	}, {
+		now:      l.Expiry,
+		name:     "expired",
+		want:     dhcpsvc.LeaseStateExpired,
+		isStatic: false,
+	}, {
+		now:      l.Expiry.Add(time.Hour),
+		name:     "static",
+		want:     dhcpsvc.LeaseStateActive,
+		isStatic: true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			lease := l.Clone()
+			lease.IsStatic = tc.isStatic
+
+			assert.Equal(t, tc.want, lease.State(dur, tc.now))
+		})
+	}
+}
@@ -1,8 +1,10 @@
 package dnsforward
 
 import (
+	"cmp"
 	"encoding/json"
 	"fmt"
+	"maps"
 	"net/http"
 	"net/netip"
 	"slices"
@@ -10,6 +12,7 @@ import (
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghalg"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/dnsproxy/proxy"
 	"github.com/AdguardTeam/golibs/container"
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/AdguardTeam/golibs/stringutil"
@@ -18,6 +21,86 @@ import (
 	"github.com/AdguardTeam/urlfilter/rules"
 )
 
+// AccessProto is the name of a client protocol, to which the access lists of
+// clients can be scoped.
+type AccessProto string
+
+// Access protocols.
+const (
+	AccessProtoPlain    AccessProto = "plain"
+	AccessProtoDoT      AccessProto = "dot"
+	AccessProtoDoH      AccessProto = "doh"
+	AccessProtoDoQ      AccessProto = "doq"
+	AccessProtoDNSCrypt AccessProto = "dnscrypt"
+)
+
+// validate returns an error if p isn't a known access protocol.
+func (p AccessProto) validate() (err error) {
+	switch p {
+	case
+		AccessProtoPlain,
+		AccessProtoDoT,
+		AccessProtoDoH,
+		AccessProtoDoQ,
+		AccessProtoDNSCrypt:
+		return nil
+	default:
+		return fmt.Errorf("unknown protocol %q", p)
+	}
+}
+
+// accessProtoFromProxy returns the access protocol of the requests received
+// over the proxy protocol p.
+func accessProtoFromProxy(p proxy.Proto) (ap AccessProto) {
+	switch p {
+	case proxy.ProtoHTTPS:
+		return AccessProtoDoH
+	case proxy.ProtoQUIC:
+		return AccessProtoDoQ
+	case proxy.ProtoTLS:
+		return AccessProtoDoT
+	case proxy.ProtoDNSCrypt:
+		return AccessProtoDNSCrypt
+	default:
+		// Consider this a plain DNS-over-UDP or DNS-over-TCP request.
+		return AccessProtoPlain
+	}
+}
+
+// ProtocolAccessList is the access lists of clients scoped to a single client
+// protocol.
+type ProtocolAccessList struct {
+	// AllowedClients is the slice of IP addresses, CIDR networks, and
+	// ClientIDs of allowed clients.  If not empty, only these clients are
+	// allowed, and DisallowedClients are ignored.
+	AllowedClients []string `yaml:"allowed_clients" json:"allowed_clients"`
+
+	// DisallowedClients is the slice of IP addresses, CIDR networks, and
+	// ClientIDs of disallowed clients.
+	DisallowedClients []string `yaml:"disallowed_clients" json:"disallowed_clients"`
+}
+
+// cloneProtocolAccess returns a deep copy of lists.  The result is never nil.
+func cloneProtocolAccess(
+	lists map[AccessProto]*ProtocolAccessList,
+) (clone map[AccessProto]*ProtocolAccessList) {
+	clone = make(map[AccessProto]*ProtocolAccessList, len(lists))
+	for p, l := range lists {
+		if l == nil {
+			clone[p] = nil
+
+			continue
+		}
+
+		clone[p] = &ProtocolAccessList{
+			AllowedClients:    slices.Clone(l.AllowedClients),
+			DisallowedClients: slices.Clone(l.DisallowedClients),
+		}
+	}
+
+	return clone
+}
+
 // accessManager controls IP and client blocking that takes place before all
 // other processing.  An accessManager is safe for concurrent use.
 type accessManager struct {
@@ -30,6 +113,11 @@ type accessManager struct {
 	// TODO(s.chzhen):  Use [aghnet.IgnoreEngine].
 	blockedHostsEng *urlfilter.DNSEngine
 
+	// protoAccess are the access managers with the client lists of the
+	// protocols, which have their own lists.  Their blocked hosts are always
+	// empty, since the blocked hosts aren't scoped to protocols.
+	protoAccess map[AccessProto]*accessManager
+
 	// TODO(a.garipov): Create a type for an efficient tree set of IP networks.
 	allowedNets []netip.Prefix
 	blockedNets []netip.Prefix
@@ -63,14 +151,23 @@ func processAccessClients(
 	return nil
 }
 
-// newAccessCtx creates a new accessCtx.
-func newAccessCtx(allowed, blocked, blockedHosts []string) (a *accessManager, err error) {
+// newAccessCtx creates a new accessCtx.  protoLists are the client lists
+// scoped to the protocols, they replace allowed and blocked for the requests
+// received over these protocols.
+func newAccessCtx(
+	allowed []string,
+	blocked []string,
+	blockedHosts []string,
+	protoLists map[AccessProto]*ProtocolAccessList,
+) (a *accessManager, err error) {
 	a = &accessManager{
 		allowedIPs: container.NewMapSet[netip.Addr](),
 		blockedIPs: container.NewMapSet[netip.Addr](),
 
 		allowedClientIDs: container.NewMapSet[string](),
 		blockedClientIDs: container.NewMapSet[string](),
+
+		protoAccess: make(map[AccessProto]*accessManager, len(protoLists)),
 	}
 
 	err = processAccessClients(allowed, a.allowedIPs, &a.allowedNets, a.allowedClientIDs)
@@ -103,9 +200,37 @@ func newAccessCtx(allowed, blocked, blockedHosts []string) (a *accessManager, er
 
 	a.blockedHostsEng = urlfilter.NewDNSEngine(rulesStrg)
 
+	for _, p := range slices.Sorted(maps.Keys(protoLists)) {
+		err = p.validate()
+		if err != nil {
+			return nil, fmt.Errorf("protocol access: %w", err)
+		}
+
+		l := protoLists[p]
+		if l == nil {
+			return nil, fmt.Errorf("protocol access: %q: no value", p)
+		}
+
+		a.protoAccess[p], err = newAccessCtx(l.AllowedClients, l.DisallowedClients, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("protocol access: %q: %w", p, err)
+		}
+	}
+
 	return a, nil
 }
 
+// forProto returns the access manager with the client lists for the requests
+// received over proto.  Note that only the client lists of the returned
+// manager are scoped to proto, so the blocked hosts must be checked using a.
+func (a *accessManager) forProto(proto AccessProto) (pa *accessManager) {
+	if pa = a.protoAccess[proto]; pa != nil {
+		return pa
+	}
+
+	return a
+}
+
 // allowlistMode returns true if this *accessCtx is in the allowlist mode.
 func (a *accessManager) allowlistMode() (ok bool) {
 	return a.allowedIPs.Len() != 0 || a.allowedClientIDs.Len() != 0 || len(a.allowedNets) != 0
@@ -167,7 +292,40 @@ func (a *accessManager) isBlockedIP(ip netip.Addr) (blocked bool, rule string) {
 	return !blocked, ""
 }
 
+// isBlockedClient returns true if the client is blocked by the client lists of
+// a as well as the rule that blocked it.
+func (a *accessManager) isBlockedClient(ip netip.Addr, clientID string) (blocked bool, rule string) {
+	blockedByIP := false
+	if ip != (netip.Addr{}) {
+		blockedByIP, rule = a.isBlockedIP(ip)
+	}
+
+	allowlistMode := a.allowlistMode()
+	blockedByClientID := a.isBlockedClientID(clientID)
+
+	// Allow if at least one of the checks allows in allowlist mode, but block
+	// if at least one of the checks blocks in blocklist mode.
+	if allowlistMode && blockedByIP && blockedByClientID {
+		log.Debug("dnsforward: client %v (id %q) is not in access allowlist", ip, clientID)
+
+		// Return now without substituting the empty rule for the
+		// clientID because the rule can't be empty here.
+		return true, rule
+	} else if !allowlistMode && (blockedByIP || blockedByClientID) {
+		log.Debug("dnsforward: client %v (id %q) is in access blocklist", ip, clientID)
+
+		blocked = true
+	}
+
+	return blocked, cmp.Or(rule, clientID)
+}
+
+// accessListJSON is the JSON representation of the access settings.
 type accessListJSON struct {
+	// ProtocolAccess are the client lists scoped to the protocols.  If it's
+	// nil in a request, the current ones are kept.
+	ProtocolAccess map[AccessProto]*ProtocolAccessList `json:"protocol_access"`
+
 	AllowedClients    []string `json:"allowed_clients"`
 	DisallowedClients []string `json:"disallowed_clients"`
 	BlockedHosts      []string `json:"blocked_hosts"`
@@ -178,6 +336,7 @@ func (s *Server) accessListJSON() (j accessListJSON) {
 	defer s.serverLock.RUnlock()
 
 	return accessListJSON{
+		ProtocolAccess:    cloneProtocolAccess(s.conf.ProtocolAccess),
 		AllowedClients:    slices.Clone(s.conf.AllowedClients),
 		DisallowedClients: slices.Clone(s.conf.DisallowedClients),
 		BlockedHosts:      slices.Clone(s.conf.BlockedHosts),
@@ -193,22 +352,51 @@ func (s *Server) handleAccessList(w http.ResponseWriter, r *http.Request) {
 // duplicates, we cannot compare the new stringutil.Set and []string, because
 // creating a set for a large array can be an unnecessary algorithmic complexity
 func validateAccessSet(list *accessListJSON) (err error) {
-	allowed, err := validateStrUniq(list.AllowedClients)
+	err = validateClientLists(list.AllowedClients, list.DisallowedClients)
 	if err != nil {
-		return fmt.Errorf("validating allowed clients: %w", err)
+		// Don't wrap the error since it's informative enough as is.
+		return err
 	}
 
-	disallowed, err := validateStrUniq(list.DisallowedClients)
+	_, err = validateStrUniq(list.BlockedHosts)
 	if err != nil {
-		return fmt.Errorf("validating disallowed clients: %w", err)
+		return fmt.Errorf("validating blocked hosts: %w", err)
 	}
 
-	_, err = validateStrUniq(list.BlockedHosts)
+	for _, p := range slices.Sorted(maps.Keys(list.ProtocolAccess)) {
+		err = p.validate()
+		if err != nil {
+			return fmt.Errorf("validating protocol access: %w", err)
+		}
+
+		l := list.ProtocolAccess[p]
+		if l == nil {
+			return fmt.Errorf("validating protocol access: %q: no value", p)
+		}
+
+		err = validateClientLists(l.AllowedClients, l.DisallowedClients)
+		if err != nil {
+			return fmt.Errorf("validating protocol access: %q: %w", p, err)
+		}
+	}
+
+	return nil
+}
+
+// validateClientLists returns an error if allowed or disallowed contain
+// duplicates or intersect.
+func validateClientLists(allowed, disallowed []string) (err error) {
+	allowedUC, err := validateStrUniq(allowed)
 	if err != nil {
-		return fmt.Errorf("validating blocked hosts: %w", err)
+		return fmt.Errorf("validating allowed clients: %w", err)
 	}
 
-	merged := allowed.Merge(disallowed)
+	disallowedUC, err := validateStrUniq(disallowed)
+	if err != nil {
+		return fmt.Errorf("validating disallowed clients: %w", err)
+	}
+
+	merged := allowedUC.Merge(disallowedUC)
 	err = merged.Validate()
 	if err != nil {
 		return fmt.Errorf("items in allowed and disallowed clients intersect: %w", err)
@@ -244,8 +432,20 @@ func (s *Server) handleAccessSet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if list.ProtocolAccess == nil {
+		// Keep the current protocol lists for the clients unaware of them.
+		s.serverLock.RLock()
+		list.ProtocolAccess = cloneProtocolAccess(s.conf.ProtocolAccess)
+		s.serverLock.RUnlock()
+	}
+
 	var a *accessManager
-	a, err = newAccessCtx(list.AllowedClients, list.DisallowedClients, list.BlockedHosts)
+	a, err = newAccessCtx(
+		list.AllowedClients,
+		list.DisallowedClients,
+		list.BlockedHosts,
+		list.ProtocolAccess,
+	)
 	if err != nil {
 		aghhttp.Error(r, w, http.StatusBadRequest, "creating access ctx: %s", err)
 
@@ -253,10 +453,11 @@ func (s *Server) handleAccessSet(w http.ResponseWriter, r *http.Request) {
 	}
 
 	defer log.Debug(
-		"access: updated lists: %d, %d, %d",
+		"access: updated lists: %d, %d, %d, %d protocols",
 		len(list.AllowedClients),
 		len(list.DisallowedClients),
 		len(list.BlockedHosts),
+		len(list.ProtocolAccess),
 	)
 
 	defer s.conf.ConfigModified()
@@ -267,5 +468,9 @@ func (s *Server) handleAccessSet(w http.ResponseWriter, r *http.Request) {
 	s.conf.AllowedClients = list.AllowedClients
 	s.conf.DisallowedClients = list.DisallowedClients
 	s.conf.BlockedHosts = list.BlockedHosts
-	s.access = a
+	s.conf.ProtocolAccess = list.ProtocolAccess
+
+	// Replace the access settings without reconfiguring the server, since the
+	// queries being processed don't lock it.
+	s.access.Store(a)
 }
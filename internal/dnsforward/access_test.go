@@ -4,6 +4,7 @@ import (
 	"net/netip"
 	"testing"
 
+	"github.com/AdguardTeam/golibs/testutil"
 	"github.com/AdguardTeam/urlfilter/rules"
 	"github.com/miekg/dns"
 	"github.com/stretchr/testify/assert"
@@ -14,12 +15,12 @@ func TestIsBlockedClientID(t *testing.T) {
 	clientID := "client-1"
 	clients := []string{clientID}
 
-	a, err := newAccessCtx(clients, nil, nil)
+	a, err := newAccessCtx(clients, nil, nil, nil)
 	require.NoError(t, err)
 
 	assert.False(t, a.isBlockedClientID(clientID))
 
-	a, err = newAccessCtx(nil, clients, nil)
+	a, err = newAccessCtx(nil, clients, nil, nil)
 	require.NoError(t, err)
 
 	assert.True(t, a.isBlockedClientID(clientID))
@@ -32,7 +33,7 @@ func TestIsBlockedHost(t *testing.T) {
 		"||host3.com^",
 		"||*^$dnstype=HTTPS",
 		"|.^",
-	})
+	}, nil)
 	require.NoError(t, err)
 
 	testCases := []struct {
@@ -115,10 +116,10 @@ func TestIsBlockedIP(t *testing.T) {
 		"5.6.7.8/24",
 	}
 
-	allowCtx, err := newAccessCtx(clients, nil, nil)
+	allowCtx, err := newAccessCtx(clients, nil, nil, nil)
 	require.NoError(t, err)
 
-	blockCtx, err := newAccessCtx(nil, clients, nil)
+	blockCtx, err := newAccessCtx(nil, clients, nil, nil)
 	require.NoError(t, err)
 
 	testCases := []struct {
@@ -164,3 +165,78 @@ func TestIsBlockedIP(t *testing.T) {
 		}
 	})
 }
+
+func TestAccessManager_forProto(t *testing.T) {
+	const clientID = "client-1"
+
+	lanIP := netip.MustParseAddr("192.168.1.1")
+	outsideIP := netip.MustParseAddr("203.0.113.1")
+
+	a, err := newAccessCtx(nil, []string{"198.51.100.1"}, nil, map[AccessProto]*ProtocolAccessList{
+		AccessProtoPlain: {
+			AllowedClients: []string{"192.168.0.0/16"},
+		},
+		AccessProtoDoT: {
+			AllowedClients: []string{clientID},
+		},
+	})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		ip          netip.Addr
+		name        string
+		clientID    string
+		proto       AccessProto
+		wantBlocked bool
+	}{{
+		ip:          lanIP,
+		name:        "plain_lan",
+		clientID:    "",
+		proto:       AccessProtoPlain,
+		wantBlocked: false,
+	}, {
+		ip:          outsideIP,
+		name:        "plain_outside",
+		clientID:    "",
+		proto:       AccessProtoPlain,
+		wantBlocked: true,
+	}, {
+		ip:          outsideIP,
+		name:        "dot_client_id",
+		clientID:    clientID,
+		proto:       AccessProtoDoT,
+		wantBlocked: false,
+	}, {
+		ip:          lanIP,
+		name:        "dot_no_client_id",
+		clientID:    "",
+		proto:       AccessProtoDoT,
+		wantBlocked: true,
+	}, {
+		ip:          outsideIP,
+		name:        "doh_global",
+		clientID:    "",
+		proto:       AccessProtoDoH,
+		wantBlocked: false,
+	}, {
+		ip:          netip.MustParseAddr("198.51.100.1"),
+		name:        "doh_global_blocked",
+		clientID:    "",
+		proto:       AccessProtoDoH,
+		wantBlocked: true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			blocked, _ := a.forProto(tc.proto).isBlockedClient(tc.ip, tc.clientID)
+			assert.Equal(t, tc.wantBlocked, blocked)
+		})
+	}
+
+	t.Run("unknown_proto", func(t *testing.T) {
+		_, err = newAccessCtx(nil, nil, nil, map[AccessProto]*ProtocolAccessList{
+			"bad": {},
+		})
+		testutil.AssertErrorMsg(t, `protocol access: unknown protocol "bad"`, err)
+	})
+}
@@ -15,8 +15,9 @@ import (
 var _ proxy.BeforeRequestHandler = (*Server)(nil)
 
 // HandleBefore is the handler that is called before any other processing,
-// including logs.  It performs access checks and puts the client ID, if there
-// is one, into the server's cache.
+// including logs.  It tracks the connections of the encrypted listeners,
+// performs access checks, applies the per-client rate limit, and puts the
+// client ID, if there is one, into the server's cache.
 //
 // TODO(d.kolyshev): Extract to separate package.
 func (s *Server) HandleBefore(
@@ -31,16 +32,31 @@ func (s *Server) HandleBefore(
 		}
 	}
 
-	blocked, _ := s.IsBlockedClient(pctx.Addr.Addr(), clientID)
-	if blocked {
+	s.conns.track(pctx, clientID)
+
+	// Use the same access settings for all the checks, even if they're
+	// replaced concurrently.
+	access := s.access.Load()
+
+	protoAccess := access.forProto(accessProtoFromProxy(pctx.Proto))
+	if blocked, _ := protoAccess.isBlockedClient(pctx.Addr.Addr(), clientID); blocked {
 		return s.preBlockedResponse(pctx)
 	}
 
+	if l := s.clientRateLimiter; l != nil && !l.allow(pctx.Addr.Addr()) {
+		log.Debug("dnsforward: client %s exceeded rate limit", pctx.Addr.Addr())
+
+		return &proxy.BeforeRequestError{
+			Err:      errClientRatelimited,
+			Response: s.makeResponseREFUSED(pctx.Req),
+		}
+	}
+
 	if len(pctx.Req.Question) == 1 {
 		q := pctx.Req.Question[0]
 		qt := q.Qtype
 		host := aghnet.NormalizeDomain(q.Name)
-		if s.access.isBlockedHost(host, qt) {
+		if access.isBlockedHost(host, qt) {
 			log.Debug("access: request %s %s is in access blocklist", dns.Type(qt), host)
 
 			return s.preBlockedResponse(pctx)
@@ -57,9 +73,17 @@ func (s *Server) HandleBefore(
 }
 
 // clientIDFromDNSContext extracts the client's ID from the server name of the
-// client's DoT or DoQ request or the path of the client's DoH.  If the protocol
-// is not one of these, clientID is an empty string and err is nil.
+// client's DoT or DoQ request, the path of the client's DoH, or the EDNS(0)
+// option of the plain-DNS request from a trusted forwarder.  If there is no
+// ClientID, clientID is an empty string and err is nil.
 func (s *Server) clientIDFromDNSContext(pctx *proxy.DNSContext) (clientID string, err error) {
+	clientID, err = s.clientIDFromEDNS(pctx)
+	if err != nil {
+		return "", fmt.Errorf("checking edns: %w", err)
+	} else if clientID != "" {
+		return clientID, nil
+	}
+
 	proto := pctx.Proto
 	if proto == proxy.ProtoHTTPS {
 		clientID, err = clientIDFromDNSContextHTTPS(pctx)
@@ -1,14 +1,21 @@
 package dnsforward
 
 import (
+	"bytes"
 	"crypto/tls"
+	"encoding/json"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
 	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/testutil"
 	"github.com/miekg/dns"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -297,3 +304,233 @@ func TestServer_HandleBefore_udp(t *testing.T) {
 		})
 	}
 }
+
+func TestServer_HandleBefore_protocolAccess(t *testing.T) {
+	t.Parallel()
+
+	const clientID = "client-1"
+
+	localAns := []dns.RR{&dns.A{
+		Hdr: dns.RR_Header{
+			Name:     testFQDN,
+			Rrtype:   dns.TypeA,
+			Class:    dns.ClassINET,
+			Ttl:      3600,
+			Rdlength: 4,
+		},
+		A: net.IP{1, 2, 3, 4},
+	}}
+	localUpsHdlr := dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		resp := (&dns.Msg{}).SetReply(req)
+		resp.Answer = localAns
+
+		require.NoError(t, w.WriteMsg(resp))
+	})
+	localUpsAddr := aghtest.StartLocalhostUpstream(t, localUpsHdlr).String()
+
+	s, _ := createTestTLS(t, TLSConfig{
+		TLSListenAddrs: []*net.TCPAddr{{}},
+		ServerName:     tlsServerName,
+	})
+
+	s.conf.UpstreamDNS = []string{localUpsAddr}
+
+	// The test clients connect from the loopback addresses, which are outside
+	// of the LAN.
+	s.conf.ProtocolAccess = map[AccessProto]*ProtocolAccessList{
+		AccessProtoPlain: {
+			AllowedClients: []string{"192.168.0.0/16"},
+		},
+		AccessProtoDoT: {
+			AllowedClients: []string{clientID},
+		},
+	}
+
+	err := s.Prepare(&s.conf)
+	require.NoError(t, err)
+
+	startDeferStop(t, s)
+
+	t.Run("plain_outside", func(t *testing.T) {
+		client := &dns.Client{
+			Net:     "udp",
+			Timeout: dnsClientTimeout,
+		}
+
+		addr := s.dnsProxy.Addr(proxy.ProtoUDP).String()
+		reply, _, exchErr := client.Exchange(createTestMessage(testFQDN), addr)
+
+		wantErr := &net.OpError{}
+		require.ErrorAs(t, exchErr, &wantErr)
+
+		assert.True(t, wantErr.Timeout())
+		assert.Nil(t, reply)
+	})
+
+	testCases := []struct {
+		name          string
+		clientSrvName string
+		wantRCode     int
+	}{{
+		name:          "dot_client_id",
+		clientSrvName: clientID + "." + tlsServerName,
+		wantRCode:     dns.RcodeSuccess,
+	}, {
+		name:          "dot_no_client_id",
+		clientSrvName: tlsServerName,
+		wantRCode:     dns.RcodeRefused,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &dns.Client{
+				Net: "tcp-tls",
+				TLSConfig: &tls.Config{
+					InsecureSkipVerify: true,
+					ServerName:         tc.clientSrvName,
+				},
+				Timeout: dnsClientTimeout,
+			}
+
+			addr := s.dnsProxy.Addr(proxy.ProtoTLS).String()
+			reply, _, exchErr := client.Exchange(createTestMessage(testFQDN), addr)
+			require.NoError(t, exchErr)
+
+			assert.Equal(t, tc.wantRCode, reply.Rcode)
+			if tc.wantRCode == dns.RcodeSuccess {
+				assert.Equal(t, localAns, reply.Answer)
+			}
+		})
+	}
+}
+
+// newAccessTestServer returns a minimal *Server with the access settings
+// blocking the clients from disallowed.
+func newAccessTestServer(tb testing.TB, disallowed []string) (s *Server) {
+	tb.Helper()
+
+	a, err := newAccessCtx(nil, disallowed, nil, nil)
+	require.NoError(tb, err)
+
+	s = &Server{
+		conf: ServerConfig{
+			ConfigModified: func() {},
+		},
+	}
+	s.access.Store(a)
+
+	return s
+}
+
+// newAccessTestContext returns a new plain-DNS request context from addr.
+func newAccessTestContext(addr netip.Addr) (pctx *proxy.DNSContext) {
+	return &proxy.DNSContext{
+		Proto: proxy.ProtoUDP,
+		Req:   createTestMessage(testFQDN),
+		Addr:  netip.AddrPortFrom(addr, 53),
+	}
+}
+
+func TestServer_HandleBefore_accessSwap(t *testing.T) {
+	const (
+		workersNum = 4
+		queriesNum = 1_000
+		swapsNum   = 100
+	)
+
+	blockedAddr := netip.MustParseAddr("192.0.2.1")
+	allowedAddr := netip.MustParseAddr("192.0.2.2")
+
+	s := newAccessTestServer(t, nil)
+
+	setDisallowed := func(t *testing.T, disallowed []string) {
+		t.Helper()
+
+		b, err := json.Marshal(&accessListJSON{
+			DisallowedClients: disallowed,
+		})
+		require.NoError(t, err)
+
+		r := httptest.NewRequest(http.MethodPost, "/control/access/set", bytes.NewReader(b))
+		w := httptest.NewRecorder()
+
+		s.handleAccessSet(w, r)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+
+	wg := &sync.WaitGroup{}
+	for range workersNum {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			pt := testutil.PanicT{}
+			for range queriesNum {
+				err := s.HandleBefore(nil, newAccessTestContext(blockedAddr))
+				if err != nil {
+					require.ErrorIs(pt, err, errAccessBlocked)
+				}
+
+				err = s.HandleBefore(nil, newAccessTestContext(allowedAddr))
+				require.NoError(pt, err)
+			}
+		}()
+	}
+
+	for i := range swapsNum {
+		if i%2 == 0 {
+			setDisallowed(t, []string{blockedAddr.String()})
+		} else {
+			setDisallowed(t, nil)
+		}
+	}
+
+	setDisallowed(t, []string{blockedAddr.String()})
+
+	wg.Wait()
+
+	err := s.HandleBefore(nil, newAccessTestContext(blockedAddr))
+	assert.ErrorIs(t, err, errAccessBlocked)
+
+	blocked, _ := s.IsBlockedClient(blockedAddr, "")
+	assert.True(t, blocked)
+
+	assert.Equal(t, []string{blockedAddr.String()}, s.accessListJSON().DisallowedClients)
+}
+
+func BenchmarkServer_HandleBefore_access(b *testing.B) {
+	blockedAddr := netip.MustParseAddr("192.0.2.1")
+	allowedAddr := netip.MustParseAddr("192.0.2.2")
+
+	s := newAccessTestServer(b, []string{blockedAddr.String(), "198.51.100.0/24"})
+
+	benchCases := []struct {
+		addr    netip.Addr
+		wantErr error
+		name    string
+	}{{
+		addr:    allowedAddr,
+		wantErr: nil,
+		name:    "allowed",
+	}, {
+		addr:    blockedAddr,
+		wantErr: errAccessBlocked,
+		name:    "blocked",
+	}}
+
+	for _, bc := range benchCases {
+		b.Run(bc.name, func(b *testing.B) {
+			pctx := newAccessTestContext(bc.addr)
+
+			var err error
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for range b.N {
+				err = s.HandleBefore(nil, pctx)
+			}
+
+			assert.ErrorIs(b, err, bc.wantErr)
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package dnsforward
+
+import (
+	"sync"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// uncachedUpstreams stores the copies of the upstream configurations without
+// caches, which are used for the requests bypassing the caches, see
+// [resolveTrace.bypassesCache].  A nil *uncachedUpstreams is valid and stores
+// nothing.
+type uncachedUpstreams struct {
+	// mu protects def and confs.
+	mu *sync.Mutex
+
+	// def is the copy of the default upstream configuration.
+	def *proxy.CustomUpstreamConfig
+
+	// confs maps the custom upstream configurations to their copies.
+	confs map[*proxy.CustomUpstreamConfig]*proxy.CustomUpstreamConfig
+}
+
+// newUncachedUpstreams returns a new properly initialized *uncachedUpstreams.
+func newUncachedUpstreams() (u *uncachedUpstreams) {
+	return &uncachedUpstreams{
+		mu:    &sync.Mutex{},
+		confs: map[*proxy.CustomUpstreamConfig]*proxy.CustomUpstreamConfig{},
+	}
+}
+
+// newUncachedConfig returns a custom upstream configuration for uc without the
+// cache.  It must not be closed, since it shares the upstreams with the
+// original configuration.
+func newUncachedConfig(uc *proxy.UpstreamConfig) (conf *proxy.CustomUpstreamConfig) {
+	return proxy.NewCustomUpstreamConfig(uc, false, 0, false)
+}
+
+// setDefault stores the copy of the default upstream configuration uc.  It's
+// safe to call on a nil u.
+func (u *uncachedUpstreams) setDefault(uc *proxy.UpstreamConfig) {
+	if u == nil {
+		return
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.def = newUncachedConfig(uc)
+}
+
+// add stores the copy of conf with the upstreams of uc.  It's safe to call on
+// a nil u.
+func (u *uncachedUpstreams) add(conf *proxy.CustomUpstreamConfig, uc *proxy.UpstreamConfig) {
+	if u == nil {
+		return
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.confs[conf] = newUncachedConfig(uc)
+}
+
+// forget removes the copy of conf, if any.  It's safe to call on a nil u.
+func (u *uncachedUpstreams) forget(conf *proxy.CustomUpstreamConfig) {
+	if u == nil {
+		return
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	delete(u.confs, conf)
+}
+
+// get returns the copy of conf, or of the default configuration if conf is
+// nil.  ok is false if there is no such copy.  It's safe to call on a nil u.
+func (u *uncachedUpstreams) get(
+	conf *proxy.CustomUpstreamConfig,
+) (uncached *proxy.CustomUpstreamConfig, ok bool) {
+	if u == nil {
+		return nil, false
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if conf == nil {
+		return u.def, u.def != nil
+	}
+
+	uncached, ok = u.confs[conf]
+
+	return uncached, ok
+}
+
+// bypassCaches makes pctx use the same upstreams as its upstream configuration
+// but without any caches.  The caches are used if there is no such
+// configuration.
+func (s *Server) bypassCaches(pctx *proxy.DNSContext) {
+	uncached, ok := s.uncached.get(pctx.CustomUpstreamConfig)
+	if !ok {
+		log.Debug("dnsforward: no upstreams without caches for %s", pctx.Addr)
+
+		return
+	}
+
+	pctx.CustomUpstreamConfig = uncached
+}
+
+// AddUncachedUpstreamConfig makes the requests using conf, which has the
+// upstreams of uc, use the same upstreams without the cache when they bypass
+// the caches.  The data is dropped by [Server.ForgetUpstreamConfig].
+func (s *Server) AddUncachedUpstreamConfig(
+	conf *proxy.CustomUpstreamConfig,
+	uc *proxy.UpstreamConfig,
+) {
+	s.uncached.add(conf, uc)
+}
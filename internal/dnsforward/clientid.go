@@ -5,12 +5,14 @@ import (
 	"fmt"
 	"net/http"
 	"path"
+	"slices"
 	"strings"
 
 	"github.com/AdguardTeam/dnsproxy/proxy"
 	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/miekg/dns"
 	"github.com/quic-go/quic-go"
 )
 
@@ -101,6 +103,67 @@ func clientIDFromDNSContextHTTPS(pctx *proxy.DNSContext) (clientID string, err e
 	return strings.ToLower(clientID), nil
 }
 
+// clientIDFromEDNS extracts the client's ID from the EDNS(0) option of the
+// plain-DNS request configured by [Config.ClientIDEDNSOption].  The option is
+// always removed from the request so that it isn't sent upstream, but it's only
+// taken into account when the request comes from a trusted network.
+func (s *Server) clientIDFromEDNS(pctx *proxy.DNSContext) (clientID string, err error) {
+	code := s.conf.ClientIDEDNSOption
+	if code == 0 {
+		return "", nil
+	}
+
+	data, ok := removeEDNSOption(pctx.Req, code)
+	if !ok {
+		return "", nil
+	}
+
+	if proto := pctx.Proto; proto != proxy.ProtoUDP && proto != proxy.ProtoTCP {
+		log.Debug("dnsforward: ignoring clientid edns option in %s request", proto)
+
+		return "", nil
+	}
+
+	addr := pctx.Addr.Addr()
+	if !s.clientIDEDNSTrusted.Contains(addr) {
+		log.Debug("dnsforward: ignoring clientid edns option from untrusted %s", addr)
+
+		return "", nil
+	}
+
+	clientID = string(data)
+	err = ValidateClientID(clientID)
+	if err != nil {
+		return "", fmt.Errorf("clientid check: %w", err)
+	}
+
+	return strings.ToLower(clientID), nil
+}
+
+// removeEDNSOption removes all the local EDNS(0) options with code from req.
+// data is the data of the first removed option, ok is true if there was one.
+func removeEDNSOption(req *dns.Msg, code uint16) (data []byte, ok bool) {
+	opt := req.IsEdns0()
+	if opt == nil {
+		return nil, false
+	}
+
+	opt.Option = slices.DeleteFunc(opt.Option, func(o dns.EDNS0) (del bool) {
+		local, isLocal := o.(*dns.EDNS0_LOCAL)
+		if !isLocal || local.Code != code {
+			return false
+		}
+
+		if !ok {
+			data, ok = local.Data, true
+		}
+
+		return true
+	})
+
+	return data, ok
+}
+
 // tlsConn is a narrow interface for *tls.Conn to simplify testing.
 type tlsConn interface {
 	ConnectionState() (cs tls.ConnectionState)
@@ -4,12 +4,15 @@ import (
 	"crypto/tls"
 	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"testing"
 
 	"github.com/AdguardTeam/dnsproxy/proxy"
 	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/AdguardTeam/golibs/netutil"
 	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
 	"github.com/quic-go/quic-go"
 	"github.com/stretchr/testify/assert"
 )
@@ -256,6 +259,102 @@ func TestServer_clientIDFromDNSContext(t *testing.T) {
 	}
 }
 
+func TestServer_clientIDFromEDNS(t *testing.T) {
+	const optCode uint16 = dns.EDNS0LOCALSTART
+
+	trustedAddr := netip.MustParseAddrPort("192.0.2.1:53")
+	untrustedAddr := netip.MustParseAddrPort("198.51.100.1:53")
+
+	srv := &Server{
+		conf: ServerConfig{
+			Config: Config{
+				ClientIDEDNSOption: optCode,
+			},
+		},
+		clientIDEDNSTrusted: netutil.SliceSubnetSet{netip.MustParsePrefix("192.0.2.0/24")},
+		baseLogger:          slogutil.NewDiscardLogger(),
+	}
+
+	testCases := []struct {
+		name         string
+		addr         netip.AddrPort
+		proto        proxy.Proto
+		data         string
+		wantClientID string
+		wantErrMsg   string
+	}{{
+		name:         "trusted_udp",
+		addr:         trustedAddr,
+		proto:        proxy.ProtoUDP,
+		data:         "Cli",
+		wantClientID: "cli",
+		wantErrMsg:   "",
+	}, {
+		name:         "trusted_tcp",
+		addr:         trustedAddr,
+		proto:        proxy.ProtoTCP,
+		data:         "cli",
+		wantClientID: "cli",
+		wantErrMsg:   "",
+	}, {
+		name:         "untrusted",
+		addr:         untrustedAddr,
+		proto:        proxy.ProtoUDP,
+		data:         "cli",
+		wantClientID: "",
+		wantErrMsg:   "",
+	}, {
+		name:         "no_option",
+		addr:         trustedAddr,
+		proto:        proxy.ProtoUDP,
+		data:         "",
+		wantClientID: "",
+		wantErrMsg:   "",
+	}, {
+		name:         "encrypted",
+		addr:         trustedAddr,
+		proto:        proxy.ProtoHTTPS,
+		data:         "cli",
+		wantClientID: "",
+		wantErrMsg:   "",
+	}, {
+		name:         "invalid",
+		addr:         trustedAddr,
+		proto:        proxy.ProtoUDP,
+		data:         "!!!",
+		wantClientID: "",
+		wantErrMsg: `clientid check: invalid clientid "!!!": ` +
+			`bad hostname label rune '!'`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := (&dns.Msg{}).SetQuestion("example.com.", dns.TypeA)
+			req.SetEdns0(dns.DefaultMsgSize, false)
+			if tc.data != "" {
+				opt := req.IsEdns0()
+				opt.Option = append(opt.Option, &dns.EDNS0_LOCAL{
+					Code: optCode,
+					Data: []byte(tc.data),
+				})
+			}
+
+			pctx := &proxy.DNSContext{
+				Proto: tc.proto,
+				Req:   req,
+				Addr:  tc.addr,
+			}
+
+			clientID, err := srv.clientIDFromEDNS(pctx)
+			assert.Equal(t, tc.wantClientID, clientID)
+
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+
+			assert.Empty(t, req.IsEdns0().Option)
+		})
+	}
+}
+
 // newHTTPReq is a helper to create HTTP requests for tests.
 func newHTTPReq(cliSrvName string, inclTLS bool) (r *http.Request) {
 	u := &url.URL{
@@ -0,0 +1,166 @@
+package dnsforward
+
+import (
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+)
+
+// clientRateLimitIdleTimeout is the time after which the token bucket of a
+// client that hasn't sent any requests is removed.
+const clientRateLimitIdleTimeout = 1 * time.Minute
+
+// errClientRatelimited is returned when a request is refused because the client
+// has exceeded [Config.ClientRatelimit].
+const errClientRatelimited errors.Error = "client rate limit exceeded"
+
+// tokenBucket is the token bucket of a single client.
+type tokenBucket struct {
+	// lastSeen is the time the bucket has been refilled for the last time.
+	lastSeen time.Time
+
+	// tokens is the number of requests the client is currently allowed to
+	// make.
+	tokens float64
+}
+
+// clientRateLimiter limits the number of requests per second from each client
+// using a token bucket for every client subnet.  It's safe for concurrent use.
+type clientRateLimiter struct {
+	// mu protects buckets.
+	mu *sync.Mutex
+
+	// buckets maps the masked addresses of the clients to their token buckets.
+	buckets map[netip.Prefix]*tokenBucket
+
+	// allowlist is the set of networks exempt from the rate limiting.
+	allowlist netutil.SliceSubnetSet
+
+	// now returns the current time.  It's replaced in tests.
+	now func() (t time.Time)
+
+	// rate is the number of requests per second allowed for each client.  It's
+	// also the capacity of each bucket.
+	rate float64
+
+	// subnetLenIPv4 is the length of the subnet mask for IPv4 addresses.
+	subnetLenIPv4 int
+
+	// subnetLenIPv6 is the length of the subnet mask for IPv6 addresses.
+	subnetLenIPv6 int
+}
+
+// newClientRateLimiter returns a new properly initialized *clientRateLimiter.
+// rate must be greater than zero.
+func newClientRateLimiter(
+	rate uint32,
+	allowlist []netip.Prefix,
+	subnetLenIPv4 int,
+	subnetLenIPv6 int,
+) (l *clientRateLimiter) {
+	return &clientRateLimiter{
+		mu:            &sync.Mutex{},
+		buckets:       map[netip.Prefix]*tokenBucket{},
+		allowlist:     netutil.SliceSubnetSet(slices.Clone(allowlist)),
+		now:           time.Now,
+		rate:          float64(rate),
+		subnetLenIPv4: subnetLenIPv4,
+		subnetLenIPv6: subnetLenIPv6,
+	}
+}
+
+// allow returns true if the request from ip is allowed and consumes a token
+// from the client's bucket.
+func (l *clientRateLimiter) allow(ip netip.Addr) (ok bool) {
+	ip = ip.Unmap()
+	if l.allowlist.Contains(ip) {
+		return true
+	}
+
+	key := l.key(ip)
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		l.buckets[key] = &tokenBucket{
+			lastSeen: now,
+			tokens:   l.rate - 1,
+		}
+
+		return true
+	}
+
+	elapsed := now.Sub(b.lastSeen).Seconds()
+	b.tokens = min(l.rate, b.tokens+elapsed*l.rate)
+	b.lastSeen = now
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+
+	return true
+}
+
+// key returns the masked address of ip, which is used as the key of the
+// client's bucket.
+func (l *clientRateLimiter) key(ip netip.Addr) (key netip.Prefix) {
+	bits := l.subnetLenIPv6
+	if ip.Is4() {
+		bits = l.subnetLenIPv4
+	}
+
+	key, err := ip.Prefix(bits)
+	if err != nil {
+		// Should never happen, since the subnet lengths are validated.
+		return netip.PrefixFrom(ip, ip.BitLen())
+	}
+
+	return key
+}
+
+// evict removes the buckets of the clients which haven't sent any requests for
+// [clientRateLimitIdleTimeout].  n is the number of removed buckets.
+func (l *clientRateLimiter) evict() (n int) {
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, b := range l.buckets {
+		if now.Sub(b.lastSeen) > clientRateLimitIdleTimeout {
+			delete(l.buckets, key)
+			n++
+		}
+	}
+
+	return n
+}
+
+// runEviction removes the idle buckets periodically until done is closed.  It
+// is intended to be used as a goroutine.
+func (l *clientRateLimiter) runEviction(done <-chan struct{}) {
+	defer log.OnPanic("dnsforward: client rate limiter")
+
+	t := time.NewTicker(clientRateLimitIdleTimeout)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+			n := l.evict()
+			log.Debug("dnsforward: client rate limiter: evicted %d idle clients", n)
+		}
+	}
+}
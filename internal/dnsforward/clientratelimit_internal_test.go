@@ -0,0 +1,122 @@
+package dnsforward
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestClientRateLimiter returns a new *clientRateLimiter with the current
+// time controlled by the returned pointer.
+func newTestClientRateLimiter(
+	rate uint32,
+	allowlist []netip.Prefix,
+) (l *clientRateLimiter, now *time.Time) {
+	now = &time.Time{}
+	*now = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	l = newClientRateLimiter(rate, allowlist, 24, 56)
+	l.now = func() (t time.Time) { return *now }
+
+	return l, now
+}
+
+func TestClientRateLimiter_allow(t *testing.T) {
+	t.Parallel()
+
+	const rate = 3
+
+	var (
+		cli         = netip.MustParseAddr("192.0.2.1")
+		cliSameNet  = netip.MustParseAddr("192.0.2.2")
+		cliOtherNet = netip.MustParseAddr("198.51.100.1")
+		cliAllowed  = netip.MustParseAddr("203.0.113.1")
+	)
+
+	allowlist := []netip.Prefix{netip.MustParsePrefix("203.0.113.0/24")}
+
+	t.Run("limit", func(t *testing.T) {
+		t.Parallel()
+
+		l, now := newTestClientRateLimiter(rate, allowlist)
+
+		for range rate {
+			assert.True(t, l.allow(cli))
+		}
+
+		assert.False(t, l.allow(cli))
+		assert.False(t, l.allow(cliSameNet))
+		assert.True(t, l.allow(cliOtherNet))
+
+		*now = now.Add(time.Second)
+		for range rate {
+			assert.True(t, l.allow(cli))
+		}
+
+		assert.False(t, l.allow(cli))
+	})
+
+	t.Run("allowlist", func(t *testing.T) {
+		t.Parallel()
+
+		l, _ := newTestClientRateLimiter(rate, allowlist)
+
+		for range rate * 2 {
+			assert.True(t, l.allow(cliAllowed))
+		}
+
+		assert.Empty(t, l.buckets)
+	})
+
+	t.Run("mapped", func(t *testing.T) {
+		t.Parallel()
+
+		l, _ := newTestClientRateLimiter(1, allowlist)
+
+		assert.True(t, l.allow(cli))
+		assert.False(t, l.allow(netip.AddrFrom16(cli.As16())))
+	})
+}
+
+func TestClientRateLimiter_evict(t *testing.T) {
+	t.Parallel()
+
+	l, now := newTestClientRateLimiter(1, nil)
+
+	assert.True(t, l.allow(netip.MustParseAddr("192.0.2.1")))
+
+	*now = now.Add(clientRateLimitIdleTimeout / 2)
+	assert.True(t, l.allow(netip.MustParseAddr("198.51.100.1")))
+
+	*now = now.Add(clientRateLimitIdleTimeout/2 + time.Second)
+	assert.Equal(t, 1, l.evict())
+	assert.Len(t, l.buckets, 1)
+
+	*now = now.Add(clientRateLimitIdleTimeout)
+	assert.Equal(t, 1, l.evict())
+	assert.Empty(t, l.buckets)
+}
+
+func BenchmarkClientRateLimiter_allow(b *testing.B) {
+	const clientsNum = 1 << 10
+
+	l := newClientRateLimiter(1_000_000, nil, 32, 128)
+
+	ips := make([]netip.Addr, 0, clientsNum)
+	for i := range clientsNum {
+		ips = append(ips, netip.AddrFrom4([4]byte{10, 0, byte(i >> 8), byte(i)}))
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			_ = l.allow(ips[i%clientsNum])
+			i++
+		}
+	})
+}
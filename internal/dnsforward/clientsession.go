@@ -0,0 +1,149 @@
+package dnsforward
+
+import (
+	"maps"
+	"net/http"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// defaultSessionIdleTimeout is the default time after which the session of a
+// client that hasn't sent any queries is removed.
+const defaultSessionIdleTimeout = 1 * time.Hour
+
+// maxSessionEvictionIvl is the maximum interval between the evictions of the
+// idle sessions.
+const maxSessionEvictionIvl = 1 * time.Minute
+
+// SessionInfo is the information about the queries of a single client since it
+// has become active.
+type SessionInfo struct {
+	// FirstSeen is the time of the first query of the session.
+	FirstSeen time.Time `json:"first_seen"`
+
+	// LastSeen is the time of the latest query of the session.
+	LastSeen time.Time `json:"last_seen"`
+
+	// QueryCount is the number of queries of the session.
+	QueryCount uint64 `json:"query_count"`
+
+	// BytesSent is the total size of the responses sent to the client, in
+	// bytes.
+	BytesSent uint64 `json:"bytes_sent"`
+}
+
+// clientSessionTracker tracks the sessions of the clients, which are the
+// periods of time during which they're actively querying.  It's safe for
+// concurrent use.
+type clientSessionTracker struct {
+	// mu protects sessions.
+	mu *sync.RWMutex
+
+	// sessions maps the addresses of the clients to their sessions.
+	sessions map[netip.Addr]*SessionInfo
+
+	// now returns the current time.  It's replaced in tests.
+	now func() (t time.Time)
+}
+
+// newClientSessionTracker returns a new properly initialized
+// *clientSessionTracker.
+func newClientSessionTracker() (t *clientSessionTracker) {
+	return &clientSessionTracker{
+		mu:       &sync.RWMutex{},
+		sessions: map[netip.Addr]*SessionInfo{},
+		now:      time.Now,
+	}
+}
+
+// track records a query from addr, the response to which is respLen bytes
+// long.  Invalid addresses, for example those of the internal queries, are
+// ignored.
+func (t *clientSessionTracker) track(addr netip.Addr, respLen int) {
+	if !addr.IsValid() {
+		return
+	}
+
+	addr = addr.Unmap()
+	now := t.now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	si, ok := t.sessions[addr]
+	if !ok {
+		si = &SessionInfo{
+			FirstSeen: now,
+		}
+		t.sessions[addr] = si
+	}
+
+	si.LastSeen = now
+	si.QueryCount++
+	si.BytesSent += uint64(max(respLen, 0))
+}
+
+// list returns a copy of the current sessions.
+func (t *clientSessionTracker) list() (sessions map[netip.Addr]SessionInfo) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	sessions = make(map[netip.Addr]SessionInfo, len(t.sessions))
+	for addr, si := range t.sessions {
+		sessions[addr] = *si
+	}
+
+	return sessions
+}
+
+// evict removes the sessions of the clients which haven't sent any queries for
+// longer than idleTimeout.  n is the number of removed sessions.
+func (t *clientSessionTracker) evict(idleTimeout time.Duration) (n int) {
+	now := t.now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	l := len(t.sessions)
+	maps.DeleteFunc(t.sessions, func(_ netip.Addr, si *SessionInfo) (ok bool) {
+		return now.Sub(si.LastSeen) > idleTimeout
+	})
+
+	return l - len(t.sessions)
+}
+
+// runEviction removes the sessions idle for longer than idleTimeout
+// periodically until done is closed.  It is intended to be used as a goroutine.
+func (t *clientSessionTracker) runEviction(done <-chan struct{}, idleTimeout time.Duration) {
+	defer log.OnPanic("dnsforward: client session tracker")
+
+	ticker := time.NewTicker(min(idleTimeout, maxSessionEvictionIvl))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			n := t.evict(idleTimeout)
+			log.Debug("dnsforward: client session tracker: evicted %d idle sessions", n)
+		}
+	}
+}
+
+// sessionsJSON is the response for the GET /control/dns/sessions HTTP API.
+type sessionsJSON struct {
+	// Sessions maps the addresses of the clients to their sessions.
+	Sessions map[netip.Addr]SessionInfo `json:"sessions"`
+}
+
+// handleGetSessions is the handler for the GET /control/dns/sessions HTTP API.
+func (s *Server) handleGetSessions(w http.ResponseWriter, r *http.Request) {
+	aghhttp.WriteJSONResponseOK(w, r, &sessionsJSON{
+		Sessions: s.sessions.list(),
+	})
+}
@@ -0,0 +1,160 @@
+package dnsforward
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestClientSessionTracker returns a new *clientSessionTracker with the
+// current time controlled by the returned pointer.
+func newTestClientSessionTracker() (t *clientSessionTracker, now *time.Time) {
+	now = &time.Time{}
+	*now = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t = newClientSessionTracker()
+	t.now = func() (n time.Time) { return *now }
+
+	return t, now
+}
+
+func TestClientSessionTracker_track(t *testing.T) {
+	t.Parallel()
+
+	const (
+		workersNum = 8
+		queriesNum = 1_000
+		respLen    = 100
+	)
+
+	var (
+		cli1 = netip.MustParseAddr("192.0.2.1")
+		cli2 = netip.MustParseAddr("2001:db8::1")
+	)
+
+	tr, now := newTestClientSessionTracker()
+	start := *now
+
+	wg := &sync.WaitGroup{}
+	for range workersNum {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for range queriesNum {
+				tr.track(cli1, respLen)
+				tr.track(netip.AddrFrom16(cli1.As16()), respLen)
+				tr.track(cli2, respLen)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	tr.track(netip.Addr{}, respLen)
+
+	*now = now.Add(time.Minute)
+	tr.track(cli2, 0)
+
+	assert.Equal(t, map[netip.Addr]SessionInfo{
+		cli1: {
+			FirstSeen:  start,
+			LastSeen:   start,
+			QueryCount: 2 * workersNum * queriesNum,
+			BytesSent:  2 * workersNum * queriesNum * respLen,
+		},
+		cli2: {
+			FirstSeen:  start,
+			LastSeen:   start.Add(time.Minute),
+			QueryCount: workersNum*queriesNum + 1,
+			BytesSent:  workersNum * queriesNum * respLen,
+		},
+	}, tr.list())
+}
+
+func TestClientSessionTracker_evict(t *testing.T) {
+	t.Parallel()
+
+	const idleTimeout = time.Hour
+
+	var (
+		cliIdle   = netip.MustParseAddr("192.0.2.1")
+		cliActive = netip.MustParseAddr("192.0.2.2")
+	)
+
+	tr, now := newTestClientSessionTracker()
+
+	tr.track(cliIdle, 0)
+	tr.track(cliActive, 0)
+
+	*now = now.Add(idleTimeout)
+	tr.track(cliActive, 0)
+
+	assert.Zero(t, tr.evict(idleTimeout))
+	assert.Len(t, tr.list(), 2)
+
+	*now = now.Add(time.Second)
+
+	assert.Equal(t, 1, tr.evict(idleTimeout))
+
+	sessions := tr.list()
+	require.Len(t, sessions, 1)
+
+	assert.Contains(t, sessions, cliActive)
+}
+
+func TestClientSessionTracker_runEviction(t *testing.T) {
+	t.Parallel()
+
+	const idleTimeout = 10 * time.Millisecond
+
+	tr := newClientSessionTracker()
+	tr.track(netip.MustParseAddr("192.0.2.1"), 0)
+
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+
+	go tr.runEviction(done, idleTimeout)
+
+	assert.Eventually(t, func() (ok bool) {
+		return len(tr.list()) == 0
+	}, 100*idleTimeout, idleTimeout)
+}
+
+func TestServer_handleGetSessions(t *testing.T) {
+	t.Parallel()
+
+	cli := netip.MustParseAddr("192.0.2.1")
+
+	tr, now := newTestClientSessionTracker()
+	tr.track(cli, 42)
+
+	s := &Server{
+		sessions: tr,
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/control/dns/sessions", nil)
+	w := httptest.NewRecorder()
+
+	s.handleGetSessions(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	resp := &sessionsJSON{}
+	err := json.NewDecoder(w.Body).Decode(resp)
+	require.NoError(t, err)
+
+	require.Contains(t, resp.Sessions, cli)
+
+	got := resp.Sessions[cli]
+	assert.True(t, now.Equal(got.FirstSeen))
+	assert.True(t, now.Equal(got.LastSeen))
+	assert.Equal(t, uint64(1), got.QueryCount)
+	assert.Equal(t, uint64(42), got.BytesSent)
+}
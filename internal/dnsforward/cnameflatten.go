@@ -0,0 +1,164 @@
+package dnsforward
+
+import (
+	"math"
+	"strings"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// processFlattenCNAME replaces the CNAME chains in the responses received from
+// upstream servers with the final A and AAAA records, if configured.  The
+// original response is kept for the query log.  The blocked and rewritten
+// responses aren't modified.
+func (s *Server) processFlattenCNAME(dctx *dnsContext) (rc resultCode) {
+	log.Debug("dnsforward: started processing cname flattening")
+	defer log.Debug("dnsforward: finished processing cname flattening")
+
+	if !s.conf.FlattenCNAMEChains {
+		return resultCodeSuccess
+	}
+
+	pctx := dctx.proxyCtx
+	res := pctx.Res
+	if !dctx.responseFromUpstream || res == nil || isRewrittenOrFiltered(dctx.result) {
+		return resultCodeSuccess
+	}
+
+	q := pctx.Req.Question[0]
+	if q.Qtype != dns.TypeA && q.Qtype != dns.TypeAAAA {
+		return resultCodeSuccess
+	}
+
+	flat, ok := flattenCNAMEChain(res.Answer, q.Name)
+	if !ok {
+		return resultCodeSuccess
+	}
+
+	log.Debug("dnsforward: flattened cname chain for %q", q.Name)
+
+	if dctx.origResp == nil {
+		dctx.origResp = res.Copy()
+	}
+
+	res.Answer = flat
+
+	return resultCodeSuccess
+}
+
+// flattenRewrittenCNAME replaces the answer of the response to the request
+// rewritten with a CNAME pointing to an external hostname with the A and AAAA
+// records of the target, if configured.  The TTLs are set to the minimum of the
+// TTLs of the rewrite and the resolved records.  The request must have been
+// resolved using the upstream servers with the original question restored.
+func (s *Server) flattenRewrittenCNAME(dctx *dnsContext) {
+	if !s.conf.FlattenRewrittenCNAMEs ||
+		!dctx.result.Reason.In(filtering.Rewritten, filtering.RewrittenRule) {
+		return
+	}
+
+	q := dctx.origQuestion
+	if q.Qtype != dns.TypeA && q.Qtype != dns.TypeAAAA {
+		return
+	}
+
+	res := dctx.proxyCtx.Res
+	flat, ok := flattenCNAMEChain(res.Answer, q.Name)
+	if !ok {
+		return
+	}
+
+	log.Debug("dnsforward: flattened rewritten cname for %q", q.Name)
+
+	res.Answer = flat
+}
+
+// isRewrittenOrFiltered returns true if res is a result of blocking or
+// rewriting the request, which has been handled by the filtering already.
+func isRewrittenOrFiltered(res *filtering.Result) (ok bool) {
+	if res == nil {
+		return false
+	}
+
+	switch res.Reason {
+	case
+		filtering.Rewritten,
+		filtering.RewrittenRule,
+		filtering.RewrittenAutoHosts,
+		filtering.FilteredSafeSearch:
+		return true
+	default:
+		return res.IsFiltered
+	}
+}
+
+// flattenCNAMEChain returns the copies of the A and AAAA records from answer
+// which the CNAME chain starting at qname leads to, with the owner names
+// replaced by qname and the TTLs set to the minimum TTL of the chain.  ok is
+// false if answer doesn't consist solely of such a chain and the address
+// records, for example if it contains signatures.
+func flattenCNAMEChain(answer []dns.RR, qname string) (flat []dns.RR, ok bool) {
+	name := qname
+	minTTL := uint32(math.MaxUint32)
+	chainLen := 0
+
+	// Limit the number of steps to prevent loops.
+	for range answer {
+		cname := findCNAME(answer, name)
+		if cname == nil {
+			break
+		}
+
+		minTTL = min(minTTL, cname.Hdr.Ttl)
+		name = cname.Target
+		chainLen++
+	}
+
+	if chainLen == 0 {
+		return nil, false
+	}
+
+	for _, rr := range answer {
+		hdr := rr.Header()
+		if !strings.EqualFold(hdr.Name, name) {
+			continue
+		}
+
+		switch rr.(type) {
+		case *dns.A, *dns.AAAA:
+			minTTL = min(minTTL, hdr.Ttl)
+			flat = append(flat, rr)
+		default:
+			// Go on.
+		}
+	}
+
+	if len(flat) == 0 || chainLen+len(flat) != len(answer) {
+		return nil, false
+	}
+
+	for i, rr := range flat {
+		rr = dns.Copy(rr)
+		hdr := rr.Header()
+		hdr.Name = qname
+		hdr.Ttl = minTTL
+		flat[i] = rr
+	}
+
+	return flat, true
+}
+
+// findCNAME returns the CNAME record from answer owned by name or nil if there
+// is none.
+func findCNAME(answer []dns.RR, name string) (cname *dns.CNAME) {
+	for _, rr := range answer {
+		c, ok := rr.(*dns.CNAME)
+		if ok && strings.EqualFold(c.Hdr.Name, name) {
+			return c
+		}
+	}
+
+	return nil
+}
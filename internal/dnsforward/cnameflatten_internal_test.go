@@ -0,0 +1,283 @@
+package dnsforward
+
+import (
+	"net"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestCNAME returns a new CNAME record from name to target with ttl.
+func newTestCNAME(name, target string, ttl uint32) (rr *dns.CNAME) {
+	return &dns.CNAME{
+		Hdr: dns.RR_Header{
+			Name:   name,
+			Rrtype: dns.TypeCNAME,
+			Class:  dns.ClassINET,
+			Ttl:    ttl,
+		},
+		Target: target,
+	}
+}
+
+// newTestA returns a new A record for name with ip and ttl.
+func newTestA(name string, ip net.IP, ttl uint32) (rr *dns.A) {
+	return &dns.A{
+		Hdr: dns.RR_Header{
+			Name:   name,
+			Rrtype: dns.TypeA,
+			Class:  dns.ClassINET,
+			Ttl:    ttl,
+		},
+		A: ip,
+	}
+}
+
+// newTestAAAA returns a new AAAA record for name with ip and ttl.
+func newTestAAAA(name string, ip net.IP, ttl uint32) (rr *dns.AAAA) {
+	return &dns.AAAA{
+		Hdr: dns.RR_Header{
+			Name:   name,
+			Rrtype: dns.TypeAAAA,
+			Class:  dns.ClassINET,
+			Ttl:    ttl,
+		},
+		AAAA: ip,
+	}
+}
+
+func TestFlattenCNAMEChain(t *testing.T) {
+	t.Parallel()
+
+	const (
+		qname = aghtest.ReqFQDN
+		step1 = "cdn1.example."
+		step2 = "cdn2.example."
+		step3 = "cdn3.example."
+	)
+
+	var (
+		ip4 = net.IP{192, 0, 2, 1}
+		ip6 = net.ParseIP("2001:db8::1")
+	)
+
+	testCases := []struct {
+		name    string
+		answer  []dns.RR
+		wantAns []dns.RR
+		wantOK  bool
+	}{{
+		name: "two_steps",
+		answer: []dns.RR{
+			newTestCNAME(qname, step1, 300),
+			newTestA(step1, ip4, 60),
+		},
+		wantAns: []dns.RR{
+			newTestA(qname, ip4, 60),
+		},
+		wantOK: true,
+	}, {
+		name: "three_steps",
+		answer: []dns.RR{
+			newTestCNAME(qname, step1, 300),
+			newTestCNAME(step1, step2, 30),
+			newTestCNAME(step2, step3, 120),
+			newTestA(step3, ip4, 60),
+		},
+		wantAns: []dns.RR{
+			newTestA(qname, ip4, 30),
+		},
+		wantOK: true,
+	}, {
+		name: "mixed",
+		answer: []dns.RR{
+			newTestCNAME(qname, step1, 300),
+			newTestCNAME(step1, step2, 200),
+			newTestA(step2, ip4, 100),
+			newTestAAAA(step2, ip6, 150),
+		},
+		wantAns: []dns.RR{
+			newTestA(qname, ip4, 100),
+			newTestAAAA(qname, ip6, 100),
+		},
+		wantOK: true,
+	}, {
+		name: "no_chain",
+		answer: []dns.RR{
+			newTestA(qname, ip4, 60),
+		},
+		wantAns: nil,
+		wantOK:  false,
+	}, {
+		name: "no_addresses",
+		answer: []dns.RR{
+			newTestCNAME(qname, step1, 300),
+		},
+		wantAns: nil,
+		wantOK:  false,
+	}, {
+		name: "unrelated_records",
+		answer: []dns.RR{
+			newTestCNAME(qname, step1, 300),
+			newTestA(step1, ip4, 60),
+			newTestA(step2, ip4, 60),
+		},
+		wantAns: nil,
+		wantOK:  false,
+	}, {
+		name: "loop",
+		answer: []dns.RR{
+			newTestCNAME(qname, step1, 300),
+			newTestCNAME(step1, qname, 300),
+		},
+		wantAns: nil,
+		wantOK:  false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			flat, ok := flattenCNAMEChain(tc.answer, qname)
+			require.Equal(t, tc.wantOK, ok)
+
+			assert.Equal(t, tc.wantAns, flat)
+		})
+	}
+}
+
+func TestServer_ProcessFlattenCNAME(t *testing.T) {
+	t.Parallel()
+
+	const (
+		qname  = aghtest.ReqFQDN
+		target = "cdn.example."
+	)
+
+	testCases := []struct {
+		result  *filtering.Result
+		name    string
+		ip      net.IP
+		wantAns []dns.RR
+		enabled bool
+	}{{
+		result:  &filtering.Result{},
+		name:    "flattened",
+		ip:      net.IP{192, 0, 2, 1},
+		wantAns: []dns.RR{newTestA(qname, net.IP{192, 0, 2, 1}, 60)},
+		enabled: true,
+	}, {
+		result: &filtering.Result{},
+		name:   "disabled",
+		ip:     net.IP{192, 0, 2, 1},
+		wantAns: []dns.RR{
+			newTestCNAME(qname, target, 60),
+			newTestA(target, net.IP{192, 0, 2, 1}, 60),
+		},
+		enabled: false,
+	}, {
+		result: &filtering.Result{
+			Reason:    filtering.Rewritten,
+			CanonName: target,
+		},
+		name: "rewritten",
+		ip:   net.IP{192, 0, 2, 1},
+		wantAns: []dns.RR{
+			newTestCNAME(qname, target, 60),
+			newTestA(target, net.IP{192, 0, 2, 1}, 60),
+		},
+		enabled: true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			s := &Server{
+				conf: ServerConfig{
+					Config: Config{
+						FlattenCNAMEChains: tc.enabled,
+					},
+				},
+			}
+
+			req := createTestMessageWithType(qname, dns.TypeA)
+			ans := []dns.RR{
+				newTestCNAME(qname, target, 60),
+				newTestA(target, tc.ip, 60),
+			}
+
+			dctx := &dnsContext{
+				responseFromUpstream: true,
+				result:               tc.result,
+				proxyCtx: &proxy.DNSContext{
+					Req: req,
+					Res: newResp(dns.RcodeSuccess, req, ans),
+				},
+			}
+
+			gotRC := s.processFlattenCNAME(dctx)
+			assert.Equal(t, resultCodeSuccess, gotRC)
+			assert.Equal(t, tc.wantAns, dctx.proxyCtx.Res.Answer)
+		})
+	}
+
+	t.Run("blocked", func(t *testing.T) {
+		t.Parallel()
+
+		c := ServerConfig{
+			Config: Config{
+				FlattenCNAMEChains: true,
+				UpstreamMode:       UpstreamModeLoadBalance,
+				EDNSClientSubnet:   &EDNSClientSubnet{Enabled: false},
+			},
+			ServePlainDNS: true,
+		}
+
+		// The test server blocks 127.0.0.255.
+		s := createTestServer(t, &filtering.Config{
+			BlockingMode: filtering.BlockingModeDefault,
+		}, c)
+
+		blockedIP := net.IP{127, 0, 0, 255}
+
+		req := createTestMessageWithType(qname, dns.TypeA)
+		ans := []dns.RR{
+			newTestCNAME(qname, target, 60),
+			newTestA(target, blockedIP, 60),
+		}
+
+		dctx := &dnsContext{
+			setts: &filtering.Settings{
+				FilteringEnabled:  true,
+				ProtectionEnabled: true,
+			},
+			protectionEnabled:    true,
+			responseFromUpstream: true,
+			result:               &filtering.Result{},
+			proxyCtx: &proxy.DNSContext{
+				Proto: proxy.ProtoUDP,
+				Req:   req,
+				Res:   newResp(dns.RcodeSuccess, req, ans),
+				Addr:  testClientAddrPort,
+			},
+		}
+
+		require.Equal(t, resultCodeSuccess, s.processFilteringAfterResponse(dctx))
+		require.Equal(t, resultCodeSuccess, s.processFlattenCNAME(dctx))
+
+		assert.True(t, dctx.result.IsFiltered)
+
+		for _, rr := range dctx.proxyCtx.Res.Answer {
+			a, ok := rr.(*dns.A)
+			if ok {
+				assert.False(t, a.A.Equal(blockedIP))
+			}
+		}
+	})
+}
@@ -27,6 +27,7 @@ import (
 	"github.com/AdguardTeam/golibs/stringutil"
 	"github.com/AdguardTeam/golibs/timeutil"
 	"github.com/ameshkov/dnscrypt/v2"
+	"github.com/miekg/dns"
 )
 
 // ClientsContainer provides information about preconfigured DNS clients.
@@ -71,6 +72,21 @@ type Config struct {
 	// RatelimitWhitelist is the list of whitelisted client IP addresses.
 	RatelimitWhitelist []netip.Addr `yaml:"ratelimit_whitelist"`
 
+	// ClientRatelimit is the maximum number of requests per second from a
+	// given client subnet, see [Config.RatelimitSubnetLenIPv4] and
+	// [Config.RatelimitSubnetLenIPv6].  Requests exceeding it are refused
+	// before reaching the upstream servers.  If 0, no limit is applied.
+	ClientRatelimit uint32 `yaml:"client_ratelimit"`
+
+	// ClientRatelimitWhitelist is the list of networks exempt from
+	// [Config.ClientRatelimit].
+	ClientRatelimitWhitelist []netip.Prefix `yaml:"client_ratelimit_whitelist"`
+
+	// SessionIdleTimeout is the time after which the session of a client that
+	// hasn't sent any queries is removed.  If it's not positive,
+	// [defaultSessionIdleTimeout] is used.
+	SessionIdleTimeout timeutil.Duration `yaml:"session_idle_timeout"`
+
 	// RefuseAny, if true, refuse ANY requests.
 	RefuseAny bool `yaml:"refuse_any"`
 
@@ -80,13 +96,30 @@ type Config struct {
 	UpstreamDNS []string `yaml:"upstream_dns"`
 
 	// UpstreamDNSFileName, if set, points to the file which contains upstream
-	// DNS servers.
+	// DNS servers.  The upstreams are reloaded when the file changes, unless
+	// the new ones are invalid.
 	UpstreamDNSFileName string `yaml:"upstream_dns_file"`
 
 	// BootstrapDNS is the list of bootstrap DNS servers for DoH and DoT
 	// resolvers (plain DNS only).
 	BootstrapDNS []string `yaml:"bootstrap_dns"`
 
+	// UpstreamGroupBootstrapDNS are the bootstrap DNS servers for the groups
+	// of domain-specific upstreams.  Each line has the form
+	// "[/domain1/../domainN/]bootstrap1 .. bootstrapN", and the servers are
+	// used instead of BootstrapDNS for the upstreams in the lines of
+	// UpstreamDNS with the same domain specification.  Plain DNS only.
+	UpstreamGroupBootstrapDNS []string `yaml:"upstream_group_bootstrap_dns"`
+
+	// UpstreamTimeouts are the timeouts of the upstreams, which are used
+	// instead of the global upstream timeout.  Each line has the form either
+	// "[/domain1/../domainN/]timeout", for the upstreams in the lines of
+	// UpstreamDNS with the same domain specification, or "address timeout",
+	// for the upstream with the address, for example "tls://dns.example 10s".
+	// The timeouts of the upstreams take precedence over the ones of the
+	// groups.
+	UpstreamTimeouts []string `yaml:"upstream_timeouts"`
+
 	// FallbackDNS is the list of fallback DNS servers used when upstream DNS
 	// servers are not responding.
 	FallbackDNS []string `yaml:"fallback_dns"`
@@ -98,6 +131,12 @@ type Config struct {
 	// when FastestAddr is true.
 	FastestTimeout timeutil.Duration `yaml:"fastest_timeout"`
 
+	// FailoverRecoveryInterval is the interval between the attempts to return
+	// to the higher-priority upstreams in [UpstreamModeFailover].  If zero,
+	// the default one is used.  It doesn't affect the custom upstreams of
+	// the clients.
+	FailoverRecoveryInterval timeutil.Duration `yaml:"failover_recovery_interval"`
+
 	// Access settings
 
 	// AllowedClients is the slice of IP addresses, CIDR networks, and
@@ -112,11 +151,28 @@ type Config struct {
 	// BlockedHosts is the list of hosts that should be blocked.
 	BlockedHosts []string `yaml:"blocked_hosts"`
 
+	// ProtocolAccess are the client lists scoped to the client protocols.  If
+	// there are lists for a protocol, they replace [Config.AllowedClients] and
+	// [Config.DisallowedClients] for the requests received over it.
+	ProtocolAccess map[AccessProto]*ProtocolAccessList `yaml:"protocol_access"`
+
 	// TrustedProxies is the list of CIDR networks with proxy servers addresses
 	// from which the DoH requests should be handled.  The value of nil or an
 	// empty slice for this field makes Proxy not trust any address.
 	TrustedProxies []netutil.Prefix `yaml:"trusted_proxies"`
 
+	// ClientIDEDNSOption is the code of the EDNS(0) option containing the
+	// ClientID of the plain-DNS requests.  It must be within the range reserved
+	// for local or experimental use.  If zero, ClientIDs aren't taken from the
+	// EDNS(0) options.
+	ClientIDEDNSOption uint16 `yaml:"clientid_edns_option"`
+
+	// ClientIDEDNSTrustedNets is the list of CIDR networks with addresses of
+	// the forwarders allowed to set the ClientID using the EDNS(0) option with
+	// the code [Config.ClientIDEDNSOption].  The option is ignored in requests
+	// from other addresses.
+	ClientIDEDNSTrustedNets []netutil.Prefix `yaml:"clientid_edns_trusted_nets"`
+
 	// DNS cache settings
 
 	// CacheSize is the DNS cache size (in bytes).
@@ -133,6 +189,64 @@ type Config struct {
 	// CacheOptimistic defines if optimistic cache mechanism should be used.
 	CacheOptimistic bool `yaml:"cache_optimistic"`
 
+	// UpstreamCaches are the configurations of the separate caches for the
+	// groups of domain-specific upstreams.  The responses from the upstreams
+	// of other groups are stored in the global cache.  The separate caches are
+	// only used when the global cache is enabled.
+	UpstreamCaches []UpstreamCacheConfig `yaml:"upstream_caches"`
+
+	// HijackDetection is the configuration of the detection of the upstream
+	// servers rewriting NXDOMAIN responses into addresses.
+	HijackDetection HijackDetectionConfig `yaml:"hijack_detection"`
+
+	// InterfaceBindings are the upstreams for the queries received on the
+	// listeners bound to the addresses of the network interfaces.  They are
+	// used instead of [Config.UpstreamDNS] for such queries, unless the client
+	// has its own upstreams.
+	InterfaceBindings []InterfaceUpstream `yaml:"interface_bindings"`
+
+	// Views are the upstreams for the queries from the clients within the
+	// subnets.  The first view with a subnet containing the address of the
+	// client is used instead of [Config.UpstreamDNS] and
+	// [Config.InterfaceBindings], unless the client has its own upstreams.
+	Views []*View `yaml:"views"`
+
+	// ServeStale defines if the expired responses should be served when all
+	// the upstream servers fail.  See RFC 8767.
+	ServeStale bool `yaml:"serve_stale"`
+
+	// ServeStaleMaxAge is the maximum time the expired responses are served
+	// for.  If zero, [defaultServeStaleMaxAge] is used.
+	ServeStaleMaxAge timeutil.Duration `yaml:"serve_stale_max_age"`
+
+	// Response TTL settings
+
+	// MinTTL is the minimum TTL value of the resource records in responses
+	// received from upstream servers.  Lower values are increased to it,
+	// except for the zero ones.  If 0, the TTLs aren't increased.
+	MinTTL uint32 `yaml:"response_ttl_min"`
+
+	// MaxTTL is the maximum TTL value of the resource records in responses
+	// received from upstream servers.  Higher values are decreased to it.  If
+	// 0, the TTLs aren't decreased.
+	MaxTTL uint32 `yaml:"response_ttl_max"`
+
+	// Response size settings
+
+	// MaxResponseSizes maps the names of query types, such as "ANY" or "TXT",
+	// to the maximum size in bytes of the responses received from upstream
+	// servers for the queries of these types.  The oversized responses are
+	// either truncated or blocked, see [Config.TruncateOversized].  The
+	// oversized responses to ANY queries are replaced with the minimal
+	// responses described in RFC 8482.
+	MaxResponseSizes map[string]uint16 `yaml:"max_response_sizes"`
+
+	// TruncateOversized, if true, makes the server respond to the oversized
+	// responses over UDP with an empty response with the TC bit set, so that
+	// the client retries the query over TCP.  Otherwise, such responses are
+	// refused regardless of the transport.
+	TruncateOversized bool `yaml:"truncate_oversized_responses"`
+
 	// Other settings
 
 	// BogusNXDomain is the list of IP addresses, responses with them will be
@@ -143,6 +257,34 @@ type Config struct {
 	// requests.
 	AAAADisabled bool `yaml:"aaaa_disabled"`
 
+	// FilterNonGlobalIPv6, if true, removes the AAAA records with non-global
+	// IPv6 addresses, such as unique local, link-local, and site-local ones,
+	// from the responses received from upstream servers.  See
+	// [isNonGlobalIPv6].
+	FilterNonGlobalIPv6 bool `yaml:"filter_non_global_ipv6"`
+
+	// FlattenCNAMEChains, if true, replaces the CNAME chains followed by A or
+	// AAAA records in the responses received from upstream servers with the
+	// final records renamed to the requested name.  See [flattenCNAMEChain].
+	FlattenCNAMEChains bool `yaml:"flatten_cname_chains"`
+
+	// FlattenRewrittenCNAMEs, if true, replaces the CNAME records of the
+	// rewrites pointing to external hostnames with the A or AAAA records of
+	// the target resolved using the upstream servers.  See
+	// [Server.flattenRewrittenCNAME].
+	FlattenRewrittenCNAMEs bool `yaml:"flatten_rewritten_cnames"`
+
+	// RebindingProtection, if true, replaces the responses received from
+	// upstream servers for the public domain names with NXDOMAIN ones if they
+	// contain the addresses from the locally-served networks.  See
+	// [netutil.IsLocallyServed].
+	RebindingProtection bool `yaml:"rebinding_protection"`
+
+	// RebindingAllowlist are the networks, the addresses from which are
+	// allowed in the responses for the public domain names even if
+	// RebindingProtection is enabled.
+	RebindingAllowlist []netip.Prefix `yaml:"rebinding_allowlist"`
+
 	// EnableDNSSEC, if true, set AD flag in outcoming DNS request.
 	EnableDNSSEC bool `yaml:"enable_dnssec"`
 
@@ -183,6 +325,17 @@ type EDNSClientSubnet struct {
 
 	// UseCustom defines if CustomIP should be used.
 	UseCustom bool `yaml:"use_custom"`
+
+	// TrustedProxies are the networks of the downstream forwarders, the EDNS
+	// Client Subnet options of which are trusted.  If not empty, the subnets
+	// from such requests are sent to the upstreams.  The options of the
+	// requests from any other addresses are left untouched.
+	TrustedProxies []netutil.Prefix `yaml:"trusted_proxies"`
+
+	// UseForClients defines if the subnets received from TrustedProxies
+	// should also be used instead of the transport sources for matching the
+	// clients.
+	UseForClients bool `yaml:"use_for_clients"`
 }
 
 // TLSConfig is the TLS configuration for HTTPS, DNS-over-HTTPS, and DNS-over-TLS
@@ -264,6 +417,16 @@ type ServerConfig struct {
 	// UpstreamTimeout is the timeout for querying upstream servers.
 	UpstreamTimeout time.Duration
 
+	// UpstreamRetries is the maximum number of retries of a failed exchange
+	// with an upstream server.  The retries are made within UpstreamTimeout.
+	// They aren't made in [UpstreamModeParallel].
+	UpstreamRetries uint
+
+	// UpstreamRetryBackoff is the base duration of waiting before a retry of
+	// a failed exchange with an upstream server.  It's doubled with each retry
+	// and randomized.
+	UpstreamRetryBackoff time.Duration
+
 	TLSv12Roots *x509.CertPool // list of root CAs for TLSv1.2
 
 	// TLSCiphers are the IDs of TLS cipher suites to use.
@@ -286,6 +449,13 @@ type ServerConfig struct {
 	// locally-served networks should be resolved via private PTR resolvers.
 	UsePrivateRDNS bool
 
+	// LocalPTRAuthoritative defines if the responses to the PTR requests for
+	// the addresses from locally-served networks, which are built from the
+	// DHCP leases and the hosts files, are authoritative.  If true, such
+	// requests are never sent to the private PTR resolvers, and the ones
+	// without local data are responded with NXDOMAIN.
+	LocalPTRAuthoritative bool
+
 	// UseDNS64 defines if DNS64 is enabled for incoming requests.
 	UseDNS64 bool
 
@@ -310,6 +480,10 @@ const (
 	UpstreamModeLoadBalance UpstreamMode = "load_balance"
 	UpstreamModeParallel    UpstreamMode = "parallel"
 	UpstreamModeFastestAddr UpstreamMode = "fastest_addr"
+
+	// UpstreamModeFailover sends the requests to the first upstream in the
+	// order of the list, which answers them.  See [failoverUpstream].
+	UpstreamModeFailover UpstreamMode = "failover"
 )
 
 // newProxyConfig creates and validates configuration for the main proxy.
@@ -385,9 +559,38 @@ func (s *Server) newProxyConfig() (conf *proxy.Config, err error) {
 		return nil, err
 	}
 
+	err = validateResponseTTL(srvConf.MinTTL, srvConf.MaxTTL)
+	if err != nil {
+		return nil, fmt.Errorf("validating response ttl: %w", err)
+	}
+
+	conf.CacheMinTTL, conf.CacheMaxTTL = clampCacheTTL(
+		srvConf.CacheMinTTL,
+		srvConf.CacheMaxTTL,
+		srvConf.MinTTL,
+		srvConf.MaxTTL,
+	)
+
 	return conf, nil
 }
 
+// clampCacheTTL returns the TTL bounds for the cache so that the cached
+// responses live as long as the clamped TTLs of the responses sent to clients.
+func clampCacheTTL(cacheMin, cacheMax, respMin, respMax uint32) (minTTL, maxTTL uint32) {
+	minTTL = max(cacheMin, respMin)
+
+	maxTTL = cacheMax
+	if respMax != 0 && (maxTTL == 0 || respMax < maxTTL) {
+		maxTTL = respMax
+	}
+
+	if maxTTL != 0 {
+		minTTL = min(minTTL, maxTTL)
+	}
+
+	return minTTL, maxTTL
+}
+
 // prepareCacheConfig prepares the cache configuration and returns an error if
 // there is one.
 func prepareCacheConfig(
@@ -481,17 +684,7 @@ func (conf *ServerConfig) loadUpstreams() (upstreams []string, err error) {
 		return stringutil.FilterOut(conf.UpstreamDNS, IsCommentOrEmpty), nil
 	}
 
-	var data []byte
-	data, err = os.ReadFile(conf.UpstreamDNSFileName)
-	if err != nil {
-		return nil, fmt.Errorf("reading upstream from file: %w", err)
-	}
-
-	upstreams = stringutil.SplitTrimmed(string(data), "\n")
-
-	log.Debug("dnsforward: got %d upstreams in %q", len(upstreams), conf.UpstreamDNSFileName)
-
-	return stringutil.FilterOut(upstreams, IsCommentOrEmpty), nil
+	return readUpstreamsFile(conf.UpstreamDNSFileName)
 }
 
 // collectListenAddr adds addrPort to addrs.  It also adds its port to
@@ -789,6 +982,76 @@ func (s *Server) enableProtectionAfterPause() {
 	log.Info("dns: protection is restarted after pause")
 }
 
+// validateResponseTTL returns an error if the configuration of the response TTL
+// bounds is invalid.
+func validateResponseTTL(minTTL, maxTTL uint32) (err error) {
+	if maxTTL > 0 && minTTL > maxTTL {
+		return errors.Error("response_ttl_min must be less than or equal to response_ttl_max")
+	}
+
+	return nil
+}
+
+// newRespSizeLimits validates the maximum response sizes and returns them
+// keyed by the query types.  See [Config.MaxResponseSizes].
+func newRespSizeLimits(sizes map[string]uint16) (limits map[uint16]int, err error) {
+	if len(sizes) == 0 {
+		return nil, nil
+	}
+
+	limits = make(map[uint16]int, len(sizes))
+	for name, size := range sizes {
+		qtype, ok := dns.StringToType[strings.ToUpper(name)]
+		if !ok {
+			return nil, fmt.Errorf("query type %q: unknown type", name)
+		} else if size == 0 {
+			return nil, fmt.Errorf("query type %q: size: %w", name, errors.ErrNotPositive)
+		}
+
+		limits[qtype] = int(size)
+	}
+
+	return limits, nil
+}
+
+// newStaleCacheFromConf returns the stale cache configured by conf or nil if
+// serving the expired responses is disabled.
+func newStaleCacheFromConf(conf *Config) (c *staleCache, err error) {
+	if !conf.ServeStale {
+		return nil, nil
+	}
+
+	maxAge := time.Duration(conf.ServeStaleMaxAge)
+	if maxAge < 0 {
+		return nil, fmt.Errorf("serve_stale_max_age: %w", errors.ErrNegative)
+	} else if maxAge == 0 {
+		maxAge = defaultServeStaleMaxAge
+	}
+
+	if conf.CacheSize == 0 {
+		log.Info("dnsforward: serving stale responses requires cache, disabling")
+
+		return nil, nil
+	}
+
+	return newStaleCache(conf.CacheSize, maxAge), nil
+}
+
+// validateClientIDEDNSOption returns an error if code isn't a valid code of the
+// EDNS(0) option containing ClientIDs.
+func validateClientIDEDNSOption(code uint16) (err error) {
+	if code == 0 || (code >= dns.EDNS0LOCALSTART && code <= dns.EDNS0LOCALEND) {
+		return nil
+	}
+
+	return fmt.Errorf(
+		"clientid_edns_option: code %d is not in range [%d, %d]",
+		code,
+		dns.EDNS0LOCALSTART,
+		dns.EDNS0LOCALEND,
+	)
+}
+
 // validateCacheTTL returns an error if the configuration of the cache TTL
 // invalid.
 //
@@ -2,7 +2,10 @@ package dnsforward
 
 import (
 	"fmt"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/AdguardTeam/dnsproxy/proxy"
 	"github.com/AdguardTeam/dnsproxy/upstream"
@@ -43,6 +46,10 @@ type upstreamResult struct {
 	// err is the upstream check error.
 	err error
 
+	// latencies are the round-trip times of the successful probes of the
+	// upstream.
+	latencies []time.Duration
+
 	// isSpecific is true if the upstream is domain-specific.
 	isSpecific bool
 }
@@ -69,17 +76,19 @@ func newUpstreamConfigValidator(
 		privateUpstreamResults:  map[string]*upstreamResult{},
 	}
 
+	stamps := stampAliases(slices.Concat(general, fallback, private), opts)
+
 	conf, err := proxy.ParseUpstreamsConfig(general, opts)
 	cv.generalParseResults = collectErrResults(general, err)
-	insertConfResults(conf, cv.generalUpstreamResults)
+	insertConfResults(conf, cv.generalUpstreamResults, stamps)
 
 	conf, err = proxy.ParseUpstreamsConfig(fallback, opts)
 	cv.fallbackParseResults = collectErrResults(fallback, err)
-	insertConfResults(conf, cv.fallbackUpstreamResults)
+	insertConfResults(conf, cv.fallbackUpstreamResults, stamps)
 
 	conf, err = proxy.ParseUpstreamsConfig(private, opts)
 	cv.privateParseResults = collectErrResults(private, err)
-	insertConfResults(conf, cv.privateUpstreamResults)
+	insertConfResults(conf, cv.privateUpstreamResults, stamps)
 
 	return cv
 }
@@ -128,25 +137,75 @@ func collectErrResults(lines []string, err error) (results []*parseResult) {
 	return results
 }
 
+// stampAliases returns the DNS stamps from the upstream configuration lines
+// keyed by the addresses of the upstreams created from them.  Those addresses
+// differ from the stamps for most of the protocols, e.g. the DNS-over-HTTPS
+// stamp is turned into an upstream with the https:// URL.
+func stampAliases(lines []string, opts *upstream.Options) (stamps map[string]string) {
+	stamps = map[string]string{}
+	for _, line := range lines {
+		// Skip the domains of the domain-specific upstreams.
+		if i := strings.LastIndexByte(line, ']'); i >= 0 {
+			line = line[i+1:]
+		}
+
+		for _, addr := range strings.Fields(line) {
+			if !strings.HasPrefix(addr, "sdns://") {
+				continue
+			}
+
+			u, err := upstream.AddressToUpstream(addr, opts.Clone())
+			if err != nil {
+				// The parsing errors are collected from the whole configuration.
+				continue
+			}
+
+			stamps[u.Address()] = addr
+
+			err = u.Close()
+			if err != nil {
+				log.Debug("dnsforward: configvalidator: closing %s: %s", addr, err)
+			}
+		}
+	}
+
+	return stamps
+}
+
 // insertConfResults parses conf and inserts the upstream result into results.
-// It can insert multiple results as well as none.
-func insertConfResults(conf *proxy.UpstreamConfig, results map[string]*upstreamResult) {
-	insertListResults(conf.Upstreams, results, false)
+// It can insert multiple results as well as none.  stamps are the original DNS
+// stamps keyed by the upstream addresses, see [stampAliases].
+func insertConfResults(
+	conf *proxy.UpstreamConfig,
+	results map[string]*upstreamResult,
+	stamps map[string]string,
+) {
+	insertListResults(conf.Upstreams, results, stamps, false)
 
 	for _, ups := range conf.DomainReservedUpstreams {
-		insertListResults(ups, results, true)
+		insertListResults(ups, results, stamps, true)
 	}
 
 	for _, ups := range conf.SpecifiedDomainUpstreams {
-		insertListResults(ups, results, true)
+		insertListResults(ups, results, stamps, true)
 	}
 }
 
 // insertListResults constructs upstream results from the upstream list and
-// inserts them into results.  It can insert multiple results as well as none.
-func insertListResults(ups []upstream.Upstream, results map[string]*upstreamResult, specific bool) {
+// inserts them into results keyed by the original DNS stamp from stamps, if
+// any, or by the address.  It can insert multiple results as well as none.
+func insertListResults(
+	ups []upstream.Upstream,
+	results map[string]*upstreamResult,
+	stamps map[string]string,
+	specific bool,
+) {
 	for _, u := range ups {
 		addr := u.Address()
+		if stamp, ok := stamps[addr]; ok {
+			addr = stamp
+		}
+
 		_, ok := results[addr]
 		if ok {
 			continue
@@ -159,11 +218,12 @@ func insertListResults(ups []upstream.Upstream, results map[string]*upstreamResu
 	}
 }
 
-// check tries to exchange with each successfully parsed upstream and enriches
-// the results with the healthcheck errors.  It should not be called after the
+// check tries to exchange with each successfully parsed upstream probes times
+// and enriches the results with the healthcheck errors and the latencies.
+// probes must be positive.  It should not be called after the
 // [upsConfValidator.close] method, since it makes no sense to check the closed
 // upstreams.
-func (cv *upstreamConfigValidator) check() {
+func (cv *upstreamConfigValidator) check(probes int) {
 	const (
 		// testTLD is the special-use fully-qualified domain name for testing
 		// the DNS server reachability.
@@ -196,26 +256,26 @@ func (cv *upstreamConfigValidator) check() {
 		len(cv.privateUpstreamResults))
 
 	for _, res := range cv.generalUpstreamResults {
-		go checkSrv(res, wg, commonChecker)
+		go checkSrv(res, wg, commonChecker, probes)
 	}
 	for _, res := range cv.fallbackUpstreamResults {
-		go checkSrv(res, wg, commonChecker)
+		go checkSrv(res, wg, commonChecker, probes)
 	}
 	for _, res := range cv.privateUpstreamResults {
-		go checkSrv(res, wg, arpaChecker)
+		go checkSrv(res, wg, arpaChecker, probes)
 	}
 
 	wg.Wait()
 }
 
-// checkSrv runs hc on the server from res, if any, and stores any occurred
-// error in res.  wg is always marked done in the end.  It is intended to be
-// used as a goroutine.
-func checkSrv(res *upstreamResult, wg *sync.WaitGroup, hc *healthchecker) {
+// checkSrv runs hc on the server from res probes times, if any, and stores the
+// latencies and any occurred error in res.  wg is always marked done in the
+// end.  It is intended to be used as a goroutine.
+func checkSrv(res *upstreamResult, wg *sync.WaitGroup, hc *healthchecker, probes int) {
 	defer log.OnPanic(fmt.Sprintf("dnsforward: checking upstream %s", res.server.Address()))
 	defer wg.Done()
 
-	res.err = hc.check(res.server)
+	res.latencies, res.err = hc.check(res.server, probes)
 	if res.err != nil && res.isSpecific {
 		res.err = domainSpecificTestError{Err: res.err}
 	}
@@ -280,6 +340,30 @@ func (cv *upstreamConfigValidator) status() (results map[string]string) {
 	return results
 }
 
+// latencies returns the latencies of the successful probes of the checked
+// upstreams keyed by the original upstream configuration piece, like
+// [upstreamConfigValidator.status] does.  If an upstream appears in several
+// sections, the latencies from the first one are used.
+func (cv *upstreamConfigValidator) latencies() (lats map[string][]time.Duration) {
+	lats = map[string][]time.Duration{}
+
+	all := []map[string]*upstreamResult{
+		cv.generalUpstreamResults,
+		cv.fallbackUpstreamResults,
+		cv.privateUpstreamResults,
+	}
+
+	for _, m := range all {
+		for original, res := range m {
+			if _, ok := lats[original]; !ok {
+				lats[original] = res.latencies
+			}
+		}
+	}
+
+	return lats
+}
+
 // upstreamResultToStatus puts "OK" or an error message from res into resMap.
 // section is the name of the upstream configuration section, i.e. "general",
 // "fallback", or "private", and only used for logging.
@@ -384,26 +468,38 @@ type healthchecker struct {
 	ansEmpty bool
 }
 
-// check exchanges with u and validates the response.
-func (h *healthchecker) check(u upstream.Upstream) (err error) {
-	req := &dns.Msg{
-		MsgHdr: dns.MsgHdr{
-			Id:               dns.Id(),
-			RecursionDesired: true,
-		},
-		Question: []dns.Question{{
-			Name:   h.hostname,
-			Qtype:  h.qtype,
-			Qclass: dns.ClassINET,
-		}},
-	}
+// check exchanges with u probes times in a row and validates the responses.
+// latencies are the round-trip times of the successful exchanges, up to the
+// first failed one.  probes must be positive.
+func (h *healthchecker) check(
+	u upstream.Upstream,
+	probes int,
+) (latencies []time.Duration, err error) {
+	latencies = make([]time.Duration, 0, probes)
+	for range probes {
+		req := &dns.Msg{
+			MsgHdr: dns.MsgHdr{
+				Id:               dns.Id(),
+				RecursionDesired: true,
+			},
+			Question: []dns.Question{{
+				Name:   h.hostname,
+				Qtype:  h.qtype,
+				Qclass: dns.ClassINET,
+			}},
+		}
+
+		start := time.Now()
+		reply, exchErr := u.Exchange(req)
+		elapsed := time.Since(start)
+		if exchErr != nil {
+			return latencies, fmt.Errorf("couldn't communicate with upstream: %w", exchErr)
+		} else if h.ansEmpty && len(reply.Answer) > 0 {
+			return latencies, errors.Error("wrong response")
+		}
 
-	reply, err := u.Exchange(req)
-	if err != nil {
-		return fmt.Errorf("couldn't communicate with upstream: %w", err)
-	} else if h.ansEmpty && len(reply.Answer) > 0 {
-		return errors.Error("wrong response")
+		latencies = append(latencies, elapsed)
 	}
 
-	return nil
+	return latencies, nil
 }
@@ -0,0 +1,411 @@
+package dnsforward
+
+import (
+	"cmp"
+	"container/list"
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/quic-go/quic-go"
+)
+
+// maxTrackedConns is the maximum number of the tracked connections of the
+// encrypted listeners.  The least recently used connections are forgotten when
+// the limit is reached.
+const maxTrackedConns = 10_000
+
+// Idle timeouts after which the connections are considered closed and aren't
+// tracked anymore.
+const (
+	// tlsConnIdleTimeout is the idle timeout of the DoT connections, which is
+	// the same as the one used by the DoT server of package proxy.
+	tlsConnIdleTimeout = 10 * time.Second
+
+	// quicConnIdleTimeout is the idle timeout of the DoQ connections.  Those
+	// are usually forgotten as soon as they're closed.
+	quicConnIdleTimeout = 5 * time.Minute
+
+	// httpsConnIdleTimeout is the idle timeout of the DoH connections.
+	httpsConnIdleTimeout = 5 * time.Minute
+)
+
+// quicCodeNoError is the DOQ_NO_ERROR error code used to close the DoQ
+// connections, see RFC 9250, Section 4.3.
+const quicCodeNoError quic.ApplicationErrorCode = 0
+
+// trackedConn is a tracked connection of an encrypted listener.
+type trackedConn struct {
+	// closeFunc closes the connection.  It's nil for the DoH connections,
+	// since those can't be closed.
+	closeFunc func() (err error)
+
+	// elem is the element of the connection in the recency list of the
+	// tracker.  Its value is the key of the connection.
+	elem *list.Element
+
+	// firstQuery is the time of the first query over the connection.
+	firstQuery time.Time
+
+	// lastQuery is the time of the latest query over the connection.
+	lastQuery time.Time
+
+	// remoteAddr is the address of the client.
+	remoteAddr string
+
+	// alpn is the negotiated application protocol of the connection, if any.
+	alpn string
+
+	// clientID is the ClientID of the latest query with one, if any.
+	clientID string
+
+	// proto is the DNS protocol of the connection.
+	proto proxy.Proto
+
+	// id is the unique identifier of the connection.
+	id uint64
+
+	// queries is the number of queries over the connection.
+	queries uint64
+}
+
+// idleTimeout returns the duration after which c is considered closed if there
+// are no queries over it.
+func (c *trackedConn) idleTimeout() (d time.Duration) {
+	switch c.proto {
+	case proxy.ProtoTLS:
+		return tlsConnIdleTimeout
+	case proxy.ProtoQUIC:
+		return quicConnIdleTimeout
+	default:
+		return httpsConnIdleTimeout
+	}
+}
+
+// connTracker tracks the connections of the encrypted DoT, DoQ, and DoH
+// listeners.  It's safe for concurrent use.
+type connTracker struct {
+	// mu protects conns, recent, and lastID.
+	mu *sync.Mutex
+
+	// conns maps the keys of the connections to their data.  The keys are the
+	// connections themselves for DoT and DoQ and the remote addresses for DoH.
+	conns map[any]*trackedConn
+
+	// recent is the list of the keys of conns from the most recently used
+	// connection to the least recently used one.
+	recent *list.List
+
+	// lastID is the identifier of the latest tracked connection.
+	lastID uint64
+}
+
+// newConnTracker returns a new properly initialized *connTracker.
+func newConnTracker() (t *connTracker) {
+	return &connTracker{
+		mu:     &sync.Mutex{},
+		conns:  map[any]*trackedConn{},
+		recent: list.New(),
+	}
+}
+
+// track records a query received over the connection of pctx, if it's a
+// connection of an encrypted listener.  clientID is the ClientID of the query,
+// if any.
+func (t *connTracker) track(pctx *proxy.DNSContext, clientID string) {
+	key, c, done := newTrackedConn(pctx)
+	if key == nil {
+		return
+	}
+
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tracked, ok := t.conns[key]
+	if !ok {
+		t.makeRoomLocked(now)
+
+		t.lastID++
+		c.id, c.firstQuery, c.elem = t.lastID, now, t.recent.PushFront(key)
+		t.conns[key] = c
+		tracked = c
+
+		if done != nil {
+			go t.untrackOnDone(key, c.id, done)
+		}
+	} else {
+		t.recent.MoveToFront(tracked.elem)
+	}
+
+	tracked.lastQuery = now
+	tracked.queries++
+	if clientID != "" {
+		tracked.clientID = clientID
+	}
+}
+
+// newTrackedConn returns the key and the data of the connection of pctx.  done
+// is closed when the connection is closed, if that's known.  key is nil if the
+// connection shouldn't be tracked.
+func newTrackedConn(pctx *proxy.DNSContext) (key any, c *trackedConn, done <-chan struct{}) {
+	c = &trackedConn{
+		proto: pctx.Proto,
+	}
+
+	switch pctx.Proto {
+	case proxy.ProtoTLS:
+		conn := pctx.Conn
+		if conn == nil {
+			return nil, nil, nil
+		}
+
+		if tc, ok := conn.(*tls.Conn); ok {
+			c.alpn = tc.ConnectionState().NegotiatedProtocol
+		}
+
+		key, c.closeFunc, c.remoteAddr = conn, conn.Close, conn.RemoteAddr().String()
+	case proxy.ProtoQUIC:
+		qc := pctx.QUICConnection
+		if qc == nil {
+			return nil, nil, nil
+		}
+
+		c.alpn = qc.ConnectionState().TLS.NegotiatedProtocol
+		c.closeFunc = func() (err error) {
+			return qc.CloseWithError(quicCodeNoError, "")
+		}
+
+		key, c.remoteAddr, done = qc, qc.RemoteAddr().String(), qc.Context().Done()
+	case proxy.ProtoHTTPS:
+		r := pctx.HTTPRequest
+		if r == nil {
+			return nil, nil, nil
+		}
+
+		c.alpn = r.Proto
+		if r.TLS != nil && r.TLS.NegotiatedProtocol != "" {
+			c.alpn = r.TLS.NegotiatedProtocol
+		}
+
+		key, c.remoteAddr = r.RemoteAddr, r.RemoteAddr
+	default:
+		return nil, nil, nil
+	}
+
+	return key, c, done
+}
+
+// makeRoomLocked removes the least recently used idle connections and, if
+// there are still too many connections, the least recently used one.  It takes
+// amortized constant time.  t.mu is expected to be locked.
+func (t *connTracker) makeRoomLocked(now time.Time) {
+	if len(t.conns) < maxTrackedConns {
+		return
+	}
+
+	for e := t.recent.Back(); e != nil; e = t.recent.Back() {
+		c := t.conns[e.Value]
+		if now.Sub(c.lastQuery) <= c.idleTimeout() {
+			break
+		}
+
+		t.removeLocked(e.Value)
+	}
+
+	if len(t.conns) >= maxTrackedConns {
+		t.removeLocked(t.recent.Back().Value)
+	}
+}
+
+// removeIdleLocked removes the connections considered closed.  t.mu is expected
+// to be locked.
+func (t *connTracker) removeIdleLocked(now time.Time) {
+	for key, c := range t.conns {
+		if now.Sub(c.lastQuery) > c.idleTimeout() {
+			t.removeLocked(key)
+		}
+	}
+}
+
+// removeLocked stops tracking the connection with key, which must be tracked.
+// t.mu is expected to be locked.
+func (t *connTracker) removeLocked(key any) {
+	t.recent.Remove(t.conns[key].elem)
+	delete(t.conns, key)
+}
+
+// untrackOnDone removes the connection with key and id when done is closed.
+// It's intended to be used as a goroutine.
+func (t *connTracker) untrackOnDone(key any, id uint64, done <-chan struct{}) {
+	defer log.OnPanic("dnsforward: untracking connection")
+
+	<-done
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Make sure that the connection hasn't been replaced.
+	if c, ok := t.conns[key]; ok && c.id == id {
+		t.removeLocked(key)
+	}
+}
+
+// connJSON is the JSON representation of a tracked connection.
+type connJSON struct {
+	// ClientID is the ClientID of the latest query with one, if any.
+	ClientID string `json:"client_id,omitempty"`
+
+	// Protocol is the DNS protocol, such as "tls", "quic", or "https".
+	Protocol string `json:"protocol"`
+
+	// ALPN is the negotiated application protocol, if any.
+	ALPN string `json:"alpn,omitempty"`
+
+	// RemoteAddr is the address of the client.
+	RemoteAddr string `json:"remote_addr"`
+
+	// ID is the identifier of the connection.
+	ID uint64 `json:"id"`
+
+	// AgeSec is the time since the first query, in seconds.
+	AgeSec float64 `json:"age_sec"`
+
+	// Queries is the number of queries over the connection.
+	Queries uint64 `json:"queries"`
+
+	// Closable is true if the connection can be closed.
+	Closable bool `json:"closable"`
+}
+
+// list returns the tracked connections sorted by their identifiers.
+func (t *connTracker) list(now time.Time) (conns []*connJSON) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.removeIdleLocked(now)
+
+	conns = make([]*connJSON, 0, len(t.conns))
+	for _, c := range t.conns {
+		conns = append(conns, &connJSON{
+			ClientID:   c.clientID,
+			Protocol:   string(c.proto),
+			ALPN:       c.alpn,
+			RemoteAddr: c.remoteAddr,
+			ID:         c.id,
+			AgeSec:     now.Sub(c.firstQuery).Seconds(),
+			Queries:    c.queries,
+			Closable:   c.closeFunc != nil,
+		})
+	}
+
+	slices.SortFunc(conns, func(a, b *connJSON) (res int) {
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	return conns
+}
+
+// errConnNotFound is returned by [connTracker.close] when there is no tracked
+// connection with the identifier.
+const errConnNotFound errors.Error = "connection not found"
+
+// errConnNotClosable is returned by [connTracker.close] when the connection
+// can't be closed.
+const errConnNotClosable errors.Error = "connection can't be closed"
+
+// close closes the tracked connection with id and stops tracking it.
+func (t *connTracker) close(id uint64) (err error) {
+	c, err := t.remove(id)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	log.Info("dnsforward: closing %s connection %d from %s", c.proto, id, c.remoteAddr)
+
+	return c.closeFunc()
+}
+
+// remove stops tracking the closable connection with id and returns it.
+func (t *connTracker) remove(id uint64) (c *trackedConn, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key, tracked := range t.conns {
+		if tracked.id != id {
+			continue
+		} else if tracked.closeFunc == nil {
+			return nil, errConnNotClosable
+		}
+
+		t.removeLocked(key)
+
+		return tracked, nil
+	}
+
+	return nil, errConnNotFound
+}
+
+// reset stops tracking all connections.
+func (t *connTracker) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	clear(t.conns)
+	t.recent.Init()
+}
+
+// connsJSON is the response for the GET /control/dns/connections HTTP API.
+type connsJSON struct {
+	Connections []*connJSON `json:"connections"`
+}
+
+// handleGetConnections is the handler for the GET /control/dns/connections
+// HTTP API.
+func (s *Server) handleGetConnections(w http.ResponseWriter, r *http.Request) {
+	aghhttp.WriteJSONResponseOK(w, r, &connsJSON{
+		Connections: s.conns.list(time.Now()),
+	})
+}
+
+// connCloseJSON is the request for the POST /control/dns/connections/close
+// HTTP API.
+type connCloseJSON struct {
+	ID uint64 `json:"id"`
+}
+
+// handleCloseConnection is the handler for the POST
+// /control/dns/connections/close HTTP API.
+func (s *Server) handleCloseConnection(w http.ResponseWriter, r *http.Request) {
+	req := &connCloseJSON{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "reading req: %s", err)
+
+		return
+	}
+
+	err = s.conns.close(req.ID)
+	switch {
+	case errors.Is(err, errConnNotFound):
+		aghhttp.Error(r, w, http.StatusNotFound, "connection %d: %s", req.ID, err)
+	case errors.Is(err, errConnNotClosable):
+		aghhttp.Error(r, w, http.StatusUnprocessableEntity, "connection %d: %s", req.ID, err)
+	case err != nil:
+		// The connection is forgotten anyway, so just log the error.
+		log.Debug("dnsforward: closing connection %d: %s", req.ID, err)
+
+		aghhttp.OK(w)
+	default:
+		aghhttp.OK(w)
+	}
+}
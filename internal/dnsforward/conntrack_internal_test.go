@@ -0,0 +1,175 @@
+package dnsforward
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_conns_tls(t *testing.T) {
+	const clientID = "client-1"
+
+	s, _ := createTestTLS(t, TLSConfig{
+		TLSListenAddrs: []*net.TCPAddr{{}},
+		ServerName:     tlsServerName,
+	})
+	s.conf.UpstreamConfig.Upstreams = []upstream.Upstream{newGoogleUpstream()}
+	startDeferStop(t, s)
+
+	client := &dns.Client{
+		Net: "tcp-tls",
+		TLSConfig: &tls.Config{
+			InsecureSkipVerify: true,
+			ServerName:         clientID + "." + tlsServerName,
+		},
+		Timeout: dnsClientTimeout,
+	}
+
+	conn, err := client.Dial(s.dnsProxy.Addr(proxy.ProtoTLS).String())
+	require.NoError(t, err)
+
+	// Don't check the error, since the connection is closed by the server
+	// below, so closing it fails to send the alert.
+	t.Cleanup(func() { _ = conn.Close() })
+
+	for range 2 {
+		var resp *dns.Msg
+		resp, _, err = client.ExchangeWithConn(createGoogleATestMessage(), conn)
+		require.NoError(t, err)
+
+		assertGoogleAResponse(t, resp)
+	}
+
+	conns := s.conns.list(time.Now())
+	require.Len(t, conns, 1)
+
+	got := conns[0]
+	assert.Equal(t, clientID, got.ClientID)
+	assert.Equal(t, string(proxy.ProtoTLS), got.Protocol)
+	assert.Equal(t, conn.LocalAddr().String(), got.RemoteAddr)
+	assert.Equal(t, uint64(2), got.Queries)
+	assert.True(t, got.Closable)
+
+	err = s.conns.close(got.ID)
+	require.NoError(t, err)
+
+	assert.Empty(t, s.conns.list(time.Now()))
+
+	_, _, err = client.ExchangeWithConn(createGoogleATestMessage(), conn)
+	assert.Error(t, err)
+
+	err = s.conns.close(got.ID)
+	assert.ErrorIs(t, err, errConnNotFound)
+}
+
+func TestServer_conns_quic(t *testing.T) {
+	s, _ := createTestTLS(t, TLSConfig{
+		QUICListenAddrs: []*net.UDPAddr{{IP: net.IP{127, 0, 0, 1}}},
+	})
+	s.conf.UpstreamConfig.Upstreams = []upstream.Upstream{newGoogleUpstream()}
+	startDeferStop(t, s)
+
+	addr := s.dnsProxy.Addr(proxy.ProtoQUIC)
+	opts := &upstream.Options{InsecureSkipVerify: true}
+
+	// Each upstream uses its own connection.
+	const connsNum = 3
+	for range connsNum {
+		u, err := upstream.AddressToUpstream(fmt.Sprintf("%s://%s", proxy.ProtoQUIC, addr), opts)
+		require.NoError(t, err)
+		testutil.CleanupAndRequireSuccess(t, u.Close)
+
+		resp, err := u.Exchange(createGoogleATestMessage())
+		require.NoError(t, err)
+
+		assertGoogleAResponse(t, resp)
+	}
+
+	conns := s.conns.list(time.Now())
+	require.Len(t, conns, connsNum)
+
+	for _, c := range conns {
+		assert.Equal(t, string(proxy.ProtoQUIC), c.Protocol)
+		assert.Equal(t, "doq", c.ALPN)
+		assert.Equal(t, uint64(1), c.Queries)
+		assert.True(t, c.Closable)
+	}
+
+	err := s.conns.close(conns[0].ID)
+	require.NoError(t, err)
+
+	assert.Len(t, s.conns.list(time.Now()), connsNum-1)
+}
+
+func TestConnTracker_https(t *testing.T) {
+	const remoteAddr = "192.0.2.1:12345"
+
+	tr := newConnTracker()
+
+	r := &http.Request{
+		Proto:      "HTTP/2.0",
+		RemoteAddr: remoteAddr,
+	}
+	for range 3 {
+		tr.track(&proxy.DNSContext{Proto: proxy.ProtoHTTPS, HTTPRequest: r}, "")
+	}
+
+	// Plain DNS connections aren't tracked.
+	tr.track(&proxy.DNSContext{Proto: proxy.ProtoUDP}, "")
+
+	conns := tr.list(time.Now())
+	require.Len(t, conns, 1)
+
+	got := conns[0]
+	assert.Equal(t, "HTTP/2.0", got.ALPN)
+	assert.Equal(t, remoteAddr, got.RemoteAddr)
+	assert.Equal(t, uint64(3), got.Queries)
+	assert.False(t, got.Closable)
+
+	err := tr.close(got.ID)
+	assert.ErrorIs(t, err, errConnNotClosable)
+
+	assert.Empty(t, tr.list(time.Now().Add(httpsConnIdleTimeout+time.Second)))
+}
+
+func TestConnTracker_makeRoomLocked(t *testing.T) {
+	tr := newConnTracker()
+
+	newPctx := func(i int) (pctx *proxy.DNSContext) {
+		return &proxy.DNSContext{
+			Proto: proxy.ProtoHTTPS,
+			HTTPRequest: &http.Request{
+				RemoteAddr: netip.AddrPortFrom(netip.IPv4Unspecified(), uint16(i)).String(),
+			},
+		}
+	}
+
+	for i := range maxTrackedConns {
+		tr.track(newPctx(i), "")
+	}
+
+	// Use the first connection again, so that the second one becomes the
+	// least recently used.
+	tr.track(newPctx(0), "")
+	tr.track(newPctx(maxTrackedConns), "")
+
+	conns := tr.list(time.Now())
+	require.Len(t, conns, maxTrackedConns)
+
+	assert.Equal(t, uint64(1), conns[0].ID)
+	assert.Equal(t, uint64(2), conns[0].Queries)
+	assert.Equal(t, uint64(3), conns[1].ID)
+	assert.Equal(t, uint64(maxTrackedConns+1), conns[len(conns)-1].ID)
+	assert.Equal(t, maxTrackedConns, tr.recent.Len())
+}
@@ -2,7 +2,6 @@
 package dnsforward
 
 import (
-	"cmp"
 	"context"
 	"fmt"
 	"io"
@@ -18,6 +17,7 @@ import (
 	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/AdguardTeam/AdGuardHome/internal/aghos"
 	"github.com/AdguardTeam/AdGuardHome/internal/client"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
 	"github.com/AdguardTeam/AdGuardHome/internal/querylog"
@@ -73,11 +73,10 @@ type DHCP interface {
 	// due to an assumption that a DHCP client must always have an IP address.
 	HostByIP(ip netip.Addr) (host string)
 
-	// IPByHost returns the IP address of the DHCP client with the given
-	// hostname.  The hostname will be an empty string if there is no such
-	// client, due to an assumption that a DHCP client must always have a
-	// hostname, either set by the client or assigned automatically.
-	IPByHost(host string) (ip netip.Addr)
+	// IPByHost returns the IP addresses of the DHCP client with the given
+	// hostname, of both families if the client has the leases for both.  ips
+	// is empty if there is no such client.
+	IPByHost(host string) (ips []netip.Addr)
 
 	// Enabled returns true if DHCP provides information about clients.
 	Enabled() (ok bool)
@@ -120,8 +119,10 @@ type Server struct {
 	// stats is the statistics collector for client's DNS usage data.
 	stats stats.Interface
 
-	// access drops disallowed clients.
-	access *accessManager
+	// access drops disallowed clients.  It's stored atomically so that the
+	// access settings could be replaced without locking the server on each
+	// query.  It's nil until the server is prepared.
+	access atomic.Pointer[accessManager]
 
 	// baseLogger is used to create loggers for other entities.  It should not
 	// have a prefix and must not be nil.
@@ -167,10 +168,89 @@ type Server struct {
 	// anonymizer masks the client's IP addresses if needed.
 	anonymizer *aghnet.IPMut
 
+	// clientIDEDNSTrusted is the set of networks from which the ClientIDs are
+	// accepted in the EDNS(0) option.  See [Config.ClientIDEDNSOption].
+	clientIDEDNSTrusted netutil.SliceSubnetSet
+
+	// ecsTrustedProxies is the set of networks of the downstream forwarders,
+	// the EDNS Client Subnet options of which are trusted.  See
+	// [EDNSClientSubnet.TrustedProxies].
+	ecsTrustedProxies netutil.SliceSubnetSet
+
+	// respSizeLimits maps the query types to the maximum sizes of the responses
+	// to them.  See [Config.MaxResponseSizes].
+	respSizeLimits map[uint16]int
+
+	// clientRateLimiter limits the number of requests from each client.  It's
+	// nil if [Config.ClientRatelimit] is zero.
+	clientRateLimiter *clientRateLimiter
+
+	// clientRateLimiterDone is closed to stop the eviction of the idle clients
+	// from clientRateLimiter.  It's nil if the server isn't running or the
+	// client rate limiting is disabled.
+	clientRateLimiterDone chan struct{}
+
+	// staleCache stores the responses to serve after they expire in case all
+	// the upstream servers fail.  It's nil if serving the expired responses is
+	// disabled.
+	staleCache *staleCache
+
+	// failover records the tiers of the upstreams answering the requests in
+	// [UpstreamModeFailover].  It's nil in the other modes.
+	failover *failoverTracker
+
+	// conns tracks the connections of the encrypted listeners.
+	conns *connTracker
+
+	// sessions tracks the sessions of the clients.
+	sessions *clientSessionTracker
+
+	// sessionsDone is closed to stop the eviction of the idle sessions from
+	// sessions.  It's nil if the server isn't running.
+	sessionsDone chan struct{}
+
+	// hijack quarantines the upstream servers rewriting NXDOMAIN responses.
+	// It's nil if [Config.HijackDetection] is disabled.
+	hijack *hijackDetector
+
+	// hijackDone is closed to stop probing the upstream servers by hijack.
+	// It's nil if the server isn't running or the detection is disabled.
+	hijackDone chan struct{}
+
+	// groupCaches maps the FQDNs of the domains of the groups of
+	// domain-specific upstreams to the custom upstream configurations with
+	// separate caches.  See [Config.UpstreamCaches].
+	groupCaches map[string]*proxy.CustomUpstreamConfig
+
+	// ifaceByName returns the network interface by its name.  It's replaced in
+	// tests.
+	ifaceByName ifaceByNameFunc
+
+	// ifaceBindings are the upstream configurations of the network interfaces.
+	// See [Config.InterfaceBindings].
+	ifaceBindings []*ifaceBinding
+
+	// views are the prepared upstream configurations of the views.  See
+	// [Config.Views].
+	views []*preparedView
+
+	// uncached stores the copies of the upstream configurations without caches
+	// for the requests bypassing the caches.
+	uncached *uncachedUpstreams
+
 	// clientIDCache is a temporary storage for ClientIDs that were extracted
 	// during the BeforeRequestHandler stage.
 	clientIDCache cache.Cache
 
+	// newFSWatcher creates the watchers of the file with upstream DNS servers.
+	// It's replaced in tests.
+	newFSWatcher newFSWatcherFunc
+
+	// upstreamsWatcher tracks the changes of the file with upstream DNS
+	// servers.  It's nil if the server isn't running or there is no such file.
+	// See [Config.UpstreamDNSFileName].
+	upstreamsWatcher aghos.FSWatcher
+
 	// internalProxy resolves internal requests from the application itself.  It
 	// isn't started and so no listen ports are required.
 	internalProxy *proxy.Proxy
@@ -252,7 +332,12 @@ func NewServer(p DNSCreateParams) (s *Server, err error) {
 			EnableLRU: true,
 			MaxCount:  defaultClientIDCacheCount,
 		}),
-		anonymizer: p.Anonymizer,
+		anonymizer:   p.Anonymizer,
+		conns:        newConnTracker(),
+		sessions:     newClientSessionTracker(),
+		ifaceByName:  netIfaceByName,
+		newFSWatcher: aghos.NewOSWritesWatcher,
+		uncached:     newUncachedUpstreams(),
 		conf: ServerConfig{
 			ServePlainDNS: true,
 		},
@@ -299,12 +384,21 @@ func (s *Server) WriteDiskConfig(c *Config) {
 	sc := s.conf.Config
 	*c = sc
 	c.RatelimitWhitelist = slices.Clone(sc.RatelimitWhitelist)
+	c.ClientRatelimitWhitelist = slices.Clone(sc.ClientRatelimitWhitelist)
 	c.BootstrapDNS = slices.Clone(sc.BootstrapDNS)
+	c.UpstreamGroupBootstrapDNS = slices.Clone(sc.UpstreamGroupBootstrapDNS)
+	c.UpstreamTimeouts = slices.Clone(sc.UpstreamTimeouts)
+	c.UpstreamCaches = slices.Clone(sc.UpstreamCaches)
+	c.InterfaceBindings = slices.Clone(sc.InterfaceBindings)
+	c.Views = cloneViews(sc.Views)
+	c.RebindingAllowlist = slices.Clone(sc.RebindingAllowlist)
 	c.FallbackDNS = slices.Clone(sc.FallbackDNS)
 	c.AllowedClients = slices.Clone(sc.AllowedClients)
 	c.DisallowedClients = slices.Clone(sc.DisallowedClients)
 	c.BlockedHosts = slices.Clone(sc.BlockedHosts)
+	c.ProtocolAccess = cloneProtocolAccess(sc.ProtocolAccess)
 	c.TrustedProxies = slices.Clone(sc.TrustedProxies)
+	c.ClientIDEDNSTrustedNets = slices.Clone(sc.ClientIDEDNSTrustedNets)
 	c.UpstreamDNS = slices.Clone(sc.UpstreamDNS)
 }
 
@@ -331,14 +425,55 @@ func (s *Server) AddrProcConfig() (c *client.DefaultAddrProcConfig) {
 
 // Resolve gets IP addresses by host name from an upstream server.  No
 // request/response filtering is performed.  Query log and Stats are not
-// updated.  This method may be called before [Server.Start].
+// updated.  The hostnames of the DHCP clients within the local domain are
+// resolved using the DHCP leases first.  This method may be called before
+// [Server.Start].
 func (s *Server) Resolve(ctx context.Context, net, host string) (addr []netip.Addr, err error) {
 	s.serverLock.RLock()
 	defer s.serverLock.RUnlock()
 
+	for _, ip := range s.dhcpIPByHost(host) {
+		if ipMatchesNetwork(ip, net) {
+			addr = append(addr, ip)
+		}
+	}
+
+	if len(addr) > 0 {
+		return addr, nil
+	}
+
 	return s.internalProxy.LookupNetIP(ctx, net, host)
 }
 
+// dhcpIPByHost returns the IP addresses of the DHCP client with the hostname
+// from host, if the DHCP server is enabled and host is within the local
+// domain.  Otherwise, it returns nil.
+func (s *Server) dhcpIPByHost(host string) (ips []netip.Addr) {
+	if !s.dhcpServer.Enabled() {
+		return nil
+	}
+
+	dhcpHost := s.dhcpHostFromName(host)
+	if dhcpHost == "" {
+		return nil
+	}
+
+	return s.dhcpServer.IPByHost(dhcpHost)
+}
+
+// ipMatchesNetwork returns true if ip belongs to the address family of the
+// network, which is one of "ip", "ip4", and "ip6".
+func ipMatchesNetwork(ip netip.Addr, network string) (ok bool) {
+	switch network {
+	case "ip4":
+		return ip.Is4()
+	case "ip6":
+		return ip.Is6()
+	default:
+		return true
+	}
+}
+
 const (
 	// ErrRDNSNoData is returned by [RDNSExchanger.Exchange] when the answer
 	// section of response is either NODATA or has no PTR records.
@@ -389,6 +524,12 @@ func (s *Server) Exchange(ip netip.Addr) (host string, ttl time.Duration, err er
 			return "", 0, nil
 		}
 
+		// Prefer the DHCP leases to the private upstreams, since the latter
+		// may not know about the clients of the built-in DHCP server.
+		if host, ttl = s.dhcpHostByIP(ip); host != "" {
+			return host, ttl, nil
+		}
+
 		errMsg = "resolving a private address: %w"
 		dctx.RequestedPrivateRDNS = netip.PrefixFrom(ip, ip.BitLen())
 	} else {
@@ -401,6 +542,28 @@ func (s *Server) Exchange(ip netip.Addr) (host string, ttl time.Duration, err er
 	return hostFromPTR(dctx.Res)
 }
 
+// dhcpHostByIP returns the fully-qualified hostname without the trailing dot of
+// the DHCP client with ip and the TTL for it, if the DHCP server is enabled.
+// Otherwise, it returns an empty string.
+func (s *Server) dhcpHostByIP(ip netip.Addr) (host string, ttl time.Duration) {
+	if !s.dhcpServer.Enabled() {
+		return "", 0
+	}
+
+	host = s.dhcpServer.HostByIP(ip)
+	if host == "" {
+		return "", 0
+	}
+
+	log.Debug("dnsforward: dhcp client %s is %q", ip, host)
+
+	// TODO(e.burkov):  Use [dhcpsvc.Lease.Expiry].  See
+	// https://github.com/AdguardTeam/AdGuardHome/issues/3932.
+	ttl = time.Duration(s.dnsFilter.BlockedResponseTTL()) * time.Second
+
+	return host + "." + s.localDomainSuffix, ttl
+}
+
 // hostFromPTR returns domain name from the PTR response or error.
 func hostFromPTR(resp *dns.Msg) (host string, ttl time.Duration, err error) {
 	// Distinguish between NODATA response and a failed request.
@@ -456,11 +619,33 @@ func (s *Server) Start() error {
 func (s *Server) startLocked() error {
 	// TODO(e.burkov):  Use context properly.
 	err := s.dnsProxy.Start(context.Background())
-	if err == nil {
-		s.isRunning = true
+	if err != nil {
+		return err
 	}
 
-	return err
+	if s.clientRateLimiter != nil {
+		s.clientRateLimiterDone = make(chan struct{})
+		go s.clientRateLimiter.runEviction(s.clientRateLimiterDone)
+	}
+
+	idleTimeout := time.Duration(s.conf.SessionIdleTimeout)
+	if idleTimeout <= 0 {
+		idleTimeout = defaultSessionIdleTimeout
+	}
+
+	s.sessionsDone = make(chan struct{})
+	go s.sessions.runEviction(s.sessionsDone, idleTimeout)
+
+	if s.hijack != nil {
+		s.hijackDone = make(chan struct{})
+		go s.hijack.runProbes(s.hijackDone)
+	}
+
+	s.isRunning = true
+
+	s.watchUpstreamsFile()
+
+	return nil
 }
 
 // Prepare initializes parameters of s using data from conf.  conf must not be
@@ -475,10 +660,25 @@ func (s *Server) Prepare(conf *ServerConfig) (err error) {
 		if err != nil {
 			return fmt.Errorf("checking blocking mode: %w", err)
 		}
+
+		err = validateAAAABlockingMode(s.dnsFilter.AAAABlockingMode())
+		if err != nil {
+			return fmt.Errorf("checking blocking mode: %w", err)
+		}
+
+		err = validateSafeBrowsingBlockingMode(s.dnsFilter.SafeBrowsingBlockingMode())
+		if err != nil {
+			return fmt.Errorf("checking blocking mode: %w", err)
+		}
 	}
 
 	s.initDefaultSettings()
 
+	s.hijack, err = newHijackDetector(&s.conf.HijackDetection)
+	if err != nil {
+		return fmt.Errorf("preparing hijack detection: %w", err)
+	}
+
 	err = s.prepareInternalDNS()
 	if err != nil {
 		// Don't wrap the error, because it's informative enough as is.
@@ -490,17 +690,53 @@ func (s *Server) Prepare(conf *ServerConfig) (err error) {
 		return fmt.Errorf("preparing proxy: %w", err)
 	}
 
+	err = validateClientIDEDNSOption(s.conf.ClientIDEDNSOption)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	}
+
+	s.clientIDEDNSTrusted = netutil.UnembedPrefixes(s.conf.ClientIDEDNSTrustedNets)
+
+	s.ecsTrustedProxies = nil
+	if ecs := s.conf.EDNSClientSubnet; ecs != nil {
+		s.ecsTrustedProxies = netutil.UnembedPrefixes(ecs.TrustedProxies)
+	}
+
+	s.respSizeLimits, err = newRespSizeLimits(s.conf.MaxResponseSizes)
+	if err != nil {
+		return fmt.Errorf("preparing max response sizes: %w", err)
+	}
+
+	s.clientRateLimiter = nil
+	if rl := s.conf.ClientRatelimit; rl > 0 {
+		s.clientRateLimiter = newClientRateLimiter(
+			rl,
+			s.conf.ClientRatelimitWhitelist,
+			s.conf.RatelimitSubnetLenIPv4,
+			s.conf.RatelimitSubnetLenIPv6,
+		)
+	}
+
+	s.staleCache, err = newStaleCacheFromConf(&s.conf.Config)
+	if err != nil {
+		return fmt.Errorf("preparing stale cache: %w", err)
+	}
+
 	s.setupDNS64()
 
-	s.access, err = newAccessCtx(
+	access, err := newAccessCtx(
 		s.conf.AllowedClients,
 		s.conf.DisallowedClients,
 		s.conf.BlockedHosts,
+		s.conf.ProtocolAccess,
 	)
 	if err != nil {
 		return fmt.Errorf("preparing access: %w", err)
 	}
 
+	s.access.Store(access)
+
 	proxyConfig.Fallbacks, err = s.setupFallbackDNS()
 	if err != nil {
 		return fmt.Errorf("setting up fallback dns servers: %w", err)
@@ -520,8 +756,12 @@ func (s *Server) Prepare(conf *ServerConfig) (err error) {
 	return nil
 }
 
-// prepareUpstreamSettings sets upstream DNS server settings.
-func (s *Server) prepareUpstreamSettings(boot upstream.Resolver) (err error) {
+// prepareUpstreamSettings sets upstream DNS server settings.  groupBoots are
+// the bootstrap resolvers of the groups of domain-specific upstreams.
+func (s *Server) prepareUpstreamSettings(
+	boot upstream.Resolver,
+	groupBoots map[string]upstream.Resolver,
+) (err error) {
 	// Load upstreams either from the file, or from the settings
 	var upstreams []string
 	upstreams, err = s.conf.loadUpstreams()
@@ -529,9 +769,19 @@ func (s *Server) prepareUpstreamSettings(boot upstream.Resolver) (err error) {
 		return fmt.Errorf("loading upstreams: %w", err)
 	}
 
-	uc, err := newUpstreamConfig(upstreams, defaultDNS, &upstream.Options{
-		Bootstrap:    boot,
-		Timeout:      s.conf.UpstreamTimeout,
+	err = validateUpstreamRetries(s.conf.UpstreamRetries, s.conf.UpstreamRetryBackoff)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	opts := &upstream.Options{
+		Bootstrap: boot,
+		Timeout: upstreamAttemptTimeout(
+			s.conf.UpstreamTimeout,
+			s.conf.UpstreamRetries,
+			s.conf.UpstreamMode,
+		),
 		HTTPVersions: UpstreamHTTPVersions(s.conf.UseHTTP3Upstreams),
 		PreferIPv6:   s.conf.BootstrapPreferIPv6,
 		// Use a customized set of RootCAs, because Go's default mechanism of
@@ -543,12 +793,86 @@ func (s *Server) prepareUpstreamSettings(boot upstream.Resolver) (err error) {
 		// TODO(a.garipov): Investigate if that's true.
 		RootCAs:      s.conf.TLSv12Roots,
 		CipherSuites: s.conf.TLSCiphers,
-	})
+	}
+
+	uc, err := newUpstreamConfig(upstreams, defaultDNS, opts)
+	if err != nil {
+		return fmt.Errorf("preparing upstream config: %w", err)
+	}
+
+	err = applyGroupBootstraps(uc, upstreams, groupBoots, opts)
+	if err != nil {
+		return fmt.Errorf("preparing upstream config: %w", err)
+	}
+
+	timeouts, err := parseUpstreamTimeouts(s.conf.UpstreamTimeouts)
+	if err != nil {
+		return fmt.Errorf("preparing upstream config: %w", err)
+	}
+
+	totals, err := applyUpstreamTimeouts(
+		uc,
+		upstreams,
+		timeouts,
+		groupBoots,
+		opts,
+		func(d time.Duration) (attempt time.Duration) {
+			return upstreamAttemptTimeout(d, s.conf.UpstreamRetries, s.conf.UpstreamMode)
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("preparing upstream config: %w", err)
+	}
+
+	wrapRetryUpstreams(
+		uc,
+		s.conf.UpstreamMode,
+		s.conf.UpstreamRetries,
+		s.conf.UpstreamRetryBackoff,
+		s.conf.UpstreamTimeout,
+		totals,
+	)
+
+	if s.hijack != nil {
+		s.hijack.wrap(uc)
+	}
+
+	err = s.setupFailover(uc)
 	if err != nil {
 		return fmt.Errorf("preparing upstream config: %w", err)
 	}
 
+	closeIfaceBindings(s.ifaceBindings, s.uncached)
+	s.ifaceBindings, err = newIfaceBindings(
+		s.conf.InterfaceBindings,
+		s.ifaceByName,
+		opts,
+		s.conf.CacheSize,
+		s.conf.EDNSClientSubnet.Enabled,
+		s.uncached,
+	)
+	if err != nil {
+		return fmt.Errorf("preparing interface bindings: %w", err)
+	}
+
+	closeViews(s.views, s.uncached)
+
+	var viewBoots []*upstream.UpstreamResolver
+	s.views, viewBoots, err = newViews(
+		s.conf.Views,
+		s.etcHosts,
+		opts,
+		s.conf.CacheSize,
+		s.conf.EDNSClientSubnet.Enabled,
+		s.uncached,
+	)
+	s.bootResolvers = append(s.bootResolvers, viewBoots...)
+	if err != nil {
+		return fmt.Errorf("preparing views: %w", err)
+	}
+
 	s.conf.UpstreamConfig = uc
+	s.uncached.setDefault(uc)
 
 	return nil
 }
@@ -631,12 +955,37 @@ func (s *Server) prepareInternalDNS() (err error) {
 		return err
 	}
 
-	err = s.prepareUpstreamSettings(s.bootstrap)
+	groupBoots, groupBootResolvers, err := newGroupBootstraps(
+		s.conf.UpstreamGroupBootstrapDNS,
+		s.etcHosts,
+		bootOpts,
+	)
+	s.bootResolvers = append(s.bootResolvers, groupBootResolvers...)
+	if err != nil {
+		return fmt.Errorf("preparing upstream group bootstraps: %w", err)
+	}
+
+	err = s.prepareUpstreamSettings(s.bootstrap, groupBoots)
 	if err != nil {
 		// Don't wrap the error, because it's informative enough as is.
 		return err
 	}
 
+	for _, conf := range s.groupCaches {
+		s.uncached.forget(conf)
+	}
+
+	s.groupCaches, err = newGroupCaches(
+		s.conf.UpstreamConfig,
+		s.conf.UpstreamCaches,
+		s.conf.CacheSize,
+		s.conf.EDNSClientSubnet.Enabled,
+		s.uncached,
+	)
+	if err != nil {
+		return fmt.Errorf("preparing upstream caches: %w", err)
+	}
+
 	s.conf.PrivateRDNSUpstreamConfig, err = s.prepareLocalResolvers()
 	if err != nil {
 		return err
@@ -658,11 +1007,17 @@ func (s *Server) setupFallbackDNS() (uc *proxy.UpstreamConfig, err error) {
 		return nil, nil
 	}
 
+	// Use the same bootstrap as the general upstreams, since the fallback ones
+	// may also be specified with hostnames, including the DNS stamps for
+	// DNS-over-HTTPS and DNS-over-TLS.  DNSCrypt stamps contain the IP
+	// address of the resolver and need no bootstrap.
 	uc, err = proxy.ParseUpstreamsConfig(fallbacks, &upstream.Options{
-		// TODO(s.chzhen):  Investigate if other options are needed.
-		Timeout:    s.conf.UpstreamTimeout,
-		PreferIPv6: s.conf.BootstrapPreferIPv6,
-		// TODO(e.burkov):  Use bootstrap.
+		Bootstrap:    s.bootstrap,
+		Timeout:      s.conf.UpstreamTimeout,
+		HTTPVersions: UpstreamHTTPVersions(s.conf.UseHTTP3Upstreams),
+		PreferIPv6:   s.conf.BootstrapPreferIPv6,
+		RootCAs:      s.conf.TLSv12Roots,
+		CipherSuites: s.conf.TLSCiphers,
 	})
 	if err != nil {
 		// Do not wrap the error because it's informative enough as is.
@@ -722,6 +1077,34 @@ func validateBlockingMode(
 	}
 }
 
+// validateAAAABlockingMode returns an error if the blocking mode for AAAA
+// requests isn't valid.
+func validateAAAABlockingMode(mode filtering.AAAABlockingMode) (err error) {
+	switch mode {
+	case
+		filtering.AAAABlockingModeDefault,
+		filtering.AAAABlockingModeNullIP,
+		filtering.AAAABlockingModeNXDOMAIN,
+		filtering.AAAABlockingModeNODATA:
+		return nil
+	default:
+		return fmt.Errorf("bad blocking mode for aaaa %q", mode)
+	}
+}
+
+// validateSafeBrowsingBlockingMode returns an error if the blocking mode for
+// the requests blocked by safe-browsing and parental control isn't valid.
+func validateSafeBrowsingBlockingMode(mode filtering.SafeBrowsingBlockingMode) (err error) {
+	switch mode {
+	case
+		filtering.SafeBrowsingBlockingModeBlockHost,
+		filtering.SafeBrowsingBlockingModeBlockingMode:
+		return nil
+	default:
+		return fmt.Errorf("bad safe browsing blocking mode %q", mode)
+	}
+}
+
 // prepareInternalProxy initializes the DNS proxy that is used for internal DNS
 // queries, such as public clients PTR resolving and updater hostname resolving.
 func (s *Server) prepareInternalProxy() (err error) {
@@ -779,6 +1162,25 @@ func (s *Server) stopLocked() {
 		logCloserErr(b, "dnsforward: closing bootstrap %s: %s", b.Address())
 	}
 
+	if s.clientRateLimiterDone != nil {
+		close(s.clientRateLimiterDone)
+		s.clientRateLimiterDone = nil
+	}
+
+	if s.sessionsDone != nil {
+		close(s.sessionsDone)
+		s.sessionsDone = nil
+	}
+
+	if s.hijackDone != nil {
+		close(s.hijackDone)
+		s.hijackDone = nil
+	}
+
+	s.conns.reset()
+
+	s.closeUpstreamsWatcher()
+
 	s.isRunning = false
 }
 
@@ -817,6 +1219,35 @@ func (s *Server) proxy() (p *proxy.Proxy) {
 	return s.dnsProxy
 }
 
+// staleResponses returns the current stale cache.  c is nil if serving the
+// expired responses is disabled.
+func (s *Server) staleResponses() (c *staleCache) {
+	s.serverLock.RLock()
+	defer s.serverLock.RUnlock()
+
+	return s.staleCache
+}
+
+// ForgetUpstreamConfig drops the data associated with the closed custom
+// upstream configuration conf, such as the identifier of it in the stale cache.
+// It's safe for concurrent use.
+func (s *Server) ForgetUpstreamConfig(conf *proxy.CustomUpstreamConfig) {
+	if c := s.staleResponses(); c != nil {
+		c.forget(conf)
+	}
+
+	s.uncached.forget(conf)
+}
+
+// failoverAnswers returns the current tracker of the failover upstreams.  t is
+// nil if the upstream mode isn't [UpstreamModeFailover].
+func (s *Server) failoverAnswers() (t *failoverTracker) {
+	s.serverLock.RLock()
+	defer s.serverLock.RUnlock()
+
+	return s.failover
+}
+
 // Reconfigure applies the new configuration to the DNS server.
 //
 // TODO(a.garipov): This whole piece of API is weird and needs to be remade.
@@ -824,6 +1255,12 @@ func (s *Server) Reconfigure(conf *ServerConfig) error {
 	s.serverLock.Lock()
 	defer s.serverLock.Unlock()
 
+	return s.reconfigureLocked(conf)
+}
+
+// reconfigureLocked applies the new configuration to the DNS server without
+// locking.  s.serverLock is expected to be locked.
+func (s *Server) reconfigureLocked(conf *ServerConfig) (err error) {
 	log.Info("dnsforward: starting reconfiguring server")
 	defer log.Info("dnsforward: finished reconfiguring server")
 
@@ -846,7 +1283,7 @@ func (s *Server) Reconfigure(conf *ServerConfig) error {
 
 	// TODO(e.burkov):  It seems an error here brings the server down, which is
 	// not reliable enough.
-	err := s.Prepare(conf)
+	err = s.Prepare(conf)
 	if err != nil {
 		return fmt.Errorf("could not reconfigure the server: %w", err)
 	}
@@ -866,33 +1303,8 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// IsBlockedClient returns true if the client is blocked by the current access
-// settings.
+// IsBlockedClient returns true if the client is blocked by the current global
+// access settings, regardless of the client lists scoped to protocols.
 func (s *Server) IsBlockedClient(ip netip.Addr, clientID string) (blocked bool, rule string) {
-	s.serverLock.RLock()
-	defer s.serverLock.RUnlock()
-
-	blockedByIP := false
-	if ip != (netip.Addr{}) {
-		blockedByIP, rule = s.access.isBlockedIP(ip)
-	}
-
-	allowlistMode := s.access.allowlistMode()
-	blockedByClientID := s.access.isBlockedClientID(clientID)
-
-	// Allow if at least one of the checks allows in allowlist mode, but block
-	// if at least one of the checks blocks in blocklist mode.
-	if allowlistMode && blockedByIP && blockedByClientID {
-		log.Debug("dnsforward: client %v (id %q) is not in access allowlist", ip, clientID)
-
-		// Return now without substituting the empty rule for the
-		// clientID because the rule can't be empty here.
-		return true, rule
-	} else if !allowlistMode && (blockedByIP || blockedByClientID) {
-		log.Debug("dnsforward: client %v (id %q) is in access blocklist", ip, clientID)
-
-		blocked = true
-	}
-
-	return blocked, cmp.Or(rule, clientID)
+	return s.access.Load().isBlockedClient(ip, clientID)
 }
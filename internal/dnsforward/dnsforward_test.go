@@ -28,6 +28,7 @@ import (
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering/safesearch"
 	"github.com/AdguardTeam/dnsproxy/proxy"
 	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/logutil/slogutil"
 	"github.com/AdguardTeam/golibs/netutil"
 	"github.com/AdguardTeam/golibs/testutil"
@@ -94,7 +95,7 @@ func createTestServer(
 	dhcp := &testDHCP{
 		OnEnabled:  func() (ok bool) { return false },
 		OnHostByIP: func(ip netip.Addr) (host string) { return "" },
-		OnIPByHost: func(host string) (ip netip.Addr) { panic("not implemented") },
+		OnIPByHost: func(host string) (ips []netip.Addr) { panic("not implemented") },
 	}
 	s, err = NewServer(DNSCreateParams{
 		DHCPServer:  dhcp,
@@ -396,6 +397,103 @@ func TestServer_Prepare_fallbacks(t *testing.T) {
 	assert.Len(t, s.dnsProxy.Fallbacks.Upstreams, 1)
 }
 
+func TestServer_Prepare_responseTTL(t *testing.T) {
+	testCases := []struct {
+		name         string
+		wantErrMsg   string
+		cacheMinTTL  uint32
+		cacheMaxTTL  uint32
+		minTTL       uint32
+		maxTTL       uint32
+		wantCacheMin uint32
+		wantCacheMax uint32
+	}{{
+		name:         "disabled",
+		wantErrMsg:   "",
+		cacheMinTTL:  10,
+		cacheMaxTTL:  100,
+		minTTL:       0,
+		maxTTL:       0,
+		wantCacheMin: 10,
+		wantCacheMax: 100,
+	}, {
+		name:         "narrower",
+		wantErrMsg:   "",
+		cacheMinTTL:  10,
+		cacheMaxTTL:  100,
+		minTTL:       20,
+		maxTTL:       50,
+		wantCacheMin: 20,
+		wantCacheMax: 50,
+	}, {
+		name:         "wider",
+		wantErrMsg:   "",
+		cacheMinTTL:  10,
+		cacheMaxTTL:  100,
+		minTTL:       5,
+		maxTTL:       500,
+		wantCacheMin: 10,
+		wantCacheMax: 100,
+	}, {
+		name:         "no_cache_max",
+		wantErrMsg:   "",
+		cacheMinTTL:  0,
+		cacheMaxTTL:  0,
+		minTTL:       20,
+		maxTTL:       50,
+		wantCacheMin: 20,
+		wantCacheMax: 50,
+	}, {
+		name:         "cache_min_above_max",
+		wantErrMsg:   "",
+		cacheMinTTL:  100,
+		cacheMaxTTL:  0,
+		minTTL:       0,
+		maxTTL:       50,
+		wantCacheMin: 50,
+		wantCacheMax: 50,
+	}, {
+		name: "bad_bounds",
+		wantErrMsg: "preparing proxy: validating response ttl: " +
+			"response_ttl_min must be less than or equal to response_ttl_max",
+		cacheMinTTL:  0,
+		cacheMaxTTL:  0,
+		minTTL:       100,
+		maxTTL:       50,
+		wantCacheMin: 0,
+		wantCacheMax: 0,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := NewServer(DNSCreateParams{
+				Logger: slogutil.NewDiscardLogger(),
+			})
+			require.NoError(t, err)
+
+			err = s.Prepare(&ServerConfig{
+				Config: Config{
+					UpstreamDNS:      []string{"8.8.8.8"},
+					UpstreamMode:     UpstreamModeLoadBalance,
+					EDNSClientSubnet: &EDNSClientSubnet{Enabled: false},
+					CacheMinTTL:      tc.cacheMinTTL,
+					CacheMaxTTL:      tc.cacheMaxTTL,
+					MinTTL:           tc.minTTL,
+					MaxTTL:           tc.maxTTL,
+				},
+				ServePlainDNS: true,
+			})
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+			if tc.wantErrMsg != "" {
+				return
+			}
+
+			assert.Equal(t, tc.wantCacheMin, s.dnsProxy.CacheMinTTL)
+			assert.Equal(t, tc.wantCacheMax, s.dnsProxy.CacheMaxTTL)
+		})
+	}
+}
+
 func TestServerWithProtectionDisabled(t *testing.T) {
 	s := createTestServer(t, &filtering.Config{
 		BlockingMode: filtering.BlockingModeDefault,
@@ -751,6 +849,119 @@ func TestServerCustomClientUpstream(t *testing.T) {
 	assert.Equal(t, uint32(1), atomic.LoadUint32(&upsCalledCounter))
 }
 
+func TestServer_serveStale(t *testing.T) {
+	const (
+		maxAge = time.Hour
+		host   = "stale.example."
+	)
+
+	forwardConf := ServerConfig{
+		UDPListenAddrs: []*net.UDPAddr{{}},
+		TCPListenAddrs: []*net.TCPAddr{{}},
+		Config: Config{
+			CacheSize:        1024 * 1024,
+			UpstreamMode:     UpstreamModeLoadBalance,
+			EDNSClientSubnet: &EDNSClientSubnet{Enabled: false},
+			ServeStale:       true,
+			ServeStaleMaxAge: timeutil.Duration(maxAge),
+		},
+		ServePlainDNS: true,
+	}
+	s := createTestServer(t, &filtering.Config{
+		BlockingMode: filtering.BlockingModeDefault,
+	}, forwardConf)
+
+	wantIP := net.IP{192, 168, 0, 1}
+
+	upsDown := &atomic.Bool{}
+	ups := aghtest.NewUpstreamMock(func(req *dns.Msg) (resp *dns.Msg, err error) {
+		if upsDown.Load() {
+			return nil, errors.Error("upstream is down")
+		}
+
+		// Use zero TTL so that the response isn't stored in the proxy's cache.
+		return newResp(dns.RcodeSuccess, req, []dns.RR{&dns.A{
+			Hdr: dns.RR_Header{
+				Name:   req.Question[0].Name,
+				Rrtype: dns.TypeA,
+				Class:  dns.ClassINET,
+				Ttl:    0,
+			},
+			A: wantIP,
+		}}), nil
+	})
+	s.dnsProxy.UpstreamConfig = &proxy.UpstreamConfig{
+		Upstreams: []upstream.Upstream{ups},
+	}
+
+	// Shift the clock of the stale cache instead of waiting.
+	nowOffset := &atomic.Int64{}
+	s.staleCache.now = func() (now time.Time) {
+		return time.Now().Add(time.Duration(nowOffset.Load()))
+	}
+
+	startDeferStop(t, s)
+
+	addr := s.dnsProxy.Addr(proxy.ProtoUDP).String()
+
+	req := createTestMessage(host)
+	req.SetEdns0(dns.DefaultMsgSize, false)
+
+	reply, err := dns.Exchange(req, addr)
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeSuccess, reply.Rcode)
+
+	assert.Nil(t, findEDE(reply))
+
+	upsDown.Store(true)
+
+	t.Run("stale", func(t *testing.T) {
+		nowOffset.Store(int64(maxAge - time.Minute))
+
+		reply, err = dns.Exchange(req, addr)
+		require.NoError(t, err)
+		require.Equal(t, dns.RcodeSuccess, reply.Rcode)
+		require.Len(t, reply.Answer, 1)
+
+		a, ok := reply.Answer[0].(*dns.A)
+		require.True(t, ok)
+
+		assert.Equal(t, wantIP, a.A)
+		assert.Equal(t, staleTTL, a.Hdr.Ttl)
+
+		ede := findEDE(reply)
+		require.NotNil(t, ede)
+
+		assert.Equal(t, dns.ExtendedErrorCodeStaleAnswer, ede.InfoCode)
+	})
+
+	t.Run("too_old", func(t *testing.T) {
+		nowOffset.Store(int64(maxAge + time.Minute))
+
+		reply, err = dns.Exchange(req, addr)
+		require.NoError(t, err)
+
+		assert.Equal(t, dns.RcodeServerFailure, reply.Rcode)
+		assert.Empty(t, reply.Answer)
+	})
+}
+
+// findEDE returns the Extended DNS Error option of msg, if any.
+func findEDE(msg *dns.Msg) (ede *dns.EDNS0_EDE) {
+	opt := msg.IsEdns0()
+	if opt == nil {
+		return nil
+	}
+
+	for _, o := range opt.Option {
+		if ede, ok := o.(*dns.EDNS0_EDE); ok {
+			return ede
+		}
+	}
+
+	return nil
+}
+
 // testCNAMEs is a map of names and CNAMEs necessary for the TestUpstream work.
 var testCNAMEs = map[string][]string{
 	"badhost.":               {"NULL.example.org."},
@@ -979,7 +1190,7 @@ func TestBlockedCustomIP(t *testing.T) {
 	dhcp := &testDHCP{
 		OnEnabled:  func() (ok bool) { return false },
 		OnHostByIP: func(_ netip.Addr) (host string) { panic("not implemented") },
-		OnIPByHost: func(_ string) (ip netip.Addr) { panic("not implemented") },
+		OnIPByHost: func(_ string) (ips []netip.Addr) { panic("not implemented") },
 	}
 	s, err := NewServer(DNSCreateParams{
 		DHCPServer:  dhcp,
@@ -1042,6 +1253,126 @@ func TestBlockedCustomIP(t *testing.T) {
 	assert.Equal(t, "::1", a6.AAAA.String())
 }
 
+func TestBlockedCustomIP_aaaaBlockingMode(t *testing.T) {
+	filters := []filtering.Filter{{
+		ID:   0,
+		Data: []byte("||NULL.example.org^\n"),
+	}}
+
+	f, err := filtering.New(&filtering.Config{
+		ProtectionEnabled: true,
+		BlockingMode:      filtering.BlockingModeCustomIP,
+		BlockingIPv4:      netip.AddrFrom4([4]byte{0, 0, 0, 1}),
+		BlockingIPv6:      netip.MustParseAddr("::1"),
+	}, filters)
+	require.NoError(t, err)
+
+	f.SetEnabled(true)
+
+	s, err := NewServer(DNSCreateParams{
+		DHCPServer: &testDHCP{
+			OnEnabled:  func() (ok bool) { return false },
+			OnHostByIP: func(_ netip.Addr) (host string) { panic("not implemented") },
+			OnIPByHost: func(_ string) (ips []netip.Addr) { panic("not implemented") },
+		},
+		DNSFilter:   f,
+		PrivateNets: netutil.SubnetSetFunc(netutil.IsLocallyServed),
+		Logger:      slogutil.NewDiscardLogger(),
+	})
+	require.NoError(t, err)
+
+	err = s.Prepare(&ServerConfig{
+		UDPListenAddrs: []*net.UDPAddr{{}},
+		TCPListenAddrs: []*net.TCPAddr{{}},
+		Config: Config{
+			UpstreamDNS:  []string{"8.8.8.8:53", "8.8.4.4:53"},
+			UpstreamMode: UpstreamModeLoadBalance,
+			EDNSClientSubnet: &EDNSClientSubnet{
+				Enabled: false,
+			},
+		},
+		ServePlainDNS: true,
+	})
+	require.NoError(t, err)
+
+	startDeferStop(t, s)
+
+	addr := s.dnsProxy.Addr(proxy.ProtoUDP).String()
+
+	testCases := []struct {
+		name      string
+		mode      filtering.AAAABlockingMode
+		wantAAAA  []string
+		wantRCode int
+		wantSOA   bool
+	}{{
+		name:      "default",
+		mode:      filtering.AAAABlockingModeDefault,
+		wantAAAA:  []string{"::1"},
+		wantRCode: dns.RcodeSuccess,
+		wantSOA:   false,
+	}, {
+		name:      "null_ip",
+		mode:      filtering.AAAABlockingModeNullIP,
+		wantAAAA:  []string{"::"},
+		wantRCode: dns.RcodeSuccess,
+		wantSOA:   false,
+	}, {
+		name:      "nxdomain",
+		mode:      filtering.AAAABlockingModeNXDOMAIN,
+		wantAAAA:  nil,
+		wantRCode: dns.RcodeNameError,
+		wantSOA:   true,
+	}, {
+		name:      "nodata",
+		mode:      filtering.AAAABlockingModeNODATA,
+		wantAAAA:  nil,
+		wantRCode: dns.RcodeSuccess,
+		wantSOA:   true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f.SetAAAABlockingMode(tc.mode)
+
+			req := createTestMessageWithType("NULL.example.org.", dns.TypeA)
+			reply, exchErr := dns.Exchange(req, addr)
+			require.NoError(t, exchErr)
+
+			require.Equal(t, dns.RcodeSuccess, reply.Rcode)
+			require.Len(t, reply.Answer, 1)
+
+			a, ok := reply.Answer[0].(*dns.A)
+			require.True(t, ok)
+
+			assert.True(t, net.IP{0, 0, 0, 1}.Equal(a.A))
+
+			req = createTestMessageWithType("NULL.example.org.", dns.TypeAAAA)
+			reply, exchErr = dns.Exchange(req, addr)
+			require.NoError(t, exchErr)
+
+			assert.Equal(t, tc.wantRCode, reply.Rcode)
+
+			var gotAAAA []string
+			for _, rr := range reply.Answer {
+				a6, isAAAA := rr.(*dns.AAAA)
+				require.True(t, isAAAA)
+
+				gotAAAA = append(gotAAAA, a6.AAAA.String())
+			}
+
+			assert.Equal(t, tc.wantAAAA, gotAAAA)
+
+			if tc.wantSOA {
+				require.Len(t, reply.Ns, 1)
+				assert.IsType(t, &dns.SOA{}, reply.Ns[0])
+			} else {
+				assert.Empty(t, reply.Ns)
+			}
+		})
+	}
+}
+
 func TestBlockedByHosts(t *testing.T) {
 	forwardConf := ServerConfig{
 		UDPListenAddrs: []*net.UDPAddr{{}},
@@ -1120,6 +1451,134 @@ func TestBlockedBySafeBrowsing(t *testing.T) {
 	assertResponse(t, reply, ans4)
 }
 
+func TestBlockedBySafeBrowsing_blockingMode(t *testing.T) {
+	const (
+		sbHost       = "wmconvirus.narod.ru"
+		parentalHost = "pornhub.com"
+		cacheTime    = 10 * time.Minute
+		cacheSize    = 10000
+	)
+
+	var (
+		sbBlockIP       = netip.MustParseAddr("192.0.2.1")
+		parentalBlockIP = netip.MustParseAddr("192.0.2.2")
+		customIPv4      = netip.MustParseAddr("192.0.2.3")
+		customIPv6      = netip.MustParseAddr("2001:db8::3")
+	)
+
+	filterConf := &filtering.Config{
+		BlockingMode:        filtering.BlockingModeDefault,
+		ProtectionEnabled:   true,
+		SafeBrowsingEnabled: true,
+		SafeBrowsingChecker: hashprefix.New(&hashprefix.Config{
+			CacheTime: cacheTime,
+			CacheSize: cacheSize,
+			Upstream:  aghtest.NewBlockUpstream(sbHost, true),
+		}),
+		SafeBrowsingBlockHost: sbBlockIP.String(),
+		ParentalEnabled:       true,
+		ParentalControlChecker: hashprefix.New(&hashprefix.Config{
+			CacheTime: cacheTime,
+			CacheSize: cacheSize,
+			Upstream:  aghtest.NewBlockUpstream(parentalHost, true),
+		}),
+		ParentalBlockHost: parentalBlockIP.String(),
+	}
+	forwardConf := ServerConfig{
+		UDPListenAddrs: []*net.UDPAddr{{}},
+		TCPListenAddrs: []*net.TCPAddr{{}},
+		Config: Config{
+			UpstreamMode: UpstreamModeLoadBalance,
+			EDNSClientSubnet: &EDNSClientSubnet{
+				Enabled: false,
+			},
+		},
+		ServePlainDNS: true,
+	}
+	s := createTestServer(t, filterConf, forwardConf)
+	startDeferStop(t, s)
+	addr := s.dnsProxy.Addr(proxy.ProtoUDP).String()
+
+	testCases := []struct {
+		name         string
+		sbMode       filtering.SafeBrowsingBlockingMode
+		mode         filtering.BlockingMode
+		wantSBIP     netip.Addr
+		wantParIP    netip.Addr
+		wantRcode    int
+		wantNoAnswer bool
+	}{{
+		name:         "block_host",
+		sbMode:       filtering.SafeBrowsingBlockingModeBlockHost,
+		mode:         filtering.BlockingModeNXDOMAIN,
+		wantSBIP:     sbBlockIP,
+		wantParIP:    parentalBlockIP,
+		wantRcode:    dns.RcodeSuccess,
+		wantNoAnswer: false,
+	}, {
+		name:         "default",
+		sbMode:       filtering.SafeBrowsingBlockingModeBlockingMode,
+		mode:         filtering.BlockingModeDefault,
+		wantSBIP:     netip.IPv4Unspecified(),
+		wantParIP:    netip.IPv4Unspecified(),
+		wantRcode:    dns.RcodeSuccess,
+		wantNoAnswer: false,
+	}, {
+		name:         "null_ip",
+		sbMode:       filtering.SafeBrowsingBlockingModeBlockingMode,
+		mode:         filtering.BlockingModeNullIP,
+		wantSBIP:     netip.IPv4Unspecified(),
+		wantParIP:    netip.IPv4Unspecified(),
+		wantRcode:    dns.RcodeSuccess,
+		wantNoAnswer: false,
+	}, {
+		name:         "custom_ip",
+		sbMode:       filtering.SafeBrowsingBlockingModeBlockingMode,
+		mode:         filtering.BlockingModeCustomIP,
+		wantSBIP:     customIPv4,
+		wantParIP:    customIPv4,
+		wantRcode:    dns.RcodeSuccess,
+		wantNoAnswer: false,
+	}, {
+		name:         "nxdomain",
+		sbMode:       filtering.SafeBrowsingBlockingModeBlockingMode,
+		mode:         filtering.BlockingModeNXDOMAIN,
+		wantRcode:    dns.RcodeNameError,
+		wantNoAnswer: true,
+	}, {
+		name:         "refused",
+		sbMode:       filtering.SafeBrowsingBlockingModeBlockingMode,
+		mode:         filtering.BlockingModeREFUSED,
+		wantRcode:    dns.RcodeRefused,
+		wantNoAnswer: true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s.dnsFilter.SetSafeBrowsingBlockingMode(tc.sbMode)
+			s.dnsFilter.SetBlockingMode(tc.mode, customIPv4, customIPv6)
+
+			hosts := map[string]netip.Addr{
+				sbHost:       tc.wantSBIP,
+				parentalHost: tc.wantParIP,
+			}
+
+			for host, wantIP := range hosts {
+				reply, err := dns.Exchange(createTestMessage(host+"."), addr)
+				require.NoError(t, err)
+
+				assert.Equal(t, tc.wantRcode, reply.Rcode)
+
+				if tc.wantNoAnswer {
+					assert.Empty(t, reply.Answer)
+				} else {
+					assertResponse(t, reply, wantIP)
+				}
+			}
+		})
+	}
+}
+
 func TestRewrite(t *testing.T) {
 	c := &filtering.Config{
 		BlockingMode: filtering.BlockingModeDefault,
@@ -1135,6 +1594,11 @@ func TestRewrite(t *testing.T) {
 			Domain: "my.alias.example.org",
 			Answer: "example.org",
 			Type:   dns.TypeCNAME,
+		}, {
+			Domain:         "local.example",
+			Answer:         "1.2.3.5",
+			Type:           dns.TypeA,
+			LocalAuthority: true,
 		}},
 	}
 	f, err := filtering.New(c, nil)
@@ -1145,7 +1609,7 @@ func TestRewrite(t *testing.T) {
 	dhcp := &testDHCP{
 		OnEnabled:  func() (ok bool) { return false },
 		OnHostByIP: func(ip netip.Addr) (host string) { panic("not implemented") },
-		OnIPByHost: func(host string) (ip netip.Addr) { panic("not implemented") },
+		OnIPByHost: func(host string) (ips []netip.Addr) { panic("not implemented") },
 	}
 	s, err := NewServer(DNSCreateParams{
 		DHCPServer:  dhcp,
@@ -1229,6 +1693,117 @@ func TestRewrite(t *testing.T) {
 
 		t.Run(fmt.Sprintf("protection_is_%t", val), subTestFunc)
 	}
+
+	t.Run("local_authority", func(t *testing.T) {
+		// The subdomain of a rewritten domain without local authority is
+		// resolved by upstream.
+		req := createTestMessageWithType("_dnslink.test.com.", dns.TypeTXT)
+		reply, eerr := dns.Exchange(req, addr.String())
+		require.NoError(t, eerr)
+
+		assert.Equal(t, dns.RcodeNameError, reply.Rcode)
+
+		// The subdomain of a rewritten domain with local authority is answered
+		// locally instead of with NXDOMAIN from upstream.
+		req = createTestMessageWithType("_dnslink.local.example.", dns.TypeTXT)
+		reply, eerr = dns.Exchange(req, addr.String())
+		require.NoError(t, eerr)
+
+		assert.Equal(t, dns.RcodeSuccess, reply.Rcode)
+		assert.Empty(t, reply.Answer)
+	})
+}
+
+func TestRewrite_flatten(t *testing.T) {
+	const rewriteTTL = 30
+
+	c := &filtering.Config{
+		BlockingMode:       filtering.BlockingModeDefault,
+		BlockedResponseTTL: rewriteTTL,
+		Rewrites: []*filtering.LegacyRewrite{{
+			Domain: "my.alias.example.org",
+			Answer: "example.org",
+			Type:   dns.TypeCNAME,
+		}, {
+			Domain: "loop1.example",
+			Answer: "loop2.example",
+			Type:   dns.TypeCNAME,
+		}, {
+			Domain: "loop2.example",
+			Answer: "loop1.example",
+			Type:   dns.TypeCNAME,
+		}},
+	}
+	f, err := filtering.New(c, nil)
+	require.NoError(t, err)
+
+	f.SetEnabled(true)
+
+	dhcp := &testDHCP{
+		OnEnabled:  func() (ok bool) { return false },
+		OnHostByIP: func(ip netip.Addr) (host string) { panic("not implemented") },
+		OnIPByHost: func(host string) (ips []netip.Addr) { panic("not implemented") },
+	}
+	s, err := NewServer(DNSCreateParams{
+		DHCPServer:  dhcp,
+		DNSFilter:   f,
+		PrivateNets: netutil.SubnetSetFunc(netutil.IsLocallyServed),
+		Logger:      slogutil.NewDiscardLogger(),
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, s.Prepare(&ServerConfig{
+		UDPListenAddrs: []*net.UDPAddr{{}},
+		TCPListenAddrs: []*net.TCPAddr{{}},
+		Config: Config{
+			UpstreamDNS:            []string{"8.8.8.8:53"},
+			UpstreamMode:           UpstreamModeLoadBalance,
+			FlattenRewrittenCNAMEs: true,
+			EDNSClientSubnet: &EDNSClientSubnet{
+				Enabled: false,
+			},
+		},
+		ServePlainDNS: true,
+	}))
+
+	ups := aghtest.NewUpstreamMock(func(req *dns.Msg) (resp *dns.Msg, err error) {
+		return cmp.Or(
+			aghtest.MatchedResponse(req, dns.TypeA, "example.org", "4.3.2.1"),
+			new(dns.Msg).SetRcode(req, dns.RcodeNameError),
+		), nil
+	})
+	s.conf.UpstreamConfig.Upstreams = []upstream.Upstream{ups}
+	startDeferStop(t, s)
+
+	addr := s.dnsProxy.Addr(proxy.ProtoUDP)
+
+	t.Run("flattened", func(t *testing.T) {
+		req := createTestMessageWithType("my.alias.example.org.", dns.TypeA)
+		reply, eerr := dns.Exchange(req, addr.String())
+		require.NoError(t, eerr)
+
+		require.Len(t, reply.Question, 1)
+
+		assert.Equal(t, "my.alias.example.org.", reply.Question[0].Name)
+
+		require.Len(t, reply.Answer, 1)
+
+		a := testutil.RequireTypeAssert[*dns.A](t, reply.Answer[0])
+		assert.Equal(t, "my.alias.example.org.", a.Hdr.Name)
+		assert.True(t, net.IP{4, 3, 2, 1}.Equal(a.A))
+
+		// The TTL of the rewrite is less than the one of the resolved record.
+		assert.Equal(t, uint32(rewriteTTL), a.Hdr.Ttl)
+	})
+
+	t.Run("loop", func(t *testing.T) {
+		req := createTestMessageWithType("loop1.example.", dns.TypeA)
+		reply, eerr := dns.Exchange(req, addr.String())
+		require.NoError(t, eerr)
+
+		assert.Equal(t, dns.RcodeNameError, reply.Rcode)
+		assert.Empty(t, reply.Answer)
+	})
 }
 
 func publicKey(priv any) any {
@@ -1247,7 +1822,7 @@ func publicKey(priv any) any {
 // testDHCP is a mock implementation of the [DHCP] interface.
 type testDHCP struct {
 	OnHostByIP func(ip netip.Addr) (host string)
-	OnIPByHost func(host string) (ip netip.Addr)
+	OnIPByHost func(host string) (ips []netip.Addr)
 	OnEnabled  func() (ok bool)
 }
 
@@ -1258,7 +1833,7 @@ var _ DHCP = (*testDHCP)(nil)
 func (d *testDHCP) HostByIP(ip netip.Addr) (host string) { return d.OnHostByIP(ip) }
 
 // IPByHost implements the [DHCP] interface for *testDHCP.
-func (d *testDHCP) IPByHost(host string) (ip netip.Addr) { return d.OnIPByHost(host) }
+func (d *testDHCP) IPByHost(host string) (ips []netip.Addr) { return d.OnIPByHost(host) }
 
 // IsClientHost implements the [DHCP] interface for *testDHCP.
 func (d *testDHCP) Enabled() (ok bool) { return d.OnEnabled() }
@@ -1275,7 +1850,7 @@ func TestPTRResponseFromDHCPLeases(t *testing.T) {
 		DNSFilter: flt,
 		DHCPServer: &testDHCP{
 			OnEnabled:  func() (ok bool) { return true },
-			OnIPByHost: func(host string) (ip netip.Addr) { panic("not implemented") },
+			OnIPByHost: func(host string) (ips []netip.Addr) { panic("not implemented") },
 			OnHostByIP: func(ip netip.Addr) (host string) {
 				return "myhost"
 			},
@@ -1316,6 +1891,248 @@ func TestPTRResponseFromDHCPLeases(t *testing.T) {
 	assert.Equal(t, dns.Fqdn("myhost."+localDomain), ptr.Ptr)
 }
 
+// startDHCPLeasesTestServer starts a server with the local domain "lan", which
+// uses dhcp, and returns its UDP address.
+func startDHCPLeasesTestServer(t *testing.T, dhcp *testDHCP) (addr string) {
+	t.Helper()
+
+	flt, err := filtering.New(&filtering.Config{
+		BlockingMode: filtering.BlockingModeDefault,
+	}, nil)
+	require.NoError(t, err)
+
+	s, err := NewServer(DNSCreateParams{
+		DNSFilter:   flt,
+		DHCPServer:  dhcp,
+		PrivateNets: netutil.SubnetSetFunc(netutil.IsLocallyServed),
+		Logger:      slogutil.NewDiscardLogger(),
+		LocalDomain: "lan",
+	})
+	require.NoError(t, err)
+
+	s.conf.UDPListenAddrs = []*net.UDPAddr{{}}
+	s.conf.TCPListenAddrs = []*net.TCPAddr{{}}
+	s.conf.UpstreamDNS = []string{"127.0.0.1:53"}
+	s.conf.Config.EDNSClientSubnet = &EDNSClientSubnet{Enabled: false}
+	s.conf.Config.UpstreamMode = UpstreamModeLoadBalance
+
+	err = s.Prepare(&s.conf)
+	require.NoError(t, err)
+
+	startDeferStop(t, s)
+
+	return s.dnsProxy.Addr(proxy.ProtoUDP).String()
+}
+
+func TestPTRResponseFromDHCPLeases_ipv6(t *testing.T) {
+	leasedIP := netip.MustParseAddr("fd00::1234")
+
+	addr := startDHCPLeasesTestServer(t, &testDHCP{
+		OnEnabled:  func() (ok bool) { return true },
+		OnIPByHost: func(host string) (ips []netip.Addr) { panic("not implemented") },
+		OnHostByIP: func(ip netip.Addr) (host string) {
+			if ip == leasedIP {
+				return "myhost"
+			}
+
+			return ""
+		},
+	})
+
+	arpa, err := netutil.IPToReversedAddr(leasedIP.AsSlice())
+	require.NoError(t, err)
+
+	req := createTestMessageWithType(dns.Fqdn(arpa), dns.TypePTR)
+
+	resp, err := dns.Exchange(req, addr)
+	require.NoErrorf(t, err, "%s", addr)
+
+	require.Len(t, resp.Answer, 1)
+
+	ans := resp.Answer[0]
+	assert.Equal(t, dns.TypePTR, ans.Header().Rrtype)
+	assert.Equal(t, dns.Fqdn(arpa), ans.Header().Name)
+
+	ptr := testutil.RequireTypeAssert[*dns.PTR](t, ans)
+
+	assert.Equal(t, "myhost.lan.", ptr.Ptr)
+}
+
+func TestResponseFromDHCPLeases_bothFamilies(t *testing.T) {
+	leasedIPv4 := netip.MustParseAddr("192.168.12.34")
+	leasedIPv6 := netip.MustParseAddr("fd00::1234")
+
+	addr := startDHCPLeasesTestServer(t, &testDHCP{
+		OnEnabled: func() (ok bool) { return true },
+		OnIPByHost: func(host string) (ips []netip.Addr) {
+			if host == "myhost" {
+				return []netip.Addr{leasedIPv4, leasedIPv6}
+			}
+
+			return nil
+		},
+		OnHostByIP: func(ip netip.Addr) (host string) { panic("not implemented") },
+	})
+
+	testCases := []struct {
+		want netip.Addr
+		name string
+		qt   uint16
+	}{{
+		want: leasedIPv4,
+		name: "a",
+		qt:   dns.TypeA,
+	}, {
+		want: leasedIPv6,
+		name: "aaaa",
+		qt:   dns.TypeAAAA,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := createTestMessageWithType("myhost.lan.", tc.qt)
+
+			resp, err := dns.Exchange(req, addr)
+			require.NoErrorf(t, err, "%s", addr)
+
+			require.Len(t, resp.Answer, 1)
+
+			ans := resp.Answer[0]
+			assert.Equal(t, tc.qt, ans.Header().Rrtype)
+
+			var ip net.IP
+			switch rr := ans.(type) {
+			case *dns.A:
+				ip = rr.A
+			case *dns.AAAA:
+				ip = rr.AAAA
+			default:
+				t.Fatalf("unexpected answer %T", rr)
+			}
+
+			got, ok := netip.AddrFromSlice(ip)
+			require.True(t, ok)
+
+			assert.Equal(t, tc.want, got.Unmap())
+		})
+	}
+}
+
+func TestPTRResponseFromDHCPLeases_authoritative(t *testing.T) {
+	const (
+		localDomain = "lan"
+		leasedARPA  = "34.12.168.192.in-addr.arpa."
+		unknownARPA = "35.12.168.192.in-addr.arpa."
+	)
+
+	var upsReqNum atomic.Uint32
+	upsHdlr := dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		upsReqNum.Add(1)
+
+		resp := (&dns.Msg{}).SetReply(req)
+		resp.Answer = []dns.RR{&dns.PTR{
+			Hdr: dns.RR_Header{
+				Name:   req.Question[0].Name,
+				Rrtype: dns.TypePTR,
+				Class:  dns.ClassINET,
+				Ttl:    60,
+			},
+			Ptr: "upstream.example.",
+		}}
+
+		require.NoError(testutil.PanicT{}, w.WriteMsg(resp))
+	})
+	upsAddr := aghtest.StartLocalhostUpstream(t, upsHdlr).String()
+
+	leasedIP := netip.MustParseAddr("192.168.12.34")
+
+	newServer := func(t *testing.T, authoritative bool) (addr string) {
+		t.Helper()
+
+		flt, err := filtering.New(&filtering.Config{
+			BlockingMode: filtering.BlockingModeDefault,
+		}, nil)
+		require.NoError(t, err)
+
+		s, err := NewServer(DNSCreateParams{
+			DNSFilter: flt,
+			DHCPServer: &testDHCP{
+				OnEnabled:  func() (ok bool) { return true },
+				OnIPByHost: func(host string) (ips []netip.Addr) { panic("not implemented") },
+				OnHostByIP: func(ip netip.Addr) (host string) {
+					if ip == leasedIP {
+						return "myhost"
+					}
+
+					return ""
+				},
+			},
+			PrivateNets: netutil.SubnetSetFunc(netutil.IsLocallyServed),
+			Logger:      slogutil.NewDiscardLogger(),
+			LocalDomain: localDomain,
+		})
+		require.NoError(t, err)
+
+		s.conf.UDPListenAddrs = []*net.UDPAddr{{}}
+		s.conf.TCPListenAddrs = []*net.TCPAddr{{}}
+		s.conf.UpstreamDNS = []string{upsAddr}
+		s.conf.LocalPTRResolvers = []string{upsAddr}
+		s.conf.UsePrivateRDNS = true
+		s.conf.LocalPTRAuthoritative = authoritative
+		s.conf.Config.EDNSClientSubnet = &EDNSClientSubnet{Enabled: false}
+		s.conf.Config.UpstreamMode = UpstreamModeLoadBalance
+
+		err = s.Prepare(&s.conf)
+		require.NoError(t, err)
+
+		startDeferStop(t, s)
+
+		return s.dnsProxy.Addr(proxy.ProtoUDP).String()
+	}
+
+	t.Run("authoritative", func(t *testing.T) {
+		upsReqNum.Store(0)
+		addr := newServer(t, true)
+
+		resp, err := dns.Exchange(createTestMessageWithType(leasedARPA, dns.TypePTR), addr)
+		require.NoError(t, err)
+		require.Len(t, resp.Answer, 1)
+
+		assert.True(t, resp.Authoritative)
+
+		ptr := testutil.RequireTypeAssert[*dns.PTR](t, resp.Answer[0])
+		assert.Equal(t, dns.Fqdn("myhost."+localDomain), ptr.Ptr)
+
+		resp, err = dns.Exchange(createTestMessageWithType(unknownARPA, dns.TypePTR), addr)
+		require.NoError(t, err)
+
+		assert.True(t, resp.Authoritative)
+		assert.Equal(t, dns.RcodeNameError, resp.Rcode)
+
+		assert.Zero(t, upsReqNum.Load())
+	})
+
+	t.Run("not_authoritative", func(t *testing.T) {
+		upsReqNum.Store(0)
+		addr := newServer(t, false)
+
+		resp, err := dns.Exchange(createTestMessageWithType(leasedARPA, dns.TypePTR), addr)
+		require.NoError(t, err)
+		require.Len(t, resp.Answer, 1)
+
+		assert.False(t, resp.Authoritative)
+
+		resp, err = dns.Exchange(createTestMessageWithType(unknownARPA, dns.TypePTR), addr)
+		require.NoError(t, err)
+		require.Len(t, resp.Answer, 1)
+
+		ptr := testutil.RequireTypeAssert[*dns.PTR](t, resp.Answer[0])
+		assert.Equal(t, "upstream.example.", ptr.Ptr)
+
+		assert.Equal(t, uint32(1), upsReqNum.Load())
+	})
+}
+
 func TestPTRResponseFromHosts(t *testing.T) {
 	// Prepare test hosts file.
 
@@ -1330,7 +2147,7 @@ func TestPTRResponseFromHosts(t *testing.T) {
 
 	dhcp := &testDHCP{
 		OnEnabled:  func() (ok bool) { return false },
-		OnIPByHost: func(host string) (ip netip.Addr) { panic("not implemented") },
+		OnIPByHost: func(host string) (ips []netip.Addr) { panic("not implemented") },
 		OnHostByIP: func(ip netip.Addr) (host string) { return "" },
 	}
 
@@ -1676,3 +2493,128 @@ func TestServer_Exchange(t *testing.T) {
 		assert.Empty(t, host)
 	})
 }
+
+func TestServer_dhcpPrecedence(t *testing.T) {
+	const (
+		dhcpHost = "myhost"
+		upsHost  = "router.host"
+		unknown  = "unknown"
+	)
+
+	var (
+		leasedIP   = netip.MustParseAddr("192.168.1.2")
+		unleasedIP = netip.MustParseAddr("192.168.1.3")
+		upsIP      = netip.MustParseAddr("192.168.1.100")
+	)
+
+	leasedRev, err := netutil.IPToReversedAddr(leasedIP.AsSlice())
+	require.NoError(t, err)
+
+	unleasedRev, err := netutil.IPToReversedAddr(unleasedIP.AsSlice())
+	require.NoError(t, err)
+
+	// The upstream knows all the hosts and addresses, including the ones
+	// leased by the DHCP server.
+	upsHdlr := dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		resp := cmp.Or(
+			aghtest.MatchedResponse(req, dns.TypePTR, leasedRev, dns.Fqdn(upsHost)),
+			aghtest.MatchedResponse(req, dns.TypePTR, unleasedRev, dns.Fqdn(upsHost)),
+			aghtest.MatchedResponse(req, dns.TypeA, dhcpHost+".lan", upsIP.String()),
+			aghtest.MatchedResponse(req, dns.TypeA, unknown+".lan", upsIP.String()),
+			new(dns.Msg).SetRcode(req, dns.RcodeNameError),
+		)
+
+		require.NoError(testutil.PanicT{}, w.WriteMsg(resp))
+	})
+	upsAddr := aghtest.StartLocalhostUpstream(t, upsHdlr).String()
+
+	srv := createTestServer(t, &filtering.Config{
+		BlockingMode: filtering.BlockingModeDefault,
+	}, ServerConfig{
+		UDPListenAddrs: []*net.UDPAddr{{}},
+		TCPListenAddrs: []*net.TCPAddr{{}},
+		Config: Config{
+			UpstreamDNS:      []string{upsAddr},
+			UpstreamMode:     UpstreamModeLoadBalance,
+			EDNSClientSubnet: &EDNSClientSubnet{Enabled: false},
+		},
+		LocalPTRResolvers: []string{upsAddr},
+		UsePrivateRDNS:    true,
+		ServePlainDNS:     true,
+	})
+	srv.dhcpServer = &testDHCP{
+		OnEnabled: func() (ok bool) { return true },
+		OnHostByIP: func(ip netip.Addr) (host string) {
+			if ip == leasedIP {
+				return dhcpHost
+			}
+
+			return ""
+		},
+		OnIPByHost: func(host string) (ips []netip.Addr) {
+			if host == dhcpHost {
+				return []netip.Addr{leasedIP}
+			}
+
+			return nil
+		},
+	}
+	startDeferStop(t, srv)
+
+	t.Run("exchange", func(t *testing.T) {
+		host, _, eerr := srv.Exchange(leasedIP)
+		require.NoError(t, eerr)
+
+		assert.Equal(t, dhcpHost+".lan", host)
+
+		host, _, eerr = srv.Exchange(unleasedIP)
+		require.NoError(t, eerr)
+
+		assert.Equal(t, upsHost, host)
+	})
+
+	t.Run("resolve", func(t *testing.T) {
+		ctx := testutil.ContextWithTimeout(t, testTimeout)
+
+		addrs, rerr := srv.Resolve(ctx, "ip4", dhcpHost+".lan")
+		require.NoError(t, rerr)
+
+		assert.Equal(t, []netip.Addr{leasedIP}, addrs)
+
+		addrs, rerr = srv.Resolve(ctx, "ip4", unknown+".lan")
+		require.NoError(t, rerr)
+
+		assert.Equal(t, []netip.Addr{upsIP}, addrs)
+	})
+
+	t.Run("ptr_query", func(t *testing.T) {
+		addr := srv.dnsProxy.Addr(proxy.ProtoUDP).String()
+
+		testCases := []struct {
+			name    string
+			rev     string
+			wantPTR string
+		}{{
+			name:    "both_sources",
+			rev:     leasedRev,
+			wantPTR: dns.Fqdn(dhcpHost + ".lan"),
+		}, {
+			name:    "upstream_only",
+			rev:     unleasedRev,
+			wantPTR: dns.Fqdn(upsHost),
+		}}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				req := createTestMessageWithType(dns.Fqdn(tc.rev), dns.TypePTR)
+
+				resp, eerr := dns.Exchange(req, addr)
+				require.NoError(t, eerr)
+				require.Len(t, resp.Answer, 1)
+
+				ptr := testutil.RequireTypeAssert[*dns.PTR](t, resp.Answer[0])
+				assert.Equal(t, tc.wantPTR, ptr.Ptr)
+			})
+		}
+	})
+}
@@ -0,0 +1,94 @@
+package dnsforward
+
+import (
+	"net"
+	"net/netip"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// Maximum prefix lengths of the EDNS Client Subnet received from the trusted
+// proxies, which is sent to the upstreams.  They are the same as the ones
+// dnsproxy uses for the subnets it sets itself.
+const (
+	ecsMaxPrefixLenIPv4 = 24
+	ecsMaxPrefixLenIPv6 = 56
+)
+
+// ecsOption returns the EDNS Client Subnet option of req, if any.
+func ecsOption(req *dns.Msg) (opt *dns.EDNS0_SUBNET) {
+	o := req.IsEdns0()
+	if o == nil {
+		return nil
+	}
+
+	for _, e := range o.Option {
+		if ecs, ok := e.(*dns.EDNS0_SUBNET); ok {
+			return ecs
+		}
+	}
+
+	return nil
+}
+
+// limitECS truncates the subnet of opt to the maximum prefix length for its
+// family and returns the resulting network.
+func limitECS(opt *dns.EDNS0_SUBNET, addr netip.Addr) (n *net.IPNet) {
+	maxLen, family := uint8(ecsMaxPrefixLenIPv6), uint16(2)
+	if addr.Is4() {
+		maxLen, family = ecsMaxPrefixLenIPv4, 1
+	}
+
+	opt.Family = family
+	opt.SourceNetmask = min(opt.SourceNetmask, maxLen)
+	opt.SourceScope = 0
+
+	// The prefix length is valid, since it's not greater than the length of
+	// the address.
+	pref, _ := addr.Prefix(int(opt.SourceNetmask))
+	opt.Address = pref.Addr().AsSlice()
+
+	return &net.IPNet{
+		IP:   opt.Address,
+		Mask: net.CIDRMask(pref.Bits(), addr.BitLen()),
+	}
+}
+
+// processTrustedECS handles the EDNS Client Subnet option of the request
+// received from a trusted proxy, see [EDNSClientSubnet.TrustedProxies].  The
+// subnet is limited and used for the upstreams and, if configured, for matching
+// the client.  The option of a request from any other address is left
+// untouched.
+func (s *Server) processTrustedECS(dctx *dnsContext) {
+	ecsConf := s.conf.EDNSClientSubnet
+	if len(s.ecsTrustedProxies) == 0 || ecsConf == nil {
+		return
+	}
+
+	pctx := dctx.proxyCtx
+	opt := ecsOption(pctx.Req)
+	if opt == nil {
+		return
+	}
+
+	if !s.ecsTrustedProxies.Contains(pctx.Addr.Addr()) {
+		return
+	}
+
+	addr, ok := netip.AddrFromSlice(opt.Address)
+	if !ok || opt.SourceNetmask == 0 {
+		return
+	}
+
+	addr = addr.Unmap()
+	if ecsConf.UseForClients {
+		dctx.ecsClientAddr = addr
+	}
+
+	if ecsConf.Enabled {
+		pctx.ReqECS = limitECS(opt, addr)
+
+		log.Debug("dnsforward: using ecs %s from trusted %s", pctx.ReqECS, pctx.Addr)
+	}
+}
@@ -0,0 +1,143 @@
+package dnsforward
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestECSRequest returns a new request with the EDNS Client Subnet option
+// for subnet, if it's valid.
+func newTestECSRequest(subnet netip.Prefix) (req *dns.Msg) {
+	req = createTestMessage("example.com.")
+	if !subnet.IsValid() {
+		return req
+	}
+
+	family := uint16(2)
+	if subnet.Addr().Is4() {
+		family = 1
+	}
+
+	req.SetEdns0(dns.DefaultMsgSize, false)
+	opt := req.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        family,
+		SourceNetmask: uint8(subnet.Bits()),
+		Address:       subnet.Addr().AsSlice(),
+	})
+
+	return req
+}
+
+func TestServer_processTrustedECS(t *testing.T) {
+	var (
+		trustedAddr   = netip.MustParseAddrPort("192.0.2.1:53")
+		untrustedAddr = netip.MustParseAddrPort("198.51.100.1:53")
+	)
+
+	s := &Server{
+		conf: ServerConfig{
+			Config: Config{
+				EDNSClientSubnet: &EDNSClientSubnet{
+					Enabled:       true,
+					UseForClients: true,
+				},
+			},
+		},
+		ecsTrustedProxies: netutil.SliceSubnetSet{netip.MustParsePrefix("192.0.2.0/24")},
+	}
+
+	testCases := []struct {
+		wantECS        *net.IPNet
+		wantOpt        *net.IPNet
+		wantClientAddr netip.Addr
+		subnet         netip.Prefix
+		addr           netip.AddrPort
+		name           string
+	}{{
+		wantECS: &net.IPNet{
+			IP:   net.IP{203, 0, 113, 0},
+			Mask: net.CIDRMask(24, 32),
+		},
+		wantOpt: &net.IPNet{
+			IP:   net.IP{203, 0, 113, 0},
+			Mask: net.CIDRMask(24, 32),
+		},
+		wantClientAddr: netip.MustParseAddr("203.0.113.5"),
+		subnet:         netip.MustParsePrefix("203.0.113.5/32"),
+		addr:           trustedAddr,
+		name:           "trusted_limited",
+	}, {
+		wantECS: &net.IPNet{
+			IP:   net.ParseIP("2001:db8::"),
+			Mask: net.CIDRMask(48, 128),
+		},
+		wantOpt: &net.IPNet{
+			IP:   net.ParseIP("2001:db8::"),
+			Mask: net.CIDRMask(48, 128),
+		},
+		wantClientAddr: netip.MustParseAddr("2001:db8::"),
+		subnet:         netip.MustParsePrefix("2001:db8::/48"),
+		addr:           trustedAddr,
+		name:           "trusted_ipv6",
+	}, {
+		wantECS:        nil,
+		wantOpt:        nil,
+		wantClientAddr: trustedAddr.Addr(),
+		subnet:         netip.Prefix{},
+		addr:           trustedAddr,
+		name:           "trusted_no_ecs",
+	}, {
+		wantECS: nil,
+		wantOpt: &net.IPNet{
+			IP:   net.IP{203, 0, 113, 0},
+			Mask: net.CIDRMask(24, 32),
+		},
+		wantClientAddr: untrustedAddr.Addr(),
+		subnet:         netip.MustParsePrefix("203.0.113.0/24"),
+		addr:           untrustedAddr,
+		name:           "untrusted_untouched",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dctx := &dnsContext{
+				proxyCtx: &proxy.DNSContext{
+					Req:  newTestECSRequest(tc.subnet),
+					Addr: tc.addr,
+				},
+			}
+
+			s.processTrustedECS(dctx)
+
+			assert.Equal(t, tc.wantClientAddr, dctx.clientAddr())
+
+			opt := ecsOption(dctx.proxyCtx.Req)
+			if tc.wantOpt == nil {
+				assert.Nil(t, opt)
+			} else {
+				require.NotNil(t, opt)
+
+				ones, _ := tc.wantOpt.Mask.Size()
+				assert.Equal(t, uint8(ones), opt.SourceNetmask)
+				assert.True(t, tc.wantOpt.IP.Equal(opt.Address))
+			}
+
+			if tc.wantECS == nil {
+				assert.Nil(t, dctx.proxyCtx.ReqECS)
+			} else {
+				require.NotNil(t, dctx.proxyCtx.ReqECS)
+
+				assert.Equal(t, tc.wantECS.String(), dctx.proxyCtx.ReqECS.String())
+			}
+		})
+	}
+}
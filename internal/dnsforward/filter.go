@@ -18,7 +18,7 @@ func (s *Server) clientRequestFilteringSettings(dctx *dnsContext) (setts *filter
 	setts = s.dnsFilter.Settings()
 	setts.ProtectionEnabled = dctx.protectionEnabled
 	if s.conf.FilterHandler != nil {
-		s.conf.FilterHandler(dctx.proxyCtx.Addr.Addr(), dctx.clientID, setts)
+		s.conf.FilterHandler(dctx.clientAddr(), dctx.clientID, setts)
 	}
 
 	return setts
@@ -50,6 +50,7 @@ func (s *Server) filterDNSRequest(dctx *dnsContext) (res *filtering.Result, err
 		pctx.Res = s.genDNSFilterMessage(pctx, res)
 	case res.Reason.In(filtering.Rewritten, filtering.FilteredSafeSearch):
 		pctx.Res = s.getCNAMEWithIPs(req, res.IPList, res.CanonName)
+		pctx.Res.Answer = append(pctx.Res.Answer, s.genAnswersFromRewrite(req, res)...)
 	case res.Reason.In(filtering.RewrittenRule, filtering.RewrittenAutoHosts):
 		if err = s.filterDNSRewrite(req, res, pctx); err != nil {
 			return nil, err
@@ -60,14 +61,15 @@ func (s *Server) filterDNSRequest(dctx *dnsContext) (res *filtering.Result, err
 }
 
 // isRewrittenCNAME returns true if the request considered to be rewritten with
-// CNAME and has no resolved IPs.
+// CNAME and has no resolved IPs or other records.
 func isRewrittenCNAME(res *filtering.Result) (ok bool) {
 	return res.Reason.In(
 		filtering.Rewritten,
 		filtering.RewrittenRule,
 		filtering.FilteredSafeSearch) &&
 		res.CanonName != "" &&
-		len(res.IPList) == 0
+		len(res.IPList) == 0 &&
+		len(res.RewriteRRs) == 0
 }
 
 // checkHostRules checks the host against filters.  It is safe for concurrent
@@ -3,6 +3,7 @@ package dnsforward
 import (
 	"net"
 	"net/netip"
+	"slices"
 	"testing"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
@@ -54,7 +55,7 @@ func TestHandleDNSRequest_handleDNSRequest(t *testing.T) {
 		DHCPServer: &testDHCP{
 			OnEnabled:  func() (ok bool) { return false },
 			OnHostByIP: func(ip netip.Addr) (host string) { panic("not implemented") },
-			OnIPByHost: func(host string) (ip netip.Addr) { panic("not implemented") },
+			OnIPByHost: func(host string) (ips []netip.Addr) { panic("not implemented") },
 		},
 		DNSFilter:   f,
 		PrivateNets: netutil.SubnetSetFunc(netutil.IsLocallyServed),
@@ -379,3 +380,114 @@ func newSVCBHintsAnswer(target string, hints []dns.SVCBKeyValue) (rrs []dns.RR)
 		},
 	}}
 }
+
+func TestHandleDNSRequest_blockedResponseTTL(t *testing.T) {
+	const (
+		globalTTL  uint32 = 3600
+		threatTTL  uint32 = 60
+		longTTL    uint32 = 7200
+		threatHost        = "threat.example"
+		adsHost           = "ads.example"
+		longHost          = "long.example"
+	)
+
+	filters := []filtering.Filter{{
+		ID: 1, Data: []byte("||" + threatHost + "^\n"),
+	}, {
+		ID: 2, Data: []byte("||" + adsHost + "^\n"),
+	}, {
+		ID: 3, Data: []byte("||" + longHost + "^\n"),
+	}}
+
+	f, err := filtering.New(&filtering.Config{
+		ProtectionEnabled:  true,
+		BlockingMode:       filtering.BlockingModeDefault,
+		BlockedResponseTTL: globalTTL,
+		Filters: []filtering.FilterYAML{{
+			Enabled:            true,
+			Filter:             filtering.Filter{ID: 1},
+			BlockedResponseTTL: threatTTL,
+		}, {
+			Enabled: true,
+			Filter:  filtering.Filter{ID: 2},
+		}, {
+			Enabled:            true,
+			Filter:             filtering.Filter{ID: 3},
+			BlockedResponseTTL: longTTL,
+		}},
+	}, filters)
+	require.NoError(t, err)
+
+	f.SetEnabled(true)
+
+	s, err := NewServer(DNSCreateParams{
+		DHCPServer: &testDHCP{
+			OnEnabled:  func() (ok bool) { return false },
+			OnHostByIP: func(ip netip.Addr) (host string) { panic("not implemented") },
+			OnIPByHost: func(host string) (ips []netip.Addr) { panic("not implemented") },
+		},
+		DNSFilter:   f,
+		PrivateNets: netutil.SubnetSetFunc(netutil.IsLocallyServed),
+		Logger:      slogutil.NewDiscardLogger(),
+	})
+	require.NoError(t, err)
+
+	err = s.Prepare(&ServerConfig{
+		UDPListenAddrs: []*net.UDPAddr{{}},
+		TCPListenAddrs: []*net.TCPAddr{{}},
+		Config: Config{
+			UpstreamMode: UpstreamModeLoadBalance,
+			EDNSClientSubnet: &EDNSClientSubnet{
+				Enabled: false,
+			},
+		},
+		ServePlainDNS: true,
+	})
+	require.NoError(t, err)
+
+	s.conf.UpstreamConfig.Upstreams = []upstream.Upstream{&aghtest.Upstream{}}
+	startDeferStop(t, s)
+
+	testCases := []struct {
+		req     *dns.Msg
+		name    string
+		wantTTL uint32
+	}{{
+		req:     createTestMessage(dns.Fqdn(threatHost)),
+		name:    "list_ttl",
+		wantTTL: threatTTL,
+	}, {
+		req:     createTestMessageWithType(dns.Fqdn(threatHost), dns.TypeTXT),
+		name:    "list_ttl_nodata",
+		wantTTL: threatTTL,
+	}, {
+		req:     createTestMessage(dns.Fqdn(adsHost)),
+		name:    "global_ttl",
+		wantTTL: globalTTL,
+	}, {
+		req:     createTestMessage(dns.Fqdn(longHost)),
+		name:    "global_ttl_smaller",
+		wantTTL: globalTTL,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dctx := &proxy.DNSContext{
+				Proto: proxy.ProtoUDP,
+				Req:   tc.req,
+				Addr:  testClientAddrPort,
+			}
+
+			err = s.handleDNSRequest(nil, dctx)
+			require.NoError(t, err)
+			require.NotNil(t, dctx.Res)
+
+			rrs := slices.Concat(dctx.Res.Answer, dctx.Res.Ns)
+			require.NotEmpty(t, rrs)
+
+			for _, rr := range rrs {
+				assert.Equal(t, tc.wantTTL, rr.Header().Ttl)
+			}
+		})
+	}
+}
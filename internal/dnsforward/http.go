@@ -18,6 +18,7 @@ import (
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/AdguardTeam/golibs/netutil"
 	"github.com/AdguardTeam/golibs/stringutil"
+	"github.com/AdguardTeam/golibs/timeutil"
 )
 
 // jsonDNSConfig is the JSON representation of the DNS server configuration.
@@ -59,6 +60,14 @@ type jsonDNSConfig struct {
 	// BlockingMode defines the way blocked responses are constructed.
 	BlockingMode *filtering.BlockingMode `json:"blocking_mode"`
 
+	// AAAABlockingMode defines the way blocked responses to AAAA requests are
+	// constructed.
+	AAAABlockingMode *filtering.AAAABlockingMode `json:"blocking_mode_aaaa"`
+
+	// SafeBrowsingBlockingMode defines the way blocked responses to requests
+	// blocked by safe-browsing and parental control are constructed.
+	SafeBrowsingBlockingMode *filtering.SafeBrowsingBlockingMode `json:"safebrowsing_blocking_mode"`
+
 	// EDNSCSEnabled defines if EDNS Client Subnet is enabled.
 	EDNSCSEnabled *bool `json:"edns_cs_enabled"`
 
@@ -71,6 +80,10 @@ type jsonDNSConfig struct {
 	// DisableIPv6 defines if IPv6 addresses should be dropped.
 	DisableIPv6 *bool `json:"disable_ipv6"`
 
+	// FilterNonGlobalIPv6 defines if non-global IPv6 addresses should be
+	// removed from the responses of upstream servers.
+	FilterNonGlobalIPv6 *bool `json:"filter_non_global_ipv6"`
+
 	// UpstreamMode defines the way DNS requests are constructed.
 	UpstreamMode *jsonUpstreamMode `json:"upstream_mode"`
 
@@ -89,6 +102,14 @@ type jsonDNSConfig struct {
 	// CacheOptimistic defines if expired entries should be served.
 	CacheOptimistic *bool `json:"cache_optimistic"`
 
+	// ServeStale defines if expired responses should be served when all the
+	// upstream servers fail.
+	ServeStale *bool `json:"serve_stale"`
+
+	// ServeStaleMaxAge is the maximum time in seconds the expired responses
+	// are served for.
+	ServeStaleMaxAge *uint32 `json:"serve_stale_max_age"`
+
 	// ResolveClients defines if clients IPs should be resolved into hostnames.
 	ResolveClients *bool `json:"resolve_clients"`
 
@@ -129,6 +150,7 @@ const (
 	jsonUpstreamModeLoadBalance jsonUpstreamMode = "load_balance"
 	jsonUpstreamModeParallel    jsonUpstreamMode = "parallel"
 	jsonUpstreamModeFastestAddr jsonUpstreamMode = "fastest_addr"
+	jsonUpstreamModeFailover    jsonUpstreamMode = "failover"
 )
 
 func (s *Server) getDNSConfig() (c *jsonDNSConfig) {
@@ -142,6 +164,8 @@ func (s *Server) getDNSConfig() (c *jsonDNSConfig) {
 	bootstraps := stringutil.CloneSliceOrEmpty(s.conf.BootstrapDNS)
 	fallbacks := stringutil.CloneSliceOrEmpty(s.conf.FallbackDNS)
 	blockingMode, blockingIPv4, blockingIPv6 := s.dnsFilter.BlockingMode()
+	aaaaBlockingMode := s.dnsFilter.AAAABlockingMode()
+	sbBlockingMode := s.dnsFilter.SafeBrowsingBlockingMode()
 	blockedResponseTTL := s.dnsFilter.BlockedResponseTTL()
 	ratelimit := s.conf.Ratelimit
 	ratelimitSubnetLenIPv4 := s.conf.RatelimitSubnetLenIPv4
@@ -154,10 +178,18 @@ func (s *Server) getDNSConfig() (c *jsonDNSConfig) {
 
 	enableDNSSEC := s.conf.EnableDNSSEC
 	aaaaDisabled := s.conf.AAAADisabled
+	filterNonGlobalIPv6 := s.conf.FilterNonGlobalIPv6
 	cacheSize := s.conf.CacheSize
 	cacheMinTTL := s.conf.CacheMinTTL
 	cacheMaxTTL := s.conf.CacheMaxTTL
 	cacheOptimistic := s.conf.CacheOptimistic
+	serveStale := s.conf.ServeStale
+	serveStaleMaxAge := uint32(time.Duration(s.conf.ServeStaleMaxAge).Seconds())
+	if serveStaleMaxAge == 0 {
+		// Report the default used instead of the unset value, since zero is
+		// not accepted by the API.
+		serveStaleMaxAge = uint32(time.Duration(defaultServeStaleMaxAge).Seconds())
+	}
 	resolveClients := s.conf.AddrProcConf.UseRDNS
 	usePrivateRDNS := s.conf.UsePrivateRDNS
 	localPTRUpstreams := stringutil.CloneSliceOrEmpty(s.conf.LocalPTRResolvers)
@@ -172,6 +204,8 @@ func (s *Server) getDNSConfig() (c *jsonDNSConfig) {
 		upstreamMode = jsonUpstreamModeParallel
 	case UpstreamModeFastestAddr:
 		upstreamMode = jsonUpstreamModeFastestAddr
+	case UpstreamModeFailover:
+		upstreamMode = jsonUpstreamModeFailover
 	}
 
 	defPTRUps, err := s.defaultLocalPTRUpstreams()
@@ -186,6 +220,8 @@ func (s *Server) getDNSConfig() (c *jsonDNSConfig) {
 		Fallbacks:                &fallbacks,
 		ProtectionEnabled:        &protectionEnabled,
 		BlockingMode:             &blockingMode,
+		AAAABlockingMode:         &aaaaBlockingMode,
+		SafeBrowsingBlockingMode: &sbBlockingMode,
 		BlockingIPv4:             blockingIPv4,
 		BlockingIPv6:             blockingIPv6,
 		Ratelimit:                &ratelimit,
@@ -197,11 +233,14 @@ func (s *Server) getDNSConfig() (c *jsonDNSConfig) {
 		EDNSCSUseCustom:          &useCustom,
 		DNSSECEnabled:            &enableDNSSEC,
 		DisableIPv6:              &aaaaDisabled,
+		FilterNonGlobalIPv6:      &filterNonGlobalIPv6,
 		BlockedResponseTTL:       &blockedResponseTTL,
 		CacheSize:                &cacheSize,
 		CacheMinTTL:              &cacheMinTTL,
 		CacheMaxTTL:              &cacheMaxTTL,
 		CacheOptimistic:          &cacheOptimistic,
+		ServeStale:               &serveStale,
+		ServeStaleMaxAge:         &serveStaleMaxAge,
 		UpstreamMode:             &upstreamMode,
 		ResolveClients:           &resolveClients,
 		UsePrivateRDNS:           &usePrivateRDNS,
@@ -235,8 +274,24 @@ func (s *Server) handleGetConfig(w http.ResponseWriter, r *http.Request) {
 	aghhttp.WriteJSONResponseOK(w, r, resp)
 }
 
-// checkBlockingMode returns an error if blocking mode is invalid.
+// checkBlockingMode returns an error if any of the blocking modes is invalid.
 func (req *jsonDNSConfig) checkBlockingMode() (err error) {
+	if req.AAAABlockingMode != nil {
+		err = validateAAAABlockingMode(*req.AAAABlockingMode)
+		if err != nil {
+			// Don't wrap the error since it's informative enough as is.
+			return err
+		}
+	}
+
+	if req.SafeBrowsingBlockingMode != nil {
+		err = validateSafeBrowsingBlockingMode(*req.SafeBrowsingBlockingMode)
+		if err != nil {
+			// Don't wrap the error since it's informative enough as is.
+			return err
+		}
+	}
+
 	if req.BlockingMode == nil {
 		return nil
 	}
@@ -255,7 +310,8 @@ func (req *jsonDNSConfig) checkUpstreamMode() (err error) {
 		jsonUpstreamModeEmpty,
 		jsonUpstreamModeLoadBalance,
 		jsonUpstreamModeParallel,
-		jsonUpstreamModeFastestAddr:
+		jsonUpstreamModeFastestAddr,
+		jsonUpstreamModeFailover:
 		return nil
 	default:
 		return fmt.Errorf("upstream_mode: incorrect value %q", um)
@@ -302,6 +358,10 @@ func (req *jsonDNSConfig) validate(
 		return err
 	}
 
+	if req.ServeStaleMaxAge != nil && *req.ServeStaleMaxAge == 0 {
+		return fmt.Errorf("serve_stale_max_age: %w", errors.ErrNotPositive)
+	}
+
 	return nil
 }
 
@@ -502,6 +562,14 @@ func (s *Server) setConfig(dc *jsonDNSConfig) (shouldRestart bool) {
 		s.dnsFilter.SetBlockingMode(*dc.BlockingMode, dc.BlockingIPv4, dc.BlockingIPv6)
 	}
 
+	if dc.AAAABlockingMode != nil {
+		s.dnsFilter.SetAAAABlockingMode(*dc.AAAABlockingMode)
+	}
+
+	if dc.SafeBrowsingBlockingMode != nil {
+		s.dnsFilter.SetSafeBrowsingBlockingMode(*dc.SafeBrowsingBlockingMode)
+	}
+
 	if dc.BlockedResponseTTL != nil {
 		s.dnsFilter.SetBlockedResponseTTL(*dc.BlockedResponseTTL)
 	}
@@ -520,6 +588,7 @@ func (s *Server) setConfig(dc *jsonDNSConfig) (shouldRestart bool) {
 
 	setIfNotNil(&s.conf.EnableDNSSEC, dc.DNSSECEnabled)
 	setIfNotNil(&s.conf.AAAADisabled, dc.DisableIPv6)
+	setIfNotNil(&s.conf.FilterNonGlobalIPv6, dc.FilterNonGlobalIPv6)
 
 	return s.setConfigRestartable(dc)
 }
@@ -534,6 +603,8 @@ func mustParseUpstreamMode(mode jsonUpstreamMode) (um UpstreamMode) {
 		return UpstreamModeParallel
 	case jsonUpstreamModeFastestAddr:
 		return UpstreamModeFastestAddr
+	case jsonUpstreamModeFailover:
+		return UpstreamModeFailover
 	default:
 		// Should never happen, since the value should be validated.
 		panic(fmt.Errorf("unexpected upstream mode: %q", mode))
@@ -571,6 +642,7 @@ func (s *Server) setConfigRestartable(dc *jsonDNSConfig) (shouldRestart bool) {
 		setIfNotNil(&s.conf.CacheMinTTL, dc.CacheMinTTL),
 		setIfNotNil(&s.conf.CacheMaxTTL, dc.CacheMaxTTL),
 		setIfNotNil(&s.conf.CacheOptimistic, dc.CacheOptimistic),
+		setIfNotNil(&s.conf.ServeStale, dc.ServeStale),
 		setIfNotNil(&s.conf.AddrProcConf.UseRDNS, dc.ResolveClients),
 		setIfNotNil(&s.conf.UsePrivateRDNS, dc.UsePrivateRDNS),
 		setIfNotNil(&s.conf.RatelimitSubnetLenIPv4, dc.RatelimitSubnetLenIPv4),
@@ -583,6 +655,11 @@ func (s *Server) setConfigRestartable(dc *jsonDNSConfig) (shouldRestart bool) {
 		}
 	}
 
+	if dc.ServeStaleMaxAge != nil {
+		s.conf.ServeStaleMaxAge = timeutil.Duration(time.Duration(*dc.ServeStaleMaxAge) * time.Second)
+		shouldRestart = true
+	}
+
 	if dc.Ratelimit != nil && s.conf.Ratelimit != *dc.Ratelimit {
 		s.conf.Ratelimit = *dc.Ratelimit
 		shouldRestart = true
@@ -597,6 +674,72 @@ type upstreamJSON struct {
 	BootstrapDNS     []string `json:"bootstrap_dns"`
 	FallbackDNS      []string `json:"fallback_dns"`
 	PrivateUpstreams []string `json:"private_upstream"`
+
+	// View is the name of the view, the upstreams and the bootstrap servers of
+	// which should be tested instead of Upstreams and BootstrapDNS.  It's
+	// ignored if empty.
+	View string `json:"view"`
+
+	// Probes is the number of the test queries sent to each upstream.  If
+	// zero, [defaultUpstreamProbes] is used.  It must not be greater than
+	// [maxUpstreamProbes].
+	Probes int `json:"probes"`
+}
+
+const (
+	// defaultUpstreamProbes is the default number of the test queries sent to
+	// each upstream by the POST /control/test_upstream_dns HTTP API.
+	defaultUpstreamProbes = 3
+
+	// maxUpstreamProbes is the maximum number of the test queries sent to each
+	// upstream by the POST /control/test_upstream_dns HTTP API.
+	maxUpstreamProbes = 10
+)
+
+// upstreamTestJSON is the result of testing a single upstream for the
+// POST /control/test_upstream_dns HTTP API.
+type upstreamTestJSON struct {
+	// Result is "OK" if the upstream works, and the error message otherwise.
+	Result string `json:"result"`
+
+	// Latencies are the round-trip times of the successful test queries, in
+	// milliseconds.
+	Latencies []int `json:"latencies"`
+
+	// LatencyMsMean is the mean of Latencies.
+	LatencyMsMean float64 `json:"latency_ms_mean"`
+
+	// LatencyMsMin is the minimum of Latencies.
+	LatencyMsMin int `json:"latency_ms_min"`
+
+	// LatencyMsMax is the maximum of Latencies.
+	LatencyMsMax int `json:"latency_ms_max"`
+}
+
+// newUpstreamTestJSON returns the result of testing an upstream with the
+// status res and the latencies of the successful test queries lats.
+func newUpstreamTestJSON(res string, lats []time.Duration) (s *upstreamTestJSON) {
+	s = &upstreamTestJSON{
+		Result:    res,
+		Latencies: make([]int, 0, len(lats)),
+	}
+
+	if len(lats) == 0 {
+		return s
+	}
+
+	sum := 0
+	for _, l := range lats {
+		ms := int(l.Milliseconds())
+		s.Latencies = append(s.Latencies, ms)
+		sum += ms
+	}
+
+	s.LatencyMsMean = float64(sum) / float64(len(lats))
+	s.LatencyMsMin = slices.Min(s.Latencies)
+	s.LatencyMsMax = slices.Max(s.Latencies)
+
+	return s
 }
 
 // closeBoots closes all the provided bootstrap servers and logs errors if any.
@@ -617,6 +760,36 @@ func (s *Server) handleTestUpstreamDNS(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if req.Probes == 0 {
+		req.Probes = defaultUpstreamProbes
+	} else if req.Probes < 0 || req.Probes > maxUpstreamProbes {
+		aghhttp.Error(
+			r,
+			w,
+			http.StatusBadRequest,
+			"probes: out of range: must be from 1 to %d, got %d",
+			maxUpstreamProbes,
+			req.Probes,
+		)
+
+		return
+	}
+
+	if req.View != "" {
+		var v *View
+		v, err = s.viewByName(req.View)
+		if err != nil {
+			aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+			return
+		}
+
+		req.Upstreams = v.Upstreams
+		if len(v.Bootstrap) > 0 {
+			req.BootstrapDNS = v.Bootstrap
+		}
+	}
+
 	req.BootstrapDNS = stringutil.FilterOut(req.BootstrapDNS, IsCommentOrEmpty)
 
 	opts := &upstream.Options{
@@ -634,15 +807,23 @@ func (s *Server) handleTestUpstreamDNS(w http.ResponseWriter, r *http.Request) {
 	defer closeBoots(boots)
 
 	cv := newUpstreamConfigValidator(req.Upstreams, req.FallbackDNS, req.PrivateUpstreams, opts)
-	cv.check()
+	cv.check(req.Probes)
 	cv.close()
 
-	aghhttp.WriteJSONResponseOK(w, r, cv.status())
+	lats := cv.latencies()
+	statuses := cv.status()
+	resp := make(map[string]*upstreamTestJSON, len(statuses))
+	for original, res := range statuses {
+		resp[original] = newUpstreamTestJSON(res, lats[original])
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
 }
 
 // handleCacheClear is the handler for the POST /control/cache_clear HTTP API.
 func (s *Server) handleCacheClear(w http.ResponseWriter, _ *http.Request) {
 	s.dnsProxy.ClearCache()
+	s.clearGroupCaches()
 	_, _ = io.WriteString(w, "OK")
 }
 
@@ -732,6 +913,28 @@ func (s *Server) registerHandlers() {
 
 	s.conf.HTTPRegister(http.MethodPost, "/control/cache_clear", s.handleCacheClear)
 
+	s.conf.HTTPRegister(http.MethodPost, "/control/dns/resolve_debug", s.handleResolveDebug)
+	s.conf.HTTPRegister(http.MethodPost, "/control/querylog/replay", s.handleQueryLogReplay)
+
+	s.conf.HTTPRegister(http.MethodGet, "/control/dns/connections", s.handleGetConnections)
+	s.conf.HTTPRegister(
+		http.MethodPost,
+		"/control/dns/connections/close",
+		s.handleCloseConnection,
+	)
+
+	s.conf.HTTPRegister(http.MethodGet, "/control/dns/sessions", s.handleGetSessions)
+	s.conf.HTTPRegister(
+		http.MethodGet,
+		"/control/dns/upstreams/status",
+		s.handleUpstreamsStatus,
+	)
+
+	s.conf.HTTPRegister(http.MethodGet, "/control/dns/views/list", s.handleViewList)
+	s.conf.HTTPRegister(http.MethodPost, "/control/dns/views/add", s.handleViewAdd)
+	s.conf.HTTPRegister(http.MethodPost, "/control/dns/views/delete", s.handleViewDelete)
+	s.conf.HTTPRegister(http.MethodPut, "/control/dns/views/update", s.handleViewUpdate)
+
 	// Register both versions, with and without the trailing slash, to
 	// prevent a 301 Moved Permanently redirect when clients request the
 	// path without the trailing slash.  Those redirects break some clients.
@@ -24,6 +24,7 @@ import (
 	"github.com/AdguardTeam/golibs/httphdr"
 	"github.com/AdguardTeam/golibs/netutil"
 	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/ameshkov/dnsstamps"
 	"github.com/miekg/dns"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -195,6 +196,18 @@ func TestDNSForwardHTTP_handleSetConfig(t *testing.T) {
 		name: "blocking_mode_bad",
 		wantSet: "validating dns config: " +
 			"blocking_ipv4 must be valid ipv4 on custom_ip blocking_mode",
+	}, {
+		name:    "blocking_mode_aaaa_good",
+		wantSet: "",
+	}, {
+		name:    "blocking_mode_aaaa_bad",
+		wantSet: `validating dns config: bad blocking mode for aaaa "refused"`,
+	}, {
+		name:    "safebrowsing_blocking_mode_good",
+		wantSet: "",
+	}, {
+		name:    "safebrowsing_blocking_mode_bad",
+		wantSet: `validating dns config: bad safe browsing blocking mode "redirect"`,
 	}, {
 		name:    "ratelimit",
 		wantSet: "",
@@ -278,6 +291,8 @@ func TestDNSForwardHTTP_handleSetConfig(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			t.Cleanup(func() {
 				s.dnsFilter.SetBlockingMode(filtering.BlockingModeDefault, netip.Addr{}, netip.Addr{})
+				s.dnsFilter.SetAAAABlockingMode(filtering.AAAABlockingModeDefault)
+				s.dnsFilter.SetSafeBrowsingBlockingMode(filtering.SafeBrowsingBlockingModeBlockHost)
 				s.conf = defaultConf
 				s.conf.Config.EDNSClientSubnet = &EDNSClientSubnet{}
 				s.dnsFilter.SetBlockedResponseTTL(testBlockedRespTTL)
@@ -378,6 +393,28 @@ func TestServer_HandleTestUpstreamDNS(t *testing.T) {
 	)
 	require.NoError(t, err)
 
+	udpConn, err := net.ListenPacket("udp", netip.AddrPortFrom(netutil.IPv4Localhost(), 0).String())
+	require.NoError(t, err)
+
+	udpStarted := make(chan struct{})
+	udpSrv := &dns.Server{
+		PacketConn:        udpConn,
+		Handler:           hdlr,
+		NotifyStartedFunc: func() { close(udpStarted) },
+	}
+	go func() {
+		srvErr := udpSrv.ActivateAndServe()
+		require.NoError(testutil.PanicT{}, srvErr)
+	}()
+
+	<-udpStarted
+	testutil.CleanupAndRequireSuccess(t, udpSrv.Shutdown)
+
+	stampUps := (&dnsstamps.ServerStamp{
+		ServerAddrStr: udpConn.LocalAddr().String(),
+		Proto:         dnsstamps.StampProtoTypePlain,
+	}).String()
+
 	srv := createTestServer(t, &filtering.Config{
 		BlockingMode: filtering.BlockingModeDefault,
 		EtcHosts:     hc,
@@ -396,13 +433,13 @@ func TestServer_HandleTestUpstreamDNS(t *testing.T) {
 
 	testCases := []struct {
 		body     map[string]any
-		wantResp map[string]any
+		wantResp map[string]string
 		name     string
 	}{{
 		body: map[string]any{
 			"upstream_dns": []string{hostsUps},
 		},
-		wantResp: map[string]any{
+		wantResp: map[string]string{
 			hostsUps: "OK",
 		},
 		name: "etc_hosts",
@@ -410,10 +447,26 @@ func TestServer_HandleTestUpstreamDNS(t *testing.T) {
 		body: map[string]any{
 			"upstream_dns": []string{ups, "#this.is.comment"},
 		},
-		wantResp: map[string]any{
+		wantResp: map[string]string{
 			ups: "OK",
 		},
 		name: "comment_mix",
+	}, {
+		body: map[string]any{
+			"upstream_dns": []string{stampUps},
+		},
+		wantResp: map[string]string{
+			stampUps: "OK",
+		},
+		name: "dns_stamp",
+	}, {
+		body: map[string]any{
+			"upstream_dns": []string{"[/example.org/]" + stampUps},
+		},
+		wantResp: map[string]string{
+			stampUps: "OK",
+		},
+		name: "domain_specific_dns_stamp",
 	}}
 
 	for _, tc := range testCases {
@@ -431,11 +484,16 @@ func TestServer_HandleTestUpstreamDNS(t *testing.T) {
 			srv.handleTestUpstreamDNS(w, r)
 			require.Equal(t, http.StatusOK, w.Code)
 
-			resp := map[string]any{}
+			resp := map[string]*upstreamTestJSON{}
 			err = json.NewDecoder(w.Body).Decode(&resp)
 			require.NoError(t, err)
 
-			assert.Equal(t, tc.wantResp, resp)
+			results := map[string]string{}
+			for ups, res := range resp {
+				results[ups] = res.Result
+			}
+
+			assert.Equal(t, tc.wantResp, results)
 		})
 	}
 
@@ -467,15 +525,120 @@ func TestServer_HandleTestUpstreamDNS(t *testing.T) {
 		srv.handleTestUpstreamDNS(w, r)
 		require.Equal(t, http.StatusOK, w.Code)
 
-		resp := map[string]any{}
+		resp := map[string]*upstreamTestJSON{}
 		err = json.NewDecoder(w.Body).Decode(&resp)
 		require.NoError(t, err)
 
 		require.Contains(t, resp, sleepyUps)
-		require.IsType(t, "", resp[sleepyUps])
-		sleepyRes, _ := resp[sleepyUps].(string)
+		sleepyRes := resp[sleepyUps].Result
 
 		// TODO(e.burkov):  Improve the format of an error in dnsproxy.
 		assert.True(t, strings.HasSuffix(sleepyRes, "i/o timeout"))
 	})
 }
+
+func TestServer_HandleTestUpstreamDNS_latency(t *testing.T) {
+	// upsDelay is the delay of each response of the upstream.
+	const upsDelay = 20 * time.Millisecond
+
+	slowHandler := dns.HandlerFunc(func(w dns.ResponseWriter, m *dns.Msg) {
+		time.Sleep(upsDelay)
+		err := w.WriteMsg(new(dns.Msg).SetReply(m))
+		require.NoError(testutil.PanicT{}, err)
+	})
+
+	ups := (&url.URL{
+		Scheme: "tcp",
+		Host:   newLocalUpstreamListener(t, 0, slowHandler).String(),
+	}).String()
+
+	srv := createTestServer(t, &filtering.Config{
+		BlockingMode: filtering.BlockingModeDefault,
+	}, ServerConfig{
+		UDPListenAddrs:  []*net.UDPAddr{{}},
+		TCPListenAddrs:  []*net.TCPAddr{{}},
+		UpstreamTimeout: testTimeout,
+		Config: Config{
+			UpstreamMode:     UpstreamModeLoadBalance,
+			EDNSClientSubnet: &EDNSClientSubnet{Enabled: false},
+		},
+		ServePlainDNS: true,
+	})
+	startDeferStop(t, srv)
+
+	testCases := []struct {
+		name       string
+		probes     int
+		wantProbes int
+		wantCode   int
+	}{{
+		name:       "default",
+		probes:     0,
+		wantProbes: defaultUpstreamProbes,
+		wantCode:   http.StatusOK,
+	}, {
+		name:       "custom",
+		probes:     5,
+		wantProbes: 5,
+		wantCode:   http.StatusOK,
+	}, {
+		name:       "max",
+		probes:     maxUpstreamProbes,
+		wantProbes: maxUpstreamProbes,
+		wantCode:   http.StatusOK,
+	}, {
+		name:       "too_many",
+		probes:     maxUpstreamProbes + 1,
+		wantProbes: 0,
+		wantCode:   http.StatusBadRequest,
+	}, {
+		name:       "negative",
+		probes:     -1,
+		wantProbes: 0,
+		wantCode:   http.StatusBadRequest,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			reqBody, err := json.Marshal(map[string]any{
+				"upstream_dns": []string{ups},
+				"probes":       tc.probes,
+			})
+			require.NoError(t, err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(
+				http.MethodPost,
+				"/control/test_upstream_dns",
+				bytes.NewReader(reqBody),
+			)
+
+			srv.handleTestUpstreamDNS(w, r)
+			require.Equal(t, tc.wantCode, w.Code)
+
+			if tc.wantCode != http.StatusOK {
+				return
+			}
+
+			resp := map[string]*upstreamTestJSON{}
+			err = json.NewDecoder(w.Body).Decode(&resp)
+			require.NoError(t, err)
+			require.Contains(t, resp, ups)
+
+			res := resp[ups]
+			assert.Equal(t, "OK", res.Result)
+			require.Len(t, res.Latencies, tc.wantProbes)
+
+			sum := 0
+			for _, l := range res.Latencies {
+				assert.GreaterOrEqual(t, l, int(upsDelay.Milliseconds()))
+				sum += l
+			}
+
+			assert.Equal(t, float64(sum)/float64(tc.wantProbes), res.LatencyMsMean)
+			assert.GreaterOrEqual(t, res.LatencyMsMin, int(upsDelay.Milliseconds()))
+			assert.LessOrEqual(t, float64(res.LatencyMsMin), res.LatencyMsMean)
+			assert.GreaterOrEqual(t, float64(res.LatencyMsMax), res.LatencyMsMean)
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package dnsforward
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/container"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// InterfaceUpstream is the configuration of the upstreams for the queries
+// received on the listeners bound to the addresses of a network interface.
+type InterfaceUpstream struct {
+	// Interface is the name of the network interface, for example "eth1".
+	Interface string `yaml:"interface"`
+
+	// Upstreams are the addresses of the upstreams for the queries received on
+	// the interface.  Unlike [Config.UpstreamDNS], the domain-specific syntax
+	// isn't supported.
+	Upstreams []string `yaml:"upstreams"`
+}
+
+// ifaceByNameFunc returns the network interface with the given name.
+type ifaceByNameFunc func(name string) (iface aghnet.NetIface, err error)
+
+// netIfaceByName is the default [ifaceByNameFunc] using the system
+// interfaces.
+func netIfaceByName(name string) (iface aghnet.NetIface, err error) {
+	netIface, err := net.InterfaceByName(name)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+
+	return netIface, nil
+}
+
+// ifaceBinding is the prepared upstream configuration of a network interface.
+type ifaceBinding struct {
+	// conf is the custom upstream configuration used for the queries received
+	// on the interface.
+	conf *proxy.CustomUpstreamConfig
+
+	// name is the name of the interface.
+	name string
+
+	// addrs are the IP addresses of the interface.
+	addrs []netip.Addr
+
+	// binderAddr is the first IPv4 address of the interface, which the
+	// outgoing connections to the upstreams should originate from.
+	binderAddr netip.Addr
+}
+
+// newIfaceBindings returns the prepared upstream configurations for confs.
+// byName is used to resolve the interfaces, opts are the base options of the
+// upstreams.  The upstream configurations use a separate cache of cacheSize
+// bytes, unless it's zero, and their copies without caches are stored in
+// uncached.
+func newIfaceBindings(
+	confs []InterfaceUpstream,
+	byName ifaceByNameFunc,
+	opts *upstream.Options,
+	cacheSize uint32,
+	enableECS bool,
+	uncached *uncachedUpstreams,
+) (bindings []*ifaceBinding, err error) {
+	names := container.NewMapSet[string]()
+	for i, c := range confs {
+		if names.Has(c.Interface) {
+			err = fmt.Errorf("duplicate interface %q", c.Interface)
+		} else {
+			var b *ifaceBinding
+			b, err = newIfaceBinding(&c, byName, opts, cacheSize, enableECS, uncached)
+			if err == nil {
+				names.Add(c.Interface)
+				bindings = append(bindings, b)
+
+				continue
+			}
+		}
+
+		closeIfaceBindings(bindings, uncached)
+
+		return nil, fmt.Errorf("interface binding at index %d: %w", i, err)
+	}
+
+	return bindings, nil
+}
+
+// newIfaceBinding returns the prepared upstream configuration for c.
+func newIfaceBinding(
+	c *InterfaceUpstream,
+	byName ifaceByNameFunc,
+	opts *upstream.Options,
+	cacheSize uint32,
+	enableECS bool,
+	uncached *uncachedUpstreams,
+) (b *ifaceBinding, err error) {
+	if c.Interface == "" {
+		return nil, errors.Error("interface: empty value")
+	} else if len(c.Upstreams) == 0 {
+		return nil, fmt.Errorf("interface %q: no upstreams", c.Interface)
+	}
+
+	iface, err := byName(c.Interface)
+	if err != nil {
+		return nil, fmt.Errorf("interface %q: %w", c.Interface, err)
+	}
+
+	addrs, binderAddr, err := ifaceAddrs(iface)
+	if err != nil {
+		return nil, fmt.Errorf("interface %q: %w", c.Interface, err)
+	}
+
+	// The outgoing connections aren't bound to binderAddr, since
+	// [upstream.Options] doesn't allow setting the local address of the dialer.
+	ups := make([]upstream.Upstream, 0, len(c.Upstreams))
+	for _, addr := range c.Upstreams {
+		var u upstream.Upstream
+		u, err = upstream.AddressToUpstream(addr, opts)
+		if err != nil {
+			closeUpstreams(ups)
+
+			return nil, fmt.Errorf("interface %q: upstream %q: %w", c.Interface, addr, err)
+		}
+
+		ups = append(ups, u)
+	}
+
+	uc := &proxy.UpstreamConfig{Upstreams: ups}
+	conf := proxy.NewCustomUpstreamConfig(uc, cacheSize != 0, int(cacheSize), enableECS)
+	uncached.add(conf, uc)
+
+	return &ifaceBinding{
+		conf:       conf,
+		name:       c.Interface,
+		addrs:      addrs,
+		binderAddr: binderAddr,
+	}, nil
+}
+
+// ifaceAddrs returns the IP addresses of iface and the first IPv4 one among
+// them.  It returns an error if the interface has no IPv4 addresses.
+func ifaceAddrs(iface aghnet.NetIface) (addrs []netip.Addr, v4 netip.Addr, err error) {
+	ips4, err := aghnet.IfaceIPAddrs(iface, aghnet.IPVersion4)
+	if err != nil {
+		return nil, netip.Addr{}, fmt.Errorf("getting ipv4 addrs: %w", err)
+	} else if len(ips4) == 0 {
+		return nil, netip.Addr{}, errors.Error("no ipv4 addrs")
+	}
+
+	ips6, err := aghnet.IfaceIPAddrs(iface, aghnet.IPVersion6)
+	if err != nil {
+		return nil, netip.Addr{}, fmt.Errorf("getting ipv6 addrs: %w", err)
+	}
+
+	addrs = make([]netip.Addr, 0, len(ips4)+len(ips6))
+	for _, ip := range slices.Concat(ips4, ips6) {
+		addr, ok := netip.AddrFromSlice(ip)
+		if ok {
+			addrs = append(addrs, addr.Unmap())
+		}
+	}
+
+	return addrs, addrs[0], nil
+}
+
+// closeUpstreams closes ups and logs the errors, if any.
+func closeUpstreams(ups []upstream.Upstream) {
+	for _, u := range ups {
+		logCloserErr(u, "dnsforward: closing upstream %s: %s", u.Address())
+	}
+}
+
+// closeIfaceBindings closes the upstreams of bindings and logs the errors, if
+// any.  The copies of their configurations are removed from uncached.
+func closeIfaceBindings(bindings []*ifaceBinding, uncached *uncachedUpstreams) {
+	for _, b := range bindings {
+		uncached.forget(b.conf)
+		logCloserErr(b.conf, "dnsforward: closing upstreams of interface %q: %s", b.name)
+	}
+}
+
+// localAddr returns the local address on which the query of pctx has been
+// received.  addr is invalid if it's unknown.  Note that the UDP listeners
+// only report the address they are bound to, so the queries received by a
+// listener on an unspecified address don't match any interface.
+func localAddr(pctx *proxy.DNSContext) (addr netip.Addr) {
+	var netAddr net.Addr
+	switch {
+	case pctx.Conn != nil:
+		netAddr = pctx.Conn.LocalAddr()
+	case pctx.QUICConnection != nil:
+		netAddr = pctx.QUICConnection.LocalAddr()
+	case pctx.HTTPRequest != nil:
+		netAddr, _ = pctx.HTTPRequest.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	default:
+		return netip.Addr{}
+	}
+
+	if netAddr == nil {
+		return netip.Addr{}
+	}
+
+	addrPort, err := netip.ParseAddrPort(netAddr.String())
+	if err != nil {
+		return netip.Addr{}
+	}
+
+	return addrPort.Addr().Unmap()
+}
+
+// ifaceUpstreamConfig returns the upstream configuration of the interface
+// owning addr.  conf is nil if there is no such interface binding.
+func (s *Server) ifaceUpstreamConfig(addr netip.Addr) (conf *proxy.CustomUpstreamConfig) {
+	if !addr.IsValid() {
+		return nil
+	}
+
+	for _, b := range s.ifaceBindings {
+		if slices.Contains(b.addrs, addr) {
+			return b.conf
+		}
+	}
+
+	return nil
+}
+
+// setIfaceUpstream sets the custom upstream configuration of the interface on
+// which the query of pctx has been received if there is no other custom
+// configuration already.
+func (s *Server) setIfaceUpstream(pctx *proxy.DNSContext) {
+	if pctx.CustomUpstreamConfig != nil || len(s.ifaceBindings) == 0 {
+		return
+	}
+
+	conf := s.ifaceUpstreamConfig(localAddr(pctx))
+	if conf != nil {
+		log.Debug("dnsforward: using interface upstreams for %s", pctx.Addr)
+
+		pctx.CustomUpstreamConfig = conf
+	}
+}
@@ -0,0 +1,223 @@
+package dnsforward
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeIface is a mock [aghnet.NetIface] for tests.
+type fakeIface struct {
+	err   error
+	addrs []net.Addr
+}
+
+// type check
+var _ aghnet.NetIface = (*fakeIface)(nil)
+
+// Addrs implements the [aghnet.NetIface] interface for *fakeIface.
+func (iface *fakeIface) Addrs() (addrs []net.Addr, err error) {
+	return iface.addrs, iface.err
+}
+
+// newFakeIfaceByName returns an [ifaceByNameFunc] resolving the names from
+// ifaces.
+func newFakeIfaceByName(ifaces map[string]*fakeIface) (f ifaceByNameFunc) {
+	return func(name string) (iface aghnet.NetIface, err error) {
+		fi, ok := ifaces[name]
+		if !ok {
+			return nil, errors.Error("no such network interface")
+		}
+
+		return fi, nil
+	}
+}
+
+// newTestIPNet is a helper that returns an IP network with the given address
+// and a full-length mask.
+func newTestIPNet(ip net.IP) (n *net.IPNet) {
+	return &net.IPNet{
+		IP:   ip,
+		Mask: net.CIDRMask(len(ip)*8, len(ip)*8),
+	}
+}
+
+func TestNewIfaceBindings(t *testing.T) {
+	byName := newFakeIfaceByName(map[string]*fakeIface{
+		"eth0": {
+			addrs: []net.Addr{
+				newTestIPNet(net.ParseIP("2001:db8::1")),
+				newTestIPNet(net.IP{192, 0, 2, 1}),
+				newTestIPNet(net.IP{192, 0, 2, 2}),
+			},
+		},
+		"eth1": {
+			addrs: []net.Addr{newTestIPNet(net.ParseIP("2001:db8::2"))},
+		},
+		"eth2": {
+			err: errors.Error("test error"),
+		},
+	})
+
+	opts := &upstream.Options{}
+
+	testCases := []struct {
+		name       string
+		wantErrMsg string
+		confs      []InterfaceUpstream
+	}{{
+		name:       "success",
+		wantErrMsg: "",
+		confs: []InterfaceUpstream{{
+			Interface: "eth0",
+			Upstreams: []string{"192.0.2.53"},
+		}},
+	}, {
+		name: "unknown_iface",
+		wantErrMsg: `interface binding at index 0: interface "eth9": ` +
+			`no such network interface`,
+		confs: []InterfaceUpstream{{
+			Interface: "eth9",
+			Upstreams: []string{"192.0.2.53"},
+		}},
+	}, {
+		name:       "no_ipv4",
+		wantErrMsg: `interface binding at index 0: interface "eth1": no ipv4 addrs`,
+		confs: []InterfaceUpstream{{
+			Interface: "eth1",
+			Upstreams: []string{"192.0.2.53"},
+		}},
+	}, {
+		name: "addrs_error",
+		wantErrMsg: `interface binding at index 0: interface "eth2": ` +
+			`getting ipv4 addrs: test error`,
+		confs: []InterfaceUpstream{{
+			Interface: "eth2",
+			Upstreams: []string{"192.0.2.53"},
+		}},
+	}, {
+		name:       "no_upstreams",
+		wantErrMsg: `interface binding at index 0: interface "eth0": no upstreams`,
+		confs: []InterfaceUpstream{{
+			Interface: "eth0",
+		}},
+	}, {
+		name:       "duplicate",
+		wantErrMsg: `interface binding at index 1: duplicate interface "eth0"`,
+		confs: []InterfaceUpstream{{
+			Interface: "eth0",
+			Upstreams: []string{"192.0.2.53"},
+		}, {
+			Interface: "eth0",
+			Upstreams: []string{"192.0.2.54"},
+		}},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			bindings, err := newIfaceBindings(tc.confs, byName, opts, 0, false, nil)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+			t.Cleanup(func() { closeIfaceBindings(bindings, nil) })
+
+			if tc.wantErrMsg != "" {
+				return
+			}
+
+			require.Len(t, bindings, 1)
+
+			b := bindings[0]
+			assert.Equal(t, netip.MustParseAddr("192.0.2.1"), b.binderAddr)
+			assert.Equal(t, []netip.Addr{
+				netip.MustParseAddr("192.0.2.1"),
+				netip.MustParseAddr("192.0.2.2"),
+				netip.MustParseAddr("2001:db8::1"),
+			}, b.addrs)
+		})
+	}
+}
+
+func TestServer_setIfaceUpstream(t *testing.T) {
+	newUps := func(ip net.IP) (addr string) {
+		hdlr := dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+			resp := (&dns.Msg{}).SetReply(req)
+			resp.Answer = []dns.RR{&dns.A{
+				Hdr: dns.RR_Header{
+					Name:   req.Question[0].Name,
+					Rrtype: dns.TypeA,
+					Class:  dns.ClassINET,
+					Ttl:    60,
+				},
+				A: ip,
+			}}
+
+			require.NoError(testutil.PanicT{}, w.WriteMsg(resp))
+		})
+
+		return aghtest.StartLocalhostUpstream(t, hdlr).String()
+	}
+
+	defaultIP := net.IP{192, 0, 2, 1}
+	ifaceIP := net.IP{192, 0, 2, 2}
+
+	s := createTestServer(t, &filtering.Config{
+		BlockingMode: filtering.BlockingModeDefault,
+	}, ServerConfig{
+		UDPListenAddrs: []*net.UDPAddr{{IP: net.IP{127, 0, 0, 1}}},
+		TCPListenAddrs: []*net.TCPAddr{{IP: net.IP{127, 0, 0, 1}}},
+		Config: Config{
+			UpstreamDNS:      []string{newUps(defaultIP)},
+			UpstreamMode:     UpstreamModeLoadBalance,
+			EDNSClientSubnet: &EDNSClientSubnet{Enabled: false},
+		},
+		ServePlainDNS: true,
+	})
+
+	s.ifaceByName = newFakeIfaceByName(map[string]*fakeIface{
+		"lo": {
+			addrs: []net.Addr{newTestIPNet(net.IP{127, 0, 0, 1})},
+		},
+	})
+	s.conf.InterfaceBindings = []InterfaceUpstream{{
+		Interface: "lo",
+		Upstreams: []string{newUps(ifaceIP)},
+	}}
+
+	err := s.Prepare(&s.conf)
+	require.NoError(t, err)
+
+	startDeferStop(t, s)
+
+	t.Run("selection", func(t *testing.T) {
+		assert.NotNil(t, s.ifaceUpstreamConfig(netip.MustParseAddr("127.0.0.1")))
+		assert.Nil(t, s.ifaceUpstreamConfig(netip.MustParseAddr("127.0.0.2")))
+		assert.Nil(t, s.ifaceUpstreamConfig(netip.Addr{}))
+	})
+
+	for _, proto := range []proxy.Proto{proxy.ProtoUDP, proxy.ProtoTCP} {
+		t.Run(string(proto), func(t *testing.T) {
+			client := &dns.Client{
+				Net:     string(proto),
+				Timeout: dnsClientTimeout,
+			}
+
+			addr := s.dnsProxy.Addr(proto).String()
+			resp, _, exchErr := client.Exchange(createTestMessage("example.com."), addr)
+			require.NoError(t, exchErr)
+			require.Len(t, resp.Answer, 1)
+
+			a := testutil.RequireTypeAssert[*dns.A](t, resp.Answer[0])
+			assert.Equal(t, ifaceIP.To16(), a.A.To16())
+		})
+	}
+}
@@ -45,10 +45,42 @@ func ipsFromRules(resRules []*filtering.ResultRule) (ips []netip.Addr) {
 }
 
 // genDNSFilterMessage generates a filtered response to req for the filtering
-// result res.
+// result res.  The TTLs of the records are limited by the blocked-response TTL
+// of the filter lists that matched, if any.
 func (s *Server) genDNSFilterMessage(
 	dctx *proxy.DNSContext,
 	res *filtering.Result,
+) (resp *dns.Msg) {
+	resp = s.genDNSFilterResp(dctx, res)
+
+	if ttl, ok := s.dnsFilter.ListBlockedResponseTTL(res); ok {
+		limitTTL(resp, ttl)
+	}
+
+	return resp
+}
+
+// limitTTL sets the TTLs of the answer and authority records of resp, which
+// are greater than ttl, to ttl.
+func limitTTL(resp *dns.Msg, ttl uint32) {
+	if resp == nil {
+		return
+	}
+
+	for _, rrs := range [][]dns.RR{resp.Answer, resp.Ns} {
+		for _, rr := range rrs {
+			if h := rr.Header(); h.Ttl > ttl {
+				h.Ttl = ttl
+			}
+		}
+	}
+}
+
+// genDNSFilterResp generates a filtered response to req for the filtering
+// result res using the global blocked-response TTL.
+func (s *Server) genDNSFilterResp(
+	dctx *proxy.DNSContext,
+	res *filtering.Result,
 ) (resp *dns.Msg) {
 	req := dctx.Req
 	qt := req.Question[0].Qtype
@@ -63,9 +95,9 @@ func (s *Server) genDNSFilterMessage(
 
 	switch res.Reason {
 	case filtering.FilteredSafeBrowsing:
-		return s.genBlockedHost(req, s.dnsFilter.SafeBrowsingBlockHost(), dctx)
+		return s.genForSafeBrowsing(dctx, s.dnsFilter.SafeBrowsingBlockHost())
 	case filtering.FilteredParental:
-		return s.genBlockedHost(req, s.dnsFilter.ParentalBlockHost(), dctx)
+		return s.genForSafeBrowsing(dctx, s.dnsFilter.ParentalBlockHost())
 	case filtering.FilteredSafeSearch:
 		// If Safe Search generated the necessary IP addresses, use them.
 		// Otherwise, if there were no errors, there are no addresses for the
@@ -76,6 +108,23 @@ func (s *Server) genDNSFilterMessage(
 	}
 }
 
+// genForSafeBrowsing generates a response to the request blocked by
+// safe-browsing or parental control based on the server's blocking mode for
+// such requests.  blockHost is the host to respond with in the
+// [filtering.SafeBrowsingBlockingModeBlockHost] mode.
+func (s *Server) genForSafeBrowsing(dctx *proxy.DNSContext, blockHost string) (resp *dns.Msg) {
+	switch mode := s.dnsFilter.SafeBrowsingBlockingMode(); mode {
+	case filtering.SafeBrowsingBlockingModeBlockHost:
+		return s.genBlockedHost(dctx.Req, blockHost, dctx)
+	case filtering.SafeBrowsingBlockingModeBlockingMode:
+		return s.genForBlockingMode(dctx.Req, nil)
+	default:
+		log.Error("dnsforward: invalid safe browsing blocking mode %q", mode)
+
+		return s.genBlockedHost(dctx.Req, blockHost, dctx)
+	}
+}
+
 // getCNAMEWithIPs generates a filtered response to req for with CNAME record
 // and provided ips.
 func (s *Server) getCNAMEWithIPs(req *dns.Msg, ips []netip.Addr, cname string) (resp *dns.Msg) {
@@ -113,6 +162,13 @@ func (s *Server) getCNAMEWithIPs(req *dns.Msg, ips []netip.Addr, cname string) (
 // genForBlockingMode generates a filtered response to req based on the server's
 // blocking mode.
 func (s *Server) genForBlockingMode(req *dns.Msg, ips []netip.Addr) (resp *dns.Msg) {
+	if req.Question[0].Qtype == dns.TypeAAAA {
+		resp = s.genForAAAABlockingMode(req)
+		if resp != nil {
+			return resp
+		}
+	}
+
 	switch mode, bIPv4, bIPv6 := s.dnsFilter.BlockingMode(); mode {
 	case filtering.BlockingModeCustomIP:
 		return s.makeResponseCustomIP(req, bIPv4, bIPv6)
@@ -135,6 +191,26 @@ func (s *Server) genForBlockingMode(req *dns.Msg, ips []netip.Addr) (resp *dns.M
 	}
 }
 
+// genForAAAABlockingMode generates a filtered response to the AAAA request req
+// based on the server's blocking mode for AAAA requests.  resp is nil if the
+// response should be generated based on the general blocking mode.
+func (s *Server) genForAAAABlockingMode(req *dns.Msg) (resp *dns.Msg) {
+	switch mode := s.dnsFilter.AAAABlockingMode(); mode {
+	case filtering.AAAABlockingModeDefault:
+		return nil
+	case filtering.AAAABlockingModeNullIP:
+		return s.makeResponseNullIP(req)
+	case filtering.AAAABlockingModeNXDOMAIN:
+		return s.NewMsgNXDOMAIN(req)
+	case filtering.AAAABlockingModeNODATA:
+		return s.NewMsgNODATA(req)
+	default:
+		log.Error("dnsforward: invalid blocking mode for aaaa %q", mode)
+
+		return nil
+	}
+}
+
 // makeResponseCustomIP generates a DNS response message for Custom IP blocking
 // mode with the provided IP addresses and an appropriate resource record type.
 func (s *Server) makeResponseCustomIP(
@@ -223,6 +299,27 @@ func (s *Server) genAnswerSRV(req *dns.Msg, srv *rules.DNSSRV) (ans *dns.SRV) {
 	}
 }
 
+// genAnswersFromRewrite returns the answers for req from the records of the
+// legacy rewrite result res with the types other than A, AAAA, and CNAME.  The
+// records are owned by the canonical name of res, if any.
+func (s *Server) genAnswersFromRewrite(req *dns.Msg, res *filtering.Result) (ans []dns.RR) {
+	name := req.Question[0].Name
+	if res.CanonName != "" {
+		name = dns.Fqdn(res.CanonName)
+	}
+
+	for _, rr := range res.RewriteRRs {
+		a := dns.Copy(rr)
+		hdr := a.Header()
+		*hdr = s.hdr(req, rr.Header().Rrtype)
+		hdr.Name = name
+
+		ans = append(ans, a)
+	}
+
+	return ans
+}
+
 func (s *Server) genAnswerTXT(req *dns.Msg, strs []string) (ans *dns.TXT) {
 	return &dns.TXT{
 		Hdr: s.hdr(req, dns.TypeTXT),
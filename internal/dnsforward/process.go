@@ -6,6 +6,7 @@ import (
 	"encoding/binary"
 	"net"
 	"net/netip"
+	"slices"
 	"strings"
 	"time"
 
@@ -29,7 +30,8 @@ type dnsContext struct {
 	result *filtering.Result
 
 	// origResp is the response received from upstream.  It is set when the
-	// response is modified by filters.
+	// response is modified by filters or when the non-global IPv6 addresses
+	// are removed from it.
 	origResp *dns.Msg
 
 	// err is the error returned from a processing function.
@@ -56,11 +58,33 @@ type dnsContext struct {
 	// responseAD shows if the response had the AD bit set.
 	responseAD bool
 
+	// trace collects the details of the processing of the internal request
+	// made by the resolution debug API.  It is nil for the regular requests.
+	trace *resolveTrace
+
+	// failover is the tier of the failover upstream, which has answered the
+	// request.  It's nil unless the upstream mode is [UpstreamModeFailover].
+	failover *failoverAnswer
+
+	// ecsClientAddr is the address from the EDNS Client Subnet option received
+	// from a trusted proxy, which is used for matching the client instead of
+	// the transport source.  It's invalid if there is no such address.
+	ecsClientAddr netip.Addr
+
 	// isDHCPHost is true if the request for a local domain name and the DHCP is
 	// available for this request.
 	isDHCPHost bool
 }
 
+// clientAddr returns the address used for matching the client of the request.
+func (dctx *dnsContext) clientAddr() (addr netip.Addr) {
+	if dctx.ecsClientAddr.IsValid() {
+		return dctx.ecsClientAddr
+	}
+
+	return dctx.proxyCtx.Addr.Addr()
+}
+
 // resultCode is the result of a request processing function.
 type resultCode int
 
@@ -90,21 +114,44 @@ func (s *Server) handleDNSRequest(_ *proxy.Proxy, pctx *proxy.DNSContext) error
 		startTime: time.Now(),
 	}
 
+	err := s.processRequest(dctx)
+
+	respLen := 0
+	if pctx.Res != nil {
+		respLen = pctx.Res.Len()
+	}
+
+	s.sessions.track(pctx.Addr.Addr(), respLen)
+
+	return err
+}
+
+// processRequest runs the request of dctx through all the processing functions.
+func (s *Server) processRequest(dctx *dnsContext) (err error) {
+	pctx := dctx.proxyCtx
+
 	type modProcessFunc func(ctx *dnsContext) (rc resultCode)
 
 	// Since (*dnsforward.Server).handleDNSRequest(...) is used as
 	// proxy.(Config).RequestHandler, there is no need for additional index
 	// out of range checking in any of the following functions, because the
 	// (*proxy.Proxy).handleDNSRequest method performs it before calling the
-	// appropriate handler.
+	// appropriate handler.  The internal requests always have exactly one
+	// question.
 	mods := []modProcessFunc{
 		s.processInitial,
 		s.processDDRQuery,
 		s.processDHCPHosts,
 		s.processDHCPAddrs,
 		s.processFilteringBeforeRequest,
+		s.processLocalPTR,
 		s.processUpstream,
 		s.processFilteringAfterResponse,
+		s.processRebinding,
+		s.processNonGlobalIPv6,
+		s.processFlattenCNAME,
+		s.processResponseTTL,
+		s.processResponseSize,
 		s.ipset.process,
 		s.processQueryLogsAndStats,
 	}
@@ -178,6 +225,8 @@ func (s *Server) processInitial(dctx *dnsContext) (rc resultCode) {
 		return resultCodeFinish
 	}
 
+	s.processTrustedECS(dctx)
+
 	// Get the ClientID, if any, before getting client-specific filtering
 	// settings.
 	var key [8]byte
@@ -307,11 +356,9 @@ func (s *Server) makeDDRResponse(req *dns.Msg) (resp *dns.Msg) {
 	return resp
 }
 
-// processDHCPHosts respond to A requests if the target hostname is known to
-// the server.  It responds with a mapped IP address if the DNS64 is enabled and
-// the request is for AAAA.
-//
-// TODO(a.garipov): Adapt to AAAA as well.
+// processDHCPHosts responds to A and AAAA requests if the target hostname is
+// known to the server.  If the host has no IPv6 leases, it responds to AAAA
+// requests with the mapped IPv4 addresses if the DNS64 is enabled.
 func (s *Server) processDHCPHosts(dctx *dnsContext) (rc resultCode) {
 	log.Debug("dnsforward: started processing dhcp hosts")
 	defer log.Debug("dnsforward: finished processing dhcp hosts")
@@ -333,8 +380,8 @@ func (s *Server) processDHCPHosts(dctx *dnsContext) (rc resultCode) {
 		return resultCodeFinish
 	}
 
-	ip := s.dhcpServer.IPByHost(dhcpHost)
-	if ip == (netip.Addr{}) {
+	ips := s.dhcpServer.IPByHost(dhcpHost)
+	if len(ips) == 0 {
 		// Go on and process them with filters, including dnsrewrite ones, and
 		// possibly route them to a domain-specific upstream.
 		log.Debug("dnsforward: no dhcp record for %q", dhcpHost)
@@ -342,26 +389,14 @@ func (s *Server) processDHCPHosts(dctx *dnsContext) (rc resultCode) {
 		return resultCodeSuccess
 	}
 
-	log.Debug("dnsforward: dhcp record for %q is %s", dhcpHost, ip)
+	log.Debug("dnsforward: dhcp records for %q are %s", dhcpHost, ips)
 
 	resp := s.replyCompressed(req)
 	switch q.Qtype {
 	case dns.TypeA:
-		a := &dns.A{
-			Hdr: s.hdr(req, dns.TypeA),
-			A:   ip.AsSlice(),
-		}
-		resp.Answer = append(resp.Answer, a)
+		resp.Answer = s.appendDHCPA(resp.Answer, req, ips)
 	case dns.TypeAAAA:
-		if s.dns64Pref != (netip.Prefix{}) {
-			// Respond with DNS64-mapped address for IPv4 host if DNS64 is
-			// enabled.
-			aaaa := &dns.AAAA{
-				Hdr:  s.hdr(req, dns.TypeAAAA),
-				AAAA: s.mapDNS64(ip),
-			}
-			resp.Answer = append(resp.Answer, aaaa)
-		}
+		resp.Answer = s.appendDHCPAAAA(resp.Answer, req, ips)
 	default:
 		// Go on.
 	}
@@ -371,6 +406,51 @@ func (s *Server) processDHCPHosts(dctx *dnsContext) (rc resultCode) {
 	return resultCodeSuccess
 }
 
+// appendDHCPA appends the A records for the IPv4 addresses from ips to rrs and
+// returns the result.
+func (s *Server) appendDHCPA(rrs []dns.RR, req *dns.Msg, ips []netip.Addr) (res []dns.RR) {
+	for _, ip := range ips {
+		if ip.Is4() {
+			rrs = append(rrs, &dns.A{
+				Hdr: s.hdr(req, dns.TypeA),
+				A:   ip.AsSlice(),
+			})
+		}
+	}
+
+	return rrs
+}
+
+// appendDHCPAAAA appends the AAAA records for the IPv6 addresses from ips to
+// rrs and returns the result.  If there are none, and the DNS64 is enabled, it
+// appends the mapped IPv4 addresses instead.
+func (s *Server) appendDHCPAAAA(rrs []dns.RR, req *dns.Msg, ips []netip.Addr) (res []dns.RR) {
+	var v4 []netip.Addr
+	for _, ip := range ips {
+		if !ip.Is6() {
+			v4 = append(v4, ip)
+
+			continue
+		}
+
+		rrs = append(rrs, &dns.AAAA{
+			Hdr:  s.hdr(req, dns.TypeAAAA),
+			AAAA: ip.AsSlice(),
+		})
+	}
+
+	if len(v4) == len(ips) && s.dns64Pref != (netip.Prefix{}) {
+		for _, ip := range v4 {
+			rrs = append(rrs, &dns.AAAA{
+				Hdr:  s.hdr(req, dns.TypeAAAA),
+				AAAA: s.mapDNS64(ip),
+			})
+		}
+	}
+
+	return rrs
+}
+
 // processDHCPAddrs responds to PTR requests if the target IP is leased by the
 // DHCP server.
 func (s *Server) processDHCPAddrs(dctx *dnsContext) (rc resultCode) {
@@ -417,6 +497,38 @@ func (s *Server) processDHCPAddrs(dctx *dnsContext) (rc resultCode) {
 	return resultCodeSuccess
 }
 
+// processLocalPTR makes the local responses to the PTR requests for the
+// addresses from the locally-served networks authoritative, if
+// [ServerConfig.LocalPTRAuthoritative] is true.  Such requests without a local
+// response are responded with NXDOMAIN, so that they never reach the private
+// upstreams.
+func (s *Server) processLocalPTR(dctx *dnsContext) (rc resultCode) {
+	log.Debug("dnsforward: started processing local ptr")
+	defer log.Debug("dnsforward: finished processing local ptr")
+
+	pctx := dctx.proxyCtx
+	if !s.conf.LocalPTRAuthoritative ||
+		pctx.RequestedPrivateRDNS == (netip.Prefix{}) ||
+		pctx.Req.Question[0].Qtype != dns.TypePTR {
+		return resultCodeSuccess
+	}
+
+	if pctx.Res == nil {
+		if !s.conf.UsePrivateRDNS {
+			// The private upstreams aren't used anyway.
+			return resultCodeSuccess
+		}
+
+		log.Debug("dnsforward: no local data for %s", pctx.RequestedPrivateRDNS.Addr())
+
+		pctx.Res = s.NewMsgNXDOMAIN(pctx.Req)
+	}
+
+	pctx.Res.Authoritative = true
+
+	return resultCodeSuccess
+}
+
 // Apply filtering logic
 func (s *Server) processFilteringBeforeRequest(dctx *dnsContext) (rc resultCode) {
 	log.Debug("dnsforward: started processing filtering before req")
@@ -429,6 +541,8 @@ func (s *Server) processFilteringBeforeRequest(dctx *dnsContext) (rc resultCode)
 		dctx.setts.SafeBrowsingEnabled = false
 		dctx.setts.SafeSearchEnabled = false
 		dctx.setts.ServicesRules = nil
+		dctx.setts.PausedServicesRules = nil
+		dctx.setts.DefaultDeny = false
 	}
 
 	if dctx.proxyCtx.Res != nil {
@@ -446,6 +560,8 @@ func (s *Server) processFilteringBeforeRequest(dctx *dnsContext) (rc resultCode)
 		return resultCodeError
 	}
 
+	dctx.trace.addFiltering(traceStageRequest, dctx.result)
+
 	return resultCodeSuccess
 }
 
@@ -482,7 +598,15 @@ func (s *Server) processUpstream(dctx *dnsContext) (rc resultCode) {
 		return resultCodeFinish
 	}
 
-	s.setCustomUpstream(pctx, dctx.clientID)
+	s.setCustomUpstream(pctx, dctx.clientAddr(), dctx.clientID)
+	s.setViewUpstream(pctx, dctx.clientAddr())
+	s.setIfaceUpstream(pctx)
+	s.setGroupUpstream(pctx)
+
+	bypassCache := dctx.trace.bypassesCache()
+	if bypassCache {
+		s.bypassCaches(pctx)
+	}
 
 	reqWantsDNSSEC := s.setReqAD(req)
 
@@ -494,8 +618,33 @@ func (s *Server) processUpstream(dctx *dnsContext) (rc resultCode) {
 		return resultCodeError
 	}
 
-	if dctx.err = prx.Resolve(pctx); dctx.err != nil {
-		return resultCodeError
+	stale := s.staleResponses()
+	if bypassCache {
+		stale = nil
+	}
+
+	var staleKey []byte
+	if stale != nil {
+		// Calculate the key before resolving, since the proxy may modify the
+		// request.
+		staleKey = stale.key(req, pctx.CustomUpstreamConfig)
+	}
+
+	if tracker := s.failoverAnswers(); tracker != nil {
+		tracker.track(req)
+		defer func() { dctx.failover = tracker.untrack(req) }()
+	}
+
+	dctx.err = prx.Resolve(pctx)
+
+	// The proxy sets the subnet while resolving.
+	staleKey = withECS(staleKey, pctx.ReqECS)
+	if dctx.err != nil {
+		if !serveStale(stale, staleKey, dctx) {
+			return resultCodeError
+		}
+	} else if stale != nil && !pctx.Res.CheckingDisabled {
+		stale.set(staleKey, pctx.Res)
 	}
 
 	dctx.responseFromUpstream = true
@@ -506,6 +655,27 @@ func (s *Server) processUpstream(dctx *dnsContext) (rc resultCode) {
 	return resultCodeSuccess
 }
 
+// serveStale sets the expired response stored in c under key as the response
+// of dctx if there is one.  c may be nil.
+func serveStale(c *staleCache, key []byte, dctx *dnsContext) (ok bool) {
+	if c == nil {
+		return false
+	}
+
+	pctx := dctx.proxyCtx
+	resp := c.get(key, pctx.Req)
+	if resp == nil {
+		return false
+	}
+
+	log.Debug("dnsforward: serving stale response after upstream error: %s", dctx.err)
+
+	pctx.Res = resp
+	dctx.err = nil
+
+	return true
+}
+
 // setReqAD changes the request based on the server settings.  wantsDNSSEC is
 // false if the response should be cleared of the AD bit.
 //
@@ -563,22 +733,30 @@ func (s *Server) dhcpHostFromRequest(q *dns.Question) (reqHost string) {
 		return ""
 	}
 
-	reqHost = strings.ToLower(q.Name[:len(q.Name)-1])
-	if !netutil.IsImmediateSubdomain(reqHost, s.localDomainSuffix) {
+	return s.dhcpHostFromName(q.Name)
+}
+
+// dhcpHostFromName returns the hostname of a DHCP client from name, if it's an
+// immediate subdomain of the local domain, and an empty string otherwise.  name
+// may be fully-qualified.
+func (s *Server) dhcpHostFromName(name string) (host string) {
+	host = strings.ToLower(strings.TrimSuffix(name, "."))
+	if !netutil.IsImmediateSubdomain(host, s.localDomainSuffix) {
 		return ""
 	}
 
-	return reqHost[:len(reqHost)-len(s.localDomainSuffix)-1]
+	return host[:len(host)-len(s.localDomainSuffix)-1]
 }
 
-// setCustomUpstream sets custom upstream settings in pctx, if necessary.
-func (s *Server) setCustomUpstream(pctx *proxy.DNSContext, clientID string) {
-	if !pctx.Addr.IsValid() || s.conf.ClientsContainer == nil {
+// setCustomUpstream sets custom upstream settings in pctx, if necessary.  addr
+// is the address of the client.
+func (s *Server) setCustomUpstream(pctx *proxy.DNSContext, addr netip.Addr, clientID string) {
+	if !addr.IsValid() || s.conf.ClientsContainer == nil {
 		return
 	}
 
 	// Use the ClientID first, since it has a higher priority.
-	id := cmp.Or(clientID, pctx.Addr.Addr().String())
+	id := cmp.Or(clientID, addr.String())
 	upsConf, err := s.conf.ClientsContainer.UpstreamConfigByID(id, s.bootstrap)
 	if err != nil {
 		log.Error("dnsforward: getting custom upstreams for client %s: %s", id, err)
@@ -599,7 +777,7 @@ func (s *Server) processFilteringAfterResponse(dctx *dnsContext) (rc resultCode)
 	defer log.Debug("dnsforward: finished processing filtering after resp")
 
 	switch res := dctx.result; res.Reason {
-	case filtering.NotFilteredAllowList:
+	case filtering.NotFilteredAllowList, filtering.NotFilteredConnectivityCheck:
 		return resultCodeSuccess
 	case
 		filtering.Rewritten,
@@ -619,6 +797,8 @@ func (s *Server) processFilteringAfterResponse(dctx *dnsContext) (rc resultCode)
 		answer := append([]dns.RR{rr}, pctx.Res.Answer...)
 		pctx.Res.Answer = answer
 
+		s.flattenRewrittenCNAME(dctx)
+
 		return resultCodeSuccess
 	default:
 		return s.filterAfterResponse(dctx)
@@ -642,5 +822,205 @@ func (s *Server) filterAfterResponse(dctx *dnsContext) (res resultCode) {
 		return resultCodeError
 	}
 
+	dctx.trace.addFiltering(traceStageResponse, dctx.result)
+
+	return resultCodeSuccess
+}
+
+// processNonGlobalIPv6 removes the AAAA records with non-global IPv6 addresses
+// from the responses received from upstream servers, if configured.  The
+// original response is kept for the query log.  If all AAAA records have been
+// removed, the response is replaced with an empty NOERROR one.  Responses from
+// the local sources, such as rewrites and DHCP, aren't modified.
+func (s *Server) processNonGlobalIPv6(dctx *dnsContext) (rc resultCode) {
+	log.Debug("dnsforward: started processing non-global ipv6")
+	defer log.Debug("dnsforward: finished processing non-global ipv6")
+
+	if !s.conf.FilterNonGlobalIPv6 {
+		return resultCodeSuccess
+	}
+
+	pctx := dctx.proxyCtx
+	res := pctx.Res
+	if !dctx.responseFromUpstream || res == nil || dctx.result.IsFiltered {
+		return resultCodeSuccess
+	}
+
+	answer := slices.DeleteFunc(slices.Clone(res.Answer), isNonGlobalAAAA)
+	removed := len(res.Answer) - len(answer)
+	if removed == 0 {
+		return resultCodeSuccess
+	}
+
+	log.Debug(
+		"dnsforward: removed %d non-global ipv6 addresses for %q",
+		removed,
+		pctx.Req.Question[0].Name,
+	)
+
+	if dctx.origResp == nil {
+		dctx.origResp = res.Copy()
+	}
+
+	if slices.ContainsFunc(answer, isAAAA) {
+		res.Answer = answer
+	} else {
+		pctx.Res = s.reply(pctx.Req, dns.RcodeSuccess)
+	}
+
+	return resultCodeSuccess
+}
+
+// isAAAA returns true if rr is an AAAA record.
+func isAAAA(rr dns.RR) (ok bool) {
+	_, ok = rr.(*dns.AAAA)
+
+	return ok
+}
+
+// isNonGlobalAAAA returns true if rr is an AAAA record with a non-global IPv6
+// address.
+func isNonGlobalAAAA(rr dns.RR) (ok bool) {
+	aaaa, ok := rr.(*dns.AAAA)
+	if !ok {
+		return false
+	}
+
+	ip, ok := netip.AddrFromSlice(aaaa.AAAA)
+
+	return ok && isNonGlobalIPv6(ip)
+}
+
+// siteLocalPrefix is the deprecated site-local IPv6 prefix.  See RFC 3879.
+var siteLocalPrefix = netip.MustParsePrefix("fec0::/10")
+
+// isNonGlobalIPv6 returns true if ip is an IPv6 address that isn't reachable
+// globally, i.e. a unique local address, see RFC 4193, a link-local address,
+// or a deprecated site-local address.
+func isNonGlobalIPv6(ip netip.Addr) (ok bool) {
+	if !ip.Is6() || ip.Is4In6() {
+		return false
+	}
+
+	return ip.IsPrivate() || ip.IsLinkLocalUnicast() || siteLocalPrefix.Contains(ip)
+}
+
+// processResponseTTL clamps the TTL values of the resource records in the
+// response received from upstream servers according to the configured bounds.
+func (s *Server) processResponseTTL(dctx *dnsContext) (rc resultCode) {
+	log.Debug("dnsforward: started processing response ttl")
+	defer log.Debug("dnsforward: finished processing response ttl")
+
+	minTTL, maxTTL := s.conf.MinTTL, s.conf.MaxTTL
+	if minTTL == 0 && maxTTL == 0 {
+		return resultCodeSuccess
+	}
+
+	res := dctx.proxyCtx.Res
+	if !dctx.responseFromUpstream || res == nil || dctx.result.IsFiltered {
+		return resultCodeSuccess
+	}
+
+	clampTTL(res.Answer, minTTL, maxTTL)
+	clampTTL(res.Ns, minTTL, maxTTL)
+	clampTTL(res.Extra, minTTL, maxTTL)
+
+	return resultCodeSuccess
+}
+
+// rfc8482TTL is the TTL of the synthesized HINFO record in the minimal
+// responses to ANY queries.  See RFC 8482, Section 4.2.
+const rfc8482TTL = 3600
+
+// processResponseSize replaces the responses received from upstream servers
+// which exceed the maximum size configured for the query type.  The oversized
+// responses to ANY queries are replaced with the minimal responses described
+// in RFC 8482.  Other oversized responses are either truncated, if the query
+// has been received over UDP, or refused.
+func (s *Server) processResponseSize(dctx *dnsContext) (rc resultCode) {
+	log.Debug("dnsforward: started processing response size")
+	defer log.Debug("dnsforward: finished processing response size")
+
+	if len(s.respSizeLimits) == 0 {
+		return resultCodeSuccess
+	}
+
+	pctx := dctx.proxyCtx
+	res := pctx.Res
+	if !dctx.responseFromUpstream || res == nil || dctx.result.IsFiltered {
+		return resultCodeSuccess
+	}
+
+	req := pctx.Req
+	qt := req.Question[0].Qtype
+	limit, ok := s.respSizeLimits[qt]
+	if !ok {
+		return resultCodeSuccess
+	}
+
+	size := res.Len()
+	if size <= limit {
+		return resultCodeSuccess
+	}
+
+	log.Debug("dnsforward: response for %s of size %d exceeds %d", dns.Type(qt), size, limit)
+
+	switch {
+	case qt == dns.TypeANY:
+		pctx.Res = s.genRFC8482Response(req)
+	case s.conf.TruncateOversized && isDatagramProto(pctx.Proto):
+		pctx.Res = s.reply(req, dns.RcodeSuccess)
+		pctx.Res.Truncated = true
+	default:
+		pctx.Res = s.makeResponseREFUSED(req)
+	}
+
 	return resultCodeSuccess
 }
+
+// isDatagramProto returns true if the responses over proto are sent in
+// datagrams, so that the clients may retry the truncated ones over TCP.
+func isDatagramProto(proto proxy.Proto) (ok bool) {
+	return proto == proxy.ProtoUDP || proto == proxy.ProtoDNSCrypt
+}
+
+// genRFC8482Response returns the minimal response to the ANY query req which
+// contains a single synthesized HINFO record.  See RFC 8482, Section 4.2.
+func (s *Server) genRFC8482Response(req *dns.Msg) (resp *dns.Msg) {
+	resp = s.reply(req, dns.RcodeSuccess)
+	resp.Answer = []dns.RR{&dns.HINFO{
+		Hdr: dns.RR_Header{
+			Name:   req.Question[0].Name,
+			Rrtype: dns.TypeHINFO,
+			Class:  dns.ClassINET,
+			Ttl:    rfc8482TTL,
+		},
+		Cpu: "RFC8482",
+		Os:  "",
+	}}
+
+	return resp
+}
+
+// clampTTL sets the TTL of each resource record in rrs to be within the
+// [minTTL, maxTTL] range.  maxTTL of 0 means no upper bound.  The OPT
+// pseudo-records are skipped, since their TTL field has a different meaning.
+// The records with zero TTL are skipped as well, since they are intentionally
+// made non-cacheable.
+func clampTTL(rrs []dns.RR, minTTL, maxTTL uint32) {
+	for _, rr := range rrs {
+		if _, ok := rr.(*dns.OPT); ok {
+			continue
+		}
+
+		hdr := rr.Header()
+		if hdr.Ttl == 0 {
+			continue
+		}
+
+		hdr.Ttl = max(hdr.Ttl, minTTL)
+		if maxTTL != 0 {
+			hdr.Ttl = min(hdr.Ttl, maxTTL)
+		}
+	}
+}
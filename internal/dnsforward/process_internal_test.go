@@ -5,6 +5,8 @@ import (
 	"context"
 	"net"
 	"net/netip"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
@@ -212,6 +214,452 @@ func TestServer_ProcessFilteringAfterResponse(t *testing.T) {
 	}
 }
 
+func TestServer_ProcessResponseTTL(t *testing.T) {
+	t.Parallel()
+
+	const (
+		minTTL uint32 = 60
+		maxTTL uint32 = 3600
+	)
+
+	newAns := func(ttl uint32) (ans []dns.RR) {
+		return []dns.RR{&dns.A{
+			Hdr: dns.RR_Header{
+				Name:   aghtest.ReqFQDN,
+				Rrtype: dns.TypeA,
+				Class:  dns.ClassINET,
+				Ttl:    ttl,
+			},
+			A: net.IP{1, 2, 3, 4},
+		}}
+	}
+
+	testCases := []struct {
+		name         string
+		minTTL       uint32
+		maxTTL       uint32
+		ttl          uint32
+		wantTTL      uint32
+		fromUpstream bool
+	}{{
+		name:         "increase",
+		minTTL:       minTTL,
+		maxTTL:       maxTTL,
+		ttl:          1,
+		wantTTL:      minTTL,
+		fromUpstream: true,
+	}, {
+		name:         "increase_low",
+		minTTL:       minTTL,
+		maxTTL:       0,
+		ttl:          5,
+		wantTTL:      minTTL,
+		fromUpstream: true,
+	}, {
+		name:         "zero",
+		minTTL:       minTTL,
+		maxTTL:       maxTTL,
+		ttl:          0,
+		wantTTL:      0,
+		fromUpstream: true,
+	}, {
+		name:         "decrease",
+		minTTL:       minTTL,
+		maxTTL:       maxTTL,
+		ttl:          maxTTL * 2,
+		wantTTL:      maxTTL,
+		fromUpstream: true,
+	}, {
+		name:         "within",
+		minTTL:       minTTL,
+		maxTTL:       maxTTL,
+		ttl:          minTTL + 1,
+		wantTTL:      minTTL + 1,
+		fromUpstream: true,
+	}, {
+		name:         "no_max",
+		minTTL:       minTTL,
+		maxTTL:       0,
+		ttl:          maxTTL * 2,
+		wantTTL:      maxTTL * 2,
+		fromUpstream: true,
+	}, {
+		name:         "disabled",
+		minTTL:       0,
+		maxTTL:       0,
+		ttl:          1,
+		wantTTL:      1,
+		fromUpstream: true,
+	}, {
+		name:         "not_from_upstream",
+		minTTL:       minTTL,
+		maxTTL:       maxTTL,
+		ttl:          1,
+		wantTTL:      1,
+		fromUpstream: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			s := &Server{
+				conf: ServerConfig{
+					Config: Config{
+						MinTTL: tc.minTTL,
+						MaxTTL: tc.maxTTL,
+					},
+				},
+			}
+
+			req := createTestMessageWithType(aghtest.ReqFQDN, dns.TypeA)
+			resp := newResp(dns.RcodeSuccess, req, newAns(tc.ttl))
+			resp.SetEdns0(dns.DefaultMsgSize, false)
+
+			dctx := &dnsContext{
+				responseFromUpstream: tc.fromUpstream,
+				result:               &filtering.Result{},
+				proxyCtx: &proxy.DNSContext{
+					Req: req,
+					Res: resp,
+				},
+			}
+
+			gotRC := s.processResponseTTL(dctx)
+			assert.Equal(t, resultCodeSuccess, gotRC)
+
+			ans := dctx.proxyCtx.Res.Answer
+			require.Len(t, ans, 1)
+
+			assert.Equal(t, tc.wantTTL, ans[0].Header().Ttl)
+
+			// Make sure the OPT pseudo-record is left intact.
+			opt := dctx.proxyCtx.Res.IsEdns0()
+			require.NotNil(t, opt)
+
+			assert.Zero(t, opt.Hdr.Ttl)
+		})
+	}
+}
+
+func TestServer_ProcessNonGlobalIPv6(t *testing.T) {
+	t.Parallel()
+
+	const cnameTarget = "cname." + aghtest.ReqFQDN
+
+	newAAAA := func(ip string) (rr dns.RR) {
+		return &dns.AAAA{
+			Hdr: dns.RR_Header{
+				Name:   cnameTarget,
+				Rrtype: dns.TypeAAAA,
+				Class:  dns.ClassINET,
+				Ttl:    60,
+			},
+			AAAA: net.ParseIP(ip),
+		}
+	}
+
+	cname := &dns.CNAME{
+		Hdr: dns.RR_Header{
+			Name:   aghtest.ReqFQDN,
+			Rrtype: dns.TypeCNAME,
+			Class:  dns.ClassINET,
+			Ttl:    60,
+		},
+		Target: cnameTarget,
+	}
+
+	var (
+		global    = newAAAA("2001:db8::1")
+		ula       = newAAAA("fd00::1")
+		linkLocal = newAAAA("fe80::1")
+		siteLocal = newAAAA("fec0::1")
+		mapped    = newAAAA("::ffff:192.168.0.1")
+	)
+
+	testCases := []struct {
+		name         string
+		ans          []dns.RR
+		wantAns      []dns.RR
+		enabled      bool
+		fromUpstream bool
+		wantOrig     bool
+	}{{
+		name:         "mixed",
+		ans:          []dns.RR{cname, ula, global, linkLocal, siteLocal},
+		wantAns:      []dns.RR{cname, global},
+		enabled:      true,
+		fromUpstream: true,
+		wantOrig:     true,
+	}, {
+		name:         "all_non_global",
+		ans:          []dns.RR{cname, ula, linkLocal},
+		wantAns:      nil,
+		enabled:      true,
+		fromUpstream: true,
+		wantOrig:     true,
+	}, {
+		name:         "global_only",
+		ans:          []dns.RR{cname, global, mapped},
+		wantAns:      []dns.RR{cname, global, mapped},
+		enabled:      true,
+		fromUpstream: true,
+		wantOrig:     false,
+	}, {
+		name:         "disabled",
+		ans:          []dns.RR{ula, global},
+		wantAns:      []dns.RR{ula, global},
+		enabled:      false,
+		fromUpstream: true,
+		wantOrig:     false,
+	}, {
+		name:         "not_from_upstream",
+		ans:          []dns.RR{ula, global},
+		wantAns:      []dns.RR{ula, global},
+		enabled:      true,
+		fromUpstream: false,
+		wantOrig:     false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			s := &Server{
+				conf: ServerConfig{
+					Config: Config{
+						FilterNonGlobalIPv6: tc.enabled,
+					},
+				},
+			}
+
+			req := createTestMessageWithType(aghtest.ReqFQDN, dns.TypeAAAA)
+			resp := newResp(dns.RcodeSuccess, req, slices.Clone(tc.ans))
+
+			dctx := &dnsContext{
+				responseFromUpstream: tc.fromUpstream,
+				result:               &filtering.Result{},
+				proxyCtx: &proxy.DNSContext{
+					Req: req,
+					Res: resp,
+				},
+			}
+
+			gotRC := s.processNonGlobalIPv6(dctx)
+			assert.Equal(t, resultCodeSuccess, gotRC)
+
+			res := dctx.proxyCtx.Res
+			require.NotNil(t, res)
+
+			assert.Equal(t, dns.RcodeSuccess, res.Rcode)
+			assert.Equal(t, tc.wantAns, res.Answer)
+
+			if !tc.wantOrig {
+				assert.Nil(t, dctx.origResp)
+
+				return
+			}
+
+			require.NotNil(t, dctx.origResp)
+
+			assert.Equal(t, tc.ans, dctx.origResp.Answer)
+		})
+	}
+}
+
+func TestServer_ProcessResponseSize(t *testing.T) {
+	t.Parallel()
+
+	const maxSize = 512
+
+	hdr := func(qtype uint16) (h dns.RR_Header) {
+		return dns.RR_Header{
+			Name:   aghtest.ReqFQDN,
+			Rrtype: qtype,
+			Class:  dns.ClassINET,
+			Ttl:    60,
+		}
+	}
+
+	bigTXT := []dns.RR{&dns.TXT{
+		Hdr: hdr(dns.TypeTXT),
+		Txt: []string{strings.Repeat("a", 255), strings.Repeat("b", 255), "c"},
+	}}
+
+	ansA := []dns.RR{&dns.A{
+		Hdr: hdr(dns.TypeA),
+		A:   net.IP{1, 2, 3, 4},
+	}}
+
+	testCases := []struct {
+		ans           []dns.RR
+		name          string
+		proto         proxy.Proto
+		qtype         uint16
+		wantRcode     int
+		wantAnsLen    int
+		truncate      bool
+		wantTruncated bool
+	}{{
+		ans:           bigTXT,
+		name:          "oversized_txt_truncated",
+		proto:         proxy.ProtoUDP,
+		qtype:         dns.TypeTXT,
+		wantRcode:     dns.RcodeSuccess,
+		wantAnsLen:    0,
+		truncate:      true,
+		wantTruncated: true,
+	}, {
+		ans:           bigTXT,
+		name:          "oversized_txt_tcp",
+		proto:         proxy.ProtoTCP,
+		qtype:         dns.TypeTXT,
+		wantRcode:     dns.RcodeRefused,
+		wantAnsLen:    0,
+		truncate:      true,
+		wantTruncated: false,
+	}, {
+		ans:           bigTXT,
+		name:          "oversized_txt_refused",
+		proto:         proxy.ProtoUDP,
+		qtype:         dns.TypeTXT,
+		wantRcode:     dns.RcodeRefused,
+		wantAnsLen:    0,
+		truncate:      false,
+		wantTruncated: false,
+	}, {
+		ans:           append(slices.Clone(bigTXT), ansA...),
+		name:          "oversized_any",
+		proto:         proxy.ProtoUDP,
+		qtype:         dns.TypeANY,
+		wantRcode:     dns.RcodeSuccess,
+		wantAnsLen:    1,
+		truncate:      true,
+		wantTruncated: false,
+	}, {
+		ans:           ansA,
+		name:          "a_passes",
+		proto:         proxy.ProtoUDP,
+		qtype:         dns.TypeA,
+		wantRcode:     dns.RcodeSuccess,
+		wantAnsLen:    1,
+		truncate:      true,
+		wantTruncated: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			limits, err := newRespSizeLimits(map[string]uint16{
+				"any": maxSize,
+				"TXT": maxSize,
+			})
+			require.NoError(t, err)
+
+			s := &Server{
+				conf: ServerConfig{
+					Config: Config{
+						TruncateOversized: tc.truncate,
+					},
+				},
+				respSizeLimits: limits,
+			}
+
+			req := createTestMessageWithType(aghtest.ReqFQDN, tc.qtype)
+			dctx := &dnsContext{
+				responseFromUpstream: true,
+				result:               &filtering.Result{},
+				proxyCtx: &proxy.DNSContext{
+					Proto: tc.proto,
+					Req:   req,
+					Res:   newResp(dns.RcodeSuccess, req, tc.ans),
+				},
+			}
+
+			gotRC := s.processResponseSize(dctx)
+			assert.Equal(t, resultCodeSuccess, gotRC)
+
+			res := dctx.proxyCtx.Res
+			require.NotNil(t, res)
+
+			assert.Equal(t, tc.wantRcode, res.Rcode)
+			assert.Equal(t, tc.wantTruncated, res.Truncated)
+			assert.Len(t, res.Answer, tc.wantAnsLen)
+		})
+	}
+
+	t.Run("any_hinfo", func(t *testing.T) {
+		t.Parallel()
+
+		s := &Server{
+			respSizeLimits: map[uint16]int{dns.TypeANY: maxSize},
+		}
+
+		req := createTestMessageWithType(aghtest.ReqFQDN, dns.TypeANY)
+		dctx := &dnsContext{
+			responseFromUpstream: true,
+			result:               &filtering.Result{},
+			proxyCtx: &proxy.DNSContext{
+				Proto: proxy.ProtoUDP,
+				Req:   req,
+				Res:   newResp(dns.RcodeSuccess, req, bigTXT),
+			},
+		}
+
+		gotRC := s.processResponseSize(dctx)
+		assert.Equal(t, resultCodeSuccess, gotRC)
+
+		ans := dctx.proxyCtx.Res.Answer
+		require.Len(t, ans, 1)
+
+		hinfo := testutil.RequireTypeAssert[*dns.HINFO](t, ans[0])
+		assert.Equal(t, "RFC8482", hinfo.Cpu)
+	})
+}
+
+func TestNewRespSizeLimits(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		sizes      map[string]uint16
+		want       map[uint16]int
+		name       string
+		wantErrMsg string
+	}{{
+		sizes:      nil,
+		want:       nil,
+		name:       "empty",
+		wantErrMsg: "",
+	}, {
+		sizes:      map[string]uint16{"any": 512, "TXT": 1024},
+		want:       map[uint16]int{dns.TypeANY: 512, dns.TypeTXT: 1024},
+		name:       "valid",
+		wantErrMsg: "",
+	}, {
+		sizes:      map[string]uint16{"BAD": 512},
+		want:       nil,
+		name:       "bad_type",
+		wantErrMsg: `query type "BAD": unknown type`,
+	}, {
+		sizes:      map[string]uint16{"TXT": 0},
+		want:       nil,
+		name:       "zero_size",
+		wantErrMsg: `query type "TXT": size: not positive`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			limits, err := newRespSizeLimits(tc.sizes)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+
+			assert.Equal(t, tc.want, limits)
+		})
+	}
+}
+
 func TestServer_ProcessDDRQuery(t *testing.T) {
 	dohSVCB := &dns.SVCB{
 		Priority: 1,
@@ -390,12 +838,12 @@ func TestServer_ProcessDHCPHosts_localRestriction(t *testing.T) {
 	knownIP := netip.MustParseAddr("1.2.3.4")
 	dhcp := &testDHCP{
 		OnEnabled: func() (_ bool) { return true },
-		OnIPByHost: func(host string) (ip netip.Addr) {
+		OnIPByHost: func(host string) (ips []netip.Addr) {
 			if host == dhcpClient {
-				ip = knownIP
+				ips = []netip.Addr{knownIP}
 			}
 
-			return ip
+			return ips
 		},
 	}
 
@@ -494,11 +942,15 @@ func TestServer_ProcessDHCPHosts(t *testing.T) {
 		localTLD = "lan"
 
 		knownClient  = "example"
+		dualClient   = "dual"
 		externalHost = knownClient + ".com"
 		clientHost   = knownClient + "." + localTLD
+		dualHost     = dualClient + "." + localTLD
 	)
 
 	knownIP := netip.MustParseAddr("1.2.3.4")
+	dualIPv4 := netip.MustParseAddr("1.2.3.5")
+	dualIPv6 := netip.MustParseAddr("fd00::5")
 
 	testCases := []struct {
 		wantIP  netip.Addr
@@ -542,6 +994,20 @@ func TestServer_ProcessDHCPHosts(t *testing.T) {
 		suffix:  localTLD,
 		wantRes: resultCodeSuccess,
 		qtyp:    dns.TypeAAAA,
+	}, {
+		wantIP:  dualIPv4,
+		name:    "dual_a",
+		host:    dualHost,
+		suffix:  localTLD,
+		wantRes: resultCodeSuccess,
+		qtyp:    dns.TypeA,
+	}, {
+		wantIP:  dualIPv6,
+		name:    "dual_aaaa",
+		host:    dualHost,
+		suffix:  localTLD,
+		wantRes: resultCodeSuccess,
+		qtyp:    dns.TypeAAAA,
 	}, {
 		wantIP:  knownIP,
 		name:    "custom_suffix",
@@ -554,12 +1020,15 @@ func TestServer_ProcessDHCPHosts(t *testing.T) {
 	for _, tc := range testCases {
 		testDHCP := &testDHCP{
 			OnEnabled: func() (_ bool) { return true },
-			OnIPByHost: func(host string) (ip netip.Addr) {
-				if host == knownClient {
-					ip = knownIP
+			OnIPByHost: func(host string) (ips []netip.Addr) {
+				switch host {
+				case knownClient:
+					return []netip.Addr{knownIP}
+				case dualClient:
+					return []netip.Addr{dualIPv4, dualIPv6}
+				default:
+					return nil
 				}
-
-				return ip
 			},
 			OnHostByIP: func(ip netip.Addr) (host string) { panic("not implemented") },
 		}
@@ -596,12 +1065,23 @@ func TestServer_ProcessDHCPHosts(t *testing.T) {
 			require.NoError(t, dctx.err)
 
 			if tc.qtyp == dns.TypeAAAA {
-				// TODO(a.garipov): Remove this special handling
-				// when we fully support AAAA.
 				require.NotNil(t, pctx.Res)
 
 				ans := pctx.Res.Answer
-				require.Len(t, ans, 0)
+				if tc.wantIP == (netip.Addr{}) {
+					require.Empty(t, ans)
+
+					return
+				}
+
+				require.Len(t, ans, 1)
+
+				aaaa := testutil.RequireTypeAssert[*dns.AAAA](t, ans[0])
+
+				ip, err := netutil.IPToAddr(aaaa.AAAA, netutil.AddrFamilyIPv6)
+				require.NoError(t, err)
+
+				assert.Equal(t, tc.wantIP, ip)
 			} else if tc.wantIP == (netip.Addr{}) {
 				assert.Nil(t, pctx.Res)
 			} else {
@@ -0,0 +1,99 @@
+package dnsforward
+
+import (
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/miekg/dns"
+)
+
+// processRebinding replaces the responses received from upstream servers for
+// the public domain names, which contain the addresses from the locally-served
+// networks, with NXDOMAIN ones, if configured.  The original response is kept
+// for the query log.  The addresses from [Config.RebindingAllowlist] and the
+// responses for the local domain names are not affected.
+func (s *Server) processRebinding(dctx *dnsContext) (rc resultCode) {
+	log.Debug("dnsforward: started processing rebinding")
+	defer log.Debug("dnsforward: finished processing rebinding")
+
+	if !s.conf.RebindingProtection {
+		return resultCodeSuccess
+	}
+
+	pctx := dctx.proxyCtx
+	res := pctx.Res
+	if !dctx.responseFromUpstream || res == nil || dctx.result.IsFiltered {
+		return resultCodeSuccess
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(pctx.Req.Question[0].Name, "."))
+	if s.isLocalName(host) {
+		return resultCodeSuccess
+	}
+
+	ip, ok := s.rebindingAddr(res.Answer)
+	if !ok {
+		return resultCodeSuccess
+	}
+
+	log.Info("dnsforward: blocked dns rebinding attempt for %q: %s", host, ip)
+
+	if dctx.origResp == nil {
+		dctx.origResp = res.Copy()
+	}
+
+	pctx.Res = s.NewMsgNXDOMAIN(pctx.Req)
+
+	return resultCodeSuccess
+}
+
+// isLocalName returns true if host is the local domain name, localhost, or a
+// subdomain of either.  host must be lowercased and not fully qualified.
+func (s *Server) isLocalName(host string) (ok bool) {
+	for _, top := range []string{s.localDomainSuffix, "localhost"} {
+		if host == top || netutil.IsSubdomain(host, top) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// rebindingAddr returns the first address from the A and AAAA records of ans,
+// which belongs to a locally-served network and isn't allowlisted.
+func (s *Server) rebindingAddr(ans []dns.RR) (ip netip.Addr, ok bool) {
+	for _, rr := range ans {
+		var data []byte
+		switch rr := rr.(type) {
+		case *dns.A:
+			data = rr.A
+		case *dns.AAAA:
+			data = rr.AAAA
+		default:
+			continue
+		}
+
+		ip, ok = netip.AddrFromSlice(data)
+		if !ok {
+			continue
+		}
+
+		ip = ip.Unmap()
+		if netutil.IsLocallyServed(ip) && !s.isRebindingAllowed(ip) {
+			return ip, true
+		}
+	}
+
+	return netip.Addr{}, false
+}
+
+// isRebindingAllowed returns true if ip belongs to one of the networks from
+// [Config.RebindingAllowlist].
+func (s *Server) isRebindingAllowed(ip netip.Addr) (ok bool) {
+	return slices.ContainsFunc(s.conf.RebindingAllowlist, func(p netip.Prefix) (ok bool) {
+		return p.Contains(ip)
+	})
+}
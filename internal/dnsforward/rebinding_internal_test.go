@@ -0,0 +1,141 @@
+package dnsforward
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_ProcessRebinding(t *testing.T) {
+	t.Parallel()
+
+	const (
+		publicFQDN = "www.example.com."
+		localFQDN  = "myhost.lan."
+	)
+
+	newA := func(name, ip string) (rr dns.RR) {
+		return &dns.A{
+			Hdr: dns.RR_Header{
+				Name:   name,
+				Rrtype: dns.TypeA,
+				Class:  dns.ClassINET,
+				Ttl:    60,
+			},
+			A: net.ParseIP(ip),
+		}
+	}
+
+	testCases := []struct {
+		name      string
+		host      string
+		ip        string
+		allowlist []netip.Prefix
+		wantRcode int
+		enabled   bool
+	}{{
+		name:      "public_to_private",
+		host:      publicFQDN,
+		ip:        "192.168.1.1",
+		allowlist: nil,
+		wantRcode: dns.RcodeNameError,
+		enabled:   true,
+	}, {
+		name:      "public_to_loopback",
+		host:      publicFQDN,
+		ip:        "127.0.0.1",
+		allowlist: nil,
+		wantRcode: dns.RcodeNameError,
+		enabled:   true,
+	}, {
+		name:      "public_to_public",
+		host:      publicFQDN,
+		ip:        "94.140.14.14",
+		allowlist: nil,
+		wantRcode: dns.RcodeSuccess,
+		enabled:   true,
+	}, {
+		name:      "local_to_private",
+		host:      localFQDN,
+		ip:        "192.168.1.1",
+		allowlist: nil,
+		wantRcode: dns.RcodeSuccess,
+		enabled:   true,
+	}, {
+		name:      "allowlisted",
+		host:      publicFQDN,
+		ip:        "192.168.1.1",
+		allowlist: []netip.Prefix{netip.MustParsePrefix("192.168.1.0/24")},
+		wantRcode: dns.RcodeSuccess,
+		enabled:   true,
+	}, {
+		name:      "disabled",
+		host:      publicFQDN,
+		ip:        "192.168.1.1",
+		allowlist: nil,
+		wantRcode: dns.RcodeSuccess,
+		enabled:   false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			flt, err := filtering.New(&filtering.Config{
+				BlockingMode: filtering.BlockingModeDefault,
+			}, nil)
+			require.NoError(t, err)
+
+			s := &Server{
+				dnsFilter: flt,
+				conf: ServerConfig{
+					Config: Config{
+						RebindingProtection: tc.enabled,
+						RebindingAllowlist:  tc.allowlist,
+					},
+				},
+				localDomainSuffix: "lan",
+			}
+
+			req := createTestMessageWithType(tc.host, dns.TypeA)
+			ans := []dns.RR{newA(tc.host, tc.ip)}
+			resp := newResp(dns.RcodeSuccess, req, ans)
+
+			dctx := &dnsContext{
+				responseFromUpstream: true,
+				result:               &filtering.Result{},
+				proxyCtx: &proxy.DNSContext{
+					Req: req,
+					Res: resp,
+				},
+			}
+
+			gotRC := s.processRebinding(dctx)
+			assert.Equal(t, resultCodeSuccess, gotRC)
+
+			res := dctx.proxyCtx.Res
+			require.NotNil(t, res)
+
+			assert.Equal(t, tc.wantRcode, res.Rcode)
+
+			if tc.wantRcode == dns.RcodeSuccess {
+				assert.Equal(t, ans, res.Answer)
+				assert.Nil(t, dctx.origResp)
+
+				return
+			}
+
+			assert.Empty(t, res.Answer)
+
+			require.NotNil(t, dctx.origResp)
+
+			assert.Equal(t, ans, dctx.origResp.Answer)
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package dnsforward
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/miekg/dns"
+)
+
+// ReplayResult is the result of replaying a query.
+type ReplayResult struct {
+	// Result is the final filtering verdict.  It's never nil.
+	Result *filtering.Result
+
+	// Response is the final response.  It's never nil.
+	Response *dns.Msg
+
+	// Upstream is the address of the upstream, which has provided the
+	// response, if any.
+	Upstream string
+}
+
+// Replay resolves the question about name of qtype again through the whole
+// request processing pipeline, except for the access and rate limiting
+// checks, using the current settings and upstreams of the client.  client is
+// either the IP address or the ClientID of the client, the loopback address is
+// used if it's empty.  Neither the caches nor the stale responses are used, and
+// the query isn't added to the query log and statistics.
+func (s *Server) Replay(name string, qtype uint16, client string) (res *ReplayResult, err error) {
+	dctx, err := s.newReplayContext(name, qtype, client)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+
+	if !s.IsRunning() {
+		return nil, errors.Error("dns server is not running")
+	}
+
+	return s.replay(dctx)
+}
+
+// newReplayContext validates the query and returns the context of the internal
+// request for replaying it.
+func (s *Server) newReplayContext(
+	name string,
+	qtype uint16,
+	client string,
+) (dctx *dnsContext, err error) {
+	name = strings.TrimSuffix(name, ".")
+	err = netutil.ValidateDomainName(name)
+	if err != nil {
+		return nil, fmt.Errorf("name: %w", err)
+	}
+
+	addr, clientID, err := parseResolveDebugClient(client)
+	if err != nil {
+		return nil, fmt.Errorf("client: %w", err)
+	}
+
+	return s.newTraceContext(name, qtype, addr, clientID, &resolveTrace{noCache: true}), nil
+}
+
+// replay processes the internal request and returns the result.
+func (s *Server) replay(dctx *dnsContext) (res *ReplayResult, err error) {
+	err = s.processRequest(dctx)
+	if err != nil {
+		return nil, fmt.Errorf("resolving: %w", err)
+	}
+
+	pctx := dctx.proxyCtx
+	if pctx.Res == nil {
+		return nil, errors.Error("resolving: no response")
+	}
+
+	res = &ReplayResult{
+		Result:   dctx.result,
+		Response: pctx.Res,
+	}
+
+	if pctx.Upstream != nil {
+		res.Upstream = pctx.Upstream.Address()
+	}
+
+	return res, nil
+}
+
+// queryLogReplayReq is the request to the POST /control/querylog/replay HTTP
+// API.
+type queryLogReplayReq struct {
+	// Domain is the domain name of the logged query.
+	Domain string `json:"domain"`
+
+	// QType is the type of the question of the logged query, for example
+	// "AAAA".
+	QType string `json:"qtype"`
+
+	// Client is the IP address or the ClientID of the client of the logged
+	// query.
+	Client string `json:"client"`
+}
+
+// queryLogReplayResp is the response to the POST /control/querylog/replay HTTP
+// API.
+type queryLogReplayResp struct {
+	// Reason is the reason of the final filtering verdict, see
+	// [filtering.Reason].
+	Reason string `json:"reason"`
+
+	// ServiceName is the name of the blocked service, if any.
+	ServiceName string `json:"service_name,omitempty"`
+
+	// Rules are the matched rules.
+	Rules []*filteringRuleTraceJSON `json:"rules"`
+
+	// Answer are the records from the answer section of the response.
+	Answer []string `json:"answer"`
+
+	// Rcode is the response code of the response.
+	Rcode string `json:"rcode"`
+
+	// Upstream is the address of the upstream, which has provided the
+	// response, if any.
+	Upstream string `json:"upstream,omitempty"`
+
+	// IsFiltered is true if the verdict has changed the response.
+	IsFiltered bool `json:"is_filtered"`
+}
+
+// handleQueryLogReplay is the handler for the POST /control/querylog/replay
+// HTTP API.  It resolves the logged query again with the current settings, see
+// [Server.Replay].
+func (s *Server) handleQueryLogReplay(w http.ResponseWriter, r *http.Request) {
+	req := &queryLogReplayReq{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "reading req: %s", err)
+
+		return
+	}
+
+	qtype, ok := dns.StringToType[strings.ToUpper(req.QType)]
+	if !ok {
+		aghhttp.Error(r, w, http.StatusBadRequest, "qtype: bad value %q", req.QType)
+
+		return
+	}
+
+	dctx, err := s.newReplayContext(req.Domain, qtype, req.Client)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
+	if !s.IsRunning() {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "dns server is not running")
+
+		return
+	}
+
+	res, err := s.replay(dctx)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "%s", err)
+
+		return
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, newQueryLogReplayResp(res))
+}
+
+// newQueryLogReplayResp returns the response to the query replay request from
+// the result of the replay.
+func newQueryLogReplayResp(res *ReplayResult) (resp *queryLogReplayResp) {
+	fr := res.Result
+	resp = &queryLogReplayResp{
+		Reason:      fr.Reason.String(),
+		ServiceName: fr.ServiceName,
+		Rules:       make([]*filteringRuleTraceJSON, 0, len(fr.Rules)),
+		Answer:      make([]string, 0, len(res.Response.Answer)),
+		Rcode:       dns.RcodeToString[res.Response.Rcode],
+		Upstream:    res.Upstream,
+		IsFiltered:  fr.IsFiltered,
+	}
+
+	for _, r := range fr.Rules {
+		resp.Rules = append(resp.Rules, &filteringRuleTraceJSON{
+			Text:         r.Text,
+			FilterListID: int64(r.FilterListID),
+		})
+	}
+
+	for _, rr := range res.Response.Answer {
+		resp.Answer = append(resp.Answer, rr.String())
+	}
+
+	return resp
+}
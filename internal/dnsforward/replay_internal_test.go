@@ -0,0 +1,171 @@
+package dnsforward
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/AdGuardHome/internal/querylog"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// replayQueryLog is a [querylog.QueryLog] sending the logged queries into a
+// channel, since the queries are logged after the responses are written.
+type replayQueryLog struct {
+	// QueryLog is embedded here simply to make replayQueryLog a
+	// [querylog.QueryLog] without actually implementing all methods.
+	querylog.QueryLog
+
+	// params receives the parameters of the logged queries.
+	params chan *querylog.AddParams
+}
+
+// Add implements the [querylog.QueryLog] interface for *replayQueryLog.
+func (l *replayQueryLog) Add(p *querylog.AddParams) {
+	l.params <- p
+}
+
+// ShouldLog implements the [querylog.QueryLog] interface for *replayQueryLog.
+func (l *replayQueryLog) ShouldLog(string, uint16, uint16, []string) bool {
+	return true
+}
+
+func TestServer_HandleQueryLogReplay(t *testing.T) {
+	var reqNum, cdNum atomic.Uint32
+	hdlr := dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		reqNum.Add(1)
+		if req.CheckingDisabled {
+			cdNum.Add(1)
+		}
+
+		resp := (&dns.Msg{}).SetReply(req)
+		resp.Answer = []dns.RR{&dns.A{
+			Hdr: dns.RR_Header{
+				Name:   req.Question[0].Name,
+				Rrtype: dns.TypeA,
+				Class:  dns.ClassINET,
+				Ttl:    60,
+			},
+			A: net.IP{94, 140, 14, 14},
+		}}
+
+		require.NoError(testutil.PanicT{}, w.WriteMsg(resp))
+	})
+	upsAddr := aghtest.StartLocalhostUpstream(t, hdlr).String()
+
+	filterConf := &filtering.Config{
+		ProtectionEnabled: true,
+		FilteringEnabled:  true,
+		BlockingMode:      filtering.BlockingModeDefault,
+	}
+	s := createTestServer(t, filterConf, ServerConfig{
+		UDPListenAddrs: []*net.UDPAddr{{}},
+		TCPListenAddrs: []*net.TCPAddr{{}},
+		Config: Config{
+			UpstreamDNS:      []string{upsAddr},
+			UpstreamMode:     UpstreamModeLoadBalance,
+			EDNSClientSubnet: &EDNSClientSubnet{Enabled: false},
+			CacheSize:        4096,
+		},
+		ServePlainDNS: true,
+	})
+
+	ql := &replayQueryLog{
+		params: make(chan *querylog.AddParams, 1),
+	}
+	s.queryLog = ql
+
+	startDeferStop(t, s)
+
+	const domain = "nxdomain.example.org"
+
+	req := (&dns.Msg{}).SetQuestion(dns.Fqdn(domain), dns.TypeA)
+	addr := s.dnsProxy.Addr(proxy.ProtoUDP).String()
+
+	_, err := dns.Exchange(req, addr)
+	require.NoError(t, err)
+
+	logged, _ := testutil.RequireReceive(t, ql.params, testTimeout)
+	require.NotNil(t, logged)
+	require.NotNil(t, logged.Result)
+	require.True(t, logged.Result.IsFiltered)
+
+	replay := func(t *testing.T) (resp *queryLogReplayResp) {
+		t.Helper()
+
+		body, mErr := json.Marshal(&queryLogReplayReq{
+			Domain: domain,
+			QType:  dns.TypeToString[logged.Question.Question[0].Qtype],
+			Client: logged.ClientIP.String(),
+		})
+		require.NoError(t, mErr)
+
+		r := httptest.NewRequest(
+			http.MethodPost,
+			"/control/querylog/replay",
+			bytes.NewReader(body),
+		)
+		w := httptest.NewRecorder()
+
+		s.handleQueryLogReplay(w, r)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		resp = &queryLogReplayResp{}
+		err = json.NewDecoder(w.Body).Decode(resp)
+		require.NoError(t, err)
+
+		return resp
+	}
+
+	resp := replay(t)
+	assert.Equal(t, filtering.FilteredBlockList.String(), resp.Reason)
+	assert.True(t, resp.IsFiltered)
+	assert.Empty(t, resp.Upstream)
+
+	require.Len(t, resp.Rules, 1)
+	assert.Equal(t, "||nxdomain.example.org", resp.Rules[0].Text)
+
+	const allowRule = "@@||nxdomain.example.org^"
+
+	filterConf.UserRules = []string{allowRule}
+	s.dnsFilter.EnableFilters(false)
+
+	resp = replay(t)
+	assert.Equal(t, filtering.NotFilteredAllowList.String(), resp.Reason)
+	assert.False(t, resp.IsFiltered)
+	assert.NotEmpty(t, resp.Upstream)
+
+	require.Len(t, resp.Rules, 1)
+	assert.Equal(t, allowRule, resp.Rules[0].Text)
+
+	require.Len(t, resp.Answer, 1)
+	assert.Contains(t, resp.Answer[0], "94.140.14.14")
+
+	// Make sure the replay is neither logged nor cached.
+	assert.Empty(t, ql.params)
+
+	// Cache the response.
+	_, err = dns.Exchange(req, addr)
+	require.NoError(t, err)
+
+	_, _ = testutil.RequireReceive(t, ql.params, testTimeout)
+
+	before := reqNum.Load()
+	resp = replay(t)
+	assert.NotEmpty(t, resp.Upstream)
+	assert.Empty(t, ql.params)
+	assert.Equal(t, before+1, reqNum.Load())
+
+	// Make sure the caches are bypassed without changing the request.
+	assert.Zero(t, cdNum.Load())
+}
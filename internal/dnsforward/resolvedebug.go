@@ -0,0 +1,421 @@
+package dnsforward
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/miekg/dns"
+)
+
+// Filtering stages of the resolution trace.
+const (
+	traceStageRequest  = "request"
+	traceStageResponse = "response"
+)
+
+// Sources of the final response in the resolution trace.
+const (
+	traceSourceCache    = "cache"
+	traceSourceLocal    = "local"
+	traceSourceUpstream = "upstream"
+)
+
+// resolveTrace collects the details of the processing of an internal request
+// made by the resolution debug API.  A nil *resolveTrace is valid and collects
+// nothing.
+type resolveTrace struct {
+	// filtering are the filtering verdicts in the order of the stages.
+	filtering []*filteringTraceJSON
+
+	// rewrites are the rewrites applied to the request.
+	rewrites []*rewriteTraceJSON
+
+	// log, if true, means that the request should be added to the query log
+	// and statistics like any other request.
+	log bool
+
+	// noCache, if true, means that neither the caches of the upstreams nor the
+	// stale responses should be used for the request.
+	noCache bool
+}
+
+// bypassesCache returns true if the caches shouldn't be used for the request.
+// It's safe to call on a nil t.
+func (t *resolveTrace) bypassesCache() (ok bool) {
+	return t != nil && t.noCache
+}
+
+// addFiltering records the filtering verdict res made at the stage.  It's safe
+// to call on a nil t.
+func (t *resolveTrace) addFiltering(stage string, res *filtering.Result) {
+	if t == nil || res == nil {
+		return
+	}
+
+	rules := make([]*filteringRuleTraceJSON, 0, len(res.Rules))
+	for _, r := range res.Rules {
+		rules = append(rules, &filteringRuleTraceJSON{
+			Text:         r.Text,
+			FilterListID: int64(r.FilterListID),
+		})
+	}
+
+	t.filtering = append(t.filtering, &filteringTraceJSON{
+		Stage:       stage,
+		Reason:      res.Reason.String(),
+		ServiceName: res.ServiceName,
+		Rules:       rules,
+		IsFiltered:  res.IsFiltered,
+	})
+
+	if !res.Reason.In(
+		filtering.Rewritten,
+		filtering.RewrittenAutoHosts,
+		filtering.RewrittenRule,
+		filtering.FilteredSafeSearch,
+	) {
+		return
+	}
+
+	ips := make([]string, 0, len(res.IPList))
+	for _, ip := range res.IPList {
+		ips = append(ips, ip.String())
+	}
+
+	t.rewrites = append(t.rewrites, &rewriteTraceJSON{
+		Stage:     stage,
+		Reason:    res.Reason.String(),
+		CanonName: res.CanonName,
+		IPs:       ips,
+	})
+}
+
+// resolveDebugReq is the request to the POST /control/dns/resolve_debug HTTP
+// API.
+type resolveDebugReq struct {
+	// Name is the domain name to resolve.
+	Name string `json:"name"`
+
+	// Type is the type of the question, for example "AAAA".  If empty, "A" is
+	// used.
+	Type string `json:"type"`
+
+	// Client is the IP address or the ClientID of the client, the settings of
+	// which are used for the resolution.  If empty, the loopback address is
+	// used.
+	Client string `json:"client"`
+
+	// Log, if true, makes the request added to the query log and statistics.
+	Log bool `json:"log"`
+}
+
+// resolveDebugResp is the response to the POST /control/dns/resolve_debug HTTP
+// API.
+type resolveDebugResp struct {
+	// Filtering are the filtering verdicts in the order of the stages.
+	Filtering []*filteringTraceJSON `json:"filtering"`
+
+	// Rewrites are the rewrites applied to the request.
+	Rewrites []*rewriteTraceJSON `json:"rewrites"`
+
+	// Upstreams are the upstreams queried during the resolution, if any.
+	Upstreams []*upstreamTraceJSON `json:"upstreams"`
+
+	// Answer are the records from the answer section of the final response.
+	Answer []string `json:"answer"`
+
+	// ClientID is the ClientID used for the resolution, if any.
+	ClientID string `json:"client_id,omitempty"`
+
+	// Rcode is the response code of the final response.
+	Rcode string `json:"rcode"`
+
+	// Source is the source of the final response, either "cache", "local",
+	// or "upstream".
+	Source string `json:"source"`
+
+	// Upstream is the address of the upstream, which has provided the final
+	// response, if any.
+	Upstream string `json:"upstream,omitempty"`
+
+	// ElapsedMs is the duration of the whole resolution in milliseconds.
+	ElapsedMs float64 `json:"elapsed_ms"`
+
+	// Cached is true if the response has been served from the cache.
+	Cached bool `json:"cached"`
+}
+
+// filteringTraceJSON is a single filtering verdict in the resolution trace.
+type filteringTraceJSON struct {
+	// Stage is either "request" or "response".
+	Stage string `json:"stage"`
+
+	// Reason is the reason of the verdict, see [filtering.Reason].
+	Reason string `json:"reason"`
+
+	// ServiceName is the name of the blocked service, if any.
+	ServiceName string `json:"service_name,omitempty"`
+
+	// Rules are the matched rules.
+	Rules []*filteringRuleTraceJSON `json:"rules"`
+
+	// IsFiltered is true if the verdict has changed the response.
+	IsFiltered bool `json:"is_filtered"`
+}
+
+// filteringRuleTraceJSON is a matched rule in the resolution trace.
+type filteringRuleTraceJSON struct {
+	// Text is the text of the rule.
+	Text string `json:"text"`
+
+	// FilterListID is the ID of the filter list containing the rule.
+	FilterListID int64 `json:"filter_list_id"`
+}
+
+// rewriteTraceJSON is an applied rewrite in the resolution trace.
+type rewriteTraceJSON struct {
+	// Stage is the filtering stage at which the rewrite has been applied.
+	Stage string `json:"stage"`
+
+	// Reason is the kind of the rewrite, see [filtering.Reason].
+	Reason string `json:"reason"`
+
+	// CanonName is the new canonical name, if any.
+	CanonName string `json:"canonical_name,omitempty"`
+
+	// IPs are the addresses the name has been rewritten to, if any.
+	IPs []string `json:"ips"`
+}
+
+// upstreamTraceJSON is a queried upstream in the resolution trace.
+type upstreamTraceJSON struct {
+	// Address is the address of the upstream.
+	Address string `json:"address"`
+
+	// Error is the error returned by the upstream, if any.
+	Error string `json:"error,omitempty"`
+
+	// RTTMs is the duration of the successful exchange with the upstream in
+	// milliseconds.
+	RTTMs float64 `json:"rtt_ms"`
+
+	// Fallback is true if the upstream is a fallback one.
+	Fallback bool `json:"fallback"`
+}
+
+// handleResolveDebug is the handler for the POST /control/dns/resolve_debug
+// HTTP API.  It resolves the name through the whole request processing
+// pipeline, except for the access and rate limiting checks, and responds with
+// the trace of the resolution.
+func (s *Server) handleResolveDebug(w http.ResponseWriter, r *http.Request) {
+	req := &resolveDebugReq{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "reading req: %s", err)
+
+		return
+	}
+
+	dctx, err := s.newResolveDebugContext(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
+	if !s.IsRunning() {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "dns server is not running")
+
+		return
+	}
+
+	err = s.processRequest(dctx)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "resolving: %s", err)
+
+		return
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, newResolveDebugResp(dctx))
+}
+
+// newResolveDebugContext validates req and returns the context of the internal
+// request for it.
+func (s *Server) newResolveDebugContext(req *resolveDebugReq) (dctx *dnsContext, err error) {
+	name := strings.TrimSuffix(req.Name, ".")
+	err = netutil.ValidateDomainName(name)
+	if err != nil {
+		return nil, fmt.Errorf("name: %w", err)
+	}
+
+	qtype := dns.TypeA
+	if req.Type != "" {
+		var ok bool
+		qtype, ok = dns.StringToType[strings.ToUpper(req.Type)]
+		if !ok {
+			return nil, fmt.Errorf("type: bad value %q", req.Type)
+		}
+	}
+
+	addr, clientID, err := parseResolveDebugClient(req.Client)
+	if err != nil {
+		return nil, fmt.Errorf("client: %w", err)
+	}
+
+	return s.newTraceContext(name, qtype, addr, clientID, &resolveTrace{log: req.Log}), nil
+}
+
+// newTraceContext returns the context of the internal request for the question
+// about name of qtype made by the client with addr and clientID.  name must be
+// a valid domain name.  trace must not be nil.
+func (s *Server) newTraceContext(
+	name string,
+	qtype uint16,
+	addr netip.Addr,
+	clientID string,
+	trace *resolveTrace,
+) (dctx *dnsContext) {
+	pctx := &proxy.DNSContext{
+		Req:             (&dns.Msg{}).SetQuestion(dns.Fqdn(name), qtype),
+		Proto:           proxy.ProtoTCP,
+		Addr:            netip.AddrPortFrom(addr, 0),
+		RequestID:       rand.Uint64(),
+		IsPrivateClient: s.privateNets != nil && s.privateNets.Contains(addr),
+	}
+	pctx.Req.RecursionDesired = true
+
+	if qtype == dns.TypePTR && s.privateNets != nil {
+		pref, extractErr := netutil.ExtractReversedAddr(pctx.Req.Question[0].Name)
+		if extractErr == nil && s.privateNets.Contains(pref.Addr()) {
+			pctx.RequestedPrivateRDNS = pref
+		}
+	}
+
+	if clientID != "" {
+		// Use the same way of passing the ClientID as the regular requests do,
+		// see [Server.HandleBefore].
+		key := [8]byte{}
+		binary.BigEndian.PutUint64(key[:], pctx.RequestID)
+		s.clientIDCache.Set(key[:], []byte(clientID))
+	}
+
+	return &dnsContext{
+		proxyCtx:  pctx,
+		result:    &filtering.Result{},
+		startTime: time.Now(),
+		trace:     trace,
+	}
+}
+
+// parseResolveDebugClient parses the client identity from the resolution debug
+// request.  addr is the loopback address if client isn't an IP address.
+func parseResolveDebugClient(client string) (addr netip.Addr, clientID string, err error) {
+	if client == "" {
+		return netip.AddrFrom4([4]byte{127, 0, 0, 1}), "", nil
+	}
+
+	addr, err = netip.ParseAddr(client)
+	if err == nil {
+		return addr.Unmap(), "", nil
+	}
+
+	err = ValidateClientID(client)
+	if err != nil {
+		return netip.Addr{}, "", errors.Error("not an ip address or a valid clientid")
+	}
+
+	return netip.AddrFrom4([4]byte{127, 0, 0, 1}), client, nil
+}
+
+// newResolveDebugResp returns the response to the resolution debug request
+// from the processed dctx.
+func newResolveDebugResp(dctx *dnsContext) (resp *resolveDebugResp) {
+	pctx := dctx.proxyCtx
+	trace := dctx.trace
+
+	resp = &resolveDebugResp{
+		Filtering: trace.filtering,
+		Rewrites:  trace.rewrites,
+		Upstreams: []*upstreamTraceJSON{},
+		Answer:    []string{},
+		ClientID:  dctx.clientID,
+		Source:    traceSourceLocal,
+		ElapsedMs: time.Since(dctx.startTime).Seconds() * 1000,
+	}
+
+	if resp.Filtering == nil {
+		resp.Filtering = []*filteringTraceJSON{}
+	}
+
+	if resp.Rewrites == nil {
+		resp.Rewrites = []*rewriteTraceJSON{}
+	}
+
+	if res := pctx.Res; res != nil {
+		resp.Rcode = dns.RcodeToString[res.Rcode]
+		for _, rr := range res.Answer {
+			resp.Answer = append(resp.Answer, rr.String())
+		}
+	}
+
+	if dctx.responseFromUpstream {
+		resp.Source = traceSourceUpstream
+	}
+
+	if pctx.Upstream != nil {
+		resp.Upstream = pctx.Upstream.Address()
+	}
+
+	qs := pctx.QueryStatistics()
+	if qs == nil {
+		return resp
+	}
+
+	if ms := qs.Main(); len(ms) == 1 && ms[0].IsCached {
+		resp.Source = traceSourceCache
+		resp.Upstream = ms[0].Address
+		resp.Cached = true
+
+		return resp
+	}
+
+	resp.Upstreams = appendUpstreamTraces(resp.Upstreams, qs.Main(), false)
+	resp.Upstreams = appendUpstreamTraces(resp.Upstreams, qs.Fallback(), true)
+
+	return resp
+}
+
+// appendUpstreamTraces appends the traces of the upstreams from stats to
+// traces and returns the result.
+func appendUpstreamTraces(
+	traces []*upstreamTraceJSON,
+	stats []*proxy.UpstreamStatistics,
+	fallback bool,
+) (res []*upstreamTraceJSON) {
+	for _, st := range stats {
+		t := &upstreamTraceJSON{
+			Address:  st.Address,
+			RTTMs:    st.QueryDuration.Seconds() * 1000,
+			Fallback: fallback,
+		}
+
+		if st.Error != nil {
+			t.Error = st.Error.Error()
+		}
+
+		traces = append(traces, t)
+	}
+
+	return traces
+}
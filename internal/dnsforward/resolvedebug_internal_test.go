@@ -0,0 +1,160 @@
+package dnsforward
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_HandleResolveDebug(t *testing.T) {
+	hdlr := dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		resp := (&dns.Msg{}).SetReply(req)
+		resp.Answer = []dns.RR{&dns.A{
+			Hdr: dns.RR_Header{
+				Name:   req.Question[0].Name,
+				Rrtype: dns.TypeA,
+				Class:  dns.ClassINET,
+				Ttl:    60,
+			},
+			A: net.IP{94, 140, 14, 14},
+		}}
+
+		require.NoError(testutil.PanicT{}, w.WriteMsg(resp))
+	})
+	upsAddr := aghtest.StartLocalhostUpstream(t, hdlr).String()
+
+	s := createTestServer(t, &filtering.Config{
+		ProtectionEnabled: true,
+		BlockingMode:      filtering.BlockingModeDefault,
+		Rewrites: []*filtering.LegacyRewrite{{
+			Domain: "alias.example.com",
+			Answer: "www.example.com",
+			Type:   dns.TypeCNAME,
+		}},
+	}, ServerConfig{
+		UDPListenAddrs: []*net.UDPAddr{{}},
+		TCPListenAddrs: []*net.TCPAddr{{}},
+		Config: Config{
+			UpstreamDNS:      []string{upsAddr},
+			UpstreamMode:     UpstreamModeLoadBalance,
+			EDNSClientSubnet: &EDNSClientSubnet{Enabled: false},
+			CacheSize:        4096,
+		},
+		ServePlainDNS: true,
+	})
+	startDeferStop(t, s)
+
+	ql := &testQueryLog{}
+	s.queryLog = ql
+
+	resolve := func(t *testing.T, body string) (resp *resolveDebugResp) {
+		t.Helper()
+
+		r := httptest.NewRequest(
+			http.MethodPost,
+			"/control/dns/resolve_debug",
+			bytes.NewBufferString(body),
+		)
+		w := httptest.NewRecorder()
+
+		s.handleResolveDebug(w, r)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		resp = &resolveDebugResp{}
+		err := json.NewDecoder(w.Body).Decode(resp)
+		require.NoError(t, err)
+
+		return resp
+	}
+
+	t.Run("upstream_then_cache", func(t *testing.T) {
+		resp := resolve(t, `{"name":"www.example.com","type":"A"}`)
+
+		assert.Equal(t, traceSourceUpstream, resp.Source)
+		assert.Equal(t, "NOERROR", resp.Rcode)
+		assert.False(t, resp.Cached)
+
+		require.Len(t, resp.Answer, 1)
+		assert.Contains(t, resp.Answer[0], "94.140.14.14")
+
+		require.Len(t, resp.Upstreams, 1)
+		assert.NotEmpty(t, resp.Upstreams[0].Address)
+		assert.Empty(t, resp.Upstreams[0].Error)
+
+		require.Len(t, resp.Filtering, 2)
+		assert.Equal(t, traceStageRequest, resp.Filtering[0].Stage)
+		assert.False(t, resp.Filtering[0].IsFiltered)
+
+		resp = resolve(t, `{"name":"www.example.com","type":"A"}`)
+
+		assert.Equal(t, traceSourceCache, resp.Source)
+		assert.True(t, resp.Cached)
+		assert.Empty(t, resp.Upstreams)
+
+		assert.Nil(t, ql.lastParams)
+	})
+
+	t.Run("blocked", func(t *testing.T) {
+		resp := resolve(t, `{"name":"nxdomain.example.org","client":"192.0.2.1"}`)
+
+		assert.Equal(t, traceSourceLocal, resp.Source)
+		assert.Empty(t, resp.Upstreams)
+
+		require.Len(t, resp.Filtering, 1)
+
+		f := resp.Filtering[0]
+		assert.Equal(t, traceStageRequest, f.Stage)
+		assert.Equal(t, filtering.FilteredBlockList.String(), f.Reason)
+		assert.True(t, f.IsFiltered)
+
+		require.Len(t, f.Rules, 1)
+		assert.Equal(t, "||nxdomain.example.org", f.Rules[0].Text)
+
+		assert.Nil(t, ql.lastParams)
+	})
+
+	t.Run("rewrite", func(t *testing.T) {
+		resp := resolve(t, `{"name":"alias.example.com","log":true}`)
+
+		require.Len(t, resp.Rewrites, 1)
+
+		rw := resp.Rewrites[0]
+		assert.Equal(t, filtering.Rewritten.String(), rw.Reason)
+		assert.Equal(t, "www.example.com", rw.CanonName)
+
+		require.Len(t, resp.Answer, 2)
+		assert.Contains(t, resp.Answer[0], "CNAME")
+		assert.Contains(t, resp.Answer[1], "94.140.14.14")
+
+		require.NotNil(t, ql.lastParams)
+		assert.Equal(t, "alias.example.com.", ql.lastParams.Question.Question[0].Name)
+	})
+
+	t.Run("bad_request", func(t *testing.T) {
+		for _, body := range []string{
+			`{"name":""}`,
+			`{"name":"example.com","type":"BAD"}`,
+			`{"name":"example.com","client":"!bad!"}`,
+		} {
+			r := httptest.NewRequest(
+				http.MethodPost,
+				"/control/dns/resolve_debug",
+				bytes.NewBufferString(body),
+			)
+			w := httptest.NewRecorder()
+
+			s.handleResolveDebug(w, r)
+			assert.Equal(t, http.StatusBadRequest, w.Code, body)
+		}
+	})
+}
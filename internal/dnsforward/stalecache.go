@@ -0,0 +1,265 @@
+package dnsforward
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/cache"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/miekg/dns"
+)
+
+// staleTTL is the TTL of the resource records in the stale responses in
+// seconds, as recommended by RFC 8767.
+//
+// See https://datatracker.ietf.org/doc/html/rfc8767#section-4.
+const staleTTL uint32 = 30
+
+// defaultServeStaleMaxAge is the default maximum time the expired responses are
+// served for.
+const defaultServeStaleMaxAge = timeutil.Day
+
+// maxUpstreamConfIDs is the maximum number of the upstream configurations the
+// stale cache keeps the identifiers of.  When it's exceeded, the cache is
+// cleared, since the configurations are recreated on reconfiguration.
+const maxUpstreamConfIDs = 1024
+
+// staleCache stores the responses received from upstream servers to answer
+// with them after they expire, in case all the upstream servers fail.  It's
+// safe for concurrent use.
+//
+// See https://datatracker.ietf.org/doc/html/rfc8767.
+type staleCache struct {
+	// items stores the packed responses along with their expiration time.
+	items cache.Cache
+
+	// confIDsMu protects confIDs and lastConfID.
+	confIDsMu *sync.Mutex
+
+	// confIDs maps the custom upstream configurations to their identifiers
+	// used in the keys, so that the responses from different upstreams don't
+	// mix, as they don't in the proxy's caches.  The configurations of the
+	// views, interface bindings, and groups are replaced along with the whole
+	// stale cache, while the ones of the clients are removed by [forget].
+	confIDs map[*proxy.CustomUpstreamConfig]uint64
+
+	// now returns the current time.  It's replaced in tests.
+	now func() (t time.Time)
+
+	// lastConfID is the latest identifier of a custom upstream configuration.
+	// Zero is used for the default upstream configuration.  The identifiers
+	// are never reused.
+	lastConfID uint64
+
+	// maxAge is the maximum time an expired response is served for.
+	maxAge time.Duration
+}
+
+// newStaleCache returns a new properly initialized *staleCache.  size is the
+// maximum size of the stored items in bytes, it must be greater than zero.
+func newStaleCache(size uint32, maxAge time.Duration) (c *staleCache) {
+	return &staleCache{
+		items: cache.New(cache.Config{
+			MaxSize:   uint(size),
+			EnableLRU: true,
+		}),
+		confIDsMu: &sync.Mutex{},
+		confIDs:   map[*proxy.CustomUpstreamConfig]uint64{},
+		now:       time.Now,
+		maxAge:    maxAge,
+	}
+}
+
+// confID returns the identifier of the custom upstream configuration conf,
+// which may be nil.
+func (c *staleCache) confID(conf *proxy.CustomUpstreamConfig) (id uint64) {
+	if conf == nil {
+		return 0
+	}
+
+	c.confIDsMu.Lock()
+	defer c.confIDsMu.Unlock()
+
+	id, ok := c.confIDs[conf]
+	if ok {
+		return id
+	}
+
+	if len(c.confIDs) >= maxUpstreamConfIDs {
+		clear(c.confIDs)
+		c.items.Clear()
+	}
+
+	c.lastConfID++
+	c.confIDs[conf] = c.lastConfID
+
+	return c.lastConfID
+}
+
+// forget removes the identifier of the closed custom upstream configuration
+// conf.  The responses stored under it are no longer reachable and are evicted
+// eventually.
+func (c *staleCache) forget(conf *proxy.CustomUpstreamConfig) {
+	c.confIDsMu.Lock()
+	defer c.confIDsMu.Unlock()
+
+	delete(c.confIDs, conf)
+}
+
+// key returns the key of the item for req resolved with the custom upstream
+// configuration conf, which may be nil, in the stale cache.  key is nil if req
+// doesn't have exactly one question.  It must be called before req is modified
+// by the proxy, and the subnet of EDNS Client Subnet must be added to key using
+// [withECS] after resolving.
+func (c *staleCache) key(req *dns.Msg, conf *proxy.CustomUpstreamConfig) (key []byte) {
+	if len(req.Question) != 1 {
+		return nil
+	}
+
+	q := req.Question[0]
+	name := strings.ToLower(q.Name)
+
+	// Reserve space for the identifier of the upstream configuration, the
+	// type, the class, the DO bit, the length of the name, and the subnet.
+	key = make([]byte, 0, 8+2+2+1+1+len(name)+1+net.IPv6len)
+	key = binary.BigEndian.AppendUint64(key, c.confID(conf))
+	key = binary.BigEndian.AppendUint16(key, q.Qtype)
+	key = binary.BigEndian.AppendUint16(key, q.Qclass)
+
+	if hasDO(req) {
+		key = append(key, 1)
+	} else {
+		key = append(key, 0)
+	}
+
+	// Prefix the name with its length, so that it can't be confused with the
+	// subnet.
+	key = append(key, byte(len(name)))
+
+	return append(key, name...)
+}
+
+// withECS returns key with the subnet of EDNS Client Subnet used in the
+// request added, like the proxy's cache does.  subnet may be nil, in which case
+// key is returned as is.
+func withECS(key []byte, subnet *net.IPNet) (res []byte) {
+	if key == nil || subnet == nil {
+		return key
+	}
+
+	ones, _ := subnet.Mask.Size()
+	key = append(key, byte(ones))
+
+	return append(key, subnet.IP.Mask(subnet.Mask)...)
+}
+
+// expTimeLen is the length of the expiration time stored before the packed
+// response in the stale cache item.
+const expTimeLen = 8
+
+// set stores resp under key.  Only successful and NXDOMAIN responses are
+// stored.
+func (c *staleCache) set(key []byte, resp *dns.Msg) {
+	if key == nil || resp == nil {
+		return
+	} else if rc := resp.Rcode; rc != dns.RcodeSuccess && rc != dns.RcodeNameError {
+		return
+	}
+
+	resp = resp.Copy()
+	resp.Extra = removeOPT(resp.Extra)
+
+	packed, err := resp.Pack()
+	if err != nil {
+		log.Debug("dnsforward: stale cache: packing response: %s", err)
+
+		return
+	}
+
+	exp := c.now().Add(time.Duration(respTTL(resp)) * time.Second)
+
+	item := make([]byte, expTimeLen, expTimeLen+len(packed))
+	binary.BigEndian.PutUint64(item, uint64(exp.UnixNano()))
+	item = append(item, packed...)
+
+	c.items.Set(key, item)
+}
+
+// get returns the response stored under key as the response for req, if it's
+// expired for no longer than the configured maximum age.  The TTLs of the
+// records in resp are set to [staleTTL] and the Extended DNS Error option is
+// added if req has EDNS(0).
+func (c *staleCache) get(key []byte, req *dns.Msg) (resp *dns.Msg) {
+	if key == nil {
+		return nil
+	}
+
+	item := c.items.Get(key)
+	if len(item) < expTimeLen {
+		return nil
+	}
+
+	exp := time.Unix(0, int64(binary.BigEndian.Uint64(item)))
+	if c.now().Sub(exp) > c.maxAge {
+		c.items.Del(key)
+
+		return nil
+	}
+
+	resp = &dns.Msg{}
+	err := resp.Unpack(item[expTimeLen:])
+	if err != nil {
+		log.Debug("dnsforward: stale cache: unpacking response: %s", err)
+
+		return nil
+	}
+
+	resp.Id = req.Id
+	for _, rrs := range [][]dns.RR{resp.Answer, resp.Ns, resp.Extra} {
+		for _, rr := range rrs {
+			rr.Header().Ttl = staleTTL
+		}
+	}
+
+	if reqOpt := req.IsEdns0(); reqOpt != nil {
+		resp.SetEdns0(reqOpt.UDPSize(), reqOpt.Do())
+		opt := resp.IsEdns0()
+		opt.Option = append(opt.Option, &dns.EDNS0_EDE{
+			InfoCode: dns.ExtendedErrorCodeStaleAnswer,
+		})
+	}
+
+	return resp
+}
+
+// respTTL returns the minimum TTL of the resource records in the answer and
+// authority sections of resp.
+func respTTL(resp *dns.Msg) (ttl uint32) {
+	first := true
+	for _, rrs := range [][]dns.RR{resp.Answer, resp.Ns} {
+		for _, rr := range rrs {
+			if rrTTL := rr.Header().Ttl; first || rrTTL < ttl {
+				ttl, first = rrTTL, false
+			}
+		}
+	}
+
+	return ttl
+}
+
+// removeOPT returns rrs without the OPT pseudo-records.  It modifies rrs.
+func removeOPT(rrs []dns.RR) (filtered []dns.RR) {
+	filtered = rrs[:0]
+	for _, rr := range rrs {
+		if _, ok := rr.(*dns.OPT); !ok {
+			filtered = append(filtered, rr)
+		}
+	}
+
+	return filtered
+}
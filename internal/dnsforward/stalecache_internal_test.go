@@ -0,0 +1,96 @@
+package dnsforward
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStaleCache_key(t *testing.T) {
+	c := newStaleCache(1024, time.Hour)
+
+	req := createTestMessage("stale.example.")
+
+	upsConf := proxy.NewCustomUpstreamConfig(&proxy.UpstreamConfig{}, false, 0, false)
+	otherConf := proxy.NewCustomUpstreamConfig(&proxy.UpstreamConfig{}, false, 0, false)
+
+	subnet := &net.IPNet{
+		IP:   net.IP{192, 0, 2, 0},
+		Mask: net.CIDRMask(24, 32),
+	}
+	otherSubnet := &net.IPNet{
+		IP:   net.IP{198, 51, 100, 0},
+		Mask: net.CIDRMask(24, 32),
+	}
+
+	defaultKey := c.key(req, nil)
+	require.NotNil(t, defaultKey)
+
+	t.Run("stable", func(t *testing.T) {
+		assert.Equal(t, defaultKey, c.key(req, nil))
+		assert.Equal(t, c.key(req, upsConf), c.key(req, upsConf))
+		assert.Equal(t, withECS(c.key(req, nil), subnet), withECS(c.key(req, nil), subnet))
+	})
+
+	t.Run("upstream_config", func(t *testing.T) {
+		confKey := c.key(req, upsConf)
+
+		assert.NotEqual(t, defaultKey, confKey)
+		assert.NotEqual(t, confKey, c.key(req, otherConf))
+	})
+
+	t.Run("ecs", func(t *testing.T) {
+		ecsKey := withECS(c.key(req, nil), subnet)
+
+		assert.NotEqual(t, defaultKey, ecsKey)
+		assert.NotEqual(t, ecsKey, withECS(c.key(req, nil), otherSubnet))
+		assert.Equal(t, defaultKey, withECS(c.key(req, nil), nil))
+	})
+
+	t.Run("no_question", func(t *testing.T) {
+		assert.Nil(t, c.key(&dns.Msg{}, nil))
+		assert.Nil(t, withECS(nil, subnet))
+	})
+}
+
+func TestStaleCache_confID(t *testing.T) {
+	c := newStaleCache(1024, time.Hour)
+
+	req := createTestMessage("stale.example.")
+	resp := newResp(dns.RcodeSuccess, req, nil)
+
+	upsConf := proxy.NewCustomUpstreamConfig(&proxy.UpstreamConfig{}, false, 0, false)
+	key := c.key(req, upsConf)
+	c.set(key, resp)
+	require.NotNil(t, c.get(key, req))
+
+	for range maxUpstreamConfIDs {
+		_ = c.key(req, proxy.NewCustomUpstreamConfig(&proxy.UpstreamConfig{}, false, 0, false))
+	}
+
+	// The identifiers aren't reused after the cache is cleared.
+	assert.Nil(t, c.get(key, req))
+	assert.NotEqual(t, key, c.key(req, upsConf))
+	assert.LessOrEqual(t, len(c.confIDs), maxUpstreamConfIDs)
+}
+
+func TestStaleCache_forget(t *testing.T) {
+	c := newStaleCache(1024, time.Hour)
+
+	req := createTestMessage("stale.example.")
+
+	upsConf := proxy.NewCustomUpstreamConfig(&proxy.UpstreamConfig{}, false, 0, false)
+	key := c.key(req, upsConf)
+	require.Contains(t, c.confIDs, upsConf)
+
+	c.forget(upsConf)
+	assert.NotContains(t, c.confIDs, upsConf)
+
+	// The identifier of the closed configuration isn't reused.
+	assert.NotEqual(t, key, c.key(req, upsConf))
+}
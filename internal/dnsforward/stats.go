@@ -18,6 +18,25 @@ func (s *Server) processQueryLogsAndStats(dctx *dnsContext) (rc resultCode) {
 	log.Debug("dnsforward: started processing querylog and stats")
 	defer log.Debug("dnsforward: finished processing querylog and stats")
 
+	if dctx.trace != nil && !dctx.trace.log {
+		// Don't log and count the internal requests unless asked to.
+		return resultCodeSuccess
+	}
+
+	// The persistent client has already been found once for this request while
+	// getting the filtering settings, including by ClientID and by the MAC
+	// address from DHCP, so don't construct anything for the ignored ones.
+	ignoreLog, ignoreStats := false, false
+	if setts := dctx.setts; setts != nil {
+		ignoreLog, ignoreStats = setts.IgnoreQueryLog, setts.IgnoreStatistics
+	}
+
+	if ignoreLog && ignoreStats {
+		log.Debug("dnsforward: client is ignored; not adding to querylog and stats")
+
+		return resultCodeSuccess
+	}
+
 	pctx := dctx.proxyCtx
 	q := pctx.Req.Question[0]
 	host := aghnet.NormalizeDomain(q.Name)
@@ -44,7 +63,7 @@ func (s *Server) processQueryLogsAndStats(dctx *dnsContext) (rc resultCode) {
 	s.serverLock.RLock()
 	defer s.serverLock.RUnlock()
 
-	if s.shouldLog(host, qt, cl, ids) {
+	if !ignoreLog && s.shouldLog(host, qt, cl, ids) {
 		s.logQuery(dctx, ip, processingTime)
 	} else {
 		log.Debug(
@@ -56,7 +75,7 @@ func (s *Server) processQueryLogsAndStats(dctx *dnsContext) (rc resultCode) {
 		)
 	}
 
-	if s.shouldCountStat(host, qt, cl, ids) {
+	if !ignoreStats && s.shouldCountStat(host, qt, cl, ids) {
 		s.updateStats(dctx, ipStr, processingTime)
 	} else {
 		log.Debug(
@@ -124,6 +143,11 @@ func (s *Server) logQuery(dctx *dnsContext, ip net.IP, processingTime time.Durat
 		p.Upstream = pctx.Upstream.Address()
 	}
 
+	if a := dctx.failover; a != nil {
+		p.Upstream = a.upstream.Address()
+		p.UpstreamTier = a.tier
+	}
+
 	if qs := pctx.QueryStatistics(); qs != nil {
 		ms := qs.Main()
 		if len(ms) == 1 && ms[0].IsCached {
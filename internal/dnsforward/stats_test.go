@@ -12,6 +12,7 @@ import (
 	"github.com/AdguardTeam/dnsproxy/proxy"
 	"github.com/AdguardTeam/dnsproxy/upstream"
 	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/AdguardTeam/golibs/timeutil"
 	"github.com/miekg/dns"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -238,3 +239,175 @@ func TestServer_ProcessQueryLogsAndStats(t *testing.T) {
 		})
 	}
 }
+
+func TestServer_ProcessQueryLogsAndStats_ignored(t *testing.T) {
+	ups, err := upstream.AddressToUpstream("1.1.1.1", nil)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		setts     *filtering.Settings
+		name      string
+		proto     proxy.Proto
+		clientID  string
+		reason    filtering.Reason
+		wantLog   bool
+		wantStats bool
+	}{{
+		setts:     &filtering.Settings{IgnoreQueryLog: true, IgnoreStatistics: true},
+		name:      "ignored",
+		proto:     proxy.ProtoUDP,
+		clientID:  "",
+		reason:    filtering.NotFilteredNotFound,
+		wantLog:   false,
+		wantStats: false,
+	}, {
+		setts:     &filtering.Settings{IgnoreQueryLog: true, IgnoreStatistics: true},
+		name:      "ignored_blocked",
+		proto:     proxy.ProtoUDP,
+		clientID:  "",
+		reason:    filtering.FilteredBlockList,
+		wantLog:   false,
+		wantStats: false,
+	}, {
+		setts:     &filtering.Settings{IgnoreQueryLog: true, IgnoreStatistics: true},
+		name:      "ignored_tls_clientid",
+		proto:     proxy.ProtoTLS,
+		clientID:  "cli42",
+		reason:    filtering.NotFilteredNotFound,
+		wantLog:   false,
+		wantStats: false,
+	}, {
+		setts:     &filtering.Settings{IgnoreQueryLog: true},
+		name:      "ignored_querylog",
+		proto:     proxy.ProtoUDP,
+		clientID:  "",
+		reason:    filtering.NotFilteredNotFound,
+		wantLog:   false,
+		wantStats: true,
+	}, {
+		setts:     &filtering.Settings{IgnoreStatistics: true},
+		name:      "ignored_stats",
+		proto:     proxy.ProtoUDP,
+		clientID:  "",
+		reason:    filtering.NotFilteredNotFound,
+		wantLog:   true,
+		wantStats: false,
+	}, {
+		setts:     &filtering.Settings{},
+		name:      "not_ignored",
+		proto:     proxy.ProtoUDP,
+		clientID:  "",
+		reason:    filtering.NotFilteredNotFound,
+		wantLog:   true,
+		wantStats: true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ql := &testQueryLog{}
+			st := &testStats{}
+			srv := &Server{
+				baseLogger: slogutil.NewDiscardLogger(),
+				queryLog:   ql,
+				stats:      st,
+				anonymizer: aghnet.NewIPMut(nil),
+			}
+
+			pctx := &proxy.DNSContext{
+				Proto: tc.proto,
+				Req: &dns.Msg{
+					Question: []dns.Question{{
+						Name: "example.com.",
+					}},
+				},
+				Res:      &dns.Msg{},
+				Addr:     testClientAddrPort,
+				Upstream: ups,
+			}
+			dctx := &dnsContext{
+				proxyCtx:  pctx,
+				setts:     tc.setts,
+				startTime: time.Now(),
+				result: &filtering.Result{
+					Reason: tc.reason,
+				},
+				clientID: tc.clientID,
+			}
+
+			code := srv.processQueryLogsAndStats(dctx)
+			assert.Equal(t, resultCodeSuccess, code)
+			assert.Equal(t, tc.wantLog, ql.lastParams != nil)
+			assert.Equal(t, tc.wantStats, st.lastEntry != nil)
+		})
+	}
+}
+
+func TestServer_ProcessQueryLogsAndStats_anonymization(t *testing.T) {
+	anonymizer := aghnet.NewIPMut(nil)
+	_, err := querylog.New(querylog.Config{
+		Logger:                  slogutil.NewDiscardLogger(),
+		Anonymizer:              anonymizer,
+		BaseDir:                 t.TempDir(),
+		RotationIvl:             timeutil.Day,
+		AnonymizationPrefixIPv4: 16,
+		AnonymizationPrefixIPv6: 32,
+		Enabled:                 true,
+		AnonymizeClientIP:       true,
+	})
+	require.NoError(t, err)
+
+	ups, err := upstream.AddressToUpstream("1.1.1.1", nil)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		addr       netip.AddrPort
+		name       string
+		wantClient string
+	}{{
+		addr:       netip.MustParseAddrPort("1.2.3.4:1234"),
+		name:       "ipv4",
+		wantClient: "1.2.0.0",
+	}, {
+		addr:       netip.MustParseAddrPort("[2001:db8:1:2::1]:1234"),
+		name:       "ipv6",
+		wantClient: "2001:db8::",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ql := &testQueryLog{}
+			st := &testStats{}
+			srv := &Server{
+				baseLogger: slogutil.NewDiscardLogger(),
+				queryLog:   ql,
+				stats:      st,
+				anonymizer: anonymizer,
+			}
+
+			dctx := &dnsContext{
+				proxyCtx: &proxy.DNSContext{
+					Proto: proxy.ProtoUDP,
+					Req: &dns.Msg{
+						Question: []dns.Question{{
+							Name: "example.com.",
+						}},
+					},
+					Res:      &dns.Msg{},
+					Addr:     tc.addr,
+					Upstream: ups,
+				},
+				startTime: time.Now(),
+				result:    &filtering.Result{},
+			}
+
+			code := srv.processQueryLogsAndStats(dctx)
+			require.Equal(t, resultCodeSuccess, code)
+
+			require.NotNil(t, ql.lastParams)
+			assert.Equal(t, tc.wantClient, ql.lastParams.ClientIP.String())
+
+			require.NotNil(t, st.lastEntry)
+			assert.Equal(t, tc.wantClient, st.lastEntry.Client)
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package dnsforward
+
+import (
+	"cmp"
+	"fmt"
+	"strings"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/container"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// UpstreamCacheConfig is the configuration of a separate DNS cache for a group
+// of domain-specific upstreams.
+type UpstreamCacheConfig struct {
+	// Group is the domain specification of the group of the form
+	// "[/domain1/../domainN/]".  It should match the domain specifications of
+	// the lines of [Config.UpstreamDNS].
+	Group string `yaml:"group"`
+
+	// Size is the size of the cache in bytes.  If zero, [Config.CacheSize] is
+	// used.
+	Size uint32 `yaml:"size"`
+
+	// MinTTL is the override TTL value (minimum) of the responses received
+	// from the upstreams of the group.
+	MinTTL uint32 `yaml:"ttl_min"`
+
+	// MaxTTL is the override TTL value (maximum) of the responses received
+	// from the upstreams of the group.  If zero, the TTLs aren't limited.
+	MaxTTL uint32 `yaml:"ttl_max"`
+}
+
+// newGroupCaches returns the custom upstream configurations with separate
+// caches for the groups of domain-specific upstreams in uc described by confs.
+// groups maps the FQDNs of the domains of the groups to the configurations.
+// defaultSize is the size of the global cache, which is used for the groups
+// without the cache size set.  If it's zero, the global cache is disabled, so
+// confs are only validated and groups is nil.  The copies of the configurations
+// without caches are stored in uncached.
+func newGroupCaches(
+	uc *proxy.UpstreamConfig,
+	confs []UpstreamCacheConfig,
+	defaultSize uint32,
+	enableECS bool,
+	uncached *uncachedUpstreams,
+) (groups map[string]*proxy.CustomUpstreamConfig, err error) {
+	if defaultSize == 0 {
+		// Only validate confs.
+		uncached = nil
+	}
+
+	groups = map[string]*proxy.CustomUpstreamConfig{}
+	for i, c := range confs {
+		err = addGroupCache(groups, uc, &c, defaultSize, enableECS, uncached)
+		if err != nil {
+			return nil, fmt.Errorf("upstream cache at index %d: %w", i, err)
+		}
+	}
+
+	if defaultSize == 0 {
+		return nil, nil
+	}
+
+	return groups, nil
+}
+
+// addGroupCache adds the custom upstream configuration for the group described
+// by c to groups.  The configuration uses the upstreams of uc with the TTLs of
+// the responses overridden, and its copy without the cache is stored in
+// uncached.
+func addGroupCache(
+	groups map[string]*proxy.CustomUpstreamConfig,
+	uc *proxy.UpstreamConfig,
+	c *UpstreamCacheConfig,
+	defaultSize uint32,
+	enableECS bool,
+	uncached *uncachedUpstreams,
+) (err error) {
+	spec, rest, err := splitDomainSpec(c.Group)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	} else if spec == "" {
+		return errors.Error("no domain specification")
+	} else if rest != "" {
+		return fmt.Errorf("unexpected data after domain specification: %q", rest)
+	}
+
+	err = validateCacheTTL(c.MinTTL, c.MaxTTL)
+	if err != nil {
+		return fmt.Errorf("group %s: validating cache ttl: %w", spec, err)
+	}
+
+	groupConf := &proxy.UpstreamConfig{
+		DomainReservedUpstreams:  map[string][]upstream.Upstream{},
+		SpecifiedDomainUpstreams: map[string][]upstream.Upstream{},
+		SubdomainExclusions:      container.NewMapSet[string](),
+	}
+
+	custom := proxy.NewCustomUpstreamConfig(
+		groupConf,
+		true,
+		int(cmp.Or(c.Size, defaultSize)),
+		enableECS,
+	)
+	uncached.add(custom, groupConf)
+
+	domains := strings.Split(spec[len("[/"):len(spec)-len("/]")], "/")
+	for _, d := range domains {
+		fqdn := strings.TrimPrefix(d, "*.") + "."
+		if _, ok := groups[fqdn]; ok {
+			return fmt.Errorf("group %s: duplicate domain %q", spec, d)
+		}
+
+		ups, ok := uc.DomainReservedUpstreams[fqdn]
+		if !ok {
+			log.Info("dnsforward: warning: no upstreams for %q of cache group %s", d, spec)
+
+			continue
+		}
+
+		groupConf.DomainReservedUpstreams[fqdn] = newTTLUpstreams(ups, c)
+		if specUps, has := uc.SpecifiedDomainUpstreams[fqdn]; has {
+			groupConf.SpecifiedDomainUpstreams[fqdn] = newTTLUpstreams(specUps, c)
+		}
+
+		if uc.SubdomainExclusions.Has(fqdn) {
+			groupConf.SubdomainExclusions.Add(fqdn)
+		}
+
+		groups[fqdn] = custom
+	}
+
+	return nil
+}
+
+// groupUpstreamConfig returns the custom upstream configuration with a
+// separate cache for the group of domain-specific upstreams which is used to
+// resolve fqdn.  conf is nil if the global cache should be used.
+func (s *Server) groupUpstreamConfig(fqdn string) (conf *proxy.CustomUpstreamConfig) {
+	uc := s.conf.UpstreamConfig
+	if len(s.groupCaches) == 0 || uc == nil {
+		return nil
+	}
+
+	fqdn = strings.ToLower(fqdn)
+	for name := fqdn; name != ""; _, name, _ = strings.Cut(name, ".") {
+		if name == fqdn && uc.SubdomainExclusions.Has(name) {
+			// The domain itself is only matched by the more specific groups.
+			if _, ok := uc.SpecifiedDomainUpstreams[name]; !ok {
+				continue
+			}
+		}
+
+		// Stop at the most specific domain with reserved upstreams, even if
+		// its group has no separate cache.
+		if _, ok := uc.DomainReservedUpstreams[name]; ok {
+			return s.groupCaches[name]
+		}
+	}
+
+	return nil
+}
+
+// setGroupUpstream sets the custom upstream configuration of the group of
+// domain-specific upstreams for pctx if there is no other custom configuration
+// already.
+func (s *Server) setGroupUpstream(pctx *proxy.DNSContext) {
+	if pctx.CustomUpstreamConfig != nil || len(pctx.Req.Question) == 0 {
+		return
+	}
+
+	conf := s.groupUpstreamConfig(pctx.Req.Question[0].Name)
+	if conf != nil {
+		log.Debug("dnsforward: using separate cache for %q", pctx.Req.Question[0].Name)
+
+		pctx.CustomUpstreamConfig = conf
+	}
+}
+
+// clearGroupCaches removes all items from the caches of the groups of
+// domain-specific upstreams.
+func (s *Server) clearGroupCaches() {
+	for _, conf := range s.groupCaches {
+		// Clearing the same cache several times is fine.
+		conf.ClearCache()
+	}
+}
+
+// ttlUpstream is an [upstream.Upstream] that overrides the TTLs of the answers
+// received from the wrapped upstream.
+type ttlUpstream struct {
+	upstream.Upstream
+
+	// minTTL is the minimum TTL of the answers.
+	minTTL uint32
+
+	// maxTTL is the maximum TTL of the answers.  If zero, the TTLs aren't
+	// limited.
+	maxTTL uint32
+}
+
+// newTTLUpstreams returns ups wrapped to override the TTLs as configured in c.
+func newTTLUpstreams(ups []upstream.Upstream, c *UpstreamCacheConfig) (wrapped []upstream.Upstream) {
+	wrapped = make([]upstream.Upstream, 0, len(ups))
+	for _, u := range ups {
+		wrapped = append(wrapped, &ttlUpstream{
+			Upstream: u,
+			minTTL:   c.MinTTL,
+			maxTTL:   c.MaxTTL,
+		})
+	}
+
+	return wrapped
+}
+
+// type check
+var _ upstream.Upstream = (*ttlUpstream)(nil)
+
+// Exchange implements the [upstream.Upstream] interface for *ttlUpstream.
+func (u *ttlUpstream) Exchange(req *dns.Msg) (resp *dns.Msg, err error) {
+	resp, err = u.Upstream.Exchange(req)
+	if resp == nil {
+		return resp, err
+	}
+
+	for _, rr := range resp.Answer {
+		hdr := rr.Header()
+		hdr.Ttl = max(hdr.Ttl, u.minTTL)
+		if u.maxTTL != 0 {
+			hdr.Ttl = min(hdr.Ttl, u.maxTTL)
+		}
+	}
+
+	return resp, err
+}
+
+// Close implements the [upstream.Upstream] interface for *ttlUpstream.  It
+// doesn't close the wrapped upstream, since it's owned by the main upstream
+// configuration.
+func (u *ttlUpstream) Close() (err error) {
+	return nil
+}
@@ -0,0 +1,180 @@
+package dnsforward
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/container"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_UpstreamCaches(t *testing.T) {
+	const (
+		upsTTL   = 10
+		groupTTL = 600
+	)
+
+	var reqNum atomic.Uint32
+	upsHdlr := dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		reqNum.Add(1)
+
+		resp := (&dns.Msg{}).SetReply(req)
+		resp.Answer = []dns.RR{&dns.A{
+			Hdr: dns.RR_Header{
+				Name:   req.Question[0].Name,
+				Rrtype: dns.TypeA,
+				Class:  dns.ClassINET,
+				Ttl:    upsTTL,
+			},
+			A: net.IP{192, 0, 2, 1},
+		}}
+
+		require.NoError(testutil.PanicT{}, w.WriteMsg(resp))
+	})
+	upsAddr := aghtest.StartLocalhostUpstream(t, upsHdlr).String()
+
+	s := createTestServer(t, &filtering.Config{
+		BlockingMode: filtering.BlockingModeDefault,
+	}, ServerConfig{
+		UDPListenAddrs: []*net.UDPAddr{{}},
+		TCPListenAddrs: []*net.TCPAddr{{}},
+		Config: Config{
+			UpstreamDNS:      []string{upsAddr, "[/internal.example/]" + upsAddr},
+			UpstreamMode:     UpstreamModeLoadBalance,
+			EDNSClientSubnet: &EDNSClientSubnet{Enabled: false},
+			CacheSize:        4096,
+			UpstreamCaches: []UpstreamCacheConfig{{
+				Group:  "[/internal.example/]",
+				MinTTL: groupTTL,
+			}},
+		},
+		ServePlainDNS: true,
+	})
+	startDeferStop(t, s)
+
+	require.Contains(t, s.groupCaches, "internal.example.")
+
+	addr := s.dnsProxy.Addr(proxy.ProtoUDP).String()
+
+	testCases := []struct {
+		name    string
+		host    string
+		wantTTL uint32
+	}{{
+		name:    "group",
+		host:    "host.internal.example.",
+		wantTTL: groupTTL,
+	}, {
+		name:    "global",
+		host:    "example.com.",
+		wantTTL: upsTTL,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			before := reqNum.Load()
+
+			// Send the request twice to make sure that the second response is
+			// taken from the cache.
+			for range 2 {
+				resp, err := dns.Exchange(createTestMessage(tc.host), addr)
+				require.NoError(t, err)
+				require.Len(t, resp.Answer, 1)
+
+				assert.LessOrEqual(t, resp.Answer[0].Header().Ttl, tc.wantTTL)
+				assert.Greater(t, resp.Answer[0].Header().Ttl, tc.wantTTL-upsTTL)
+			}
+
+			assert.Equal(t, before+1, reqNum.Load())
+		})
+	}
+}
+
+func TestNewGroupCaches(t *testing.T) {
+	ups, err := upstream.AddressToUpstream("127.0.0.1:53", nil)
+	require.NoError(t, err)
+
+	uc := &proxy.UpstreamConfig{
+		DomainReservedUpstreams: map[string][]upstream.Upstream{
+			"internal.example.": {ups},
+		},
+		SpecifiedDomainUpstreams: map[string][]upstream.Upstream{},
+		SubdomainExclusions:      container.NewMapSet[string](),
+	}
+
+	confs := []UpstreamCacheConfig{{
+		Group: "[/internal.example/]",
+	}}
+
+	testCases := []struct {
+		name        string
+		defaultSize uint32
+		wantGroup   bool
+	}{{
+		name:        "enabled",
+		defaultSize: 4096,
+		wantGroup:   true,
+	}, {
+		name:        "global_cache_disabled",
+		defaultSize: 0,
+		wantGroup:   false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			groups, groupsErr := newGroupCaches(uc, confs, tc.defaultSize, false, nil)
+			require.NoError(t, groupsErr)
+
+			assert.Equal(t, tc.wantGroup, groups["internal.example."] != nil)
+		})
+	}
+}
+
+func TestNewGroupCaches_errors(t *testing.T) {
+	uc := &proxy.UpstreamConfig{}
+
+	testCases := []struct {
+		name       string
+		wantErrMsg string
+		confs      []UpstreamCacheConfig
+	}{{
+		name:       "no_spec",
+		wantErrMsg: "upstream cache at index 0: no domain specification",
+		confs:      []UpstreamCacheConfig{{Group: "example.org"}},
+	}, {
+		name: "bad_spec",
+		wantErrMsg: "upstream cache at index 0: " +
+			"wrong domain specification format",
+		confs: []UpstreamCacheConfig{{Group: "[/example.org"}},
+	}, {
+		name: "trailing_data",
+		wantErrMsg: "upstream cache at index 0: " +
+			`unexpected data after domain specification: "1.1.1.1"`,
+		confs: []UpstreamCacheConfig{{Group: "[/example.org/]1.1.1.1"}},
+	}, {
+		name: "bad_ttl",
+		wantErrMsg: "upstream cache at index 0: group [/example.org/]: " +
+			"validating cache ttl: " +
+			"cache_ttl_min must be less than or equal to cache_ttl_max",
+		confs: []UpstreamCacheConfig{{
+			Group:  "[/example.org/]",
+			MinTTL: 20,
+			MaxTTL: 10,
+		}},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := newGroupCaches(uc, tc.confs, 0, false, nil)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
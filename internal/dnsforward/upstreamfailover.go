@@ -0,0 +1,261 @@
+package dnsforward
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// defaultFailoverRecoveryIvl is the default interval between the attempts to
+// return to the higher-priority tiers in [UpstreamModeFailover].
+const defaultFailoverRecoveryIvl = 1 * time.Minute
+
+// failoverAnswer is the tier of a failover upstream, which has answered a
+// request.
+type failoverAnswer struct {
+	// upstream is the upstream of the tier.
+	upstream upstream.Upstream
+
+	// tier is the number of the tier starting from 1, which is the primary
+	// one.
+	tier int
+}
+
+// failoverTracker records the tiers of the failover upstreams, which have
+// answered the requests being processed.  Only the tracked requests are
+// recorded, so that the requests made by the proxy itself, such as the DNS64
+// ones, don't accumulate.  It's safe for concurrent use.
+type failoverTracker struct {
+	// mu protects answers.
+	mu *sync.Mutex
+
+	// answers maps the tracked requests to the tiers which have answered
+	// them.  The
+	// value is nil until some tier answers.
+	answers map[*dns.Msg]*failoverAnswer
+}
+
+// newFailoverTracker returns a new properly initialized *failoverTracker.
+func newFailoverTracker() (t *failoverTracker) {
+	return &failoverTracker{
+		mu:      &sync.Mutex{},
+		answers: map[*dns.Msg]*failoverAnswer{},
+	}
+}
+
+// track starts recording the answering tier for req.
+func (t *failoverTracker) track(req *dns.Msg) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.answers[req] = nil
+}
+
+// record sets the answering tier of req, if it's tracked.
+func (t *failoverTracker) record(req *dns.Msg, a *failoverAnswer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.answers[req]; ok {
+		t.answers[req] = a
+	}
+}
+
+// untrack stops recording the answering tier for req and returns the recorded
+// one.  a is nil if no failover upstream has answered req.
+func (t *failoverTracker) untrack(req *dns.Msg) (a *failoverAnswer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	a = t.answers[req]
+	delete(t.answers, req)
+
+	return a
+}
+
+// setupFailover wraps the upstreams of uc into the failover ones, if the
+// upstream mode is [UpstreamModeFailover], and sets the tracker of the
+// answering tiers accordingly.
+func (s *Server) setupFailover(uc *proxy.UpstreamConfig) (err error) {
+	s.failover = nil
+	if s.conf.UpstreamMode != UpstreamModeFailover {
+		return nil
+	}
+
+	ivl := time.Duration(s.conf.FailoverRecoveryInterval)
+	if ivl < 0 {
+		return fmt.Errorf("failover recovery interval: negative value %s", ivl)
+	} else if ivl == 0 {
+		ivl = defaultFailoverRecoveryIvl
+	}
+
+	s.failover = newFailoverTracker()
+	wrapFailoverUpstreams(uc, s.failover, ivl)
+
+	return nil
+}
+
+// wrapFailoverUpstreams replaces each list of upstreams in uc with a single
+// upstream, which uses them as tiers in the order of the list.  The answering
+// tiers are recorded into tracker.  ivl is the interval between the attempts to
+// return to the higher-priority tiers.
+func wrapFailoverUpstreams(uc *proxy.UpstreamConfig, tracker *failoverTracker, ivl time.Duration) {
+	wrap := func(ups []upstream.Upstream) (res []upstream.Upstream) {
+		if len(ups) == 0 {
+			return ups
+		}
+
+		return []upstream.Upstream{&failoverUpstream{
+			tracker:     tracker,
+			mu:          &sync.Mutex{},
+			now:         time.Now,
+			tiers:       ups,
+			recoveryIvl: ivl,
+		}}
+	}
+
+	uc.Upstreams = wrap(uc.Upstreams)
+	for _, specUps := range []map[string][]upstream.Upstream{
+		uc.DomainReservedUpstreams,
+		uc.SpecifiedDomainUpstreams,
+	} {
+		for domain, ups := range specUps {
+			specUps[domain] = wrap(ups)
+		}
+	}
+}
+
+// failoverUpstream is an [upstream.Upstream] that sends the requests to the
+// first healthy one of the ordered tiers.  A tier is considered unhealthy once
+// it fails to answer, and the next tier is tried for the same request.  The
+// higher-priority tiers are retried once per the recovery interval.
+type failoverUpstream struct {
+	// tracker records the answering tiers.
+	tracker *failoverTracker
+
+	// mu protects active and lastProbe.
+	mu *sync.Mutex
+
+	// now returns the current time.  It's replaced in tests.
+	now func() (t time.Time)
+
+	// lastProbe is the time of the latest failover or the latest attempt to
+	// return to the higher-priority tiers.
+	lastProbe time.Time
+
+	// tiers are the upstreams in the order of priority.
+	tiers []upstream.Upstream
+
+	// active is the index of the first tier considered healthy.
+	active int
+
+	// recoveryIvl is the interval between the attempts to return to the
+	// higher-priority tiers.
+	recoveryIvl time.Duration
+}
+
+// type check
+var _ upstream.Upstream = (*failoverUpstream)(nil)
+
+// Address implements the [upstream.Upstream] interface for *failoverUpstream.
+// It returns the address of the currently active tier.
+func (u *failoverUpstream) Address() (addr string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.tiers[u.active].Address()
+}
+
+// Exchange implements the [upstream.Upstream] interface for *failoverUpstream.
+func (u *failoverUpstream) Exchange(req *dns.Msg) (resp *dns.Msg, err error) {
+	first := u.firstTier()
+
+	var errs []error
+	for i := first; i < len(u.tiers); i++ {
+		t := u.tiers[i]
+		resp, err = t.Exchange(req)
+		if err == nil {
+			u.setActive(first, i)
+			u.tracker.record(req, &failoverAnswer{
+				upstream: t,
+				tier:     i + 1,
+			})
+
+			return resp, nil
+		}
+
+		log.Debug("dnsforward: failover: tier %d: upstream %s: %s", i+1, t.Address(), err)
+
+		errs = append(errs, err)
+	}
+
+	return nil, fmt.Errorf("all failover tiers failed: %w", errors.Join(errs...))
+}
+
+// firstTier returns the index of the tier to start the exchange with.  It's the
+// primary tier if the recovery interval has passed since the latest failover or
+// the latest attempt to recover, and the active tier otherwise.
+func (u *failoverUpstream) firstTier() (i int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.active == 0 {
+		return 0
+	}
+
+	now := u.now()
+	if now.Before(u.lastProbe.Add(u.recoveryIvl)) {
+		return u.active
+	}
+
+	u.lastProbe = now
+
+	return 0
+}
+
+// setActive updates the active tier after the tier with index answered has
+// answered the exchange started from the tier with index first.  The exchanges
+// started below the active tier don't make it lower, since they haven't tried
+// it.
+func (u *failoverUpstream) setActive(first, answered int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	switch {
+	case answered < u.active:
+		log.Info(
+			"dnsforward: failover: recovered to tier %d, upstream %s",
+			answered+1,
+			u.tiers[answered].Address(),
+		)
+	case answered > u.active && first <= u.active:
+		log.Info(
+			"dnsforward: failover: tier %d failed, switching to tier %d, upstream %s",
+			u.active+1,
+			answered+1,
+			u.tiers[answered].Address(),
+		)
+
+		u.lastProbe = u.now()
+	default:
+		return
+	}
+
+	u.active = answered
+}
+
+// Close implements the [upstream.Upstream] interface for *failoverUpstream.
+func (u *failoverUpstream) Close() (err error) {
+	var errs []error
+	for _, t := range u.tiers {
+		errs = append(errs, t.Close())
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,163 @@
+package dnsforward
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newKillableUpstream returns an upstream that fails all the exchanges while
+// down is true.  calls is the pointer to the number of the exchanges made.
+func newKillableUpstream(
+	addr string,
+) (u *aghtest.UpstreamMock, down *atomic.Bool, calls *atomic.Int64) {
+	const testErr errors.Error = "test error"
+
+	down, calls = &atomic.Bool{}, &atomic.Int64{}
+
+	u = aghtest.NewUpstreamMock(func(req *dns.Msg) (resp *dns.Msg, err error) {
+		calls.Add(1)
+		if down.Load() {
+			return nil, testErr
+		}
+
+		return aghtest.MatchedResponse(req, dns.TypeA, googleDomainName, "8.8.8.8"), nil
+	})
+	u.OnAddress = func() (a string) { return addr }
+
+	return u, down, calls
+}
+
+func TestWrapFailoverUpstreams(t *testing.T) {
+	const (
+		primaryAddr   = "primary.example"
+		secondaryAddr = "secondary.example"
+
+		ivl = time.Minute
+	)
+
+	primary, primaryDown, primaryCalls := newKillableUpstream(primaryAddr)
+	secondary, _, secondaryCalls := newKillableUpstream(secondaryAddr)
+
+	uc := &proxy.UpstreamConfig{
+		Upstreams: []upstream.Upstream{primary, secondary},
+		SpecifiedDomainUpstreams: map[string][]upstream.Upstream{
+			"domain.example.": {secondary},
+		},
+	}
+
+	tracker := newFailoverTracker()
+	wrapFailoverUpstreams(uc, tracker, ivl)
+	require.Len(t, uc.Upstreams, 1)
+	require.Len(t, uc.SpecifiedDomainUpstreams["domain.example."], 1)
+
+	u, ok := uc.Upstreams[0].(*failoverUpstream)
+	require.True(t, ok)
+
+	now := time.Now()
+	u.now = func() (t time.Time) { return now }
+
+	// exchange makes a tracked exchange and returns the tier, which has answered
+	// it.
+	exchange := func(t *testing.T) (a *failoverAnswer) {
+		t.Helper()
+
+		req := createGoogleATestMessage()
+		tracker.track(req)
+
+		resp, err := u.Exchange(req)
+		require.NoError(t, err)
+
+		assertGoogleAResponse(t, resp)
+
+		a = tracker.untrack(req)
+		require.NotNil(t, a)
+
+		return a
+	}
+
+	a := exchange(t)
+	assert.Equal(t, 1, a.tier)
+	assert.Equal(t, primaryAddr, a.upstream.Address())
+	assert.Equal(t, int64(1), primaryCalls.Load())
+	assert.Zero(t, secondaryCalls.Load())
+
+	t.Run("failover", func(t *testing.T) {
+		primaryDown.Store(true)
+
+		a = exchange(t)
+		assert.Equal(t, 2, a.tier)
+		assert.Equal(t, secondaryAddr, a.upstream.Address())
+		assert.Equal(t, secondaryAddr, u.Address())
+
+		// The failed tier shouldn't be tried until the recovery interval
+		// passes.
+		primaryDown.Store(false)
+		now = now.Add(ivl / 2)
+
+		a = exchange(t)
+		assert.Equal(t, 2, a.tier)
+		assert.Equal(t, int64(2), primaryCalls.Load())
+		assert.Equal(t, int64(2), secondaryCalls.Load())
+	})
+
+	t.Run("recovery", func(t *testing.T) {
+		now = now.Add(ivl)
+
+		a = exchange(t)
+		assert.Equal(t, 1, a.tier)
+		assert.Equal(t, primaryAddr, u.Address())
+
+		a = exchange(t)
+		assert.Equal(t, 1, a.tier)
+		assert.Equal(t, int64(4), primaryCalls.Load())
+		assert.Equal(t, int64(2), secondaryCalls.Load())
+	})
+
+	t.Run("failed_recovery", func(t *testing.T) {
+		primaryDown.Store(true)
+
+		a = exchange(t)
+		require.Equal(t, 2, a.tier)
+
+		now = now.Add(ivl)
+
+		a = exchange(t)
+		assert.Equal(t, 2, a.tier)
+		assert.Equal(t, int64(6), primaryCalls.Load())
+
+		// The failed attempt to recover postpones the next one.
+		a = exchange(t)
+		assert.Equal(t, 2, a.tier)
+		assert.Equal(t, int64(6), primaryCalls.Load())
+	})
+
+	t.Run("untracked", func(t *testing.T) {
+		_, err := u.Exchange(createGoogleATestMessage())
+		require.NoError(t, err)
+
+		assert.Empty(t, tracker.answers)
+	})
+
+	t.Run("all_failed", func(t *testing.T) {
+		down, isDown, _ := newKillableUpstream("down.example")
+		isDown.Store(true)
+
+		uc = &proxy.UpstreamConfig{
+			Upstreams: []upstream.Upstream{down, down},
+		}
+
+		wrapFailoverUpstreams(uc, tracker, ivl)
+
+		_, err := uc.Upstreams[0].Exchange(createGoogleATestMessage())
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,325 @@
+package dnsforward
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/miekg/dns"
+)
+
+// defaultHijackProbeIvl is the default interval between the probes of the
+// upstream servers for rewriting NXDOMAIN responses.
+const defaultHijackProbeIvl = 1 * time.Hour
+
+// defaultHijackProbeDomains are the default domains, random nonexistent
+// subdomains of which are used to probe the upstream servers.
+var defaultHijackProbeDomains = []string{"com", "net", "org"}
+
+// errHijacking is returned by the upstream servers quarantined for rewriting
+// NXDOMAIN responses.
+const errHijacking errors.Error = "upstream is quarantined for rewriting nxdomain responses"
+
+// HijackDetectionConfig is the configuration of the detection of the upstream
+// servers, which rewrite NXDOMAIN responses into the addresses of their own
+// servers.
+type HijackDetectionConfig struct {
+	// ProbeDomains are the domains, random nonexistent subdomains of which are
+	// used to probe the upstream servers.  If empty, the default ones are
+	// used.
+	ProbeDomains []string `yaml:"probe_domains"`
+
+	// Interval is the interval between the probes.  If zero, the default one
+	// is used.
+	Interval timeutil.Duration `yaml:"interval"`
+
+	// Enabled, if true, enables the detection.  The upstream servers answering
+	// the probes with addresses are excluded from resolving until they pass
+	// the probes again.
+	Enabled bool `yaml:"enabled"`
+}
+
+// hijackDetector periodically probes the upstream servers for rewriting NXDOMAIN
+// responses and quarantines the ones that do.  It's safe for concurrent use.
+type hijackDetector struct {
+	// mu protects upstreams and lastProbe.
+	mu *sync.Mutex
+
+	// upstreams are the currently used upstream servers.
+	upstreams []*hijackUpstream
+
+	// lastProbe is the time of the latest probe.
+	lastProbe time.Time
+
+	// probeDomains are the FQDNs of the domains used for probes.
+	probeDomains []string
+
+	// interval is the interval between the probes.
+	interval time.Duration
+}
+
+// newHijackDetector returns a new properly initialized *hijackDetector.  It
+// returns nil if the detection is disabled.  conf must not be nil.
+func newHijackDetector(conf *HijackDetectionConfig) (d *hijackDetector, err error) {
+	if !conf.Enabled {
+		return nil, nil
+	}
+
+	ivl := time.Duration(conf.Interval)
+	if ivl < 0 {
+		return nil, fmt.Errorf("interval: negative value %s", ivl)
+	} else if ivl == 0 {
+		ivl = defaultHijackProbeIvl
+	}
+
+	domains := conf.ProbeDomains
+	if len(domains) == 0 {
+		domains = defaultHijackProbeDomains
+	}
+
+	fqdns := make([]string, 0, len(domains))
+	for i, domain := range domains {
+		domain = strings.Trim(domain, ".")
+		err = netutil.ValidateDomainName(domain)
+		if err != nil {
+			return nil, fmt.Errorf("probe domain at index %d: %w", i, err)
+		}
+
+		fqdns = append(fqdns, dns.Fqdn(domain))
+	}
+
+	return &hijackDetector{
+		mu:           &sync.Mutex{},
+		probeDomains: fqdns,
+		interval:     ivl,
+	}, nil
+}
+
+// newHijackProbeLabel returns a random label, which is very unlikely to exist.
+func newHijackProbeLabel() (l string) {
+	return fmt.Sprintf("agh-probe-%016x", rand.Uint64())
+}
+
+// wrap makes the upstream servers of uc subject to the quarantine.
+func (d *hijackDetector) wrap(uc *proxy.UpstreamConfig) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.upstreams = d.upstreams[:0]
+
+	// Use the same wrapper for the same upstream, since the upstreams may be
+	// shared between the domains.
+	wrapped := map[upstream.Upstream]*hijackUpstream{}
+	wrap := func(ups []upstream.Upstream) (res []upstream.Upstream) {
+		res = make([]upstream.Upstream, 0, len(ups))
+		for _, u := range ups {
+			w, ok := wrapped[u]
+			if !ok {
+				w = &hijackUpstream{
+					Upstream: u,
+				}
+				wrapped[u] = w
+				d.upstreams = append(d.upstreams, w)
+			}
+
+			res = append(res, w)
+		}
+
+		return res
+	}
+
+	uc.Upstreams = wrap(uc.Upstreams)
+	for _, specUps := range []map[string][]upstream.Upstream{
+		uc.DomainReservedUpstreams,
+		uc.SpecifiedDomainUpstreams,
+	} {
+		for domain, ups := range specUps {
+			specUps[domain] = wrap(ups)
+		}
+	}
+}
+
+// runProbes probes the upstream servers immediately and then periodically until
+// done is closed.  It is intended to be used as a goroutine.
+func (d *hijackDetector) runProbes(done <-chan struct{}) {
+	defer log.OnPanic("dnsforward: hijack detector")
+
+	d.probe()
+
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			d.probe()
+		}
+	}
+}
+
+// probe checks all the current upstream servers and updates their states.
+func (d *hijackDetector) probe() {
+	d.mu.Lock()
+	ups := slices.Clone(d.upstreams)
+	d.mu.Unlock()
+
+	for _, u := range ups {
+		addr := u.Address()
+		hijacking := d.isHijacking(u.Upstream)
+		prev := u.hijacking.Swap(hijacking)
+		switch {
+		case hijacking && !prev:
+			log.Info(
+				"dnsforward: warning: upstream %s answers nonexistent domains with "+
+					"addresses; excluding it",
+				addr,
+			)
+		case !hijacking && prev:
+			log.Info("dnsforward: upstream %s passed hijack probes; including it again", addr)
+		default:
+			log.Debug("dnsforward: upstream %s: hijacking: %t", addr, hijacking)
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.lastProbe = time.Now()
+}
+
+// isHijacking returns true if u answers the queries for the random nonexistent
+// subdomains of the probe domains with addresses.  The failed exchanges aren't
+// considered hijacking.
+func (d *hijackDetector) isHijacking(u upstream.Upstream) (ok bool) {
+	for _, domain := range d.probeDomains {
+		for _, qt := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			req := (&dns.Msg{}).SetQuestion(newHijackProbeLabel()+"."+domain, qt)
+
+			resp, err := u.Exchange(req)
+			if err != nil {
+				log.Debug("dnsforward: hijack probe for %s: %s", u.Address(), err)
+
+				continue
+			}
+
+			if slices.ContainsFunc(resp.Answer, isAddressRR) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// isAddressRR returns true if rr is an A or AAAA record.
+func isAddressRR(rr dns.RR) (ok bool) {
+	switch rr.(type) {
+	case *dns.A, *dns.AAAA:
+		return true
+	default:
+		return false
+	}
+}
+
+// hijackUpstream is an [upstream.Upstream] that fails all exchanges while the
+// wrapped upstream is quarantined for rewriting NXDOMAIN responses, so that the
+// other upstreams are used instead.
+type hijackUpstream struct {
+	upstream.Upstream
+
+	// hijacking is true if the upstream is quarantined.
+	hijacking atomic.Bool
+}
+
+// type check
+var _ upstream.Upstream = (*hijackUpstream)(nil)
+
+// Exchange implements the [upstream.Upstream] interface for *hijackUpstream.
+func (u *hijackUpstream) Exchange(req *dns.Msg) (resp *dns.Msg, err error) {
+	if u.hijacking.Load() {
+		return nil, fmt.Errorf("%s: %w", u.Address(), errHijacking)
+	}
+
+	return u.Upstream.Exchange(req)
+}
+
+// upstreamStatusJSON is the status of a single upstream server.
+type upstreamStatusJSON struct {
+	// Address is the address of the upstream server.
+	Address string `json:"address"`
+
+	// Hijacking is true if the upstream server is quarantined for rewriting
+	// NXDOMAIN responses.
+	Hijacking bool `json:"hijacking"`
+}
+
+// upstreamsStatusJSON is the response for the GET /control/dns/upstreams/status
+// HTTP API.
+type upstreamsStatusJSON struct {
+	// LastProbe is the time of the latest hijack probe.  It's nil if the
+	// upstream servers haven't been probed yet.
+	LastProbe *time.Time `json:"last_probe,omitempty"`
+
+	// Upstreams are the statuses of the upstream servers.
+	Upstreams []*upstreamStatusJSON `json:"upstreams"`
+
+	// HijackDetection is true if the hijack detection is enabled.
+	HijackDetection bool `json:"hijack_detection"`
+}
+
+// status returns the statuses of the current upstream servers.
+func (d *hijackDetector) status() (resp *upstreamsStatusJSON) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	resp = &upstreamsStatusJSON{
+		Upstreams:       make([]*upstreamStatusJSON, 0, len(d.upstreams)),
+		HijackDetection: true,
+	}
+
+	if !d.lastProbe.IsZero() {
+		lastProbe := d.lastProbe
+		resp.LastProbe = &lastProbe
+	}
+
+	for _, u := range d.upstreams {
+		resp.Upstreams = append(resp.Upstreams, &upstreamStatusJSON{
+			Address:   u.Address(),
+			Hijacking: u.hijacking.Load(),
+		})
+	}
+
+	return resp
+}
+
+// handleUpstreamsStatus is the handler for the GET /control/dns/upstreams/status
+// HTTP API.
+func (s *Server) handleUpstreamsStatus(w http.ResponseWriter, r *http.Request) {
+	s.serverLock.RLock()
+	d := s.hijack
+	s.serverLock.RUnlock()
+
+	if d == nil {
+		aghhttp.WriteJSONResponseOK(w, r, &upstreamsStatusJSON{
+			Upstreams: []*upstreamStatusJSON{},
+		})
+
+		return
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, d.status())
+}
@@ -0,0 +1,144 @@
+package dnsforward
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewHijackDetector(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		conf       *HijackDetectionConfig
+		name       string
+		wantErrMsg string
+		wantNil    bool
+	}{{
+		conf:       &HijackDetectionConfig{Enabled: false},
+		name:       "disabled",
+		wantErrMsg: "",
+		wantNil:    true,
+	}, {
+		conf:       &HijackDetectionConfig{Enabled: true},
+		name:       "defaults",
+		wantErrMsg: "",
+		wantNil:    false,
+	}, {
+		conf: &HijackDetectionConfig{
+			Interval: timeutil.Duration(-time.Second),
+			Enabled:  true,
+		},
+		name:       "negative_interval",
+		wantErrMsg: "interval: negative value -1s",
+		wantNil:    true,
+	}, {
+		conf: &HijackDetectionConfig{
+			ProbeDomains: []string{"example.com", "bad domain"},
+			Enabled:      true,
+		},
+		name: "bad_domain",
+		wantErrMsg: `probe domain at index 1: bad domain name "bad domain": ` +
+			`bad top-level domain name label "bad domain": ` +
+			`bad top-level domain name label rune ' '`,
+		wantNil: true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			d, err := newHijackDetector(tc.conf)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+
+			assert.Equal(t, tc.wantNil, d == nil)
+		})
+	}
+}
+
+func TestHijackDetector(t *testing.T) {
+	t.Parallel()
+
+	const (
+		honestAddr = "honest.example"
+		liarAddr   = "liar.example"
+	)
+
+	honest := aghtest.NewUpstreamMock(func(req *dns.Msg) (resp *dns.Msg, err error) {
+		return (&dns.Msg{}).SetRcode(req, dns.RcodeNameError), nil
+	})
+	honest.OnAddress = func() (addr string) { return honestAddr }
+
+	liar := aghtest.NewUpstreamMock(func(req *dns.Msg) (resp *dns.Msg, err error) {
+		resp = (&dns.Msg{}).SetReply(req)
+		resp.Answer = append(
+			resp.Answer,
+			newTestA(req.Question[0].Name, net.IP{192, 0, 2, 1}, 60),
+		)
+
+		return resp, nil
+	})
+	liar.OnAddress = func() (addr string) { return liarAddr }
+
+	d, err := newHijackDetector(&HijackDetectionConfig{
+		ProbeDomains: []string{"example"},
+		Enabled:      true,
+	})
+	require.NoError(t, err)
+	require.NotNil(t, d)
+
+	uc := &proxy.UpstreamConfig{
+		Upstreams: []upstream.Upstream{honest, liar},
+		SpecifiedDomainUpstreams: map[string][]upstream.Upstream{
+			"domain.example.": {liar},
+		},
+	}
+
+	d.wrap(uc)
+	require.Len(t, uc.Upstreams, 2)
+
+	// The same upstream must share the wrapper.
+	assert.Same(t, uc.Upstreams[1], uc.SpecifiedDomainUpstreams["domain.example."][0])
+
+	st := d.status()
+	assert.Nil(t, st.LastProbe)
+
+	d.probe()
+
+	st = d.status()
+	require.NotNil(t, st.LastProbe)
+
+	assert.True(t, st.HijackDetection)
+	assert.Equal(t, []*upstreamStatusJSON{{
+		Address:   honestAddr,
+		Hijacking: false,
+	}, {
+		Address:   liarAddr,
+		Hijacking: true,
+	}}, st.Upstreams)
+
+	req := createTestMessage(aghtest.ReqFQDN)
+
+	_, err = uc.Upstreams[0].Exchange(req)
+	require.NoError(t, err)
+
+	_, err = uc.Upstreams[1].Exchange(req)
+	assert.ErrorIs(t, err, errHijacking)
+
+	t.Run("recovered", func(t *testing.T) {
+		liar.OnExchange = honest.OnExchange
+		d.probe()
+
+		_, err = uc.Upstreams[1].Exchange(req)
+		assert.NoError(t, err)
+	})
+}
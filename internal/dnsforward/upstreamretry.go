@@ -0,0 +1,165 @@
+package dnsforward
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// maxUpstreamRetries is the maximum number of retries of a failed exchange with
+// an upstream server.
+const maxUpstreamRetries = 10
+
+// validateUpstreamRetries returns an error if the retry configuration is
+// invalid.
+func validateUpstreamRetries(retries uint, backoff time.Duration) (err error) {
+	if retries > maxUpstreamRetries {
+		return fmt.Errorf(
+			"upstream retries: must be less than or equal to %d, got %d",
+			maxUpstreamRetries,
+			retries,
+		)
+	}
+
+	if backoff < 0 {
+		return fmt.Errorf("upstream retry backoff: negative value %s", backoff)
+	}
+
+	return nil
+}
+
+// upstreamAttemptTimeout returns the timeout of a single exchange with an
+// upstream server so that all attempts fit into the whole timeout.
+func upstreamAttemptTimeout(
+	timeout time.Duration,
+	retries uint,
+	mode UpstreamMode,
+) (d time.Duration) {
+	if retries == 0 || mode == UpstreamModeParallel {
+		return timeout
+	}
+
+	return timeout / time.Duration(retries+1)
+}
+
+// wrapRetryUpstreams makes the upstreams of uc retry the failed exchanges up to
+// retries times within timeout or within the timeout of the upstream from
+// totals, if there is one.  It does nothing in the parallel mode, since the
+// other upstreams are queried simultaneously anyway.
+func wrapRetryUpstreams(
+	uc *proxy.UpstreamConfig,
+	mode UpstreamMode,
+	retries uint,
+	backoff time.Duration,
+	timeout time.Duration,
+	totals map[upstream.Upstream]time.Duration,
+) {
+	if retries == 0 {
+		return
+	} else if mode == UpstreamModeParallel {
+		log.Debug("dnsforward: upstream retries are disabled in %s mode", mode)
+
+		return
+	}
+
+	// Use the same wrapper for the same upstream, since the upstreams may be
+	// shared between the domains.
+	wrapped := map[upstream.Upstream]upstream.Upstream{}
+	wrap := func(ups []upstream.Upstream) (res []upstream.Upstream) {
+		res = make([]upstream.Upstream, 0, len(ups))
+		for _, u := range ups {
+			w, ok := wrapped[u]
+			if !ok {
+				upsTimeout, hasTotal := totals[u]
+				if !hasTotal {
+					upsTimeout = timeout
+				}
+
+				w = &retryUpstream{
+					Upstream: u,
+					retries:  retries,
+					backoff:  backoff,
+					timeout:  upsTimeout,
+				}
+				wrapped[u] = w
+			}
+
+			res = append(res, w)
+		}
+
+		return res
+	}
+
+	uc.Upstreams = wrap(uc.Upstreams)
+	for _, specUps := range []map[string][]upstream.Upstream{
+		uc.DomainReservedUpstreams,
+		uc.SpecifiedDomainUpstreams,
+	} {
+		for domain, ups := range specUps {
+			specUps[domain] = wrap(ups)
+		}
+	}
+}
+
+// retryUpstream is an [upstream.Upstream] that retries the failed exchanges
+// with the wrapped upstream using exponential backoff with jitter.
+type retryUpstream struct {
+	upstream.Upstream
+
+	// retries is the maximum number of retries of a failed exchange.
+	retries uint
+
+	// backoff is the base duration of waiting before a retry.  It's doubled
+	// with each retry.
+	backoff time.Duration
+
+	// timeout is the duration within which all attempts should be made.
+	timeout time.Duration
+}
+
+// type check
+var _ upstream.Upstream = (*retryUpstream)(nil)
+
+// Exchange implements the [upstream.Upstream] interface for *retryUpstream.
+func (u *retryUpstream) Exchange(req *dns.Msg) (resp *dns.Msg, err error) {
+	start := time.Now()
+	for attempt := uint(0); ; attempt++ {
+		resp, err = u.Upstream.Exchange(req)
+		if err == nil || attempt == u.retries {
+			return resp, err
+		}
+
+		wait := jitter(u.backoff << attempt)
+		if time.Since(start)+wait >= u.timeout {
+			log.Debug("dnsforward: upstream %s: no time left to retry", u.Address())
+
+			return resp, err
+		}
+
+		log.Debug(
+			"dnsforward: upstream %s: retrying in %s after attempt %d: %s",
+			u.Address(),
+			wait,
+			attempt+1,
+			err,
+		)
+
+		time.Sleep(wait)
+	}
+}
+
+// jitter returns a random duration in the range [d/2, d), so that the retries
+// of concurrent requests don't happen simultaneously.
+func jitter(d time.Duration) (res time.Duration) {
+	half := d / 2
+	if half <= 0 {
+		return d
+	}
+
+	return half + rand.N(half)
+}
@@ -0,0 +1,146 @@
+package dnsforward
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newFlakyUpstream returns an upstream that fails the first failsNum
+// exchanges.  calls is the pointer to the number of the exchanges made.
+func newFlakyUpstream(failsNum int) (u *aghtest.UpstreamMock, calls *int) {
+	const testErr errors.Error = "test error"
+
+	calls = new(int)
+
+	return &aghtest.UpstreamMock{
+		OnAddress: func() (addr string) { return "flaky.upstream.example" },
+		OnExchange: func(req *dns.Msg) (resp *dns.Msg, err error) {
+			*calls++
+			if *calls <= failsNum {
+				return nil, testErr
+			}
+
+			return aghtest.MatchedResponse(req, dns.TypeA, googleDomainName, "8.8.8.8"), nil
+		},
+		OnClose: func() (err error) { return nil },
+	}, calls
+}
+
+func TestWrapRetryUpstreams(t *testing.T) {
+	const (
+		backoff = time.Millisecond
+		timeout = time.Second
+	)
+
+	testCases := []struct {
+		name      string
+		mode      UpstreamMode
+		retries   uint
+		timeout   time.Duration
+		failsNum  int
+		wantCalls int
+		wantErr   bool
+	}{{
+		name:      "success_second_try",
+		mode:      UpstreamModeLoadBalance,
+		retries:   2,
+		timeout:   timeout,
+		failsNum:  1,
+		wantCalls: 2,
+		wantErr:   false,
+	}, {
+		name:      "fastest_addr",
+		mode:      UpstreamModeFastestAddr,
+		retries:   1,
+		timeout:   timeout,
+		failsNum:  1,
+		wantCalls: 2,
+		wantErr:   false,
+	}, {
+		name:      "retries_exhausted",
+		mode:      UpstreamModeLoadBalance,
+		retries:   2,
+		timeout:   timeout,
+		failsNum:  3,
+		wantCalls: 3,
+		wantErr:   true,
+	}, {
+		name:      "no_retries",
+		mode:      UpstreamModeLoadBalance,
+		retries:   0,
+		timeout:   timeout,
+		failsNum:  1,
+		wantCalls: 1,
+		wantErr:   true,
+	}, {
+		name:      "parallel",
+		mode:      UpstreamModeParallel,
+		retries:   2,
+		timeout:   timeout,
+		failsNum:  1,
+		wantCalls: 1,
+		wantErr:   true,
+	}, {
+		name:      "no_time_left",
+		mode:      UpstreamModeLoadBalance,
+		retries:   2,
+		timeout:   backoff / 2,
+		failsNum:  1,
+		wantCalls: 1,
+		wantErr:   true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ups, calls := newFlakyUpstream(tc.failsNum)
+			uc := &proxy.UpstreamConfig{
+				Upstreams: []upstream.Upstream{ups},
+				DomainReservedUpstreams: map[string][]upstream.Upstream{
+					"example.org.": {ups},
+				},
+			}
+
+			wrapRetryUpstreams(uc, tc.mode, tc.retries, backoff, tc.timeout, nil)
+			require.Len(t, uc.Upstreams, 1)
+
+			// The same upstream should be wrapped only once.
+			assert.Same(t, uc.Upstreams[0], uc.DomainReservedUpstreams["example.org."][0])
+
+			resp, err := uc.Upstreams[0].Exchange(createGoogleATestMessage())
+			assert.Equal(t, tc.wantCalls, *calls)
+
+			if tc.wantErr {
+				assert.Error(t, err)
+
+				return
+			}
+
+			require.NoError(t, err)
+
+			assertGoogleAResponse(t, resp)
+		})
+	}
+}
+
+func TestValidateUpstreamRetries(t *testing.T) {
+	assert.NoError(t, validateUpstreamRetries(0, 0))
+	assert.NoError(t, validateUpstreamRetries(maxUpstreamRetries, time.Second))
+	assert.Error(t, validateUpstreamRetries(maxUpstreamRetries+1, 0))
+	assert.Error(t, validateUpstreamRetries(1, -time.Second))
+}
+
+func TestUpstreamAttemptTimeout(t *testing.T) {
+	const timeout = 3 * time.Second
+
+	assert.Equal(t, timeout, upstreamAttemptTimeout(timeout, 0, UpstreamModeLoadBalance))
+	assert.Equal(t, timeout, upstreamAttemptTimeout(timeout, 2, UpstreamModeParallel))
+	assert.Equal(t, time.Second, upstreamAttemptTimeout(timeout, 2, UpstreamModeFastestAddr))
+}
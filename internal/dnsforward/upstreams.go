@@ -2,12 +2,15 @@ package dnsforward
 
 import (
 	"fmt"
+	"net/netip"
 	"slices"
+	"strings"
 	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
 	"github.com/AdguardTeam/dnsproxy/proxy"
 	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/AdguardTeam/golibs/netutil"
 	"github.com/AdguardTeam/golibs/stringutil"
@@ -52,6 +55,165 @@ func newBootstrap(
 	return r, boots, nil
 }
 
+// errNotPlainDNS is returned when a bootstrap address isn't the address of a
+// plain DNS server.
+const errNotPlainDNS errors.Error = "not an ip address of a plain dns server"
+
+// checkPlainDNS returns an error if addr isn't the IP address of a plain DNS
+// server, optionally with a port and the udp:// or tcp:// scheme.
+func checkPlainDNS(addr string) (err error) {
+	hostPort := addr
+	for _, scheme := range []string{"udp://", "tcp://"} {
+		hostPort = strings.TrimPrefix(hostPort, scheme)
+	}
+
+	if _, err = netip.ParseAddr(hostPort); err == nil {
+		return nil
+	}
+
+	if _, err = netip.ParseAddrPort(hostPort); err != nil {
+		return errNotPlainDNS
+	}
+
+	return nil
+}
+
+// splitDomainSpec splits the domain specification, such as
+// "[/example.internal/]", from the rest of the line.  spec is normalized and is
+// empty if the line has no domain specification.
+func splitDomainSpec(line string) (spec, rest string, err error) {
+	if !strings.HasPrefix(line, "[/") {
+		return "", line, nil
+	}
+
+	domains, rest, ok := strings.Cut(line[len("[/"):], "/]")
+	if !ok {
+		return "", "", errors.Error("wrong domain specification format")
+	}
+
+	return "[/" + strings.ToLower(domains) + "/]", rest, nil
+}
+
+// newGroupBootstraps returns the bootstrap resolvers for the groups of
+// domain-specific upstreams.  lines must be of the form
+// "[/domain1/../domainN/]bootstrap1 .. bootstrapN".  groups maps the normalized
+// domain specifications to the resolvers.  boots are the upstream resolvers
+// that should be closed after use.
+func newGroupBootstraps(
+	lines []string,
+	etcHosts upstream.Resolver,
+	opts *upstream.Options,
+) (groups map[string]upstream.Resolver, boots []*upstream.UpstreamResolver, err error) {
+	groups = make(map[string]upstream.Resolver, len(lines))
+	for i, line := range stringutil.FilterOut(lines, IsCommentOrEmpty) {
+		var r upstream.Resolver
+		var groupBoots []*upstream.UpstreamResolver
+		r, groupBoots, err = newGroupBootstrap(line, groups, etcHosts, opts)
+		boots = append(boots, groupBoots...)
+		if err != nil {
+			return nil, boots, fmt.Errorf("group bootstrap at index %d: %w", i, err)
+		}
+
+		spec, _, _ := splitDomainSpec(line)
+		groups[spec] = r
+	}
+
+	return groups, boots, nil
+}
+
+// newGroupBootstrap returns the bootstrap resolver for the group of
+// domain-specific upstreams described by line.  groups are the already parsed
+// groups.
+func newGroupBootstrap(
+	line string,
+	groups map[string]upstream.Resolver,
+	etcHosts upstream.Resolver,
+	opts *upstream.Options,
+) (r upstream.Resolver, boots []*upstream.UpstreamResolver, err error) {
+	spec, rest, err := splitDomainSpec(line)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, nil, err
+	} else if spec == "" {
+		return nil, nil, errors.Error("no domain specification")
+	} else if _, ok := groups[spec]; ok {
+		return nil, nil, fmt.Errorf("duplicate group %s", spec)
+	}
+
+	addrs := strings.Fields(rest)
+	if len(addrs) == 0 {
+		return nil, nil, errors.Error("no bootstrap servers")
+	}
+
+	for _, addr := range addrs {
+		if err = checkPlainDNS(addr); err != nil {
+			return nil, nil, fmt.Errorf("bootstrap %q: %w", addr, err)
+		}
+	}
+
+	// Don't wrap the error since it's informative enough as is.
+	return newBootstrap(addrs, etcHosts, opts)
+}
+
+// applyGroupBootstraps replaces the upstreams of the groups in uc, which has
+// been parsed from upstreams using opts, with the ones using the bootstrap
+// resolvers of the groups.  groups maps the normalized domain specifications
+// to the bootstrap resolvers.
+//
+// The replaced upstreams don't need to be closed, since they aren't used for
+// exchanging yet.
+func applyGroupBootstraps(
+	uc *proxy.UpstreamConfig,
+	upstreams []string,
+	groups map[string]upstream.Resolver,
+	opts *upstream.Options,
+) (err error) {
+	for spec, boot := range groups {
+		var lines []string
+		for _, line := range upstreams {
+			lineSpec, _, _ := splitDomainSpec(line)
+			if lineSpec == spec {
+				lines = append(lines, line)
+			}
+		}
+
+		if len(lines) == 0 {
+			log.Info("dnsforward: warning: no upstreams for bootstrap group %s", spec)
+
+			continue
+		}
+
+		groupOpts := opts.Clone()
+		groupOpts.Bootstrap = boot
+
+		var groupConf *proxy.UpstreamConfig
+		groupConf, err = proxy.ParseUpstreamsConfig(lines, groupOpts)
+		if err != nil {
+			return fmt.Errorf("parsing upstreams of group %s: %w", spec, err)
+		}
+
+		replaceUpstreams(uc.DomainReservedUpstreams, groupConf.DomainReservedUpstreams)
+		replaceUpstreams(uc.SpecifiedDomainUpstreams, groupConf.SpecifiedDomainUpstreams)
+	}
+
+	return nil
+}
+
+// replaceUpstreams replaces the upstreams of each domain of src in dst with the
+// upstreams from src having the same address.
+func replaceUpstreams(dst, src map[string][]upstream.Upstream) {
+	for domain, srcUps := range src {
+		for i, u := range dst[domain] {
+			idx := slices.IndexFunc(srcUps, func(s upstream.Upstream) (ok bool) {
+				return s.Address() == u.Address()
+			})
+			if idx >= 0 {
+				dst[domain][i] = srcUps[idx]
+			}
+		}
+	}
+}
+
 // newUpstreamConfig returns the upstream configuration based on upstreams.  If
 // upstreams slice specifies no default upstreams, defaultUpstreams are used to
 // create upstreams with no domain specifications.  opts are used when creating
@@ -154,7 +316,9 @@ func setProxyUpstreamMode(
 	case UpstreamModeFastestAddr:
 		conf.UpstreamMode = proxy.UpstreamModeFastestAddr
 		conf.FastestPingTimeout = fastestTimeout
-	case UpstreamModeLoadBalance:
+	case UpstreamModeLoadBalance, UpstreamModeFailover:
+		// Each list of upstreams is replaced with a single failover one in
+		// [UpstreamModeFailover], so the load balancing doesn't matter.
 		conf.UpstreamMode = proxy.UpstreamModeLoadBalance
 	default:
 		return fmt.Errorf("unexpected value %q", upstreamMode)
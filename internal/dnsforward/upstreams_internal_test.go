@@ -1,14 +1,21 @@
 package dnsforward
 
 import (
+	"context"
+	"crypto/ed25519"
 	"net"
+	"net/netip"
 	"net/url"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/ameshkov/dnsstamps"
 	"github.com/miekg/dns"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -131,6 +138,14 @@ func TestUpstreamConfigValidator(t *testing.T) {
 		want: map[string]string{
 			"bad://1.2.3.4": generalTextLabel + " 1: parsing error",
 		},
+	}, {
+		name: "bad_stamp",
+		general: []string{
+			"sdns://bad",
+		},
+		want: map[string]string{
+			"sdns://bad": generalTextLabel + " 1: parsing error",
+		},
 	}, {
 		name: "truncated_line",
 		general: []string{
@@ -147,7 +162,7 @@ func TestUpstreamConfigValidator(t *testing.T) {
 				Timeout:   upsTimeout,
 				Bootstrap: net.DefaultResolver,
 			})
-			cv.check()
+			cv.check(1)
 			cv.close()
 
 			assert.Equal(t, tc.want, cv.status())
@@ -199,7 +214,7 @@ func TestUpstreamConfigValidator_Check_once(t *testing.T) {
 			})
 
 			go func() {
-				cv.check()
+				cv.check(1)
 				testutil.RequireSend(testutil.PanicT{}, reqCh, signal{}, testTimeout)
 			}()
 
@@ -214,3 +229,225 @@ func TestUpstreamConfigValidator_Check_once(t *testing.T) {
 		})
 	}
 }
+
+func TestNewUpstreamConfig_dnsCryptStamp(t *testing.T) {
+	stamp := (&dnsstamps.ServerStamp{
+		ServerAddrStr: "192.0.2.1:5443",
+		ServerPk:      make([]byte, ed25519.PublicKeySize),
+		ProviderName:  "2.dnscrypt-cert.example.org",
+		Proto:         dnsstamps.StampProtoTypeDNSCrypt,
+	}).String()
+
+	uc, err := newUpstreamConfig([]string{stamp}, nil, &upstream.Options{
+		Timeout: testTimeout,
+	})
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, uc.Close)
+
+	require.Len(t, uc.Upstreams, 1)
+
+	assert.Equal(t, stamp, uc.Upstreams[0].Address())
+}
+
+func TestCheckPlainDNS(t *testing.T) {
+	testCases := []struct {
+		name       string
+		addr       string
+		wantErrMsg string
+	}{{
+		name:       "ip",
+		addr:       "192.0.2.1",
+		wantErrMsg: "",
+	}, {
+		name:       "ip_port",
+		addr:       "192.0.2.1:5353",
+		wantErrMsg: "",
+	}, {
+		name:       "ipv6_port",
+		addr:       "[2001:db8::1]:53",
+		wantErrMsg: "",
+	}, {
+		name:       "udp",
+		addr:       "udp://192.0.2.1:53",
+		wantErrMsg: "",
+	}, {
+		name:       "tcp",
+		addr:       "tcp://192.0.2.1",
+		wantErrMsg: "",
+	}, {
+		name:       "tls",
+		addr:       "tls://192.0.2.1",
+		wantErrMsg: string(errNotPlainDNS),
+	}, {
+		name:       "hostname",
+		addr:       "dns.example",
+		wantErrMsg: string(errNotPlainDNS),
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, checkPlainDNS(tc.addr))
+		})
+	}
+}
+
+func TestNewGroupBootstraps(t *testing.T) {
+	opts := &upstream.Options{
+		Timeout: testTimeout,
+	}
+
+	t.Run("success", func(t *testing.T) {
+		groups, boots, err := newGroupBootstraps([]string{
+			"# comment",
+			"[/Corp.example/]192.0.2.1 udp://192.0.2.2:53",
+			"[/other.example/*.another.example/]tcp://192.0.2.3",
+		}, nil, opts)
+		require.NoError(t, err)
+
+		for _, b := range boots {
+			testutil.CleanupAndRequireSuccess(t, b.Close)
+		}
+
+		assert.Len(t, boots, 3)
+		assert.Len(t, groups, 2)
+		assert.Contains(t, groups, "[/corp.example/]")
+		assert.Contains(t, groups, "[/other.example/*.another.example/]")
+	})
+
+	testCases := []struct {
+		name       string
+		line       string
+		wantErrMsg string
+	}{{
+		name:       "no_spec",
+		line:       "192.0.2.1",
+		wantErrMsg: "group bootstrap at index 0: no domain specification",
+	}, {
+		name:       "bad_spec",
+		line:       "[/corp.example 192.0.2.1",
+		wantErrMsg: "group bootstrap at index 0: wrong domain specification format",
+	}, {
+		name:       "no_servers",
+		line:       "[/corp.example/]",
+		wantErrMsg: "group bootstrap at index 0: no bootstrap servers",
+	}, {
+		name: "encrypted",
+		line: "[/corp.example/]tls://192.0.2.1",
+		wantErrMsg: `group bootstrap at index 0: bootstrap "tls://192.0.2.1": ` +
+			string(errNotPlainDNS),
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, _, err := newGroupBootstraps([]string{tc.line}, nil, opts)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+
+	t.Run("duplicate", func(t *testing.T) {
+		_, boots, err := newGroupBootstraps([]string{
+			"[/corp.example/]192.0.2.1",
+			"[/CORP.example/]192.0.2.2",
+		}, nil, opts)
+		for _, b := range boots {
+			testutil.CleanupAndRequireSuccess(t, b.Close)
+		}
+
+		testutil.AssertErrorMsg(
+			t,
+			"group bootstrap at index 1: duplicate group [/corp.example/]",
+			err,
+		)
+	})
+}
+
+// recordingResolver is an [upstream.Resolver] that records the looked up hosts
+// and always fails.
+type recordingResolver struct {
+	// mu protects hosts.
+	mu *sync.Mutex
+
+	// hosts are the looked up hosts.
+	hosts []string
+}
+
+// newRecordingResolver returns a new *recordingResolver.
+func newRecordingResolver() (r *recordingResolver) {
+	return &recordingResolver{
+		mu: &sync.Mutex{},
+	}
+}
+
+// type check
+var _ upstream.Resolver = (*recordingResolver)(nil)
+
+// LookupNetIP implements the [upstream.Resolver] interface for
+// *recordingResolver.
+func (r *recordingResolver) LookupNetIP(
+	_ context.Context,
+	_ string,
+	host string,
+) (addrs []netip.Addr, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.hosts = append(r.hosts, host)
+
+	return nil, errors.Error("test error")
+}
+
+// lookedUp returns the looked up hosts.
+func (r *recordingResolver) lookedUp() (hosts []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return slices.Clone(r.hosts)
+}
+
+func TestApplyGroupBootstraps(t *testing.T) {
+	globalBoot := newRecordingResolver()
+	groupBoot := newRecordingResolver()
+
+	upstreams := []string{
+		"tls://default.example",
+		"[/corp.example/]tls://dns.corp.example",
+		"[/other.example/]tls://dns.other.example",
+	}
+
+	opts := &upstream.Options{
+		Bootstrap: globalBoot,
+		Timeout:   testTimeout,
+	}
+
+	uc, err := newUpstreamConfig(upstreams, nil, opts)
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, uc.Close)
+
+	err = applyGroupBootstraps(uc, upstreams, map[string]upstream.Resolver{
+		"[/corp.example/]": groupBoot,
+	}, opts)
+	require.NoError(t, err)
+
+	req := (&dns.Msg{}).SetQuestion("www.example.", dns.TypeA)
+
+	require.Len(t, uc.Upstreams, 1)
+	require.Len(t, uc.DomainReservedUpstreams["corp.example."], 1)
+	require.Len(t, uc.DomainReservedUpstreams["other.example."], 1)
+
+	for _, u := range []upstream.Upstream{
+		uc.Upstreams[0],
+		uc.DomainReservedUpstreams["corp.example."][0],
+		uc.DomainReservedUpstreams["other.example."][0],
+	} {
+		_, err = u.Exchange(req)
+		require.Error(t, err)
+	}
+
+	assert.Contains(t, groupBoot.lookedUp(), "dns.corp.example")
+	assert.NotContains(t, groupBoot.lookedUp(), "default.example")
+
+	globalHosts := globalBoot.lookedUp()
+	assert.Contains(t, globalHosts, "default.example")
+	assert.Contains(t, globalHosts, "dns.other.example")
+	assert.NotContains(t, globalHosts, "dns.corp.example")
+}
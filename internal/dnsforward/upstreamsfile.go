@@ -0,0 +1,134 @@
+package dnsforward
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghos"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/stringutil"
+)
+
+// newFSWatcherFunc is the type of functions creating file system watchers.
+type newFSWatcherFunc func() (w aghos.FSWatcher, err error)
+
+// readUpstreamsFile reads the upstream DNS servers from the file with the name
+// fn, skipping the comments and the empty lines.
+func readUpstreamsFile(fn string) (upstreams []string, err error) {
+	// #nosec G304 -- Trust the path explicitly given by the user.
+	data, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, fmt.Errorf("reading upstream from file: %w", err)
+	}
+
+	upstreams = stringutil.SplitTrimmed(string(data), "\n")
+
+	log.Debug("dnsforward: got %d upstreams in %q", len(upstreams), fn)
+
+	return stringutil.FilterOut(upstreams, IsCommentOrEmpty), nil
+}
+
+// validateUpstreamsFile returns an error if the file with the name fn doesn't
+// contain a valid non-empty list of upstream DNS servers.
+func validateUpstreamsFile(fn string) (err error) {
+	upstreams, err := readUpstreamsFile(fn)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	} else if len(upstreams) == 0 {
+		return errors.Error("no upstreams")
+	}
+
+	uc, err := proxy.ParseUpstreamsConfig(upstreams, &upstream.Options{})
+	err = errors.WithDeferred(err, uc.Close())
+	if err != nil {
+		return fmt.Errorf("upstream servers: %w", err)
+	}
+
+	return nil
+}
+
+// watchUpstreamsFile starts watching the file with upstream DNS servers, if
+// any, to reload the upstreams when it changes.  s.serverLock is expected to be
+// locked.
+func (s *Server) watchUpstreamsFile() {
+	fn := s.conf.UpstreamDNSFileName
+	if fn == "" || s.newFSWatcher == nil {
+		return
+	}
+
+	w, err := s.newFSWatcher()
+	if err != nil {
+		log.Info("dnsforward: warning: creating upstreams file watcher: %s", err)
+
+		return
+	}
+
+	err = w.Add(fn)
+	if err == nil {
+		err = w.Start()
+	}
+
+	if err != nil {
+		err = errors.WithDeferred(err, w.Close())
+		log.Info("dnsforward: warning: watching upstreams file %q: %s", fn, err)
+
+		return
+	}
+
+	s.upstreamsWatcher = w
+
+	go s.handleUpstreamsFileEvents(w, fn)
+}
+
+// closeUpstreamsWatcher stops watching the file with upstream DNS servers, if
+// it's watched.  s.serverLock is expected to be locked.
+func (s *Server) closeUpstreamsWatcher() {
+	if s.upstreamsWatcher == nil {
+		return
+	}
+
+	logCloserErr(s.upstreamsWatcher, "dnsforward: closing upstreams file watcher: %s")
+	s.upstreamsWatcher = nil
+}
+
+// handleUpstreamsFileEvents reloads the upstreams on each event of w about the
+// file with the name fn until w is closed.  It's intended to be used as a
+// goroutine.
+func (s *Server) handleUpstreamsFileEvents(w aghos.FSWatcher, fn string) {
+	defer log.OnPanic("dnsforward: handling upstreams file events")
+
+	for range w.Events() {
+		s.reloadUpstreamsFile(w, fn)
+	}
+}
+
+// reloadUpstreamsFile reconfigures the server with the upstreams from the file
+// with the name fn, unless w doesn't watch it anymore.  The current upstreams
+// are kept if the new ones are invalid.
+func (s *Server) reloadUpstreamsFile(w aghos.FSWatcher, fn string) {
+	err := validateUpstreamsFile(fn)
+	if err != nil {
+		log.Error("dnsforward: upstreams file %q changed: keeping current upstreams: %s", fn, err)
+
+		return
+	}
+
+	s.serverLock.Lock()
+	defer s.serverLock.Unlock()
+
+	if s.upstreamsWatcher != w {
+		// The server has been stopped or reconfigured in the meantime.
+		return
+	}
+
+	log.Info("dnsforward: upstreams file %q changed, reloading", fn)
+
+	err = s.reconfigureLocked(nil)
+	if err != nil {
+		log.Error("dnsforward: reloading upstreams from file %q: %s", fn, err)
+	}
+}
@@ -0,0 +1,111 @@
+package dnsforward
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghos"
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// upstreamHosts returns the hosts of the default upstreams of s.
+func upstreamHosts(s *Server) (hosts []string) {
+	s.serverLock.RLock()
+	defer s.serverLock.RUnlock()
+
+	for _, u := range s.dnsProxy.UpstreamConfig.Upstreams {
+		hosts = append(hosts, u.Address())
+	}
+
+	return hosts
+}
+
+// hasSingleUpstream returns true if s has the only default upstream with host.
+func hasSingleUpstream(s *Server, host string) (ok bool) {
+	hosts := upstreamHosts(s)
+
+	return len(hosts) == 1 && strings.Contains(hosts[0], host)
+}
+
+func TestServer_watchUpstreamsFile(t *testing.T) {
+	hdlr := dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		require.NoError(testutil.PanicT{}, w.WriteMsg((&dns.Msg{}).SetReply(req)))
+	})
+
+	firstUps := aghtest.StartLocalhostUpstream(t, hdlr)
+	secondUps := aghtest.StartLocalhostUpstream(t, hdlr)
+
+	fn := filepath.Join(t.TempDir(), "upstreams.txt")
+	err := os.WriteFile(fn, []byte(firstUps.String()+"\n"), 0o600)
+	require.NoError(t, err)
+
+	s := createTestServer(t, &filtering.Config{
+		BlockingMode: filtering.BlockingModeDefault,
+	}, ServerConfig{
+		UDPListenAddrs: []*net.UDPAddr{{}},
+		TCPListenAddrs: []*net.TCPAddr{{}},
+		Config: Config{
+			UpstreamDNSFileName: fn,
+			UpstreamMode:        UpstreamModeLoadBalance,
+			EDNSClientSubnet:    &EDNSClientSubnet{Enabled: false},
+		},
+		ServePlainDNS: true,
+	})
+
+	// watchers receives the events channels of the created watchers.  The
+	// events are unbuffered, so a successful send means that the previous event
+	// has been handled.  The events are only closed after the test, since the
+	// event sent to a replaced watcher is just ignored by its handler.
+	watchers := make(chan chan struct{}, 1)
+	s.newFSWatcher = func() (w aghos.FSWatcher, err error) {
+		events := make(chan struct{})
+		t.Cleanup(func() { close(events) })
+
+		return &aghtest.FSWatcher{
+			OnStart:  func() (err error) { return nil },
+			OnClose:  func() (err error) { return nil },
+			OnEvents: func() (e <-chan struct{}) { return events },
+			OnAdd: func(name string) (err error) {
+				pt := testutil.PanicT{}
+				require.Equal(pt, fn, name)
+				testutil.RequireSend(pt, watchers, events, testTimeout)
+
+				return nil
+			},
+		}, nil
+	}
+
+	startDeferStop(t, s)
+
+	events, _ := testutil.RequireReceive(t, watchers, testTimeout)
+	require.True(t, hasSingleUpstream(s, firstUps.Host))
+
+	t.Run("invalid", func(t *testing.T) {
+		err = os.WriteFile(fn, []byte("bad://upstream\n"), 0o600)
+		require.NoError(t, err)
+
+		testutil.RequireSend(t, events, struct{}{}, testTimeout)
+		testutil.RequireSend(t, events, struct{}{}, testTimeout)
+
+		assert.True(t, hasSingleUpstream(s, firstUps.Host))
+	})
+
+	t.Run("valid", func(t *testing.T) {
+		err = os.WriteFile(fn, []byte("# Comment.\n"+secondUps.String()+"\n"), 0o600)
+		require.NoError(t, err)
+
+		testutil.RequireSend(t, events, struct{}{}, testTimeout)
+
+		// The server is restarted with a new watcher.
+		_, _ = testutil.RequireReceive(t, watchers, testTimeout)
+		assert.True(t, hasSingleUpstream(s, secondUps.Host))
+	})
+}
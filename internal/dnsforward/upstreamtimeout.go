@@ -0,0 +1,180 @@
+package dnsforward
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/stringutil"
+)
+
+// upstreamTimeouts are the parsed timeouts of the upstreams.  See
+// [Config.UpstreamTimeouts].
+type upstreamTimeouts struct {
+	// groups maps the normalized domain specifications of the groups of
+	// domain-specific upstreams to their timeouts.
+	groups map[string]time.Duration
+
+	// addrs maps the addresses of the upstreams to their timeouts.
+	addrs map[string]time.Duration
+}
+
+// parseUpstreamTimeouts parses the timeouts of the upstreams.  lines must be
+// of the form "[/domain1/../domainN/]timeout" or "address timeout".
+func parseUpstreamTimeouts(lines []string) (t *upstreamTimeouts, err error) {
+	t = &upstreamTimeouts{
+		groups: map[string]time.Duration{},
+		addrs:  map[string]time.Duration{},
+	}
+
+	for i, line := range stringutil.FilterOut(lines, IsCommentOrEmpty) {
+		err = t.parseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("upstream timeout at index %d: %w", i, err)
+		}
+	}
+
+	return t, nil
+}
+
+// parseLine parses a single line of the upstream timeouts into t.
+func (t *upstreamTimeouts) parseLine(line string) (err error) {
+	spec, rest, err := splitDomainSpec(line)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	key, durStr, m := spec, strings.TrimSpace(rest), t.groups
+	if spec == "" {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return errors.Error("want an address and a timeout")
+		}
+
+		key, durStr, m = fields[0], fields[1], t.addrs
+	}
+
+	if _, ok := m[key]; ok {
+		return fmt.Errorf("duplicate timeout for %s", key)
+	}
+
+	d, err := time.ParseDuration(durStr)
+	if err != nil {
+		return fmt.Errorf("timeout for %s: %w", key, err)
+	} else if d <= 0 {
+		return fmt.Errorf("timeout for %s: %w: %s", key, errors.ErrNotPositive, d)
+	}
+
+	m[key] = d
+
+	return nil
+}
+
+// timeout returns the timeout of the upstream with addr from the group with
+// spec, which is empty for the general upstreams.  Timeouts of the upstreams
+// take precedence over the ones of the groups.
+func (t *upstreamTimeouts) timeout(spec, addr string) (d time.Duration, ok bool) {
+	if d, ok = t.addrs[addr]; ok || spec == "" {
+		return d, ok
+	}
+
+	d, ok = t.groups[spec]
+
+	return d, ok
+}
+
+// applyUpstreamTimeouts replaces the upstreams of uc, which has been parsed from
+// upstreams using opts, with the ones using the timeouts from t.  groupBoots
+// are the bootstrap resolvers of the groups of domain-specific upstreams.
+// attemptTimeout returns the timeout of a single exchange for the whole
+// timeout of an upstream.  totals are the whole timeouts of the replaced
+// upstreams.
+//
+// The replaced upstreams don't need to be closed, since they aren't used for
+// exchanging yet.
+func applyUpstreamTimeouts(
+	uc *proxy.UpstreamConfig,
+	upstreams []string,
+	t *upstreamTimeouts,
+	groupBoots map[string]upstream.Resolver,
+	opts *upstream.Options,
+	attemptTimeout func(d time.Duration) (attempt time.Duration),
+) (totals map[upstream.Upstream]time.Duration, err error) {
+	totals = map[upstream.Upstream]time.Duration{}
+	if len(t.groups) == 0 && len(t.addrs) == 0 {
+		return totals, nil
+	}
+
+	for _, line := range stringutil.FilterOut(upstreams, IsCommentOrEmpty) {
+		spec, rest, _ := splitDomainSpec(line)
+
+		// Mirror the parsing of the upstream configuration, where the lines
+		// without domain specifications contain a single upstream.
+		addrs := []string{strings.TrimSpace(rest)}
+		if spec != "" {
+			addrs = strings.Fields(rest)
+		}
+
+		for _, addr := range addrs {
+			d, ok := t.timeout(spec, addr)
+			if !ok || addr == "#" {
+				continue
+			}
+
+			upsOpts := opts.Clone()
+			upsOpts.Timeout = attemptTimeout(d)
+			if boot, hasBoot := groupBoots[spec]; hasBoot {
+				upsOpts.Bootstrap = boot
+			}
+
+			var conf *proxy.UpstreamConfig
+			conf, err = proxy.ParseUpstreamsConfig([]string{spec + addr}, upsOpts)
+			if err != nil {
+				return nil, fmt.Errorf("parsing upstream %s%s: %w", spec, addr, err)
+			}
+
+			replaceUpstreamList(uc.Upstreams, conf.Upstreams)
+			replaceUpstreams(uc.DomainReservedUpstreams, conf.DomainReservedUpstreams)
+			replaceUpstreams(uc.SpecifiedDomainUpstreams, conf.SpecifiedDomainUpstreams)
+
+			addTotals(totals, conf, d)
+		}
+	}
+
+	return totals, nil
+}
+
+// addTotals sets the whole timeout of each upstream of conf in totals to d.
+func addTotals(
+	totals map[upstream.Upstream]time.Duration,
+	conf *proxy.UpstreamConfig,
+	d time.Duration,
+) {
+	for _, u := range conf.Upstreams {
+		totals[u] = d
+	}
+
+	for _, specUps := range []map[string][]upstream.Upstream{
+		conf.DomainReservedUpstreams,
+		conf.SpecifiedDomainUpstreams,
+	} {
+		for _, ups := range specUps {
+			for _, u := range ups {
+				totals[u] = d
+			}
+		}
+	}
+}
+
+// replaceUpstreamList replaces the upstreams in dst with the upstreams from src
+// having the same address.
+func replaceUpstreamList(dst, src []upstream.Upstream) {
+	replaceUpstreams(
+		map[string][]upstream.Upstream{"": dst},
+		map[string][]upstream.Upstream{"": src},
+	)
+}
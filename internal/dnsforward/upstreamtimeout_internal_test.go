@@ -0,0 +1,137 @@
+package dnsforward
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseUpstreamTimeouts(t *testing.T) {
+	testCases := []struct {
+		name       string
+		wantErrMsg string
+		lines      []string
+	}{{
+		name:       "success",
+		wantErrMsg: "",
+		lines: []string{
+			"# comment",
+			"[/example.org/]5s",
+			"tls://dns.example 10s",
+		},
+	}, {
+		name:       "bad_spec",
+		wantErrMsg: "upstream timeout at index 0: wrong domain specification format",
+		lines:      []string{"[/example.org5s"},
+	}, {
+		name:       "no_timeout",
+		wantErrMsg: "upstream timeout at index 0: want an address and a timeout",
+		lines:      []string{"tls://dns.example"},
+	}, {
+		name: "bad_timeout",
+		wantErrMsg: `upstream timeout at index 0: timeout for [/example.org/]: ` +
+			`time: invalid duration "fast"`,
+		lines: []string{"[/example.org/]fast"},
+	}, {
+		name: "not_positive",
+		wantErrMsg: "upstream timeout at index 0: timeout for tls://dns.example: " +
+			"not positive: 0s",
+		lines: []string{"tls://dns.example 0s"},
+	}, {
+		name:       "duplicate",
+		wantErrMsg: "upstream timeout at index 1: duplicate timeout for [/example.org/]",
+		lines:      []string{"[/example.org/]5s", "[/EXAMPLE.org/]10s"},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseUpstreamTimeouts(tc.lines)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
+
+func TestApplyUpstreamTimeouts(t *testing.T) {
+	const (
+		delay       = 200 * time.Millisecond
+		timeout     = 50 * time.Millisecond
+		slowTimeout = 1 * time.Second
+	)
+
+	newSlowUps := func() (addr string) {
+		hdlr := dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+			time.Sleep(delay)
+
+			_ = w.WriteMsg((&dns.Msg{}).SetReply(req))
+		})
+
+		return aghtest.StartLocalhostUpstream(t, hdlr).String()
+	}
+
+	defaultAddr := newSlowUps()
+	groupAddr := newSlowUps()
+	otherAddr := newSlowUps()
+
+	upstreams := []string{
+		defaultAddr,
+		otherAddr,
+		"[/slow.example/]" + groupAddr,
+	}
+
+	opts := &upstream.Options{
+		Timeout: timeout,
+	}
+
+	uc, err := newUpstreamConfig(upstreams, nil, opts)
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, uc.Close)
+
+	timeouts, err := parseUpstreamTimeouts([]string{
+		"[/slow.example/]" + slowTimeout.String(),
+		otherAddr + " " + slowTimeout.String(),
+	})
+	require.NoError(t, err)
+
+	totals, err := applyUpstreamTimeouts(
+		uc,
+		upstreams,
+		timeouts,
+		nil,
+		opts,
+		func(d time.Duration) (attempt time.Duration) { return d },
+	)
+	require.NoError(t, err)
+
+	require.Len(t, uc.Upstreams, 2)
+	require.Len(t, uc.DomainReservedUpstreams["slow.example."], 1)
+
+	defaultUps, otherUps := uc.Upstreams[0], uc.Upstreams[1]
+	groupUps := uc.DomainReservedUpstreams["slow.example."][0]
+
+	assert.Equal(t, slowTimeout, totals[groupUps])
+	assert.Equal(t, slowTimeout, totals[otherUps])
+	assert.NotContains(t, totals, defaultUps)
+
+	req := (&dns.Msg{}).SetQuestion("www.slow.example.", dns.TypeA)
+
+	t.Run("default", func(t *testing.T) {
+		_, exchErr := defaultUps.Exchange(req)
+		assert.Error(t, exchErr)
+	})
+
+	t.Run("group", func(t *testing.T) {
+		_, exchErr := groupUps.Exchange(req)
+		assert.NoError(t, exchErr)
+	})
+
+	t.Run("upstream", func(t *testing.T) {
+		_, exchErr := otherUps.Exchange(req)
+		assert.NoError(t, exchErr)
+	})
+}
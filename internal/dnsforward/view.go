@@ -0,0 +1,246 @@
+package dnsforward
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/container"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/stringutil"
+)
+
+// View is the configuration of the upstreams for the queries from the clients
+// within the subnets.
+type View struct {
+	// Name is the unique name of the view.
+	Name string `yaml:"name"`
+
+	// Subnets are the subnets the source addresses of the queries are matched
+	// against.
+	Subnets []netip.Prefix `yaml:"subnets"`
+
+	// Upstreams are the upstreams for the queries from the subnets.  The
+	// syntax is the same as the one of [Config.UpstreamDNS].
+	Upstreams []string `yaml:"upstreams"`
+
+	// Bootstrap are the plain DNS servers used to resolve the hostnames of the
+	// upstreams.  If empty, [Config.BootstrapDNS] is used.
+	Bootstrap []string `yaml:"bootstrap"`
+}
+
+// Clone returns a deep copy of v.
+func (v *View) Clone() (clone *View) {
+	return &View{
+		Name:      v.Name,
+		Subnets:   slices.Clone(v.Subnets),
+		Upstreams: slices.Clone(v.Upstreams),
+		Bootstrap: slices.Clone(v.Bootstrap),
+	}
+}
+
+// cloneViews returns a deep copy of views.
+func cloneViews(views []*View) (clone []*View) {
+	if views == nil {
+		return nil
+	}
+
+	clone = make([]*View, 0, len(views))
+	for _, v := range views {
+		clone = append(clone, v.Clone())
+	}
+
+	return clone
+}
+
+// validate returns an error if v is invalid.  It doesn't check the uniqueness
+// of the name.
+func (v *View) validate() (err error) {
+	if v.Name == "" {
+		return errors.Error("name: empty value")
+	}
+
+	defer func() { err = errors.Annotate(err, "view %q: %w", v.Name) }()
+
+	if len(v.Subnets) == 0 {
+		return errors.Error("no subnets")
+	}
+
+	for i, s := range v.Subnets {
+		if !s.IsValid() {
+			return fmt.Errorf("subnet at index %d: bad value", i)
+		}
+	}
+
+	upstreams := stringutil.FilterOut(v.Upstreams, IsCommentOrEmpty)
+	if len(upstreams) == 0 {
+		return errors.Error("no upstreams")
+	}
+
+	uc, err := proxy.ParseUpstreamsConfig(upstreams, &upstream.Options{})
+	err = errors.WithDeferred(err, uc.Close())
+	if err != nil {
+		return fmt.Errorf("upstreams: %w", err)
+	}
+
+	for _, addr := range stringutil.FilterOut(v.Bootstrap, IsCommentOrEmpty) {
+		if err = checkPlainDNS(addr); err != nil {
+			return fmt.Errorf("bootstrap %q: %w", addr, err)
+		}
+	}
+
+	return nil
+}
+
+// validateViews returns an error if any of views is invalid or if their names
+// aren't unique.
+func validateViews(views []*View) (err error) {
+	names := container.NewMapSet[string]()
+	for i, v := range views {
+		if names.Has(v.Name) {
+			err = fmt.Errorf("duplicate name %q", v.Name)
+		} else {
+			err = v.validate()
+		}
+
+		if err != nil {
+			return fmt.Errorf("view at index %d: %w", i, err)
+		}
+
+		names.Add(v.Name)
+	}
+
+	return nil
+}
+
+// preparedView is the prepared upstream configuration of a view.
+type preparedView struct {
+	// conf is the custom upstream configuration used for the queries from the
+	// subnets of the view.
+	conf *proxy.CustomUpstreamConfig
+
+	// name is the name of the view.
+	name string
+
+	// subnets are the subnets of the view.
+	subnets []netip.Prefix
+}
+
+// newViews returns the prepared upstream configurations for confs.  etcHosts
+// and opts are used to create the bootstrap resolvers of the views, opts are
+// also the base options of the upstreams.  boots are the bootstrap resolvers
+// that should be closed after use.  The upstream configurations use a separate
+// cache of cacheSize bytes, unless it's zero, and their copies without caches
+// are stored in uncached.
+func newViews(
+	confs []*View,
+	etcHosts upstream.Resolver,
+	opts *upstream.Options,
+	cacheSize uint32,
+	enableECS bool,
+	uncached *uncachedUpstreams,
+) (views []*preparedView, boots []*upstream.UpstreamResolver, err error) {
+	err = validateViews(confs)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, nil, err
+	}
+
+	for i, c := range confs {
+		var v *preparedView
+		var viewBoots []*upstream.UpstreamResolver
+		v, viewBoots, err = newView(c, etcHosts, opts, cacheSize, enableECS, uncached)
+		boots = append(boots, viewBoots...)
+		if err != nil {
+			closeViews(views, uncached)
+
+			return nil, boots, fmt.Errorf("view at index %d: %w", i, err)
+		}
+
+		views = append(views, v)
+	}
+
+	return views, boots, nil
+}
+
+// newView returns the prepared upstream configuration for c, which must be
+// valid.
+func newView(
+	c *View,
+	etcHosts upstream.Resolver,
+	opts *upstream.Options,
+	cacheSize uint32,
+	enableECS bool,
+	uncached *uncachedUpstreams,
+) (v *preparedView, boots []*upstream.UpstreamResolver, err error) {
+	viewOpts := opts
+	if addrs := stringutil.FilterOut(c.Bootstrap, IsCommentOrEmpty); len(addrs) > 0 {
+		viewOpts = opts.Clone()
+		viewOpts.Bootstrap, boots, err = newBootstrap(addrs, etcHosts, opts)
+		if err != nil {
+			return nil, boots, fmt.Errorf("view %q: bootstrap: %w", c.Name, err)
+		}
+	}
+
+	upstreams := stringutil.FilterOut(c.Upstreams, IsCommentOrEmpty)
+	uc, err := proxy.ParseUpstreamsConfig(upstreams, viewOpts)
+	if err != nil {
+		return nil, boots, fmt.Errorf("view %q: upstreams: %w", c.Name, err)
+	}
+
+	conf := proxy.NewCustomUpstreamConfig(uc, cacheSize != 0, int(cacheSize), enableECS)
+	uncached.add(conf, uc)
+
+	return &preparedView{
+		conf:    conf,
+		name:    c.Name,
+		subnets: slices.Clone(c.Subnets),
+	}, boots, nil
+}
+
+// closeViews closes the upstreams of views and logs the errors, if any.  The
+// copies of their configurations are removed from uncached.
+func closeViews(views []*preparedView, uncached *uncachedUpstreams) {
+	for _, v := range views {
+		uncached.forget(v.conf)
+		logCloserErr(v.conf, "dnsforward: closing upstreams of view %q: %s", v.name)
+	}
+}
+
+// viewUpstreamConfig returns the upstream configuration of the first view with
+// a subnet containing addr.  conf is nil if there is no such view.
+func (s *Server) viewUpstreamConfig(addr netip.Addr) (conf *proxy.CustomUpstreamConfig) {
+	if !addr.IsValid() {
+		return nil
+	}
+
+	addr = addr.Unmap()
+	for _, v := range s.views {
+		if slices.ContainsFunc(v.subnets, func(p netip.Prefix) (ok bool) {
+			return p.Contains(addr)
+		}) {
+			return v.conf
+		}
+	}
+
+	return nil
+}
+
+// setViewUpstream sets the custom upstream configuration of the view matching
+// addr, the address of the client, if there is no other custom configuration
+// in pctx already.
+func (s *Server) setViewUpstream(pctx *proxy.DNSContext, addr netip.Addr) {
+	if pctx.CustomUpstreamConfig != nil || len(s.views) == 0 {
+		return
+	}
+
+	conf := s.viewUpstreamConfig(addr)
+	if conf != nil {
+		log.Debug("dnsforward: using view upstreams for %s", addr)
+
+		pctx.CustomUpstreamConfig = conf
+	}
+}
@@ -0,0 +1,176 @@
+package dnsforward
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_setViewUpstream(t *testing.T) {
+	newUps := func(ip net.IP) (addr string) {
+		hdlr := dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+			resp := (&dns.Msg{}).SetReply(req)
+			resp.Answer = []dns.RR{&dns.A{
+				Hdr: dns.RR_Header{
+					Name:   req.Question[0].Name,
+					Rrtype: dns.TypeA,
+					Class:  dns.ClassINET,
+					Ttl:    60,
+				},
+				A: ip,
+			}}
+
+			require.NoError(testutil.PanicT{}, w.WriteMsg(resp))
+		})
+
+		return aghtest.StartLocalhostUpstream(t, hdlr).String()
+	}
+
+	defaultIP := net.IP{192, 0, 2, 1}
+	corpIP := net.IP{192, 0, 2, 2}
+	guestIP := net.IP{192, 0, 2, 3}
+
+	s := createTestServer(t, &filtering.Config{
+		BlockingMode: filtering.BlockingModeDefault,
+	}, ServerConfig{
+		UDPListenAddrs: []*net.UDPAddr{{IP: net.IP{127, 0, 0, 1}}},
+		TCPListenAddrs: []*net.TCPAddr{{IP: net.IP{127, 0, 0, 1}}},
+		Config: Config{
+			UpstreamDNS:      []string{newUps(defaultIP)},
+			UpstreamMode:     UpstreamModeLoadBalance,
+			EDNSClientSubnet: &EDNSClientSubnet{Enabled: false},
+			Views: []*View{{
+				Name:      "corp",
+				Subnets:   []netip.Prefix{netip.MustParsePrefix("10.0.1.0/24")},
+				Upstreams: []string{newUps(corpIP)},
+			}, {
+				Name: "guest",
+				Subnets: []netip.Prefix{
+					netip.MustParsePrefix("10.0.2.0/24"),
+					netip.MustParsePrefix("2001:db8::/32"),
+				},
+				Upstreams: []string{newUps(guestIP)},
+			}},
+		},
+		ServePlainDNS: true,
+	})
+	startDeferStop(t, s)
+
+	testCases := []struct {
+		addr   netip.AddrPort
+		name   string
+		wantIP net.IP
+	}{{
+		addr:   netip.MustParseAddrPort("10.0.1.10:53"),
+		name:   "corp",
+		wantIP: corpIP,
+	}, {
+		addr:   netip.MustParseAddrPort("10.0.2.10:53"),
+		name:   "guest",
+		wantIP: guestIP,
+	}, {
+		addr:   netip.MustParseAddrPort("[2001:db8::1]:53"),
+		name:   "guest_ipv6",
+		wantIP: guestIP,
+	}, {
+		addr:   netip.MustParseAddrPort("10.0.3.10:53"),
+		name:   "no_view",
+		wantIP: defaultIP,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pctx := &proxy.DNSContext{
+				Proto: proxy.ProtoUDP,
+				Req:   createTestMessage("example.com."),
+				Addr:  tc.addr,
+			}
+
+			err := s.handleDNSRequest(s.dnsProxy, pctx)
+			require.NoError(t, err)
+			require.NotNil(t, pctx.Res)
+			require.Len(t, pctx.Res.Answer, 1)
+
+			a := testutil.RequireTypeAssert[*dns.A](t, pctx.Res.Answer[0])
+			assert.Equal(t, tc.wantIP.To16(), a.A.To16())
+		})
+	}
+}
+
+func TestValidateViews(t *testing.T) {
+	subnets := []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}
+	upstreams := []string{"192.0.2.53"}
+
+	testCases := []struct {
+		name       string
+		wantErrMsg string
+		views      []*View
+	}{{
+		name:       "success",
+		wantErrMsg: "",
+		views: []*View{{
+			Name:      "view",
+			Subnets:   subnets,
+			Upstreams: upstreams,
+			Bootstrap: []string{"192.0.2.1"},
+		}},
+	}, {
+		name:       "no_name",
+		wantErrMsg: "view at index 0: name: empty value",
+		views: []*View{{
+			Subnets:   subnets,
+			Upstreams: upstreams,
+		}},
+	}, {
+		name:       "no_subnets",
+		wantErrMsg: `view at index 0: view "view": no subnets`,
+		views: []*View{{
+			Name:      "view",
+			Upstreams: upstreams,
+		}},
+	}, {
+		name:       "no_upstreams",
+		wantErrMsg: `view at index 0: view "view": no upstreams`,
+		views: []*View{{
+			Name:      "view",
+			Subnets:   subnets,
+			Upstreams: []string{"# comment"},
+		}},
+	}, {
+		name: "bad_bootstrap",
+		wantErrMsg: `view at index 0: view "view": bootstrap "tls://dns.example": ` +
+			`not an ip address of a plain dns server`,
+		views: []*View{{
+			Name:      "view",
+			Subnets:   subnets,
+			Upstreams: upstreams,
+			Bootstrap: []string{"tls://dns.example"},
+		}},
+	}, {
+		name:       "duplicate",
+		wantErrMsg: `view at index 1: duplicate name "view"`,
+		views: []*View{{
+			Name:      "view",
+			Subnets:   subnets,
+			Upstreams: upstreams,
+		}, {
+			Name:      "view",
+			Subnets:   subnets,
+			Upstreams: upstreams,
+		}},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, validateViews(tc.views))
+		})
+	}
+}
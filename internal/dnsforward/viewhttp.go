@@ -0,0 +1,217 @@
+package dnsforward
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"slices"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// viewJSON is the JSON representation of a [View].
+type viewJSON struct {
+	Name      string         `json:"name"`
+	Subnets   []netip.Prefix `json:"subnets"`
+	Upstreams []string       `json:"upstreams"`
+	Bootstrap []string       `json:"bootstrap"`
+}
+
+// toView returns a new view from j.
+func (j *viewJSON) toView() (v *View) {
+	return &View{
+		Name:      j.Name,
+		Subnets:   slices.Clone(j.Subnets),
+		Upstreams: slices.Clone(j.Upstreams),
+		Bootstrap: slices.Clone(j.Bootstrap),
+	}
+}
+
+// viewToJSON returns the JSON representation of v.
+func viewToJSON(v *View) (j *viewJSON) {
+	j = &viewJSON{
+		Name:      v.Name,
+		Subnets:   slices.Clone(v.Subnets),
+		Upstreams: slices.Clone(v.Upstreams),
+		Bootstrap: slices.Clone(v.Bootstrap),
+	}
+
+	if j.Subnets == nil {
+		j.Subnets = []netip.Prefix{}
+	}
+
+	if j.Upstreams == nil {
+		j.Upstreams = []string{}
+	}
+
+	if j.Bootstrap == nil {
+		j.Bootstrap = []string{}
+	}
+
+	return j
+}
+
+// viewDeleteJSON is the request to the POST /control/dns/views/delete HTTP
+// API.
+type viewDeleteJSON struct {
+	Name string `json:"name"`
+}
+
+// viewUpdateJSON is the request to the PUT /control/dns/views/update HTTP API.
+type viewUpdateJSON struct {
+	Data *viewJSON `json:"data"`
+	Name string    `json:"name"`
+}
+
+// errViewNotFound is returned when a view with the requested name doesn't
+// exist.
+const errViewNotFound errors.Error = "view not found"
+
+// handleViewList is the handler for the GET /control/dns/views/list HTTP API.
+func (s *Server) handleViewList(w http.ResponseWriter, r *http.Request) {
+	views := []*viewJSON{}
+
+	func() {
+		s.serverLock.RLock()
+		defer s.serverLock.RUnlock()
+
+		for _, v := range s.conf.Views {
+			views = append(views, viewToJSON(v))
+		}
+	}()
+
+	aghhttp.WriteJSONResponseOK(w, r, views)
+}
+
+// handleViewAdd is the handler for the POST /control/dns/views/add HTTP API.
+func (s *Server) handleViewAdd(w http.ResponseWriter, r *http.Request) {
+	req := &viewJSON{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding request: %s", err)
+
+		return
+	}
+
+	s.updateViews(w, r, func(views []*View) (updated []*View, err error) {
+		log.Debug("dnsforward: adding view %q", req.Name)
+
+		return append(views, req.toView()), nil
+	})
+}
+
+// handleViewDelete is the handler for the POST /control/dns/views/delete HTTP
+// API.
+func (s *Server) handleViewDelete(w http.ResponseWriter, r *http.Request) {
+	req := &viewDeleteJSON{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding request: %s", err)
+
+		return
+	}
+
+	s.updateViews(w, r, func(views []*View) (updated []*View, err error) {
+		i := viewIndex(views, req.Name)
+		if i < 0 {
+			return nil, fmt.Errorf("view %q: %w", req.Name, errViewNotFound)
+		}
+
+		log.Debug("dnsforward: removing view %q", req.Name)
+
+		return slices.Delete(views, i, i+1), nil
+	})
+}
+
+// handleViewUpdate is the handler for the PUT /control/dns/views/update HTTP
+// API.
+func (s *Server) handleViewUpdate(w http.ResponseWriter, r *http.Request) {
+	req := &viewUpdateJSON{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding request: %s", err)
+
+		return
+	} else if req.Data == nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "data: no value")
+
+		return
+	}
+
+	s.updateViews(w, r, func(views []*View) (updated []*View, err error) {
+		i := viewIndex(views, req.Name)
+		if i < 0 {
+			return nil, fmt.Errorf("view %q: %w", req.Name, errViewNotFound)
+		}
+
+		log.Debug("dnsforward: updating view %q", req.Name)
+
+		views[i] = req.Data.toView()
+
+		return views, nil
+	})
+}
+
+// viewIndex returns the index of the view with the name in views or -1 if
+// there is no such view.
+func viewIndex(views []*View, name string) (i int) {
+	return slices.IndexFunc(views, func(v *View) (ok bool) { return v.Name == name })
+}
+
+// updateViews applies upd to a copy of the configured views, validates the
+// result, and, if it's valid, reconfigures the server to use the updated
+// views.  Any error is written to w.
+func (s *Server) updateViews(
+	w http.ResponseWriter,
+	r *http.Request,
+	upd func(views []*View) (updated []*View, err error),
+) {
+	err := func() (err error) {
+		s.serverLock.Lock()
+		defer s.serverLock.Unlock()
+
+		views, err := upd(cloneViews(s.conf.Views))
+		if err != nil {
+			// Don't wrap the error since it's informative enough as is.
+			return err
+		}
+
+		err = validateViews(views)
+		if err != nil {
+			// Don't wrap the error since it's informative enough as is.
+			return err
+		}
+
+		s.conf.Views = views
+
+		return nil
+	}()
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
+	s.conf.ConfigModified()
+
+	err = s.Reconfigure(nil)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "%s", err)
+	}
+}
+
+// viewByName returns a copy of the configured view with the name.
+func (s *Server) viewByName(name string) (v *View, err error) {
+	s.serverLock.RLock()
+	defer s.serverLock.RUnlock()
+
+	i := viewIndex(s.conf.Views, name)
+	if i < 0 {
+		return nil, fmt.Errorf("view %q: %w", name, errViewNotFound)
+	}
+
+	return s.conf.Views[i].Clone(), nil
+}
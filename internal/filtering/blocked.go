@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"slices"
+	"sync/atomic"
 	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
@@ -14,19 +15,54 @@ import (
 	"github.com/AdguardTeam/urlfilter/rules"
 )
 
-// serviceRules maps a service ID to its filtering rules.
-var serviceRules map[string][]*rules.NetworkRule
+// Sources of the blocked services index.
+const (
+	servicesSourceEmbedded = "embedded"
+	servicesSourceFile     = "file"
+	servicesSourceURL      = "url"
+)
 
-// serviceIDs contains service IDs sorted alphabetically.
-var serviceIDs []string
+// servicesIndex is the index of the blocked services.  It must not be modified
+// after creation.
+type servicesIndex struct {
+	// updated is the time the index has been updated at.  It's zero for the
+	// embedded index.
+	updated time.Time
 
-// initBlockedServices initializes package-level blocked service data.
-func initBlockedServices() {
-	l := len(blockedServices)
-	serviceIDs = make([]string, l)
-	serviceRules = make(map[string][]*rules.NetworkRule, l)
+	// rules maps a service ID to its filtering rules.
+	rules map[string][]*rules.NetworkRule
+
+	// source is the source of the index, one of the servicesSource constants.
+	source string
+
+	// services are the blocked services.
+	services []blockedService
+
+	// ids contains service IDs sorted alphabetically.
+	ids []string
+}
+
+// currentServices is the current index of the blocked services.  It's swapped
+// when the index is loaded or refreshed.
+var currentServices atomic.Pointer[servicesIndex]
+
+// newServicesIndex returns a new index of svcs.  The invalid rules are logged
+// and skipped.
+func newServicesIndex(
+	svcs []blockedService,
+	source string,
+	updated time.Time,
+) (idx *servicesIndex) {
+	l := len(svcs)
+	idx = &servicesIndex{
+		updated:  updated,
+		rules:    make(map[string][]*rules.NetworkRule, l),
+		source:   source,
+		services: svcs,
+		ids:      make([]string, l),
+	}
 
-	for i, s := range blockedServices {
+	for i, s := range svcs {
 		netRules := make([]*rules.NetworkRule, 0, len(s.Rules))
 		for _, text := range s.Rules {
 			rule, err := rules.NewNetworkRule(text, rulelist.URLFilterIDBlockedService)
@@ -39,13 +75,20 @@ func initBlockedServices() {
 			netRules = append(netRules, rule)
 		}
 
-		serviceIDs[i] = s.ID
-		serviceRules[s.ID] = netRules
+		idx.ids[i] = s.ID
+		idx.rules[s.ID] = netRules
 	}
 
-	slices.Sort(serviceIDs)
+	slices.Sort(idx.ids)
+
+	return idx
+}
+
+// initBlockedServices initializes package-level blocked service data.
+func initBlockedServices() {
+	currentServices.Store(newServicesIndex(blockedServices, servicesSourceEmbedded, time.Time{}))
 
-	log.Debug("filtering: initialized %d services", l)
+	log.Debug("filtering: initialized %d services", len(blockedServices))
 }
 
 // BlockedServices is the configuration of blocked services.
@@ -73,7 +116,7 @@ func (s *BlockedServices) Clone() (c *BlockedServices) {
 // must not be nil.
 func (s *BlockedServices) Validate() (err error) {
 	for _, id := range s.IDs {
-		_, ok := serviceRules[id]
+		_, ok := currentServices.Load().rules[id]
 		if !ok {
 			return fmt.Errorf("unknown blocked-service %q", id)
 		}
@@ -88,42 +131,91 @@ func (d *DNSFilter) ApplyBlockedServices(setts *Settings) {
 	defer d.confMu.RUnlock()
 
 	setts.ServicesRules = []ServiceEntry{}
+	setts.PausedServicesRules = nil
 
 	bsvc := d.conf.BlockedServices
 
 	// TODO(s.chzhen):  Use startTime from [dnsforward.dnsContext].
 	if !bsvc.Schedule.Contains(time.Now()) {
 		d.ApplyBlockedServicesList(setts, bsvc.IDs)
+	} else if d.conf.DecisionDetails {
+		setts.PausedServicesRules = appendServiceEntries(nil, bsvc.IDs)
 	}
 }
 
 // ApplyBlockedServicesList appends filtering rules to the settings.
 func (d *DNSFilter) ApplyBlockedServicesList(setts *Settings, list []string) {
+	setts.ServicesRules = appendServiceEntries(setts.ServicesRules, list)
+}
+
+// ApplyPausedBlockedServicesList appends the filtering rules of the services
+// paused by the schedule to the settings, if the decision details are enabled.
+func (d *DNSFilter) ApplyPausedBlockedServicesList(setts *Settings, list []string) {
+	if !d.decisionDetailsEnabled() {
+		return
+	}
+
+	setts.PausedServicesRules = appendServiceEntries(setts.PausedServicesRules, list)
+}
+
+// appendServiceEntries appends the entries of the services from list to
+// entries and returns the result.
+func appendServiceEntries(entries []ServiceEntry, list []string) (res []ServiceEntry) {
+	idx := currentServices.Load()
 	for _, name := range list {
-		rules, ok := serviceRules[name]
+		rules, ok := idx.rules[name]
 		if !ok {
 			log.Error("unknown service name: %s", name)
 
 			continue
 		}
 
-		setts.ServicesRules = append(setts.ServicesRules, ServiceEntry{
+		entries = append(entries, ServiceEntry{
 			Name:  name,
 			Rules: rules,
 		})
 	}
+
+	return entries
 }
 
 func (d *DNSFilter) handleBlockedServicesIDs(w http.ResponseWriter, r *http.Request) {
-	aghhttp.WriteJSONResponseOK(w, r, serviceIDs)
+	aghhttp.WriteJSONResponseOK(w, r, currentServices.Load().ids)
+}
+
+// blockedServicesAllResp is the response for the GET
+// /control/blocked_services/all HTTP API.
+type blockedServicesAllResp struct {
+	// UpdatedAt is the time the index has been updated at.  It's nil for the
+	// embedded index.
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+
+	// Source is the source of the index, one of "embedded", "file", and "url".
+	Source string `json:"source"`
+
+	BlockedServices []blockedService `json:"blocked_services"`
+}
+
+// newBlockedServicesAllResp returns the response for the GET
+// /control/blocked_services/all HTTP API with the data from idx.
+func newBlockedServicesAllResp(idx *servicesIndex) (resp *blockedServicesAllResp) {
+	resp = &blockedServicesAllResp{
+		Source:          idx.source,
+		BlockedServices: idx.services,
+	}
+
+	if !idx.updated.IsZero() {
+		updated := idx.updated
+		resp.UpdatedAt = &updated
+	}
+
+	return resp
 }
 
+// handleBlockedServicesAll is the handler for the GET
+// /control/blocked_services/all HTTP API.
 func (d *DNSFilter) handleBlockedServicesAll(w http.ResponseWriter, r *http.Request) {
-	aghhttp.WriteJSONResponseOK(w, r, struct {
-		BlockedServices []blockedService `json:"blocked_services"`
-	}{
-		BlockedServices: blockedServices,
-	})
+	aghhttp.WriteJSONResponseOK(w, r, newBlockedServicesAllResp(currentServices.Load()))
 }
 
 // handleBlockedServicesList is the handler for the GET
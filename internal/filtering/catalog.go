@@ -0,0 +1,389 @@
+package filtering
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/golibs/container"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/ioutil"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/c2h5oh/datasize"
+)
+
+// embeddedCatalogData is the catalog of the well-known filter lists shipped
+// with AdGuard Home.  It's used until the catalog is refreshed from
+// [Config.CatalogIndexURL] and whenever the refresh fails.
+//
+//go:embed catalog.json
+var embeddedCatalogData []byte
+
+// maxCatalogSize is the maximum size of the catalog index.
+const maxCatalogSize = 1 * datasize.MB
+
+// Categories of the filter lists in the catalog.
+const (
+	catalogCategoryGeneral  = "general"
+	catalogCategoryOther    = "other"
+	catalogCategoryRegional = "regional"
+	catalogCategorySecurity = "security"
+)
+
+// catalogFilter is a filter list in the catalog.  The catalog is purely
+// informational, so the lists are never subscribed to automatically.
+type catalogFilter struct {
+	// ID is the unique identifier of the list, for example
+	// "adguard_dns_filter".
+	ID string `json:"id"`
+
+	// Name is the human-readable name of the list.
+	Name string `json:"name"`
+
+	// URL is the URL of the rules of the list.
+	URL string `json:"url"`
+
+	// Homepage is the URL of the homepage of the list, if any.
+	Homepage string `json:"homepage,omitempty"`
+
+	// Category is the category of the list, one of catalogCategory constants.
+	Category string `json:"category"`
+
+	// Maintainer is the name of the maintainer of the list.
+	Maintainer string `json:"maintainer"`
+
+	// Languages are the ISO 639-1 codes of the languages the list is intended
+	// for.  It's empty for the lists not specific to any language.
+	Languages []string `json:"languages,omitempty"`
+
+	// Regions are the ISO 3166-1 alpha-2 codes of the regions the list is
+	// intended for.  It's empty for the lists not specific to any region.
+	Regions []string `json:"regions,omitempty"`
+}
+
+// validate returns an error if f isn't a valid catalog filter list.
+func (f *catalogFilter) validate() (err error) {
+	if f == nil {
+		return errors.ErrNoValue
+	}
+
+	var errs []error
+	if f.ID == "" {
+		errs = append(errs, fmt.Errorf("id: %w", errors.ErrEmptyValue))
+	}
+
+	if f.Name == "" {
+		errs = append(errs, fmt.Errorf("name: %w", errors.ErrEmptyValue))
+	}
+
+	errs = append(errs, validateCatalogURL("url", f.URL, false))
+	errs = append(errs, validateCatalogURL("homepage", f.Homepage, true))
+
+	switch f.Category {
+	case
+		catalogCategoryGeneral,
+		catalogCategoryOther,
+		catalogCategoryRegional,
+		catalogCategorySecurity:
+		// Go on.
+	default:
+		errs = append(errs, fmt.Errorf("category: %w: %q", errors.ErrBadEnumValue, f.Category))
+	}
+
+	for i, lang := range f.Languages {
+		if !isLetterCode(lang, 'a', 'z') {
+			errs = append(errs, fmt.Errorf("languages: at index %d: bad code %q", i, lang))
+		}
+	}
+
+	for i, reg := range f.Regions {
+		if !isLetterCode(reg, 'A', 'Z') {
+			errs = append(errs, fmt.Errorf("regions: at index %d: bad code %q", i, reg))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateCatalogURL returns an error if rawURL isn't a valid HTTP(S) URL.
+// name is the name of the property.  If optional is true, an empty rawURL is
+// valid.
+func validateCatalogURL(name, rawURL string, optional bool) (err error) {
+	if rawURL == "" {
+		if optional {
+			return nil
+		}
+
+		return fmt.Errorf("%s: %w", name, errors.ErrEmptyValue)
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%s: bad url scheme %q", name, u.Scheme)
+	}
+
+	return nil
+}
+
+// isLetterCode returns true if s is a two-letter code consisting of the
+// letters from lo to hi.
+func isLetterCode(s string, lo, hi rune) (ok bool) {
+	if len(s) != 2 {
+		return false
+	}
+
+	for _, c := range s {
+		if c < lo || c > hi {
+			return false
+		}
+	}
+
+	return true
+}
+
+// catalogIndex is the index of the catalog of the well-known filter lists.
+type catalogIndex struct {
+	Filters []*catalogFilter `json:"filters"`
+}
+
+// validate returns an error if idx isn't a valid catalog index.
+func (idx *catalogIndex) validate() (err error) {
+	if len(idx.Filters) == 0 {
+		return fmt.Errorf("filters: %w", errors.ErrEmptyValue)
+	}
+
+	ids := container.NewMapSet[string]()
+	urls := container.NewMapSet[string]()
+	for i, f := range idx.Filters {
+		err = f.validate()
+		if err != nil {
+			return fmt.Errorf("filter at index %d: %w", i, err)
+		}
+
+		if ids.Has(f.ID) {
+			return fmt.Errorf("filter at index %d: duplicate id %q", i, f.ID)
+		} else if urls.Has(f.URL) {
+			return fmt.Errorf("filter at index %d: duplicate url %q", i, f.URL)
+		}
+
+		ids.Add(f.ID)
+		urls.Add(f.URL)
+	}
+
+	return nil
+}
+
+// parseCatalogIndex decodes and validates the catalog index from r.
+func parseCatalogIndex(r io.Reader) (idx *catalogIndex, err error) {
+	idx = &catalogIndex{}
+	err = json.NewDecoder(r).Decode(idx)
+	if err != nil {
+		return nil, fmt.Errorf("decoding: %w", err)
+	}
+
+	err = idx.validate()
+	if err != nil {
+		return nil, fmt.Errorf("validating: %w", err)
+	}
+
+	return idx, nil
+}
+
+// filterCatalog is the catalog of the well-known filter lists, which is
+// refreshed from the index URL on the schedule of the filter lists updates.
+// It's safe for concurrent use.
+type filterCatalog struct {
+	// client is used to fetch the index.
+	client *http.Client
+
+	// embedded is the index shipped with AdGuard Home.
+	embedded *catalogIndex
+
+	// mu protects index and refreshed.
+	mu *sync.RWMutex
+
+	// index is the current index.
+	index *catalogIndex
+
+	// refreshed is the time of the latest refresh attempt.
+	refreshed time.Time
+
+	// indexURL is the URL of the index.  If empty, the embedded index is
+	// always used.
+	indexURL string
+}
+
+// newFilterCatalog returns a new properly initialized *filterCatalog.  If
+// indexURL is empty, the catalog isn't refreshed.
+func newFilterCatalog(client *http.Client, indexURL string) (c *filterCatalog, err error) {
+	err = validateCatalogURL("url", indexURL, true)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+
+	embedded, err := parseCatalogIndex(bytes.NewReader(embeddedCatalogData))
+	if err != nil {
+		// Should not happen.
+		return nil, fmt.Errorf("embedded catalog: %w", err)
+	}
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &filterCatalog{
+		client:   client,
+		embedded: embedded,
+		mu:       &sync.RWMutex{},
+		index:    embedded,
+		indexURL: indexURL,
+	}, nil
+}
+
+// refresh fetches the index, if ivl has passed since the previous attempt.
+// ivl of zero disables the refresh.  If the fetched index is invalid, the
+// embedded one is used.
+func (c *filterCatalog) refresh(ctx context.Context, now time.Time, ivl time.Duration) {
+	if c.indexURL == "" || ivl == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	due := !now.Before(c.refreshed.Add(ivl))
+	if due {
+		c.refreshed = now
+	}
+	c.mu.Unlock()
+
+	if !due {
+		return
+	}
+
+	idx, err := c.fetch(ctx)
+	if err != nil {
+		log.Error("filtering: catalog: refreshing: %s; using embedded index", err)
+
+		idx = c.embedded
+	} else {
+		log.Debug("filtering: catalog: refreshed %d filters", len(idx.Filters))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.index = idx
+}
+
+// fetch fetches and validates the index.
+func (c *filterCatalog) fetch(ctx context.Context) (idx *catalogIndex, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.indexURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+	defer func() { err = errors.WithDeferred(err, resp.Body.Close()) }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("got status code %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	return parseCatalogIndex(ioutil.LimitReader(resp.Body, maxCatalogSize.Bytes()))
+}
+
+// filters returns the filter lists from the current index for the language,
+// the region, and the category.  Empty values match all lists.
+func (c *filterCatalog) filters(lang, region, category string) (filters []*catalogFilter) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	filters = []*catalogFilter{}
+	for _, f := range c.index.Filters {
+		if category != "" && f.Category != category {
+			continue
+		}
+
+		if lang != "" && !slices.Contains(f.Languages, strings.ToLower(lang)) {
+			continue
+		}
+
+		if region != "" && !slices.Contains(f.Regions, strings.ToUpper(region)) {
+			continue
+		}
+
+		filters = append(filters, f)
+	}
+
+	return filters
+}
+
+// byURL returns the filter list with the rules URL from the current index, if
+// any.
+func (c *filterCatalog) byURL(rulesURL string) (f *catalogFilter, ok bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	i := slices.IndexFunc(c.index.Filters, func(f *catalogFilter) (ok bool) {
+		return f.URL == rulesURL
+	})
+	if i < 0 {
+		return nil, false
+	}
+
+	return c.index.Filters[i], true
+}
+
+// catalogResp is the response for the GET /control/filtering/catalog HTTP API.
+type catalogResp struct {
+	Filters []*catalogFilter `json:"filters"`
+}
+
+// handleFilteringCatalog is the handler for the GET /control/filtering/catalog
+// HTTP API.  The lists may be filtered by the language, region, and category
+// query parameters.
+func (d *DNSFilter) handleFilteringCatalog(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	category := q.Get("category")
+	switch category {
+	case
+		"",
+		catalogCategoryGeneral,
+		catalogCategoryOther,
+		catalogCategoryRegional,
+		catalogCategorySecurity:
+		// Go on.
+	default:
+		aghhttp.Error(
+			r,
+			w,
+			http.StatusBadRequest,
+			"category: %s: %q",
+			errors.ErrBadEnumValue,
+			category,
+		)
+
+		return
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, &catalogResp{
+		Filters: d.catalog.filters(q.Get("language"), q.Get("region"), category),
+	})
+}
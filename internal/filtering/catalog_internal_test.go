@@ -0,0 +1,190 @@
+package filtering
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCatalogIndex_validate(t *testing.T) {
+	newFilter := func() (f *catalogFilter) {
+		return &catalogFilter{
+			ID:         "test_list",
+			Name:       "Test List",
+			URL:        "https://filters.example/list.txt",
+			Category:   catalogCategoryRegional,
+			Maintainer: "Tester",
+			Languages:  []string{"de"},
+			Regions:    []string{"DE"},
+		}
+	}
+
+	badCategory := newFilter()
+	badCategory.Category = "ads"
+
+	badURL := newFilter()
+	badURL.URL = "ftp://filters.example/list.txt"
+
+	badCodes := newFilter()
+	badCodes.Languages = []string{"DE"}
+	badCodes.Regions = []string{"de"}
+
+	testCases := []struct {
+		name       string
+		wantErrMsg string
+		filters    []*catalogFilter
+	}{{
+		name:       "success",
+		wantErrMsg: "",
+		filters:    []*catalogFilter{newFilter()},
+	}, {
+		name:       "empty",
+		wantErrMsg: "filters: empty value",
+		filters:    nil,
+	}, {
+		name:       "nil_filter",
+		wantErrMsg: "filter at index 0: no value",
+		filters:    []*catalogFilter{nil},
+	}, {
+		name:       "bad_category",
+		wantErrMsg: `filter at index 0: category: bad enum value: "ads"`,
+		filters:    []*catalogFilter{badCategory},
+	}, {
+		name:       "bad_url",
+		wantErrMsg: `filter at index 0: url: bad url scheme "ftp"`,
+		filters:    []*catalogFilter{badURL},
+	}, {
+		name: "bad_codes",
+		wantErrMsg: `filter at index 0: languages: at index 0: bad code "DE"` + "\n" +
+			`regions: at index 0: bad code "de"`,
+		filters: []*catalogFilter{badCodes},
+	}, {
+		name:       "duplicate",
+		wantErrMsg: `filter at index 1: duplicate id "test_list"`,
+		filters:    []*catalogFilter{newFilter(), newFilter()},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			idx := &catalogIndex{Filters: tc.filters}
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, idx.validate())
+		})
+	}
+}
+
+func TestFilterCatalog_refresh(t *testing.T) {
+	const (
+		validIndex = `{"filters":[{"id":"remote","name":"Remote List",` +
+			`"url":"https://filters.example/remote.txt","category":"security",` +
+			`"maintainer":"Tester"}]}`
+		invalidIndex = `{"filters":[{"id":"remote","name":"Remote List",` +
+			`"url":"https://filters.example/remote.txt","category":"unknown",` +
+			`"maintainer":"Tester"}]}`
+
+		ivl = 24 * time.Hour
+	)
+
+	var (
+		body     atomic.Value
+		requests atomic.Int32
+	)
+
+	body.Store(validIndex)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+
+		_, _ = w.Write([]byte(body.Load().(string)))
+	}))
+	t.Cleanup(srv.Close)
+
+	c, err := newFilterCatalog(srv.Client(), srv.URL)
+	require.NoError(t, err)
+
+	embeddedLen := len(c.filters("", "", ""))
+	require.Greater(t, embeddedLen, 1)
+
+	ctx := context.Background()
+	now := time.Now()
+
+	c.refresh(ctx, now, ivl)
+	require.EqualValues(t, 1, requests.Load())
+
+	filters := c.filters("", "", "")
+	require.Len(t, filters, 1)
+
+	assert.Equal(t, "remote", filters[0].ID)
+
+	f, ok := c.byURL("https://filters.example/remote.txt")
+	require.True(t, ok)
+
+	assert.Equal(t, "Remote List", f.Name)
+
+	t.Run("not_due", func(t *testing.T) {
+		c.refresh(ctx, now.Add(ivl/2), ivl)
+		assert.EqualValues(t, 1, requests.Load())
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		body.Store(invalidIndex)
+
+		c.refresh(ctx, now.Add(ivl), ivl)
+		assert.EqualValues(t, 2, requests.Load())
+		assert.Len(t, c.filters("", "", ""), embeddedLen)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		c.refresh(ctx, now.Add(2*ivl), 0)
+		assert.EqualValues(t, 2, requests.Load())
+	})
+}
+
+func TestFilterCatalog_filters(t *testing.T) {
+	c, err := newFilterCatalog(nil, "")
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name     string
+		lang     string
+		region   string
+		category string
+		wantID   string
+	}{{
+		name:     "language",
+		lang:     "HU",
+		region:   "",
+		category: "",
+		wantID:   "HUN_hufilter",
+	}, {
+		name:     "region",
+		lang:     "",
+		region:   "il",
+		category: "",
+		wantID:   "ISR_easyList_hebrew",
+	}, {
+		name:     "category",
+		lang:     "uk",
+		region:   "",
+		category: catalogCategoryOther,
+		wantID:   "ukrainian_security_filter",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			filters := c.filters(tc.lang, tc.region, tc.category)
+			require.Len(t, filters, 1)
+
+			assert.Equal(t, tc.wantID, filters[0].ID)
+		})
+	}
+
+	t.Run("none", func(t *testing.T) {
+		assert.Empty(t, c.filters("hu", "", catalogCategorySecurity))
+	})
+}
@@ -0,0 +1,71 @@
+package filtering
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/AdguardTeam/golibs/errors"
+)
+
+// DefaultFilterCategory is the category of the filter lists, which have no
+// category set by the user.
+const DefaultFilterCategory = "Custom"
+
+// maxFilterCategoryLen is the maximum length of a filter list category, in
+// runes.
+const maxFilterCategoryLen = 64
+
+// validateFilterCategory returns an error if cat is not a valid filter list
+// category.  A valid category is a non-empty string of at most
+// [maxFilterCategoryLen] letters, digits, spaces, hyphens, underscores, and
+// dots, which starts with a letter or a digit.
+func validateFilterCategory(cat string) (err error) {
+	if cat == "" {
+		return fmt.Errorf("category: %w", errors.ErrEmptyValue)
+	}
+
+	if l := utf8.RuneCountInString(cat); l > maxFilterCategoryLen {
+		return fmt.Errorf("category: too long: got %d runes, max %d", l, maxFilterCategoryLen)
+	}
+
+	for i, r := range cat {
+		isAlnum := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if i == 0 && !isAlnum {
+			return fmt.Errorf("category: %q: must start with a letter or a digit", cat)
+		} else if !isAlnum && !strings.ContainsRune(" -_.", r) {
+			return fmt.Errorf("category: %q: bad rune %q at index %d", cat, r, i)
+		}
+	}
+
+	return nil
+}
+
+// category returns the category of the filter list or
+// [DefaultFilterCategory] if there is none.
+func (filter *FilterYAML) category() (cat string) {
+	if filter.Category == "" {
+		return DefaultFilterCategory
+	}
+
+	return filter.Category
+}
+
+// groupFiltersByCategory returns the filter lists from fjs grouped by their
+// categories.  The lists within each category are sorted by name.
+func groupFiltersByCategory(fjs []filterJSON) (groups map[string][]filterJSON) {
+	groups = map[string][]filterJSON{}
+	for _, fj := range fjs {
+		groups[fj.Category] = append(groups[fj.Category], fj)
+	}
+
+	for _, g := range groups {
+		slices.SortStableFunc(g, func(a, b filterJSON) (res int) {
+			return strings.Compare(a.Name, b.Name)
+		})
+	}
+
+	return groups
+}
@@ -0,0 +1,76 @@
+package filtering
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateFilterCategory(t *testing.T) {
+	testCases := []struct {
+		name       string
+		cat        string
+		wantErrMsg string
+	}{{
+		name:       "simple",
+		cat:        "Ads",
+		wantErrMsg: "",
+	}, {
+		name:       "complex",
+		cat:        "Kids 2.0_home-lists",
+		wantErrMsg: "",
+	}, {
+		name:       "unicode",
+		cat:        "Реклама",
+		wantErrMsg: "",
+	}, {
+		name:       "empty",
+		cat:        "",
+		wantErrMsg: "category: empty value",
+	}, {
+		name:       "too_long",
+		cat:        strings.Repeat("a", maxFilterCategoryLen+1),
+		wantErrMsg: "category: too long: got 65 runes, max 64",
+	}, {
+		name:       "bad_start",
+		cat:        "-ads",
+		wantErrMsg: `category: "-ads": must start with a letter or a digit`,
+	}, {
+		name:       "bad_rune",
+		cat:        "ads/trackers",
+		wantErrMsg: `category: "ads/trackers": bad rune '/' at index 3`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, validateFilterCategory(tc.cat))
+		})
+	}
+}
+
+func TestGroupFiltersByCategory(t *testing.T) {
+	fjs := []filterJSON{
+		filterToJSON(FilterYAML{Name: "b-ads", Category: "Ads"}),
+		filterToJSON(FilterYAML{Name: "no-category"}),
+		filterToJSON(FilterYAML{Name: "a-ads", Category: "Ads"}),
+		filterToJSON(FilterYAML{Name: "custom", Category: DefaultFilterCategory}),
+	}
+
+	groups := groupFiltersByCategory(fjs)
+	assert.Len(t, groups, 2)
+
+	names := func(fjs []filterJSON) (res []string) {
+		for _, fj := range fjs {
+			res = append(res, fj.Name)
+		}
+
+		return res
+	}
+
+	assert.Equal(t, []string{"a-ads", "b-ads"}, names(groups["Ads"]))
+	assert.Equal(t, []string{"custom", "no-category"}, names(groups[DefaultFilterCategory]))
+
+	assert.Empty(t, groupFiltersByCategory(nil))
+}
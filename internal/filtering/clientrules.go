@@ -0,0 +1,76 @@
+package filtering
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering/rulelist"
+	"github.com/AdguardTeam/urlfilter"
+	"github.com/AdguardTeam/urlfilter/rules"
+)
+
+// ClientRules is the compiled set of the custom filtering rules of a single
+// persistent client.  Each client has its own engine, so that changing the
+// rules of one client doesn't require rebuilding the engines of the others.
+type ClientRules struct {
+	// engine matches the requests against the rules of the client.
+	engine *urlfilter.DNSEngine
+}
+
+// NewClientRules compiles the custom filtering rules of a client.  Empty lines
+// and comments are allowed.  cr is nil if there are no rules.
+func NewClientRules(ruleTexts []string) (cr *ClientRules, err error) {
+	if len(ruleTexts) == 0 {
+		return nil, nil
+	}
+
+	for i, text := range ruleTexts {
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+
+		_, err = rules.NewRule(text, rulelist.URLFilterIDClientRules)
+		if err != nil {
+			return nil, fmt.Errorf("rule at index %d: %w", i, err)
+		}
+	}
+
+	storage, err := newRuleStorage([]Filter{{
+		ID:   rulelist.URLFilterIDClientRules,
+		Data: []byte(strings.Join(ruleTexts, "\n")),
+	}})
+	if err != nil {
+		return nil, fmt.Errorf("creating rule storage: %w", err)
+	}
+
+	return &ClientRules{
+		engine: urlfilter.NewDNSEngine(storage),
+	}, nil
+}
+
+// matchClientRules matches host against the custom filtering rules of the
+// client from setts.  The rules of the client take precedence over the global
+// filter lists and the user rules, so an allowlist rule of the client unblocks
+// host for this client only.
+func (d *DNSFilter) matchClientRules(
+	host string,
+	rrtype uint16,
+	setts *Settings,
+) (res Result, err error) {
+	cr := setts.ClientRules
+	if cr == nil || !setts.FilteringEnabled {
+		return Result{}, nil
+	}
+
+	dnsres, matched := cr.engine.MatchRequest(newDNSRequest(host, rrtype, setts))
+
+	rwRes := d.processDNSResultRewrites(dnsres.DNSRewrites(), host)
+	if rwRes.Reason != NotFilteredNotFound {
+		return rwRes, nil
+	} else if !matched || !setts.ProtectionEnabled {
+		return Result{}, nil
+	}
+
+	return d.matchHostProcessDNSResult(rrtype, dnsres), nil
+}
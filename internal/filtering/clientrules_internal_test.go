@@ -0,0 +1,95 @@
+package filtering
+
+import (
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering/rulelist"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_CheckHost_clientRules(t *testing.T) {
+	const (
+		blockedHost = "blocked.example"
+		schoolHost  = "school-site.example"
+	)
+
+	d, _ := newForTest(t, &Config{}, []Filter{{
+		ID: 0, Data: []byte("||" + schoolHost + "^\n"),
+	}})
+	t.Cleanup(d.Close)
+
+	kidRules, err := NewClientRules([]string{
+		"! Allow the school site.",
+		"@@||" + schoolHost + "^",
+		"",
+		"||" + blockedHost + "^",
+	})
+	require.NoError(t, err)
+
+	newSetts := func(cr *ClientRules) (setts *Settings) {
+		return &Settings{
+			ClientRules:       cr,
+			ProtectionEnabled: true,
+			FilteringEnabled:  true,
+		}
+	}
+
+	testCases := []struct {
+		setts      *Settings
+		name       string
+		host       string
+		wantReason Reason
+		wantListID rulelist.URLFilterID
+	}{{
+		setts:      newSetts(kidRules),
+		name:       "client_block",
+		host:       blockedHost,
+		wantReason: FilteredBlockList,
+		wantListID: rulelist.URLFilterIDClientRules,
+	}, {
+		setts:      newSetts(nil),
+		name:       "other_client_not_blocked",
+		host:       blockedHost,
+		wantReason: NotFilteredNotFound,
+	}, {
+		setts:      newSetts(kidRules),
+		name:       "client_allow",
+		host:       schoolHost,
+		wantReason: NotFilteredAllowList,
+		wantListID: rulelist.URLFilterIDClientRules,
+	}, {
+		setts:      newSetts(nil),
+		name:       "other_client_blocked",
+		host:       schoolHost,
+		wantReason: FilteredBlockList,
+		wantListID: 0,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, checkErr := d.CheckHost(tc.host, dns.TypeA, tc.setts)
+			require.NoError(t, checkErr)
+
+			assert.Equal(t, tc.wantReason, res.Reason)
+			if tc.wantReason == NotFilteredNotFound {
+				return
+			}
+
+			require.Len(t, res.Rules, 1)
+
+			assert.Equal(t, tc.wantListID, res.Rules[0].FilterListID)
+		})
+	}
+}
+
+func TestNewClientRules(t *testing.T) {
+	cr, err := NewClientRules(nil)
+	require.NoError(t, err)
+
+	assert.Nil(t, cr)
+
+	_, err = NewClientRules([]string{"||example.org^$badmodifier"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,226 @@
+package filtering
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/golibs/container"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+)
+
+// defaultConnectivityCheckDomains are the domain names used by the popular
+// operating systems and browsers to detect captive portals and the Internet
+// connectivity.  Blocking those makes the devices consider the network broken.
+//
+// NOTE:  Keep sorted.
+var defaultConnectivityCheckDomains = []string{
+	"captive.apple.com",
+	"captive.g.aaplimg.com",
+	"clients3.google.com",
+	"connect.rom.miui.com",
+	"connectivity-check.ubuntu.com",
+	"connectivitycheck.android.com",
+	"connectivitycheck.gstatic.com",
+	"connectivitycheck.platform.hicloud.com",
+	"conncheck.opensuse.org",
+	"detectportal.firefox.com",
+	"dns.msftncsi.com",
+	"ipv6.msftconnecttest.com",
+	"network-test.debian.org",
+	"nmcheck.gnome.org",
+	"www.msftconnecttest.com",
+	"www.msftncsi.com",
+}
+
+// DefaultConnectivityCheckDomains returns a copy of the built-in list of the
+// connectivity-check domain names.
+func DefaultConnectivityCheckDomains() (domains []string) {
+	return slices.Clone(defaultConnectivityCheckDomains)
+}
+
+// ConnectivityCheckConfig is the configuration of the exemption of the
+// connectivity-check domain names from blocking.
+type ConnectivityCheckConfig struct {
+	// Domains are the exempted domain names.  Subdomains of those are exempted
+	// as well.
+	Domains []string `yaml:"domains" json:"domains"`
+
+	// Enabled defines if the exemption is enabled.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+}
+
+// Clone returns a deep copy of c.
+func (c *ConnectivityCheckConfig) Clone() (cloned *ConnectivityCheckConfig) {
+	if c == nil {
+		return nil
+	}
+
+	return &ConnectivityCheckConfig{
+		Domains: slices.Clone(c.Domains),
+		Enabled: c.Enabled,
+	}
+}
+
+// newConnectivityCheckSet validates domains and returns the set of their
+// normalized versions.
+func newConnectivityCheckSet(domains []string) (set *container.MapSet[string], err error) {
+	set = container.NewMapSet[string]()
+	for i, d := range domains {
+		d = strings.ToLower(strings.TrimSuffix(d, "."))
+		err = netutil.ValidateDomainName(d)
+		if err != nil {
+			return nil, fmt.Errorf("domain at index %d: %w", i, err)
+		}
+
+		set.Add(d)
+	}
+
+	return set, nil
+}
+
+// isConnectivityCheck returns true if host or any of its parent domains is in
+// the set of the connectivity-check domains.  d.confMu is expected to be
+// locked.
+func (d *DNSFilter) isConnectivityCheck(host string) (ok bool) {
+	if d.connCheckDomains == nil {
+		return false
+	}
+
+	for host != "" {
+		if d.connCheckDomains.Has(host) {
+			return true
+		}
+
+		_, host, _ = strings.Cut(host, ".")
+	}
+
+	return false
+}
+
+// matchConnectivityCheck returns a not-filtered result with the reason
+// NotFilteredConnectivityCheck if host is a connectivity-check domain and the
+// exemption is enabled.  The err is always nil, it is only there to make this
+// a valid hostChecker function.
+func (d *DNSFilter) matchConnectivityCheck(
+	host string,
+	_ uint16,
+	setts *Settings,
+) (res Result, err error) {
+	if !setts.ProtectionEnabled {
+		return Result{}, nil
+	}
+
+	d.confMu.RLock()
+	defer d.confMu.RUnlock()
+
+	c := d.conf.ConnectivityCheck
+	if c == nil || !c.Enabled || !d.isConnectivityCheck(host) {
+		return Result{}, nil
+	}
+
+	log.Debug("filtering: host %q is a connectivity check, not blocking", host)
+
+	return Result{
+		Reason: NotFilteredConnectivityCheck,
+	}, nil
+}
+
+// setConnectivityCheck validates and sets the connectivity-check exemption
+// configuration.  d.confMu is expected to be locked.
+func (d *DNSFilter) setConnectivityCheck(c *ConnectivityCheckConfig) (err error) {
+	if c == nil {
+		d.connCheckDomains = nil
+		d.conf.ConnectivityCheck = nil
+
+		return nil
+	}
+
+	set, err := newConnectivityCheckSet(c.Domains)
+	if err != nil {
+		return fmt.Errorf("connectivity check: %w", err)
+	}
+
+	d.connCheckDomains = set
+	d.conf.ConnectivityCheck = c
+
+	return nil
+}
+
+// connectivityCheckJSON is the JSON structure for the connectivity-check
+// exemption HTTP API.
+type connectivityCheckJSON struct {
+	// DefaultDomains are the built-in connectivity-check domains.  It's only
+	// set in responses.
+	DefaultDomains []string `json:"default_domains,omitempty"`
+
+	// Domains are the currently exempted domains.
+	Domains []string `json:"domains"`
+
+	// Enabled defines if the exemption is enabled.
+	Enabled bool `json:"enabled"`
+}
+
+// handleConnectivityCheckGet is the handler for the GET
+// /control/filtering/connectivity_check HTTP API.
+func (d *DNSFilter) handleConnectivityCheckGet(w http.ResponseWriter, r *http.Request) {
+	resp := &connectivityCheckJSON{
+		DefaultDomains: DefaultConnectivityCheckDomains(),
+		Domains:        []string{},
+	}
+
+	func() {
+		d.confMu.RLock()
+		defer d.confMu.RUnlock()
+
+		if c := d.conf.ConnectivityCheck; c != nil {
+			resp.Enabled = c.Enabled
+			resp.Domains = append(resp.Domains, c.Domains...)
+		}
+	}()
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
+
+// handleConnectivityCheckUpdate is the handler for the PUT
+// /control/filtering/connectivity_check HTTP API.
+func (d *DNSFilter) handleConnectivityCheckUpdate(w http.ResponseWriter, r *http.Request) {
+	req := &connectivityCheckJSON{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "json.Decode: %s", err)
+
+		return
+	}
+
+	c := &ConnectivityCheckConfig{
+		Domains: req.Domains,
+		Enabled: req.Enabled,
+	}
+
+	err = func() (err error) {
+		d.confMu.Lock()
+		defer d.confMu.Unlock()
+
+		return d.setConnectivityCheck(c)
+	}()
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusUnprocessableEntity, "validating: %s", err)
+
+		return
+	}
+
+	log.Debug(
+		"filtering: updated connectivity check: enabled %t, %d domains",
+		c.Enabled,
+		len(c.Domains),
+	)
+
+	d.conf.ConfigModified()
+
+	aghhttp.OK(w)
+}
@@ -0,0 +1,145 @@
+package filtering
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_CheckHost_connectivityCheck(t *testing.T) {
+	const (
+		exemptedHost = "connectivitycheck.gstatic.com"
+		blockedHost  = "blocked.example"
+	)
+
+	filters := []Filter{{
+		ID:   0,
+		Data: []byte("||gstatic.com^\n||" + blockedHost + "^\n"),
+	}}
+
+	testCases := []struct {
+		conf       *ConnectivityCheckConfig
+		name       string
+		host       string
+		wantReason Reason
+	}{{
+		conf: &ConnectivityCheckConfig{
+			Domains: DefaultConnectivityCheckDomains(),
+			Enabled: true,
+		},
+		name:       "exempted",
+		host:       exemptedHost,
+		wantReason: NotFilteredConnectivityCheck,
+	}, {
+		conf: &ConnectivityCheckConfig{
+			Domains: []string{"gstatic.com"},
+			Enabled: true,
+		},
+		name:       "exempted_subdomain",
+		host:       exemptedHost,
+		wantReason: NotFilteredConnectivityCheck,
+	}, {
+		conf: &ConnectivityCheckConfig{
+			Domains: DefaultConnectivityCheckDomains(),
+			Enabled: true,
+		},
+		name:       "not_exempted",
+		host:       blockedHost,
+		wantReason: FilteredBlockList,
+	}, {
+		conf: &ConnectivityCheckConfig{
+			Domains: DefaultConnectivityCheckDomains(),
+			Enabled: false,
+		},
+		name:       "disabled",
+		host:       exemptedHost,
+		wantReason: FilteredBlockList,
+	}, {
+		conf:       nil,
+		name:       "no_config",
+		host:       exemptedHost,
+		wantReason: FilteredBlockList,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d, setts := newForTest(t, &Config{ConnectivityCheck: tc.conf}, filters)
+			t.Cleanup(d.Close)
+
+			res, err := d.CheckHost(tc.host, dns.TypeA, setts)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantReason, res.Reason)
+			assert.Equal(t, tc.wantReason == FilteredBlockList, res.IsFiltered)
+		})
+	}
+}
+
+func TestDNSFilter_handleConnectivityCheckUpdate(t *testing.T) {
+	confModifiedCalled := false
+	d, _ := newForTest(t, &Config{
+		ConfigModified: func() { confModifiedCalled = true },
+	}, nil)
+	t.Cleanup(d.Close)
+
+	testCases := []struct {
+		name       string
+		body       string
+		wantCode   int
+		wantConfig *ConnectivityCheckConfig
+	}{{
+		name:     "success",
+		body:     `{"enabled":true,"domains":["captive.example"]}`,
+		wantCode: http.StatusOK,
+		wantConfig: &ConnectivityCheckConfig{
+			Domains: []string{"captive.example"},
+			Enabled: true,
+		},
+	}, {
+		name:     "bad_domain",
+		body:     `{"enabled":true,"domains":["bad domain"]}`,
+		wantCode: http.StatusUnprocessableEntity,
+		wantConfig: &ConnectivityCheckConfig{
+			Domains: []string{"captive.example"},
+			Enabled: true,
+		},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			confModifiedCalled = false
+
+			r := httptest.NewRequest(
+				http.MethodPut,
+				"/control/filtering/connectivity_check",
+				bytes.NewBufferString(tc.body),
+			)
+			w := httptest.NewRecorder()
+
+			d.handleConnectivityCheckUpdate(w, r)
+			require.Equal(t, tc.wantCode, w.Code)
+
+			assert.Equal(t, tc.wantCode == http.StatusOK, confModifiedCalled)
+
+			r = httptest.NewRequest(http.MethodGet, "/control/filtering/connectivity_check", nil)
+			w = httptest.NewRecorder()
+
+			d.handleConnectivityCheckGet(w, r)
+			require.Equal(t, http.StatusOK, w.Code)
+
+			resp := &connectivityCheckJSON{}
+			err := json.NewDecoder(w.Body).Decode(resp)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantConfig.Enabled, resp.Enabled)
+			assert.Equal(t, tc.wantConfig.Domains, resp.Domains)
+			assert.Equal(t, defaultConnectivityCheckDomains, resp.DefaultDomains)
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package filtering
+
+import (
+	"sync/atomic"
+
+	"github.com/AdguardTeam/urlfilter"
+)
+
+// OverrideMechanism is the mechanism that has prevented a matching blocking
+// rule from blocking a request.
+type OverrideMechanism string
+
+// Override mechanisms.
+const (
+	// OverrideAllowlistRule means that an allowlist rule has matched the
+	// request.
+	OverrideAllowlistRule OverrideMechanism = "allowlist_rule"
+
+	// OverrideClientSettings means that the filtering is disabled in the
+	// settings of the client.
+	OverrideClientSettings OverrideMechanism = "client_settings"
+
+	// OverrideFilteringDisabled means that the filtering is disabled
+	// globally.
+	OverrideFilteringDisabled OverrideMechanism = "filtering_disabled"
+
+	// OverrideProtectionDisabled means that the protection is disabled or
+	// paused.
+	OverrideProtectionDisabled OverrideMechanism = "protection_disabled"
+
+	// OverrideServiceSchedule means that the blocked service isn't blocked
+	// currently due to the schedule.
+	OverrideServiceSchedule OverrideMechanism = "service_schedule"
+)
+
+// DecisionDetail describes a blocking rule that has matched a request but
+// hasn't been applied.
+type DecisionDetail struct {
+	// CandidateRule is the rule that would have blocked the request.  It is
+	// never nil.
+	CandidateRule *ResultRule `json:",omitempty"`
+
+	// OverridingRule is the allowlist rule that has overridden CandidateRule.
+	// It is nil unless Mechanism is [OverrideAllowlistRule].
+	OverridingRule *ResultRule `json:",omitempty"`
+
+	// ServiceName is the name of the blocked service CandidateRule belongs to,
+	// if any.
+	ServiceName string `json:",omitempty"`
+
+	// Mechanism is the mechanism that has overridden CandidateRule.
+	Mechanism OverrideMechanism `json:",omitempty"`
+}
+
+// withDecisionDetail returns res with the decision detail set, if it's enabled
+// and res isn't a blocking one.
+//
+// Note that an allowlist rule hides the blocking rules from the same engine, so
+// only the blocking rules overridden by the allowlist rules from the other
+// engines, such as the user rules or the allowlists, are detected.
+func (d *DNSFilter) withDecisionDetail(
+	host string,
+	qtype uint16,
+	setts *Settings,
+	res Result,
+) (withDetail Result) {
+	if !d.decisionDetailsEnabled() || !res.Reason.In(NotFilteredNotFound, NotFilteredAllowList) {
+		return res
+	}
+
+	res.DecisionDetail = d.decisionDetail(host, qtype, setts, &res)
+
+	return res
+}
+
+// decisionDetailsEnabled returns true if the decision details should be added
+// to the results.
+func (d *DNSFilter) decisionDetailsEnabled() (ok bool) {
+	d.confMu.RLock()
+	defer d.confMu.RUnlock()
+
+	return d.conf.DecisionDetails
+}
+
+// decisionDetail returns the detail about the blocking rule matching host,
+// which hasn't been applied, or nil if there is no such rule.  res must not be
+// nil.
+func (d *DNSFilter) decisionDetail(
+	host string,
+	qtype uint16,
+	setts *Settings,
+	res *Result,
+) (dd *DecisionDetail) {
+	candidate, isRule := d.blockingRuleCandidate(host, qtype, setts), true
+	svcName := ""
+	if candidate == nil {
+		isRule = false
+		svcName, candidate = serviceCandidate(host, setts.ServicesRules)
+	}
+
+	if candidate == nil {
+		svcName, candidate = serviceCandidate(host, setts.PausedServicesRules)
+		if candidate == nil {
+			return nil
+		}
+
+		return &DecisionDetail{
+			CandidateRule: candidate,
+			ServiceName:   svcName,
+			Mechanism:     OverrideServiceSchedule,
+		}
+	}
+
+	dd = &DecisionDetail{
+		CandidateRule: candidate,
+		ServiceName:   svcName,
+	}
+
+	switch {
+	case res.Reason == NotFilteredAllowList:
+		dd.Mechanism = OverrideAllowlistRule
+		if len(res.Rules) > 0 {
+			dd.OverridingRule = res.Rules[0]
+		}
+	case !setts.ProtectionEnabled:
+		dd.Mechanism = OverrideProtectionDisabled
+	case isRule && !setts.FilteringEnabled:
+		dd.Mechanism = d.filteringDisabledMechanism()
+	default:
+		return nil
+	}
+
+	return dd
+}
+
+// filteringDisabledMechanism returns the mechanism that has disabled the
+// filtering for the request.
+func (d *DNSFilter) filteringDisabledMechanism() (m OverrideMechanism) {
+	d.confMu.RLock()
+	defer d.confMu.RUnlock()
+
+	if atomic.LoadUint32(&d.conf.enabled) == 0 {
+		return OverrideFilteringDisabled
+	}
+
+	return OverrideClientSettings
+}
+
+// blockingRuleCandidate returns the blocking rule from the user rules or the
+// filter lists that matches host regardless of the allowlist rules from the
+// other engines and of the settings.  rule is nil if there is no such rule.
+func (d *DNSFilter) blockingRuleCandidate(
+	host string,
+	qtype uint16,
+	setts *Settings,
+) (rule *ResultRule) {
+	ufReq := newDNSRequest(host, qtype, setts)
+
+	d.engineLock.RLock()
+	defer d.engineLock.RUnlock()
+
+	for _, engine := range []*urlfilter.DNSEngine{d.userEngine, d.filteringEngine} {
+		if engine == nil {
+			continue
+		}
+
+		dnsres, ok := engine.MatchRequest(ufReq)
+		if !ok {
+			continue
+		}
+
+		res := d.matchHostProcessDNSResult(qtype, dnsres)
+		if res.Reason == FilteredBlockList && len(res.Rules) > 0 {
+			return res.Rules[0]
+		}
+	}
+
+	return nil
+}
+
+// serviceCandidate returns the rule of the first service from svcs matching
+// host and the name of the service.  rule is nil if there is no such service.
+func serviceCandidate(host string, svcs []ServiceEntry) (svcName string, rule *ResultRule) {
+	svcName, r := matchServices(host, svcs)
+	if r == nil {
+		return "", nil
+	}
+
+	return svcName, &ResultRule{
+		FilterListID: r.GetFilterListID(),
+		Text:         r.Text(),
+	}
+}
@@ -0,0 +1,154 @@
+package filtering
+
+import (
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering/rulelist"
+	"github.com/AdguardTeam/urlfilter/rules"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_CheckHost_decisionDetail(t *testing.T) {
+	const (
+		listRule    = "||list-block.example^"
+		userAllow   = "@@||list-block.example^"
+		allowedRule = "||allowlisted.example^"
+		serviceRule = "||service.example^"
+	)
+
+	d, _ := newForTest(t, &Config{DecisionDetails: true}, nil)
+	t.Cleanup(d.Close)
+
+	d.SetEnabled(true)
+
+	err := d.setFilters(&filtersInitializerParams{
+		allowFilters: []Filter{{ID: 2, Data: []byte(allowedRule + "\n")}},
+		blockFilters: []Filter{{
+			ID:   1,
+			Data: []byte(listRule + "\n||allowlisted.example^\n||plain-block.example^\n"),
+		}},
+		userFilter: &Filter{
+			ID:   rulelist.URLFilterIDCustom,
+			Data: []byte(userAllow + "\n"),
+		},
+	}, false)
+	require.NoError(t, err)
+
+	svcRule, err := rules.NewNetworkRule(serviceRule, 0)
+	require.NoError(t, err)
+
+	svcs := []ServiceEntry{{
+		Name:  "service",
+		Rules: []*rules.NetworkRule{svcRule},
+	}}
+
+	testCases := []struct {
+		setts *Settings
+		want  *DecisionDetail
+		name  string
+		host  string
+	}{{
+		setts: &Settings{ProtectionEnabled: true, FilteringEnabled: true},
+		want: &DecisionDetail{
+			CandidateRule:  &ResultRule{Text: listRule, FilterListID: 1},
+			OverridingRule: &ResultRule{Text: userAllow, FilterListID: rulelist.URLFilterIDCustom},
+			Mechanism:      OverrideAllowlistRule,
+		},
+		name: "user_allowlist_rule",
+		host: "list-block.example",
+	}, {
+		setts: &Settings{ProtectionEnabled: true, FilteringEnabled: true},
+		want: &DecisionDetail{
+			CandidateRule:  &ResultRule{Text: allowedRule, FilterListID: 1},
+			OverridingRule: &ResultRule{Text: allowedRule, FilterListID: 2},
+			Mechanism:      OverrideAllowlistRule,
+		},
+		name: "allowlist",
+		host: "allowlisted.example",
+	}, {
+		setts: &Settings{ProtectionEnabled: false, FilteringEnabled: true},
+		want: &DecisionDetail{
+			CandidateRule: &ResultRule{Text: "||plain-block.example^", FilterListID: 1},
+			Mechanism:     OverrideProtectionDisabled,
+		},
+		name: "protection_disabled",
+		host: "plain-block.example",
+	}, {
+		setts: &Settings{ProtectionEnabled: true, FilteringEnabled: false},
+		want: &DecisionDetail{
+			CandidateRule: &ResultRule{Text: "||plain-block.example^", FilterListID: 1},
+			Mechanism:     OverrideClientSettings,
+		},
+		name: "client_settings",
+		host: "plain-block.example",
+	}, {
+		setts: &Settings{
+			ProtectionEnabled:   true,
+			FilteringEnabled:    true,
+			PausedServicesRules: svcs,
+		},
+		want: &DecisionDetail{
+			CandidateRule: &ResultRule{Text: serviceRule},
+			ServiceName:   "service",
+			Mechanism:     OverrideServiceSchedule,
+		},
+		name: "service_schedule",
+		host: "service.example",
+	}, {
+		setts: &Settings{ProtectionEnabled: false, ServicesRules: svcs},
+		want: &DecisionDetail{
+			CandidateRule: &ResultRule{Text: serviceRule},
+			ServiceName:   "service",
+			Mechanism:     OverrideProtectionDisabled,
+		},
+		name: "service_protection_disabled",
+		host: "service.example",
+	}, {
+		setts: &Settings{ProtectionEnabled: true, FilteringEnabled: true},
+		want:  nil,
+		name:  "blocked",
+		host:  "plain-block.example",
+	}, {
+		setts: &Settings{ProtectionEnabled: false, FilteringEnabled: true},
+		want:  nil,
+		name:  "no_candidate",
+		host:  "unknown.example",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, checkErr := d.CheckHost(tc.host, dns.TypeA, tc.setts)
+			require.NoError(t, checkErr)
+
+			assert.Equal(t, tc.want, res.DecisionDetail)
+		})
+	}
+
+	t.Run("filtering_disabled", func(t *testing.T) {
+		d.SetEnabled(false)
+		t.Cleanup(func() { d.SetEnabled(true) })
+
+		setts := &Settings{ProtectionEnabled: true, FilteringEnabled: false}
+		res, checkErr := d.CheckHost("plain-block.example", dns.TypeA, setts)
+		require.NoError(t, checkErr)
+		require.NotNil(t, res.DecisionDetail)
+
+		assert.Equal(t, OverrideFilteringDisabled, res.DecisionDetail.Mechanism)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		disabled, _ := newForTest(t, &Config{}, []Filter{{
+			ID:   1,
+			Data: []byte(listRule + "\n"),
+		}})
+		t.Cleanup(disabled.Close)
+
+		setts := &Settings{ProtectionEnabled: false, FilteringEnabled: true}
+		res, checkErr := disabled.CheckHost("list-block.example", dns.TypeA, setts)
+		require.NoError(t, checkErr)
+
+		assert.Nil(t, res.DecisionDetail)
+	})
+}
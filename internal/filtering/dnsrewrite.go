@@ -1,7 +1,6 @@
 package filtering
 
 import (
-	"github.com/AdguardTeam/urlfilter"
 	"github.com/AdguardTeam/urlfilter/rules"
 	"github.com/miekg/dns"
 )
@@ -76,12 +75,11 @@ func (d *DNSFilter) processDNSRewrites(dnsr []*rules.NetworkRule) (res Result) {
 }
 
 // processDNSResultRewrites returns an empty Result if there are no dnsrewrite
-// rules in dnsres.  Otherwise, it returns the processed Result.
+// rules in dnsr.  Otherwise, it returns the processed Result.
 func (d *DNSFilter) processDNSResultRewrites(
-	dnsres *urlfilter.DNSResult,
+	dnsr []*rules.NetworkRule,
 	host string,
 ) (dnsRWRes Result) {
-	dnsr := dnsres.DNSRewrites()
 	if len(dnsr) == 0 {
 		return Result{}
 	}
@@ -1,6 +1,12 @@
 package filtering
 
 import (
+	"bufio"
+	"cmp"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
 	"fmt"
 	"io"
 	"net/http"
@@ -16,13 +22,21 @@ import (
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering/rulelist"
 	"github.com/AdguardTeam/golibs/container"
 	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/ioutil"
 	"github.com/AdguardTeam/golibs/log"
+	"github.com/c2h5oh/datasize"
+	"golang.org/x/sync/errgroup"
 )
 
 // filterDir is the subdirectory of a data directory to store downloaded
 // filters.
 const filterDir = "filters"
 
+// maxDecodedListSize is the maximum size of the decoded data of a compressed
+// filtering-rule list.  It protects from decompression bombs.
+const maxDecodedListSize = 256 * datasize.MB
+
 // FilterYAML represents a filter list in the configuration file.
 //
 // TODO(e.burkov):  Investigate if the field ordering is important.
@@ -35,6 +49,35 @@ type FilterYAML struct {
 	checksum    uint32    // checksum of the file data
 	white       bool
 
+	// BlockedResponseTTL is the upper bound of the TTL for responses blocked by
+	// the rules of this list, in seconds.  If 0, only the global
+	// [Config.BlockedResponseTTL] is used.
+	BlockedResponseTTL uint32 `yaml:"blocked_response_ttl,omitempty"`
+
+	// UpdateIntervalHours is the interval between the updates of this list, in
+	// hours.  If 0, the global [Config.FiltersUpdateIntervalHours] is used.
+	UpdateIntervalHours uint32 `yaml:"update_interval_hours,omitempty"`
+
+	// Category is the user-defined category of this list, which is used to
+	// group the lists in the UI.  If empty, [DefaultFilterCategory] is used.
+	Category string `yaml:"category"`
+
+	// validators are the HTTP cache validators received with the current
+	// contents of the list.  They are used to make conditional requests.
+	validators listValidators
+
+	// SkippedRules are the first lines of the list that couldn't be parsed as
+	// rules during the last load or update.
+	SkippedRules []*rulelist.SkippedRule `yaml:"-"`
+
+	// SkippedRulesCount is the total number of lines of the list that couldn't
+	// be parsed as rules during the last load or update.
+	SkippedRulesCount int `yaml:"-"`
+
+	// hasAffectingRules is true if the list contains rules that affect the
+	// rules of other lists, see [rulelist.AffectsOtherRules].
+	hasAffectingRules bool
+
 	Filter `yaml:",inline"`
 }
 
@@ -42,6 +85,46 @@ type FilterYAML struct {
 func (filter *FilterYAML) unload() {
 	filter.RulesCount = 0
 	filter.checksum = 0
+	filter.validators = listValidators{}
+	filter.SkippedRules = nil
+	filter.SkippedRulesCount = 0
+	filter.hasAffectingRules = false
+}
+
+// updateInterval returns the interval between the updates of the list.
+// globalHours is the global update interval in hours.  ivl is zero if the list
+// shouldn't be updated periodically.
+func (filter *FilterYAML) updateInterval(globalHours uint32) (ivl time.Duration) {
+	return time.Duration(cmp.Or(filter.UpdateIntervalHours, globalHours)) * time.Hour
+}
+
+// listValidators are the HTTP cache validators of a filter list downloaded from
+// a URL.  See RFC 9110, section 8.8.
+type listValidators struct {
+	// eTag is the value of the ETag header.
+	eTag string
+
+	// lastModified is the value of the Last-Modified header.
+	lastModified string
+}
+
+// errNotModified is returned by [DNSFilter.reader] when the server reports
+// that the list hasn't been modified since the last download.
+const errNotModified errors.Error = "not modified"
+
+// setSkippedRules sets the information about the skipped lines from res and
+// logs it.
+func (filter *FilterYAML) setSkippedRules(res *rulelist.ParseResult) {
+	filter.SkippedRules, filter.SkippedRulesCount = res.SkippedRules, res.SkippedCount
+	if res.SkippedCount > 0 {
+		log.Info("filtering: filter %d: skipped %d invalid rules", filter.ID, res.SkippedCount)
+	}
+}
+
+// Checksum returns the checksum of the current contents of the filter list.
+// It's zero if the list hasn't been loaded yet.
+func (filter *FilterYAML) Checksum() (sum uint32) {
+	return filter.checksum
 }
 
 // Path to the filter contents
@@ -86,6 +169,7 @@ const (
 // the values of newList to it, updating afterwards if needed.  It returns true
 // if the update was performed and the filtering engine restart is required.
 func (d *DNSFilter) filterSetProperties(
+	ctx context.Context,
 	listURL string,
 	newList FilterYAML,
 	isAllowlist bool,
@@ -105,24 +189,37 @@ func (d *DNSFilter) filterSetProperties(
 
 	flt := &filters[i]
 	log.Debug(
-		"filtering: set name to %q, url to %s, enabled to %t for filter %s",
+		"filtering: set name to %q, url to %s, enabled to %t, blocked ttl to %d for filter %s",
 		newList.Name,
 		newList.URL,
 		newList.Enabled,
+		newList.BlockedResponseTTL,
 		flt.URL,
 	)
 
-	defer func(oldURL, oldName string, oldEnabled bool, oldUpdated time.Time, oldRulesCount int) {
+	defer func(old FilterYAML) {
 		if err != nil {
-			flt.URL = oldURL
-			flt.Name = oldName
-			flt.Enabled = oldEnabled
-			flt.LastUpdated = oldUpdated
-			flt.RulesCount = oldRulesCount
+			flt.URL = old.URL
+			flt.Name = old.Name
+			flt.Enabled = old.Enabled
+			flt.LastUpdated = old.LastUpdated
+			flt.RulesCount = old.RulesCount
+			flt.SkippedRules = old.SkippedRules
+			flt.SkippedRulesCount = old.SkippedRulesCount
+			flt.hasAffectingRules = old.hasAffectingRules
+			flt.BlockedResponseTTL = old.BlockedResponseTTL
+			flt.UpdateIntervalHours = old.UpdateIntervalHours
+			flt.Category = old.Category
+			flt.validators = old.validators
 		}
-	}(flt.URL, flt.Name, flt.Enabled, flt.LastUpdated, flt.RulesCount)
+	}(*flt)
 
 	flt.Name = newList.Name
+	flt.BlockedResponseTTL = newList.BlockedResponseTTL
+	flt.UpdateIntervalHours = newList.UpdateIntervalHours
+	if newList.Category != "" {
+		flt.Category = newList.Category
+	}
 
 	if flt.URL != newList.URL {
 		if d.filterExistsLocked(newList.URL) {
@@ -144,7 +241,7 @@ func (d *DNSFilter) filterSetProperties(
 	if flt.Enabled {
 		if shouldRestart {
 			// Download the filter contents.
-			shouldRestart, err = d.update(flt)
+			shouldRestart, err = d.update(ctx, flt)
 		}
 	} else {
 		// TODO(e.burkov):  The validation of the contents of the new URL is
@@ -158,6 +255,44 @@ func (d *DNSFilter) filterSetProperties(
 	return shouldRestart, err
 }
 
+// filterBlockedResponseTTL returns the blocked-response TTL of the filter list
+// with listURL, or 0 if there is no such list.  It's safe for concurrent use.
+func (d *DNSFilter) filterBlockedResponseTTL(listURL string, isAllowlist bool) (ttl uint32) {
+	d.conf.filtersMu.RLock()
+	defer d.conf.filtersMu.RUnlock()
+
+	filters := d.conf.Filters
+	if isAllowlist {
+		filters = d.conf.WhitelistFilters
+	}
+
+	i := slices.IndexFunc(filters, func(flt FilterYAML) bool { return flt.URL == listURL })
+	if i == -1 {
+		return 0
+	}
+
+	return filters[i].BlockedResponseTTL
+}
+
+// filterUpdateIntervalHours returns the update interval of the filter list with
+// listURL, or 0 if there is no such list.  It's safe for concurrent use.
+func (d *DNSFilter) filterUpdateIntervalHours(listURL string, isAllowlist bool) (hours uint32) {
+	d.conf.filtersMu.RLock()
+	defer d.conf.filtersMu.RUnlock()
+
+	filters := d.conf.Filters
+	if isAllowlist {
+		filters = d.conf.WhitelistFilters
+	}
+
+	i := slices.IndexFunc(filters, func(flt FilterYAML) bool { return flt.URL == listURL })
+	if i == -1 {
+		return 0
+	}
+
+	return filters[i].UpdateIntervalHours
+}
+
 // filterExists returns true if a filter with the same url exists in d.  It's
 // safe for concurrent use.
 func (d *DNSFilter) filterExists(url string) (ok bool) {
@@ -249,18 +384,71 @@ func deduplicateFilters(filters []FilterYAML) (deduplicated []FilterYAML) {
 	return filters[:lastIdx]
 }
 
+// RulesCount is the number of rules in a filter list.
+type RulesCount struct {
+	// Name is the name of the filter list.
+	Name string
+
+	// ID is the identifier of the filter list.
+	ID rulelist.URLFilterID
+
+	// Count is the number of rules in the filter list.
+	Count int
+
+	// Allow is true if the filter list is an allowlist.
+	Allow bool
+}
+
+// RulesCounts returns the numbers of rules in the enabled filter lists.
+func (d *DNSFilter) RulesCounts() (counts []*RulesCount) {
+	d.conf.filtersMu.RLock()
+	defer d.conf.filtersMu.RUnlock()
+
+	for _, f := range d.conf.Filters {
+		if f.Enabled {
+			counts = append(counts, &RulesCount{
+				Name:  f.Name,
+				ID:    f.ID,
+				Count: f.RulesCount,
+			})
+		}
+	}
+
+	for _, f := range d.conf.WhitelistFilters {
+		if f.Enabled {
+			counts = append(counts, &RulesCount{
+				Name:  f.Name,
+				ID:    f.ID,
+				Count: f.RulesCount,
+				Allow: true,
+			})
+		}
+	}
+
+	return counts
+}
+
 // tryRefreshFilters is like [refreshFilters], but backs down if the update is
-// already going on.
+// already going on.  It sends the webhook notification about the updated
+// lists, if any, within ctx, which should be the context of the service, since
+// the notification is sent in a separate goroutine.  No new lists are
+// downloaded after ctx is canceled.
 //
 // TODO(e.burkov):  Get rid of the concurrency pattern which requires the
 // [sync.Mutex.TryLock].
-func (d *DNSFilter) tryRefreshFilters(block, allow, force bool) (updated int, isNetworkErr, ok bool) {
+func (d *DNSFilter) tryRefreshFilters(
+	ctx context.Context,
+	block bool,
+	allow bool,
+	force bool,
+) (updated []*filterUpdate, isNetworkErr, ok bool) {
 	if ok = d.refreshLock.TryLock(); !ok {
-		return 0, false, false
+		return nil, false, false
 	}
 	defer d.refreshLock.Unlock()
 
-	updated, isNetworkErr = d.refreshFiltersIntl(block, allow, force)
+	updated, isNetworkErr = d.refreshFiltersIntl(ctx, block, allow, force)
+	d.notifyUpdated(ctx, updated)
 
 	return updated, isNetworkErr, ok
 }
@@ -280,8 +468,8 @@ func (d *DNSFilter) listsToUpdate(filters *[]FilterYAML, force bool) (toUpd []Fi
 		}
 
 		if !force {
-			exp := flt.LastUpdated.Add(time.Duration(d.conf.FiltersUpdateIntervalHours) * time.Hour)
-			if now.Before(exp) {
+			ivl := flt.updateInterval(d.conf.FiltersUpdateIntervalHours)
+			if ivl == 0 || now.Before(flt.LastUpdated.Add(ivl)) {
 				continue
 			}
 		}
@@ -290,41 +478,62 @@ func (d *DNSFilter) listsToUpdate(filters *[]FilterYAML, force bool) (toUpd []Fi
 			Filter: Filter{
 				ID: flt.ID,
 			},
-			URL:      flt.URL,
-			Name:     flt.Name,
-			checksum: flt.checksum,
+			URL:        flt.URL,
+			Name:       flt.Name,
+			checksum:   flt.checksum,
+			validators: flt.validators,
 		})
 	}
 
 	return toUpd
 }
 
-func (d *DNSFilter) refreshFiltersArray(filters *[]FilterYAML, force bool) (int, []FilterYAML, []bool, bool) {
-	var updateFlags []bool // 'true' if filter data has changed
+// nextRefreshIvl returns the duration from now until the earliest next update
+// of the enabled filter lists.  ivl is maxIvl if there are no such lists or
+// none of them should be updated before maxIvl passes.
+func (d *DNSFilter) nextRefreshIvl(now time.Time, maxIvl time.Duration) (ivl time.Duration) {
+	d.conf.filtersMu.RLock()
+	defer d.conf.filtersMu.RUnlock()
+
+	ivl = maxIvl
+	for _, filters := range [][]FilterYAML{d.conf.Filters, d.conf.WhitelistFilters} {
+		for _, flt := range filters {
+			updIvl := flt.updateInterval(d.conf.FiltersUpdateIntervalHours)
+			if !flt.Enabled || updIvl == 0 {
+				continue
+			}
+
+			ivl = min(ivl, flt.LastUpdated.Add(updIvl).Sub(now))
+		}
+	}
+
+	return ivl
+}
 
+func (d *DNSFilter) refreshFiltersArray(
+	ctx context.Context,
+	filters *[]FilterYAML,
+	force bool,
+) ([]*filterUpdate, []FilterYAML, []bool, bool) {
 	updateFilters := d.listsToUpdate(filters, force)
 	if len(updateFilters) == 0 {
-		return 0, nil, nil, false
+		return nil, nil, nil, false
 	}
 
-	failNum := 0
-	for i := range updateFilters {
-		uf := &updateFilters[i]
-		updated, err := d.update(uf)
-		updateFlags = append(updateFlags, updated)
-		if err != nil {
-			failNum++
-			log.Error("filtering: updating filter from url %q: %s\n", uf.URL, err)
+	updateFlags, failNum, err := d.updateLists(ctx, updateFilters)
+	if err != nil {
+		log.Error("filtering: updating filters: %s", err)
 
-			continue
+		if d.conf.OnUpdateFailure != nil {
+			d.conf.OnUpdateFailure(err)
 		}
 	}
 
 	if failNum == len(updateFilters) {
-		return 0, nil, nil, true
+		return nil, nil, nil, true
 	}
 
-	updateCount := 0
+	var upds []*filterUpdate
 
 	d.conf.filtersMu.Lock()
 	defer d.conf.filtersMu.Unlock()
@@ -340,6 +549,7 @@ func (d *DNSFilter) refreshFiltersArray(filters *[]FilterYAML, force bool) (int,
 			}
 
 			f.LastUpdated = uf.LastUpdated
+			f.validators = uf.validators
 			if !updated {
 				continue
 			}
@@ -351,14 +561,98 @@ func (d *DNSFilter) refreshFiltersArray(filters *[]FilterYAML, force bool) (int,
 				f.RulesCount,
 			)
 
+			upds = append(upds, &filterUpdate{
+				ID:            f.ID,
+				OldRulesCount: f.RulesCount,
+				NewRulesCount: uf.RulesCount,
+			})
+
 			f.Name = uf.Name
 			f.RulesCount = uf.RulesCount
+			f.SkippedRules = uf.SkippedRules
+			f.SkippedRulesCount = uf.SkippedRulesCount
+			f.hasAffectingRules = uf.hasAffectingRules
 			f.checksum = uf.checksum
-			updateCount++
 		}
 	}
 
-	return updateCount, updateFilters, updateFlags, false
+	return upds, updateFilters, updateFlags, false
+}
+
+// DefaultFilterUpdateConcurrency is the default maximum number of the filter
+// lists downloaded at the same time during an update.
+const DefaultFilterUpdateConcurrency = 4
+
+// updateLists downloads and saves lists using at most
+// [Config.FilterUpdateConcurrency] workers.  flags[i] is true if the data of
+// lists[i] has changed.  failNum is the number of lists, which couldn't be
+// updated, and err contains all their errors.  If ctx is canceled, the lists,
+// which haven't started downloading yet, are considered failed.
+func (d *DNSFilter) updateLists(
+	ctx context.Context,
+	lists []FilterYAML,
+) (flags []bool, failNum int, err error) {
+	flags = make([]bool, len(lists))
+	errs := make([]error, len(lists))
+
+	n := d.conf.FilterUpdateConcurrency
+	if n <= 0 {
+		n = DefaultFilterUpdateConcurrency
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+	sem := make(chan struct{}, n)
+
+	for i := range lists {
+		if !acquireWorker(ctx, sem) {
+			cancelErr := context.Cause(ctx)
+			for j := i; j < len(lists); j++ {
+				errs[j] = fmt.Errorf("filter from url %q: %w", lists[j].URL, cancelErr)
+			}
+
+			break
+		}
+
+		g.Go(func() (_ error) {
+			defer func() { <-sem }()
+			defer log.OnPanic("filtering: updating filter")
+
+			uf := &lists[i]
+			flags[i], errs[i] = d.update(ctx, uf)
+			if errs[i] != nil {
+				errs[i] = fmt.Errorf("filter from url %q: %w", uf.URL, errs[i])
+			}
+
+			// Don't return the error to update the rest of the lists.
+			return nil
+		})
+	}
+
+	// The workers never return errors, see above.
+	_ = g.Wait()
+
+	for _, e := range errs {
+		if e != nil {
+			failNum++
+		}
+	}
+
+	return flags, failNum, errors.Join(errs...)
+}
+
+// acquireWorker sends to sem, blocking until there is room in it.  ok is false
+// if ctx is canceled before that.
+func acquireWorker(ctx context.Context, sem chan<- struct{}) (ok bool) {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	select {
+	case sem <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
 }
 
 // refreshFiltersIntl checks filters and updates them if necessary.  If force is
@@ -375,35 +669,44 @@ func (d *DNSFilter) refreshFiltersArray(filters *[]FilterYAML, force bool) (int,
 //     that this method works only on Unix systems.  On Windows, don't pass
 //     files to filtering, pass the whole data.
 //
-// refreshFiltersIntl returns the number of updated filters.  It also returns
-// true if there was a network error and nothing could be updated.
+// refreshFiltersIntl returns the information about the updated filters.  It
+// also returns true if there was a network error and nothing could be updated.
 //
 // TODO(a.garipov, e.burkov): What the hell?
-func (d *DNSFilter) refreshFiltersIntl(block, allow, force bool) (int, bool) {
-	updNum := 0
+func (d *DNSFilter) refreshFiltersIntl(
+	ctx context.Context,
+	block bool,
+	allow bool,
+	force bool,
+) ([]*filterUpdate, bool) {
+	var upds []*filterUpdate
 	log.Debug("filtering: starting updating")
-	defer func() { log.Debug("filtering: finished updating, %d updated", updNum) }()
+	defer func() { log.Debug("filtering: finished updating, %d updated", len(upds)) }()
 
 	var lists []FilterYAML
 	var toUpd []bool
 	isNetErr := false
 
 	if block {
-		updNum, lists, toUpd, isNetErr = d.refreshFiltersArray(&d.conf.Filters, force)
+		upds, lists, toUpd, isNetErr = d.refreshFiltersArray(ctx, &d.conf.Filters, force)
 	}
 	if allow {
-		updNumAl, listsAl, toUpdAl, isNetErrAl := d.refreshFiltersArray(&d.conf.WhitelistFilters, force)
+		updsAl, listsAl, toUpdAl, isNetErrAl := d.refreshFiltersArray(
+			ctx,
+			&d.conf.WhitelistFilters,
+			force,
+		)
 
-		updNum += updNumAl
+		upds = append(upds, updsAl...)
 		lists = append(lists, listsAl...)
 		toUpd = append(toUpd, toUpdAl...)
 		isNetErr = isNetErr || isNetErrAl
 	}
 	if isNetErr {
-		return 0, true
+		return nil, true
 	}
 
-	if updNum != 0 {
+	if len(upds) != 0 {
 		d.EnableFilters(false)
 
 		for i := range lists {
@@ -421,12 +724,12 @@ func (d *DNSFilter) refreshFiltersIntl(block, allow, force bool) (int, bool) {
 		}
 	}
 
-	return updNum, false
+	return upds, false
 }
 
 // update refreshes filter's content and a/mtimes of it's file.
-func (d *DNSFilter) update(filter *FilterYAML) (b bool, err error) {
-	b, err = d.updateIntl(filter)
+func (d *DNSFilter) update(ctx context.Context, filter *FilterYAML) (b bool, err error) {
+	b, err = d.updateIntl(ctx, filter)
 	filter.LastUpdated = time.Now()
 	if !b {
 		chErr := os.Chtimes(
@@ -444,7 +747,7 @@ func (d *DNSFilter) update(filter *FilterYAML) (b bool, err error) {
 
 // updateIntl updates the flt rewriting it's actual file.  It returns true if
 // the actual update has been performed.
-func (d *DNSFilter) updateIntl(flt *FilterYAML) (ok bool, err error) {
+func (d *DNSFilter) updateIntl(ctx context.Context, flt *FilterYAML) (ok bool, err error) {
 	log.Debug("filtering: downloading update for filter %d from %q", flt.ID, flt.URL)
 
 	var res *rulelist.ParseResult
@@ -455,8 +758,19 @@ func (d *DNSFilter) updateIntl(flt *FilterYAML) (ok bool, err error) {
 	}
 	defer func() { err = d.finalizeUpdate(tmpFile, flt, res, err, ok) }()
 
-	r, err := d.reader(flt.URL)
-	if err != nil {
+	// Only make conditional requests if the list has already been loaded,
+	// since otherwise there is nothing to keep.
+	var validators listValidators
+	if flt.checksum != 0 {
+		validators = flt.validators
+	}
+
+	r, validators, err := d.reader(ctx, flt.URL, validators)
+	if errors.Is(err, errNotModified) {
+		log.Debug("filtering: filter %d from url %q is not modified", flt.ID, flt.URL)
+
+		return false, nil
+	} else if err != nil {
 		// Don't wrap the error since it's informative enough as is.
 		return false, err
 	}
@@ -467,8 +781,21 @@ func (d *DNSFilter) updateIntl(flt *FilterYAML) (ok bool, err error) {
 
 	p := rulelist.NewParser()
 	res, err = p.Parse(tmpFile, r, *bufPtr)
+	if err != nil {
+		return false, err
+	}
+
+	// Store the skipped rules along with the rules, since the line numbers
+	// refer to the original list.
+	_, err = p.WriteSkippedRules(tmpFile)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return false, err
+	}
+
+	flt.validators = validators
 
-	return res.Checksum != flt.checksum && err == nil, err
+	return res.Checksum != flt.checksum, nil
 }
 
 // finalizeUpdate closes and gets rid of temporary file f with filter's content
@@ -503,49 +830,164 @@ func (d *DNSFilter) finalizeUpdate(
 	flt.ensureName(res.Title)
 	flt.checksum = res.Checksum
 	flt.RulesCount = rulesCount
+	flt.hasAffectingRules = res.HasAffectingRules
+	flt.setSkippedRules(res)
 
 	return nil
 }
 
 // reader returns an io.ReadCloser reading filtering-rule list data form either
-// a file on the filesystem or the filter's HTTP URL.
-func (d *DNSFilter) reader(fltURL string) (r io.ReadCloser, err error) {
+// a file on the filesystem or the filter's HTTP URL.  For URLs, the request is
+// conditional if v contains any validators, and errNotModified is returned if
+// the list hasn't changed.  newV are the validators of the received data.
+func (d *DNSFilter) reader(
+	ctx context.Context,
+	fltURL string,
+	v listValidators,
+) (r io.ReadCloser, newV listValidators, err error) {
 	if !filepath.IsAbs(fltURL) {
-		r, err = d.readerFromURL(fltURL)
-		if err != nil {
-			return nil, fmt.Errorf("reading from url: %w", err)
+		r, newV, err = d.readerFromURL(ctx, fltURL, v)
+		if errors.Is(err, errNotModified) {
+			// Don't wrap the sentinel error to simplify checking for it.
+			return nil, listValidators{}, err
+		} else if err != nil {
+			return nil, listValidators{}, fmt.Errorf("reading from url: %w", err)
 		}
 
-		return r, nil
+		return r, newV, nil
 	}
 
 	fltURL = filepath.Clean(fltURL)
 	if !pathMatchesAny(d.safeFSPatterns, fltURL) {
-		return nil, fmt.Errorf("path %q does not match safe patterns", fltURL)
+		return nil, listValidators{}, fmt.Errorf("path %q does not match safe patterns", fltURL)
 	}
 
 	r, err = os.Open(fltURL)
 	if err != nil {
-		return nil, fmt.Errorf("opening file: %w", err)
+		return nil, listValidators{}, fmt.Errorf("opening file: %w", err)
 	}
 
-	return r, nil
+	return r, listValidators{}, nil
 }
 
 // readerFromURL returns an io.ReadCloser reading filtering-rule list data form
-// the filter's URL.
-func (d *DNSFilter) readerFromURL(fltURL string) (r io.ReadCloser, err error) {
-	resp, err := d.conf.HTTPClient.Get(fltURL)
+// the filter's URL.  See [DNSFilter.reader].
+func (d *DNSFilter) readerFromURL(
+	ctx context.Context,
+	fltURL string,
+	v listValidators,
+) (r io.ReadCloser, newV listValidators, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fltURL, nil)
+	if err != nil {
+		return nil, listValidators{}, fmt.Errorf("creating request: %w", err)
+	}
+
+	if v.eTag != "" {
+		req.Header.Set(httphdr.IfNoneMatch, v.eTag)
+	}
+
+	if v.lastModified != "" {
+		req.Header.Set(httphdr.IfModifiedSince, v.lastModified)
+	}
+
+	// Set the header explicitly, since the transport only decodes gzip
+	// transparently, and only when it has set the header itself.
+	req.Header.Set(httphdr.AcceptEncoding, "gzip, deflate")
+
+	resp, err := d.conf.HTTPClient.Do(req)
 	if err != nil {
 		// Don't wrap the error since it's informative enough as is.
-		return nil, err
+		return nil, listValidators{}, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		r, err = decodedBody(resp)
+		if err != nil {
+			return nil, listValidators{}, errors.WithDeferred(err, resp.Body.Close())
+		}
+
+		newV = listValidators{
+			eTag:         resp.Header.Get(httphdr.ETag),
+			lastModified: resp.Header.Get(httphdr.LastModified),
+		}
+
+		return r, newV, nil
+	case http.StatusNotModified:
+		err = errNotModified
+	default:
+		err = fmt.Errorf("got status code %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	return nil, listValidators{}, errors.WithDeferred(err, resp.Body.Close())
+}
+
+// decodedBody returns the body of resp decoded according to its content
+// encoding.  The size of the decoded data is limited by [maxDecodedListSize].
+// Closing r also closes the body.
+func decodedBody(resp *http.Response) (r io.ReadCloser, err error) {
+	var dec io.ReadCloser
+	enc := strings.ToLower(strings.TrimSpace(resp.Header.Get(httphdr.ContentEncoding)))
+	switch enc {
+	case "", "identity":
+		return resp.Body, nil
+	case "gzip", "x-gzip":
+		dec, err = gzip.NewReader(resp.Body)
+	case "deflate":
+		dec, err = newDeflateReader(resp.Body)
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", enc)
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("got status code %d, want %d", resp.StatusCode, http.StatusOK)
+	if err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", enc, err)
 	}
 
-	return resp.Body, nil
+	return &decodingReader{
+		Reader:  ioutil.LimitReader(dec, maxDecodedListSize.Bytes()),
+		decoder: dec,
+		body:    resp.Body,
+	}, nil
+}
+
+// newDeflateReader returns a reader decoding the "deflate" content encoding
+// from body.  The encoding should use the zlib format, but some servers send
+// the raw deflate data, so both are supported.
+func newDeflateReader(body io.Reader) (dec io.ReadCloser, err error) {
+	br := bufio.NewReader(body)
+	hdr, err := br.Peek(2)
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+
+	// See RFC 1950, Section 2.2.
+	cmf, flg := uint16(hdr[0]), uint16(hdr[1])
+	if cmf&0x0f == 8 && (cmf<<8|flg)%31 == 0 {
+		// Don't wrap the error since it's informative enough as is.
+		return zlib.NewReader(br)
+	}
+
+	return flate.NewReader(br), nil
+}
+
+// decodingReader is an [io.ReadCloser] reading the decoded data of an HTTP
+// response body.
+type decodingReader struct {
+	io.Reader
+
+	// decoder is the decoder of the body data.
+	decoder io.Closer
+
+	// body is the original body of the response.
+	body io.Closer
+}
+
+// type check
+var _ io.ReadCloser = (*decodingReader)(nil)
+
+// Close implements the [io.Closer] interface for *decodingReader.
+func (r *decodingReader) Close() (err error) {
+	return errors.WithDeferred(r.decoder.Close(), r.body.Close())
 }
 
 // loads filter contents from the file in dataDir
@@ -573,7 +1015,7 @@ func (d *DNSFilter) load(flt *FilterYAML) (err error) {
 	bufPtr := d.bufPool.Get()
 	defer d.bufPool.Put(bufPtr)
 
-	p := rulelist.NewParser()
+	p := rulelist.NewStoredParser()
 	res, err := p.Parse(io.Discard, file, *bufPtr)
 	if err != nil {
 		return fmt.Errorf("parsing filter file: %w", err)
@@ -581,6 +1023,8 @@ func (d *DNSFilter) load(flt *FilterYAML) (err error) {
 
 	flt.ensureName(res.Title)
 	flt.RulesCount, flt.checksum, flt.LastUpdated = res.RulesCount, res.Checksum, st.ModTime()
+	flt.hasAffectingRules = res.HasAffectingRules
+	flt.setSkippedRules(res)
 
 	return nil
 }
@@ -593,10 +1037,13 @@ func (d *DNSFilter) EnableFilters(async bool) {
 }
 
 func (d *DNSFilter) enableFiltersLocked(async bool) {
-	filters := make([]Filter, 1, len(d.conf.Filters)+len(d.conf.WhitelistFilters)+1)
-	filters[0] = Filter{
-		ID:   rulelist.URLFilterIDCustom,
-		Data: []byte(strings.Join(d.conf.UserRules, "\n")),
+	p := &filtersInitializerParams{}
+
+	filters := make([]Filter, 0, len(d.conf.Filters)+1)
+	if userRulesNeedMerge(d.conf.UserRules, d.listsHaveAffectingRules()) {
+		filters = append(filters, *d.userFilter())
+	} else {
+		p.userFilter = d.userFilter()
 	}
 
 	for _, filter := range d.conf.Filters {
@@ -622,10 +1069,55 @@ func (d *DNSFilter) enableFiltersLocked(async bool) {
 		})
 	}
 
-	err := d.setFilters(filters, allowFilters, async)
+	p.blockFilters, p.allowFilters = filters, allowFilters
+
+	err := d.setFilters(p, async)
 	if err != nil {
 		log.Error("filtering: enabling filters: %s", err)
 	}
 
 	d.SetEnabled(d.conf.FilteringEnabled)
 }
+
+// enableUserRules applies the changed user rules.  Unless the user rules affect
+// the rules of the filter lists, only the engine of the user rules is rebuilt.
+func (d *DNSFilter) enableUserRules() {
+	d.conf.filtersMu.RLock()
+	defer d.conf.filtersMu.RUnlock()
+
+	if !userRulesNeedMerge(d.conf.UserRules, d.listsHaveAffectingRules()) {
+		p := &filtersInitializerParams{
+			userFilter: d.userFilter(),
+			userOnly:   true,
+		}
+
+		err := d.setFilters(p, true)
+		if err == nil {
+			return
+		} else if !errors.Is(err, errUserRulesMerged) {
+			log.Error("filtering: enabling user rules: %s", err)
+
+			return
+		}
+	}
+
+	d.enableFiltersLocked(true)
+}
+
+// listsHaveAffectingRules returns true if any of the enabled blocklists
+// contains rules affecting the rules of other lists.  d.conf.filtersMu is
+// expected to be locked.
+func (d *DNSFilter) listsHaveAffectingRules() (ok bool) {
+	return slices.ContainsFunc(d.conf.Filters, func(f FilterYAML) (affecting bool) {
+		return f.Enabled && f.hasAffectingRules
+	})
+}
+
+// userFilter returns the filter with the user rules.  d.conf.filtersMu is
+// expected to be locked.
+func (d *DNSFilter) userFilter() (f *Filter) {
+	return &Filter{
+		ID:   rulelist.URLFilterIDCustom,
+		Data: []byte(strings.Join(d.conf.UserRules, "\n")),
+	}
+}
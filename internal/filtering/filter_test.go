@@ -1,14 +1,25 @@
 package filtering
 
 import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"io"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering/rulelist"
+	"github.com/AdguardTeam/golibs/httphdr"
 	"github.com/AdguardTeam/golibs/netutil/urlutil"
 	"github.com/AdguardTeam/golibs/testutil"
 	"github.com/stretchr/testify/assert"
@@ -20,7 +31,7 @@ const testTimeout = 5 * time.Second
 
 // serveHTTPLocally starts a new HTTP server, that handles its index with h.  It
 // also gracefully closes the listener when the test under t finishes.
-func serveHTTPLocally(t *testing.T, h http.Handler) (urlStr string) {
+func serveHTTPLocally(t testing.TB, h http.Handler) (urlStr string) {
 	t.Helper()
 
 	l, err := net.Listen("tcp", ":0")
@@ -40,7 +51,7 @@ func serveHTTPLocally(t *testing.T, h http.Handler) (urlStr string) {
 
 // serveFiltersLocally is a helper that concurrently listens on a free port to
 // respond with fltContent.
-func serveFiltersLocally(t *testing.T, fltContent []byte) (urlStr string) {
+func serveFiltersLocally(t testing.TB, fltContent []byte) (urlStr string) {
 	t.Helper()
 
 	return serveHTTPLocally(t, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
@@ -63,7 +74,7 @@ func updateAndAssert(
 ) {
 	t.Helper()
 
-	ok, err := dnsFilter.update(f)
+	ok, err := dnsFilter.update(testutil.ContextWithTimeout(t, testTimeout), f)
 	require.NoError(t, err)
 	wantUpd(t, ok)
 
@@ -80,7 +91,7 @@ func updateAndAssert(
 }
 
 // newDNSFilter returns a new properly initialized DNS filter instance.
-func newDNSFilter(t *testing.T) (d *DNSFilter) {
+func newDNSFilter(t testing.TB) (d *DNSFilter) {
 	t.Helper()
 
 	dnsFilter, err := New(&Config{
@@ -136,6 +147,209 @@ func TestDNSFilter_Update(t *testing.T) {
 	})
 }
 
+func TestDNSFilter_Update_notModified(t *testing.T) {
+	const (
+		content      = "||example.org^\n||example.com^\n"
+		eTag         = `"v1"`
+		lastModified = "Mon, 02 Jan 2006 15:04:05 GMT"
+	)
+
+	var served, notModified atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pt := testutil.PanicT{}
+
+		if r.Header.Get(httphdr.IfNoneMatch) == eTag {
+			require.Equal(pt, lastModified, r.Header.Get(httphdr.IfModifiedSince))
+
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+
+			return
+		}
+
+		served.Add(1)
+		w.Header().Set(httphdr.ETag, eTag)
+		w.Header().Set(httphdr.LastModified, lastModified)
+
+		_, werr := io.WriteString(w, content)
+		require.NoError(pt, werr)
+	}))
+	t.Cleanup(srv.Close)
+
+	d := newDNSFilter(t)
+	d.conf.Filters = []FilterYAML{{
+		Enabled: true,
+		URL:     srv.URL,
+		Name:    "test-filter",
+		Filter:  Filter{ID: 1},
+	}}
+
+	ctx := testutil.ContextWithTimeout(t, testTimeout)
+	upds, isNetErr, ok := d.tryRefreshFilters(ctx, true, false, true)
+	require.True(t, ok)
+	require.False(t, isNetErr)
+	require.Len(t, upds, 1)
+
+	assert.Equal(t, int32(1), served.Load())
+	assert.Equal(t, 2, d.conf.Filters[0].RulesCount)
+
+	fltPath := d.conf.Filters[0].Path(d.conf.DataDir)
+	fiBefore, err := os.Stat(fltPath)
+	require.NoError(t, err)
+
+	engine := d.filteringEngine
+	require.NotNil(t, engine)
+
+	upds, isNetErr, ok = d.tryRefreshFilters(ctx, true, false, true)
+	require.True(t, ok)
+	require.False(t, isNetErr)
+
+	assert.Empty(t, upds)
+	assert.Equal(t, int32(1), served.Load())
+	assert.Equal(t, int32(1), notModified.Load())
+	assert.Equal(t, 2, d.conf.Filters[0].RulesCount)
+
+	// The file must not be replaced and the engine must not be rebuilt.
+	fiAfter, err := os.Stat(fltPath)
+	require.NoError(t, err)
+
+	assert.True(t, os.SameFile(fiBefore, fiAfter))
+	assert.Same(t, engine, d.filteringEngine)
+}
+
+func TestDNSFilter_Update_compressed(t *testing.T) {
+	const content = "||example.org^\n||example.com^\n! Comment\n"
+
+	testCases := []struct {
+		newWriter func(w io.Writer) (cw io.WriteCloser)
+		name      string
+		encoding  string
+	}{{
+		newWriter: func(w io.Writer) (cw io.WriteCloser) { return gzip.NewWriter(w) },
+		name:      "gzip",
+		encoding:  "gzip",
+	}, {
+		newWriter: func(w io.Writer) (cw io.WriteCloser) { return zlib.NewWriter(w) },
+		name:      "deflate",
+		encoding:  "deflate",
+	}, {
+		newWriter: func(w io.Writer) (cw io.WriteCloser) {
+			fw, err := flate.NewWriter(w, flate.DefaultCompression)
+			require.NoError(t, err)
+
+			return fw
+		},
+		name:     "raw_deflate",
+		encoding: "deflate",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			cw := tc.newWriter(buf)
+
+			_, err := io.WriteString(cw, content)
+			require.NoError(t, err)
+			require.NoError(t, cw.Close())
+
+			data := buf.Bytes()
+			fltURL := serveHTTPLocally(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				pt := testutil.PanicT{}
+
+				require.Contains(pt, r.Header.Get(httphdr.AcceptEncoding), tc.encoding)
+
+				w.Header().Set(httphdr.ContentEncoding, tc.encoding)
+				_, werr := w.Write(data)
+				require.NoError(pt, werr)
+			}))
+
+			d := newDNSFilter(t)
+			f := &FilterYAML{
+				URL:  fltURL,
+				Name: "test-filter",
+			}
+
+			updateAndAssert(t, d, f, require.True, 2)
+		})
+	}
+}
+
+func TestDNSFilter_listsToUpdate(t *testing.T) {
+	now := time.Now()
+
+	d := newDNSFilter(t)
+	d.conf.FiltersUpdateIntervalHours = 24
+	d.conf.Filters = []FilterYAML{{
+		Enabled:     true,
+		URL:         "https://global.example",
+		LastUpdated: now.Add(-2 * time.Hour),
+		Filter:      Filter{ID: 1},
+	}, {
+		Enabled:             true,
+		URL:                 "https://hourly.example",
+		LastUpdated:         now.Add(-2 * time.Hour),
+		UpdateIntervalHours: 1,
+		Filter:              Filter{ID: 2},
+	}, {
+		Enabled:             true,
+		URL:                 "https://weekly.example",
+		LastUpdated:         now.Add(-48 * time.Hour),
+		UpdateIntervalHours: 7 * 24,
+		Filter:              Filter{ID: 3},
+	}}
+
+	toUpd := d.listsToUpdate(&d.conf.Filters, false)
+	require.Len(t, toUpd, 1)
+
+	assert.Equal(t, "https://hourly.example", toUpd[0].URL)
+
+	// The hourly list is due, so wake up as soon as possible.
+	assert.LessOrEqual(t, d.nextRefreshIvl(now, time.Hour), time.Duration(0))
+
+	d.conf.Filters[1].LastUpdated = now.Add(-30 * time.Minute)
+	assert.Equal(t, 30*time.Minute, d.nextRefreshIvl(now, time.Hour))
+
+	d.conf.Filters[1].Enabled = false
+	assert.Equal(t, time.Hour, d.nextRefreshIvl(now, time.Hour))
+}
+
+func TestDNSFilter_Update_skippedRules(t *testing.T) {
+	const content = "! Title: Test\n" +
+		"||valid.example^\n" +
+		"example.org##.banner\n" +
+		"||garbage.example^$nonexistent_modifier\n" +
+		"# Comment\n" +
+		"0.0.0.0 hosts.example\n" +
+		"||garbage-2.example^$nonexistent_modifier\n"
+
+	f := &FilterYAML{
+		URL:  serveFiltersLocally(t, []byte(content)),
+		Name: "test-filter",
+	}
+
+	dnsFilter := newDNSFilter(t)
+
+	updateAndAssert(t, dnsFilter, f, require.True, 5)
+
+	assert.Equal(t, 2, f.SkippedRulesCount)
+	require.Len(t, f.SkippedRules, 2)
+
+	assert.Equal(t, 4, f.SkippedRules[0].Line)
+	assert.Equal(t, "||garbage.example^$nonexistent_modifier", f.SkippedRules[0].Text)
+	assert.Equal(t, 7, f.SkippedRules[1].Line)
+
+	fj := filterToJSON(*f)
+	assert.Equal(t, uint32(2), fj.SkippedRulesCount)
+	require.Len(t, fj.SkippedRules, 2)
+
+	assert.Equal(t, 4, fj.SkippedRules[0].Line)
+	assert.Equal(t, 7, fj.SkippedRules[1].Line)
+
+	f.unload()
+	assert.Zero(t, f.SkippedRulesCount)
+	assert.Empty(t, f.SkippedRules)
+}
+
 func TestFilterYAML_EnsureName(t *testing.T) {
 	dnsFilter := newDNSFilter(t)
 
@@ -173,3 +387,222 @@ func TestFilterYAML_EnsureName(t *testing.T) {
 		assert.Equal(t, "List 0", f.Name)
 	})
 }
+
+func TestDNSFilter_updateLists(t *testing.T) {
+	const content = "||example.org^\n||example.com^\n"
+
+	okURL := serveFiltersLocally(t, []byte(content))
+	failURL := serveHTTPLocally(t, http.NotFoundHandler())
+
+	lists := []FilterYAML{{
+		Filter: Filter{ID: 1},
+		URL:    okURL,
+	}, {
+		Filter: Filter{ID: 2},
+		URL:    failURL,
+	}, {
+		Filter: Filter{ID: 3},
+		URL:    okURL,
+	}, {
+		Filter: Filter{ID: 4},
+		URL:    failURL,
+	}}
+
+	d := newDNSFilter(t)
+	d.conf.FilterUpdateConcurrency = 2
+
+	flags, failNum, err := d.updateLists(testutil.ContextWithTimeout(t, testTimeout), lists)
+	require.Error(t, err)
+
+	// Both errors are reported.
+	assert.Equal(t, 2, failNum)
+	assert.Equal(t, []bool{true, false, true, false}, flags)
+
+	for _, l := range lists {
+		assert.False(t, l.LastUpdated.IsZero())
+	}
+
+	assert.Equal(t, 2, lists[0].RulesCount)
+	assert.Equal(t, 2, lists[2].RulesCount)
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(testutil.ContextWithTimeout(t, testTimeout))
+		cancel()
+
+		flags, failNum, err = d.updateLists(ctx, lists)
+		assert.ErrorIs(t, err, context.Canceled)
+
+		assert.Equal(t, len(lists), failNum)
+		assert.Equal(t, []bool{false, false, false, false}, flags)
+	})
+
+	t.Run("canceled_download", func(t *testing.T) {
+		started := make(chan struct{}, 1)
+		blockHdlr := http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			started <- struct{}{}
+			<-r.Context().Done()
+		})
+		blockURL := serveHTTPLocally(t, blockHdlr)
+
+		blockLists := []FilterYAML{{
+			Filter: Filter{ID: 5},
+			URL:    blockURL,
+		}}
+
+		ctx, cancel := context.WithCancel(testutil.ContextWithTimeout(t, testTimeout))
+		defer cancel()
+
+		errCh := make(chan error, 1)
+		go func() {
+			_, _, updErr := d.updateLists(ctx, blockLists)
+			errCh <- updErr
+		}()
+
+		testutil.RequireReceive(t, started, testTimeout)
+		cancel()
+
+		updErr, _ := testutil.RequireReceive(t, errCh, testTimeout)
+		assert.ErrorIs(t, updErr, context.Canceled)
+	})
+}
+
+func TestDNSFilter_tryRefreshFilters_concurrency(t *testing.T) {
+	const (
+		content     = "||example.org^\n||example.com^\n"
+		listsNum    = 10
+		concurrency = 3
+	)
+
+	var inFlight, maxInFlight atomic.Int32
+	started := make(chan struct{}, listsNum)
+	release := make(chan struct{})
+
+	// newHandler returns a handler of a list server, which holds the request
+	// until release is closed and then responds with the content or, if fail
+	// is true, with an error.
+	newHandler := func(fail bool) (h http.Handler) {
+		return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			cur := inFlight.Add(1)
+			defer inFlight.Add(-1)
+
+			for prev := maxInFlight.Load(); cur > prev; prev = maxInFlight.Load() {
+				if maxInFlight.CompareAndSwap(prev, cur) {
+					break
+				}
+			}
+
+			testutil.RequireSend(testutil.PanicT{}, started, struct{}{}, testTimeout)
+			<-release
+
+			if fail {
+				http.Error(w, "failed", http.StatusInternalServerError)
+
+				return
+			}
+
+			_, _ = io.WriteString(w, content)
+		})
+	}
+
+	d := newDNSFilter(t)
+	d.conf.FilterUpdateConcurrency = concurrency
+
+	for i := range listsNum {
+		d.conf.Filters = append(d.conf.Filters, FilterYAML{
+			Enabled: true,
+			URL:     serveHTTPLocally(t, newHandler(i%4 == 1)),
+			Name:    fmt.Sprintf("list-%d", i),
+			Filter:  Filter{ID: rulelist.URLFilterID(i + 1)},
+		})
+	}
+
+	type result struct {
+		upds     []*filterUpdate
+		isNetErr bool
+		ok       bool
+	}
+
+	ctx := testutil.ContextWithTimeout(t, testTimeout)
+	results := make(chan result, 1)
+	go func() {
+		upds, isNetErr, ok := d.tryRefreshFilters(ctx, true, false, true)
+		results <- result{
+			upds:     upds,
+			isNetErr: isNetErr,
+			ok:       ok,
+		}
+	}()
+
+	// Wait for the pool to fill up and make sure that no more requests are
+	// sent before letting the requests finish.
+	for range concurrency {
+		testutil.RequireReceive(t, started, testTimeout)
+	}
+
+	assert.Never(t, func() (ok bool) {
+		return inFlight.Load() > concurrency
+	}, 100*time.Millisecond, 10*time.Millisecond)
+
+	close(release)
+
+	res, _ := testutil.RequireReceive(t, results, testTimeout)
+	require.True(t, res.ok)
+	require.False(t, res.isNetErr)
+
+	assert.Equal(t, int32(concurrency), maxInFlight.Load())
+
+	// The lists failed to download must not prevent the others from being
+	// updated.
+	assert.Len(t, res.upds, 7)
+	for i, f := range d.conf.Filters {
+		if i%4 == 1 {
+			assert.Zero(t, f.RulesCount)
+		} else {
+			assert.Equal(t, 2, f.RulesCount)
+		}
+	}
+}
+
+// filterLatency is the simulated latency of the filter list servers in the
+// benchmarks.
+const filterLatency = 50 * time.Millisecond
+
+func BenchmarkDNSFilter_updateLists(b *testing.B) {
+	const listsNum = 20
+
+	content := []byte("||example.org^\n||example.com^\n")
+
+	lists := make([]FilterYAML, 0, listsNum)
+	for i := range listsNum {
+		h := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			time.Sleep(filterLatency)
+
+			_, _ = w.Write(content)
+		})
+
+		lists = append(lists, FilterYAML{
+			Filter: Filter{ID: rulelist.URLFilterID(i + 1)},
+			URL:    serveHTTPLocally(b, h),
+		})
+	}
+
+	for _, n := range []int{1, DefaultFilterUpdateConcurrency} {
+		b.Run(fmt.Sprintf("concurrency_%d", n), func(b *testing.B) {
+			d := newDNSFilter(b)
+			d.conf.FilterUpdateConcurrency = n
+
+			ctx := context.Background()
+
+			var failNum int
+			var err error
+
+			b.ReportAllocs()
+			for range b.N {
+				_, failNum, err = d.updateLists(ctx, lists)
+			}
+
+			require.NoError(b, err)
+			require.Zero(b, failNum)
+		})
+	}
+}
@@ -21,12 +21,14 @@ import (
 	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghos"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering/rulelist"
+	"github.com/AdguardTeam/AdGuardHome/internal/schedule"
 	"github.com/AdguardTeam/golibs/container"
 	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/hostsfile"
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/AdguardTeam/golibs/mathutil"
 	"github.com/AdguardTeam/golibs/syncutil"
+	"github.com/AdguardTeam/golibs/timeutil"
 	"github.com/AdguardTeam/urlfilter"
 	"github.com/AdguardTeam/urlfilter/filterlist"
 	"github.com/AdguardTeam/urlfilter/rules"
@@ -47,14 +49,39 @@ type Settings struct {
 
 	ServicesRules []ServiceEntry
 
+	// PausedServicesRules are the rules of the blocked services that aren't
+	// blocked currently due to the schedule.  It is only filled when
+	// [Config.DecisionDetails] is true.
+	PausedServicesRules []ServiceEntry
+
 	ProtectionEnabled   bool
 	FilteringEnabled    bool
 	SafeSearchEnabled   bool
 	SafeBrowsingEnabled bool
 	ParentalEnabled     bool
 
+	// DefaultDeny, if true, makes the hosts not matched by any allowlist rule
+	// blocked.  See [Config.DefaultDeny].
+	DefaultDeny bool
+
 	// ClientSafeSearch is a client configured safe search.
 	ClientSafeSearch SafeSearch
+
+	// ClientRules are the custom filtering rules of the client.  They are
+	// checked before the global filter lists.  It may be nil.
+	ClientRules *ClientRules
+
+	// BlockedSuffixes are the blocked domain name suffixes.  They are checked
+	// before the filter lists.  It may be nil.
+	BlockedSuffixes *SuffixSet
+
+	// IgnoreQueryLog, if true, means that the requests of the client aren't
+	// written to the query log.
+	IgnoreQueryLog bool
+
+	// IgnoreStatistics, if true, means that the requests of the client aren't
+	// counted in the statistics.
+	IgnoreStatistics bool
 }
 
 // Resolver is the interface for net.Resolver to simplify testing.
@@ -78,10 +105,19 @@ type Config struct {
 
 	SafeSearch SafeSearch `yaml:"-"`
 
+	// SafeSearchHealth is the health of the hosts enforced by safe search.
+	// It may be nil.
+	SafeSearchHealth SafeSearchHealth `yaml:"-"`
+
 	// BlockedServices is the configuration of blocked services.
 	// Per-client settings can override this configuration.
 	BlockedServices *BlockedServices `yaml:"blocked_services"`
 
+	// ConnectivityCheck is the configuration of the exemption of the
+	// connectivity-check domain names from blocking.  If nil, the exemption
+	// is disabled.
+	ConnectivityCheck *ConnectivityCheckConfig `yaml:"connectivity_check"`
+
 	// EtcHosts is a container of IP-hostname pairs taken from the operating
 	// system configuration files (e.g. /etc/hosts).
 	//
@@ -94,24 +130,83 @@ type Config struct {
 	// Register an HTTP handler
 	HTTPRegister aghhttp.RegisterFunc `yaml:"-"`
 
+	// OnUpdateFailure is called with the errors of the filter lists that
+	// couldn't be updated.  It may be nil.
+	OnUpdateFailure func(err error) `yaml:"-"`
+
 	// HTTPClient is the client to use for updating the remote filters.
 	HTTPClient *http.Client `yaml:"-"`
 
+	// WebhookURL is the URL to post the notifications about the updated filter
+	// lists to.  If empty, the notifications are disabled.
+	WebhookURL string `yaml:"webhook_url"`
+
+	// WebhookSecret is the key used to sign the bodies of the webhook
+	// notifications with HMAC-SHA256.
+	WebhookSecret string `yaml:"webhook_secret"`
+
+	// WebhookEvents are the types of the events to send the webhook
+	// notifications about, "filters_updated" and "client_blocked".  If empty,
+	// only the notifications about the updated filter lists are sent.
+	WebhookEvents []string `yaml:"webhook_events"`
+
+	// QueryLog is the source of the queries for the suggestions of filter
+	// lists.  If nil, the suggestions are disabled.
+	QueryLog QueryLogRanger `yaml:"-"`
+
+	// SuggestCatalogURL is the URL of the catalog of the community filter
+	// lists used for the suggestions.  If empty, [DefaultSuggestCatalogURL] is
+	// used.
+	SuggestCatalogURL string `yaml:"suggest_catalog_url"`
+
+	// CatalogIndexURL is the URL of the index of the catalog of the well-known
+	// filter lists.  The index is refreshed with the filter lists.  If empty,
+	// the index shipped with AdGuard Home is used.
+	CatalogIndexURL string `yaml:"catalog_index_url"`
+
+	// BlockedServicesIndexURL is the URL of the index of the blocked services.
+	// The index is refreshed with the filter lists and saved to the filters
+	// directory.  If empty, the index isn't refreshed.  Until the first
+	// successful refresh, the index shipped with AdGuard Home is used.
+	BlockedServicesIndexURL string `yaml:"blocked_services_index_url"`
+
+	// DecisionDetails, if true, makes the results of the requests that haven't
+	// been blocked despite a matching blocking rule contain the details about
+	// the mechanism that has overridden the rule.  See [DecisionDetail].
+	DecisionDetails bool `yaml:"decision_details"`
+
 	// filtersMu protects filter lists.
 	filtersMu *sync.RWMutex
 
-	// ProtectionDisabledUntil is the timestamp until when the protection is
-	// disabled.
-	ProtectionDisabledUntil *time.Time `yaml:"protection_disabled_until"`
+	// ProtectionSchedule is the weekly schedule of the time when the
+	// protection is disabled.  While the schedule is active, the protection is
+	// disabled regardless of ProtectionEnabled and of the pause set by
+	// [DNSFilter.SetProtectionStatus].  If nil, the protection is only
+	// controlled manually.
+	ProtectionSchedule *schedule.Weekly `yaml:"protection_schedule,omitempty"`
 
 	SafeSearchConf SafeSearchConfig `yaml:"safe_search"`
 
+	// SafeSearchCheckIvl is the interval of checking that the hosts enforced by
+	// safe search, such as forcesafesearch.google.com, resolve.  If zero, the
+	// hosts aren't checked.
+	SafeSearchCheckIvl timeutil.Duration `yaml:"safe_search_check_interval"`
+
+	// SafeSearchFailClosed, if true, makes the requests rewritten by safe
+	// search to the enforced hosts that fail to resolve blocked.
+	SafeSearchFailClosed bool `yaml:"safe_search_fail_closed"`
+
 	// DataDir is used to store filters' contents.
 	DataDir string `yaml:"-"`
 
 	// BlockingMode defines the way how blocked responses are constructed.
 	BlockingMode BlockingMode `yaml:"blocking_mode"`
 
+	// AAAABlockingMode defines the way how blocked responses to AAAA requests
+	// are constructed.  If it's empty or [AAAABlockingModeDefault],
+	// BlockingMode is used.
+	AAAABlockingMode AAAABlockingMode `yaml:"blocking_mode_aaaa"`
+
 	// ParentalBlockHost is the IP (or domain name) which is used to respond to
 	// DNS requests blocked by parental control.
 	ParentalBlockHost string `yaml:"parental_block_host"`
@@ -120,8 +215,17 @@ type Config struct {
 	// to DNS requests blocked by safe-browsing.
 	SafeBrowsingBlockHost string `yaml:"safebrowsing_block_host"`
 
+	// SafeBrowsingBlockingMode defines the way blocked responses to DNS
+	// requests blocked by safe-browsing and parental control are constructed.
+	// If it's empty, [SafeBrowsingBlockingModeBlockHost] is used.
+	SafeBrowsingBlockingMode SafeBrowsingBlockingMode `yaml:"safebrowsing_blocking_mode"`
+
 	Rewrites []*LegacyRewrite `yaml:"rewrites"`
 
+	// RewritesLocalAuthority, if true, makes all the rewrites behave as if
+	// their LocalAuthority field is set.
+	RewritesLocalAuthority bool `yaml:"rewrites_local_authority"`
+
 	// Filters are the blocking filter lists.
 	Filters []FilterYAML `yaml:"-"`
 
@@ -152,6 +256,11 @@ type Config struct {
 	// (in hours).
 	FiltersUpdateIntervalHours uint32 `yaml:"filters_update_interval"`
 
+	// FilterUpdateConcurrency is the maximum number of the filter lists
+	// downloaded at the same time during an update.  If it's not positive,
+	// [DefaultFilterUpdateConcurrency] is used.
+	FilterUpdateConcurrency int `yaml:"filter_update_concurrency"`
+
 	// BlockedResponseTTL is the time-to-live value for blocked responses.  If
 	// 0, then default value is used (3600).
 	BlockedResponseTTL uint32 `yaml:"blocked_response_ttl"`
@@ -159,6 +268,17 @@ type Config struct {
 	// FilteringEnabled indicates whether or not use filter lists.
 	FilteringEnabled bool `yaml:"filtering_enabled"`
 
+	// DefaultDeny, if true, inverts the default verdict of the filtering, so
+	// that only the hosts matched by an allowlist rule, either an exception
+	// rule or a rule from an allowlist, are resolved.  Rewrites, hosts files,
+	// and DHCP hostnames still resolve.
+	DefaultDeny bool `yaml:"default_deny"`
+
+	// BlockedSuffixes is the list of the blocked domain name suffixes, such as
+	// whole TLDs.  See [NewSuffixSet] for the format.  Per-client settings can
+	// override it.
+	BlockedSuffixes []string `yaml:"blocked_suffixes"`
+
 	ParentalEnabled     bool `yaml:"parental_enabled"`
 	SafeBrowsingEnabled bool `yaml:"safebrowsing_enabled"`
 
@@ -190,6 +310,44 @@ const (
 	BlockingModeREFUSED BlockingMode = "refused"
 )
 
+// AAAABlockingMode is an enum of all allowed blocking modes for AAAA requests.
+type AAAABlockingMode string
+
+// Allowed blocking modes for AAAA requests.
+const (
+	// AAAABlockingModeDefault means respond in accordance with the
+	// [BlockingMode].
+	AAAABlockingModeDefault AAAABlockingMode = "default"
+
+	// AAAABlockingModeNullIP means respond with a zero IPv6 address: "::".
+	AAAABlockingModeNullIP AAAABlockingMode = "null_ip"
+
+	// AAAABlockingModeNXDOMAIN means respond with the NXDOMAIN code.
+	AAAABlockingModeNXDOMAIN AAAABlockingMode = "nxdomain"
+
+	// AAAABlockingModeNODATA means respond with the NOERROR code and no
+	// answer.
+	AAAABlockingModeNODATA AAAABlockingMode = "nodata"
+)
+
+// SafeBrowsingBlockingMode is an enum of all allowed blocking modes for the
+// requests blocked by safe-browsing and parental control.
+type SafeBrowsingBlockingMode string
+
+// Allowed blocking modes for the requests blocked by safe-browsing and parental
+// control.
+const (
+	// SafeBrowsingBlockingModeBlockHost means respond with the IP addresses of
+	// [Config.SafeBrowsingBlockHost] or [Config.ParentalBlockHost].
+	SafeBrowsingBlockingModeBlockHost SafeBrowsingBlockingMode = "block_host"
+
+	// SafeBrowsingBlockingModeBlockingMode means respond in accordance with the
+	// [BlockingMode], like for the requests blocked by filtering rules.  It
+	// prevents the browsers from showing TLS errors caused by the certificate
+	// of the block host.
+	SafeBrowsingBlockingModeBlockingMode SafeBrowsingBlockingMode = "blocking_mode"
+)
+
 // LookupStats store stats collected during safebrowsing or parental checks
 type LookupStats struct {
 	Requests   uint64 // number of HTTP requests that were sent
@@ -205,10 +363,19 @@ type Stats struct {
 	Safesearch   LookupStats
 }
 
-// Parameters to pass to filters-initializer goroutine
+// filtersInitializerParams are the parameters of an initialization of the
+// filtering engines.
 type filtersInitializerParams struct {
 	allowFilters []Filter
 	blockFilters []Filter
+
+	// userFilter is the filter with the user rules, which are matched by a
+	// separate engine.  It's nil if the user rules are a part of blockFilters.
+	userFilter *Filter
+
+	// userOnly is true if only the engine of the user rules should be rebuilt.
+	// allowFilters and blockFilters are ignored in that case.
+	userOnly bool
 }
 
 type hostChecker struct {
@@ -236,6 +403,13 @@ type DNSFilter struct {
 	rulesStorageAllow    *filterlist.RuleStorage
 	filteringEngineAllow *urlfilter.DNSEngine
 
+	// userRulesStorage and userEngine match the user rules separately from the
+	// filter lists, so that changing the user rules doesn't require rebuilding
+	// the much larger engine of the lists.  They're nil if the user rules are
+	// a part of filteringEngine, see [userRulesNeedMerge].
+	userRulesStorage *filterlist.RuleStorage
+	userEngine       *urlfilter.DNSEngine
+
 	safeSearch SafeSearch
 
 	// safeBrowsingChecker is the safe browsing hash-prefix checker.
@@ -246,24 +420,68 @@ type DNSFilter struct {
 
 	engineLock sync.RWMutex
 
-	// confMu protects conf.
+	// confMu protects conf and protectionPausedUntil.
 	confMu *sync.RWMutex
 
 	// conf contains filtering parameters.
 	conf *Config
 
+	// protectionPausedUntil is the time until when the protection is paused.
+	// It isn't stored in the configuration file, so that the protection isn't
+	// left disabled after a restart.
+	protectionPausedUntil *time.Time
+
 	// done is the channel to signal to stop running filters updates loop.
 	done chan struct{}
 
 	// Channel for passing data to filters-initializer goroutine
-	filtersInitializerChan chan filtersInitializerParams
+	filtersInitializerChan chan *filtersInitializerParams
+
+	// filtersInitializerLock protects filtersInitializerChan and
+	// userRulesSeparate.
 	filtersInitializerLock sync.Mutex
 
+	// userRulesSeparate is true if the latest requested initialization of the
+	// engines has the user rules matched by a separate engine.
+	userRulesSeparate bool
+
 	refreshLock *sync.Mutex
 
 	hostCheckers []hostChecker
 
 	safeFSPatterns []string
+
+	// connCheckDomains is the set of the normalized connectivity-check domain
+	// names.  It's protected by confMu.
+	connCheckDomains *container.MapSet[string]
+
+	// webhook sends the notifications about the updated filter lists and the
+	// blocked requests.  It's nil if the webhook is not configured.
+	webhook *webhookNotifier
+
+	// shutdownCtx is canceled when the filter is closed.  It's used by the
+	// background tasks, such as sending the webhook notifications, which may
+	// outlive the HTTP requests initiating them.
+	shutdownCtx context.Context
+
+	// cancelShutdown cancels shutdownCtx.
+	cancelShutdown context.CancelFunc
+
+	// suggester suggests the filter lists based on the query log.
+	suggester *listSuggester
+
+	// catalog is the catalog of the well-known filter lists.
+	catalog *filterCatalog
+
+	// servicesUpdater refreshes the index of the blocked services.
+	servicesUpdater *servicesUpdater
+
+	// blockedSuffixes is the compiled set of [Config.BlockedSuffixes].  It's
+	// nil if there are none.
+	blockedSuffixes *SuffixSet
+
+	// now returns the current time.  It's replaced in tests.
+	now func() (t time.Time)
 }
 
 // Filter represents a filter list
@@ -322,6 +540,10 @@ const (
 	//
 	// See https://github.com/AdguardTeam/AdGuardHome/issues/2499.
 	RewrittenRule
+
+	// NotFilteredConnectivityCheck is returned when the host is a
+	// connectivity-check domain exempted from blocking.
+	NotFilteredConnectivityCheck
 )
 
 // TODO(a.garipov): Resync with actual code names or replace completely
@@ -341,6 +563,8 @@ var reasonNames = []string{
 	Rewritten:          "Rewrite",
 	RewrittenAutoHosts: "RewriteEtcHosts",
 	RewrittenRule:      "RewriteRule",
+
+	NotFilteredConnectivityCheck: "NotFilteredConnectivityCheck",
 }
 
 func (r Reason) String() string {
@@ -369,6 +593,8 @@ func (d *DNSFilter) Settings() (s *Settings) {
 		SafeSearchEnabled:   d.conf.SafeSearchConf.Enabled,
 		SafeBrowsingEnabled: d.conf.SafeBrowsingEnabled,
 		ParentalEnabled:     d.conf.ParentalEnabled,
+		DefaultDeny:         d.conf.DefaultDeny,
+		BlockedSuffixes:     d.blockedSuffixes,
 	}
 }
 
@@ -380,6 +606,8 @@ func (d *DNSFilter) WriteDiskConfig(c *Config) {
 
 		*c = *d.conf
 		c.Rewrites = cloneRewrites(c.Rewrites)
+		c.ConnectivityCheck = c.ConnectivityCheck.Clone()
+		c.BlockedSuffixes = slices.Clone(c.BlockedSuffixes)
 	}()
 
 	d.conf.filtersMu.RLock()
@@ -390,38 +618,46 @@ func (d *DNSFilter) WriteDiskConfig(c *Config) {
 	c.UserRules = slices.Clone(d.conf.UserRules)
 }
 
+// errUserRulesMerged is returned by [DNSFilter.setFilters] when only the user
+// rules are requested to be rebuilt, but they are a part of the engine of the
+// filter lists.
+const errUserRulesMerged errors.Error = "user rules are merged with filter lists"
+
 // setFilters sets new filters, synchronously or asynchronously.  When filters
 // are set asynchronously, the old filters continue working until the new
 // filters are ready.
 //
 // In this case the caller must ensure that the old filter files are intact.
-func (d *DNSFilter) setFilters(blockFilters, allowFilters []Filter, async bool) error {
-	if async {
-		params := filtersInitializerParams{
-			allowFilters: allowFilters,
-			blockFilters: blockFilters,
-		}
-
-		d.filtersInitializerLock.Lock()
-		defer d.filtersInitializerLock.Unlock()
-
-		// Remove all pending tasks.
-	removeLoop:
-		for {
-			select {
-			case <-d.filtersInitializerChan:
-				// Continue removing.
-			default:
-				break removeLoop
-			}
-		}
+func (d *DNSFilter) setFilters(p *filtersInitializerParams, async bool) (err error) {
+	d.filtersInitializerLock.Lock()
+	defer d.filtersInitializerLock.Unlock()
+
+	if !p.userOnly {
+		d.userRulesSeparate = p.userFilter != nil
+	} else if !d.userRulesSeparate {
+		return errUserRulesMerged
+	}
 
-		d.filtersInitializerChan <- params
+	if !async {
+		return d.initFiltering(p)
+	}
 
-		return nil
+	// Remove the pending task, if any.  The tasks are replaced by the newer
+	// ones, but a pending full initialization must not be lost because of an
+	// update of the user rules.
+	select {
+	case pending := <-d.filtersInitializerChan:
+		if p.userOnly && !pending.userOnly {
+			pending.userFilter = p.userFilter
+			p = pending
+		}
+	default:
+		// Go on.
 	}
 
-	return d.initFiltering(allowFilters, blockFilters)
+	d.filtersInitializerChan <- p
+
+	return nil
 }
 
 // Close - close the object
@@ -433,6 +669,7 @@ func (d *DNSFilter) Close() {
 		d.done <- struct{}{}
 	}
 
+	d.cancelShutdown()
 	d.reset()
 }
 
@@ -448,25 +685,52 @@ func (d *DNSFilter) reset() {
 			log.Error("filtering: rulesStorageAllow.Close: %s", err)
 		}
 	}
+
+	d.resetUserRules()
+}
+
+// resetUserRules closes the storage of the user rules, if any.  d.engineLock is
+// expected to be locked.
+func (d *DNSFilter) resetUserRules() {
+	if d.userRulesStorage != nil {
+		if err := d.userRulesStorage.Close(); err != nil {
+			log.Error("filtering: userRulesStorage.Close: %s", err)
+		}
+	}
 }
 
 // ProtectionStatus returns the status of protection and time until it's
-// disabled if so.
+// disabled if so.  While the protection schedule is active, the protection is
+// disabled and disabledUntil is nil.
 func (d *DNSFilter) ProtectionStatus() (status bool, disabledUntil *time.Time) {
 	d.confMu.RLock()
 	defer d.confMu.RUnlock()
 
-	return d.conf.ProtectionEnabled, d.conf.ProtectionDisabledUntil
+	if d.isProtectionScheduledOff() {
+		return false, nil
+	} else if d.protectionPausedUntil != nil {
+		return false, d.protectionPausedUntil
+	}
+
+	return d.conf.ProtectionEnabled, nil
 }
 
 // SetProtectionStatus updates the status of protection and time until it's
-// disabled.
+// disabled.  If status is false and disabledUntil is not nil, the protection is
+// paused until then, but stays enabled in the configuration.
 func (d *DNSFilter) SetProtectionStatus(status bool, disabledUntil *time.Time) {
 	d.confMu.Lock()
 	defer d.confMu.Unlock()
 
+	if !status && disabledUntil != nil {
+		d.conf.ProtectionEnabled = true
+		d.protectionPausedUntil = disabledUntil
+
+		return
+	}
+
 	d.conf.ProtectionEnabled = status
-	d.conf.ProtectionDisabledUntil = disabledUntil
+	d.protectionPausedUntil = nil
 }
 
 // SetProtectionEnabled updates the status of protection.
@@ -475,6 +739,7 @@ func (d *DNSFilter) SetProtectionEnabled(status bool) {
 	defer d.confMu.Unlock()
 
 	d.conf.ProtectionEnabled = status
+	d.protectionPausedUntil = nil
 }
 
 // SetBlockingMode sets blocking mode properties.
@@ -497,6 +762,50 @@ func (d *DNSFilter) BlockingMode() (mode BlockingMode, bIPv4, bIPv6 netip.Addr)
 	return d.conf.BlockingMode, d.conf.BlockingIPv4, d.conf.BlockingIPv6
 }
 
+// SetAAAABlockingMode sets the blocking mode for AAAA requests.
+func (d *DNSFilter) SetAAAABlockingMode(mode AAAABlockingMode) {
+	d.confMu.Lock()
+	defer d.confMu.Unlock()
+
+	d.conf.AAAABlockingMode = mode
+}
+
+// AAAABlockingMode returns the blocking mode for AAAA requests.  It returns
+// [AAAABlockingModeDefault] if the mode isn't set.
+func (d *DNSFilter) AAAABlockingMode() (mode AAAABlockingMode) {
+	d.confMu.RLock()
+	defer d.confMu.RUnlock()
+
+	if d.conf.AAAABlockingMode == "" {
+		return AAAABlockingModeDefault
+	}
+
+	return d.conf.AAAABlockingMode
+}
+
+// SetSafeBrowsingBlockingMode sets the blocking mode for the requests blocked
+// by safe-browsing and parental control.
+func (d *DNSFilter) SetSafeBrowsingBlockingMode(mode SafeBrowsingBlockingMode) {
+	d.confMu.Lock()
+	defer d.confMu.Unlock()
+
+	d.conf.SafeBrowsingBlockingMode = mode
+}
+
+// SafeBrowsingBlockingMode returns the blocking mode for the requests blocked
+// by safe-browsing and parental control.  It returns
+// [SafeBrowsingBlockingModeBlockHost] if the mode isn't set.
+func (d *DNSFilter) SafeBrowsingBlockingMode() (mode SafeBrowsingBlockingMode) {
+	d.confMu.RLock()
+	defer d.confMu.RUnlock()
+
+	if d.conf.SafeBrowsingBlockingMode == "" {
+		return SafeBrowsingBlockingModeBlockHost
+	}
+
+	return d.conf.SafeBrowsingBlockingMode
+}
+
 // SetBlockedResponseTTL sets TTL for blocked responses.
 func (d *DNSFilter) SetBlockedResponseTTL(ttl uint32) {
 	d.confMu.Lock()
@@ -513,6 +822,39 @@ func (d *DNSFilter) BlockedResponseTTL() (ttl uint32) {
 	return d.conf.BlockedResponseTTL
 }
 
+// ListBlockedResponseTTL returns the smallest blocked-response TTL of the
+// blocking filter lists which the rules of res belong to.  ok is false if none
+// of these lists has the TTL set, in which case the global
+// [DNSFilter.BlockedResponseTTL] should be used.
+func (d *DNSFilter) ListBlockedResponseTTL(res *Result) (ttl uint32, ok bool) {
+	if res == nil || len(res.Rules) == 0 {
+		return 0, false
+	}
+
+	d.conf.filtersMu.RLock()
+	defer d.conf.filtersMu.RUnlock()
+
+	for _, r := range res.Rules {
+		i := slices.IndexFunc(d.conf.Filters, func(f FilterYAML) (found bool) {
+			return f.ID == r.FilterListID
+		})
+		if i == -1 {
+			continue
+		}
+
+		listTTL := d.conf.Filters[i].BlockedResponseTTL
+		if listTTL == 0 {
+			continue
+		}
+
+		if !ok || listTTL < ttl {
+			ttl, ok = listTTL, true
+		}
+	}
+
+	return ttl, ok
+}
+
 // SafeBrowsingBlockHost returns a host for safe browsing blocked responses.
 func (d *DNSFilter) SafeBrowsingBlockHost() (host string) {
 	return d.conf.SafeBrowsingBlockHost
@@ -559,6 +901,12 @@ type Result struct {
 	// Rewritten.
 	IPList []netip.Addr `json:",omitempty"`
 
+	// RewriteRRs are the records of the lookup rewrite result with the types
+	// other than A, AAAA, and CNAME, such as SRV.  Only the types are set in
+	// their headers.  It is empty unless Reason is set to Rewritten.  They
+	// aren't stored in the query log.
+	RewriteRRs []dns.RR `json:"-"`
+
 	// Rules are applied rules.  If Rules are not empty, each rule is not nil.
 	Rules []*ResultRule `json:",omitempty"`
 
@@ -569,6 +917,11 @@ type Result struct {
 	//
 	// TODO(d.kolyshev): Get rid of this flag.
 	IsFiltered bool `json:",omitempty"`
+
+	// DecisionDetail describes the blocking rule that has matched the request
+	// but has been overridden.  It is nil unless [Config.DecisionDetails] is
+	// true and the request hasn't been blocked.
+	DecisionDetail *DecisionDetail `json:",omitempty"`
 }
 
 // Matched returns true if any match at all was found regardless of
@@ -583,7 +936,8 @@ func (d *DNSFilter) CheckHostRules(host string, rrtype uint16, setts *Settings)
 }
 
 // CheckHost tries to match the host against filtering rules, then safebrowsing
-// and parental control rules, if they are enabled.
+// and parental control rules, if they are enabled.  No rules are checked while
+// the protection schedule is active.
 func (d *DNSFilter) CheckHost(
 	host string,
 	qtype uint16,
@@ -597,6 +951,15 @@ func (d *DNSFilter) CheckHost(
 
 	host = strings.ToLower(host)
 
+	if d.protectionScheduledOff() {
+		// Don't check the rules, but report the one that would have blocked
+		// the request.
+		offSetts := *setts
+		offSetts.ProtectionEnabled = false
+
+		return d.withDecisionDetail(host, qtype, &offSetts, Result{}), nil
+	}
+
 	if setts.FilteringEnabled {
 		res = d.processRewrites(host, qtype)
 		if res.Reason == Rewritten {
@@ -611,11 +974,11 @@ func (d *DNSFilter) CheckHost(
 		}
 
 		if res.Reason.Matched() {
-			return res, nil
+			return d.withDecisionDetail(host, qtype, setts, res), nil
 		}
 	}
 
-	return Result{}, nil
+	return d.withDecisionDetail(host, qtype, setts, Result{}), nil
 }
 
 // processRewrites performs filtering based on the legacy rewrite records.
@@ -627,12 +990,22 @@ func (d *DNSFilter) CheckHost(
 // Secondly, it finds A or AAAA rewrites for host and, if found, sets res.IPList
 // accordingly.  If the found rewrite has a special value of "A" or "AAAA", the
 // result is an exception.
+//
+// If there are no rewrites for host, but it's a subdomain of a rewritten domain
+// with local authority, the result is a rewrite with no answers, so that the
+// request isn't forwarded upstream.
 func (d *DNSFilter) processRewrites(host string, qtype uint16) (res Result) {
 	d.confMu.RLock()
 	defer d.confMu.RUnlock()
 
 	rewrites, matched := findRewrites(d.conf.Rewrites, host, qtype)
 	if !matched {
+		if isUnderLocalAuthority(d.conf.Rewrites, host, d.conf.RewritesLocalAuthority) {
+			log.Debug("rewrite: %s is under local authority", host)
+
+			return Result{Reason: Rewritten}
+		}
+
 		return Result{}
 	}
 
@@ -691,34 +1064,44 @@ func matchBlockedServicesRules(
 		return Result{}, nil
 	}
 
-	svcs := setts.ServicesRules
-	if len(svcs) == 0 {
+	svcName, rule := matchServices(host, setts.ServicesRules)
+	if rule == nil {
 		return Result{}, nil
 	}
 
+	res.Reason = FilteredBlockedService
+	res.IsFiltered = true
+	res.ServiceName = svcName
+
+	ruleText := rule.Text()
+	res.Rules = []*ResultRule{{
+		FilterListID: rule.GetFilterListID(),
+		Text:         ruleText,
+	}}
+
+	log.Debug("blocked services: matched rule: %s  host: %s  service: %s",
+		ruleText, host, svcName)
+
+	return res, nil
+}
+
+// matchServices returns the name of the first service from svcs with a rule
+// matching host and the rule itself.  rule is nil if there is no such service.
+func matchServices(host string, svcs []ServiceEntry) (svcName string, rule *rules.NetworkRule) {
+	if len(svcs) == 0 {
+		return "", nil
+	}
+
 	req := rules.NewRequestForHostname(host)
 	for _, s := range svcs {
-		for _, rule := range s.Rules {
-			if rule.Match(req) {
-				res.Reason = FilteredBlockedService
-				res.IsFiltered = true
-				res.ServiceName = s.Name
-
-				ruleText := rule.Text()
-				res.Rules = []*ResultRule{{
-					FilterListID: rule.GetFilterListID(),
-					Text:         ruleText,
-				}}
-
-				log.Debug("blocked services: matched rule: %s  host: %s  service: %s",
-					ruleText, host, s.Name)
-
-				return res, nil
+		for _, r := range s.Rules {
+			if r.Match(req) {
+				return s.Name, r
 			}
 		}
 	}
 
-	return res, nil
+	return "", nil
 }
 
 //
@@ -775,13 +1158,37 @@ func newRuleStorage(filters []Filter) (rs *filterlist.RuleStorage, err error) {
 }
 
 // Initialize urlfilter objects.
-func (d *DNSFilter) initFiltering(allowFilters, blockFilters []Filter) (err error) {
-	rulesStorage, err := newRuleStorage(blockFilters)
+func (d *DNSFilter) initFiltering(p *filtersInitializerParams) (err error) {
+	var userStorage *filterlist.RuleStorage
+	var userEngine *urlfilter.DNSEngine
+	if p.userFilter != nil {
+		userStorage, err = newRuleStorage([]Filter{*p.userFilter})
+		if err != nil {
+			return fmt.Errorf("user rules: %w", err)
+		}
+
+		userEngine = urlfilter.NewDNSEngine(userStorage)
+	}
+
+	if p.userOnly {
+		d.engineLock.Lock()
+		defer d.engineLock.Unlock()
+
+		d.resetUserRules()
+		d.userRulesStorage = userStorage
+		d.userEngine = userEngine
+
+		log.Debug("filtering: initialized user rules engine")
+
+		return nil
+	}
+
+	rulesStorage, err := newRuleStorage(p.blockFilters)
 	if err != nil {
 		return err
 	}
 
-	rulesStorageAllow, err := newRuleStorage(allowFilters)
+	rulesStorageAllow, err := newRuleStorage(p.allowFilters)
 	if err != nil {
 		return err
 	}
@@ -798,6 +1205,8 @@ func (d *DNSFilter) initFiltering(allowFilters, blockFilters []Filter) (err erro
 		d.filteringEngine = filteringEngine
 		d.rulesStorageAllow = rulesStorageAllow
 		d.filteringEngineAllow = filteringEngineAllow
+		d.userRulesStorage = userStorage
+		d.userEngine = userEngine
 	}()
 
 	// Make sure that the OS reclaims memory as soon as possible.
@@ -911,14 +1320,7 @@ func (d *DNSFilter) matchHost(
 		return Result{}, nil
 	}
 
-	ufReq := &urlfilter.DNSRequest{
-		Hostname:         host,
-		SortedClientTags: setts.ClientTags,
-		// TODO(e.burkov): Wait for urlfilter update to pass net.IP.
-		ClientIP:   setts.ClientIP,
-		ClientName: setts.ClientName,
-		DNSType:    rrtype,
-	}
+	ufReq := newDNSRequest(host, rrtype, setts)
 
 	d.engineLock.RLock()
 	// Keep in mind that this lock must be held no just when calling Match() but
@@ -938,10 +1340,10 @@ func (d *DNSFilter) matchHost(
 		return Result{}, nil
 	}
 
-	dnsres, matchedEngine := d.filteringEngine.MatchRequest(ufReq)
+	dnsres, rewrites, matchedEngine := d.matchBlockEngines(ufReq)
 
 	// Check DNS rewrites first, because the API there is a bit awkward.
-	dnsRWRes := d.processDNSResultRewrites(dnsres, host)
+	dnsRWRes := d.processDNSResultRewrites(rewrites, host)
 	if dnsRWRes.Reason != NotFilteredNotFound {
 		return dnsRWRes, nil
 	} else if !matchedEngine {
@@ -966,6 +1368,107 @@ func (d *DNSFilter) matchHost(
 	return res, nil
 }
 
+// matchDefaultDeny returns a blocked result for any host if the default-deny
+// mode is enabled.  It must only be called after the filtering rules, so that
+// the hosts matched by the allowlist rules have already been returned.
+func matchDefaultDeny(host string, _ uint16, setts *Settings) (res Result, err error) {
+	if !setts.DefaultDeny || !setts.ProtectionEnabled || !setts.FilteringEnabled {
+		return Result{}, nil
+	}
+
+	log.Debug("filtering: host %q is not allowlisted in default-deny mode", host)
+
+	return Result{
+		Reason:     FilteredBlockList,
+		IsFiltered: true,
+	}, nil
+}
+
+// newDNSRequest returns a new request for matching host against the filtering
+// engines.
+func newDNSRequest(host string, rrtype uint16, setts *Settings) (ufReq *urlfilter.DNSRequest) {
+	return &urlfilter.DNSRequest{
+		Hostname:         host,
+		SortedClientTags: setts.ClientTags,
+		// TODO(e.burkov): Wait for urlfilter update to pass net.IP.
+		ClientIP:   setts.ClientIP,
+		ClientName: setts.ClientName,
+		DNSType:    rrtype,
+	}
+}
+
+// matchBlockEngines matches ufReq against the engine of the filter lists and
+// the engine of the user rules, if there is one.  rewrites are the $dnsrewrite
+// rules with the exceptions applied.  d.engineLock is expected to be locked.
+func (d *DNSFilter) matchBlockEngines(
+	ufReq *urlfilter.DNSRequest,
+) (dnsres *urlfilter.DNSResult, rewrites []*rules.NetworkRule, matched bool) {
+	dnsres, matched = d.filteringEngine.MatchRequest(ufReq)
+	if d.userEngine == nil {
+		return dnsres, dnsres.DNSRewrites(), matched
+	}
+
+	userRes, _ := d.userEngine.MatchRequest(ufReq)
+
+	return combineDNSResults(userRes, dnsres)
+}
+
+// combineDNSResults combines the results of matching the same request against
+// the engine of the user rules and the engine of the filter lists the same way
+// a single engine with all the rules does.  That is, the network rule with the
+// highest priority is chosen, the user rules winning the ties since they come
+// first, and the host rules are only used if there are no network rules.
+//
+// The rules affecting the rules of other lists, such as $badfilter ones, are
+// only handled within the same engine, so the user rules and the lists
+// containing such rules must not be matched separately, see
+// [userRulesNeedMerge].
+func combineDNSResults(
+	userRes *urlfilter.DNSResult,
+	listRes *urlfilter.DNSResult,
+) (dnsres *urlfilter.DNSResult, rewrites []*rules.NetworkRule, matched bool) {
+	if userRes == nil {
+		userRes = &urlfilter.DNSResult{}
+	}
+
+	if listRes == nil {
+		listRes = &urlfilter.DNSResult{}
+	}
+
+	rewrites = slices.Concat(userRes.DNSRewrites(), listRes.DNSRewrites())
+
+	nr := userRes.NetworkRule
+	if lr := listRes.NetworkRule; lr != nil && (nr == nil || lr.IsHigherPriority(nr)) {
+		nr = lr
+	}
+
+	if nr != nil {
+		return &urlfilter.DNSResult{NetworkRule: nr}, rewrites, true
+	}
+
+	dnsres = &urlfilter.DNSResult{
+		HostRulesV4: slices.Concat(userRes.HostRulesV4, listRes.HostRulesV4),
+		HostRulesV6: slices.Concat(userRes.HostRulesV6, listRes.HostRulesV6),
+	}
+
+	return dnsres, rewrites, len(dnsres.HostRulesV4) > 0 || len(dnsres.HostRulesV6) > 0
+}
+
+// userRulesNeedMerge returns true if the user rules must be matched by the same
+// engine as the filter lists.  That is the case if either userRules or the
+// enabled blocklists, as reported by listsAffecting, contain rules that affect
+// the rules of other lists, such as $badfilter rules and $dnsrewrite
+// exceptions.
+func userRulesNeedMerge(userRules []string, listsAffecting bool) (ok bool) {
+	if listsAffecting {
+		return true
+	}
+
+	return slices.ContainsFunc(userRules, func(r string) (affects bool) {
+		return rulelist.AffectsOtherRules([]byte(strings.TrimSpace(r)))
+	})
+}
+
 // makeResult returns a properly constructed Result.
 func makeResult(matchedRules []rules.Rule, reason Reason) (res Result) {
 	resRules := make([]*ResultRule, len(matchedRules))
@@ -999,8 +1502,11 @@ func New(c *Config, blockFilters []Filter) (d *DNSFilter, err error) {
 		safeBrowsingChecker:    c.SafeBrowsingChecker,
 		parentalControlChecker: c.ParentalControlChecker,
 		confMu:                 &sync.RWMutex{},
+		now:                    time.Now,
 	}
 
+	d.shutdownCtx, d.cancelShutdown = context.WithCancel(context.Background())
+
 	for i, p := range c.SafeFSPatterns {
 		// Use Match to validate the patterns here.
 		_, err = filepath.Match(p, "test")
@@ -1014,9 +1520,21 @@ func New(c *Config, blockFilters []Filter) (d *DNSFilter, err error) {
 	d.hostCheckers = []hostChecker{{
 		check: d.matchSysHosts,
 		name:  "hosts container",
+	}, {
+		check: d.matchConnectivityCheck,
+		name:  "connectivity check",
+	}, {
+		check: d.matchClientRules,
+		name:  "client rules",
+	}, {
+		check: d.matchBlockedSuffixes,
+		name:  "blocked suffixes",
 	}, {
 		check: d.matchHost,
 		name:  "filtering",
+	}, {
+		check: matchDefaultDeny,
+		name:  "default deny",
 	}, {
 		check: matchBlockedServicesRules,
 		name:  "blocked services",
@@ -1048,8 +1566,52 @@ func New(c *Config, blockFilters []Filter) (d *DNSFilter, err error) {
 		}
 	}
 
+	err = d.setConnectivityCheck(d.conf.ConnectivityCheck)
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return nil, err
+	}
+
+	d.blockedSuffixes, err = NewSuffixSet(d.conf.BlockedSuffixes)
+	if err != nil {
+		return nil, fmt.Errorf("blocked_suffixes: %w", err)
+	}
+
+	d.webhook, err = newWebhookNotifier(
+		d.conf.HTTPClient,
+		d.conf.WebhookURL,
+		d.conf.WebhookSecret,
+		d.conf.WebhookEvents,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("webhook_url: %w", err)
+	}
+
+	d.suggester, err = newListSuggester(
+		d.conf.HTTPClient,
+		d.conf.QueryLog,
+		d.conf.SuggestCatalogURL,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("suggest_catalog_url: %w", err)
+	}
+
+	d.catalog, err = newFilterCatalog(d.conf.HTTPClient, d.conf.CatalogIndexURL)
+	if err != nil {
+		return nil, fmt.Errorf("catalog_index_url: %w", err)
+	}
+
+	d.servicesUpdater, err = newServicesUpdater(
+		d.conf.HTTPClient,
+		d.conf.BlockedServicesIndexURL,
+		d.conf.DataDir,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("blocked_services_index_url: %w", err)
+	}
+
 	if blockFilters != nil {
-		err = d.initFiltering(nil, blockFilters)
+		err = d.initFiltering(&filtersInitializerParams{blockFilters: blockFilters})
 		if err != nil {
 			d.Close()
 
@@ -1078,7 +1640,7 @@ func New(c *Config, blockFilters []Filter) (d *DNSFilter, err error) {
 
 // Start registers web handlers and starts filters updates loop.
 func (d *DNSFilter) Start() {
-	d.filtersInitializerChan = make(chan filtersInitializerParams, 1)
+	d.filtersInitializerChan = make(chan *filtersInitializerParams, 1)
 	d.done = make(chan struct{}, 1)
 
 	d.RegisterFilteringHandlers()
@@ -1086,17 +1648,39 @@ func (d *DNSFilter) Start() {
 	go d.updatesLoop()
 }
 
-// updatesLoop initializes new filters and checks for filters updates in a loop.
+// updatesLoop initializes new filters, checks for filters updates, and reports
+// the transitions of the protection schedule in a loop.
 func (d *DNSFilter) updatesLoop() {
 	defer log.OnPanic("filtering: updates loop")
 
 	ivl := time.Second * 5
 	t := time.NewTimer(ivl)
 
+	// Receiving from a nil channel blocks forever, so the schedule transitions
+	// are never reported if there are none.
+	var schedCh <-chan time.Time
+	var schedTimer *time.Timer
+	sched := d.newProtectionScheduler()
+	if sched != nil {
+		sched.logStatus()
+
+		if schedIvl, ok := sched.next(); ok {
+			schedTimer = time.NewTimer(schedIvl)
+			defer schedTimer.Stop()
+
+			schedCh = schedTimer.C
+		}
+	}
+
 	for {
 		select {
+		case <-schedCh:
+			sched.logStatus()
+
+			schedIvl, _ := sched.next()
+			schedTimer.Reset(schedIvl)
 		case params := <-d.filtersInitializerChan:
-			err := d.initFiltering(params.allowFilters, params.blockFilters)
+			err := d.initFiltering(params)
 			if err != nil {
 				log.Error("filtering: initializing: %s", err)
 
@@ -1116,17 +1700,25 @@ func (d *DNSFilter) updatesLoop() {
 // periodicallyRefreshFilters checks for filters updates and returns time
 // interval for the next update.
 func (d *DNSFilter) periodicallyRefreshFilters(ivl time.Duration) (nextIvl time.Duration) {
-	const maxInterval = time.Hour
+	const (
+		minInterval = time.Minute
+		maxInterval = time.Hour
+	)
 
-	if d.conf.FiltersUpdateIntervalHours == 0 {
-		return ivl
-	}
+	_, isNetErr, ok := d.tryRefreshFilters(d.shutdownCtx, true, true, false)
 
-	isNetErr, ok := false, false
-	_, isNetErr, ok = d.tryRefreshFilters(true, true, false)
+	d.conf.filtersMu.RLock()
+	catalogIvl := time.Duration(d.conf.FiltersUpdateIntervalHours) * time.Hour
+	d.conf.filtersMu.RUnlock()
+
+	// TODO(e.burkov):  Pass context.
+	d.catalog.refresh(context.TODO(), time.Now(), catalogIvl)
+	d.servicesUpdater.refresh(context.TODO(), time.Now(), catalogIvl)
 
 	if ok && !isNetErr {
-		ivl = maxInterval
+		// Wake up when the next list should be updated, since the lists may
+		// have different update intervals.
+		ivl = max(d.nextRefreshIvl(time.Now(), maxInterval), minInterval)
 	} else if isNetErr {
 		ivl *= 2
 		ivl = max(ivl, maxInterval)
@@ -1166,6 +1758,8 @@ func (d *DNSFilter) checkSafeBrowsing(
 		return Result{}, err
 	}
 
+	d.notifyBlocked(host, res.Reason, setts)
+
 	return res, nil
 }
 
@@ -1198,5 +1792,7 @@ func (d *DNSFilter) checkParental(
 		return Result{}, err
 	}
 
+	d.notifyBlocked(host, res.Reason, setts)
+
 	return res, nil
 }
@@ -3,11 +3,14 @@ package filtering
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"net/netip"
+	"strings"
 	"testing"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering/hashprefix"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering/rulelist"
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/AdguardTeam/golibs/netutil"
 	"github.com/AdguardTeam/golibs/testutil"
@@ -548,7 +551,10 @@ func TestWhitelist(t *testing.T) {
 	}}
 	d, setts := newForTest(t, nil, filters)
 
-	err := d.setFilters(filters, whiteFilters, false)
+	err := d.setFilters(&filtersInitializerParams{
+		allowFilters: whiteFilters,
+		blockFilters: filters,
+	}, false)
 	require.NoError(t, err)
 
 	t.Cleanup(d.Close)
@@ -576,6 +582,85 @@ func TestWhitelist(t *testing.T) {
 	assert.Equal(t, "||host2^", res.Rules[0].Text)
 }
 
+func TestDNSFilter_CheckHost_defaultDeny(t *testing.T) {
+	filters := []Filter{{
+		ID: 0, Data: []byte("@@||allowed-by-rule.example\n"),
+	}}
+	allowFilters := []Filter{{
+		ID: 1, Data: []byte("||allowed.example^\n"),
+	}}
+
+	d, setts := newForTest(t, &Config{
+		Rewrites: []*LegacyRewrite{{
+			Domain: "rewritten.example",
+			Answer: "192.0.2.1",
+		}},
+	}, filters)
+	t.Cleanup(d.Close)
+
+	err := d.setFilters(&filtersInitializerParams{
+		allowFilters: allowFilters,
+		blockFilters: filters,
+	}, false)
+	require.NoError(t, err)
+
+	setts.DefaultDeny = true
+
+	testCases := []struct {
+		name           string
+		host           string
+		wantReason     Reason
+		wantIsFiltered bool
+	}{{
+		name:           "allowlist",
+		host:           "allowed.example",
+		wantReason:     NotFilteredAllowList,
+		wantIsFiltered: false,
+	}, {
+		name:           "exception_rule",
+		host:           "allowed-by-rule.example",
+		wantReason:     NotFilteredAllowList,
+		wantIsFiltered: false,
+	}, {
+		name:           "rewrite",
+		host:           "rewritten.example",
+		wantReason:     Rewritten,
+		wantIsFiltered: false,
+	}, {
+		name:           "random",
+		host:           "random.example",
+		wantReason:     FilteredBlockList,
+		wantIsFiltered: true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, checkErr := d.CheckHost(tc.host, dns.TypeA, setts)
+			require.NoError(t, checkErr)
+
+			assert.Equal(t, tc.wantReason, res.Reason)
+			assert.Equal(t, tc.wantIsFiltered, res.IsFiltered)
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		setts.DefaultDeny = false
+		t.Cleanup(func() { setts.DefaultDeny = true })
+
+		res, checkErr := d.CheckHost("random.example", dns.TypeA, setts)
+		require.NoError(t, checkErr)
+
+		assert.False(t, res.IsFiltered)
+	})
+
+	t.Run("response_check", func(t *testing.T) {
+		res, checkErr := d.CheckHostRules("random.example", dns.TypeA, setts)
+		require.NoError(t, checkErr)
+
+		assert.False(t, res.IsFiltered)
+	})
+}
+
 // Client Settings.
 
 func applyClientSettings(setts *Settings) {
@@ -663,6 +748,243 @@ func TestClientSettings(t *testing.T) {
 
 // Benchmarks.
 
+// testUserRules are the user rules for testing the engine of the user rules.
+var testUserRules = []string{
+	"||user-block.example^",
+	"@@||list-block.example^",
+	"||user-important.example^$important",
+	"||list-allow.example^",
+	"@@||list-important.example^",
+	"||list-important-allow.example^$important",
+	"0.0.0.1 hosts.example",
+	"||user-rewrite.example^$dnsrewrite=192.0.2.1",
+	"||list-rewrite.example^",
+	"||client.example^$client=192.0.2.10",
+	"||aaaa.example^$dnstype=AAAA",
+	"@@||aaaa-list.example^$dnstype=AAAA",
+}
+
+// testListRules are the filter list rules for testing the engine of the user
+// rules.
+const testListRules = `||list-block.example^
+@@||user-important.example^
+@@||list-allow.example^
+||list-important.example^$important
+@@||list-important-allow.example^$important
+0.0.0.2 hosts.example
+::2 hosts.example
+||list-rewrite.example^$dnsrewrite=NOERROR;CNAME;target.example
+||user-rewrite.example^
+@@||client.example^$client=192.0.2.20
+||aaaa-list.example^
+`
+
+// testAffectingListRules are the filter list rules, which affect the user
+// rules, for testing the engine of the user rules.
+const testAffectingListRules = testListRules + `||user-block.example^$badfilter
+@@||user-rewrite.example^$dnsrewrite
+||list-only.example^
+||list-only.example^$badfilter
+`
+
+func TestDNSFilter_userEngine(t *testing.T) {
+	testCases := []struct {
+		name       string
+		listRules  string
+		wantMerged bool
+	}{{
+		name:       "separate",
+		listRules:  testListRules,
+		wantMerged: false,
+	}, {
+		name:       "list_affects_user_rules",
+		listRules:  testAffectingListRules,
+		wantMerged: true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testUserEngineEquivalence(t, tc.listRules, tc.wantMerged)
+		})
+	}
+}
+
+// testUserEngineEquivalence checks that the filter with the engine of the user
+// rules chosen the same way [DNSFilter.EnableFilters] does returns the same
+// results as the filter with the user rules and listRules in the same engine.
+func testUserEngineEquivalence(t *testing.T, listRules string, wantMerged bool) {
+	t.Helper()
+
+	userData := []byte(strings.Join(testUserRules, "\n"))
+	listFilter := Filter{ID: 1, Data: []byte(listRules)}
+	userFilter := &Filter{
+		ID:   rulelist.URLFilterIDCustom,
+		Data: userData,
+	}
+
+	// merged is the filter with the user rules and the list rules in the same
+	// engine, which is the reference.
+	merged, _ := newForTest(t, nil, []Filter{*userFilter, listFilter})
+	t.Cleanup(merged.Close)
+
+	res, err := rulelist.NewParser().Parse(io.Discard, strings.NewReader(listRules), nil)
+	require.NoError(t, err)
+
+	p := &filtersInitializerParams{
+		blockFilters: []Filter{listFilter},
+		userFilter:   userFilter,
+	}
+
+	needMerge := userRulesNeedMerge(testUserRules, res.HasAffectingRules)
+	require.Equal(t, wantMerged, needMerge)
+
+	if needMerge {
+		p.blockFilters, p.userFilter = []Filter{*userFilter, listFilter}, nil
+	}
+
+	separate, _ := newForTest(t, nil, nil)
+	t.Cleanup(separate.Close)
+
+	err = separate.setFilters(p, false)
+	require.NoError(t, err)
+	require.Equal(t, wantMerged, separate.userEngine == nil)
+
+	hosts := []string{
+		"user-block.example",
+		"sub.user-block.example",
+		"list-block.example",
+		"user-important.example",
+		"list-allow.example",
+		"list-important.example",
+		"list-important-allow.example",
+		"list-only.example",
+		"hosts.example",
+		"user-rewrite.example",
+		"list-rewrite.example",
+		"client.example",
+		"aaaa.example",
+		"aaaa-list.example",
+		"unknown.example",
+	}
+
+	qtypes := []uint16{dns.TypeA, dns.TypeAAAA, dns.TypeHTTPS}
+	clientIPs := []netip.Addr{
+		{},
+		netip.MustParseAddr("192.0.2.10"),
+		netip.MustParseAddr("192.0.2.20"),
+	}
+
+	for _, host := range hosts {
+		for _, qt := range qtypes {
+			for _, ip := range clientIPs {
+				setts := &Settings{
+					ClientIP:          ip,
+					ProtectionEnabled: true,
+					FilteringEnabled:  true,
+				}
+
+				want, wantErr := merged.CheckHost(host, qt, setts)
+				got, gotErr := separate.CheckHost(host, qt, setts)
+
+				name := fmt.Sprintf("%s_%s_%s", host, dns.Type(qt), ip)
+				assert.Equal(t, wantErr, gotErr, name)
+				assert.Equal(t, want, got, name)
+			}
+		}
+	}
+}
+
+func TestDNSFilter_setFilters_userOnly(t *testing.T) {
+	d, setts := newForTest(t, nil, nil)
+	t.Cleanup(d.Close)
+
+	listFilter := Filter{ID: 1, Data: []byte("||list.example^\n")}
+	err := d.setFilters(&filtersInitializerParams{
+		blockFilters: []Filter{listFilter},
+		userFilter:   &Filter{ID: rulelist.URLFilterIDCustom},
+	}, false)
+	require.NoError(t, err)
+
+	listEngine := d.filteringEngine
+
+	err = d.setFilters(&filtersInitializerParams{
+		userFilter: &Filter{
+			ID:   rulelist.URLFilterIDCustom,
+			Data: []byte("||user.example^\n"),
+		},
+		userOnly: true,
+	}, false)
+	require.NoError(t, err)
+
+	// The engine of the lists must not be rebuilt.
+	assert.Same(t, listEngine, d.filteringEngine)
+
+	for _, host := range []string{"list.example", "user.example"} {
+		res, checkErr := d.CheckHost(host, dns.TypeA, setts)
+		require.NoError(t, checkErr)
+
+		assert.True(t, res.IsFiltered, host)
+	}
+
+	t.Run("merged", func(t *testing.T) {
+		err = d.setFilters(&filtersInitializerParams{
+			blockFilters: []Filter{listFilter},
+		}, false)
+		require.NoError(t, err)
+
+		err = d.setFilters(&filtersInitializerParams{
+			userFilter: &Filter{ID: rulelist.URLFilterIDCustom},
+			userOnly:   true,
+		}, false)
+		assert.ErrorIs(t, err, errUserRulesMerged)
+	})
+}
+
+func TestUserRulesNeedMerge(t *testing.T) {
+	testCases := []struct {
+		name           string
+		rules          []string
+		listsAffecting bool
+		want           bool
+	}{{
+		name:           "empty",
+		rules:          nil,
+		listsAffecting: false,
+		want:           false,
+	}, {
+		name:           "plain",
+		rules:          []string{"||example.org^", "@@||example.com^$important"},
+		listsAffecting: false,
+		want:           false,
+	}, {
+		name:           "rewrite",
+		rules:          []string{"||example.org^$dnsrewrite=192.0.2.1"},
+		listsAffecting: false,
+		want:           false,
+	}, {
+		name:           "badfilter",
+		rules:          []string{"||example.org^", "||example.com^$badfilter"},
+		listsAffecting: false,
+		want:           true,
+	}, {
+		name:           "rewrite_exception",
+		rules:          []string{"  @@||example.org^$dnsrewrite"},
+		listsAffecting: false,
+		want:           true,
+	}, {
+		name:           "lists_affecting",
+		rules:          []string{"||example.org^"},
+		listsAffecting: true,
+		want:           true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, userRulesNeedMerge(tc.rules, tc.listsAffecting))
+		})
+	}
+}
+
 func BenchmarkSafeBrowsing(b *testing.B) {
 	d, setts := newForTest(b, &Config{
 		SafeBrowsingEnabled: true,
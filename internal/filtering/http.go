@@ -9,6 +9,7 @@ import (
 	"os"
 	"path/filepath"
 	"slices"
+	"strings"
 	"sync"
 	"time"
 
@@ -58,6 +59,18 @@ type filterAddJSON struct {
 	Name      string `json:"name"`
 	URL       string `json:"url"`
 	Whitelist bool   `json:"whitelist"`
+
+	// BlockedResponseTTL is the upper bound of the TTL for responses blocked
+	// by the list.  0 means that only the global TTL is used.
+	BlockedResponseTTL uint32 `json:"blocked_response_ttl"`
+
+	// UpdateIntervalHours is the interval between the updates of the list in
+	// hours.  0 means that the global interval is used.
+	UpdateIntervalHours uint32 `json:"update_interval_hours"`
+
+	// Category is the category of the list.  If empty,
+	// [DefaultFilterCategory] is used.
+	Category string `json:"category"`
 }
 
 func (d *DNSFilter) handleFilteringAddURL(w http.ResponseWriter, r *http.Request) {
@@ -76,6 +89,26 @@ func (d *DNSFilter) handleFilteringAddURL(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	if !ValidateUpdateIvl(fj.UpdateIntervalHours) {
+		aghhttp.Error(r, w, http.StatusBadRequest, "Unsupported update interval")
+
+		return
+	}
+
+	fj.Category = strings.TrimSpace(fj.Category)
+	if fj.Category == "" {
+		fj.Category = DefaultFilterCategory
+	} else if err = validateFilterCategory(fj.Category); err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
+	// Prefill the name of a well-known list.
+	if cf, ok := d.catalog.byURL(fj.URL); ok && fj.Name == "" {
+		fj.Name = cf.Name
+	}
+
 	// Check for duplicates
 	if d.filterExists(fj.URL) {
 		err = errFilterExists
@@ -93,10 +126,13 @@ func (d *DNSFilter) handleFilteringAddURL(w http.ResponseWriter, r *http.Request
 		Filter: Filter{
 			ID: d.idGen.next(),
 		},
+		BlockedResponseTTL:  fj.BlockedResponseTTL,
+		UpdateIntervalHours: fj.UpdateIntervalHours,
+		Category:            fj.Category,
 	}
 
 	// Download the filter contents
-	ok, err := d.update(&filt)
+	ok, err := d.update(r.Context(), &filt)
 	if err != nil {
 		aghhttp.Error(
 			r,
@@ -134,10 +170,7 @@ func (d *DNSFilter) handleFilteringAddURL(w http.ResponseWriter, r *http.Request
 	d.conf.ConfigModified()
 	d.EnableFilters(true)
 
-	_, err = fmt.Fprintf(w, "OK %d rules\n", filt.RulesCount)
-	if err != nil {
-		aghhttp.Error(r, w, http.StatusInternalServerError, "Couldn't write body: %s", err)
-	}
+	aghhttp.WriteJSONResponseOK(w, r, filterToJSON(filt))
 }
 
 func (d *DNSFilter) handleFilteringRemoveURL(w http.ResponseWriter, r *http.Request) {
@@ -203,6 +236,18 @@ func (d *DNSFilter) handleFilteringRemoveURL(w http.ResponseWriter, r *http.Requ
 }
 
 type filterURLReqData struct {
+	// BlockedResponseTTL is the upper bound of the TTL for responses blocked
+	// by the list.  If nil, the current value is kept.
+	BlockedResponseTTL *uint32 `json:"blocked_response_ttl,omitempty"`
+
+	// UpdateIntervalHours is the interval between the updates of the list in
+	// hours.  If nil, the current value is kept.
+	UpdateIntervalHours *uint32 `json:"update_interval_hours,omitempty"`
+
+	// Category is the category of the list.  If nil, the current value is
+	// kept.
+	Category *string `json:"category,omitempty"`
+
 	Name    string `json:"name"`
 	URL     string `json:"url"`
 	Enabled bool   `json:"enabled"`
@@ -242,7 +287,35 @@ func (d *DNSFilter) handleFilteringSetURL(w http.ResponseWriter, r *http.Request
 		URL:     fj.Data.URL,
 	}
 
-	restart, err := d.filterSetProperties(fj.URL, filt, fj.Whitelist)
+	if ttl := fj.Data.BlockedResponseTTL; ttl != nil {
+		filt.BlockedResponseTTL = *ttl
+	} else {
+		filt.BlockedResponseTTL = d.filterBlockedResponseTTL(fj.URL, fj.Whitelist)
+	}
+
+	if ivl := fj.Data.UpdateIntervalHours; ivl != nil {
+		if !ValidateUpdateIvl(*ivl) {
+			aghhttp.Error(r, w, http.StatusBadRequest, "Unsupported update interval")
+
+			return
+		}
+
+		filt.UpdateIntervalHours = *ivl
+	} else {
+		filt.UpdateIntervalHours = d.filterUpdateIntervalHours(fj.URL, fj.Whitelist)
+	}
+
+	if cat := fj.Data.Category; cat != nil {
+		filt.Category = strings.TrimSpace(*cat)
+		err = validateFilterCategory(filt.Category)
+		if err != nil {
+			aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+			return
+		}
+	}
+
+	restart, err := d.filterSetProperties(r.Context(), fj.URL, filt, fj.Whitelist)
 	if err != nil {
 		aghhttp.Error(r, w, http.StatusBadRequest, err.Error())
 
@@ -275,7 +348,7 @@ func (d *DNSFilter) handleFilteringSetRules(w http.ResponseWriter, r *http.Reque
 
 	d.conf.UserRules = req.Rules
 	d.conf.ConfigModified()
-	d.EnableFilters(true)
+	d.enableUserRules()
 }
 
 func (d *DNSFilter) handleFilteringRefresh(w http.ResponseWriter, r *http.Request) {
@@ -292,11 +365,9 @@ func (d *DNSFilter) handleFilteringRefresh(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	var ok bool
-	resp := struct {
-		Updated int `json:"updated"`
-	}{}
-	resp.Updated, _, ok = d.tryRefreshFilters(!req.White, req.White, true)
+	// Don't use the context of the request, since the webhook notification
+	// is sent after the response.
+	upds, _, ok := d.tryRefreshFilters(d.shutdownCtx, !req.White, req.White, true)
 	if !ok {
 		aghhttp.Error(
 			r,
@@ -308,19 +379,67 @@ func (d *DNSFilter) handleFilteringRefresh(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	resp := struct {
+		Filters []filterJSON `json:"filters"`
+		Updated int          `json:"updated"`
+	}{
+		Filters: d.updatedFiltersJSON(upds),
+		Updated: len(upds),
+	}
+
 	aghhttp.WriteJSONResponseOK(w, r, resp)
 }
 
+// updatedFiltersJSON returns the JSON representations of the filter lists from
+// upds.
+func (d *DNSFilter) updatedFiltersJSON(upds []*filterUpdate) (fjs []filterJSON) {
+	d.conf.filtersMu.RLock()
+	defer d.conf.filtersMu.RUnlock()
+
+	fjs = []filterJSON{}
+	for _, upd := range upds {
+		for _, filters := range [][]FilterYAML{d.conf.Filters, d.conf.WhitelistFilters} {
+			i := slices.IndexFunc(filters, func(f FilterYAML) (ok bool) { return f.ID == upd.ID })
+			if i != -1 {
+				fjs = append(fjs, filterToJSON(filters[i]))
+			}
+		}
+	}
+
+	return fjs
+}
+
+// skippedRuleJSON is the JSON representation of a line of a filter list that
+// couldn't be parsed as a rule.
+type skippedRuleJSON struct {
+	Text   string `json:"text"`
+	Reason string `json:"reason"`
+	Line   int    `json:"line"`
+}
+
 type filterJSON struct {
-	URL         string               `json:"url"`
-	Name        string               `json:"name"`
-	LastUpdated string               `json:"last_updated,omitempty"`
-	ID          rulelist.URLFilterID `json:"id"`
-	RulesCount  uint32               `json:"rules_count"`
-	Enabled     bool                 `json:"enabled"`
+	URL                 string               `json:"url"`
+	Name                string               `json:"name"`
+	LastUpdated         string               `json:"last_updated,omitempty"`
+	SkippedRules        []skippedRuleJSON    `json:"skipped_rules,omitempty"`
+	ID                  rulelist.URLFilterID `json:"id"`
+	RulesCount          uint32               `json:"rules_count"`
+	SkippedRulesCount   uint32               `json:"skipped_rules_count"`
+	BlockedResponseTTL  uint32               `json:"blocked_response_ttl,omitempty"`
+	UpdateIntervalHours uint32               `json:"update_interval_hours,omitempty"`
+	Enabled             bool                 `json:"enabled"`
+	Category            string               `json:"category"`
 }
 
 type filteringConfig struct {
+	// Categories are the blocklists grouped by their categories and sorted by
+	// name within each category.  It's only set in responses.
+	Categories map[string][]filterJSON `json:"categories"`
+
+	// WhitelistCategories are the allowlists grouped by their categories and
+	// sorted by name within each category.  It's only set in responses.
+	WhitelistCategories map[string][]filterJSON `json:"whitelist_categories"`
+
 	Filters          []filterJSON `json:"filters"`
 	WhitelistFilters []filterJSON `json:"whitelist_filters"`
 	UserRules        []string     `json:"user_rules"`
@@ -330,11 +449,23 @@ type filteringConfig struct {
 
 func filterToJSON(f FilterYAML) filterJSON {
 	fj := filterJSON{
-		ID:         f.ID,
-		Enabled:    f.Enabled,
-		URL:        f.URL,
-		Name:       f.Name,
-		RulesCount: uint32(f.RulesCount),
+		ID:                  f.ID,
+		Enabled:             f.Enabled,
+		URL:                 f.URL,
+		Name:                f.Name,
+		RulesCount:          uint32(f.RulesCount),
+		SkippedRulesCount:   uint32(f.SkippedRulesCount),
+		BlockedResponseTTL:  f.BlockedResponseTTL,
+		UpdateIntervalHours: f.UpdateIntervalHours,
+		Category:            f.category(),
+	}
+
+	for _, sr := range f.SkippedRules {
+		fj.SkippedRules = append(fj.SkippedRules, skippedRuleJSON{
+			Text:   sr.Text,
+			Reason: sr.Reason,
+			Line:   sr.Line,
+		})
 	}
 
 	if !f.LastUpdated.IsZero() {
@@ -361,6 +492,9 @@ func (d *DNSFilter) handleFilteringStatus(w http.ResponseWriter, r *http.Request
 	resp.UserRules = d.conf.UserRules
 	d.conf.filtersMu.RUnlock()
 
+	resp.Categories = groupFiltersByCategory(resp.Filters)
+	resp.WhitelistCategories = groupFiltersByCategory(resp.WhitelistFilters)
+
 	aghhttp.WriteJSONResponseOK(w, r, resp)
 }
 
@@ -564,9 +698,16 @@ func (d *DNSFilter) RegisterFilteringHandlers() {
 	registerHTTP(http.MethodPost, "/control/rewrite/add", d.handleRewriteAdd)
 	registerHTTP(http.MethodPut, "/control/rewrite/update", d.handleRewriteUpdate)
 	registerHTTP(http.MethodPost, "/control/rewrite/delete", d.handleRewriteDelete)
+	registerHTTP(http.MethodPost, "/control/rewrite/import", d.handleRewriteImport)
+	registerHTTP(http.MethodGet, "/control/rewrite/export", d.handleRewriteExport)
 
 	registerHTTP(http.MethodGet, "/control/blocked_services/services", d.handleBlockedServicesIDs)
 	registerHTTP(http.MethodGet, "/control/blocked_services/all", d.handleBlockedServicesAll)
+	registerHTTP(
+		http.MethodPost,
+		"/control/blocked_services/refresh",
+		d.handleBlockedServicesRefresh,
+	)
 
 	// Deprecated handlers.
 	registerHTTP(http.MethodGet, "/control/blocked_services/list", d.handleBlockedServicesList)
@@ -583,6 +724,19 @@ func (d *DNSFilter) RegisterFilteringHandlers() {
 	registerHTTP(http.MethodPost, "/control/filtering/refresh", d.handleFilteringRefresh)
 	registerHTTP(http.MethodPost, "/control/filtering/set_rules", d.handleFilteringSetRules)
 	registerHTTP(http.MethodGet, "/control/filtering/check_host", d.handleCheckHost)
+	registerHTTP(http.MethodPost, "/control/filtering/suggest", d.handleFilteringSuggest)
+	registerHTTP(http.MethodGet, "/control/filtering/catalog", d.handleFilteringCatalog)
+
+	registerHTTP(
+		http.MethodGet,
+		"/control/filtering/connectivity_check",
+		d.handleConnectivityCheckGet,
+	)
+	registerHTTP(
+		http.MethodPut,
+		"/control/filtering/connectivity_check",
+		d.handleConnectivityCheckUpdate,
+	)
 }
 
 // ValidateUpdateIvl returns false if i is not a valid filters update interval.
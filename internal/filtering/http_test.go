@@ -305,3 +305,71 @@ func TestDNSFilter_handleParentalStatus(t *testing.T) {
 		})
 	}
 }
+
+func TestDNSFilter_handleFilteringStatus_categories(t *testing.T) {
+	const statusURL = "/control/filtering/status"
+
+	handlers := make(map[string]http.Handler)
+
+	d, err := New(&Config{
+		DataDir: t.TempDir(),
+		HTTPRegister: func(_, url string, handler http.HandlerFunc) {
+			handlers[url] = handler
+		},
+		Filters: []FilterYAML{{
+			Filter:   Filter{ID: 1},
+			URL:      "https://example.com/trackers.txt",
+			Name:     "Trackers",
+			Category: "Privacy",
+		}, {
+			Filter: Filter{ID: 2},
+			URL:    "https://example.com/old.txt",
+			Name:   "Old list",
+		}, {
+			Filter:   Filter{ID: 3},
+			URL:      "https://example.com/analytics.txt",
+			Name:     "Analytics",
+			Category: "Privacy",
+		}},
+		WhitelistFilters: []FilterYAML{{
+			Filter:   Filter{ID: 4},
+			URL:      "https://example.com/allow.txt",
+			Name:     "Allowlist",
+			Category: "Work",
+		}},
+	}, nil)
+	require.NoError(t, err)
+	t.Cleanup(d.Close)
+
+	d.RegisterFilteringHandlers()
+	require.Contains(t, handlers, statusURL)
+
+	r := httptest.NewRequest(http.MethodGet, statusURL, nil)
+	w := httptest.NewRecorder()
+
+	handlers[statusURL].ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	resp := &filteringConfig{}
+	err = json.NewDecoder(w.Body).Decode(resp)
+	require.NoError(t, err)
+
+	// The flat lists must be kept for compatibility.
+	require.Len(t, resp.Filters, 3)
+	require.Len(t, resp.WhitelistFilters, 1)
+
+	assert.Equal(t, DefaultFilterCategory, resp.Filters[1].Category)
+
+	require.Len(t, resp.Categories, 2)
+	require.Len(t, resp.Categories["Privacy"], 2)
+	require.Len(t, resp.Categories[DefaultFilterCategory], 1)
+
+	assert.Equal(t, "Analytics", resp.Categories["Privacy"][0].Name)
+	assert.Equal(t, "Trackers", resp.Categories["Privacy"][1].Name)
+	assert.Equal(t, "Old list", resp.Categories[DefaultFilterCategory][0].Name)
+
+	require.Len(t, resp.WhitelistCategories, 1)
+	require.Len(t, resp.WhitelistCategories["Work"], 1)
+
+	assert.Equal(t, "Allowlist", resp.WhitelistCategories["Work"][0].Name)
+}
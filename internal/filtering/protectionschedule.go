@@ -0,0 +1,75 @@
+package filtering
+
+import (
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/schedule"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// protectionScheduler tracks the transitions of the protection schedule.  The
+// status of the protection itself is derived from the schedule on every check,
+// see [DNSFilter.ProtectionStatus], so the scheduler only reports the changes.
+// It's not safe for concurrent use.
+type protectionScheduler struct {
+	// schedule is the schedule of the time when the protection is disabled.
+	schedule *schedule.Weekly
+
+	// now returns the current time.  It's replaced in tests.
+	now func() (t time.Time)
+}
+
+// newProtectionScheduler returns a new protection scheduler for the configured
+// schedule, or nil if there is none.
+func (d *DNSFilter) newProtectionScheduler() (s *protectionScheduler) {
+	d.confMu.RLock()
+	defer d.confMu.RUnlock()
+
+	sched := d.conf.ProtectionSchedule
+	if sched == nil {
+		return nil
+	}
+
+	return &protectionScheduler{
+		schedule: sched.Clone(),
+		now:      d.now,
+	}
+}
+
+// next returns the duration until the next transition of the schedule.  ok is
+// false if there are no transitions.
+func (s *protectionScheduler) next() (ivl time.Duration, ok bool) {
+	now := s.now()
+	t, ok := s.schedule.NextTransition(now)
+	if !ok {
+		return 0, false
+	}
+
+	return t.Sub(now), true
+}
+
+// logStatus logs the current status of the protection set by the schedule.
+func (s *protectionScheduler) logStatus() {
+	if s.schedule.Contains(s.now()) {
+		log.Info("filtering: protection disabled by schedule")
+	} else {
+		log.Info("filtering: protection no longer disabled by schedule")
+	}
+}
+
+// isProtectionScheduledOff returns true if the protection is disabled by the
+// schedule at the current time.  d.confMu is expected to be locked.
+func (d *DNSFilter) isProtectionScheduledOff() (ok bool) {
+	sched := d.conf.ProtectionSchedule
+
+	return sched != nil && sched.Contains(d.now())
+}
+
+// protectionScheduledOff is like [DNSFilter.isProtectionScheduledOff] but locks
+// d.confMu itself.
+func (d *DNSFilter) protectionScheduledOff() (ok bool) {
+	d.confMu.RLock()
+	defer d.confMu.RUnlock()
+
+	return d.isProtectionScheduledOff()
+}
@@ -0,0 +1,127 @@
+package filtering
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/schedule"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestDNSFilter_protectionSchedule(t *testing.T) {
+	const schedYAML = `
+fri:
+    start: 12h
+    end: 14h
+time_zone: UTC
+`
+
+	sched := &schedule.Weekly{}
+	err := yaml.Unmarshal([]byte(schedYAML), sched)
+	require.NoError(t, err)
+
+	// friday is a Friday.
+	friday := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	var (
+		beforeRange = friday.Add(11 * time.Hour)
+		rangeStart  = friday.Add(12 * time.Hour)
+		inRange     = friday.Add(13 * time.Hour)
+		rangeEnd    = friday.Add(14 * time.Hour)
+	)
+
+	const blockedHost = "blocked.example"
+
+	d, setts := newForTest(t, &Config{
+		ProtectionEnabled:  true,
+		ProtectionSchedule: sched,
+		DecisionDetails:    true,
+	}, []Filter{{
+		ID: 0, Data: []byte("||" + blockedHost + "^\n"),
+	}})
+	t.Cleanup(d.Close)
+
+	now := beforeRange
+	d.now = func() (t time.Time) { return now }
+
+	s := d.newProtectionScheduler()
+	require.NotNil(t, s)
+
+	testCases := []struct {
+		now         time.Time
+		name        string
+		wantNext    time.Duration
+		wantEnabled bool
+	}{{
+		now:         beforeRange,
+		name:        "before_range",
+		wantNext:    time.Hour,
+		wantEnabled: true,
+	}, {
+		now:         rangeStart,
+		name:        "range_start",
+		wantNext:    2 * time.Hour,
+		wantEnabled: false,
+	}, {
+		now:         inRange,
+		name:        "in_range",
+		wantNext:    time.Hour,
+		wantEnabled: false,
+	}, {
+		now:         rangeEnd,
+		name:        "range_end",
+		wantNext:    7*24*time.Hour - 2*time.Hour,
+		wantEnabled: true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			now = tc.now
+
+			enabled, disabledUntil := d.ProtectionStatus()
+			assert.Equal(t, tc.wantEnabled, enabled)
+			assert.Nil(t, disabledUntil)
+
+			res, checkErr := d.CheckHost(blockedHost, dns.TypeA, setts)
+			require.NoError(t, checkErr)
+
+			assert.Equal(t, tc.wantEnabled, res.IsFiltered)
+			if tc.wantEnabled {
+				assert.Nil(t, res.DecisionDetail)
+			} else {
+				require.NotNil(t, res.DecisionDetail)
+
+				assert.Equal(t, OverrideProtectionDisabled, res.DecisionDetail.Mechanism)
+			}
+
+			ivl, ok := s.next()
+			require.True(t, ok)
+
+			assert.Equal(t, tc.wantNext, ivl)
+		})
+	}
+}
+
+func TestDNSFilter_SetProtectionStatus_pause(t *testing.T) {
+	d, _ := newForTest(t, &Config{ProtectionEnabled: true}, nil)
+	t.Cleanup(d.Close)
+
+	until := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	d.SetProtectionStatus(false, &until)
+
+	enabled, disabledUntil := d.ProtectionStatus()
+	assert.False(t, enabled)
+	assert.Equal(t, &until, disabledUntil)
+
+	conf := &Config{}
+	d.WriteDiskConfig(conf)
+	assert.True(t, conf.ProtectionEnabled)
+
+	d.SetProtectionStatus(true, nil)
+
+	enabled, disabledUntil = d.ProtectionStatus()
+	assert.True(t, enabled)
+	assert.Nil(t, disabledUntil)
+}
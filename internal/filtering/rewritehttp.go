@@ -1,18 +1,32 @@
 package filtering
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"slices"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/hostsfile"
+	"github.com/AdguardTeam/golibs/httphdr"
 	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
 )
 
 // TODO(d.kolyshev): Use [rewrite.Item] instead.
 type rewriteEntryJSON struct {
 	Domain string `json:"domain"`
 	Answer string `json:"answer"`
+
+	// RData is the semicolon-delimited data of the record for the "SRV",
+	// "CAA", and "NAPTR" answers.  See [LegacyRewrite.RData].
+	RData string `json:"rdata,omitempty"`
+
+	LocalAuthority bool `json:"local_authority"`
 }
 
 // handleRewriteList is the handler for the GET /control/rewrite/list HTTP API.
@@ -25,8 +39,10 @@ func (d *DNSFilter) handleRewriteList(w http.ResponseWriter, r *http.Request) {
 
 		for _, ent := range d.conf.Rewrites {
 			jsonEnt := rewriteEntryJSON{
-				Domain: ent.Domain,
-				Answer: ent.Answer,
+				Domain:         ent.Domain,
+				Answer:         ent.Answer,
+				RData:          ent.RData,
+				LocalAuthority: ent.LocalAuthority,
 			}
 			arr = append(arr, &jsonEnt)
 		}
@@ -46,14 +62,14 @@ func (d *DNSFilter) handleRewriteAdd(w http.ResponseWriter, r *http.Request) {
 	}
 
 	rw := &LegacyRewrite{
-		Domain: rwJSON.Domain,
-		Answer: rwJSON.Answer,
+		Domain:         rwJSON.Domain,
+		Answer:         rwJSON.Answer,
+		RData:          rwJSON.RData,
+		LocalAuthority: rwJSON.LocalAuthority,
 	}
 
 	err = rw.normalize()
 	if err != nil {
-		// Shouldn't happen currently, since normalize only returns a non-nil
-		// error when a rewrite is nil, but be change-proof.
 		aghhttp.Error(r, w, http.StatusBadRequest, "normalizing: %s", err)
 
 		return
@@ -89,6 +105,7 @@ func (d *DNSFilter) handleRewriteDelete(w http.ResponseWriter, r *http.Request)
 	entDel := &LegacyRewrite{
 		Domain: jsent.Domain,
 		Answer: jsent.Answer,
+		RData:  jsent.RData,
 	}
 	arr := []*LegacyRewrite{}
 
@@ -131,17 +148,18 @@ func (d *DNSFilter) handleRewriteUpdate(w http.ResponseWriter, r *http.Request)
 	rwDel := &LegacyRewrite{
 		Domain: updateJSON.Target.Domain,
 		Answer: updateJSON.Target.Answer,
+		RData:  updateJSON.Target.RData,
 	}
 
 	rwAdd := &LegacyRewrite{
-		Domain: updateJSON.Update.Domain,
-		Answer: updateJSON.Update.Answer,
+		Domain:         updateJSON.Update.Domain,
+		Answer:         updateJSON.Update.Answer,
+		RData:          updateJSON.Update.RData,
+		LocalAuthority: updateJSON.Update.LocalAuthority,
 	}
 
 	err = rwAdd.normalize()
 	if err != nil {
-		// Shouldn't happen currently, since normalize only returns a non-nil
-		// error when a rewrite is nil, but be change-proof.
 		aghhttp.Error(r, w, http.StatusBadRequest, "normalizing: %s", err)
 
 		return
@@ -169,3 +187,158 @@ func (d *DNSFilter) handleRewriteUpdate(w http.ResponseWriter, r *http.Request)
 	log.Debug("rewrite: removed element: %s -> %s", rwDel.Domain, rwDel.Answer)
 	log.Debug("rewrite: added element: %s -> %s", rwAdd.Domain, rwAdd.Answer)
 }
+
+// rewriteImportResp is the response for the POST /control/rewrite/import HTTP
+// API.
+type rewriteImportResp struct {
+	// Errors are the descriptions of the malformed lines.
+	Errors []string `json:"errors"`
+
+	// Added is the number of the added rewrites.
+	Added int `json:"added"`
+
+	// Skipped is the number of the rewrites, which are already present.
+	Skipped int `json:"skipped"`
+}
+
+// handleRewriteImport is the handler for the POST /control/rewrite/import HTTP
+// API.  The request body is a hosts file.
+func (d *DNSFilter) handleRewriteImport(w http.ResponseWriter, r *http.Request) {
+	resp := &rewriteImportResp{
+		Errors: []string{},
+	}
+
+	var err error
+	func() {
+		d.confMu.Lock()
+		defer d.confMu.Unlock()
+
+		d.conf.Rewrites, err = importHostsRewrites(d.conf.Rewrites, r.Body, resp)
+	}()
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "reading hosts: %s", err)
+
+		return
+	}
+
+	log.Debug("rewrite: imported %d elements, skipped %d", resp.Added, resp.Skipped)
+
+	if resp.Added > 0 {
+		d.conf.ConfigModified()
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
+
+// rewriteKey is the key of a rewrite with an IP address answer used for
+// detecting duplicates.
+type rewriteKey struct {
+	domain string
+	answer string
+}
+
+// importHostsRewrites parses the hosts file from r and returns rws with the
+// rewrites of type A or AAAA for each hostname appended.  The rewrites already
+// present in rws are skipped.  resp is filled with the results.  If reading r
+// fails, rws is returned unchanged.
+func importHostsRewrites(
+	rws []*LegacyRewrite,
+	r io.Reader,
+	resp *rewriteImportResp,
+) (res []*LegacyRewrite, err error) {
+	seen := make(map[rewriteKey]struct{}, len(rws))
+	for _, rw := range rws {
+		seen[rewriteKey{domain: rw.Domain, answer: rw.Answer}] = struct{}{}
+	}
+
+	var added []*LegacyRewrite
+	s := bufio.NewScanner(r)
+	for lineNum := 1; s.Scan(); lineNum++ {
+		rec := &hostsfile.Record{}
+		recErr := rec.UnmarshalText(s.Bytes())
+		if errors.Is(recErr, hostsfile.ErrEmptyLine) {
+			continue
+		} else if recErr != nil {
+			resp.Errors = append(resp.Errors, fmt.Sprintf("line %d: %s", lineNum, recErr))
+
+			continue
+		}
+
+		for _, name := range rec.Names {
+			rw := &LegacyRewrite{
+				Domain: name,
+				Answer: rec.Addr.String(),
+			}
+
+			nErr := rw.normalize()
+			if nErr != nil {
+				resp.Errors = append(resp.Errors, fmt.Sprintf("line %d: %s", lineNum, nErr))
+
+				continue
+			}
+
+			k := rewriteKey{domain: rw.Domain, answer: rw.Answer}
+			if _, ok := seen[k]; ok {
+				resp.Skipped++
+
+				continue
+			}
+
+			seen[k] = struct{}{}
+			added = append(added, rw)
+		}
+	}
+
+	err = s.Err()
+	if err != nil {
+		return rws, fmt.Errorf("scanning: %w", err)
+	}
+
+	resp.Added = len(added)
+
+	return append(rws, added...), nil
+}
+
+// handleRewriteExport is the handler for the GET /control/rewrite/export HTTP
+// API.  The response body is a hosts file.
+func (d *DNSFilter) handleRewriteExport(w http.ResponseWriter, r *http.Request) {
+	b := &bytes.Buffer{}
+
+	func() {
+		d.confMu.RLock()
+		defer d.confMu.RUnlock()
+
+		writeHostsRewrites(b, d.conf.Rewrites)
+	}()
+
+	h := w.Header()
+	h.Set(httphdr.ContentType, aghhttp.HdrValTextPlain)
+	h.Set(httphdr.ContentDisposition, `attachment; filename=rewrites.hosts`)
+
+	_, err := w.Write(b.Bytes())
+	if err != nil {
+		log.Debug("rewrite: writing exported elements: %s", err)
+	}
+}
+
+// writeHostsRewrites writes rws into b in the hosts file format.  The rewrites,
+// which can't be represented in this format, such as the CNAME and wildcard
+// ones, are written as comments.
+func writeHostsRewrites(b *bytes.Buffer, rws []*LegacyRewrite) {
+	for _, rw := range rws {
+		switch {
+		case isWildcard(rw.Domain):
+			_, _ = fmt.Fprintf(b, "# wildcard rewrite omitted: %s -> %s\n", rw.Domain, rw.Answer)
+		case !rw.IP.IsValid():
+			_, _ = fmt.Fprintf(
+				b,
+				"# %s rewrite omitted: %s -> %s\n",
+				dns.Type(rw.Type),
+				rw.Domain,
+				rw.Answer,
+			)
+		default:
+			_, _ = fmt.Fprintf(b, "%s %s\n", rw.IP, rw.Domain)
+		}
+	}
+}
@@ -3,9 +3,11 @@ package filtering_test
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -17,8 +19,9 @@ import (
 
 // TODO(d.kolyshev): Use [rewrite.Item] instead.
 type rewriteJSON struct {
-	Domain string `json:"domain"`
-	Answer string `json:"answer"`
+	Domain         string `json:"domain"`
+	Answer         string `json:"answer"`
+	LocalAuthority bool   `json:"local_authority"`
 }
 
 type rewriteUpdateJSON struct {
@@ -34,6 +37,8 @@ const (
 	addURL    = "/control/rewrite/add"
 	deleteURL = "/control/rewrite/delete"
 	updateURL = "/control/rewrite/update"
+	importURL = "/control/rewrite/import"
+	exportURL = "/control/rewrite/export"
 
 	decodeErrorMsg = "json.Decode: json: cannot unmarshal string into Go value of type" +
 		" filtering.rewriteEntryJSON\n"
@@ -206,6 +211,87 @@ func TestDNSFilter_handleRewriteHTTP(t *testing.T) {
 	}
 }
 
+func TestDNSFilter_handleRewriteImportExport(t *testing.T) {
+	const entriesNum = 500
+
+	var confModified int
+	handlers := make(map[string]http.Handler)
+
+	d, err := filtering.New(&filtering.Config{
+		ConfigModified: func() { confModified++ },
+		HTTPRegister: func(_, url string, handler http.HandlerFunc) {
+			handlers[url] = handler
+		},
+		Rewrites: []*filtering.LegacyRewrite{{
+			Domain: "cname.local",
+			Answer: "example.local",
+		}, {
+			Domain: "host-0.local",
+			Answer: "192.0.2.0",
+		}},
+	}, nil)
+	require.NoError(t, err)
+	t.Cleanup(d.Close)
+
+	d.RegisterFilteringHandlers()
+	require.Contains(t, handlers, importURL)
+	require.Contains(t, handlers, exportURL)
+
+	wantExport := &strings.Builder{}
+	_, _ = wantExport.WriteString("# CNAME rewrite omitted: cname.local -> example.local\n")
+
+	hosts := &strings.Builder{}
+	_, _ = hosts.WriteString("# Imported hosts.\n\n")
+	for i := range entriesNum {
+		var line string
+		if i%2 == 0 {
+			line = fmt.Sprintf("192.0.2.%d host-%d.local", i/2%256, i)
+		} else {
+			line = fmt.Sprintf("2001:db8::%x host-%d.local", i, i)
+		}
+
+		_, _ = fmt.Fprintf(hosts, "%s # Entry %d.\n", line, i)
+		_, _ = fmt.Fprintf(wantExport, "%s\n", line)
+	}
+
+	// The duplicate of the existing rewrite, the duplicate within the file,
+	// and the malformed lines.
+	_, _ = hosts.WriteString("192.0.2.0 HOST-0.local\n")
+	_, _ = hosts.WriteString("192.0.2.1 host-2.local\n")
+	_, _ = hosts.WriteString("192.0.2.256 bad-ip.local\n")
+	_, _ = hosts.WriteString("192.0.2.1\n")
+
+	r := httptest.NewRequest(http.MethodPost, importURL, strings.NewReader(hosts.String()))
+	w := httptest.NewRecorder()
+
+	handlers[importURL].ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	resp := &struct {
+		Errors  []string `json:"errors"`
+		Added   int      `json:"added"`
+		Skipped int      `json:"skipped"`
+	}{}
+	err = json.NewDecoder(w.Body).Decode(resp)
+	require.NoError(t, err)
+
+	assert.Equal(t, entriesNum-1, resp.Added)
+	assert.Equal(t, 3, resp.Skipped)
+	assert.Equal(t, []string{
+		`line 505: ParseAddr("192.0.2.256"): IPv4 field has value >255`,
+		"line 506: no hostnames",
+	}, resp.Errors)
+	assert.Equal(t, 1, confModified)
+
+	r = httptest.NewRequest(http.MethodGet, exportURL, nil)
+	w = httptest.NewRecorder()
+
+	handlers[exportURL].ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	assert.Equal(t, wantExport.String(), w.Body.String())
+}
+
 // assertRewritesList checks if rewrites list equals the list received from the
 // handler by listURL.
 func assertRewritesList(t *testing.T, handler http.Handler, wantList []*rewriteJSON) {
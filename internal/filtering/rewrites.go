@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/netip"
 	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/AdguardTeam/golibs/errors"
@@ -21,20 +22,39 @@ type LegacyRewrite struct {
 	Domain string `yaml:"domain"`
 
 	// Answer is the IP address, canonical name, or one of the special
-	// values: "A" or "AAAA".
+	// values: "A", "AAAA", "SRV", "CAA", or "NAPTR".
 	Answer string `yaml:"answer"`
 
+	// RData is the semicolon-delimited data of the record, if Answer is one of
+	// "SRV", "CAA", or "NAPTR":
+	//
+	//   - SRV: priority;weight;port;target
+	//   - CAA: flag;tag;value
+	//   - NAPTR: order;preference;flags;services;regexp;replacement
+	//
+	// Only the last field may contain semicolons.
+	RData string `yaml:"rdata,omitempty"`
+
+	// rr is the record parsed from RData.  Only the type is set in its header.
+	rr dns.RR
+
 	// IP is the IP address that should be used in the response if Type is
 	// dns.TypeA or dns.TypeAAAA.
 	IP netip.Addr `yaml:"-"`
 
-	// Type is the DNS record type: A, AAAA, or CNAME.
+	// Type is the DNS record type: A, AAAA, CNAME, SRV, CAA, or NAPTR.
 	Type uint16 `yaml:"-"`
+
+	// LocalAuthority, if true, means that the queries for the subdomains of
+	// Domain, which have no rewrites of their own, are answered locally with
+	// an empty response instead of being forwarded upstream.  It has no effect
+	// for wildcard rewrites, since they already match all the subdomains.
+	LocalAuthority bool `yaml:"local_authority,omitempty"`
 }
 
 // equal returns true if the rw is equal to the other.
 func (rw *LegacyRewrite) equal(other *LegacyRewrite) (ok bool) {
-	return rw.Domain == other.Domain && rw.Answer == other.Answer
+	return rw.Domain == other.Domain && rw.Answer == other.Answer && rw.RData == other.RData
 }
 
 // matchesQType returns true if the entry matches the question type qt.
@@ -44,6 +64,10 @@ func (rw *LegacyRewrite) matchesQType(qt uint16) (ok bool) {
 		return true
 	}
 
+	if rw.rr != nil {
+		return rw.Type == qt
+	}
+
 	// Reject types other than A and AAAA.
 	if qt != dns.TypeA && qt != dns.TypeAAAA {
 		return false
@@ -67,6 +91,7 @@ func (rw *LegacyRewrite) normalize() (err error) {
 	// use it in matchDomainWildcard instead of using strings.ToLower
 	// everywhere.
 	rw.Domain = strings.ToLower(rw.Domain)
+	rw.rr = nil
 
 	switch rw.Answer {
 	case "AAAA":
@@ -78,6 +103,15 @@ func (rw *LegacyRewrite) normalize() (err error) {
 		rw.IP = netip.Addr{}
 		rw.Type = dns.TypeA
 
+		return nil
+	case "SRV", "CAA", "NAPTR":
+		rw.IP = netip.Addr{}
+		rw.Type = dns.StringToType[rw.Answer]
+		rw.rr, err = parseRData(rw.Type, rw.RData)
+		if err != nil {
+			return fmt.Errorf("rewrite for %q: %s rdata: %w", rw.Domain, rw.Answer, err)
+		}
+
 		return nil
 	default:
 		// Go on.
@@ -101,6 +135,155 @@ func (rw *LegacyRewrite) normalize() (err error) {
 	return nil
 }
 
+// parseRData parses the semicolon-delimited rdata of a record of type rrType.
+// Only the type is set in the header of rr.
+func parseRData(rrType uint16, rdata string) (rr dns.RR, err error) {
+	switch rrType {
+	case dns.TypeSRV:
+		rr, err = parseRDataSRV(rdata)
+	case dns.TypeCAA:
+		rr, err = parseRDataCAA(rdata)
+	case dns.TypeNAPTR:
+		rr, err = parseRDataNAPTR(rdata)
+	default:
+		return nil, fmt.Errorf("unsupported record type %s", dns.Type(rrType))
+	}
+
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+
+	rr.Header().Rrtype = rrType
+
+	return rr, nil
+}
+
+// splitRData splits rdata into exactly n fields.  Only the last field may
+// contain semicolons.
+func splitRData(rdata string, n int) (fields []string, err error) {
+	fields = strings.SplitN(rdata, ";", n)
+	if len(fields) != n {
+		return nil, fmt.Errorf("want %d fields, got %d", n, len(fields))
+	}
+
+	for i, f := range fields {
+		fields[i] = strings.TrimSpace(f)
+	}
+
+	return fields, nil
+}
+
+// parseRDataUint16 parses a uint16 field of rdata with the given name.
+func parseRDataUint16(name, s string) (v uint16, err error) {
+	v64, err := strconv.ParseUint(s, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+
+	return uint16(v64), nil
+}
+
+// validateRDataName returns an error if name isn't a valid domain name field
+// of rdata.  "." is allowed.
+func validateRDataName(field, name string) (err error) {
+	if _, ok := dns.IsDomainName(name); !ok || name == "" {
+		return fmt.Errorf("%s: bad domain name %q", field, name)
+	}
+
+	return nil
+}
+
+// parseRDataSRV parses the rdata of an SRV record in the format
+// "priority;weight;port;target".
+func parseRDataSRV(rdata string) (rr *dns.SRV, err error) {
+	fields, err := splitRData(rdata, 4)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+
+	rr = &dns.SRV{}
+	if rr.Priority, err = parseRDataUint16("priority", fields[0]); err != nil {
+		return nil, err
+	} else if rr.Weight, err = parseRDataUint16("weight", fields[1]); err != nil {
+		return nil, err
+	} else if rr.Port, err = parseRDataUint16("port", fields[2]); err != nil {
+		return nil, err
+	}
+
+	err = validateRDataName("target", fields[3])
+	if err != nil {
+		return nil, err
+	}
+
+	rr.Target = dns.Fqdn(fields[3])
+
+	return rr, nil
+}
+
+// parseRDataCAA parses the rdata of a CAA record in the format
+// "flag;tag;value".
+func parseRDataCAA(rdata string) (rr *dns.CAA, err error) {
+	fields, err := splitRData(rdata, 3)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+
+	flag, err := strconv.ParseUint(fields[0], 10, 8)
+	if err != nil {
+		return nil, fmt.Errorf("flag: %w", err)
+	}
+
+	tag := fields[1]
+	if tag == "" || strings.IndexFunc(tag, isNotAlnum) >= 0 {
+		return nil, fmt.Errorf("tag: bad value %q", tag)
+	}
+
+	return &dns.CAA{
+		Flag:  uint8(flag),
+		Tag:   tag,
+		Value: fields[2],
+	}, nil
+}
+
+// isNotAlnum returns true if r isn't an ASCII letter or digit.
+func isNotAlnum(r rune) (ok bool) {
+	return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+}
+
+// parseRDataNAPTR parses the rdata of a NAPTR record in the format
+// "order;preference;flags;services;regexp;replacement".
+func parseRDataNAPTR(rdata string) (rr *dns.NAPTR, err error) {
+	fields, err := splitRData(rdata, 6)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+
+	rr = &dns.NAPTR{
+		Flags:   fields[2],
+		Service: fields[3],
+		Regexp:  fields[4],
+	}
+
+	if rr.Order, err = parseRDataUint16("order", fields[0]); err != nil {
+		return nil, err
+	} else if rr.Preference, err = parseRDataUint16("preference", fields[1]); err != nil {
+		return nil, err
+	}
+
+	err = validateRDataName("replacement", fields[5])
+	if err != nil {
+		return nil, err
+	}
+
+	rr.Replacement = dns.Fqdn(fields[5])
+
+	return rr, nil
+}
+
 // isWildcard returns true if pat is a wildcard domain pattern.
 func isWildcard(pat string) bool {
 	return len(pat) > 1 && pat[0] == '*' && pat[1] == '.'
@@ -189,10 +372,35 @@ func findRewrites(
 	return rewrites, matched
 }
 
-// setRewriteResult sets the Reason or IPList of res if necessary.  res must not
-// be nil.
+// isUnderLocalAuthority returns true if host is a subdomain of the domain of a
+// non-wildcard rewrite entry with local authority.  If global is true, all the
+// entries are considered to have local authority.
+func isUnderLocalAuthority(entries []*LegacyRewrite, host string, global bool) (ok bool) {
+	for _, e := range entries {
+		if (!global && !e.LocalAuthority) || isWildcard(e.Domain) {
+			continue
+		}
+
+		if strings.HasSuffix(host, "."+e.Domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// setRewriteResult sets the Reason, IPList, or RewriteRRs of res if necessary.
+// res must not be nil.
 func setRewriteResult(res *Result, host string, rewrites []*LegacyRewrite, qtype uint16) {
 	for _, rw := range rewrites {
+		if rw.rr != nil && rw.Type == qtype {
+			res.RewriteRRs = append(res.RewriteRRs, rw.rr)
+
+			log.Debug("rewrite: %s for %s is %q", rw.Answer, host, rw.RData)
+
+			continue
+		}
+
 		if rw.Type == qtype && (qtype == dns.TypeA || qtype == dns.TypeAAAA) {
 			if rw.IP == (netip.Addr{}) {
 				// "A"/"AAAA" exception: allow getting from upstream.
@@ -215,8 +423,12 @@ func cloneRewrites(entries []*LegacyRewrite) (clone []*LegacyRewrite) {
 		clone[i] = &LegacyRewrite{
 			Domain: rw.Domain,
 			Answer: rw.Answer,
+			RData:  rw.RData,
+			rr:     rw.rr,
 			IP:     rw.IP,
 			Type:   rw.Type,
+
+			LocalAuthority: rw.LocalAuthority,
 		}
 	}
 
@@ -5,6 +5,7 @@ import (
 	"net/netip"
 	"testing"
 
+	"github.com/AdguardTeam/golibs/testutil"
 	"github.com/miekg/dns"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -400,3 +401,279 @@ func TestRewritesExceptionIP(t *testing.T) {
 		})
 	}
 }
+
+func TestRewritesLocalAuthority(t *testing.T) {
+	d, _ := newForTest(t, nil, nil)
+	t.Cleanup(d.Close)
+
+	var (
+		parentAddr = netip.MustParseAddr("192.168.0.1")
+		subAddr    = netip.MustParseAddr("192.168.0.2")
+		wildAddr   = netip.MustParseAddr("192.168.0.3")
+	)
+
+	d.conf.Rewrites = []*LegacyRewrite{{
+		Domain:         "example.internal",
+		Answer:         parentAddr.String(),
+		LocalAuthority: true,
+	}, {
+		Domain: "sub.example.internal",
+		Answer: subAddr.String(),
+	}, {
+		Domain:         "*.wild.internal",
+		Answer:         wildAddr.String(),
+		LocalAuthority: true,
+	}, {
+		Domain: "other.internal",
+		Answer: parentAddr.String(),
+	}}
+
+	require.NoError(t, d.prepareRewrites())
+
+	testCases := []struct {
+		name       string
+		host       string
+		want       []netip.Addr
+		dtyp       uint16
+		wantGlobal Reason
+		wantReason Reason
+	}{{
+		name:       "parent_a",
+		host:       "example.internal",
+		want:       []netip.Addr{parentAddr},
+		dtyp:       dns.TypeA,
+		wantGlobal: Rewritten,
+		wantReason: Rewritten,
+	}, {
+		name:       "parent_txt",
+		host:       "example.internal",
+		want:       nil,
+		dtyp:       dns.TypeTXT,
+		wantGlobal: Rewritten,
+		wantReason: Rewritten,
+	}, {
+		name:       "child_a",
+		host:       "host.example.internal",
+		want:       nil,
+		dtyp:       dns.TypeA,
+		wantGlobal: Rewritten,
+		wantReason: Rewritten,
+	}, {
+		name:       "child_txt",
+		host:       "_dnslink.example.internal",
+		want:       nil,
+		dtyp:       dns.TypeTXT,
+		wantGlobal: Rewritten,
+		wantReason: Rewritten,
+	}, {
+		name:       "child_srv",
+		host:       "_sip._tcp.example.internal",
+		want:       nil,
+		dtyp:       dns.TypeSRV,
+		wantGlobal: Rewritten,
+		wantReason: Rewritten,
+	}, {
+		name:       "specific_child_a",
+		host:       "sub.example.internal",
+		want:       []netip.Addr{subAddr},
+		dtyp:       dns.TypeA,
+		wantGlobal: Rewritten,
+		wantReason: Rewritten,
+	}, {
+		name:       "wildcard_txt",
+		host:       "_dnslink.host.wild.internal",
+		want:       nil,
+		dtyp:       dns.TypeTXT,
+		wantGlobal: Rewritten,
+		wantReason: Rewritten,
+	}, {
+		name:       "wildcard_parent_a",
+		host:       "wild.internal",
+		want:       nil,
+		dtyp:       dns.TypeA,
+		wantGlobal: NotFilteredNotFound,
+		wantReason: NotFilteredNotFound,
+	}, {
+		name:       "no_authority_txt",
+		host:       "_dnslink.other.internal",
+		want:       nil,
+		dtyp:       dns.TypeTXT,
+		wantGlobal: Rewritten,
+		wantReason: NotFilteredNotFound,
+	}, {
+		name:       "not_subdomain_a",
+		host:       "notexample.internal",
+		want:       nil,
+		dtyp:       dns.TypeA,
+		wantGlobal: NotFilteredNotFound,
+		wantReason: NotFilteredNotFound,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d.conf.RewritesLocalAuthority = false
+
+			r := d.processRewrites(tc.host, tc.dtyp)
+			assert.Equal(t, tc.want, r.IPList)
+			assert.Equal(t, tc.wantReason, r.Reason)
+
+			d.conf.RewritesLocalAuthority = true
+
+			r = d.processRewrites(tc.host, tc.dtyp)
+			assert.Equal(t, tc.want, r.IPList)
+			assert.Equal(t, tc.wantGlobal, r.Reason)
+		})
+	}
+}
+
+func TestRewritesCustomTypes(t *testing.T) {
+	d, _ := newForTest(t, nil, nil)
+	t.Cleanup(d.Close)
+
+	d.conf.Rewrites = []*LegacyRewrite{{
+		Domain: "_sip._tcp.example.org",
+		Answer: "SRV",
+		RData:  "10;5;5060;sip.example.org",
+	}, {
+		Domain: "example.org",
+		Answer: "CAA",
+		RData:  "0;issue;letsencrypt.org; validationmethods=dns-01",
+	}, {
+		Domain: "example.org",
+		Answer: "NAPTR",
+		RData:  "100;10;U;E2U+sip;!^.*$!sip:info@example.org!;.",
+	}, {
+		Domain: "alias.example.org",
+		Answer: "example.org",
+	}}
+
+	require.NoError(t, d.prepareRewrites())
+
+	testCases := []struct {
+		want      dns.RR
+		name      string
+		host      string
+		wantCanon string
+		dtyp      uint16
+	}{{
+		want: &dns.SRV{
+			Hdr:      dns.RR_Header{Rrtype: dns.TypeSRV},
+			Priority: 10,
+			Weight:   5,
+			Port:     5060,
+			Target:   "sip.example.org.",
+		},
+		name:      "srv",
+		host:      "_sip._tcp.example.org",
+		wantCanon: "",
+		dtyp:      dns.TypeSRV,
+	}, {
+		want: &dns.CAA{
+			Hdr:   dns.RR_Header{Rrtype: dns.TypeCAA},
+			Flag:  0,
+			Tag:   "issue",
+			Value: "letsencrypt.org; validationmethods=dns-01",
+		},
+		name:      "caa",
+		host:      "example.org",
+		wantCanon: "",
+		dtyp:      dns.TypeCAA,
+	}, {
+		want: &dns.NAPTR{
+			Hdr:         dns.RR_Header{Rrtype: dns.TypeNAPTR},
+			Order:       100,
+			Preference:  10,
+			Flags:       "U",
+			Service:     "E2U+sip",
+			Regexp:      "!^.*$!sip:info@example.org!",
+			Replacement: ".",
+		},
+		name:      "naptr",
+		host:      "example.org",
+		wantCanon: "",
+		dtyp:      dns.TypeNAPTR,
+	}, {
+		want: &dns.CAA{
+			Hdr:   dns.RR_Header{Rrtype: dns.TypeCAA},
+			Flag:  0,
+			Tag:   "issue",
+			Value: "letsencrypt.org; validationmethods=dns-01",
+		},
+		name:      "caa_cname",
+		host:      "alias.example.org",
+		wantCanon: "example.org",
+		dtyp:      dns.TypeCAA,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := d.processRewrites(tc.host, tc.dtyp)
+			require.Equal(t, Rewritten, r.Reason)
+
+			assert.Equal(t, tc.wantCanon, r.CanonName)
+			assert.Empty(t, r.IPList)
+			assert.Equal(t, []dns.RR{tc.want}, r.RewriteRRs)
+		})
+	}
+
+	t.Run("other_type", func(t *testing.T) {
+		r := d.processRewrites("_sip._tcp.example.org", dns.TypeA)
+
+		assert.Equal(t, Rewritten, r.Reason)
+		assert.Empty(t, r.RewriteRRs)
+	})
+}
+
+func TestLegacyRewrite_normalize_rdata(t *testing.T) {
+	testCases := []struct {
+		name       string
+		answer     string
+		rdata      string
+		wantErrMsg string
+	}{{
+		name:       "srv_fields",
+		answer:     "SRV",
+		rdata:      "10;5;sip.example.org",
+		wantErrMsg: `rewrite for "example.org": SRV rdata: want 4 fields, got 3`,
+	}, {
+		name:   "srv_port",
+		answer: "SRV",
+		rdata:  "10;5;65536;sip.example.org",
+		wantErrMsg: `rewrite for "example.org": SRV rdata: port: ` +
+			`strconv.ParseUint: parsing "65536": value out of range`,
+	}, {
+		name:       "srv_target",
+		answer:     "SRV",
+		rdata:      "10;5;5060;",
+		wantErrMsg: `rewrite for "example.org": SRV rdata: target: bad domain name ""`,
+	}, {
+		name:       "caa_tag",
+		answer:     "CAA",
+		rdata:      "0;is sue;letsencrypt.org",
+		wantErrMsg: `rewrite for "example.org": CAA rdata: tag: bad value "is sue"`,
+	}, {
+		name:   "caa_flag",
+		answer: "CAA",
+		rdata:  "256;issue;letsencrypt.org",
+		wantErrMsg: `rewrite for "example.org": CAA rdata: flag: ` +
+			`strconv.ParseUint: parsing "256": value out of range`,
+	}, {
+		name:   "naptr_order",
+		answer: "NAPTR",
+		rdata:  "x;10;U;E2U+sip;;.",
+		wantErrMsg: `rewrite for "example.org": NAPTR rdata: order: ` +
+			`strconv.ParseUint: parsing "x": invalid syntax`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rw := &LegacyRewrite{
+				Domain: "example.org",
+				Answer: tc.answer,
+				RData:  tc.rdata,
+			}
+
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, rw.normalize())
+		})
+	}
+}
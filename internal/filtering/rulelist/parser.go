@@ -3,29 +3,74 @@ package rulelist
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"hash/crc32"
 	"io"
 	"slices"
+	"strconv"
 
 	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/urlfilter/rules"
 )
 
+// MaxSkippedRules is the maximum number of skipped rules reported in
+// [ParseResult.SkippedRules].
+const MaxSkippedRules = 50
+
+// maxSkippedRuleTextLen is the maximum length of [SkippedRule.Text] in bytes.
+const maxSkippedRuleTextLen = 128
+
+// Prefixes of the comments, which store the skipped rules in the lists written
+// by [Parser.WriteSkippedRules].
+const (
+	skippedCountPrefix = "! Skipped rules: "
+	skippedRulePrefix  = "! Skipped rule: "
+)
+
+// SkippedRule describes a line of a filtering-rule list that couldn't be
+// parsed as a rule and is thus ignored by the filtering engine.
+type SkippedRule struct {
+	// Text is the excerpt of the line, truncated to a reasonable length.
+	Text string `json:"text"`
+
+	// Reason is the description of the parsing error.
+	Reason string `json:"reason"`
+
+	// Line is the one-based number of the line.
+	Line int `json:"line"`
+}
+
 // Parser is a filtering-rule parser that collects data, such as the checksum
 // and the title, as well as counts rules and removes comments.
 type Parser struct {
-	title      string
-	rulesCount int
-	written    int
-	checksum   uint32
-	titleFound bool
+	title        string
+	skippedRules []*SkippedRule
+	rulesCount   int
+	skippedCount int
+	written      int
+	checksum     uint32
+	titleFound   bool
+	affecting    bool
+	stored       bool
 }
 
-// NewParser returns a new filtering-rule parser.
+// NewParser returns a new filtering-rule parser.  It checks if the rules can be
+// parsed by the filtering engine, see [ParseResult.SkippedRules].
 func NewParser() (p *Parser) {
 	return &Parser{}
 }
 
+// NewStoredParser returns a new filtering-rule parser for the lists written by
+// [Parser.Parse] and [Parser.WriteSkippedRules].  It doesn't check the rules
+// and reads the skipped ones from the comments instead, so that their line
+// numbers refer to the original list.
+func NewStoredParser() (p *Parser) {
+	return &Parser{
+		stored: true,
+	}
+}
+
 // ParseResult contains information about the results of parsing a
 // filtering-rule list by [Parser.Parse].
 type ParseResult struct {
@@ -39,9 +84,32 @@ type ParseResult struct {
 	// BytesWritten is the number of bytes written to dst.
 	BytesWritten int
 
+	// SkippedRules are the first [MaxSkippedRules] lines that couldn't be
+	// parsed as rules.
+	SkippedRules []*SkippedRule
+
+	// SkippedCount is the total number of lines that couldn't be parsed as
+	// rules.  These lines are still included into RulesCount.
+	SkippedCount int
+
 	// Checksum is the CRC-32 checksum of the rules content.  That is, excluding
 	// empty lines and comments.
 	Checksum uint32
+
+	// HasAffectingRules is true if the list contains rules that affect the
+	// rules of other lists, see [AffectsOtherRules].
+	HasAffectingRules bool
+}
+
+// AffectsOtherRules returns true if rule is a filtering rule that can affect
+// the rules of other lists, such as a $badfilter rule or a $dnsrewrite
+// exception.  rule must be trimmed.
+func AffectsOtherRules(rule []byte) (ok bool) {
+	if bytes.Contains(rule, []byte("badfilter")) {
+		return true
+	}
+
+	return bytes.HasPrefix(rule, []byte("@@")) && bytes.Contains(rule, []byte("dnsrewrite"))
 }
 
 // Parse parses data from src into dst using buf during parsing.  r is never
@@ -85,7 +153,11 @@ func (p *Parser) result() (r *ParseResult) {
 		Title:        p.title,
 		RulesCount:   p.rulesCount,
 		BytesWritten: p.written,
+		SkippedRules: p.skippedRules,
+		SkippedCount: p.skippedCount,
 		Checksum:     p.checksum,
+
+		HasAffectingRules: p.affecting,
 	}
 }
 
@@ -97,6 +169,10 @@ func (p *Parser) processLine(dst io.Writer, line []byte, lineNum int) (n int, er
 		return 0, ErrHTML
 	}
 
+	if p.stored && p.readSkipped(trimmed) {
+		return 0, nil
+	}
+
 	badIdx, isRule := 0, false
 	if p.titleFound {
 		badIdx, isRule = parseLine(trimmed)
@@ -118,6 +194,10 @@ func (p *Parser) processLine(dst io.Writer, line []byte, lineNum int) (n int, er
 
 	p.rulesCount++
 	p.checksum = crc32.Update(p.checksum, crc32.IEEETable, trimmed)
+	p.affecting = p.affecting || AffectsOtherRules(trimmed)
+	if !p.stored {
+		p.checkRule(trimmed, lineNum)
+	}
 
 	// Assume that there is generally enough space in the buffer to add a
 	// newline.
@@ -126,6 +206,84 @@ func (p *Parser) processLine(dst io.Writer, line []byte, lineNum int) (n int, er
 	return n, errors.Annotate(err, "writing rule line: %w")
 }
 
+// checkRule checks if line can be parsed by the filtering engine and records it
+// as skipped if it can't.  Only the first [MaxSkippedRules] skipped lines are
+// stored to keep the memory usage low.
+func (p *Parser) checkRule(line []byte, lineNum int) {
+	_, err := rules.NewRule(string(line), 0)
+	if err == nil {
+		return
+	}
+
+	p.skippedCount++
+	if len(p.skippedRules) >= MaxSkippedRules {
+		return
+	}
+
+	text := line
+	if len(text) > maxSkippedRuleTextLen {
+		text = text[:maxSkippedRuleTextLen]
+	}
+
+	p.skippedRules = append(p.skippedRules, &SkippedRule{
+		Text:   string(bytes.ToValidUTF8(text, []byte("\uFFFD"))),
+		Reason: err.Error(),
+		Line:   lineNum,
+	})
+}
+
+// WriteSkippedRules writes the skipped rules found by the previous call to
+// [Parser.Parse] to dst as comments, so that they could be read by the parser
+// returned by [NewStoredParser].  n is the number of bytes written.
+func (p *Parser) WriteSkippedRules(dst io.Writer) (n int, err error) {
+	if p.skippedCount == 0 {
+		return 0, nil
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteString(skippedCountPrefix)
+	buf.WriteString(strconv.Itoa(p.skippedCount))
+	buf.WriteByte('\n')
+
+	for _, sr := range p.skippedRules {
+		buf.WriteString(skippedRulePrefix)
+
+		// Don't check the error, since SkippedRule always can be encoded.
+		data, _ := json.Marshal(sr)
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+
+	n, err = dst.Write(buf.Bytes())
+
+	return n, errors.Annotate(err, "writing skipped rules: %w")
+}
+
+// readSkipped reads the skipped rule or the number of those from the comment
+// written by [Parser.WriteSkippedRules].  ok is false if line isn't such a
+// comment.  line must be trimmed.
+func (p *Parser) readSkipped(line []byte) (ok bool) {
+	if countData, found := bytes.CutPrefix(line, []byte(skippedCountPrefix)); found {
+		// Ignore the malformed values, since the list is still usable.
+		p.skippedCount, _ = strconv.Atoi(string(countData))
+
+		return true
+	}
+
+	ruleData, found := bytes.CutPrefix(line, []byte(skippedRulePrefix))
+	if !found {
+		return false
+	}
+
+	sr := &SkippedRule{}
+	err := json.Unmarshal(ruleData, sr)
+	if err == nil && len(p.skippedRules) < MaxSkippedRules {
+		p.skippedRules = append(p.skippedRules, sr)
+	}
+
+	return true
+}
+
 // isHTMLLine returns true if line is likely an HTML line.  line is assumed to
 // be trimmed of whitespace characters.
 func isHTMLLine(line []byte) (isHTML bool) {
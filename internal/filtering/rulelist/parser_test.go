@@ -202,6 +202,142 @@ func TestParser_Parse_checksums(t *testing.T) {
 	assert.Equal(t, gotWithoutComments, gotWithComments)
 }
 
+func TestParser_Parse_affectingRules(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name string
+		in   string
+		want bool
+	}{{
+		name: "plain",
+		in:   testRuleTextBlocked + "@@||allowed.example^\n",
+		want: false,
+	}, {
+		name: "rewrite",
+		in:   "||rewrite.example^$dnsrewrite=192.0.2.1\n",
+		want: false,
+	}, {
+		name: "comment",
+		in:   "! ||example.org^$badfilter\n",
+		want: false,
+	}, {
+		name: "badfilter",
+		in:   testRuleTextBlocked + "||example.org^$badfilter\n",
+		want: true,
+	}, {
+		name: "rewrite_exception",
+		in:   "  @@||example.org^$dnsrewrite\n",
+		want: true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			buf := make([]byte, rulelist.DefaultRuleBufSize)
+			r, err := rulelist.NewParser().Parse(&bytes.Buffer{}, strings.NewReader(tc.in), buf)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.want, r.HasAffectingRules)
+		})
+	}
+}
+
+func TestParser_Parse_skippedRules(t *testing.T) {
+	t.Parallel()
+
+	const (
+		badRule1 = "||bad-1.example^$nonexistent_modifier"
+		badRule2 = "||bad-2.example^$nonexistent_modifier"
+	)
+
+	const in = testRuleTextTitle +
+		testRuleTextBlocked +
+		"example.org##.banner\n" +
+		badRule1 + "\n" +
+		"# Comment.\n" +
+		"0.0.0.0 hosts.example\n" +
+		"  " + badRule2 + "\n"
+
+	buf := make([]byte, rulelist.DefaultRuleBufSize)
+
+	p := rulelist.NewParser()
+	r, err := p.Parse(&bytes.Buffer{}, strings.NewReader(in), buf)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+
+	assert.Equal(t, 5, r.RulesCount)
+	assert.Equal(t, 2, r.SkippedCount)
+
+	require.Len(t, r.SkippedRules, 2)
+
+	assert.Equal(t, 4, r.SkippedRules[0].Line)
+	assert.Equal(t, badRule1, r.SkippedRules[0].Text)
+	assert.NotEmpty(t, r.SkippedRules[0].Reason)
+
+	assert.Equal(t, 7, r.SkippedRules[1].Line)
+	assert.Equal(t, badRule2, r.SkippedRules[1].Text)
+	assert.NotEmpty(t, r.SkippedRules[1].Reason)
+}
+
+func TestParser_Parse_skippedRulesLimit(t *testing.T) {
+	t.Parallel()
+
+	const badNum = rulelist.MaxSkippedRules + 10
+
+	badRule := "||" + strings.Repeat("a", 200) + ".example^$nonexistent_modifier\n"
+	in := testRuleTextBlocked + strings.Repeat(badRule, badNum)
+
+	buf := make([]byte, rulelist.DefaultRuleBufSize)
+
+	p := rulelist.NewParser()
+	r, err := p.Parse(&bytes.Buffer{}, strings.NewReader(in), buf)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+
+	assert.Equal(t, badNum+1, r.RulesCount)
+	assert.Equal(t, badNum, r.SkippedCount)
+
+	require.Len(t, r.SkippedRules, rulelist.MaxSkippedRules)
+
+	last := r.SkippedRules[len(r.SkippedRules)-1]
+	assert.Equal(t, rulelist.MaxSkippedRules+1, last.Line)
+	assert.Less(t, len(last.Text), len(badRule))
+}
+
+func TestParser_WriteSkippedRules(t *testing.T) {
+	t.Parallel()
+
+	const in = testRuleTextTitle +
+		"# Comment.\n" +
+		testRuleTextBlocked +
+		"||bad.example^$nonexistent_modifier\n"
+
+	buf := make([]byte, rulelist.DefaultRuleBufSize)
+	stored := &bytes.Buffer{}
+
+	p := rulelist.NewParser()
+	want, err := p.Parse(stored, strings.NewReader(in), buf)
+	require.NoError(t, err)
+
+	_, err = p.WriteSkippedRules(stored)
+	require.NoError(t, err)
+
+	p = rulelist.NewStoredParser()
+	got, err := p.Parse(&bytes.Buffer{}, stored, buf)
+	require.NoError(t, err)
+
+	assert.Equal(t, want.RulesCount, got.RulesCount)
+	assert.Equal(t, want.Checksum, got.Checksum)
+	assert.Equal(t, 1, got.SkippedCount)
+	assert.Equal(t, want.SkippedRules, got.SkippedRules)
+
+	require.Len(t, got.SkippedRules, 1)
+
+	assert.Equal(t, 4, got.SkippedRules[0].Line)
+}
+
 var (
 	resSink *rulelist.ParseResult
 	errSink error
@@ -1,6 +1,14 @@
 package filtering
 
-import "context"
+import (
+	"context"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/netutil"
+)
 
 // SafeSearch interface describes a service for search engines hosts rewrites.
 type SafeSearch interface {
@@ -14,6 +22,39 @@ type SafeSearch interface {
 	Update(ctx context.Context, conf SafeSearchConfig) (err error)
 }
 
+// SafeSearchHealth is the interface for checking the health of the hosts
+// enforced by safe search, such as forcesafesearch.google.com.
+type SafeSearchHealth interface {
+	// Health returns the health of the safe search providers by their names.
+	// Health must be safe for concurrent use.
+	Health() (h map[string]*SafeSearchProviderHealth)
+
+	// ShouldBlock returns true if the requests rewritten to the enforced host
+	// target must be blocked, because target fails to resolve.  ShouldBlock
+	// must be safe for concurrent use.
+	ShouldBlock(target string) (ok bool)
+}
+
+// SafeSearchProviderHealth is the health of the enforced hosts of a single safe
+// search provider.
+type SafeSearchProviderHealth struct {
+	// LastCheck is the time of the last check.  It's zero if the provider
+	// hasn't been checked yet.
+	LastCheck time.Time `json:"last_check"`
+
+	// Error is the error of the last failed resolution of the enforced hosts,
+	// if any.
+	Error string `json:"error,omitempty"`
+
+	// Targets are the enforced hosts of the provider.  It's empty if the
+	// provider only rewrites to IP addresses.
+	Targets []string `json:"targets"`
+
+	// Healthy is true if all of Targets have been resolved successfully during
+	// the last check.
+	Healthy bool `json:"healthy"`
+}
+
 // SafeSearchConfig is a struct with safe search related settings.
 type SafeSearchConfig struct {
 	// Enabled indicates if safe search is enabled entirely.
@@ -23,12 +64,59 @@ type SafeSearchConfig struct {
 	// enabled or disabled.
 
 	Bing       bool `yaml:"bing" json:"bing"`
+	Brave      bool `yaml:"brave" json:"brave"`
 	DuckDuckGo bool `yaml:"duckduckgo" json:"duckduckgo"`
 	Ecosia     bool `yaml:"ecosia" json:"ecosia"`
 	Google     bool `yaml:"google" json:"google"`
 	Pixabay    bool `yaml:"pixabay" json:"pixabay"`
 	Yandex     bool `yaml:"yandex" json:"yandex"`
 	YouTube    bool `yaml:"youtube" json:"youtube"`
+
+	// CustomEngines are the user-defined search engines, which are checked
+	// after the built-in ones.
+	CustomEngines []*SafeSearchEngine `yaml:"custom_engines,omitempty" json:"custom_engines,omitempty"`
+}
+
+// SafeSearchEngine is the configuration of a user-defined safe search engine.
+type SafeSearchEngine struct {
+	// Name is the name of the engine.
+	Name string `yaml:"name" json:"name"`
+
+	// SafeSearchCNAME is the host name the requests for the matching domains
+	// are rewritten to.
+	SafeSearchCNAME string `yaml:"safe_search_cname" json:"safe_search_cname"`
+
+	// MatchDomains are the glob patterns of the domain names of the engine,
+	// such as "*.search.example".  See [path.Match] for the syntax.
+	MatchDomains []string `yaml:"match_domains" json:"match_domains"`
+}
+
+// Validate returns an error if the engine configuration is invalid.  e must
+// not be nil.
+func (e *SafeSearchEngine) Validate() (err error) {
+	if e.Name == "" {
+		return fmt.Errorf("name: %w", errors.ErrEmptyValue)
+	}
+
+	defer func() { err = errors.Annotate(err, "engine %q: %w", e.Name) }()
+
+	err = netutil.ValidateHostname(e.SafeSearchCNAME)
+	if err != nil {
+		return fmt.Errorf("safe_search_cname: %w", err)
+	}
+
+	if len(e.MatchDomains) == 0 {
+		return fmt.Errorf("match_domains: %w", errors.ErrEmptyValue)
+	}
+
+	for i, pattern := range e.MatchDomains {
+		_, err = path.Match(pattern, "")
+		if err != nil {
+			return fmt.Errorf("match_domains: at index %d: %w", i, err)
+		}
+	}
+
+	return nil
 }
 
 // checkSafeSearch checks host with safe search engine.  Matches
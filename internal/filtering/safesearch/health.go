@@ -0,0 +1,277 @@
+package safesearch
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/golibs/container"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/AdguardTeam/golibs/service"
+)
+
+// healthCheckTimeout is the timeout for resolving a single enforced host.
+const healthCheckTimeout = 10 * time.Second
+
+// errNoAddresses is returned when an enforced host resolves to no addresses.
+const errNoAddresses errors.Error = "no addresses"
+
+// HealthCheckerConfig is the configuration structure for [HealthChecker].
+type HealthCheckerConfig struct {
+	// Logger is used for logging the health checks.  It must not be nil.
+	Logger *slog.Logger
+
+	// Resolver is used to resolve the enforced hosts.  It must not be nil.
+	Resolver filtering.Resolver
+
+	// Interval is the interval between the checks.  It must be positive.
+	Interval time.Duration
+
+	// FailClosed, if true, makes [HealthChecker.ShouldBlock] report the hosts
+	// that have failed to resolve during the last check.
+	FailClosed bool
+}
+
+// HealthChecker periodically resolves the hosts enforced by safe search, such
+// as forcesafesearch.google.com, and reports the health of the providers.
+type HealthChecker struct {
+	// logger is used for logging the health checks.
+	logger *slog.Logger
+
+	// resolver is used to resolve the enforced hosts.
+	resolver filtering.Resolver
+
+	// mu protects providers, failed, and done.
+	mu *sync.RWMutex
+
+	// providers is the health of the providers as of the last check.
+	providers map[Service]*filtering.SafeSearchProviderHealth
+
+	// failed is the set of the enforced hosts that have failed to resolve
+	// during the last check.
+	failed *container.MapSet[string]
+
+	// done is closed to stop the periodic checks.  It's nil if the checks
+	// aren't running.
+	done chan struct{}
+
+	// targets are the enforced hosts of the providers.
+	targets map[Service][]string
+
+	// now returns the current time.  It's replaced in tests.
+	now func() (t time.Time)
+
+	// interval is the interval between the checks.
+	interval time.Duration
+
+	// failClosed, if true, makes ShouldBlock report the failed hosts.
+	failClosed bool
+}
+
+// NewHealthChecker returns a new properly initialized *HealthChecker.  conf
+// must not be nil.
+func NewHealthChecker(conf *HealthCheckerConfig) (c *HealthChecker) {
+	targets := enforcedTargets()
+
+	providers := make(map[Service]*filtering.SafeSearchProviderHealth, len(targets))
+	for service, serviceTargets := range targets {
+		providers[service] = &filtering.SafeSearchProviderHealth{
+			Targets: serviceTargets,
+			// Providers without enforced hosts only rewrite to IP addresses,
+			// so there is nothing to check.
+			Healthy: len(serviceTargets) == 0,
+		}
+	}
+
+	return &HealthChecker{
+		logger:     conf.Logger,
+		resolver:   conf.Resolver,
+		mu:         &sync.RWMutex{},
+		providers:  providers,
+		failed:     container.NewMapSet[string](),
+		targets:    targets,
+		now:        time.Now,
+		interval:   conf.Interval,
+		failClosed: conf.FailClosed,
+	}
+}
+
+// enforcedTargets returns the hosts that the safe search rules of each service
+// rewrite the requests to.
+func enforcedTargets() (targets map[Service][]string) {
+	const cnamePrefix = "$dnsrewrite=NOERROR;CNAME;"
+
+	targets = make(map[Service][]string, len(safeSearchRules))
+	for service, serviceRules := range safeSearchRules {
+		set := container.NewMapSet[string]()
+
+		s := bufio.NewScanner(strings.NewReader(serviceRules))
+		for s.Scan() {
+			_, target, ok := strings.Cut(s.Text(), cnamePrefix)
+			if ok && target != "" {
+				set.Add(strings.ToLower(target))
+			}
+		}
+
+		targets[service] = slices.Sorted(set.Range)
+	}
+
+	return targets
+}
+
+// type check
+var _ service.Interface = (*HealthChecker)(nil)
+
+// Start implements the [service.Interface] for *HealthChecker.  It checks the
+// enforced hosts in a separate goroutine until [HealthChecker.Shutdown] is
+// called.
+func (c *HealthChecker) Start(ctx context.Context) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.done != nil {
+		return errors.Error("already started")
+	}
+
+	c.done = make(chan struct{})
+
+	go c.run(ctx, c.done)
+
+	return nil
+}
+
+// Shutdown implements the [service.Interface] for *HealthChecker.
+func (c *HealthChecker) Shutdown(_ context.Context) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.done != nil {
+		close(c.done)
+		c.done = nil
+	}
+
+	return nil
+}
+
+// run checks the enforced hosts periodically until done is closed.  It is
+// intended to be used as a goroutine.
+func (c *HealthChecker) run(ctx context.Context, done <-chan struct{}) {
+	defer slogutil.RecoverAndLog(ctx, c.logger)
+
+	t := time.NewTicker(c.interval)
+	defer t.Stop()
+
+	for {
+		c.Refresh(ctx)
+
+		select {
+		case <-done:
+			return
+		case <-t.C:
+			// Go on.
+		}
+	}
+}
+
+// Refresh resolves all enforced hosts and updates the health of the
+// providers.  The hosts that fail to resolve are logged as errors.
+func (c *HealthChecker) Refresh(ctx context.Context) {
+	now := c.now()
+
+	failed := container.NewMapSet[string]()
+	providers := make(map[Service]*filtering.SafeSearchProviderHealth, len(c.targets))
+	for service, targets := range c.targets {
+		h := &filtering.SafeSearchProviderHealth{
+			LastCheck: now,
+			Targets:   targets,
+			Healthy:   true,
+		}
+
+		for _, target := range targets {
+			err := c.check(ctx, target)
+			if err != nil {
+				c.logger.ErrorContext(
+					ctx,
+					"enforced host does not resolve; safe search may not work",
+					"service", service,
+					"target", target,
+					slogutil.KeyError, err,
+				)
+
+				failed.Add(target)
+				h.Healthy = false
+				h.Error = fmt.Sprintf("%s: %s", target, err)
+			}
+		}
+
+		providers[service] = h
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for target := range c.failed.Range {
+		if !failed.Has(target) {
+			c.logger.InfoContext(ctx, "enforced host resolves again", "target", target)
+		}
+	}
+
+	c.providers = providers
+	c.failed = failed
+}
+
+// check returns an error if target doesn't resolve to any addresses.
+func (c *HealthChecker) check(ctx context.Context, target string) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	ips, err := c.resolver.LookupIP(ctx, "ip", target)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	} else if len(ips) == 0 {
+		return errNoAddresses
+	}
+
+	return nil
+}
+
+// type check
+var _ filtering.SafeSearchHealth = (*HealthChecker)(nil)
+
+// Health implements the [filtering.SafeSearchHealth] interface for
+// *HealthChecker.
+func (c *HealthChecker) Health() (h map[string]*filtering.SafeSearchProviderHealth) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	h = make(map[string]*filtering.SafeSearchProviderHealth, len(c.providers))
+	for service, p := range c.providers {
+		ph := *p
+		ph.Targets = slices.Clone(ph.Targets)
+
+		h[string(service)] = &ph
+	}
+
+	return h
+}
+
+// ShouldBlock implements the [filtering.SafeSearchHealth] interface for
+// *HealthChecker.
+func (c *HealthChecker) ShouldBlock(target string) (ok bool) {
+	if !c.failClosed {
+		return false
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.failed.Has(strings.ToLower(target))
+}
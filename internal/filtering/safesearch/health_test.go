@@ -0,0 +1,145 @@
+package safesearch_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering/safesearch"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testDeadTarget is the enforced host of Google, which is simulated to fail to
+// resolve.
+const testDeadTarget = "forcesafesearch.google.com"
+
+// newTestResolver returns a resolver that fails to resolve the hosts in dead
+// and resolves all other hosts to a single address.
+func newTestResolver(dead map[string]bool) (r *aghtest.Resolver) {
+	return &aghtest.Resolver{
+		OnLookupIP: func(_ context.Context, _, host string) (ips []net.IP, err error) {
+			if dead[host] {
+				return nil, errors.Error("no such host")
+			}
+
+			return []net.IP{{192, 0, 2, 1}}, nil
+		},
+	}
+}
+
+func TestHealthChecker_Refresh(t *testing.T) {
+	dead := map[string]bool{testDeadTarget: true}
+
+	c := safesearch.NewHealthChecker(&safesearch.HealthCheckerConfig{
+		Logger:     slogutil.NewDiscardLogger(),
+		Resolver:   newTestResolver(dead),
+		Interval:   testCacheTTL,
+		FailClosed: true,
+	})
+
+	h := c.Health()
+	require.Contains(t, h, string(safesearch.Google))
+
+	assert.True(t, h[string(safesearch.Google)].LastCheck.IsZero())
+	assert.False(t, h[string(safesearch.Google)].Healthy)
+	assert.True(t, h[string(safesearch.Yandex)].Healthy)
+	assert.Empty(t, h[string(safesearch.Yandex)].Targets)
+	assert.False(t, c.ShouldBlock(testDeadTarget))
+
+	ctx := testutil.ContextWithTimeout(t, testTimeout)
+	c.Refresh(ctx)
+
+	h = c.Health()
+
+	google := h[string(safesearch.Google)]
+	require.NotNil(t, google)
+
+	assert.False(t, google.LastCheck.IsZero())
+	assert.False(t, google.Healthy)
+	assert.Equal(t, []string{testDeadTarget}, google.Targets)
+	assert.Equal(t, testDeadTarget+": no such host", google.Error)
+
+	bing := h[string(safesearch.Bing)]
+	require.NotNil(t, bing)
+
+	assert.True(t, bing.Healthy)
+	assert.Equal(t, []string{"strict.bing.com"}, bing.Targets)
+	assert.Empty(t, bing.Error)
+
+	assert.True(t, c.ShouldBlock(testDeadTarget))
+	assert.False(t, c.ShouldBlock("strict.bing.com"))
+
+	delete(dead, testDeadTarget)
+	c.Refresh(ctx)
+
+	assert.True(t, c.Health()[string(safesearch.Google)].Healthy)
+	assert.False(t, c.ShouldBlock(testDeadTarget))
+}
+
+func TestDefault_CheckHost_failClosed(t *testing.T) {
+	testCases := []struct {
+		name        string
+		failClosed  bool
+		wantBlocked bool
+	}{{
+		name:        "fail_open",
+		failClosed:  false,
+		wantBlocked: false,
+	}, {
+		name:        "fail_closed",
+		failClosed:  true,
+		wantBlocked: true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := testutil.ContextWithTimeout(t, testTimeout)
+
+			c := safesearch.NewHealthChecker(&safesearch.HealthCheckerConfig{
+				Logger:     slogutil.NewDiscardLogger(),
+				Resolver:   newTestResolver(map[string]bool{testDeadTarget: true}),
+				Interval:   testCacheTTL,
+				FailClosed: tc.failClosed,
+			})
+			c.Refresh(ctx)
+
+			ss, err := safesearch.NewDefault(ctx, &safesearch.DefaultConfig{
+				Logger:         slogutil.NewDiscardLogger(),
+				ServicesConfig: testConf,
+				CacheSize:      testCacheSize,
+				CacheTTL:       testCacheTTL,
+				Health:         c,
+			})
+			require.NoError(t, err)
+
+			// Check twice to make sure that the cached results are blocked as
+			// well.
+			for range 2 {
+				var res filtering.Result
+				res, err = ss.CheckHost(ctx, "www.google.com", testQType)
+				require.NoError(t, err)
+
+				assert.True(t, res.IsFiltered)
+				assert.Equal(t, filtering.FilteredSafeSearch, res.Reason)
+
+				if tc.wantBlocked {
+					assert.Empty(t, res.CanonName)
+				} else {
+					assert.Equal(t, testDeadTarget, res.CanonName)
+				}
+			}
+
+			// Other providers aren't affected.
+			res, err := ss.CheckHost(ctx, "www.bing.com", testQType)
+			require.NoError(t, err)
+
+			assert.Equal(t, "strict.bing.com", res.CanonName)
+		})
+	}
+}
@@ -9,6 +9,8 @@ import (
 	"fmt"
 	"log/slog"
 	"net/netip"
+	"path"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -36,6 +38,7 @@ type Service string
 // Service enum members.
 const (
 	Bing       Service = "bing"
+	Brave      Service = "brave"
 	DuckDuckGo Service = "duckduckgo"
 	Ecosia     Service = "ecosia"
 	Google     Service = "google"
@@ -49,6 +52,8 @@ func isServiceProtected(s filtering.SafeSearchConfig, service Service) (ok bool)
 	switch service {
 	case Bing:
 		return s.Bing
+	case Brave:
+		return s.Brave
 	case DuckDuckGo:
 		return s.DuckDuckGo
 	case Ecosia:
@@ -84,6 +89,10 @@ type DefaultConfig struct {
 	// ServicesConfig contains safe search settings for services.  It must not
 	// be nil.
 	ServicesConfig filtering.SafeSearchConfig
+
+	// Health is used to block the requests rewritten to the enforced hosts
+	// that fail to resolve.  If nil, the requests are never blocked.
+	Health filtering.SafeSearchHealth
 }
 
 // Default is the default safe search filter that uses filtering rules with the
@@ -92,16 +101,24 @@ type Default struct {
 	// logger is used for logging the operation of the safe search filter.
 	logger *slog.Logger
 
-	// mu protects engine.
+	// mu protects engine and customEngines.
 	mu *sync.RWMutex
 
 	// engine is the filtering engine that contains the DNS rewrite rules.
 	// engine may be nil, which means that this safe search filter is disabled.
 	engine *urlfilter.DNSEngine
 
+	// customEngines are the user-defined search engines, which are checked
+	// after engine.
+	customEngines []*filtering.SafeSearchEngine
+
 	// cache stores safe search filtering results.
 	cache cache.Cache
 
+	// health is used to block the requests rewritten to the enforced hosts
+	// that fail to resolve.  It may be nil.
+	health filtering.SafeSearchHealth
+
 	// cacheTTL is the Time to Live duration for cached items.
 	cacheTTL time.Duration
 }
@@ -116,6 +133,7 @@ func NewDefault(ctx context.Context, conf *DefaultConfig) (ss *Default, err erro
 			EnableLRU: true,
 			MaxSize:   conf.CacheSize,
 		}),
+		health:   conf.Health,
 		cacheTTL: conf.CacheTTL,
 	}
 
@@ -136,6 +154,13 @@ func (ss *Default) resetEngine(
 	listID int,
 	conf filtering.SafeSearchConfig,
 ) (err error) {
+	for i, e := range conf.CustomEngines {
+		err = e.Validate()
+		if err != nil {
+			return fmt.Errorf("custom engine at index %d: %w", i, err)
+		}
+	}
+
 	if !conf.Enabled {
 		ss.logger.DebugContext(ctx, "disabled")
 
@@ -161,8 +186,14 @@ func (ss *Default) resetEngine(
 	}
 
 	ss.engine = urlfilter.NewDNSEngine(rs)
+	ss.customEngines = slices.Clone(conf.CustomEngines)
 
-	ss.logger.InfoContext(ctx, "reset rules", "count", ss.engine.RulesCount)
+	ss.logger.InfoContext(
+		ctx,
+		"reset rules",
+		"count", ss.engine.RulesCount,
+		"custom_engines", len(ss.customEngines),
+	)
 
 	return nil
 }
@@ -193,10 +224,14 @@ func (ss *Default) CheckHost(
 	if isFound {
 		ss.logger.DebugContext(ctx, "found in cache", "host", host)
 
-		return cachedValue, nil
+		return ss.blockIfUnhealthy(ctx, host, cachedValue), nil
 	}
 
 	rewrite := ss.searchHost(host, qtype)
+	if rewrite == nil {
+		rewrite = ss.searchCustomEngines(host)
+	}
+
 	if rewrite == nil {
 		return filtering.Result{}, nil
 	}
@@ -214,7 +249,29 @@ func (ss *Default) CheckHost(
 	// saving results to cache.
 	ss.setCacheResult(ctx, host, qtype, res)
 
-	return res, nil
+	return ss.blockIfUnhealthy(ctx, host, res), nil
+}
+
+// blockIfUnhealthy returns a result without any records, which is converted
+// into a NODATA response, if the enforced host res is rewritten to fails to
+// resolve and the requests to it must be blocked.  Otherwise, it returns res.
+// The blocked results aren't cached, so that the rewrites are restored as soon
+// as the enforced host resolves again.
+func (ss *Default) blockIfUnhealthy(
+	ctx context.Context,
+	host string,
+	res filtering.Result,
+) (blocked filtering.Result) {
+	if ss.health == nil || res.CanonName == "" || !ss.health.ShouldBlock(res.CanonName) {
+		return res
+	}
+
+	ss.logger.DebugContext(ctx, "enforced host is unhealthy", "host", host, "target", res.CanonName)
+
+	return filtering.Result{
+		Reason:     filtering.FilteredSafeSearch,
+		IsFiltered: true,
+	}
 }
 
 // searchHost looks up DNS rewrites in the internal DNS filtering engine.
@@ -239,6 +296,27 @@ func (ss *Default) searchHost(host string, qtype rules.RRType) (res *rules.DNSRe
 	return nil
 }
 
+// searchCustomEngines looks up host in the domains of the user-defined search
+// engines and returns the CNAME rewrite of the first matching one, if any.
+func (ss *Default) searchCustomEngines(host string) (res *rules.DNSRewrite) {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, e := range ss.customEngines {
+		for _, pattern := range e.MatchDomains {
+			// The patterns are validated in [filtering.SafeSearchEngine.Validate].
+			if ok, _ := path.Match(strings.ToLower(pattern), host); ok {
+				return &rules.DNSRewrite{
+					NewCNAME: e.SafeSearchCNAME,
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
 // newResult creates Result object from rewrite rule.  qtype must be either
 // [dns.TypeA] or [dns.TypeAAAA], or [dns.TypeHTTPS].  If err is nil, res is
 // never nil, so that the empty result is converted into a NODATA response.
@@ -29,6 +29,7 @@ const testTimeout = 1 * time.Second
 var defaultSafeSearchConf = filtering.SafeSearchConfig{
 	Enabled:    true,
 	Bing:       true,
+	Brave:      true,
 	DuckDuckGo: true,
 	Ecosia:     true,
 	Google:     true,
@@ -58,6 +59,49 @@ func TestSafeSearch(t *testing.T) {
 	assert.Equal(t, &rules.DNSRewrite{NewCNAME: "forcesafesearch.google.com"}, val)
 }
 
+func TestSafeSearch_engines(t *testing.T) {
+	ss := newForTest(t, defaultSafeSearchConf)
+
+	testCases := []struct {
+		name      string
+		host      string
+		wantCNAME string
+	}{{
+		name:      "brave",
+		host:      "search.brave.com",
+		wantCNAME: "forcesafe.search.brave.com",
+	}, {
+		name:      "ecosia",
+		host:      "www.ecosia.org",
+		wantCNAME: "strict-safe-search.ecosia.org",
+	}, {
+		name:      "brave_other",
+		host:      "brave.com",
+		wantCNAME: "",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			val := ss.searchHost(tc.host, testQType)
+			if tc.wantCNAME == "" {
+				assert.Nil(t, val)
+
+				return
+			}
+
+			assert.Equal(t, &rules.DNSRewrite{NewCNAME: tc.wantCNAME}, val)
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		conf := defaultSafeSearchConf
+		conf.Brave = false
+
+		val := newForTest(t, conf).searchHost("search.brave.com", testQType)
+		assert.Nil(t, val)
+	})
+}
+
 func TestSafeSearchCacheYandex(t *testing.T) {
 	const domain = "yandex.ru"
 
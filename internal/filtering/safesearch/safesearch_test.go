@@ -33,6 +33,7 @@ var testConf = filtering.SafeSearchConfig{
 	Enabled: true,
 
 	Bing:       true,
+	Brave:      true,
 	DuckDuckGo: true,
 	Ecosia:     true,
 	Google:     true,
@@ -220,3 +221,111 @@ func TestDefault_Update(t *testing.T) {
 
 	assert.False(t, res.IsFiltered)
 }
+
+func TestDefault_CheckHost_customEngine(t *testing.T) {
+	const safeCNAME = "safe.search.example.com"
+
+	conf := testConf
+	conf.CustomEngines = []*filtering.SafeSearchEngine{{
+		Name:            "Example Search",
+		SafeSearchCNAME: safeCNAME,
+		MatchDomains:    []string{"search.example.com", "*.search.example.com"},
+	}}
+
+	ctx := testutil.ContextWithTimeout(t, testTimeout)
+	ss, err := safesearch.NewDefault(ctx, &safesearch.DefaultConfig{
+		Logger:         slogutil.NewDiscardLogger(),
+		ServicesConfig: conf,
+		CacheSize:      testCacheSize,
+		CacheTTL:       testCacheTTL,
+	})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name      string
+		host      string
+		wantCNAME string
+	}{{
+		name:      "exact",
+		host:      "search.example.com",
+		wantCNAME: safeCNAME,
+	}, {
+		name:      "subdomain",
+		host:      "www.search.example.com",
+		wantCNAME: safeCNAME,
+	}, {
+		name:      "case",
+		host:      "WWW.Search.Example.COM",
+		wantCNAME: safeCNAME,
+	}, {
+		name:      "builtin",
+		host:      "www.google.com",
+		wantCNAME: "forcesafesearch.google.com",
+	}, {
+		name:      "not_matched",
+		host:      "search.example.org",
+		wantCNAME: "",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var res filtering.Result
+			res, err = ss.CheckHost(ctx, tc.host, testQType)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantCNAME, res.CanonName)
+			assert.Equal(t, tc.wantCNAME != "", res.IsFiltered)
+			assert.Empty(t, res.Rules)
+		})
+	}
+}
+
+func TestNewDefault_badCustomEngine(t *testing.T) {
+	testCases := []struct {
+		engine     *filtering.SafeSearchEngine
+		name       string
+		wantErrMsg string
+	}{{
+		engine: &filtering.SafeSearchEngine{
+			SafeSearchCNAME: "safe.search.example",
+			MatchDomains:    []string{"search.example"},
+		},
+		name:       "no_name",
+		wantErrMsg: "custom engine at index 0: name: empty value",
+	}, {
+		engine: &filtering.SafeSearchEngine{
+			Name:            "example",
+			SafeSearchCNAME: "safe.search.example",
+			MatchDomains:    nil,
+		},
+		name:       "no_domains",
+		wantErrMsg: `custom engine at index 0: engine "example": match_domains: empty value`,
+	}, {
+		engine: &filtering.SafeSearchEngine{
+			Name:            "example",
+			SafeSearchCNAME: "safe.search.example",
+			MatchDomains:    []string{"[search.example"},
+		},
+		name: "bad_pattern",
+		wantErrMsg: `custom engine at index 0: engine "example": match_domains: ` +
+			`at index 0: syntax error in pattern`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conf := testConf
+			conf.CustomEngines = []*filtering.SafeSearchEngine{tc.engine}
+
+			_, err := safesearch.NewDefault(
+				testutil.ContextWithTimeout(t, testTimeout),
+				&safesearch.DefaultConfig{
+					Logger:         slogutil.NewDiscardLogger(),
+					ServicesConfig: conf,
+					CacheSize:      testCacheSize,
+					CacheTTL:       testCacheTTL,
+				},
+			)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
@@ -25,15 +25,28 @@ func (d *DNSFilter) handleSafeSearchDisable(w http.ResponseWriter, r *http.Reque
 	d.conf.ConfigModified()
 }
 
+// safeSearchStatusResp is the response for GET /control/safesearch/status HTTP
+// API.
+type safeSearchStatusResp struct {
+	// Health is the health of the hosts enforced by the providers.  It's nil
+	// if the hosts aren't checked.
+	Health map[string]*SafeSearchProviderHealth `json:"health,omitempty"`
+
+	SafeSearchConfig
+}
+
 // handleSafeSearchStatus is the handler for GET /control/safesearch/status
 // HTTP API.
 func (d *DNSFilter) handleSafeSearchStatus(w http.ResponseWriter, r *http.Request) {
-	var resp SafeSearchConfig
+	resp := &safeSearchStatusResp{}
 	func() {
 		d.confMu.RLock()
 		defer d.confMu.RUnlock()
 
-		resp = d.conf.SafeSearchConf
+		resp.SafeSearchConfig = d.conf.SafeSearchConf
+		if d.conf.SafeSearchHealth != nil {
+			resp.Health = d.conf.SafeSearchHealth.Health()
+		}
 	}()
 
 	aghhttp.WriteJSONResponseOK(w, r, resp)
@@ -0,0 +1,339 @@
+package filtering
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/AdGuardHome/internal/aghos"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering/rulelist"
+	"github.com/AdguardTeam/golibs/container"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/ioutil"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/urlfilter/rules"
+	"github.com/c2h5oh/datasize"
+	"github.com/google/renameio/v2/maybe"
+)
+
+// DefaultBlockedServicesIndexURL is the default URL of the index of the blocked
+// services.
+const DefaultBlockedServicesIndexURL = "https://adguardteam.github.io/HostlistsRegistry/assets/services.json"
+
+// servicesIndexFilename is the name of the file within the filters directory,
+// which contains the latest refreshed index of the blocked services.
+const servicesIndexFilename = "services.json"
+
+// maxServicesIndexSize is the maximum size of the index of the blocked
+// services.
+const maxServicesIndexSize = 4 * datasize.MB
+
+// servicesIndexService is a blocked service in the index.
+type servicesIndexService struct {
+	// ID is the unique identifier of the service, for example "youtube".
+	ID string `json:"id"`
+
+	// Name is the human-readable name of the service.
+	Name string `json:"name"`
+
+	// IconSVG is the SVG icon of the service.
+	IconSVG string `json:"icon_svg"`
+
+	// Rules are the filtering rules blocking the service.
+	Rules []string `json:"rules"`
+}
+
+// validate returns an error if s isn't a valid blocked service.
+func (s *servicesIndexService) validate() (err error) {
+	if s == nil {
+		return errors.ErrNoValue
+	}
+
+	var errs []error
+	if s.ID == "" {
+		errs = append(errs, fmt.Errorf("id: %w", errors.ErrEmptyValue))
+	}
+
+	if s.Name == "" {
+		errs = append(errs, fmt.Errorf("name: %w", errors.ErrEmptyValue))
+	}
+
+	if len(s.Rules) == 0 {
+		errs = append(errs, fmt.Errorf("rules: %w", errors.ErrEmptyValue))
+	}
+
+	for i, text := range s.Rules {
+		_, ruleErr := rules.NewNetworkRule(text, rulelist.URLFilterIDBlockedService)
+		if ruleErr != nil {
+			errs = append(errs, fmt.Errorf("rules: at index %d: %w", i, ruleErr))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// servicesIndexData is the index of the blocked services in the format of the
+// hostlists registry.
+type servicesIndexData struct {
+	BlockedServices []*servicesIndexService `json:"blocked_services"`
+}
+
+// validate returns an error if data isn't a valid index.
+func (data *servicesIndexData) validate() (err error) {
+	if len(data.BlockedServices) == 0 {
+		return fmt.Errorf("blocked_services: %w", errors.ErrEmptyValue)
+	}
+
+	ids := container.NewMapSet[string]()
+	for i, s := range data.BlockedServices {
+		err = s.validate()
+		if err != nil {
+			return fmt.Errorf("service at index %d: %w", i, err)
+		}
+
+		if ids.Has(s.ID) {
+			return fmt.Errorf("service at index %d: duplicate id %q", i, s.ID)
+		}
+
+		ids.Add(s.ID)
+	}
+
+	return nil
+}
+
+// services returns the blocked services from data.
+func (data *servicesIndexData) services() (svcs []blockedService) {
+	svcs = make([]blockedService, 0, len(data.BlockedServices))
+	for _, s := range data.BlockedServices {
+		svcs = append(svcs, blockedService{
+			ID:      s.ID,
+			Name:    s.Name,
+			IconSVG: []byte(s.IconSVG),
+			Rules:   s.Rules,
+		})
+	}
+
+	return svcs
+}
+
+// parseServicesIndex decodes and validates the index of the blocked services
+// from r.
+func parseServicesIndex(r io.Reader) (data *servicesIndexData, err error) {
+	data = &servicesIndexData{}
+	err = json.NewDecoder(r).Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("decoding: %w", err)
+	}
+
+	err = data.validate()
+	if err != nil {
+		return nil, fmt.Errorf("validating: %w", err)
+	}
+
+	return data, nil
+}
+
+// servicesIndexPath returns the path to the index of the blocked services
+// within dataDir.
+func servicesIndexPath(dataDir string) (p string) {
+	return filepath.Join(dataDir, filterDir, servicesIndexFilename)
+}
+
+// LoadBlockedServices replaces the embedded index of the blocked services with
+// the one previously refreshed into dataDir, if there is one.  If the index is
+// invalid, the current one is kept.  [InitModule] must be called first.
+func LoadBlockedServices(dataDir string) (err error) {
+	p := servicesIndexPath(dataDir)
+
+	// #nosec G304 -- Trust the path, since it's constructed from the data
+	// directory.
+	f, err := os.Open(p)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+	defer func() { err = errors.WithDeferred(err, f.Close()) }()
+
+	fi, err := f.Stat()
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	data, err := parseServicesIndex(ioutil.LimitReader(f, maxServicesIndexSize.Bytes()))
+	if err != nil {
+		return fmt.Errorf("%s: %w", p, err)
+	}
+
+	currentServices.Store(newServicesIndex(data.services(), servicesSourceFile, fi.ModTime()))
+
+	log.Debug("filtering: loaded %d services from %s", len(data.BlockedServices), p)
+
+	return nil
+}
+
+// servicesUpdater refreshes the index of the blocked services from the index
+// URL on the schedule of the filter lists updates.  It's safe for concurrent
+// use.
+type servicesUpdater struct {
+	// client is used to fetch the index.
+	client *http.Client
+
+	// mu serializes the refreshes and protects refreshed.
+	mu *sync.Mutex
+
+	// refreshed is the time of the latest refresh attempt.
+	refreshed time.Time
+
+	// indexURL is the URL of the index.  If empty, the index is never
+	// refreshed.
+	indexURL string
+
+	// path is the path to the file the refreshed index is saved to.
+	path string
+}
+
+// newServicesUpdater returns a new properly initialized *servicesUpdater.  If
+// indexURL is empty, the index isn't refreshed.
+func newServicesUpdater(
+	client *http.Client,
+	indexURL string,
+	dataDir string,
+) (u *servicesUpdater, err error) {
+	err = validateCatalogURL("url", indexURL, true)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &servicesUpdater{
+		client:   client,
+		mu:       &sync.Mutex{},
+		indexURL: indexURL,
+		path:     servicesIndexPath(dataDir),
+	}, nil
+}
+
+// refresh fetches the index, if ivl has passed since the previous attempt.
+// ivl of zero disables the refresh.  If the fetched index is invalid, the
+// current one is kept.
+func (u *servicesUpdater) refresh(ctx context.Context, now time.Time, ivl time.Duration) {
+	if u.indexURL == "" || ivl == 0 {
+		return
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if now.Before(u.refreshed.Add(ivl)) {
+		return
+	}
+
+	err := u.refreshLocked(ctx, now)
+	if err != nil {
+		log.Error("filtering: blocked services: refreshing: %s", err)
+	}
+}
+
+// refreshLocked fetches the index, makes it current, and saves it to the file.
+// u.mu is expected to be locked.
+func (u *servicesUpdater) refreshLocked(ctx context.Context, now time.Time) (err error) {
+	u.refreshed = now
+
+	b, data, err := u.fetch(ctx)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	currentServices.Store(newServicesIndex(data.services(), servicesSourceURL, now))
+
+	log.Debug("filtering: blocked services: refreshed %d services", len(data.BlockedServices))
+
+	// Don't return the error, since the refreshed index is used anyway.
+	err = maybe.WriteFile(u.path, b, aghos.DefaultPermFile)
+	if err != nil {
+		log.Error("filtering: blocked services: saving: %s", err)
+	}
+
+	return nil
+}
+
+// fetch fetches and validates the index.  b is the raw data of the index.
+func (u *servicesUpdater) fetch(ctx context.Context) (b []byte, data *servicesIndexData, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.indexURL, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, nil, err
+	}
+	defer func() { err = errors.WithDeferred(err, resp.Body.Close()) }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf(
+			"got status code %d, want %d",
+			resp.StatusCode,
+			http.StatusOK,
+		)
+	}
+
+	b, err = io.ReadAll(ioutil.LimitReader(resp.Body, maxServicesIndexSize.Bytes()))
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading: %w", err)
+	}
+
+	data, err = parseServicesIndex(bytes.NewReader(b))
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, nil, err
+	}
+
+	return b, data, nil
+}
+
+// handleBlockedServicesRefresh is the handler for the POST
+// /control/blocked_services/refresh HTTP API.  It refreshes the index
+// immediately and responds with the same data as the GET
+// /control/blocked_services/all HTTP API.
+func (d *DNSFilter) handleBlockedServicesRefresh(w http.ResponseWriter, r *http.Request) {
+	u := d.servicesUpdater
+	if u.indexURL == "" {
+		aghhttp.Error(r, w, http.StatusBadRequest, "blocked services index url is not set")
+
+		return
+	}
+
+	err := func() (err error) {
+		u.mu.Lock()
+		defer u.mu.Unlock()
+
+		return u.refreshLocked(r.Context(), time.Now())
+	}()
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadGateway, "refreshing blocked services: %s", err)
+
+		return
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, newBlockedServicesAllResp(currentServices.Load()))
+}
@@ -0,0 +1,153 @@
+package filtering
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghos"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testServicesIndex is a valid index of the blocked services with a single
+// service not present in the embedded index.
+const testServicesIndex = `{"blocked_services":[{` +
+	`"id":"test_service",` +
+	`"name":"Test Service",` +
+	`"icon_svg":"<svg></svg>",` +
+	`"rules":["||blocked.example^"]` +
+	`}]}`
+
+// newTestServicesDataDir returns a temporary data directory with the filters
+// directory containing the index of the blocked services with data, if any.
+func newTestServicesDataDir(t *testing.T, data string) (dataDir string) {
+	t.Helper()
+
+	dataDir = t.TempDir()
+	err := os.MkdirAll(filepath.Join(dataDir, filterDir), aghos.DefaultPermDir)
+	require.NoError(t, err)
+
+	if data != "" {
+		err = os.WriteFile(servicesIndexPath(dataDir), []byte(data), aghos.DefaultPermFile)
+		require.NoError(t, err)
+	}
+
+	return dataDir
+}
+
+// assertServiceBlocked checks that the host is blocked by the service with the
+// given ID.
+func assertServiceBlocked(t *testing.T, id, host string) {
+	t.Helper()
+
+	bsvc := &BlockedServices{IDs: []string{id}}
+	require.NoError(t, bsvc.Validate())
+
+	setts := &Settings{
+		ProtectionEnabled: true,
+		ServicesRules:     appendServiceEntries(nil, bsvc.IDs),
+	}
+
+	res, err := matchBlockedServicesRules(host, dns.TypeA, setts)
+	require.NoError(t, err)
+
+	assert.True(t, res.IsFiltered)
+	assert.Equal(t, FilteredBlockedService, res.Reason)
+	assert.Equal(t, id, res.ServiceName)
+}
+
+func TestLoadBlockedServices(t *testing.T) {
+	// Don't use t.Parallel, since the index is global.
+	initBlockedServices()
+	t.Cleanup(initBlockedServices)
+
+	t.Run("no_file", func(t *testing.T) {
+		err := LoadBlockedServices(newTestServicesDataDir(t, ""))
+		require.NoError(t, err)
+
+		assert.Equal(t, servicesSourceEmbedded, currentServices.Load().source)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		dataDir := newTestServicesDataDir(t, `{"blocked_services":[{"id":"test_service"}]}`)
+		err := LoadBlockedServices(dataDir)
+
+		wantErrMsg := servicesIndexPath(dataDir) + `: validating: service at index 0: ` +
+			"name: empty value\n" +
+			"rules: empty value"
+		testutil.AssertErrorMsg(t, wantErrMsg, err)
+
+		assert.Equal(t, servicesSourceEmbedded, currentServices.Load().source)
+		assert.Error(t, (&BlockedServices{IDs: []string{"test_service"}}).Validate())
+	})
+
+	t.Run("custom", func(t *testing.T) {
+		err := LoadBlockedServices(newTestServicesDataDir(t, testServicesIndex))
+		require.NoError(t, err)
+
+		idx := currentServices.Load()
+		assert.Equal(t, servicesSourceFile, idx.source)
+		assert.Equal(t, []string{"test_service"}, idx.ids)
+
+		assertServiceBlocked(t, "test_service", "sub.blocked.example")
+
+		resp := newBlockedServicesAllResp(idx)
+		require.NotNil(t, resp.UpdatedAt)
+		require.Len(t, resp.BlockedServices, 1)
+
+		assert.Equal(t, []byte("<svg></svg>"), resp.BlockedServices[0].IconSVG)
+	})
+}
+
+func TestServicesUpdater_refresh(t *testing.T) {
+	// Don't use t.Parallel, since the index is global.
+	initBlockedServices()
+	t.Cleanup(initBlockedServices)
+
+	respData := `{"blocked_services":[]}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(respData))
+	}))
+	t.Cleanup(srv.Close)
+
+	dataDir := newTestServicesDataDir(t, "")
+	u, err := newServicesUpdater(srv.Client(), srv.URL, dataDir)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	now := time.Now()
+
+	u.refresh(ctx, now, time.Hour)
+	assert.Equal(t, servicesSourceEmbedded, currentServices.Load().source)
+
+	respData = testServicesIndex
+
+	// Not due yet.
+	u.refresh(ctx, now.Add(time.Minute), time.Hour)
+	assert.Equal(t, servicesSourceEmbedded, currentServices.Load().source)
+
+	now = now.Add(time.Hour)
+	u.refresh(ctx, now, time.Hour)
+
+	idx := currentServices.Load()
+	assert.Equal(t, servicesSourceURL, idx.source)
+	assert.Equal(t, now, idx.updated)
+
+	assertServiceBlocked(t, "test_service", "blocked.example")
+
+	// The refreshed index must be loaded after the restart.
+	initBlockedServices()
+
+	err = LoadBlockedServices(dataDir)
+	require.NoError(t, err)
+
+	assert.Equal(t, servicesSourceFile, currentServices.Load().source)
+	assertServiceBlocked(t, "test_service", "blocked.example")
+}
@@ -0,0 +1,172 @@
+package filtering
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering/rulelist"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+)
+
+// SuffixSet is the set of the domain name suffixes, such as whole TLDs, which
+// block all their subdomains.  It's matched using a trie of the labels, so the
+// time of the matching doesn't depend on the number of the suffixes.
+type SuffixSet struct {
+	// root is the root node of the trie.  Its children are the TLDs.
+	root *suffixNode
+}
+
+// suffixNode is a node of the trie of the domain name labels.
+type suffixNode struct {
+	// children are the nodes of the labels to the left of this one.  It's nil
+	// if there are none.
+	children map[string]*suffixNode
+
+	// isSuffix is true if the labels from the root to this node form a suffix
+	// of the set.
+	isSuffix bool
+}
+
+// NewSuffixSet parses the list of suffixes.  Each suffix is a domain name, the
+// leading dot or the "*." prefix is optional, so both ".zip" and "zip" block
+// all the subdomains of the TLD "zip".  Empty lines and comments starting with
+// "#" or "!" are allowed.  s is nil if there are no suffixes.
+func NewSuffixSet(suffixes []string) (s *SuffixSet, err error) {
+	s = &SuffixSet{
+		root: &suffixNode{},
+	}
+
+	empty := true
+	for i, text := range suffixes {
+		suffix := normalizeSuffix(text)
+		if suffix == "" {
+			continue
+		}
+
+		err = netutil.ValidateDomainName(suffix)
+		if err != nil {
+			return nil, fmt.Errorf("suffix at index %d: %w", i, err)
+		}
+
+		s.add(suffix)
+		empty = false
+	}
+
+	if empty {
+		return nil, nil
+	}
+
+	return s, nil
+}
+
+// normalizeSuffix returns the suffix from a line of a suffix list.  suffix is
+// empty if the line is empty or is a comment.
+func normalizeSuffix(text string) (suffix string) {
+	text = strings.TrimSpace(text)
+	if text == "" || text[0] == '#' || text[0] == '!' {
+		return ""
+	}
+
+	text = strings.TrimPrefix(text, "*")
+	text = strings.TrimPrefix(text, ".")
+	text = strings.TrimSuffix(text, ".")
+
+	return strings.ToLower(text)
+}
+
+// add adds suffix to the trie.  suffix must be a valid domain name.
+func (s *SuffixSet) add(suffix string) {
+	n := s.root
+	for label, rest := suffixLastLabel(suffix); label != ""; label, rest = suffixLastLabel(rest) {
+		if n.children == nil {
+			n.children = map[string]*suffixNode{}
+		}
+
+		next, ok := n.children[label]
+		if !ok {
+			next = &suffixNode{}
+			n.children[label] = next
+		}
+
+		n = next
+	}
+
+	n.isSuffix = true
+}
+
+// suffixLastLabel returns the rightmost label of name and the part of name to
+// the left of it.
+func suffixLastLabel(name string) (label, rest string) {
+	i := strings.LastIndexByte(name, '.')
+	if i < 0 {
+		return name, ""
+	}
+
+	return name[i+1:], name[:i]
+}
+
+// Match returns the shortest suffix of the set, which host is or is a
+// subdomain of.  host must be lowercased.  ok is false if there is no such
+// suffix.  A nil *SuffixSet matches nothing.
+func (s *SuffixSet) Match(host string) (suffix string, ok bool) {
+	if s == nil {
+		return "", false
+	}
+
+	host = strings.TrimSuffix(host, ".")
+
+	n := s.root
+	for end := len(host); end > 0; {
+		start := strings.LastIndexByte(host[:end], '.') + 1
+
+		n = n.children[host[start:end]]
+		if n == nil {
+			return "", false
+		} else if n.isSuffix {
+			return host[start:], true
+		}
+
+		end = start - 1
+	}
+
+	return "", false
+}
+
+// matchBlockedSuffixes blocks host if it's a subdomain of one of the suffixes
+// from setts.  The hosts allowlisted by the filtering rules aren't blocked.
+// The filtering engines are only consulted for the matched hosts, so that the
+// suffixes are checked before them.
+func (d *DNSFilter) matchBlockedSuffixes(
+	host string,
+	rrtype uint16,
+	setts *Settings,
+) (res Result, err error) {
+	if !setts.ProtectionEnabled || !setts.FilteringEnabled {
+		return Result{}, nil
+	}
+
+	suffix, ok := setts.BlockedSuffixes.Match(host)
+	if !ok {
+		return Result{}, nil
+	}
+
+	res, err = d.matchHost(host, rrtype, setts)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return Result{}, err
+	} else if res.Reason == NotFilteredAllowList {
+		return res, nil
+	}
+
+	log.Debug("filtering: host %q is blocked by suffix %q", host, suffix)
+
+	return Result{
+		Rules: []*ResultRule{{
+			Text:         "||" + suffix + "^",
+			FilterListID: rulelist.URLFilterIDBlockedSuffixes,
+		}},
+		Reason:     FilteredBlockList,
+		IsFiltered: true,
+	}, nil
+}
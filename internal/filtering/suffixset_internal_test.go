@@ -0,0 +1,176 @@
+package filtering
+
+import (
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering/rulelist"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSuffixSet_Match(t *testing.T) {
+	s, err := NewSuffixSet([]string{
+		"# Whole TLDs.",
+		".zip",
+		"*.mov",
+		"",
+		"co.uk",
+		"Sub.Blocked.Example.",
+	})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name       string
+		host       string
+		wantSuffix string
+		wantOK     bool
+	}{{
+		name:       "tld",
+		host:       "archive.zip",
+		wantSuffix: "zip",
+		wantOK:     true,
+	}, {
+		name:       "tld_itself",
+		host:       "mov",
+		wantSuffix: "mov",
+		wantOK:     true,
+	}, {
+		name:       "nested",
+		host:       "www.example.co.uk",
+		wantSuffix: "co.uk",
+		wantOK:     true,
+	}, {
+		name:       "parent_of_suffix",
+		host:       "uk",
+		wantSuffix: "",
+		wantOK:     false,
+	}, {
+		name:       "sibling",
+		host:       "example.org.uk",
+		wantSuffix: "",
+		wantOK:     false,
+	}, {
+		name:       "deep",
+		host:       "a.sub.blocked.example",
+		wantSuffix: "sub.blocked.example",
+		wantOK:     true,
+	}, {
+		name:       "not_label_boundary",
+		host:       "notsub.blocked.example",
+		wantSuffix: "",
+		wantOK:     false,
+	}, {
+		name:       "fqdn",
+		host:       "archive.zip.",
+		wantSuffix: "zip",
+		wantOK:     true,
+	}, {
+		name:       "other",
+		host:       "example.com",
+		wantSuffix: "",
+		wantOK:     false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			suffix, ok := s.Match(tc.host)
+			assert.Equal(t, tc.wantOK, ok)
+			assert.Equal(t, tc.wantSuffix, suffix)
+		})
+	}
+}
+
+func TestNewSuffixSet(t *testing.T) {
+	s, err := NewSuffixSet([]string{"# Only a comment.", ""})
+	require.NoError(t, err)
+
+	assert.Nil(t, s)
+
+	_, ok := s.Match("example.zip")
+	assert.False(t, ok)
+
+	_, err = NewSuffixSet([]string{"bad..suffix"})
+	assert.Error(t, err)
+}
+
+func TestDNSFilter_CheckHost_blockedSuffixes(t *testing.T) {
+	const (
+		allowedHost = "example.co.uk"
+		blockedHost = "other.co.uk"
+	)
+
+	d, _ := newForTest(t, &Config{
+		BlockedSuffixes: []string{".co.uk", ".zip"},
+	}, []Filter{{
+		ID: 0, Data: []byte("@@||" + allowedHost + "^\n"),
+	}})
+	t.Cleanup(d.Close)
+
+	setts := d.Settings()
+	setts.ProtectionEnabled = true
+	setts.FilteringEnabled = true
+
+	clientSuffixes, err := NewSuffixSet([]string{".zip"})
+	require.NoError(t, err)
+
+	clientSetts := *setts
+	clientSetts.BlockedSuffixes = clientSuffixes
+
+	testCases := []struct {
+		setts      *Settings
+		name       string
+		host       string
+		wantReason Reason
+		wantRule   string
+		wantListID rulelist.URLFilterID
+	}{{
+		setts:      setts,
+		name:       "blocked",
+		host:       blockedHost,
+		wantReason: FilteredBlockList,
+		wantRule:   "||co.uk^",
+		wantListID: rulelist.URLFilterIDBlockedSuffixes,
+	}, {
+		setts:      setts,
+		name:       "allowlisted",
+		host:       "www." + allowedHost,
+		wantReason: NotFilteredAllowList,
+		wantRule:   "@@||" + allowedHost + "^",
+		wantListID: 0,
+	}, {
+		setts:      setts,
+		name:       "not_blocked",
+		host:       "example.org",
+		wantReason: NotFilteredNotFound,
+	}, {
+		setts:      &clientSetts,
+		name:       "client_override",
+		host:       blockedHost,
+		wantReason: NotFilteredNotFound,
+	}, {
+		setts:      &clientSetts,
+		name:       "client_blocked",
+		host:       "archive.zip",
+		wantReason: FilteredBlockList,
+		wantRule:   "||zip^",
+		wantListID: rulelist.URLFilterIDBlockedSuffixes,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, checkErr := d.CheckHost(tc.host, dns.TypeA, tc.setts)
+			require.NoError(t, checkErr)
+
+			assert.Equal(t, tc.wantReason, res.Reason)
+			if tc.wantReason == NotFilteredNotFound {
+				return
+			}
+
+			require.Len(t, res.Rules, 1)
+
+			assert.Equal(t, tc.wantRule, res.Rules[0].Text)
+			assert.Equal(t, tc.wantListID, res.Rules[0].FilterListID)
+		})
+	}
+}
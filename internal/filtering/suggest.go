@@ -0,0 +1,706 @@
+package filtering
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/maphash"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/golibs/container"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/ioutil"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/c2h5oh/datasize"
+)
+
+// DefaultSuggestCatalogURL is the default URL of the catalog of the community
+// filter lists used for the suggestions.
+const DefaultSuggestCatalogURL = "https://adguardteam.github.io/HostlistsRegistry/assets/filters.json"
+
+const (
+	// defaultSuggestDays is the default number of the latest days of the query
+	// log analyzed for the suggestions.
+	defaultSuggestDays = 7
+
+	// maxSuggestDays is the maximum number of the latest days of the query log
+	// analyzed for the suggestions.
+	maxSuggestDays = 90
+
+	// maxSuggestDomains is the maximum number of the unique queried domains
+	// sampled for the suggestions.
+	maxSuggestDomains = 1_000
+
+	// maxSuggestSampledQueries is the maximum number of the queries sampled
+	// from the query log.  It bounds the memory used for analyzing the query
+	// log regardless of its size.
+	maxSuggestSampledQueries = 10_000
+
+	// maxSuggestCachedHashes is the default maximum total number of the rule
+	// hashes cached for all filter lists, which also bounds the number of the
+	// hashes kept while parsing a single list.  With about 40 bytes per hash,
+	// the hashes take up to a few tens of megabytes.
+	maxSuggestCachedHashes = 1_000_000
+
+	// suggestRateLimit is the minimum interval between the suggestion requests
+	// of a single session.
+	suggestRateLimit = 5 * time.Minute
+
+	// suggestHashesTTL is the duration for which the rule hashes of a filter
+	// list are cached.
+	suggestHashesTTL = 24 * time.Hour
+
+	// maxSuggestCatalogSize is the maximum size of the catalog of filter lists.
+	maxSuggestCatalogSize = 10 * datasize.MB
+
+	// maxSuggestListSize is the maximum size of a filter list downloaded for
+	// the suggestions.
+	maxSuggestListSize = 64 * datasize.MB
+)
+
+// QueryLogRanger is the source of the queries for the suggestions of filter
+// lists.
+type QueryLogRanger interface {
+	// RangeQueries calls f for the host and the client of each query logged
+	// since the given time until f returns false.
+	RangeQueries(
+		ctx context.Context,
+		since time.Time,
+		f func(host, client string) (cont bool),
+	) (err error)
+}
+
+// listSuggester suggests the filter lists from a catalog based on the queries
+// from the query log.  It's safe for concurrent use.
+type listSuggester struct {
+	// client is used to fetch the catalog and the filter lists.
+	client *http.Client
+
+	// queryLog is the source of the queries.
+	queryLog QueryLogRanger
+
+	// catalogURL is the URL of the catalog of filter lists.
+	catalogURL string
+
+	// seed is used to hash the hostnames.
+	seed maphash.Seed
+
+	// mu protects hashes and lastRequests.
+	mu *sync.Mutex
+
+	// hashes maps the URLs of the filter lists to their cached rule hashes.
+	// The total number of the cached hashes doesn't exceed maxHashes.
+	hashes map[string]*listHashes
+
+	// maxHashes is the maximum total number of the cached rule hashes.
+	maxHashes int
+
+	// lastRequests maps the session keys to the times of their latest
+	// suggestion requests.
+	lastRequests map[string]time.Time
+}
+
+// newListSuggester returns a new properly initialized *listSuggester.  If
+// catalogURL is empty, [DefaultSuggestCatalogURL] is used.
+func newListSuggester(
+	client *http.Client,
+	queryLog QueryLogRanger,
+	catalogURL string,
+) (s *listSuggester, err error) {
+	catalogURL = cmp.Or(catalogURL, DefaultSuggestCatalogURL)
+
+	u, err := url.Parse(catalogURL)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("bad url scheme %q", u.Scheme)
+	}
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &listSuggester{
+		client:       client,
+		queryLog:     queryLog,
+		catalogURL:   catalogURL,
+		seed:         maphash.MakeSeed(),
+		mu:           &sync.Mutex{},
+		hashes:       map[string]*listHashes{},
+		maxHashes:    maxSuggestCachedHashes,
+		lastRequests: map[string]time.Time{},
+	}, nil
+}
+
+// listHashes are the hashes of the hostnames blocked by the rules of a filter
+// list.
+type listHashes struct {
+	// fetched is the time when the list has been fetched.
+	fetched time.Time
+
+	// exact are the hashes of the hostnames blocked without their subdomains.
+	exact *container.MapSet[uint64]
+
+	// withSubdomains are the hashes of the hostnames blocked along with their
+	// subdomains.
+	withSubdomains *container.MapSet[uint64]
+
+	// candidates, if not nil, are the only hashes kept in h, see
+	// [listHashes.restrict].  Such hashes are only valid for the domains they
+	// were restricted to, so they aren't cached.
+	candidates *container.MapSet[uint64]
+
+	// rulesCount is the number of rules in the list.
+	rulesCount int
+}
+
+// len returns the number of hashes in h.
+func (h *listHashes) len() (n int) {
+	return h.exact.Len() + h.withSubdomains.Len()
+}
+
+// restrict removes the hashes not in candidates from h and makes it only keep
+// the hashes from candidates from now on.  candidates must not be nil.
+func (h *listHashes) restrict(candidates *container.MapSet[uint64]) {
+	exact := container.NewMapSet[uint64]()
+	withSubdomains := container.NewMapSet[uint64]()
+	candidates.Range(func(v uint64) (cont bool) {
+		if h.exact.Has(v) {
+			exact.Add(v)
+		}
+
+		if h.withSubdomains.Has(v) {
+			withSubdomains.Add(v)
+		}
+
+		return true
+	})
+
+	h.exact, h.withSubdomains, h.candidates = exact, withSubdomains, candidates
+}
+
+// matches returns true if host is blocked by the list.  host must be a valid,
+// lowercase, non-fully-qualified domain name.
+func (h *listHashes) matches(seed maphash.Seed, host string) (ok bool) {
+	if h.exact.Has(maphash.String(seed, host)) {
+		return true
+	}
+
+	for _, sub := range netutil.Subdomains(host) {
+		if h.withSubdomains.Has(maphash.String(seed, sub)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sampledDomain is a queried domain sampled for the suggestions.
+type sampledDomain struct {
+	// host is the queried hostname.
+	host string
+
+	// clients is the number of unique clients that have queried host.
+	clients int
+}
+
+// hostClient is a sampled query, also used as the key for deduplicating the
+// queries by client.
+type hostClient struct {
+	host   string
+	client string
+}
+
+// sampleDomains returns up to [maxSuggestDomains] unique domains queried since
+// the given time.  The domains are taken from a uniform sample of at most
+// [maxSuggestSampledQueries] queries, so that the memory used doesn't depend on
+// the size of the query log.  The domains queried by more clients are
+// preferred.
+func (s *listSuggester) sampleDomains(
+	ctx context.Context,
+	since time.Time,
+) (domains []*sampledDomain, err error) {
+	// Use reservoir sampling to sample the queries in a single pass.
+	sample := make([]hostClient, 0, maxSuggestSampledQueries)
+	n := 0
+	err = s.queryLog.RangeQueries(ctx, since, func(host, client string) (cont bool) {
+		host = strings.ToLower(strings.TrimSuffix(host, "."))
+		if host == "" {
+			return true
+		}
+
+		n++
+		q := hostClient{host: host, client: client}
+		if len(sample) < maxSuggestSampledQueries {
+			sample = append(sample, q)
+		} else if i := rand.IntN(n); i < maxSuggestSampledQueries {
+			sample[i] = q
+		}
+
+		return ctx.Err() == nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading query log: %w", err)
+	}
+
+	seen := container.NewMapSet[hostClient]()
+	counts := map[string]int{}
+	for _, q := range sample {
+		if !seen.Has(q) {
+			seen.Add(q)
+			counts[q.host]++
+		}
+	}
+
+	domains = make([]*sampledDomain, 0, len(counts))
+	for host, n := range counts {
+		domains = append(domains, &sampledDomain{host: host, clients: n})
+	}
+
+	slices.SortFunc(domains, func(a, b *sampledDomain) (res int) {
+		return cmp.Or(cmp.Compare(b.clients, a.clients), strings.Compare(a.host, b.host))
+	})
+
+	return domains[:min(len(domains), maxSuggestDomains)], nil
+}
+
+// candidateHashes returns the hashes of the hostnames, which may match domains,
+// that is the domains themselves and their parent domains.
+func (s *listSuggester) candidateHashes(
+	domains []*sampledDomain,
+) (candidates *container.MapSet[uint64]) {
+	candidates = container.NewMapSet[uint64]()
+	for _, d := range domains {
+		for _, sub := range netutil.Subdomains(d.host) {
+			candidates.Add(maphash.String(s.seed, sub))
+		}
+	}
+
+	return candidates
+}
+
+// suggestCatalog is the catalog of filter lists in the format of the
+// HostlistsRegistry.
+type suggestCatalog struct {
+	Filters []*suggestCatalogFilter `json:"filters"`
+}
+
+// suggestCatalogFilter is a filter list in the catalog.
+type suggestCatalogFilter struct {
+	Name        string `json:"name"`
+	DownloadURL string `json:"downloadUrl"`
+}
+
+// fetchCatalog returns the filter lists from the catalog.
+func (s *listSuggester) fetchCatalog(
+	ctx context.Context,
+) (filters []*suggestCatalogFilter, err error) {
+	body, err := s.get(ctx, s.catalogURL)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+	defer func() { err = errors.WithDeferred(err, body.Close()) }()
+
+	c := &suggestCatalog{}
+	err = json.NewDecoder(ioutil.LimitReader(body, maxSuggestCatalogSize.Bytes())).Decode(c)
+	if err != nil {
+		return nil, fmt.Errorf("decoding: %w", err)
+	}
+
+	return c.Filters, nil
+}
+
+// get performs a GET request to rawURL and returns the body of the successful
+// response.
+func (s *listSuggester) get(ctx context.Context, rawURL string) (body io.ReadCloser, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("got status code %d, want %d", resp.StatusCode, http.StatusOK)
+
+		return nil, errors.WithDeferred(err, resp.Body.Close())
+	}
+
+	return resp.Body, nil
+}
+
+// listHashes returns the rule hashes of the filter list at rawURL, using the
+// cached ones, if they're fresh enough.  candidates are used to restrict the
+// hashes of the lists too large to be cached, see [listSuggester.parseList].
+func (s *listSuggester) listHashes(
+	ctx context.Context,
+	rawURL string,
+	now time.Time,
+	candidates *container.MapSet[uint64],
+) (h *listHashes, err error) {
+	s.mu.Lock()
+	h = s.hashes[rawURL]
+	s.mu.Unlock()
+
+	if h != nil && now.Sub(h.fetched) < suggestHashesTTL {
+		return h, nil
+	}
+
+	body, err := s.get(ctx, rawURL)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+	defer func() { err = errors.WithDeferred(err, body.Close()) }()
+
+	h, err = s.parseList(ioutil.LimitReader(body, maxSuggestListSize.Bytes()), candidates)
+	if err != nil {
+		return nil, fmt.Errorf("parsing: %w", err)
+	}
+
+	h.fetched = now
+	s.cacheHashes(rawURL, h, now)
+
+	return h, nil
+}
+
+// cacheHashes caches h for rawURL, unless h is restricted.  It removes the
+// expired hashes and, if the total number of the cached hashes would exceed
+// s.maxHashes, the oldest ones.
+func (s *listSuggester) cacheHashes(rawURL string, h *listHashes, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.hashes, rawURL)
+
+	total := 0
+	for u, cached := range s.hashes {
+		if now.Sub(cached.fetched) >= suggestHashesTTL {
+			delete(s.hashes, u)
+		} else {
+			total += cached.len()
+		}
+	}
+
+	if h.candidates != nil || h.len() > s.maxHashes {
+		return
+	}
+
+	for total+h.len() > s.maxHashes {
+		oldest := ""
+		for u, cached := range s.hashes {
+			if oldest == "" || cached.fetched.Before(s.hashes[oldest].fetched) {
+				oldest = u
+			}
+		}
+
+		total -= s.hashes[oldest].len()
+		delete(s.hashes, oldest)
+	}
+
+	s.hashes[rawURL] = h
+}
+
+// parseList parses the blocking rules of the filter list from r.  Only the
+// basic rules, such as "||example.org^" and "0.0.0.0 example.org", are
+// hashed, since the others can't be matched by the hostname only.  Once the
+// number of the hashes exceeds s.maxHashes, only the ones from candidates are
+// kept, see [listHashes.restrict].  candidates must not be nil in that case.
+func (s *listSuggester) parseList(
+	r io.Reader,
+	candidates *container.MapSet[uint64],
+) (h *listHashes, err error) {
+	h = &listHashes{
+		exact:          container.NewMapSet[uint64](),
+		withSubdomains: container.NewMapSet[uint64](),
+	}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), bufio.MaxScanTokenSize*16)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '!' || line[0] == '#' {
+			continue
+		}
+
+		h.rulesCount++
+		s.addRule(h, line)
+
+		if h.candidates == nil && h.len() > s.maxHashes {
+			h.restrict(candidates)
+		}
+	}
+
+	return h, sc.Err()
+}
+
+// addRule adds the hash of the hostname blocked by the rule in line to h, if
+// it's a basic blocking rule.
+func (s *listSuggester) addRule(h *listHashes, line string) {
+	line = strings.ToLower(strings.TrimSuffix(line, "$important"))
+	if strings.HasPrefix(line, "||") && strings.HasSuffix(line, "^") {
+		s.addHost(h, h.withSubdomains, line[len("||"):len(line)-len("^")])
+
+		return
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) == 1 {
+		s.addHost(h, h.withSubdomains, fields[0])
+
+		return
+	}
+
+	if _, err := netip.ParseAddr(fields[0]); err != nil {
+		return
+	}
+
+	for _, f := range fields[1:] {
+		if f[0] == '#' {
+			break
+		}
+
+		s.addHost(h, h.exact, f)
+	}
+}
+
+// addHost adds the hash of host to set, which is one of the sets of h, if it's
+// a valid hostname and h isn't restricted to other hashes.
+func (s *listSuggester) addHost(h *listHashes, set *container.MapSet[uint64], host string) {
+	if !netutil.IsValidHostname(host) {
+		return
+	}
+
+	hash := maphash.String(s.seed, host)
+	if h.candidates == nil || h.candidates.Has(hash) {
+		set.Add(hash)
+	}
+}
+
+// listSuggestion is a suggested filter list.
+type listSuggestion struct {
+	// ListName is the name of the list from the catalog.
+	ListName string `json:"listName"`
+
+	// ListURL is the URL of the list.
+	ListURL string `json:"listURL"`
+
+	// EstimatedBlockRate is the share of the sampled queries that would be
+	// blocked by the list, from 0 to 1.
+	EstimatedBlockRate float64 `json:"estimatedBlockRate"`
+
+	// RulesCount is the number of rules in the list.
+	RulesCount int `json:"rulesCount"`
+}
+
+// suggest returns the filter lists from the catalog which would block the
+// queries of the latest days, except for the lists with URLs in enabled, sorted
+// by the estimated block rate.
+func (s *listSuggester) suggest(
+	ctx context.Context,
+	days int,
+	enabled *container.MapSet[string],
+) (suggestions []*listSuggestion, err error) {
+	now := time.Now()
+
+	domains, err := s.sampleDomains(ctx, now.AddDate(0, 0, -days))
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+
+	total := 0
+	for _, d := range domains {
+		total += d.clients
+	}
+
+	if total == 0 {
+		return []*listSuggestion{}, nil
+	}
+
+	filters, err := s.fetchCatalog(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("fetching catalog: %w", err)
+	}
+
+	candidates := s.candidateHashes(domains)
+	suggestions = []*listSuggestion{}
+	for _, f := range filters {
+		if f == nil || f.DownloadURL == "" || enabled.Has(f.DownloadURL) {
+			continue
+		}
+
+		var h *listHashes
+		h, err = s.listHashes(ctx, f.DownloadURL, now, candidates)
+		if err != nil {
+			log.Debug("filtering: suggest: fetching list %q: %s", f.DownloadURL, err)
+
+			continue
+		}
+
+		blocked := 0
+		for _, d := range domains {
+			if h.matches(s.seed, d.host) {
+				blocked += d.clients
+			}
+		}
+
+		if blocked == 0 {
+			continue
+		}
+
+		suggestions = append(suggestions, &listSuggestion{
+			ListName:           f.Name,
+			ListURL:            f.DownloadURL,
+			EstimatedBlockRate: float64(blocked) / float64(total),
+			RulesCount:         h.rulesCount,
+		})
+	}
+
+	slices.SortStableFunc(suggestions, func(a, b *listSuggestion) (res int) {
+		return cmp.Compare(b.EstimatedBlockRate, a.EstimatedBlockRate)
+	})
+
+	return suggestions, nil
+}
+
+// allowRequest returns true and records the request if the session with key
+// hasn't requested the suggestions within [suggestRateLimit].  Otherwise, it
+// returns the duration after which the request can be repeated.
+func (s *listSuggester) allowRequest(
+	key string,
+	now time.Time,
+) (ok bool, retryAfter time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k, t := range s.lastRequests {
+		if now.Sub(t) >= suggestRateLimit {
+			delete(s.lastRequests, k)
+		}
+	}
+
+	if t, has := s.lastRequests[key]; has {
+		return false, suggestRateLimit - now.Sub(t)
+	}
+
+	s.lastRequests[key] = now
+
+	return true, 0
+}
+
+// sessionKey returns the key identifying the web session of r.  The remote
+// address is used if there is no session, for example when the authentication
+// is disabled.
+func sessionKey(r *http.Request) (key string) {
+	c, err := r.Cookie(aghhttp.SessionCookieName)
+	if err == nil && c.Value != "" {
+		return "session:" + c.Value
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	return "addr:" + host
+}
+
+// suggestReq is the request for the POST /control/filtering/suggest HTTP API.
+type suggestReq struct {
+	// Days is the number of the latest days of the query log to analyze.  If
+	// zero, [defaultSuggestDays] is used.
+	Days int `json:"days"`
+}
+
+// handleFilteringSuggest is the handler for the POST /control/filtering/suggest
+// HTTP API.
+func (d *DNSFilter) handleFilteringSuggest(w http.ResponseWriter, r *http.Request) {
+	s := d.suggester
+	if s == nil || s.queryLog == nil {
+		aghhttp.Error(r, w, http.StatusServiceUnavailable, "query log is not available")
+
+		return
+	}
+
+	req := &suggestReq{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil && !errors.Is(err, io.EOF) {
+		aghhttp.Error(r, w, http.StatusBadRequest, "reading req: %s", err)
+
+		return
+	}
+
+	req.Days = cmp.Or(req.Days, defaultSuggestDays)
+	if req.Days < 0 || req.Days > maxSuggestDays {
+		aghhttp.Error(
+			r,
+			w,
+			http.StatusBadRequest,
+			"days: must be between 1 and %d, got %d",
+			maxSuggestDays,
+			req.Days,
+		)
+
+		return
+	}
+
+	ok, retryAfter := s.allowRequest(sessionKey(r), time.Now())
+	if !ok {
+		w.Header().Set(httphdr.RetryAfter, strconv.Itoa(int(retryAfter.Seconds())+1))
+		aghhttp.Error(
+			r,
+			w,
+			http.StatusTooManyRequests,
+			"retry in %s",
+			retryAfter.Round(time.Second),
+		)
+
+		return
+	}
+
+	suggestions, err := s.suggest(r.Context(), req.Days, d.enabledFilterURLs())
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "suggesting lists: %s", err)
+
+		return
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, suggestions)
+}
+
+// enabledFilterURLs returns the URLs of the enabled blocklists.
+func (d *DNSFilter) enabledFilterURLs() (urls *container.MapSet[string]) {
+	d.conf.filtersMu.RLock()
+	defer d.conf.filtersMu.RUnlock()
+
+	urls = container.NewMapSet[string]()
+	for _, f := range d.conf.Filters {
+		if f.Enabled {
+			urls.Add(f.URL)
+		}
+	}
+
+	return urls
+}
@@ -0,0 +1,314 @@
+package filtering
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"hash/maphash"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/golibs/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testQuery is a query of the synthetic query log.
+type testQuery struct {
+	time   time.Time
+	host   string
+	client string
+}
+
+// testQueryLog is a [QueryLogRanger] for tests.
+type testQueryLog struct {
+	// queries are the logged queries from the newest to the oldest.
+	queries []*testQuery
+}
+
+// type check
+var _ QueryLogRanger = (*testQueryLog)(nil)
+
+// RangeQueries implements the [QueryLogRanger] interface for *testQueryLog.
+func (l *testQueryLog) RangeQueries(
+	_ context.Context,
+	since time.Time,
+	f func(host, client string) (cont bool),
+) (err error) {
+	for _, q := range l.queries {
+		if q.time.Before(since) || !f(q.host, q.client) {
+			break
+		}
+	}
+
+	return nil
+}
+
+func TestDNSFilter_handleFilteringSuggest(t *testing.T) {
+	adsURL := serveFiltersLocally(t, []byte(strings.Join([]string{
+		"! Title: Ads",
+		"||ads.example^",
+		"0.0.0.0 tracker.example",
+		"||path.example/ads^",
+	}, "\n")))
+	trackersURL := serveFiltersLocally(t, []byte("||tracker.example^$important\n"))
+	enabledURL := serveFiltersLocally(t, []byte("||ads.example^\n"))
+	unusedURL := serveFiltersLocally(t, []byte("||unused.example^\n"))
+
+	catalog, err := json.Marshal(&suggestCatalog{
+		Filters: []*suggestCatalogFilter{{
+			Name:        "Unused",
+			DownloadURL: unusedURL,
+		}, {
+			Name:        "Trackers",
+			DownloadURL: trackersURL,
+		}, {
+			Name:        "Enabled",
+			DownloadURL: enabledURL,
+		}, {
+			Name:        "Ads",
+			DownloadURL: adsURL,
+		}},
+	})
+	require.NoError(t, err)
+
+	now := time.Now()
+	qlog := &testQueryLog{
+		queries: []*testQuery{{
+			time:   now,
+			host:   "ads.example.",
+			client: "client-1",
+		}, {
+			time:   now,
+			host:   "ADS.example.",
+			client: "client-1",
+		}, {
+			time:   now,
+			host:   "ads.example.",
+			client: "192.0.2.2",
+		}, {
+			time:   now,
+			host:   "sub.tracker.example.",
+			client: "client-1",
+		}, {
+			time:   now,
+			host:   "other.example.",
+			client: "192.0.2.3",
+		}, {
+			time:   now.AddDate(0, 0, -defaultSuggestDays-1),
+			host:   "unused.example.",
+			client: "192.0.2.3",
+		}},
+	}
+
+	d, err := New(&Config{
+		Filters: []FilterYAML{{
+			Enabled: true,
+			URL:     enabledURL,
+			Name:    "Enabled",
+		}},
+		HTTPClient:        &http.Client{Timeout: 5 * time.Second},
+		QueryLog:          qlog,
+		SuggestCatalogURL: serveFiltersLocally(t, catalog),
+		DataDir:           t.TempDir(),
+	}, nil)
+	require.NoError(t, err)
+	t.Cleanup(d.Close)
+
+	newReq := func(session string) (r *http.Request) {
+		r = httptest.NewRequest(http.MethodPost, "/control/filtering/suggest", &bytes.Buffer{})
+		r.AddCookie(&http.Cookie{Name: aghhttp.SessionCookieName, Value: session})
+
+		return r
+	}
+
+	w := httptest.NewRecorder()
+	d.handleFilteringSuggest(w, newReq("session-1"))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var got []*listSuggestion
+	err = json.NewDecoder(w.Body).Decode(&got)
+	require.NoError(t, err)
+
+	// There are four queries after the deduplication by client, two of them
+	// for ads.example.
+	assert.Equal(t, []*listSuggestion{{
+		ListName:           "Ads",
+		ListURL:            adsURL,
+		EstimatedBlockRate: 0.5,
+		RulesCount:         3,
+	}, {
+		ListName:           "Trackers",
+		ListURL:            trackersURL,
+		EstimatedBlockRate: 0.25,
+		RulesCount:         1,
+	}}, got)
+
+	t.Run("rate_limited", func(t *testing.T) {
+		w = httptest.NewRecorder()
+		d.handleFilteringSuggest(w, newReq("session-1"))
+
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.NotEmpty(t, w.Header().Get("Retry-After"))
+	})
+
+	t.Run("other_session", func(t *testing.T) {
+		w = httptest.NewRecorder()
+		d.handleFilteringSuggest(w, newReq("session-2"))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("bad_days", func(t *testing.T) {
+		r := httptest.NewRequest(
+			http.MethodPost,
+			"/control/filtering/suggest",
+			strings.NewReader(`{"days":-1}`),
+		)
+
+		w = httptest.NewRecorder()
+		d.handleFilteringSuggest(w, r)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestListHashes_matches(t *testing.T) {
+	s, err := newListSuggester(nil, nil, "")
+	require.NoError(t, err)
+
+	h, err := s.parseList(strings.NewReader(strings.Join([]string{
+		"# Comment",
+		"||blocked.example^",
+		"127.0.0.1 exact.example # Comment",
+		"plain.example",
+		"@@||allowed.blocked.example^",
+		"/regexp\\.example/",
+	}, "\n")), nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, 5, h.rulesCount)
+
+	testCases := []struct {
+		host string
+		want assert.BoolAssertionFunc
+	}{{
+		host: "blocked.example",
+		want: assert.True,
+	}, {
+		host: "sub.blocked.example",
+		want: assert.True,
+	}, {
+		host: "exact.example",
+		want: assert.True,
+	}, {
+		host: "sub.exact.example",
+		want: assert.False,
+	}, {
+		host: "sub.plain.example",
+		want: assert.True,
+	}, {
+		host: "regexp.example",
+		want: assert.False,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.host, func(t *testing.T) {
+			tc.want(t, h.matches(s.seed, tc.host))
+		})
+	}
+}
+
+func TestListSuggester_parseList_restricted(t *testing.T) {
+	s, err := newListSuggester(nil, nil, "")
+	require.NoError(t, err)
+
+	s.maxHashes = 2
+
+	candidates := s.candidateHashes([]*sampledDomain{{
+		host:    "sub.last.example",
+		clients: 1,
+	}, {
+		host:    "first.example",
+		clients: 1,
+	}})
+
+	h, err := s.parseList(strings.NewReader(strings.Join([]string{
+		"||first.example^",
+		"||second.example^",
+		"||third.example^",
+		"||last.example^",
+	}, "\n")), candidates)
+	require.NoError(t, err)
+
+	assert.Equal(t, 4, h.rulesCount)
+	assert.Equal(t, 2, h.len())
+	assert.True(t, h.matches(s.seed, "first.example"))
+	assert.True(t, h.matches(s.seed, "sub.last.example"))
+	assert.False(t, h.matches(s.seed, "second.example"))
+
+	s.cacheHashes("https://list.example", h, time.Now())
+	assert.Empty(t, s.hashes)
+}
+
+func TestListSuggester_cacheHashes(t *testing.T) {
+	s, err := newListSuggester(nil, nil, "")
+	require.NoError(t, err)
+
+	s.maxHashes = 3
+
+	now := time.Now()
+	newHashes := func(t *testing.T, fetched time.Time, hosts ...string) (h *listHashes) {
+		t.Helper()
+
+		h = &listHashes{
+			fetched:        fetched,
+			exact:          container.NewMapSet[uint64](),
+			withSubdomains: container.NewMapSet[uint64](),
+		}
+
+		for _, host := range hosts {
+			h.withSubdomains.Add(maphash.String(s.seed, host))
+		}
+
+		return h
+	}
+
+	s.cacheHashes("expired", newHashes(t, now.Add(-suggestHashesTTL), "expired.example"), now)
+	s.cacheHashes("oldest", newHashes(t, now.Add(-time.Hour), "a.example", "b.example"), now)
+	require.NotContains(t, s.hashes, "expired")
+
+	s.cacheHashes("newer", newHashes(t, now.Add(-time.Minute), "c.example"), now)
+	require.Len(t, s.hashes, 2)
+
+	s.cacheHashes("newest", newHashes(t, now, "d.example"), now)
+	assert.Len(t, s.hashes, 2)
+	assert.NotContains(t, s.hashes, "oldest")
+	assert.Contains(t, s.hashes, "newer")
+	assert.Contains(t, s.hashes, "newest")
+
+	tooLarge := newHashes(t, now, "1.example", "2.example", "3.example", "4.example")
+	s.cacheHashes("too_large", tooLarge, now)
+	assert.NotContains(t, s.hashes, "too_large")
+}
+
+func TestListSuggester_allowRequest(t *testing.T) {
+	s, err := newListSuggester(nil, nil, "")
+	require.NoError(t, err)
+
+	now := time.Now()
+
+	ok, _ := s.allowRequest("key", now)
+	assert.True(t, ok)
+
+	ok, retryAfter := s.allowRequest("key", now.Add(time.Minute))
+	assert.False(t, ok)
+	assert.Equal(t, suggestRateLimit-time.Minute, retryAfter)
+
+	ok, _ = s.allowRequest("key", now.Add(suggestRateLimit))
+	assert.True(t, ok)
+}
@@ -0,0 +1,371 @@
+package filtering
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering/rulelist"
+	"github.com/AdguardTeam/golibs/container"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// WebhookSignatureHeader is the name of the HTTP header containing the
+// hex-encoded HMAC-SHA256 signature of the webhook request body.
+const WebhookSignatureHeader = "X-Adguardhome-Signature"
+
+const (
+	// webhookTimeout is the timeout of a single webhook request.
+	webhookTimeout = 10 * time.Second
+
+	// webhookMaxRetries is the maximum number of retries of a failed webhook
+	// request.
+	webhookMaxRetries = 3
+
+	// defaultWebhookBackoff is the default interval before the first retry of
+	// a failed webhook request.  It's doubled for each subsequent retry.
+	defaultWebhookBackoff = 1 * time.Second
+
+	// defaultWebhookBlockedIvl is the default minimum interval between the
+	// notifications about the blocked requests of a single client.
+	defaultWebhookBlockedIvl = 1 * time.Minute
+
+	// webhookMaxBlockedClients is the number of the tracked clients above which
+	// the clients, notified about longer than the blocked interval ago, are
+	// forgotten.
+	webhookMaxBlockedClients = 1_000
+)
+
+// webhookEvent is the type of the event the webhook notification is sent about.
+type webhookEvent string
+
+// webhookEvent constants.
+const (
+	// webhookEventFiltersUpdated is the event of updating the filter lists.
+	webhookEventFiltersUpdated webhookEvent = "filters_updated"
+
+	// webhookEventClientBlocked is the event of blocking a request of a client
+	// by the safe browsing or the parental control.
+	webhookEventClientBlocked webhookEvent = "client_blocked"
+)
+
+// filterUpdate is the information about a single updated filter list sent in
+// the webhook notification.
+type filterUpdate struct {
+	// ID is the identifier of the filter list.
+	ID rulelist.URLFilterID `json:"id"`
+
+	// OldRulesCount is the number of rules in the filter list before the
+	// update.
+	OldRulesCount int `json:"old_rules_count"`
+
+	// NewRulesCount is the number of rules in the filter list after the
+	// update.
+	NewRulesCount int `json:"new_rules_count"`
+}
+
+// webhookPayload is the body of the webhook notification about the updated
+// filter lists.
+type webhookPayload struct {
+	// Event is always [webhookEventFiltersUpdated].
+	Event webhookEvent `json:"event"`
+
+	// Timestamp is the time of the update.
+	Timestamp time.Time `json:"timestamp"`
+
+	// Updated are the filter lists that have been updated.
+	Updated []*filterUpdate `json:"updated"`
+
+	// TotalRulesCount is the total number of rules in all enabled filter
+	// lists.
+	TotalRulesCount int `json:"total_rules_count"`
+}
+
+// blockedPayload is the body of the webhook notification about a blocked
+// request.
+type blockedPayload struct {
+	// Event is always [webhookEventClientBlocked].
+	Event webhookEvent `json:"event"`
+
+	// Timestamp is the time of the request.
+	Timestamp time.Time `json:"timestamp"`
+
+	// ClientIP is the IP address of the client.
+	ClientIP netip.Addr `json:"client_ip"`
+
+	// ClientName is the name of the client, if it's known.
+	ClientName string `json:"client_name,omitempty"`
+
+	// Host is the requested hostname.
+	Host string `json:"host"`
+
+	// Reason is the reason of blocking, either FilteredSafeBrowsing or
+	// FilteredParental.
+	Reason string `json:"reason"`
+}
+
+// webhookNotifier sends the notifications about the updated filter lists and
+// the blocked requests.
+type webhookNotifier struct {
+	// client is the HTTP client used to send the notifications.
+	client *http.Client
+
+	// url is the URL the notifications are posted to.
+	url string
+
+	// secret is the key used to sign the request bodies.
+	secret []byte
+
+	// events are the types of the events to send the notifications about.
+	events *container.MapSet[webhookEvent]
+
+	// blockedMu protects lastBlocked.
+	blockedMu *sync.Mutex
+
+	// lastBlocked maps the addresses of the clients to the times of the latest
+	// notifications about their blocked requests.
+	lastBlocked map[netip.Addr]time.Time
+
+	// now returns the current time.  It's replaced in tests.
+	now func() (t time.Time)
+
+	// backoff is the interval before the first retry of a failed request.
+	backoff time.Duration
+
+	// blockedIvl is the minimum interval between the notifications about the
+	// blocked requests of a single client.
+	blockedIvl time.Duration
+}
+
+// newWebhookNotifier returns a new properly initialized *webhookNotifier.  It
+// returns nil if rawURL is empty.  If events is empty, only the notifications
+// about the updated filter lists are sent.
+func newWebhookNotifier(
+	client *http.Client,
+	rawURL string,
+	secret string,
+	events []string,
+) (n *webhookNotifier, err error) {
+	if rawURL == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("bad url scheme %q", u.Scheme)
+	}
+
+	evSet, err := parseWebhookEvents(events)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &webhookNotifier{
+		client:      client,
+		url:         rawURL,
+		secret:      []byte(secret),
+		events:      evSet,
+		blockedMu:   &sync.Mutex{},
+		lastBlocked: map[netip.Addr]time.Time{},
+		now:         time.Now,
+		backoff:     defaultWebhookBackoff,
+		blockedIvl:  defaultWebhookBlockedIvl,
+	}, nil
+}
+
+// parseWebhookEvents returns the set of the webhook events from events.  If
+// events is empty, the set only contains [webhookEventFiltersUpdated].
+func parseWebhookEvents(events []string) (evSet *container.MapSet[webhookEvent], err error) {
+	if len(events) == 0 {
+		return container.NewMapSet(webhookEventFiltersUpdated), nil
+	}
+
+	evSet = container.NewMapSet[webhookEvent]()
+	for i, e := range events {
+		ev := webhookEvent(e)
+		switch ev {
+		case webhookEventFiltersUpdated, webhookEventClientBlocked:
+			evSet.Add(ev)
+		default:
+			return nil, fmt.Errorf("webhook_events: at index %d: bad event %q", i, e)
+		}
+	}
+
+	return evSet, nil
+}
+
+// allowBlocked returns true if the notification about a blocked request of the
+// client with addr should be sent.
+func (n *webhookNotifier) allowBlocked(addr netip.Addr) (ok bool) {
+	now := n.now()
+
+	n.blockedMu.Lock()
+	defer n.blockedMu.Unlock()
+
+	if last, found := n.lastBlocked[addr]; found && now.Sub(last) < n.blockedIvl {
+		return false
+	}
+
+	if len(n.lastBlocked) >= webhookMaxBlockedClients {
+		maps.DeleteFunc(n.lastBlocked, func(_ netip.Addr, last time.Time) (del bool) {
+			return now.Sub(last) >= n.blockedIvl
+		})
+	}
+
+	n.lastBlocked[addr] = now
+
+	return true
+}
+
+// notify posts the signed payload to the webhook URL, retrying the failed
+// requests with exponential backoff.  If all the attempts fail or ctx is
+// canceled, the notification is dropped.  It is intended to be used as a
+// goroutine.
+func (n *webhookNotifier) notify(ctx context.Context, payload any) {
+	defer log.OnPanic("filtering: webhook")
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Error("filtering: webhook: encoding payload: %s", err)
+
+		return
+	}
+
+	sig := n.sign(body)
+	backoff := n.backoff
+	for i := 0; ; i++ {
+		err = n.send(ctx, body, sig)
+		if err == nil {
+			return
+		} else if i == webhookMaxRetries {
+			break
+		}
+
+		log.Debug("filtering: webhook: attempt %d: %s; retrying in %s", i+1, err, backoff)
+
+		select {
+		case <-ctx.Done():
+			log.Debug("filtering: webhook: dropping notification: %s", context.Cause(ctx))
+
+			return
+		case <-time.After(backoff):
+			backoff *= 2
+		}
+	}
+
+	log.Info(
+		"filtering: warning: webhook: dropping notification after %d attempts: %s",
+		webhookMaxRetries+1,
+		err,
+	)
+}
+
+// sign returns the hex-encoded HMAC-SHA256 signature of body.
+func (n *webhookNotifier) sign(body []byte) (sig string) {
+	mac := hmac.New(sha256.New, n.secret)
+
+	// Don't check the error, since hash.Hash never returns one.
+	_, _ = mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// send performs a single webhook request with body and signature sig.
+func (n *webhookNotifier) send(ctx context.Context, body []byte, sig string) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+
+	req.Header.Set(httphdr.ContentType, aghhttp.HdrValApplicationJSON)
+	req.Header.Set(WebhookSignatureHeader, sig)
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+	defer func() { err = errors.WithDeferred(err, resp.Body.Close()) }()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("got status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// notifyUpdated sends the webhook notification about upds within ctx, if the
+// webhook is configured.
+func (d *DNSFilter) notifyUpdated(ctx context.Context, upds []*filterUpdate) {
+	if d.webhook == nil || !d.webhook.events.Has(webhookEventFiltersUpdated) || len(upds) == 0 {
+		return
+	}
+
+	payload := &webhookPayload{
+		Event:           webhookEventFiltersUpdated,
+		Timestamp:       time.Now().UTC(),
+		Updated:         upds,
+		TotalRulesCount: d.totalRulesCount(),
+	}
+
+	go d.webhook.notify(ctx, payload)
+}
+
+// notifyBlocked sends the webhook notification about the request for host
+// blocked for reason, if the webhook is configured for such notifications and
+// the client hasn't been notified about recently.  setts must not be nil.
+func (d *DNSFilter) notifyBlocked(host string, reason Reason, setts *Settings) {
+	n := d.webhook
+	if n == nil || !n.events.Has(webhookEventClientBlocked) || !setts.ClientIP.IsValid() {
+		return
+	}
+
+	if !n.allowBlocked(setts.ClientIP) {
+		return
+	}
+
+	payload := &blockedPayload{
+		Event:      webhookEventClientBlocked,
+		Timestamp:  n.now().UTC(),
+		ClientIP:   setts.ClientIP,
+		ClientName: setts.ClientName,
+		Host:       host,
+		Reason:     reason.String(),
+	}
+
+	go n.notify(d.shutdownCtx, payload)
+}
+
+// totalRulesCount returns the total number of rules in all enabled filter
+// lists.
+func (d *DNSFilter) totalRulesCount() (n int) {
+	for _, c := range d.RulesCounts() {
+		n += c.Count
+	}
+
+	return n
+}
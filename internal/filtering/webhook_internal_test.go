@@ -0,0 +1,378 @@
+package filtering
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// webhookRequest is a request received by the test webhook server.
+type webhookRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// newWebhookServer returns a test webhook server, which responds with the
+// codes from codes in turn and then with [http.StatusOK].  reqs returns the
+// received requests.
+func newWebhookServer(
+	t *testing.T,
+	codes ...int,
+) (srv *httptest.Server, reqs func() (received []*webhookRequest)) {
+	t.Helper()
+
+	mu := &sync.Mutex{}
+	var received []*webhookRequest
+
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		received = append(received, &webhookRequest{
+			header: r.Header.Clone(),
+			body:   body,
+		})
+
+		code := http.StatusOK
+		if i := len(received) - 1; i < len(codes) {
+			code = codes[i]
+		}
+
+		w.WriteHeader(code)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, func() (res []*webhookRequest) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return received
+	}
+}
+
+func TestWebhookNotifier_notify(t *testing.T) {
+	const secret = "secret"
+
+	payload := &webhookPayload{
+		Event:     webhookEventFiltersUpdated,
+		Timestamp: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		Updated: []*filterUpdate{{
+			ID:            1,
+			OldRulesCount: 10,
+			NewRulesCount: 20,
+		}},
+		TotalRulesCount: 30,
+	}
+
+	wantBody := map[string]any{
+		"event":     "filters_updated",
+		"timestamp": "2025-01-01T00:00:00Z",
+		"updated": []any{map[string]any{
+			"id":              float64(1),
+			"old_rules_count": float64(10),
+			"new_rules_count": float64(20),
+		}},
+		"total_rules_count": float64(30),
+	}
+
+	testCases := []struct {
+		name     string
+		codes    []int
+		wantReqs int
+		canceled bool
+	}{{
+		name:     "success",
+		codes:    nil,
+		wantReqs: 1,
+	}, {
+		name:     "retry",
+		codes:    []int{http.StatusInternalServerError, http.StatusBadGateway},
+		wantReqs: 3,
+	}, {
+		name:     "canceled",
+		codes:    []int{http.StatusInternalServerError},
+		canceled: true,
+		wantReqs: 1,
+	}, {
+		name: "drop",
+		codes: []int{
+			http.StatusInternalServerError,
+			http.StatusInternalServerError,
+			http.StatusInternalServerError,
+			http.StatusInternalServerError,
+			http.StatusInternalServerError,
+		},
+		wantReqs: webhookMaxRetries + 1,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv, reqs := newWebhookServer(t, tc.codes...)
+
+			n, err := newWebhookNotifier(srv.Client(), srv.URL, secret, nil)
+			require.NoError(t, err)
+			require.NotNil(t, n)
+
+			n.backoff = time.Millisecond
+			if tc.canceled {
+				// Make sure the first request is sent before canceling.
+				n.backoff = testTimeout
+			}
+
+			ctx, cancel := context.WithCancel(testutil.ContextWithTimeout(t, testTimeout))
+			defer cancel()
+
+			if tc.canceled {
+				go func() {
+					require.Eventually(testutil.PanicT{}, func() (ok bool) {
+						return len(reqs()) > 0
+					}, testTimeout, time.Millisecond)
+
+					cancel()
+				}()
+			}
+
+			n.notify(ctx, payload)
+
+			received := reqs()
+			require.Len(t, received, tc.wantReqs)
+
+			for _, r := range received {
+				assert.Equal(t, aghhttp.HdrValApplicationJSON, r.header.Get(httphdr.ContentType))
+
+				mac := hmac.New(sha256.New, []byte(secret))
+				_, _ = mac.Write(r.body)
+				wantSig := hex.EncodeToString(mac.Sum(nil))
+				assert.Equal(t, wantSig, r.header.Get(WebhookSignatureHeader))
+
+				gotBody := map[string]any{}
+				err = json.Unmarshal(r.body, &gotBody)
+				require.NoError(t, err)
+
+				assert.Equal(t, wantBody, gotBody)
+			}
+		})
+	}
+}
+
+func TestNewWebhookNotifier(t *testing.T) {
+	testCases := []struct {
+		name       string
+		url        string
+		events     []string
+		wantNil    bool
+		wantErrMsg string
+	}{{
+		name:       "empty",
+		url:        "",
+		events:     nil,
+		wantNil:    true,
+		wantErrMsg: "",
+	}, {
+		name:       "valid",
+		url:        "https://webhook.example/filters",
+		events:     nil,
+		wantNil:    false,
+		wantErrMsg: "",
+	}, {
+		name:       "valid_events",
+		url:        "https://webhook.example/filters",
+		events:     []string{"filters_updated", "client_blocked"},
+		wantNil:    false,
+		wantErrMsg: "",
+	}, {
+		name:       "bad_scheme",
+		url:        "ftp://webhook.example/filters",
+		events:     nil,
+		wantNil:    true,
+		wantErrMsg: `bad url scheme "ftp"`,
+	}, {
+		name:       "bad_event",
+		url:        "https://webhook.example/filters",
+		events:     []string{"client_blocked", "bad"},
+		wantNil:    true,
+		wantErrMsg: `webhook_events: at index 1: bad event "bad"`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			n, err := newWebhookNotifier(nil, tc.url, "", tc.events)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+
+			assert.Equal(t, tc.wantNil, n == nil)
+		})
+	}
+}
+
+func TestWebhookNotifier_allowBlocked(t *testing.T) {
+	n, err := newWebhookNotifier(nil, "https://webhook.example/filters", "", nil)
+	require.NoError(t, err)
+
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	n.now = func() (t time.Time) { return now }
+
+	var (
+		cli1 = netip.MustParseAddr("192.0.2.1")
+		cli2 = netip.MustParseAddr("192.0.2.2")
+	)
+
+	assert.True(t, n.allowBlocked(cli1))
+	assert.False(t, n.allowBlocked(cli1))
+	assert.True(t, n.allowBlocked(cli2))
+
+	now = now.Add(n.blockedIvl - time.Second)
+	assert.False(t, n.allowBlocked(cli1))
+
+	now = now.Add(time.Second)
+	assert.True(t, n.allowBlocked(cli1))
+}
+
+func TestDNSFilter_notifyBlocked(t *testing.T) {
+	const (
+		secret = "secret"
+		host   = "blocked.example"
+	)
+
+	cliIP := netip.MustParseAddr("192.0.2.1")
+	setts := &Settings{
+		ClientName: "client",
+		ClientIP:   cliIP,
+	}
+
+	wantBody := map[string]any{
+		"event":       "client_blocked",
+		"timestamp":   "2025-01-01T00:00:00Z",
+		"client_ip":   "192.0.2.1",
+		"client_name": "client",
+		"host":        host,
+		"reason":      "FilteredSafeBrowsing",
+	}
+
+	testCases := []struct {
+		name     string
+		events   []string
+		wantReqs int
+	}{{
+		name:     "enabled",
+		events:   []string{"client_blocked"},
+		wantReqs: 1,
+	}, {
+		name:     "disabled",
+		events:   nil,
+		wantReqs: 0,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv, reqs := newWebhookServer(t)
+
+			n, err := newWebhookNotifier(srv.Client(), srv.URL, secret, tc.events)
+			require.NoError(t, err)
+
+			n.now = func() (t time.Time) { return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC) }
+
+			d := &DNSFilter{
+				webhook:     n,
+				shutdownCtx: testutil.ContextWithTimeout(t, testTimeout),
+			}
+
+			// Only the first one of these should be sent due to the rate
+			// limiting.
+			d.notifyBlocked(host, FilteredSafeBrowsing, setts)
+			d.notifyBlocked(host, FilteredParental, setts)
+
+			if tc.wantReqs == 0 {
+				// Give the possible request some time to arrive.
+				time.Sleep(10 * time.Millisecond)
+				assert.Empty(t, reqs())
+
+				return
+			}
+
+			require.Eventually(t, func() (ok bool) {
+				return len(reqs()) == tc.wantReqs
+			}, time.Second, time.Millisecond)
+
+			r := reqs()[0]
+			gotBody := map[string]any{}
+			err = json.Unmarshal(r.body, &gotBody)
+			require.NoError(t, err)
+
+			assert.Equal(t, wantBody, gotBody)
+
+			mac := hmac.New(sha256.New, []byte(secret))
+			_, _ = mac.Write(r.body)
+			assert.Equal(t, hex.EncodeToString(mac.Sum(nil)), r.header.Get(WebhookSignatureHeader))
+
+			// Make sure the second notification wasn't sent after all.
+			time.Sleep(10 * time.Millisecond)
+			assert.Len(t, reqs(), tc.wantReqs)
+		})
+	}
+}
+
+func TestDNSFilter_handleFilteringRefresh_webhook(t *testing.T) {
+	srv, reqs := newWebhookServer(t)
+
+	n, err := newWebhookNotifier(srv.Client(), srv.URL, "", nil)
+	require.NoError(t, err)
+
+	d := newDNSFilter(t)
+	d.webhook = n
+	d.conf.Filters = []FilterYAML{{
+		Enabled: true,
+		URL:     serveFiltersLocally(t, []byte("||example.org^\n")),
+		Name:    "test-filter",
+		Filter:  Filter{ID: 1},
+	}}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(
+		http.MethodPost,
+		"/control/filtering/refresh",
+		strings.NewReader(`{"whitelist":false}`),
+	)
+
+	d.handleFilteringRefresh(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	require.Eventually(t, func() (ok bool) {
+		return len(reqs()) == 1
+	}, testTimeout, time.Millisecond)
+
+	gotBody := &webhookPayload{}
+	err = json.Unmarshal(reqs()[0].body, gotBody)
+	require.NoError(t, err)
+
+	assert.Equal(t, webhookEventFiltersUpdated, gotBody.Event)
+	require.Len(t, gotBody.Updated, 1)
+
+	assert.Equal(t, &filterUpdate{
+		ID:            1,
+		OldRulesCount: 0,
+		NewRulesCount: 1,
+	}, gotBody.Updated[0])
+}
@@ -0,0 +1,262 @@
+package home
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// apiTokenSize is the length of an API token in bytes.
+const apiTokenSize = 32
+
+// apiTokensPath is the path of the HTTP API for managing the API tokens.  API
+// tokens can't be used to access it.
+const apiTokensPath = "/control/tokens"
+
+// apiToken is a token for the automated access to the HTTP API, distinct from
+// the session cookie of the web interface.
+type apiToken struct {
+	// Name is the unique name of the token.
+	Name string `yaml:"name"`
+
+	// Hash is the hex-encoded SHA-256 hash of the token.  Tokens are generated
+	// randomly and have enough entropy, so there is no need for a slow hash
+	// function like the one used for passwords.
+	Hash string `yaml:"hash"`
+
+	// ReadOnly, if true, means that the token can't be used for the requests
+	// that modify data.
+	ReadOnly bool `yaml:"read_only"`
+}
+
+// hashAPIToken returns the hex-encoded SHA-256 hash of token.
+func hashAPIToken(token string) (hash string) {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// addAPIToken generates a new API token with the given name and stores its
+// hash.  token is the only copy of the token, it isn't stored anywhere.
+func (a *Auth) addAPIToken(name string, readOnly bool) (token string, err error) {
+	if name == "" {
+		return "", errors.Error("empty token name")
+	}
+
+	data := make([]byte, apiTokenSize)
+	_, err = rand.Read(data)
+	if err != nil {
+		return "", fmt.Errorf("generating token: %w", err)
+	}
+
+	token = hex.EncodeToString(data)
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if slices.ContainsFunc(a.apiTokens, func(t *apiToken) (ok bool) { return t.Name == name }) {
+		return "", fmt.Errorf("token with name %q already exists", name)
+	}
+
+	a.apiTokens = append(a.apiTokens, &apiToken{
+		Name:     name,
+		Hash:     hashAPIToken(token),
+		ReadOnly: readOnly,
+	})
+
+	log.Debug("auth: added api token %q", name)
+
+	return token, nil
+}
+
+// removeAPIToken removes the API token with the given name.  ok is false if
+// there is no such token.
+func (a *Auth) removeAPIToken(name string) (ok bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	l := len(a.apiTokens)
+	a.apiTokens = slices.DeleteFunc(a.apiTokens, func(t *apiToken) (found bool) {
+		return t.Name == name
+	})
+
+	if len(a.apiTokens) == l {
+		return false
+	}
+
+	log.Debug("auth: removed api token %q", name)
+
+	return true
+}
+
+// findAPIToken returns the stored API token matching token, if there is one.
+func (a *Auth) findAPIToken(token string) (t apiToken, ok bool) {
+	hash := []byte(hashAPIToken(token))
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	for _, at := range a.apiTokens {
+		if subtle.ConstantTimeCompare([]byte(at.Hash), hash) == 1 {
+			return *at, true
+		}
+	}
+
+	return apiToken{}, false
+}
+
+// apiTokensList returns a deep copy of the API tokens list.
+func (a *Auth) apiTokensList() (tokens []*apiToken) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	tokens = make([]*apiToken, 0, len(a.apiTokens))
+	for _, t := range a.apiTokens {
+		tokens = append(tokens, &apiToken{
+			Name:     t.Name,
+			Hash:     t.Hash,
+			ReadOnly: t.ReadOnly,
+		})
+	}
+
+	return tokens
+}
+
+// requestAPIToken returns the API token from the Authorization header of r.  ok
+// is false if r isn't a request to the HTTP API or has no bearer token.
+func requestAPIToken(r *http.Request) (token string, ok bool) {
+	if !strings.HasPrefix(r.URL.Path, "/control/") {
+		return "", false
+	}
+
+	scheme, token, ok := strings.Cut(r.Header.Get(httphdr.Authorization), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+
+	token = strings.TrimSpace(token)
+
+	return token, token != ""
+}
+
+// checkAPIToken checks if the request r authorized with token is allowed.  If
+// it isn't, it writes the error response to w and returns true.
+func checkAPIToken(w http.ResponseWriter, r *http.Request, token, pref string) (mustAuth bool) {
+	t, ok := Context.auth.findAPIToken(token)
+
+	var reason string
+	switch {
+	case !ok:
+		reason = "invalid api token"
+	case r.URL.Path == apiTokensPath || strings.HasPrefix(r.URL.Path, apiTokensPath+"/"):
+		reason = fmt.Sprintf("api token %q can't manage api tokens", t.Name)
+	case t.ReadOnly && modifiesData(r.Method):
+		reason = fmt.Sprintf("api token %q is read-only", t.Name)
+	default:
+		return false
+	}
+
+	log.Info("%s: %s: responded with forbidden to %s %s", pref, reason, r.Method, r.URL.Path)
+	w.WriteHeader(http.StatusForbidden)
+	_, _ = w.Write([]byte("Forbidden"))
+
+	return true
+}
+
+// apiTokenJSON is the JSON structure for an API token.
+type apiTokenJSON struct {
+	// Token is the token itself.  It's only returned once, when the token is
+	// created.
+	Token string `json:"token,omitempty"`
+
+	// Name is the unique name of the token.
+	Name string `json:"name"`
+
+	// ReadOnly, if true, means that the token can't be used for the requests
+	// that modify data.
+	ReadOnly bool `json:"read_only"`
+}
+
+// apiTokensListJSON is the response to the GET /control/tokens HTTP API.
+type apiTokensListJSON struct {
+	Tokens []*apiTokenJSON `json:"tokens"`
+}
+
+// handleAPITokensList is the handler for the GET /control/tokens HTTP API.
+func handleAPITokensList(w http.ResponseWriter, r *http.Request) {
+	resp := &apiTokensListJSON{
+		Tokens: []*apiTokenJSON{},
+	}
+
+	for _, t := range Context.auth.apiTokensList() {
+		resp.Tokens = append(resp.Tokens, &apiTokenJSON{
+			Name:     t.Name,
+			ReadOnly: t.ReadOnly,
+		})
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
+
+// handleAPITokensCreate is the handler for the POST /control/tokens HTTP API.
+func handleAPITokensCreate(w http.ResponseWriter, r *http.Request) {
+	req := &apiTokenJSON{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "json decode: %s", err)
+
+		return
+	}
+
+	token, err := Context.auth.addAPIToken(req.Name, req.ReadOnly)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "creating token: %s", err)
+
+		return
+	}
+
+	onConfigModified()
+
+	aghhttp.WriteJSONResponseOK(w, r, &apiTokenJSON{
+		Token:    token,
+		Name:     req.Name,
+		ReadOnly: req.ReadOnly,
+	})
+}
+
+// apiTokenRevokeJSON is the request to the DELETE /control/tokens HTTP API.
+type apiTokenRevokeJSON struct {
+	Name string `json:"name"`
+}
+
+// handleAPITokensRevoke is the handler for the DELETE /control/tokens HTTP API.
+func handleAPITokensRevoke(w http.ResponseWriter, r *http.Request) {
+	req := &apiTokenRevokeJSON{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "json decode: %s", err)
+
+		return
+	}
+
+	if !Context.auth.removeAPIToken(req.Name) {
+		aghhttp.Error(r, w, http.StatusNotFound, "token %q not found", req.Name)
+
+		return
+	}
+
+	onConfigModified()
+
+	aghhttp.OK(w)
+}
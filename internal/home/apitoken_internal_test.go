@@ -0,0 +1,111 @@
+package home
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuth_apiTokens(t *testing.T) {
+	users := []webUser{{
+		Name:         "name",
+		PasswordHash: "$2y$05$..vyzAECIhJPfaQiOK17IukcQnqEgKJHy0iETyYqxn3YXJl8yZuo2",
+	}}
+
+	Context.auth = InitAuth(filepath.Join(t.TempDir(), "sessions.db"), users, nil, 60, nil, nil)
+	require.NotNil(t, Context.auth)
+	t.Cleanup(func() {
+		Context.auth.Close()
+		Context.auth = nil
+	})
+
+	roToken, err := Context.auth.addAPIToken("read_only", true)
+	require.NoError(t, err)
+
+	rwToken, err := Context.auth.addAPIToken("read_write", false)
+	require.NoError(t, err)
+
+	_, err = Context.auth.addAPIToken("read_only", false)
+	require.Error(t, err)
+
+	for _, tok := range Context.auth.apiTokensList() {
+		assert.NotEqual(t, roToken, tok.Hash)
+		assert.NotEqual(t, rwToken, tok.Hash)
+	}
+
+	handler := optionalAuth(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	testCases := []struct {
+		name     string
+		token    string
+		method   string
+		path     string
+		wantCode int
+	}{{
+		name:     "read_only_get",
+		token:    roToken,
+		method:   http.MethodGet,
+		path:     "/control/status",
+		wantCode: http.StatusOK,
+	}, {
+		name:     "read_only_post",
+		token:    roToken,
+		method:   http.MethodPost,
+		path:     "/control/dns_config",
+		wantCode: http.StatusForbidden,
+	}, {
+		name:     "read_write_post",
+		token:    rwToken,
+		method:   http.MethodPost,
+		path:     "/control/dns_config",
+		wantCode: http.StatusOK,
+	}, {
+		name:     "tokens_management",
+		token:    rwToken,
+		method:   http.MethodPost,
+		path:     apiTokensPath,
+		wantCode: http.StatusForbidden,
+	}, {
+		name:     "invalid",
+		token:    "invalid",
+		method:   http.MethodGet,
+		path:     "/control/status",
+		wantCode: http.StatusForbidden,
+	}, {
+		name:     "not_api",
+		token:    rwToken,
+		method:   http.MethodGet,
+		path:     "/",
+		wantCode: http.StatusFound,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, tc.path, nil)
+			r.Header.Set(httphdr.Authorization, "Bearer "+tc.token)
+			w := httptest.NewRecorder()
+
+			handler(w, r)
+
+			assert.Equal(t, tc.wantCode, w.Code)
+		})
+	}
+
+	require.True(t, Context.auth.removeAPIToken("read_only"))
+	assert.False(t, Context.auth.removeAPIToken("read_only"))
+
+	r := httptest.NewRequest(http.MethodGet, "/control/status", nil)
+	r.Header.Set(httphdr.Authorization, "Bearer "+roToken)
+	w := httptest.NewRecorder()
+
+	handler(w, r)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
@@ -60,6 +60,7 @@ type Auth struct {
 	rateLimiter    *authRateLimiter
 	sessions       map[string]*session
 	users          []webUser
+	apiTokens      []*apiToken
 	lock           sync.Mutex
 	sessionTTL     uint32
 }
@@ -76,6 +77,7 @@ type webUser struct {
 func InitAuth(
 	dbFilename string,
 	users []webUser,
+	apiTokens []*apiToken,
 	sessionTTL uint32,
 	rateLimiter *authRateLimiter,
 	trustedProxies netutil.SubnetSet,
@@ -87,6 +89,7 @@ func InitAuth(
 		rateLimiter:    rateLimiter,
 		sessions:       make(map[string]*session),
 		users:          users,
+		apiTokens:      apiTokens,
 		trustedProxies: trustedProxies,
 	}
 	var err error
@@ -101,13 +104,26 @@ func InitAuth(
 		return nil
 	}
 	a.loadSessions()
-	log.Info("auth: initialized.  users:%d  sessions:%d", len(a.users), len(a.sessions))
+	log.Info(
+		"auth: initialized.  users:%d  sessions:%d  api tokens:%d",
+		len(a.users),
+		len(a.sessions),
+		len(a.apiTokens),
+	)
+
+	if rateLimiter != nil {
+		go rateLimiter.periodicCleanup()
+	}
 
 	return a
 }
 
-// Close closes the authentication database.
+// Close closes the authentication database and stops the rate limiter.
 func (a *Auth) Close() {
+	if a.rateLimiter != nil {
+		a.rateLimiter.shutdown()
+	}
+
 	_ = a.db.Close()
 }
 
@@ -23,7 +23,7 @@ import (
 const cookieTTL = 365 * timeutil.Day
 
 // sessionCookieName is the name of the session cookie.
-const sessionCookieName = "agh_session"
+const sessionCookieName = aghhttp.SessionCookieName
 
 // loginJSON is the JSON structure for authentication.
 type loginJSON struct {
@@ -112,6 +112,43 @@ func realIP(r *http.Request) (ip netip.Addr, err error) {
 	return netip.ParseAddr(ipStr)
 }
 
+// loginRemoteIP returns the address of the client that tries to log in.  The
+// proxy headers are only taken into account if the request comes directly from
+// one of trustedProxies, which may be nil.
+//
+// See https://github.com/AdguardTeam/AdGuardHome/issues/2799.
+func loginRemoteIP(r *http.Request, trustedProxies netutil.SubnetSet) (ip string, err error) {
+	ip, err = netutil.SplitHost(r.RemoteAddr)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return "", err
+	}
+
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || trustedProxies == nil || !trustedProxies.Contains(addr.Unmap()) {
+		return ip, nil
+	}
+
+	realAddr, err := realIP(r)
+	if err != nil {
+		log.Error("auth: getting real ip from request with remote ip %s: %s", ip, err)
+
+		return ip, nil
+	}
+
+	return realAddr.String(), nil
+}
+
+// requestUserName returns the name of the user that has made r.  It returns an
+// empty string if the user is unknown.
+func requestUserName(r *http.Request) (name string) {
+	if Context.auth == nil {
+		return ""
+	}
+
+	return Context.auth.getCurrentUser(r).Name
+}
+
 // writeErrorWithIP is like [aghhttp.Error], but includes the remote IP address
 // when it writes to the log.
 func writeErrorWithIP(
@@ -137,12 +174,8 @@ func handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var remoteIP string
-	// realIP cannot be used here without taking TrustedProxies into account due
-	// to security issues.
-	//
-	// See https://github.com/AdguardTeam/AdGuardHome/issues/2799.
-	if remoteIP, err = netutil.SplitHost(r.RemoteAddr); err != nil {
+	remoteIP, err := loginRemoteIP(r, Context.auth.trustedProxies)
+	if err != nil {
 		writeErrorWithIP(
 			r,
 			w,
@@ -171,24 +204,14 @@ func handleLogin(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	ip, err := realIP(r)
-	if err != nil {
-		log.Error("auth: getting real ip from request with remote ip %s: %s", remoteIP, err)
-	}
-
 	cookie, err := Context.auth.newCookie(req, remoteIP)
 	if err != nil {
-		logIP := remoteIP
-		if Context.auth.trustedProxies.Contains(ip.Unmap()) {
-			logIP = ip.String()
-		}
-
-		writeErrorWithIP(r, w, http.StatusForbidden, logIP, "%s", err)
+		writeErrorWithIP(r, w, http.StatusForbidden, remoteIP, "%s", err)
 
 		return
 	}
 
-	log.Info("auth: user %q successfully logged in from ip %s", req.Name, ip)
+	log.Info("auth: user %q successfully logged in from ip %s", req.Name, remoteIP)
 
 	http.SetCookie(w, cookie)
 
@@ -230,10 +253,39 @@ func handleLogout(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusFound)
 }
 
+// loginAttemptsResp is the response to the GET /control/login_attempts HTTP
+// API.
+type loginAttemptsResp struct {
+	// Attempts are the tracked failed login attempts.  It's empty if the login
+	// throttling is disabled.
+	Attempts []*loginAttempt `json:"attempts"`
+}
+
+// handleLoginAttempts is the handler for the GET /control/login_attempts HTTP
+// API.
+func handleLoginAttempts(w http.ResponseWriter, r *http.Request) {
+	resp := &loginAttemptsResp{
+		Attempts: []*loginAttempt{},
+	}
+
+	if rateLimiter := Context.auth.rateLimiter; rateLimiter != nil {
+		resp.Attempts = rateLimiter.list()
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, resp)
+}
+
 // RegisterAuthHandlers - register handlers
 func RegisterAuthHandlers() {
 	Context.mux.Handle("/control/login", postInstallHandler(ensureHandler(http.MethodPost, handleLogin)))
 	httpRegister(http.MethodGet, "/control/logout", handleLogout)
+	httpRegister(http.MethodGet, "/control/login_attempts", handleLoginAttempts)
+
+
+	// The API tokens handlers share the path, so use the method patterns.
+	httpRegister(http.MethodGet, "GET "+apiTokensPath, handleAPITokensList)
+	httpRegister(http.MethodPost, "POST "+apiTokensPath, handleAPITokensCreate)
+	httpRegister(http.MethodDelete, "DELETE "+apiTokensPath, handleAPITokensRevoke)
 }
 
 // optionalAuthThird returns true if a user should authenticate first.
@@ -246,6 +298,10 @@ func optionalAuthThird(w http.ResponseWriter, r *http.Request) (mustAuth bool) {
 		return false
 	}
 
+	if token, ok := requestAPIToken(r); ok {
+		return checkAPIToken(w, r, token, pref)
+	}
+
 	// redirect to login page if not authenticated
 	isAuthenticated := false
 	cookie, err := r.Cookie(sessionCookieName)
@@ -287,6 +343,56 @@ func optionalAuthThird(w http.ResponseWriter, r *http.Request) (mustAuth bool) {
 	return true
 }
 
+// hasValidSession returns true if r has the cookie of a valid session.
+func hasValidSession(r *http.Request) (ok bool) {
+	if Context.auth == nil {
+		return false
+	}
+
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		// The only error that is returned from r.Cookie is [http.ErrNoCookie].
+		return false
+	}
+
+	return Context.auth.checkSession(cookie.Value) == checkSessionOK
+}
+
+// isRateLimitExempt returns true if r must not be rate limited by the web
+// server, which is only the case when r has a valid session or API token.  The
+// requests are rate limited even if the authentication is disabled.
+func isRateLimitExempt(r *http.Request) (ok bool) {
+	if Context.auth == nil {
+		return false
+	}
+
+	if token, isToken := requestAPIToken(r); isToken {
+		_, ok = Context.auth.findAPIToken(token)
+
+		return ok
+	}
+
+	return hasValidSession(r)
+}
+
+// rateLimitRemoteIP returns the address of the client that has made r for the
+// rate limiting of the web server.  The proxy headers are only taken into
+// account if the request comes directly from one of the trusted proxies.
+func rateLimitRemoteIP(r *http.Request) (ip netip.Addr, err error) {
+	var trustedProxies netutil.SubnetSet
+	if Context.auth != nil {
+		trustedProxies = Context.auth.trustedProxies
+	}
+
+	ipStr, err := loginRemoteIP(r, trustedProxies)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return netip.Addr{}, err
+	}
+
+	return netip.ParseAddr(ipStr)
+}
+
 // TODO(a.garipov): Use [http.Handler] consistently everywhere throughout the
 // project.
 func optionalAuth(
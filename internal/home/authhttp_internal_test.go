@@ -1,14 +1,20 @@
 package home
 
 import (
+	"encoding/json"
 	"net/http"
+	"net/http/httptest"
 	"net/netip"
 	"net/textproto"
 	"net/url"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/AdguardTeam/golibs/netutil"
 	"github.com/AdguardTeam/golibs/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -39,7 +45,7 @@ func TestAuthHTTP(t *testing.T) {
 	users := []webUser{
 		{Name: "name", PasswordHash: "$2y$05$..vyzAECIhJPfaQiOK17IukcQnqEgKJHy0iETyYqxn3YXJl8yZuo2"},
 	}
-	Context.auth = InitAuth(fn, users, 60, nil, nil)
+	Context.auth = InitAuth(fn, users, nil, 60, nil, nil)
 
 	handlerCalled := false
 	handler := func(_ http.ResponseWriter, _ *http.Request) {
@@ -173,3 +179,182 @@ func TestRealIP(t *testing.T) {
 		})
 	}
 }
+
+func TestHandleLogin_rateLimit(t *testing.T) {
+	const (
+		remoteIP = "192.0.2.1"
+		maxAtt   = 2
+		blockDur = 15 * time.Minute
+	)
+
+	users := []webUser{
+		{Name: "name", PasswordHash: "$2y$05$..vyzAECIhJPfaQiOK17IukcQnqEgKJHy0iETyYqxn3YXJl8yZuo2"},
+	}
+
+	rateLimiter := newAuthRateLimiter(slogutil.NewDiscardLogger(), blockDur, maxAtt)
+	Context.auth = InitAuth(
+		filepath.Join(t.TempDir(), "sessions.db"),
+		users,
+		nil,
+		60,
+		rateLimiter,
+		nil,
+	)
+	require.NotNil(t, Context.auth)
+	t.Cleanup(Context.auth.Close)
+
+	login := func(t *testing.T, passwd string) (w *httptest.ResponseRecorder) {
+		t.Helper()
+
+		body := `{"name":"name","password":"` + passwd + `"}`
+		r := httptest.NewRequest(http.MethodPost, "/control/login", strings.NewReader(body))
+		r.RemoteAddr = remoteIP + ":12345"
+
+		w = httptest.NewRecorder()
+		handleLogin(w, r)
+
+		return w
+	}
+
+	for range maxAtt {
+		w := login(t, "wrong")
+		require.Equal(t, http.StatusForbidden, w.Code)
+	}
+
+	w := login(t, "password")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get(httphdr.RetryAfter))
+
+	t.Run("attempts", func(t *testing.T) {
+		aw := httptest.NewRecorder()
+		handleLoginAttempts(aw, httptest.NewRequest(http.MethodGet, "/control/login_attempts", nil))
+		require.Equal(t, http.StatusOK, aw.Code)
+
+		resp := &loginAttemptsResp{}
+		err := json.NewDecoder(aw.Body).Decode(resp)
+		require.NoError(t, err)
+
+		require.Len(t, resp.Attempts, 1)
+
+		att := resp.Attempts[0]
+		assert.Equal(t, remoteIP, att.IP)
+		assert.EqualValues(t, maxAtt, att.Failures)
+		require.NotNil(t, att.BlockedUntil)
+		assert.True(t, att.BlockedUntil.After(time.Now()))
+	})
+
+	// Simulate the end of the block.
+	rateLimiter.failedAuthsLock.Lock()
+	a := rateLimiter.failedAuths[remoteIP]
+	a.until = time.Now().Add(-time.Second)
+	rateLimiter.failedAuths[remoteIP] = a
+	rateLimiter.failedAuthsLock.Unlock()
+
+	w = login(t, "password")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	assert.Empty(t, rateLimiter.list())
+}
+
+func TestLoginRemoteIP(t *testing.T) {
+	const (
+		proxyAddr = "192.0.2.1:12345"
+		realAddr  = "198.51.100.1"
+	)
+
+	trusted := netutil.SliceSubnetSet{netip.MustParsePrefix("192.0.2.0/24")}
+
+	hdr := http.Header{
+		textproto.CanonicalMIMEHeaderKey(httphdr.XRealIP): []string{realAddr},
+	}
+
+	testCases := []struct {
+		trustedProxies netutil.SubnetSet
+		name           string
+		want           string
+	}{{
+		trustedProxies: nil,
+		name:           "no_trusted_proxies",
+		want:           "192.0.2.1",
+	}, {
+		trustedProxies: netutil.SliceSubnetSet{netip.MustParsePrefix("203.0.113.0/24")},
+		name:           "untrusted",
+		want:           "192.0.2.1",
+	}, {
+		trustedProxies: trusted,
+		name:           "trusted",
+		want:           realAddr,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &http.Request{
+				Header:     hdr,
+				RemoteAddr: proxyAddr,
+			}
+
+			ip, err := loginRemoteIP(r, tc.trustedProxies)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.want, ip)
+		})
+	}
+}
+
+func TestIsRateLimitExempt(t *testing.T) {
+	Context.auth = nil
+
+	r := httptest.NewRequest(http.MethodPost, "/control/test_upstream_dns", nil)
+	assert.False(t, isRateLimitExempt(r))
+
+	// The requests are rate limited even if the authentication is disabled.
+	Context.auth = InitAuth(filepath.Join(t.TempDir(), "sessions.db"), nil, nil, 60, nil, nil)
+	require.NotNil(t, Context.auth)
+	require.False(t, Context.auth.authRequired())
+
+	assert.False(t, isRateLimitExempt(r))
+	Context.auth.Close()
+
+	users := []webUser{
+		{Name: "name", PasswordHash: "$2y$05$..vyzAECIhJPfaQiOK17IukcQnqEgKJHy0iETyYqxn3YXJl8yZuo2"},
+	}
+
+	Context.auth = InitAuth(filepath.Join(t.TempDir(), "sessions.db"), users, nil, 60, nil, nil)
+	require.NotNil(t, Context.auth)
+	t.Cleanup(func() {
+		Context.auth.Close()
+		Context.auth = nil
+	})
+
+	token, err := Context.auth.addAPIToken("token", true)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name    string
+		authHdr string
+		want    bool
+	}{{
+		name:    "unauthenticated",
+		authHdr: "",
+		want:    false,
+	}, {
+		name:    "valid_token",
+		authHdr: "Bearer " + token,
+		want:    true,
+	}, {
+		name:    "invalid_token",
+		authHdr: "Bearer invalid",
+		want:    false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r = httptest.NewRequest(http.MethodPost, "/control/test_upstream_dns", nil)
+			if tc.authHdr != "" {
+				r.Header.Set(httphdr.Authorization, tc.authHdr)
+			}
+
+			assert.Equal(t, tc.want, isRateLimitExempt(r))
+		})
+	}
+}
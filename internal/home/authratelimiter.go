@@ -1,14 +1,38 @@
 package home
 
 import (
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
 )
 
 // failedAuthTTL is the period of time for which the failed attempt will stay in
 // cache.
 const failedAuthTTL = 1 * time.Minute
 
+// blockedAuthTTL is the period of time for which the attempter stays in cache
+// after the end of its block, so that its next failed attempt doubles the block
+// duration.
+const blockedAuthTTL = 1 * time.Hour
+
+const (
+	// minAuthBlockDur is the duration of the first block when the lockout
+	// duration isn't set.
+	minAuthBlockDur = 1 * time.Second
+
+	// maxAuthBlockDur is the maximum duration of a block.
+	maxAuthBlockDur = 24 * time.Hour
+)
+
+// authCleanupIvl is the interval between the periodic removals of the expired
+// entries from the cache.
+const authCleanupIvl = 1 * time.Minute
+
 // failedAuth is an entry of authRateLimiter's cache.
 type failedAuth struct {
 	until time.Time
@@ -17,32 +41,86 @@ type failedAuth struct {
 
 // authRateLimiter used to cache failed authentication attempts.
 type authRateLimiter struct {
+	// logger is used to log the failed attempts.
+	logger *slog.Logger
+
+	// done is closed when the periodic cleanup should be stopped.
+	done chan struct{}
+
 	failedAuths map[string]failedAuth
 	// failedAuthsLock protects failedAuths.
 	failedAuthsLock sync.Mutex
-	blockDur        time.Duration
-	maxAttempts     uint
+
+	// blockDur is the duration of the first block.  Each subsequent failed
+	// attempt doubles it.  If it's zero, [minAuthBlockDur] is used.
+	blockDur    time.Duration
+	maxAttempts uint
 }
 
-// newAuthRateLimiter returns properly initialized *authRateLimiter.
-func newAuthRateLimiter(blockDur time.Duration, maxAttempts uint) (ab *authRateLimiter) {
+// newAuthRateLimiter returns properly initialized *authRateLimiter.  logger
+// must not be nil.
+func newAuthRateLimiter(
+	logger *slog.Logger,
+	blockDur time.Duration,
+	maxAttempts uint,
+) (ab *authRateLimiter) {
 	return &authRateLimiter{
+		logger:      logger,
+		done:        make(chan struct{}),
 		failedAuths: make(map[string]failedAuth),
 		blockDur:    blockDur,
 		maxAttempts: maxAttempts,
 	}
 }
 
+// periodicCleanup removes the expired entries from the cache every
+// [authCleanupIvl] until ab is shut down.  It is intended to be used as a
+// goroutine.
+func (ab *authRateLimiter) periodicCleanup() {
+	ctx := context.Background()
+	defer slogutil.RecoverAndLog(ctx, ab.logger)
+
+	t := time.NewTicker(authCleanupIvl)
+	defer t.Stop()
+
+	for {
+		select {
+		case now := <-t.C:
+			ab.failedAuthsLock.Lock()
+			ab.cleanupLocked(now)
+			ab.failedAuthsLock.Unlock()
+		case <-ab.done:
+			return
+		}
+	}
+}
+
+// shutdown stops the periodic cleanup.
+func (ab *authRateLimiter) shutdown() {
+	close(ab.done)
+}
+
 // cleanupLocked checks each blocked users removing ones with expired TTL.  For
 // internal use only.
 func (ab *authRateLimiter) cleanupLocked(now time.Time) {
 	for k, v := range ab.failedAuths {
-		if now.After(v.until) {
+		expire := v.until
+		if ab.isBlocked(v) {
+			expire = expire.Add(blockedAuthTTL)
+		}
+
+		if now.After(expire) {
 			delete(ab.failedAuths, k)
 		}
 	}
 }
 
+// isBlocked returns true if a has reached the limit of attempts.  It doesn't
+// check if the block has ended.
+func (ab *authRateLimiter) isBlocked(a failedAuth) (ok bool) {
+	return ab.maxAttempts > 0 && a.num >= ab.maxAttempts
+}
+
 // checkLocked checks the attempter for it's state.  For internal use only.
 func (ab *authRateLimiter) checkLocked(usrID string, now time.Time) (left time.Duration) {
 	a, ok := ab.failedAuths[usrID]
@@ -70,9 +148,28 @@ func (ab *authRateLimiter) check(usrID string) (left time.Duration) {
 	return ab.checkLocked(usrID, now)
 }
 
+// blockDurFor returns the duration of the block after attNum failed attempts,
+// which must be not less than ab.maxAttempts.  The duration is doubled with
+// each attempt above the limit up to [maxAuthBlockDur].
+func (ab *authRateLimiter) blockDurFor(attNum uint) (dur time.Duration) {
+	dur = ab.blockDur
+	if dur <= 0 {
+		dur = minAuthBlockDur
+	}
+
+	for range attNum - ab.maxAttempts {
+		dur *= 2
+		if dur >= maxAuthBlockDur {
+			return maxAuthBlockDur
+		}
+	}
+
+	return min(dur, maxAuthBlockDur)
+}
+
 // incLocked increments the number of unsuccessful attempts for attempter with
 // usrID and updates it's blocking moment if needed.  For internal use only.
-func (ab *authRateLimiter) incLocked(usrID string, now time.Time) {
+func (ab *authRateLimiter) incLocked(usrID string, now time.Time) (a failedAuth) {
 	until := now.Add(failedAuthTTL)
 	var attNum uint = 1
 
@@ -82,23 +179,42 @@ func (ab *authRateLimiter) incLocked(usrID string, now time.Time) {
 		attNum = a.num + 1
 	}
 	if attNum >= ab.maxAttempts {
-		until = now.Add(ab.blockDur)
+		until = now.Add(ab.blockDurFor(attNum))
 	}
 
-	ab.failedAuths[usrID] = failedAuth{
+	a = failedAuth{
 		num:   attNum,
 		until: until,
 	}
+	ab.failedAuths[usrID] = a
+
+	return a
 }
 
-// inc updates the failed attempt in cache.
+// inc updates the failed attempt in cache and logs it.
 func (ab *authRateLimiter) inc(usrID string) {
 	now := time.Now()
 
 	ab.failedAuthsLock.Lock()
 	defer ab.failedAuthsLock.Unlock()
 
-	ab.incLocked(usrID, now)
+	a := ab.incLocked(usrID, now)
+	if ab.logger == nil {
+		return
+	}
+
+	ctx := context.Background()
+	if a.num < ab.maxAttempts {
+		ab.logger.InfoContext(ctx, "failed login attempt", "ip", usrID, "failures", a.num)
+	} else {
+		ab.logger.WarnContext(
+			ctx,
+			"failed login attempt; blocking",
+			"ip", usrID,
+			"failures", a.num,
+			"blocked_for", a.until.Sub(now),
+		)
+	}
 }
 
 // remove stops any tracking and any blocking of the user.
@@ -108,3 +224,48 @@ func (ab *authRateLimiter) remove(usrID string) {
 
 	delete(ab.failedAuths, usrID)
 }
+
+// loginAttempt is the information about the failed login attempts from a
+// single address.
+type loginAttempt struct {
+	// BlockedUntil is the time until which the address is blocked.  It's nil if
+	// the address isn't blocked.
+	BlockedUntil *time.Time `json:"blocked_until,omitempty"`
+
+	// IP is the address of the attempter.
+	IP string `json:"ip"`
+
+	// Failures is the number of failed attempts.
+	Failures uint `json:"failures"`
+}
+
+// list returns the currently tracked attempters sorted by their addresses.
+func (ab *authRateLimiter) list() (attempts []*loginAttempt) {
+	now := time.Now()
+
+	ab.failedAuthsLock.Lock()
+	defer ab.failedAuthsLock.Unlock()
+
+	ab.cleanupLocked(now)
+
+	attempts = make([]*loginAttempt, 0, len(ab.failedAuths))
+	for k, v := range ab.failedAuths {
+		la := &loginAttempt{
+			IP:       k,
+			Failures: v.num,
+		}
+
+		if ab.isBlocked(v) && v.until.After(now) {
+			until := v.until.UTC()
+			la.BlockedUntil = &until
+		}
+
+		attempts = append(attempts, la)
+	}
+
+	slices.SortFunc(attempts, func(a, b *loginAttempt) (res int) {
+		return strings.Compare(a.IP, b.IP)
+	})
+
+	return attempts
+}
@@ -205,3 +205,45 @@ func TestAuthRateLimiter_Remove(t *testing.T) {
 
 	assert.Empty(t, ab.failedAuths)
 }
+
+func TestAuthRateLimiter_blockDurFor(t *testing.T) {
+	const maxAtt = 3
+
+	testCases := []struct {
+		name     string
+		blockDur time.Duration
+		attNum   uint
+		want     time.Duration
+	}{{
+		name:     "first",
+		blockDur: 15 * time.Minute,
+		attNum:   maxAtt,
+		want:     15 * time.Minute,
+	}, {
+		name:     "doubled",
+		blockDur: 15 * time.Minute,
+		attNum:   maxAtt + 2,
+		want:     time.Hour,
+	}, {
+		name:     "capped",
+		blockDur: 15 * time.Minute,
+		attNum:   maxAtt + 100,
+		want:     maxAuthBlockDur,
+	}, {
+		name:     "no_lockout",
+		blockDur: 0,
+		attNum:   maxAtt + 1,
+		want:     2 * minAuthBlockDur,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ab := &authRateLimiter{
+				blockDur:    tc.blockDur,
+				maxAttempts: maxAtt,
+			}
+
+			assert.Equal(t, tc.want, ab.blockDurFor(tc.attNum))
+		})
+	}
+}
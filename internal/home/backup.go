@@ -0,0 +1,320 @@
+package home
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/AdGuardHome/internal/configmigrate"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/httphdr"
+)
+
+// Clients backup format
+//
+// The backup of the persistent clients is a JSON object of the following
+// form:
+//
+//	{
+//	  "version": 1,
+//	  "schema_version": 30,
+//	  "clients": [
+//	    {
+//	      "name": "My Client",
+//	      "uid": "01234567-89ab-cdef-0123-456789abcdef",
+//	      "ids": ["192.0.2.1"],
+//	      …
+//	    }
+//	  ],
+//	  "signature": "0123abcd…"
+//	}
+//
+// The "version" field is the version of the envelope format itself, see
+// [clientsBackupVersion].  The "schema_version" field is the schema version of
+// the configuration file the clients have been exported from.  The "clients"
+// field is the list of the persistent clients in the same form as the one used
+// in the configuration file, see [clientObject].
+//
+// The "signature" field is the hex-encoded HMAC-SHA256 of the following
+// message:
+//
+//	<version> LF <schema_version> LF <compacted clients JSON>
+//
+// The key of the HMAC is itself the HMAC-SHA256 of [clientsBackupKeyLabel]
+// keyed with the password hash of the admin user, that is the first user in
+// the configuration file.  Therefore, changing the password of the admin user
+// invalidates all the previously created backups.
+
+const (
+	// clientsBackupVersion is the current version of the clients backup
+	// envelope format.
+	clientsBackupVersion uint = 1
+
+	// minClientsBackupSchemaVersion is the earliest configuration schema
+	// version of the clients backup, which clients are compatible with the
+	// current ones.  The schema version 22 changed the format of the blocked
+	// services of the persistent clients.
+	minClientsBackupSchemaVersion uint = 22
+
+	// clientsBackupKeyLabel is the label used to derive the signing key of the
+	// clients backup from the password hash.
+	clientsBackupKeyLabel = "adguardhome clients backup"
+)
+
+// clientsBackup is the signed envelope of the persistent clients backup.
+type clientsBackup struct {
+	// Clients is the JSON-encoded list of the persistent clients.
+	Clients json.RawMessage `json:"clients"`
+
+	// Signature is the hex-encoded HMAC-SHA256 of the backup.
+	Signature string `json:"signature"`
+
+	// Version is the version of the envelope format.
+	Version uint `json:"version"`
+
+	// SchemaVersion is the configuration schema version of the clients.
+	SchemaVersion uint `json:"schema_version"`
+}
+
+// newClientsBackupKey returns the signing key of the clients backup derived
+// from passwordHash.
+func newClientsBackupKey(passwordHash string) (key []byte) {
+	mac := hmac.New(sha256.New, []byte(passwordHash))
+
+	// Don't check the error, since [hash.Hash.Write] never returns one.
+	_, _ = mac.Write([]byte(clientsBackupKeyLabel))
+
+	return mac.Sum(nil)
+}
+
+// sum returns the HMAC-SHA256 of the backup keyed with key.
+func (b *clientsBackup) sum(key []byte) (sum []byte, err error) {
+	// Compact the clients, since the envelope may have been reformatted after
+	// signing.
+	clients := &bytes.Buffer{}
+	err = json.Compact(clients, b.Clients)
+	if err != nil {
+		return nil, fmt.Errorf("compacting clients: %w", err)
+	}
+
+	mac := hmac.New(sha256.New, key)
+
+	// Don't check the errors, since [hash.Hash.Write] never returns one.
+	_, _ = fmt.Fprintf(mac, "%d\n%d\n", b.Version, b.SchemaVersion)
+	_, _ = mac.Write(clients.Bytes())
+
+	return mac.Sum(nil), nil
+}
+
+// sign sets the signature of the backup using key.
+func (b *clientsBackup) sign(key []byte) (err error) {
+	sum, err := b.sum(key)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	b.Signature = hex.EncodeToString(sum)
+
+	return nil
+}
+
+// verify returns an error if the signature of the backup isn't valid for key.
+func (b *clientsBackup) verify(key []byte) (err error) {
+	sig, err := hex.DecodeString(b.Signature)
+	if err != nil {
+		return fmt.Errorf("decoding signature: %w", err)
+	}
+
+	sum, err := b.sum(key)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	if !hmac.Equal(sig, sum) {
+		return errors.Error("signature mismatch")
+	}
+
+	return nil
+}
+
+// validateVersions returns an error if the backup can't be restored by the
+// current version of AdGuard Home.
+func (b *clientsBackup) validateVersions() (err error) {
+	if b.Version != clientsBackupVersion {
+		return fmt.Errorf("unsupported backup version %d", b.Version)
+	}
+
+	if b.SchemaVersion < minClientsBackupSchemaVersion {
+		return fmt.Errorf(
+			"schema version %d is too old, minimum is %d",
+			b.SchemaVersion,
+			minClientsBackupSchemaVersion,
+		)
+	} else if b.SchemaVersion > configmigrate.LastSchemaVersion {
+		return fmt.Errorf(
+			"schema version %d is too new, maximum is %d",
+			b.SchemaVersion,
+			configmigrate.LastSchemaVersion,
+		)
+	}
+
+	return nil
+}
+
+// adminBackupKey returns the signing key of the clients backup derived from
+// the password hash of the admin user.
+func adminBackupKey() (key []byte, err error) {
+	if Context.auth == nil {
+		return nil, errors.Error("authentication is not configured")
+	}
+
+	users := Context.auth.usersList()
+	if len(users) == 0 {
+		return nil, errors.Error("no admin user")
+	}
+
+	return newClientsBackupKey(users[0].PasswordHash), nil
+}
+
+// handleClientsBackup is the handler for the GET /control/clients/backup HTTP
+// API.
+func (clients *clientsContainer) handleClientsBackup(w http.ResponseWriter, r *http.Request) {
+	key, err := adminBackupKey()
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusForbidden, "signing backup: %s", err)
+
+		return
+	}
+
+	data, err := json.Marshal(clients.forConfig())
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "encoding clients: %s", err)
+
+		return
+	}
+
+	b := &clientsBackup{
+		Clients:       data,
+		Version:       clientsBackupVersion,
+		SchemaVersion: configmigrate.LastSchemaVersion,
+	}
+
+	err = b.sign(key)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "signing backup: %s", err)
+
+		return
+	}
+
+	w.Header().Set(httphdr.ContentDisposition, `attachment; filename=clients_backup.json`)
+
+	aghhttp.WriteJSONResponseOK(w, r, b)
+}
+
+// handleClientsRestore is the handler for the POST /control/clients/restore
+// HTTP API.
+func (clients *clientsContainer) handleClientsRestore(w http.ResponseWriter, r *http.Request) {
+	b := &clientsBackup{}
+	err := json.NewDecoder(r.Body).Decode(b)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "failed to process request body: %s", err)
+
+		return
+	}
+
+	key, err := adminBackupKey()
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusForbidden, "verifying backup: %s", err)
+
+		return
+	}
+
+	err = b.verify(key)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "verifying backup: %s", err)
+
+		return
+	}
+
+	err = b.validateVersions()
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
+	var objs []*clientObject
+	err = json.Unmarshal(b.Clients, &objs)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "decoding clients: %s", err)
+
+		return
+	}
+
+	err = clients.restore(r.Context(), objs)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "restoring clients: %s", err)
+
+		return
+	}
+
+	if clients.testing {
+		return
+	}
+
+	onConfigModified()
+
+	if isRunning() {
+		err = reconfigureDNSServer()
+		if err != nil {
+			aghhttp.Error(r, w, http.StatusInternalServerError, "%s", err)
+		}
+	}
+}
+
+// restore replaces the persistent clients with the ones from objects and
+// restarts the storage.  The stored persistent clients aren't changed if any
+// of objects is invalid.
+func (clients *clientsContainer) restore(
+	ctx context.Context,
+	objects []*clientObject,
+) (err error) {
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	persistent, err := clients.toPersistent(ctx, objects)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	err = clients.storage.Reload(ctx, persistent)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	// Restart the storage to drop the state left from the previous clients,
+	// such as the upstream configurations, and to re-read the runtime sources.
+	err = clients.storage.Shutdown(ctx)
+	if err != nil {
+		return fmt.Errorf("shutting down storage: %w", err)
+	}
+
+	err = clients.storage.Start(context.WithoutCancel(ctx))
+	if err != nil {
+		return fmt.Errorf("starting storage: %w", err)
+	}
+
+	clients.storage.UpdateDHCP(ctx)
+
+	return nil
+}
@@ -0,0 +1,191 @@
+package home
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/client"
+	"github.com/AdguardTeam/AdGuardHome/internal/configmigrate"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestClientsBackup returns a new clients backup signed with key.
+func newTestClientsBackup(t *testing.T, key []byte) (b *clientsBackup) {
+	t.Helper()
+
+	b = &clientsBackup{
+		Clients:       json.RawMessage(`[{"name":"client","ids":["192.0.2.1"]}]`),
+		Version:       clientsBackupVersion,
+		SchemaVersion: configmigrate.LastSchemaVersion,
+	}
+
+	err := b.sign(key)
+	require.NoError(t, err)
+
+	return b
+}
+
+func TestClientsBackup_verify(t *testing.T) {
+	key := newClientsBackupKey("$2y$10$hash")
+
+	indented := &bytes.Buffer{}
+	err := json.Indent(indented, newTestClientsBackup(t, key).Clients, "", "  ")
+	require.NoError(t, err)
+
+	testCases := []struct {
+		modify     func(b *clientsBackup)
+		name       string
+		key        []byte
+		wantErrMsg string
+	}{{
+		modify:     func(_ *clientsBackup) {},
+		name:       "valid",
+		key:        key,
+		wantErrMsg: "",
+	}, {
+		modify: func(b *clientsBackup) {
+			b.Clients = indented.Bytes()
+		},
+		name:       "reformatted",
+		key:        key,
+		wantErrMsg: "",
+	}, {
+		modify:     func(_ *clientsBackup) {},
+		name:       "other_password",
+		key:        newClientsBackupKey("$2y$10$other"),
+		wantErrMsg: "signature mismatch",
+	}, {
+		modify: func(b *clientsBackup) {
+			b.Clients = json.RawMessage(`[{"name":"client","ids":["192.0.2.2"]}]`)
+		},
+		name:       "tampered_clients",
+		key:        key,
+		wantErrMsg: "signature mismatch",
+	}, {
+		modify: func(b *clientsBackup) {
+			b.SchemaVersion--
+		},
+		name:       "tampered_schema_version",
+		key:        key,
+		wantErrMsg: "signature mismatch",
+	}, {
+		modify: func(b *clientsBackup) {
+			b.Signature = "not hex"
+		},
+		name:       "bad_signature",
+		key:        key,
+		wantErrMsg: "decoding signature: encoding/hex: invalid byte: U+006E 'n'",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b := newTestClientsBackup(t, key)
+			tc.modify(b)
+
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, b.verify(tc.key))
+		})
+	}
+}
+
+func TestClientsBackup_validateVersions(t *testing.T) {
+	testCases := []struct {
+		name          string
+		wantErrMsg    string
+		version       uint
+		schemaVersion uint
+	}{{
+		name:          "current",
+		wantErrMsg:    "",
+		version:       clientsBackupVersion,
+		schemaVersion: configmigrate.LastSchemaVersion,
+	}, {
+		name:          "min",
+		wantErrMsg:    "",
+		version:       clientsBackupVersion,
+		schemaVersion: minClientsBackupSchemaVersion,
+	}, {
+		name:          "bad_version",
+		wantErrMsg:    "unsupported backup version 2",
+		version:       clientsBackupVersion + 1,
+		schemaVersion: configmigrate.LastSchemaVersion,
+	}, {
+		name:          "too_old",
+		wantErrMsg:    "schema version 21 is too old, minimum is 22",
+		version:       clientsBackupVersion,
+		schemaVersion: minClientsBackupSchemaVersion - 1,
+	}, {
+		name: "too_new",
+		wantErrMsg: fmt.Sprintf(
+			"schema version %d is too new, maximum is %d",
+			configmigrate.LastSchemaVersion+1,
+			configmigrate.LastSchemaVersion,
+		),
+		version:       clientsBackupVersion,
+		schemaVersion: configmigrate.LastSchemaVersion + 1,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b := &clientsBackup{
+				Version:       tc.version,
+				SchemaVersion: tc.schemaVersion,
+			}
+
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, b.validateVersions())
+		})
+	}
+}
+
+func TestClientsContainer_restore(t *testing.T) {
+	clients := newClientsContainer(t)
+	ctx := testutil.ContextWithTimeout(t, testTimeout)
+
+	err := clients.storage.Add(ctx, &client.Persistent{
+		Name: "old",
+		UID:  client.MustNewUID(),
+		ClientIDs: []string{
+			"old",
+		},
+	})
+	require.NoError(t, err)
+
+	data, err := json.Marshal(clients.forConfig())
+	require.NoError(t, err)
+
+	var objs []*clientObject
+	err = json.Unmarshal(data, &objs)
+	require.NoError(t, err)
+	require.Len(t, objs, 1)
+
+	objs[0].Name = "new"
+
+	err = clients.restore(ctx, objs)
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, func() (err error) {
+		return clients.storage.Shutdown(testutil.ContextWithTimeout(t, testTimeout))
+	})
+
+	_, ok := clients.storage.FindByName("old")
+	assert.False(t, ok)
+
+	c, ok := clients.storage.FindByName("new")
+	require.True(t, ok)
+
+	assert.Equal(t, objs[0].UID, c.UID)
+	assert.Equal(t, []string{"old"}, c.ClientIDs)
+
+	t.Run("invalid", func(t *testing.T) {
+		err = clients.restore(ctx, []*clientObject{{
+			Name: "invalid",
+			IDs:  []string{"!!!"},
+		}})
+		require.Error(t, err)
+
+		_, ok = clients.storage.FindByName("new")
+		assert.True(t, ok)
+	})
+}
@@ -0,0 +1,306 @@
+package home
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/miekg/dns"
+)
+
+// blockPageConfig is the block with the configuration of the block page HTTP
+// server.  The server is intended to be used with the custom_ip blocking mode,
+// when the blocked hosts are resolved into the address of AdGuard Home.
+type blockPageConfig struct {
+	// Address is the address to serve the block page on.  Its port must not
+	// be used by the web interface.
+	Address netip.AddrPort `yaml:"address"`
+
+	// Template is the path to the html/template file of the page.  If it's
+	// empty, the built-in page is used.  See [blockPageData] for the data
+	// passed to the template.
+	Template string `yaml:"template"`
+
+	// Enabled defines if the block page server is enabled.
+	Enabled bool `yaml:"enabled"`
+}
+
+// blockPageData is the data passed to the template of the block page.
+type blockPageData struct {
+	// Host is the requested host.
+	Host string
+
+	// Reason is the reason of the filtering decision for Host.
+	Reason string
+
+	// ServiceName is the name of the blocked service, which blocks Host, if
+	// any.
+	ServiceName string
+
+	// Rules are the texts of the rules matched by Host.
+	Rules []string
+
+	// Blocked is true if Host is blocked.
+	Blocked bool
+}
+
+// defaultBlockPageTemplate is the built-in template of the block page.
+const defaultBlockPageTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{ if .Blocked }}Blocked{{ else }}Not blocked{{ end }}: {{ .Host }}</title>
+</head>
+<body>
+{{ if .Blocked -}}
+<h1>Access to {{ .Host }} is blocked</h1>
+<p>The website has been blocked by AdGuard Home.</p>
+<p>Reason: {{ .Reason }}</p>
+{{ with .ServiceName }}<p>Blocked service: {{ . }}</p>
+{{ end -}}
+{{ if .Rules }}<p>Matched rules:</p>
+<ul>
+{{ range .Rules }}<li><code>{{ . }}</code></li>
+{{ end -}}
+</ul>
+{{ end -}}
+{{ else -}}
+<h1>{{ .Host }} is not blocked</h1>
+<p>AdGuard Home doesn't block this website now.  Try reloading the page.</p>
+{{ end -}}
+</body>
+</html>
+`
+
+// blockPageReadTimeout is the timeout for reading the requests to the block
+// page server.
+const blockPageReadTimeout = 10 * time.Second
+
+// blockChecker returns the result of the filtering of host for the client
+// with clientIP.  clientIP may be invalid, if the address of the client is
+// unknown.
+type blockChecker func(host string, clientIP netip.Addr) (res *filtering.Result, err error)
+
+// newFilteringBlockChecker returns a blockChecker, which checks the hosts using
+// d with the current protection status and the settings of the client, like
+// the DNS server does.  applySetts applies the settings of the client to setts,
+// it must not be nil.
+func newFilteringBlockChecker(
+	d *filtering.DNSFilter,
+	applySetts func(clientIP netip.Addr, clientID string, setts *filtering.Settings),
+) (check blockChecker) {
+	return func(host string, clientIP netip.Addr) (res *filtering.Result, err error) {
+		setts := d.Settings()
+		setts.ProtectionEnabled, _ = d.ProtectionStatus()
+
+		applySetts(clientIP, "", setts)
+
+		r, err := d.CheckHost(host, dns.TypeA, setts)
+		if err != nil {
+			// Don't wrap the error since it's informative enough as is.
+			return nil, err
+		}
+
+		return &r, nil
+	}
+}
+
+// blockPageServer is the HTTP server, which serves the page explaining why the
+// requested host is blocked.
+type blockPageServer struct {
+	logger *slog.Logger
+	check  blockChecker
+	tmpl   *template.Template
+	srv    *http.Server
+
+	// listener is the listener of srv.  It's nil until the server is started.
+	listener net.Listener
+
+	// addr is the address to listen on.
+	addr netip.AddrPort
+}
+
+// newBlockPageServer returns a new properly initialized *blockPageServer.  conf
+// and check must not be nil.
+func newBlockPageServer(
+	logger *slog.Logger,
+	conf *blockPageConfig,
+	check blockChecker,
+) (s *blockPageServer, err error) {
+	text := defaultBlockPageTemplate
+	if conf.Template != "" {
+		var data []byte
+		// #nosec G304 -- Trust the path explicitly given by the user.
+		data, err = os.ReadFile(conf.Template)
+		if err != nil {
+			return nil, fmt.Errorf("reading template: %w", err)
+		}
+
+		text = string(data)
+	}
+
+	tmpl, err := template.New("block_page").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parsing template: %w", err)
+	}
+
+	s = &blockPageServer{
+		logger: logger,
+		check:  check,
+		tmpl:   tmpl,
+		addr:   conf.Address,
+	}
+
+	s.srv = &http.Server{
+		Handler:           s,
+		ReadTimeout:       blockPageReadTimeout,
+		ReadHeaderTimeout: blockPageReadTimeout,
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelDebug),
+	}
+
+	return s, nil
+}
+
+// type check
+var _ http.Handler = (*blockPageServer)(nil)
+
+// ServeHTTP implements the [http.Handler] interface for *blockPageServer.
+func (s *blockPageServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host, err := netutil.SplitHost(r.Host)
+	if err == nil {
+		err = netutil.ValidateHostname(host)
+	}
+
+	if err != nil {
+		http.Error(w, "bad host", http.StatusBadRequest)
+
+		return
+	}
+
+	var clientIP netip.Addr
+	addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		s.logger.DebugContext(r.Context(), "parsing remote addr", slogutil.KeyError, err)
+	} else {
+		clientIP = addrPort.Addr().Unmap()
+	}
+
+	res, err := s.check(host, clientIP)
+	if err != nil {
+		s.logger.DebugContext(r.Context(), "checking host", "host", host, slogutil.KeyError, err)
+		http.Error(w, "checking host", http.StatusInternalServerError)
+
+		return
+	}
+
+	data := &blockPageData{
+		Host:        host,
+		Reason:      res.Reason.String(),
+		ServiceName: res.ServiceName,
+		Blocked:     res.IsFiltered,
+	}
+
+	for _, rule := range res.Rules {
+		data.Rules = append(data.Rules, rule.Text)
+	}
+
+	buf := &bytes.Buffer{}
+	err = s.tmpl.Execute(buf, data)
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "executing template", slogutil.KeyError, err)
+		http.Error(w, "executing template", http.StatusInternalServerError)
+
+		return
+	}
+
+	code := http.StatusOK
+	if data.Blocked {
+		code = http.StatusForbidden
+	}
+
+	w.Header().Set(httphdr.ContentType, "text/html; charset=utf-8")
+	w.Header().Set(httphdr.CacheControl, "no-store")
+	w.WriteHeader(code)
+
+	_, err = w.Write(buf.Bytes())
+	if err != nil {
+		s.logger.DebugContext(r.Context(), "writing response", slogutil.KeyError, err)
+	}
+}
+
+// start starts listening on the configured address and serving the requests
+// in a separate goroutine.
+func (s *blockPageServer) start(ctx context.Context) (err error) {
+	s.listener, err = net.Listen("tcp", s.addr.String())
+	if err != nil {
+		return fmt.Errorf("listening: %w", err)
+	}
+
+	s.logger.InfoContext(ctx, "listening", "addr", s.listener.Addr())
+
+	go func() {
+		defer slogutil.RecoverAndLog(ctx, s.logger)
+
+		serveErr := s.srv.Serve(s.listener)
+		if !errors.Is(serveErr, http.ErrServerClosed) {
+			s.logger.ErrorContext(ctx, "serving", slogutil.KeyError, serveErr)
+		}
+	}()
+
+	return nil
+}
+
+// shutdown gracefully stops the server.
+func (s *blockPageServer) shutdown(ctx context.Context) (err error) {
+	err = s.srv.Shutdown(ctx)
+	if err != nil {
+		return fmt.Errorf("shutting down: %w", err)
+	}
+
+	return nil
+}
+
+// startBlockPage starts the block page server, if it's enabled.  The errors
+// are only logged, since the block page isn't crucial.  baseLogger must not be
+// nil, Context.filters must be initialized.
+func startBlockPage(ctx context.Context, baseLogger *slog.Logger) {
+	conf := config.HTTPConfig.BlockPage
+	if conf == nil || !conf.Enabled {
+		return
+	}
+
+	logger := baseLogger.With(slogutil.KeyPrefix, "blockpage")
+
+	s, err := newBlockPageServer(
+		logger,
+		conf,
+		newFilteringBlockChecker(Context.filters, applyAdditionalFiltering),
+	)
+	if err != nil {
+		logger.ErrorContext(ctx, "initializing", slogutil.KeyError, err)
+
+		return
+	}
+
+	err = s.start(ctx)
+	if err != nil {
+		logger.ErrorContext(ctx, "starting", slogutil.KeyError, err)
+
+		return
+	}
+
+	Context.blockPage = s
+}
@@ -0,0 +1,240 @@
+package home
+
+import (
+	"io"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/client"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/AdGuardHome/internal/schedule"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testBlockedHost is the host blocked by the test block checker.
+const testBlockedHost = "blocked.example"
+
+// testBlockRule is the text of the rule blocking [testBlockedHost].
+const testBlockRule = "||blocked.example^"
+
+// testBlockChecker is a blockChecker, which only blocks [testBlockedHost].
+func testBlockChecker(host string, _ netip.Addr) (res *filtering.Result, err error) {
+	if host != testBlockedHost {
+		return &filtering.Result{
+			Reason: filtering.NotFilteredNotFound,
+		}, nil
+	}
+
+	return &filtering.Result{
+		Rules: []*filtering.ResultRule{{
+			Text: testBlockRule,
+		}},
+		Reason:     filtering.FilteredBlockList,
+		IsFiltered: true,
+	}, nil
+}
+
+// startTestBlockPage starts a block page server with conf and check on a random
+// port and returns its URL.
+func startTestBlockPage(t *testing.T, conf *blockPageConfig, check blockChecker) (u string) {
+	t.Helper()
+
+	conf.Address = netip.AddrPortFrom(netip.AddrFrom4([4]byte{127, 0, 0, 1}), 0)
+
+	s, err := newBlockPageServer(slogutil.NewDiscardLogger(), conf, check)
+	require.NoError(t, err)
+
+	require.NoError(t, s.start(testutil.ContextWithTimeout(t, testTimeout)))
+	testutil.CleanupAndRequireSuccess(t, func() (err error) {
+		return s.shutdown(testutil.ContextWithTimeout(t, testTimeout))
+	})
+
+	return "http://" + s.listener.Addr().String() + "/"
+}
+
+// getBlockPage requests the page at u with the Host header set to host and
+// returns the status code and the body of the response.
+func getBlockPage(t *testing.T, u, host string) (code int, body string) {
+	t.Helper()
+
+	ctx := testutil.ContextWithTimeout(t, testTimeout)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	require.NoError(t, err)
+
+	req.Host = host
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, resp.Body.Close)
+
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	if resp.StatusCode != http.StatusBadRequest {
+		assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get(httphdr.ContentType))
+	}
+
+	return resp.StatusCode, string(data)
+}
+
+func TestBlockPageServer(t *testing.T) {
+	u := startTestBlockPage(t, &blockPageConfig{}, testBlockChecker)
+
+	testCases := []struct {
+		name         string
+		host         string
+		wantContains string
+		wantCode     int
+	}{{
+		name:         "blocked",
+		host:         testBlockedHost,
+		wantContains: testBlockRule,
+		wantCode:     http.StatusForbidden,
+	}, {
+		name:         "blocked_with_port",
+		host:         testBlockedHost + ":80",
+		wantContains: testBlockRule,
+		wantCode:     http.StatusForbidden,
+	}, {
+		name:         "not_blocked",
+		host:         "allowed.example",
+		wantContains: "allowed.example is not blocked",
+		wantCode:     http.StatusOK,
+	}, {
+		name:         "bad_host",
+		host:         "bad_host!",
+		wantContains: "bad host",
+		wantCode:     http.StatusBadRequest,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			code, body := getBlockPage(t, u, tc.host)
+			assert.Equal(t, tc.wantCode, code)
+			assert.Contains(t, body, tc.wantContains)
+		})
+	}
+}
+
+func TestBlockPageServer_template(t *testing.T) {
+	tmplPath := filepath.Join(t.TempDir(), "block_page.html")
+	err := os.WriteFile(
+		tmplPath,
+		[]byte(`{{ .Host }}|{{ .Reason }}|{{ range .Rules }}{{ . }}{{ end }}`),
+		0o644,
+	)
+	require.NoError(t, err)
+
+	u := startTestBlockPage(t, &blockPageConfig{
+		Template: tmplPath,
+	}, testBlockChecker)
+
+	code, body := getBlockPage(t, u, testBlockedHost)
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, testBlockedHost+"|FilteredBlackList|"+testBlockRule, body)
+}
+
+func TestNewBlockPageServer_badTemplate(t *testing.T) {
+	tmplPath := filepath.Join(t.TempDir(), "block_page.html")
+	err := os.WriteFile(tmplPath, []byte(`{{ .Host `), 0o644)
+	require.NoError(t, err)
+
+	_, err = newBlockPageServer(slogutil.NewDiscardLogger(), &blockPageConfig{
+		Template: tmplPath,
+	}, testBlockChecker)
+	assert.ErrorContains(t, err, "parsing template")
+}
+
+func TestNewFilteringBlockChecker(t *testing.T) {
+	const (
+		clientHost = "client.example"
+		otherHost  = "other.example"
+	)
+
+	var err error
+	Context.filters, err = filtering.New(&filtering.Config{
+		ProtectionEnabled: true,
+		FilteringEnabled:  true,
+		BlockedServices: &filtering.BlockedServices{
+			Schedule: schedule.EmptyWeekly(),
+		},
+	}, nil)
+	require.NoError(t, err)
+
+	Context.filters.SetEnabled(true)
+
+	clientRules, err := filtering.NewClientRules([]string{"||" + clientHost + "^"})
+	require.NoError(t, err)
+
+	localIP := netip.AddrFrom4([4]byte{127, 0, 0, 1})
+	otherIP := netip.AddrFrom4([4]byte{192, 0, 2, 1})
+
+	Context.clients.storage = newStorage(t, []*client.Persistent{{
+		Name:        "client",
+		IPs:         []netip.Addr{localIP},
+		ClientRules: clientRules,
+	}})
+
+	check := newFilteringBlockChecker(Context.filters, applyAdditionalFiltering)
+
+	testCases := []struct {
+		clientIP    netip.Addr
+		name        string
+		host        string
+		wantBlocked bool
+	}{{
+		clientIP:    localIP,
+		name:        "client_rule",
+		host:        clientHost,
+		wantBlocked: true,
+	}, {
+		clientIP:    localIP,
+		name:        "client_other_host",
+		host:        otherHost,
+		wantBlocked: false,
+	}, {
+		clientIP:    otherIP,
+		name:        "other_client",
+		host:        clientHost,
+		wantBlocked: false,
+	}, {
+		clientIP:    netip.Addr{},
+		name:        "unknown_client",
+		host:        clientHost,
+		wantBlocked: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, checkErr := check(tc.host, tc.clientIP)
+			require.NoError(t, checkErr)
+
+			assert.Equal(t, tc.wantBlocked, res.IsFiltered)
+		})
+	}
+
+	t.Run("remote_addr", func(t *testing.T) {
+		u := startTestBlockPage(t, &blockPageConfig{}, check)
+
+		code, body := getBlockPage(t, u, clientHost)
+		assert.Equal(t, http.StatusForbidden, code)
+		assert.Contains(t, body, "||"+clientHost+"^")
+	})
+
+	t.Run("protection_disabled", func(t *testing.T) {
+		Context.filters.SetProtectionEnabled(false)
+		t.Cleanup(func() { Context.filters.SetProtectionEnabled(true) })
+
+		res, checkErr := check(clientHost, localIP)
+		require.NoError(t, checkErr)
+
+		assert.False(t, res.IsFiltered)
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log/slog"
 	"net/netip"
+	"path/filepath"
 	"slices"
 	"sync"
 	"time"
@@ -23,6 +24,7 @@ import (
 	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/logutil/slogutil"
 	"github.com/AdguardTeam/golibs/stringutil"
+	"github.com/AdguardTeam/golibs/timeutil"
 )
 
 // clientsContainer is the storage of all runtime and persistent clients.
@@ -34,6 +36,10 @@ type clientsContainer struct {
 	// storage stores information about persistent clients.
 	storage *client.Storage
 
+	// geoIP is used to tag runtime clients with their countries.  It's nil if
+	// the GeoIP database isn't configured.
+	geoIP *aghnet.GeoIPTagger
+
 	// clientChecker checks if a client is blocked by the current access
 	// settings.
 	clientChecker BlockedClientChecker
@@ -52,6 +58,10 @@ type clientsContainer struct {
 	// persistent clients.
 	safeSearchCacheTTL time.Duration
 
+	// safeSearchHealth is the health of the hosts enforced by safe search to
+	// use for persistent clients.  It may be nil.
+	safeSearchHealth filtering.SafeSearchHealth
+
 	// testing is a flag that disables some features for internal tests.
 	//
 	// TODO(a.garipov): Awful.  Remove.
@@ -84,16 +94,11 @@ func (clients *clientsContainer) Init(
 	clients.baseLogger = baseLogger
 	clients.safeSearchCacheSize = filteringConf.SafeSearchCacheSize
 	clients.safeSearchCacheTTL = time.Minute * time.Duration(filteringConf.CacheTime)
+	clients.safeSearchHealth = filteringConf.SafeSearchHealth
 
-	confClients := make([]*client.Persistent, 0, len(objects))
-	for i, o := range objects {
-		var p *client.Persistent
-		p, err = o.toPersistent(ctx, baseLogger, clients.safeSearchCacheSize, clients.safeSearchCacheTTL)
-		if err != nil {
-			return fmt.Errorf("init persistent client at index %d: %w", i, err)
-		}
-
-		confClients = append(confClients, p)
+	confClients, err := clients.toPersistent(ctx, objects)
+	if err != nil {
+		return fmt.Errorf("init %w", err)
 	}
 
 	// The clients.etcHosts may be nil even if config.Clients.Sources.HostsFile
@@ -107,13 +112,25 @@ func (clients *clientsContainer) Init(
 		hosts = etcHosts
 	}
 
+	var geoIP client.GeoIP
+	if dbPath := config.GeoIPDatabase; dbPath != "" {
+		if !filepath.IsAbs(dbPath) {
+			dbPath = filepath.Join(Context.workDir, dbPath)
+		}
+
+		clients.geoIP = aghnet.NewGeoIPTagger(dbPath)
+		geoIP = clients.geoIP
+	}
+
 	clients.storage, err = client.NewStorage(ctx, &client.StorageConfig{
 		Logger:                 baseLogger.With(slogutil.KeyPrefix, "client_storage"),
 		InitialClients:         confClients,
 		DHCP:                   dhcpServer,
 		EtcHosts:               hosts,
 		ARPDB:                  arpDB,
+		GeoIP:                  geoIP,
 		ARPClientsUpdatePeriod: arpClientsUpdatePeriod,
+		OnUpstreamsClosed:      forgetUpstreamConfig,
 		RuntimeSourceDHCP:      config.Clients.Sources.DHCP,
 	})
 	if err != nil {
@@ -123,6 +140,73 @@ func (clients *clientsContainer) Init(
 	return nil
 }
 
+// forgetUpstreamConfig drops the data the DNS server associates with the closed
+// custom upstream configuration of a persistent client.
+func forgetUpstreamConfig(conf *proxy.CustomUpstreamConfig) {
+	if Context.dnsServer != nil {
+		Context.dnsServer.ForgetUpstreamConfig(conf)
+	}
+}
+
+// addUncachedUpstreamConfig makes the DNS server use the upstreams of uc
+// without the cache for the requests using conf, the custom upstream
+// configuration of a persistent client, and bypassing the caches.
+func addUncachedUpstreamConfig(conf *proxy.CustomUpstreamConfig, uc *proxy.UpstreamConfig) {
+	if Context.dnsServer != nil {
+		Context.dnsServer.AddUncachedUpstreamConfig(conf, uc)
+	}
+}
+
+// reloadGeoIP makes the GeoIP database be loaded again on the next lookup, if
+// it's configured.
+func (clients *clientsContainer) reloadGeoIP() {
+	if clients.geoIP != nil {
+		clients.geoIP.Reload()
+	}
+}
+
+// toPersistent converts the YAML representations of persistent clients into
+// the initialized persistent clients.
+func (clients *clientsContainer) toPersistent(
+	ctx context.Context,
+	objects []*clientObject,
+) (persistent []*client.Persistent, err error) {
+	persistent = make([]*client.Persistent, 0, len(objects))
+	for i, o := range objects {
+		var p *client.Persistent
+		p, err = o.toPersistent(
+			ctx,
+			clients.baseLogger,
+			clients.safeSearchCacheSize,
+			clients.safeSearchCacheTTL,
+			clients.safeSearchHealth,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("persistent client at index %d: %w", i, err)
+		}
+
+		persistent = append(persistent, p)
+	}
+
+	return persistent, nil
+}
+
+// reload replaces the persistent clients with the ones from objects, keeping
+// the runtime clients.  The stored persistent clients aren't changed if any of
+// objects is invalid.
+func (clients *clientsContainer) reload(ctx context.Context, objects []*clientObject) (err error) {
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	persistent, err := clients.toPersistent(ctx, objects)
+	if err != nil {
+		return fmt.Errorf("reloading %w", err)
+	}
+
+	// Don't wrap the error since it's informative enough as is.
+	return clients.storage.Reload(ctx, persistent)
+}
+
 // webHandlersRegistered prevents a [clientsContainer] from registering its web
 // handlers more than once.
 //
@@ -145,36 +229,55 @@ func (clients *clientsContainer) Start(ctx context.Context) (err error) {
 
 // clientObject is the YAML representation of a persistent client.
 type clientObject struct {
-	SafeSearchConf filtering.SafeSearchConfig `yaml:"safe_search"`
+	SafeSearchConf filtering.SafeSearchConfig `yaml:"safe_search" json:"safe_search"`
 
 	// BlockedServices is the configuration of blocked services of a client.
-	BlockedServices *filtering.BlockedServices `yaml:"blocked_services"`
+	BlockedServices *filtering.BlockedServices `yaml:"blocked_services" json:"blocked_services"`
+
+	Name string `yaml:"name" json:"name"`
 
-	Name string `yaml:"name"`
+	IDs       []string `yaml:"ids" json:"ids"`
+	Tags      []string `yaml:"tags" json:"tags"`
+	Upstreams []string `yaml:"upstreams" json:"upstreams"`
 
-	IDs       []string `yaml:"ids"`
-	Tags      []string `yaml:"tags"`
-	Upstreams []string `yaml:"upstreams"`
+	// Rules are the custom filtering rules of the client.
+	Rules []string `yaml:"rules" json:"rules"`
+
+	// BlockedSuffixes are the blocked domain name suffixes of the client.
+	// They're only used if UseOwnBlockedSuffixes is true.
+	BlockedSuffixes []string `yaml:"blocked_suffixes" json:"blocked_suffixes"`
 
 	// UID is the unique identifier of the persistent client.
-	UID client.UID `yaml:"uid"`
+	UID client.UID `yaml:"uid" json:"uid"`
+
+	// QueryLogRetention is the retention period of the query log entries of
+	// the client.  If zero, the global query log interval is used.
+	QueryLogRetention timeutil.Duration `yaml:"querylog_retention,omitempty" json:"querylog_retention,omitempty"`
+
+	// StatsRetention is the retention period of the statistics of the client.
+	// If zero, the global statistics interval is used.
+	StatsRetention timeutil.Duration `yaml:"stats_retention,omitempty" json:"stats_retention,omitempty"`
 
 	// UpstreamsCacheSize is the DNS cache size (in bytes).
 	//
 	// TODO(d.kolyshev): Use [datasize.Bytesize].
-	UpstreamsCacheSize uint32 `yaml:"upstreams_cache_size"`
+	UpstreamsCacheSize uint32 `yaml:"upstreams_cache_size" json:"upstreams_cache_size"`
 
 	// UpstreamsCacheEnabled indicates if the DNS cache is enabled.
-	UpstreamsCacheEnabled bool `yaml:"upstreams_cache_enabled"`
+	UpstreamsCacheEnabled bool `yaml:"upstreams_cache_enabled" json:"upstreams_cache_enabled"`
+
+	UseGlobalSettings        bool `yaml:"use_global_settings" json:"use_global_settings"`
+	FilteringEnabled         bool `yaml:"filtering_enabled" json:"filtering_enabled"`
+	ParentalEnabled          bool `yaml:"parental_enabled" json:"parental_enabled"`
+	SafeBrowsingEnabled      bool `yaml:"safebrowsing_enabled" json:"safebrowsing_enabled"`
+	UseGlobalBlockedServices bool `yaml:"use_global_blocked_services" json:"use_global_blocked_services"`
 
-	UseGlobalSettings        bool `yaml:"use_global_settings"`
-	FilteringEnabled         bool `yaml:"filtering_enabled"`
-	ParentalEnabled          bool `yaml:"parental_enabled"`
-	SafeBrowsingEnabled      bool `yaml:"safebrowsing_enabled"`
-	UseGlobalBlockedServices bool `yaml:"use_global_blocked_services"`
+	// UseOwnBlockedSuffixes, if true, makes the client use BlockedSuffixes
+	// instead of the global blocked suffixes.
+	UseOwnBlockedSuffixes bool `yaml:"use_own_blocked_suffixes" json:"use_own_blocked_suffixes"`
 
-	IgnoreQueryLog   bool `yaml:"ignore_querylog"`
-	IgnoreStatistics bool `yaml:"ignore_statistics"`
+	IgnoreQueryLog   bool `yaml:"ignore_querylog" json:"ignore_querylog"`
+	IgnoreStatistics bool `yaml:"ignore_statistics" json:"ignore_statistics"`
 }
 
 // toPersistent returns an initialized persistent client if there are no errors.
@@ -183,6 +286,7 @@ func (o *clientObject) toPersistent(
 	baseLogger *slog.Logger,
 	safeSearchCacheSize uint,
 	safeSearchCacheTTL time.Duration,
+	safeSearchHealth filtering.SafeSearchHealth,
 ) (cli *client.Persistent, err error) {
 	cli = &client.Persistent{
 		Name: o.Name,
@@ -191,12 +295,16 @@ func (o *clientObject) toPersistent(
 
 		UID: o.UID,
 
+		QueryLogRetention: time.Duration(o.QueryLogRetention),
+		StatsRetention:    time.Duration(o.StatsRetention),
+
 		UseOwnSettings:        !o.UseGlobalSettings,
 		FilteringEnabled:      o.FilteringEnabled,
 		ParentalEnabled:       o.ParentalEnabled,
 		SafeSearchConf:        o.SafeSearchConf,
 		SafeBrowsingEnabled:   o.SafeBrowsingEnabled,
 		UseOwnBlockedServices: !o.UseGlobalBlockedServices,
+		UseOwnBlockedSuffixes: o.UseOwnBlockedSuffixes,
 		IgnoreQueryLog:        o.IgnoreQueryLog,
 		IgnoreStatistics:      o.IgnoreStatistics,
 		UpstreamsCacheEnabled: o.UpstreamsCacheEnabled,
@@ -208,6 +316,16 @@ func (o *clientObject) toPersistent(
 		return nil, fmt.Errorf("parsing ids: %w", err)
 	}
 
+	err = cli.SetRules(slices.Clone(o.Rules))
+	if err != nil {
+		return nil, fmt.Errorf("init rules %q: %w", cli.Name, err)
+	}
+
+	err = cli.SetBlockedSuffixes(slices.Clone(o.BlockedSuffixes))
+	if err != nil {
+		return nil, fmt.Errorf("init blocked suffixes %q: %w", cli.Name, err)
+	}
+
 	if (cli.UID == client.UID{}) {
 		cli.UID, err = client.NewUID()
 		if err != nil {
@@ -227,6 +345,7 @@ func (o *clientObject) toPersistent(
 			ClientName:     cli.Name,
 			CacheSize:      safeSearchCacheSize,
 			CacheTTL:       safeSearchCacheTTL,
+			Health:         safeSearchHealth,
 		})
 		if err != nil {
 			return nil, fmt.Errorf("init safesearch %q: %w", cli.Name, err)
@@ -269,15 +388,22 @@ func (clients *clientsContainer) forConfig() (objs []*clientObject) {
 			IDs:       cli.IDs(),
 			Tags:      slices.Clone(cli.Tags),
 			Upstreams: slices.Clone(cli.Upstreams),
+			Rules:     slices.Clone(cli.Rules),
+
+			BlockedSuffixes: slices.Clone(cli.BlockedSuffixes),
 
 			UID: cli.UID,
 
+			QueryLogRetention: timeutil.Duration(cli.QueryLogRetention),
+			StatsRetention:    timeutil.Duration(cli.StatsRetention),
+
 			UseGlobalSettings:        !cli.UseOwnSettings,
 			FilteringEnabled:         cli.FilteringEnabled,
 			ParentalEnabled:          cli.ParentalEnabled,
 			SafeSearchConf:           cli.SafeSearchConf,
 			SafeBrowsingEnabled:      cli.SafeBrowsingEnabled,
 			UseGlobalBlockedServices: !cli.UseOwnBlockedServices,
+			UseOwnBlockedSuffixes:    cli.UseOwnBlockedSuffixes,
 			IgnoreQueryLog:           cli.IgnoreQueryLog,
 			IgnoreStatistics:         cli.IgnoreStatistics,
 			UpstreamsCacheEnabled:    cli.UpstreamsCacheEnabled,
@@ -370,6 +496,43 @@ func (clients *clientsContainer) shouldCountClient(ids []string) (y bool) {
 	return true
 }
 
+// queryLogRetention is a wrapper around [clientsContainer.find] to make it
+// a valid client retention finder for the query log.  ivl is zero if the client
+// isn't found or has no own retention period.
+func (clients *clientsContainer) queryLogRetention(ids []string) (ivl time.Duration) {
+	return clients.retention(ids, func(c *client.Persistent) (ivl time.Duration) {
+		return c.QueryLogRetention
+	})
+}
+
+// statsRetention is a wrapper around [clientsContainer.find] to make it a valid
+// client retention finder for the statistics.  ivl is zero if the client isn't
+// found or has no own retention period.
+func (clients *clientsContainer) statsRetention(ids []string) (ivl time.Duration) {
+	return clients.retention(ids, func(c *client.Persistent) (ivl time.Duration) {
+		return c.StatsRetention
+	})
+}
+
+// retention returns the retention period of the persistent client with one of
+// ids as returned by get.  ivl is zero if the client isn't found.
+func (clients *clientsContainer) retention(
+	ids []string,
+	get func(c *client.Persistent) (ivl time.Duration),
+) (ivl time.Duration) {
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	for _, id := range ids {
+		c, ok := clients.storage.Find(id)
+		if ok {
+			return get(c)
+		}
+	}
+
+	return 0
+}
+
 // type check
 var _ dnsforward.ClientsContainer = (*clientsContainer)(nil)
 
@@ -380,51 +543,40 @@ func (clients *clientsContainer) UpstreamConfigByID(
 	id string,
 	bootstrap upstream.Resolver,
 ) (conf *proxy.CustomUpstreamConfig, err error) {
-	clients.lock.Lock()
-	defer clients.lock.Unlock()
-
-	c, ok := clients.storage.Find(id)
-	if !ok {
-		return nil, nil
-	} else if c.UpstreamConfig != nil {
-		return c.UpstreamConfig, nil
-	}
-
-	upstreams := stringutil.FilterOut(c.Upstreams, dnsforward.IsCommentOrEmpty)
-	if len(upstreams) == 0 {
-		return nil, nil
-	}
-
-	var upsConf *proxy.UpstreamConfig
-	upsConf, err = proxy.ParseUpstreamsConfig(
-		upstreams,
-		&upstream.Options{
-			Bootstrap:    bootstrap,
-			Timeout:      time.Duration(config.DNS.UpstreamTimeout),
-			HTTPVersions: dnsforward.UpstreamHTTPVersions(config.DNS.UseHTTP3Upstreams),
-			PreferIPv6:   config.DNS.BootstrapPreferIPv6,
-		},
-	)
-	if err != nil {
-		// Don't wrap the error since it's informative enough as is.
-		return nil, err
-	}
+	return clients.storage.UpstreamConfig(id, func(c *client.Persistent) (
+		conf *proxy.CustomUpstreamConfig,
+		err error,
+	) {
+		upstreams := stringutil.FilterOut(c.Upstreams, dnsforward.IsCommentOrEmpty)
+		if len(upstreams) == 0 {
+			return nil, nil
+		}
 
-	conf = proxy.NewCustomUpstreamConfig(
-		upsConf,
-		c.UpstreamsCacheEnabled,
-		int(c.UpstreamsCacheSize),
-		config.DNS.EDNSClientSubnet.Enabled,
-	)
-	c.UpstreamConfig = conf
+		var upsConf *proxy.UpstreamConfig
+		upsConf, err = proxy.ParseUpstreamsConfig(
+			upstreams,
+			&upstream.Options{
+				Bootstrap:    bootstrap,
+				Timeout:      time.Duration(config.DNS.UpstreamTimeout),
+				HTTPVersions: dnsforward.UpstreamHTTPVersions(config.DNS.UseHTTP3Upstreams),
+				PreferIPv6:   config.DNS.BootstrapPreferIPv6,
+			},
+		)
+		if err != nil {
+			// Don't wrap the error since it's informative enough as is.
+			return nil, err
+		}
 
-	// TODO(s.chzhen):  Pass context.
-	err = clients.storage.Update(context.TODO(), c.Name, c)
-	if err != nil {
-		return nil, fmt.Errorf("setting upstream config: %w", err)
-	}
+		conf = proxy.NewCustomUpstreamConfig(
+			upsConf,
+			c.UpstreamsCacheEnabled,
+			int(c.UpstreamsCacheSize),
+			config.DNS.EDNSClientSubnet.Enabled,
+		)
+		addUncachedUpstreamConfig(conf, upsConf)
 
-	return conf, nil
+		return conf, nil
+	})
 }
 
 // type check
@@ -1,11 +1,15 @@
 package home
 
 import (
+	"fmt"
 	"net"
 	"net/netip"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/client"
+	"github.com/AdguardTeam/AdGuardHome/internal/configmigrate"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
 	"github.com/AdguardTeam/golibs/logutil/slogutil"
 	"github.com/AdguardTeam/golibs/testutil"
@@ -62,3 +66,62 @@ func TestClientsCustomUpstream(t *testing.T) {
 	require.NotNil(t, upsConf)
 	assert.NoError(t, err)
 }
+
+func TestReadPersistentClients(t *testing.T) {
+	prevWorkDir, prevConfPath := Context.workDir, Context.confFilePath
+	t.Cleanup(func() { Context.workDir, Context.confFilePath = prevWorkDir, prevConfPath })
+
+	dir := t.TempDir()
+	confPath := filepath.Join(dir, "AdGuardHome.yaml")
+	Context.workDir, Context.confFilePath = dir, confPath
+
+	const clientsConf = `
+clients:
+  persistent:
+    - name: client
+      ids:
+        - 1.1.1.1
+`
+
+	testCases := []struct {
+		name       string
+		version    uint
+		wantNames  []string
+		wantErrMsg string
+	}{{
+		name:       "current",
+		version:    configmigrate.LastSchemaVersion,
+		wantNames:  []string{"client"},
+		wantErrMsg: "",
+	}, {
+		name:       "old",
+		version:    configmigrate.LastSchemaVersion - 1,
+		wantNames:  []string{"client"},
+		wantErrMsg: "",
+	}, {
+		name:      "newer",
+		version:   configmigrate.LastSchemaVersion + 1,
+		wantNames: nil,
+		wantErrMsg: fmt.Sprintf(
+			"upgrading config: unknown current schema version %d",
+			configmigrate.LastSchemaVersion+1,
+		),
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			data := fmt.Sprintf("schema_version: %d\n%s", tc.version, clientsConf)
+			require.NoError(t, os.WriteFile(confPath, []byte(data), 0o600))
+
+			objs, err := readPersistentClients()
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+
+			var names []string
+			for _, o := range objs {
+				names = append(names, o.Name)
+			}
+
+			assert.Equal(t, tc.wantNames, names)
+		})
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"net/http"
 	"net/netip"
+	"slices"
+	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghalg"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
@@ -49,6 +51,10 @@ type clientJSON struct {
 	Tags            []string `json:"tags"`
 	Upstreams       []string `json:"upstreams"`
 
+	// Rules are the custom filtering rules of the client.  If nil, the
+	// previous value is kept.
+	Rules []string `json:"rules"`
+
 	FilteringEnabled    bool `json:"filtering_enabled"`
 	ParentalEnabled     bool `json:"parental_enabled"`
 	SafeBrowsingEnabled bool `json:"safebrowsing_enabled"`
@@ -60,6 +66,16 @@ type clientJSON struct {
 	IgnoreQueryLog   aghalg.NullBool `json:"ignore_querylog"`
 	IgnoreStatistics aghalg.NullBool `json:"ignore_statistics"`
 
+	// QueryLogRetention is the retention period of the query log entries of
+	// the client in milliseconds.  Zero means that the global interval is
+	// used.  If nil, the previous value is kept.
+	QueryLogRetention *float64 `json:"querylog_retention,omitempty"`
+
+	// StatsRetention is the retention period of the statistics of the client
+	// in milliseconds.  Zero means that the global interval is used.  If nil,
+	// the previous value is kept.
+	StatsRetention *float64 `json:"stats_retention,omitempty"`
+
 	UpstreamsCacheSize    uint32          `json:"upstreams_cache_size"`
 	UpstreamsCacheEnabled aghalg.NullBool `json:"upstreams_cache_enabled"`
 }
@@ -136,6 +152,13 @@ func initPrev(cj clientJSON, prev *client.Persistent) (c *client.Persistent, err
 		ignoreStatistics bool
 		upsCacheEnabled  bool
 		upsCacheSize     uint32
+		qlogRetention    time.Duration
+		statsRetention   time.Duration
+		ruleTexts        []string
+		clientRules      *filtering.ClientRules
+		suffixes         []string
+		suffixSet        *filtering.SuffixSet
+		useOwnSuffixes   bool
 	)
 
 	if prev != nil {
@@ -144,6 +167,30 @@ func initPrev(cj clientJSON, prev *client.Persistent) (c *client.Persistent, err
 		ignoreStatistics = prev.IgnoreStatistics
 		upsCacheEnabled = prev.UpstreamsCacheEnabled
 		upsCacheSize = prev.UpstreamsCacheSize
+		qlogRetention = prev.QueryLogRetention
+		statsRetention = prev.StatsRetention
+		ruleTexts = prev.Rules
+		clientRules = prev.ClientRules
+		suffixes = prev.BlockedSuffixes
+		suffixSet = prev.BlockedSuffixSet
+		useOwnSuffixes = prev.UseOwnBlockedSuffixes
+	}
+
+	// Only recompile the rules if they've changed.
+	if cj.Rules != nil && !slices.Equal(cj.Rules, ruleTexts) {
+		ruleTexts = slices.Clone(cj.Rules)
+		clientRules, err = filtering.NewClientRules(ruleTexts)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rules: %w", err)
+		}
+	}
+
+	if cj.QueryLogRetention != nil {
+		qlogRetention = time.Duration(*cj.QueryLogRetention) * time.Millisecond
+	}
+
+	if cj.StatsRetention != nil {
+		statsRetention = time.Duration(*cj.StatsRetention) * time.Millisecond
 	}
 
 	if cj.IgnoreQueryLog != aghalg.NBNull {
@@ -178,6 +225,13 @@ func initPrev(cj clientJSON, prev *client.Persistent) (c *client.Persistent, err
 		IgnoreStatistics:      ignoreStatistics,
 		UpstreamsCacheEnabled: upsCacheEnabled,
 		UpstreamsCacheSize:    upsCacheSize,
+		QueryLogRetention:     qlogRetention,
+		StatsRetention:        statsRetention,
+		Rules:                 ruleTexts,
+		ClientRules:           clientRules,
+		BlockedSuffixes:       suffixes,
+		BlockedSuffixSet:      suffixSet,
+		UseOwnBlockedSuffixes: useOwnSuffixes,
 	}, nil
 }
 
@@ -222,6 +276,7 @@ func (clients *clientsContainer) jsonToClient(
 			ClientName:     c.Name,
 			CacheSize:      clients.safeSearchCacheSize,
 			CacheTTL:       clients.safeSearchCacheTTL,
+			Health:         clients.safeSearchHealth,
 		})
 		if err != nil {
 			return nil, fmt.Errorf("creating safesearch for client %q: %w", c.Name, err)
@@ -251,6 +306,7 @@ func copySafeSearch(
 	// Set default service flags for enabled safesearch.
 	if conf.Enabled {
 		conf.Bing = true
+		conf.Brave = true
 		conf.DuckDuckGo = true
 		conf.Ecosia = true
 		conf.Google = true
@@ -315,15 +371,26 @@ func clientToJSON(c *client.Persistent) (cj *clientJSON) {
 		BlockedServices: c.BlockedServices.IDs,
 
 		Upstreams: c.Upstreams,
+		Rules:     c.Rules,
 
 		IgnoreQueryLog:   aghalg.BoolToNullBool(c.IgnoreQueryLog),
 		IgnoreStatistics: aghalg.BoolToNullBool(c.IgnoreStatistics),
 
+		QueryLogRetention: durationToMillis(c.QueryLogRetention),
+		StatsRetention:    durationToMillis(c.StatsRetention),
+
 		UpstreamsCacheSize:    c.UpstreamsCacheSize,
 		UpstreamsCacheEnabled: aghalg.BoolToNullBool(c.UpstreamsCacheEnabled),
 	}
 }
 
+// durationToMillis returns a pointer to the number of milliseconds in d.
+func durationToMillis(d time.Duration) (ms *float64) {
+	msVal := float64(d.Milliseconds())
+
+	return &msVal
+}
+
 // handleAddClient is the handler for POST /control/clients/add HTTP API.
 func (clients *clientsContainer) handleAddClient(w http.ResponseWriter, r *http.Request) {
 	cj := clientJSON{}
@@ -528,6 +595,134 @@ func (clients *clientsContainer) findRuntime(ip netip.Addr, idStr string) (cj *c
 	return cj
 }
 
+// Effective settings sources.
+const (
+	// settingsSourcePersistent means that the settings of a persistent client
+	// have been applied.
+	settingsSourcePersistent = "persistent"
+
+	// settingsSourceRuntime means that no persistent client has been found and
+	// the global settings have been applied.
+	settingsSourceRuntime = "runtime"
+)
+
+// effectiveSettingsJSON is the response for the GET
+// /control/clients/effective_settings HTTP API.
+type effectiveSettingsJSON struct {
+	// Source is either [settingsSourcePersistent] or [settingsSourceRuntime].
+	Source string `json:"source"`
+
+	// MatchedID is the identifier the persistent client has been found by.
+	// It's empty if Source is [settingsSourceRuntime].
+	MatchedID string `json:"matched_id,omitempty"`
+
+	// ClientName is the name of the persistent client.
+	ClientName string `json:"client_name,omitempty"`
+
+	// BlockedServices are the IDs of the blocked services.
+	BlockedServices []string `json:"blocked_services"`
+
+	// Tags are the tags of the client.
+	Tags []string `json:"tags"`
+
+	ProtectionEnabled   bool `json:"protection_enabled"`
+	FilteringEnabled    bool `json:"filtering_enabled"`
+	SafeSearchEnabled   bool `json:"safesearch_enabled"`
+	SafeBrowsingEnabled bool `json:"safebrowsing_enabled"`
+	ParentalEnabled     bool `json:"parental_enabled"`
+}
+
+// effectiveSettings returns the filtering settings that the DNS server applies
+// to the requests from the client with ip and clientID, as well as the
+// persistent client and the identifier it's been found by, if any.
+func effectiveSettings(
+	ip netip.Addr,
+	clientID string,
+) (setts *filtering.Settings, c *client.Persistent, matchedID string) {
+	setts = Context.filters.Settings()
+	if Context.dnsServer != nil {
+		setts.ProtectionEnabled, _ = Context.dnsServer.UpdatedProtectionStatus()
+	} else {
+		setts.ProtectionEnabled, _ = Context.filters.ProtectionStatus()
+	}
+
+	c, matchedID = applyClientSettings(ip, clientID, setts)
+
+	return setts, c, matchedID
+}
+
+// effectiveSettingsToJSON converts the effective settings into their JSON
+// form.
+func effectiveSettingsToJSON(
+	setts *filtering.Settings,
+	c *client.Persistent,
+	matchedID string,
+) (resp *effectiveSettingsJSON) {
+	resp = &effectiveSettingsJSON{
+		Source:              settingsSourceRuntime,
+		BlockedServices:     make([]string, 0, len(setts.ServicesRules)),
+		Tags:                slices.Clone(setts.ClientTags),
+		ProtectionEnabled:   setts.ProtectionEnabled,
+		FilteringEnabled:    setts.FilteringEnabled,
+		SafeSearchEnabled:   setts.SafeSearchEnabled,
+		SafeBrowsingEnabled: setts.SafeBrowsingEnabled,
+		ParentalEnabled:     setts.ParentalEnabled,
+	}
+
+	for _, svc := range setts.ServicesRules {
+		resp.BlockedServices = append(resp.BlockedServices, svc.Name)
+	}
+
+	if resp.Tags == nil {
+		resp.Tags = []string{}
+	}
+
+	if c != nil {
+		resp.Source = settingsSourcePersistent
+		resp.MatchedID = matchedID
+		resp.ClientName = c.Name
+	}
+
+	return resp
+}
+
+// handleEffectiveSettings is the handler for the GET
+// /control/clients/effective_settings HTTP API.
+func (clients *clientsContainer) handleEffectiveSettings(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	var ip netip.Addr
+	if ipStr := q.Get("ip"); ipStr != "" {
+		var err error
+		ip, err = netip.ParseAddr(ipStr)
+		if err != nil {
+			aghhttp.Error(r, w, http.StatusBadRequest, "parsing ip: %s", err)
+
+			return
+		}
+	}
+
+	clientID := q.Get("client_id")
+	if clientID != "" {
+		err := client.ValidateClientID(clientID)
+		if err != nil {
+			aghhttp.Error(r, w, http.StatusBadRequest, "client_id: %s", err)
+
+			return
+		}
+	}
+
+	if !ip.IsValid() && clientID == "" {
+		aghhttp.Error(r, w, http.StatusBadRequest, "either ip or client_id is required")
+
+		return
+	}
+
+	setts, c, matchedID := effectiveSettings(ip, clientID)
+
+	aghhttp.WriteJSONResponseOK(w, r, effectiveSettingsToJSON(setts, c, matchedID))
+}
+
 // RegisterClientsHandlers registers HTTP handlers
 func (clients *clientsContainer) registerWebHandlers() {
 	httpRegister(http.MethodGet, "/control/clients", clients.handleGetClients)
@@ -535,6 +730,13 @@ func (clients *clientsContainer) registerWebHandlers() {
 	httpRegister(http.MethodPost, "/control/clients/delete", clients.handleDelClient)
 	httpRegister(http.MethodPost, "/control/clients/update", clients.handleUpdateClient)
 	httpRegister(http.MethodPost, "/control/clients/search", clients.handleSearchClient)
+	httpRegister(http.MethodGet, "/control/clients/backup", clients.handleClientsBackup)
+	httpRegister(http.MethodPost, "/control/clients/restore", clients.handleClientsRestore)
+	httpRegister(
+		http.MethodGet,
+		"/control/clients/effective_settings",
+		clients.handleEffectiveSettings,
+	)
 
 	// Deprecated handler.
 	httpRegister(http.MethodGet, "/control/clients/find", clients.handleFindClient)
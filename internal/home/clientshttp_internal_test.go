@@ -551,3 +551,129 @@ func TestClientsContainer_HandleSearchClient(t *testing.T) {
 		})
 	}
 }
+
+func TestClientsContainer_HandleEffectiveSettings(t *testing.T) {
+	filtering.InitModule()
+
+	var err error
+	Context.filters, err = filtering.New(&filtering.Config{
+		BlockedServices: &filtering.BlockedServices{
+			Schedule: schedule.EmptyWeekly(),
+			IDs:      []string{"facebook"},
+		},
+	}, nil)
+	require.NoError(t, err)
+
+	kids := newPersistentClientWithIDs(t, "kids", []string{"kids"})
+	kids.Tags = []string{"user_child"}
+	kids.UseOwnSettings = true
+	kids.FilteringEnabled = true
+	kids.ParentalEnabled = true
+	kids.UseOwnBlockedServices = true
+	kids.BlockedServices.IDs = []string{"youtube"}
+
+	office := newPersistentClientWithIDs(t, "office", []string{testClientIP1})
+
+	Context.clients.storage = newStorage(t, []*client.Persistent{kids, office})
+
+	testCases := []struct {
+		query         url.Values
+		name          string
+		wantSource    string
+		wantMatchedID string
+		wantErrMsg    string
+		wantCode      int
+	}{{
+		query: url.Values{
+			"ip":        []string{testClientIP2},
+			"client_id": []string{"kids"},
+		},
+		name:          "client_id",
+		wantSource:    settingsSourcePersistent,
+		wantMatchedID: "kids",
+		wantErrMsg:    "",
+		wantCode:      http.StatusOK,
+	}, {
+		query: url.Values{
+			"client_id": []string{"kids"},
+		},
+		name:          "client_id_only",
+		wantSource:    settingsSourcePersistent,
+		wantMatchedID: "kids",
+		wantErrMsg:    "",
+		wantCode:      http.StatusOK,
+	}, {
+		query: url.Values{
+			"ip": []string{testClientIP1},
+		},
+		name:          "ip",
+		wantSource:    settingsSourcePersistent,
+		wantMatchedID: testClientIP1,
+		wantErrMsg:    "",
+		wantCode:      http.StatusOK,
+	}, {
+		query: url.Values{
+			"ip":        []string{testClientIP2},
+			"client_id": []string{"unknown"},
+		},
+		name:          "runtime",
+		wantSource:    settingsSourceRuntime,
+		wantMatchedID: "",
+		wantErrMsg:    "",
+		wantCode:      http.StatusOK,
+	}, {
+		query: url.Values{
+			"ip": []string{"bad"},
+		},
+		name:          "bad_ip",
+		wantSource:    "",
+		wantMatchedID: "",
+		wantErrMsg: `parsing ip: ParseAddr("bad"): unable to parse IP` +
+			"\n",
+		wantCode: http.StatusBadRequest,
+	}, {
+		query:         url.Values{},
+		name:          "no_ids",
+		wantSource:    "",
+		wantMatchedID: "",
+		wantErrMsg:    "either ip or client_id is required\n",
+		wantCode:      http.StatusBadRequest,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/control/clients/effective_settings", nil)
+			r.URL.RawQuery = tc.query.Encode()
+
+			rw := httptest.NewRecorder()
+			Context.clients.handleEffectiveSettings(rw, r)
+			require.Equal(t, tc.wantCode, rw.Code)
+
+			if tc.wantCode != http.StatusOK {
+				assert.Equal(t, tc.wantErrMsg, rw.Body.String())
+
+				return
+			}
+
+			got := &effectiveSettingsJSON{}
+			err = json.NewDecoder(rw.Body).Decode(got)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantSource, got.Source)
+			assert.Equal(t, tc.wantMatchedID, got.MatchedID)
+
+			// Compute the settings the same way the DNS server does.
+			ip, _ := netip.ParseAddr(tc.query.Get("ip"))
+			setts := Context.filters.Settings()
+			setts.ProtectionEnabled, _ = Context.filters.ProtectionStatus()
+			applyAdditionalFiltering(ip, tc.query.Get("client_id"), setts)
+
+			want := effectiveSettingsToJSON(setts, nil, "")
+			want.Source = got.Source
+			want.MatchedID = got.MatchedID
+			want.ClientName = setts.ClientName
+
+			assert.Equal(t, want, got)
+		})
+	}
+}
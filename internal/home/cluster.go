@@ -0,0 +1,263 @@
+package home
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/AdGuardHome/internal/client"
+	"github.com/AdguardTeam/AdGuardHome/internal/cluster"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/AdGuardHome/internal/version"
+	"github.com/AdguardTeam/golibs/container"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// clusterConfig is the configuration of the peering with the other members of
+// the cluster.
+type clusterConfig struct {
+	// Peers are the other members of the cluster.  If there are none, the
+	// peers aren't checked.
+	Peers []*clusterPeerConfig `yaml:"peers"`
+
+	// CheckInterval is the interval between the checks of the peers.
+	CheckInterval timeutil.Duration `yaml:"check_interval"`
+
+	// Timeout is the timeout of a single check of a peer.
+	Timeout timeutil.Duration `yaml:"timeout"`
+}
+
+// clusterPeerConfig is the configuration of a single member of the cluster.
+type clusterPeerConfig struct {
+	// Name is the unique name of the peer.
+	Name string `yaml:"name"`
+
+	// URL is the base URL of the web interface of the peer, for example
+	// "http://192.0.2.2:3000".
+	URL string `yaml:"url"`
+
+	// Username is the name of the user to authenticate with on the peer.
+	Username string `yaml:"username"`
+
+	// Password is the password of the user to authenticate with on the peer.
+	Password string `yaml:"password"`
+}
+
+// Default values of the cluster configuration.
+const (
+	defaultClusterCheckInterval = 1 * time.Minute
+	defaultClusterTimeout       = 5 * time.Second
+)
+
+// toCheckerConfig validates conf and returns the configuration of the checker
+// of the peers.
+func (conf *clusterConfig) toCheckerConfig(
+	logger *slog.Logger,
+) (checkerConf *cluster.CheckerConfig, err error) {
+	if conf.CheckInterval <= 0 {
+		return nil, fmt.Errorf("check_interval: must be positive, got %s", conf.CheckInterval)
+	} else if conf.Timeout <= 0 {
+		return nil, fmt.Errorf("timeout: must be positive, got %s", conf.Timeout)
+	}
+
+	names := container.NewMapSet[string]()
+	peers := make([]*cluster.PeerConfig, 0, len(conf.Peers))
+	for i, p := range conf.Peers {
+		var peer *cluster.PeerConfig
+		peer, err = p.toPeerConfig()
+		if err != nil {
+			return nil, fmt.Errorf("peers: at index %d: %w", i, err)
+		}
+
+		if names.Has(peer.Name) {
+			return nil, fmt.Errorf("peers: at index %d: duplicate name %q", i, peer.Name)
+		}
+
+		names.Add(peer.Name)
+		peers = append(peers, peer)
+	}
+
+	return &cluster.CheckerConfig{
+		Logger:      logger,
+		HTTPClient:  httpClient(),
+		Fingerprint: clusterFingerprint,
+		Peers:       peers,
+		Interval:    time.Duration(conf.CheckInterval),
+		Timeout:     time.Duration(conf.Timeout),
+	}, nil
+}
+
+// toPeerConfig validates c and returns the configuration of the peer.
+func (c *clusterPeerConfig) toPeerConfig() (p *cluster.PeerConfig, err error) {
+	if c == nil {
+		return nil, errors.Error("no value")
+	} else if c.Name == "" {
+		return nil, errors.Error("name: empty value")
+	}
+
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, fmt.Errorf("url: %w", err)
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("url: bad scheme %q", u.Scheme)
+	} else if u.Host == "" {
+		return nil, errors.Error("url: empty host")
+	}
+
+	return &cluster.PeerConfig{
+		URL:      u,
+		Name:     c.Name,
+		Username: c.Username,
+		Password: c.Password,
+	}, nil
+}
+
+// initCluster initializes [Context.cluster] if there are any peers in the
+// configuration.  baseLogger must not be nil.
+func initCluster(baseLogger *slog.Logger) (err error) {
+	if len(config.Cluster.Peers) == 0 {
+		return nil
+	}
+
+	checkerConf, err := config.Cluster.toCheckerConfig(
+		baseLogger.With(slogutil.KeyPrefix, "cluster"),
+	)
+	if err != nil {
+		return fmt.Errorf("cluster: %w", err)
+	}
+
+	Context.cluster = cluster.NewChecker(checkerConf)
+
+	return nil
+}
+
+// Configuration section names of the fingerprint.
+const (
+	clusterSectionClients   = "clients"
+	clusterSectionDNS       = "dns"
+	clusterSectionFilters   = "filters"
+	clusterSectionFiltering = "filtering"
+	clusterSectionUserRules = "user_rules"
+)
+
+// clusterFilter is the part of a filter list compared between the members of
+// the cluster.
+type clusterFilter struct {
+	URL     string `yaml:"url"`
+	Enabled bool   `yaml:"enabled"`
+	Allow   bool   `yaml:"allow"`
+}
+
+// clusterFingerprint returns the fingerprint of the local configuration.  The
+// settings that are expected to differ between the members of the cluster,
+// such as the addresses to listen on and the UIDs of the clients, aren't
+// taken into account.
+func clusterFingerprint() (fp *cluster.Fingerprint) {
+	filterConf := &filtering.Config{}
+	if Context.filters != nil {
+		Context.filters.WriteDiskConfig(filterConf)
+	}
+
+	lists := make([]*clusterFilter, 0, len(filterConf.Filters)+len(filterConf.WhitelistFilters))
+	versions := make([]*cluster.FilterVersion, 0, cap(lists))
+	for _, fl := range []struct {
+		filters []filtering.FilterYAML
+		allow   bool
+	}{{
+		filters: filterConf.Filters,
+		allow:   false,
+	}, {
+		filters: filterConf.WhitelistFilters,
+		allow:   true,
+	}} {
+		for _, f := range fl.filters {
+			lists = append(lists, &clusterFilter{
+				URL:     f.URL,
+				Enabled: f.Enabled,
+				Allow:   fl.allow,
+			})
+
+			versions = append(versions, &cluster.FilterVersion{
+				LastUpdated: f.LastUpdated,
+				URL:         f.URL,
+				RulesCount:  f.RulesCount,
+				Checksum:    f.Checksum(),
+				Enabled:     f.Enabled,
+			})
+		}
+	}
+
+	clients := Context.clients.forConfig()
+	for _, c := range clients {
+		c.UID = client.UID{}
+	}
+
+	userRules := filterConf.UserRules
+	filterConf.Filters, filterConf.WhitelistFilters, filterConf.UserRules = nil, nil, nil
+
+	config.RLock()
+	defer config.RUnlock()
+
+	dnsConf := config.DNS
+	dnsConf.BindHosts, dnsConf.Port = nil, 0
+
+	return &cluster.Fingerprint{
+		Sections: map[string]string{
+			clusterSectionClients:   sectionHash(clusterSectionClients, clients),
+			clusterSectionDNS:       sectionHash(clusterSectionDNS, dnsConf),
+			clusterSectionFilters:   sectionHash(clusterSectionFilters, lists),
+			clusterSectionFiltering: sectionHash(clusterSectionFiltering, filterConf),
+			clusterSectionUserRules: sectionHash(clusterSectionUserRules, userRules),
+		},
+		Version: version.Version(),
+		Filters: versions,
+		Running: isRunning(),
+	}
+}
+
+// sectionHash returns the hex-encoded SHA-256 hash of the YAML representation
+// of the configuration section v.  Errors are logged and an empty string is
+// returned in that case.
+func sectionHash(name string, v any) (hash string) {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		log.Error("cluster: encoding section %q: %s", name, err)
+
+		return ""
+	}
+
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// handleClusterFingerprint is the handler for the GET
+// /control/cluster/fingerprint HTTP API.  It's used by the other members of
+// the cluster.
+func handleClusterFingerprint(w http.ResponseWriter, r *http.Request) {
+	aghhttp.WriteJSONResponseOK(w, r, clusterFingerprint())
+}
+
+// handleClusterStatus is the handler for the GET /control/cluster/status HTTP
+// API.
+func handleClusterStatus(w http.ResponseWriter, r *http.Request) {
+	if Context.cluster == nil {
+		aghhttp.WriteJSONResponseOK(w, r, &cluster.Status{
+			Local: clusterFingerprint(),
+			Peers: []*cluster.PeerStatus{},
+		})
+
+		return
+	}
+
+	aghhttp.WriteJSONResponseOK(w, r, Context.cluster.Status())
+}
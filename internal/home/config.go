@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghalg"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghos"
@@ -23,6 +24,7 @@ import (
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/AdguardTeam/golibs/netutil"
 	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/c2h5oh/datasize"
 	"github.com/google/renameio/v2/maybe"
 	yaml "gopkg.in/yaml.v3"
 )
@@ -113,12 +115,10 @@ type configuration struct {
 	HTTPConfig httpConfig `yaml:"http"`
 	// Users are the clients capable for accessing the web interface.
 	Users []webUser `yaml:"users"`
-	// AuthAttempts is the maximum number of failed login attempts a user
-	// can do before being blocked.
-	AuthAttempts uint `yaml:"auth_attempts"`
-	// AuthBlockMin is the duration, in minutes, of the block of new login
-	// attempts after AuthAttempts unsuccessful login attempts.
-	AuthBlockMin uint `yaml:"block_auth_min"`
+	// APITokens are the tokens for the automated access to the HTTP API.
+	APITokens []*apiToken `yaml:"api_tokens"`
+	// Auth is the block with the settings of the login throttling.
+	Auth authConfig `yaml:"auth"`
 	// ProxyURL is the address of proxy server for the internal HTTP client.
 	ProxyURL string `yaml:"http_proxy"`
 	// Language is a two-letter ISO 639-1 language code.
@@ -152,6 +152,16 @@ type configuration struct {
 	// Keep this field sorted to ensure consistent ordering.
 	Clients *clientsConfig `yaml:"clients"`
 
+	// GeoIPDatabase is the path to the MaxMind GeoLite2-Country database used
+	// to tag runtime clients with their countries.  A relative path is
+	// relative to the working directory.  If the database is absent, runtime
+	// clients aren't tagged.
+	GeoIPDatabase string `yaml:"geoip_database"`
+
+	// Cluster is the configuration of the peering with the other members of
+	// the cluster.
+	Cluster clusterConfig `yaml:"cluster"`
+
 	// Log is a block with log configuration settings.
 	Log logSettings `yaml:"log"`
 
@@ -168,6 +178,30 @@ type configuration struct {
 	// NOTE: It's only exists for testing purposes and should not be used in
 	// release.
 	UnsafeUseCustomUpdateIndexURL bool `yaml:"unsafe_use_custom_update_index_url,omitempty"`
+
+	// UpdateSigningKey is the base64-encoded Ed25519 public key used to verify
+	// the signatures of the update packages instead of the one embedded into
+	// the binary.  It's intended for users building their own releases.
+	UpdateSigningKey string `yaml:"update_signing_key,omitempty"`
+
+	// UnsafeSkipUpdateVerification, if true, disables the verification of the
+	// signatures of the update packages.  It's the only way to install the
+	// unsigned updates.
+	UnsafeSkipUpdateVerification bool `yaml:"unsafe_skip_update_verification,omitempty"`
+}
+
+// authConfig is a block with the settings of the login throttling.
+type authConfig struct {
+	// AttemptsLimit is the maximum number of failed login attempts from a
+	// single IP address before it's blocked.  If it's zero, the throttling is
+	// disabled.
+	AttemptsLimit uint `yaml:"attempts_limit"`
+
+	// BlockDuration is the duration of the first block of new login attempts
+	// after AttemptsLimit unsuccessful ones.  Each subsequent failed attempt
+	// doubles it.  If it's zero, there is no lockout, and the blocks start from
+	// one second.
+	BlockDuration timeutil.Duration `yaml:"block_duration"`
 }
 
 // httpConfig is a block with HTTP configuration params.
@@ -178,12 +212,23 @@ type httpConfig struct {
 	// Pprof defines the profiling HTTP handler.
 	Pprof *httpPprofConfig `yaml:"pprof"`
 
+	// BlockPage defines the HTTP server of the block page.
+	BlockPage *blockPageConfig `yaml:"block_page"`
+
 	// Address is the address to serve the web UI on.
 	Address netip.AddrPort
 
 	// SessionTTL for a web session.
 	// An active session is automatically refreshed once a day.
 	SessionTTL timeutil.Duration `yaml:"session_ttl"`
+
+	// MaxRequests is the maximum number of unauthenticated requests from a
+	// single IP address within WindowSeconds.  If it's zero, the rate limiting
+	// of the web API is disabled.
+	MaxRequests int `yaml:"rate_limit_max_requests"`
+
+	// WindowSeconds is the duration of the rate limiting window, in seconds.
+	WindowSeconds int `yaml:"rate_limit_window_seconds"`
 }
 
 // httpPprofConfig is the block with pprof HTTP configuration.
@@ -215,6 +260,14 @@ type dnsConfig struct {
 	// UpstreamTimeout is the timeout for querying upstream servers.
 	UpstreamTimeout timeutil.Duration `yaml:"upstream_timeout"`
 
+	// UpstreamRetries is the maximum number of retries of a failed exchange
+	// with an upstream server.  Zero means no retries.
+	UpstreamRetries uint `yaml:"upstream_retries"`
+
+	// UpstreamRetryBackoff is the base duration of waiting before a retry of
+	// a failed exchange with an upstream server.
+	UpstreamRetryBackoff timeutil.Duration `yaml:"upstream_retry_backoff"`
+
 	// PrivateNets is the set of IP networks for which the private reverse DNS
 	// resolver should be used.
 	PrivateNets []netutil.Prefix `yaml:"private_networks"`
@@ -233,6 +286,12 @@ type dnsConfig struct {
 	// If empty, the OS-provided resolvers are used for private requests.
 	PrivateRDNSResolvers []string `yaml:"local_ptr_upstreams"`
 
+	// LocalPTRAuthoritative makes the responses to the PTR requests for the
+	// private addresses built from the DHCP leases and the hosts files
+	// authoritative.  The requests for the private addresses without local
+	// data aren't sent to [PrivateRDNSResolvers] in that case.
+	LocalPTRAuthoritative bool `yaml:"local_ptr_authoritative"`
+
 	// UseDNS64 defines if DNS64 should be used for incoming requests.  Requests
 	// of type PTR for addresses within the configured prefixes will be resolved
 	// via [PrivateRDNSResolvers], so those should be valid and UsePrivateRDNS
@@ -303,6 +362,27 @@ type queryLogConfig struct {
 	// to disk.
 	MemSize uint `yaml:"size_memory"`
 
+	// MinFreeSpace is the minimum free space on the filesystem containing the
+	// query log files.  Below it, the query log is only kept in memory.  If
+	// zero, it isn't checked.
+	MinFreeSpace datasize.ByteSize `yaml:"min_free_space"`
+
+	// MinFreeSpacePercent is the same as MinFreeSpace but in percents of the
+	// total space of the filesystem.  If zero, it isn't checked.
+	MinFreeSpacePercent float64 `yaml:"min_free_space_percent"`
+
+	// AnonymizationPrefixIPv4 is the length of the prefix of the clients' IPv4
+	// addresses kept when [dnsConfig.AnonymizeClientIP] is true.
+	AnonymizationPrefixIPv4 int `yaml:"anonymization_prefix_ipv4"`
+
+	// AnonymizationPrefixIPv6 is the length of the prefix of the clients' IPv6
+	// addresses kept when [dnsConfig.AnonymizeClientIP] is true.
+	AnonymizationPrefixIPv6 int `yaml:"anonymization_prefix_ipv6"`
+
+	// Remote is the configuration of forwarding the query log entries to a
+	// centralized log management system.
+	Remote querylog.RemoteLogConfig `yaml:"remote"`
+
 	// Enabled defines if the query log is enabled.
 	Enabled bool `yaml:"enabled"`
 
@@ -335,8 +415,10 @@ const (
 //
 // TODO(a.garipov, e.burkov): This global is awful and must be removed.
 var config = &configuration{
-	AuthAttempts: 5,
-	AuthBlockMin: 15,
+	Auth: authConfig{
+		AttemptsLimit: 5,
+		BlockDuration: timeutil.Duration(15 * time.Minute),
+	},
 	HTTPConfig: httpConfig{
 		Address:    netip.AddrPortFrom(netip.IPv4Unspecified(), 3000),
 		SessionTTL: timeutil.Duration(30 * timeutil.Day),
@@ -344,6 +426,12 @@ var config = &configuration{
 			Enabled: false,
 			Port:    6060,
 		},
+		BlockPage: &blockPageConfig{
+			Address: netip.AddrPortFrom(netip.IPv4Unspecified(), 80),
+			Enabled: false,
+		},
+		MaxRequests:   300,
+		WindowSeconds: 60,
 	},
 	DNS: dnsConfig{
 		BindHosts: []netip.Addr{netip.IPv4Unspecified()},
@@ -357,6 +445,8 @@ var config = &configuration{
 			HandleDDR:              true,
 			FastestTimeout:         timeutil.Duration(fastip.DefaultPingWaitTimeout),
 
+			FailoverRecoveryInterval: timeutil.Duration(time.Minute),
+
 			TrustedProxies: []netutil.Prefix{{
 				Prefix: netip.MustParsePrefix("127.0.0.0/8"),
 			}, {
@@ -364,10 +454,16 @@ var config = &configuration{
 			}},
 			CacheSize: 4 * 1024 * 1024,
 
+			ServeStaleMaxAge: timeutil.Duration(timeutil.Day),
+
+			SessionIdleTimeout: timeutil.Duration(time.Hour),
+
 			EDNSClientSubnet: &dnsforward.EDNSClientSubnet{
-				CustomIP:  netip.Addr{},
-				Enabled:   false,
-				UseCustom: false,
+				CustomIP:       netip.Addr{},
+				TrustedProxies: []netutil.Prefix{},
+				Enabled:        false,
+				UseCustom:      false,
+				UseForClients:  false,
 			},
 
 			// set default maximum concurrent queries to 300
@@ -376,10 +472,11 @@ var config = &configuration{
 			// was later increased to 300 due to https://github.com/AdguardTeam/AdGuardHome/issues/2257
 			MaxGoroutines: 300,
 		},
-		UpstreamTimeout:  timeutil.Duration(dnsforward.DefaultTimeout),
-		UsePrivateRDNS:   true,
-		ServePlainDNS:    true,
-		HostsFileEnabled: true,
+		UpstreamTimeout:      timeutil.Duration(dnsforward.DefaultTimeout),
+		UpstreamRetryBackoff: timeutil.Duration(100 * time.Millisecond),
+		UsePrivateRDNS:       true,
+		ServePlainDNS:        true,
+		HostsFileEnabled:     true,
 	},
 	TLS: tlsConfigSettings{
 		PortHTTPS:       defaultPortHTTPS,
@@ -392,6 +489,18 @@ var config = &configuration{
 		Interval:    timeutil.Duration(90 * timeutil.Day),
 		MemSize:     1000,
 		Ignored:     []string{},
+
+		MinFreeSpace:        100 * datasize.MB,
+		MinFreeSpacePercent: 1,
+
+		AnonymizationPrefixIPv4: querylog.DefaultAnonymizationPrefixIPv4,
+		AnonymizationPrefixIPv6: querylog.DefaultAnonymizationPrefixIPv6,
+
+		Remote: querylog.RemoteLogConfig{
+			Protocol:  querylog.RemoteLogProtocolUDP,
+			Format:    querylog.RemoteLogFormatGELF,
+			BatchSize: querylog.DefaultRemoteLogBatchSize,
+		},
 	},
 	Stats: statsConfig{
 		Enabled:  true,
@@ -404,23 +513,29 @@ var config = &configuration{
 	// TODO(a.garipov): Think of a way to make scripts/vetted-filters update
 	// these as well if necessary.
 	Filters: []filtering.FilterYAML{{
-		Filter:  filtering.Filter{ID: 1},
-		Enabled: true,
-		URL:     "https://adguardteam.github.io/HostlistsRegistry/assets/filter_1.txt",
-		Name:    "AdGuard DNS filter",
+		Filter:   filtering.Filter{ID: 1},
+		Enabled:  true,
+		URL:      "https://adguardteam.github.io/HostlistsRegistry/assets/filter_1.txt",
+		Name:     "AdGuard DNS filter",
+		Category: filtering.DefaultFilterCategory,
 	}, {
-		Filter:  filtering.Filter{ID: 2},
-		Enabled: false,
-		URL:     "https://adguardteam.github.io/HostlistsRegistry/assets/filter_2.txt",
-		Name:    "AdAway Default Blocklist",
+		Filter:   filtering.Filter{ID: 2},
+		Enabled:  false,
+		URL:      "https://adguardteam.github.io/HostlistsRegistry/assets/filter_2.txt",
+		Name:     "AdAway Default Blocklist",
+		Category: filtering.DefaultFilterCategory,
 	}},
 	Filtering: &filtering.Config{
-		ProtectionEnabled:  true,
-		BlockingMode:       filtering.BlockingModeDefault,
-		BlockedResponseTTL: 10, // in seconds
+		ProtectionEnabled:        true,
+		BlockingMode:             filtering.BlockingModeDefault,
+		AAAABlockingMode:         filtering.AAAABlockingModeDefault,
+		SafeBrowsingBlockingMode: filtering.SafeBrowsingBlockingModeBlockHost,
+		BlockedResponseTTL:       10, // in seconds
 
 		FilteringEnabled:           true,
 		FiltersUpdateIntervalHours: 24,
+		FilterUpdateConcurrency:    filtering.DefaultFilterUpdateConcurrency,
+		BlockedServicesIndexURL:    filtering.DefaultBlockedServicesIndexURL,
 
 		ParentalEnabled:     false,
 		SafeBrowsingEnabled: false,
@@ -433,6 +548,7 @@ var config = &configuration{
 		SafeSearchConf: filtering.SafeSearchConfig{
 			Enabled:    false,
 			Bing:       true,
+			Brave:      true,
 			DuckDuckGo: true,
 			Ecosia:     true,
 			Google:     true,
@@ -446,14 +562,20 @@ var config = &configuration{
 			IDs:      []string{},
 		},
 
+		ConnectivityCheck: &filtering.ConnectivityCheckConfig{
+			Domains: filtering.DefaultConnectivityCheckDomains(),
+			Enabled: true,
+		},
+
 		ParentalBlockHost:     defaultParentalBlockHost,
 		SafeBrowsingBlockHost: defaultSafeBrowsingBlockHost,
 	},
 	DHCP: &dhcpd.ServerConfig{
 		LocalDomainName: "lan",
 		Conf4: dhcpd.V4ServerConf{
-			LeaseDuration: dhcpd.DefaultDHCPLeaseTTL,
-			ICMPTimeout:   dhcpd.DefaultDHCPTimeoutICMP,
+			LeaseDuration:     dhcpd.DefaultDHCPLeaseTTL,
+			ICMPTimeout:       dhcpd.DefaultDHCPTimeoutICMP,
+			ConflictDetection: dhcpd.ConflictDetectionPing,
 		},
 		Conf6: dhcpd.V6ServerConf{
 			LeaseDuration: dhcpd.DefaultDHCPLeaseTTL,
@@ -468,6 +590,11 @@ var config = &configuration{
 			HostsFile: true,
 		},
 	},
+	Cluster: clusterConfig{
+		Peers:         []*clusterPeerConfig{},
+		CheckInterval: timeutil.Duration(defaultClusterCheckInterval),
+		Timeout:       timeutil.Duration(defaultClusterTimeout),
+	},
 	Log: logSettings{
 		Enabled:    true,
 		File:       "",
@@ -521,35 +648,31 @@ func validateBindHosts(conf *configuration) (err error) {
 }
 
 // parseConfig loads configuration from the YAML file, upgrading it if
-// necessary.
-func parseConfig() (err error) {
-	// Do the upgrade if necessary.
+// necessary.  If rollback is true, the configuration file is backed up before
+// the upgrade, see [rollbackOnInitError].
+func parseConfig(rollback bool) (err error) {
 	config.fileData, err = readConfigFile()
 	if err != nil {
 		return err
 	}
 
-	migrator := configmigrate.New(&configmigrate.Config{
-		WorkingDir: Context.workDir,
-		DataDir:    Context.getDataDir(),
-	})
+	if Context.configRolledBack {
+		// The rolled back file may have an older schema, so it still needs to
+		// be upgraded, but only in memory, so that the file keeps the schema
+		// version it had before the failed upgrade.
+		log.Info("upgrading rolled back configuration in memory")
+
+		config.fileData, _, err = configmigrate.New(newMigrationConfig()).Migrate(
+			config.fileData,
+			configmigrate.LastSchemaVersion,
+		)
+	} else {
+		err = upgradeConfig(rollback)
+	}
 
-	var upgraded bool
-	config.fileData, upgraded, err = migrator.Migrate(
-		config.fileData,
-		configmigrate.LastSchemaVersion,
-	)
 	if err != nil {
 		// Don't wrap the error, because it's informative enough as is.
 		return err
-	} else if upgraded {
-		confPath := configFilePath()
-		log.Debug("writing config file %q after config upgrade", confPath)
-
-		err = maybe.WriteFile(confPath, config.fileData, aghos.DefaultPermFile)
-		if err != nil {
-			return fmt.Errorf("writing new config: %w", err)
-		}
 	}
 
 	err = yaml.Unmarshal(config.fileData, &config)
@@ -571,6 +694,56 @@ func parseConfig() (err error) {
 	return setContextTLSCipherIDs()
 }
 
+// upgradeConfig upgrades the configuration file data to the latest schema
+// version and writes it to the file, if necessary.  If rollback is true, the
+// upgraded file can be rolled back with [Context.configMigrator].
+func upgradeConfig(rollback bool) (err error) {
+	confPath := configFilePath()
+	migrConf := newMigrationConfig()
+
+	var upgraded bool
+	if rollback {
+		migrator := configmigrate.NewWithRollback(migrConf, confPath)
+		config.fileData, upgraded, err = migrator.Migrate(
+			config.fileData,
+			configmigrate.LastSchemaVersion,
+		)
+		if upgraded {
+			Context.configMigrator = migrator
+		}
+	} else {
+		config.fileData, upgraded, err = configmigrate.New(migrConf).Migrate(
+			config.fileData,
+			configmigrate.LastSchemaVersion,
+		)
+	}
+
+	if err != nil {
+		// Don't wrap the error, because it's informative enough as is.
+		return err
+	} else if !upgraded {
+		return nil
+	}
+
+	log.Debug("writing config file %q after config upgrade", confPath)
+
+	err = maybe.WriteFile(confPath, config.fileData, aghos.DefaultPermFile)
+	if err != nil {
+		return fmt.Errorf("writing new config: %w", err)
+	}
+
+	return nil
+}
+
+// newMigrationConfig returns the configuration for the migrators of the
+// configuration file.
+func newMigrationConfig() (c *configmigrate.Config) {
+	return &configmigrate.Config{
+		WorkingDir: Context.workDir,
+		DataDir:    Context.getDataDir(),
+	}
+}
+
 // validateConfig returns error if the configuration is invalid.
 func validateConfig() (err error) {
 	err = validateBindHosts(config)
@@ -598,12 +771,23 @@ func validateConfig() (err error) {
 		addPorts(udpPorts, udpPort(config.TLS.PortDNSOverQUIC))
 	}
 
+	if bp := config.HTTPConfig.BlockPage; bp != nil && bp.Enabled {
+		addPorts(tcpPorts, tcpPort(bp.Address.Port()))
+	}
+
 	if err = tcpPorts.Validate(); err != nil {
 		return fmt.Errorf("validating tcp ports: %w", err)
 	} else if err = udpPorts.Validate(); err != nil {
 		return fmt.Errorf("validating udp ports: %w", err)
 	}
 
+	if hc := config.HTTPConfig; hc.MaxRequests > 0 && hc.WindowSeconds <= 0 {
+		return fmt.Errorf(
+			"http: rate_limit_window_seconds: must be positive, got %d",
+			hc.WindowSeconds,
+		)
+	}
+
 	if !filtering.ValidateUpdateIvl(config.Filtering.FiltersUpdateIntervalHours) {
 		config.Filtering.FiltersUpdateIntervalHours = 24
 	}
@@ -639,6 +823,46 @@ func readConfigFile() (fileData []byte, err error) {
 	return os.ReadFile(confPath)
 }
 
+// readPersistentClients reads the persistent clients from the configuration
+// file on disk, ignoring the cached file contents.  The file is upgraded to the
+// latest schema version in memory, if necessary, and the files with a newer
+// schema version are rejected.
+func readPersistentClients() (objs []*clientObject, err error) {
+	confPath := configFilePath()
+	log.Debug("reading persistent clients from config file %q", confPath)
+
+	data, err := os.ReadFile(confPath)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+
+	migrator := configmigrate.New(&configmigrate.Config{
+		WorkingDir: Context.workDir,
+		DataDir:    Context.getDataDir(),
+	})
+
+	data, upgraded, err := migrator.Migrate(data, configmigrate.LastSchemaVersion)
+	if err != nil {
+		return nil, fmt.Errorf("upgrading config: %w", err)
+	} else if upgraded {
+		log.Info("upgraded config file %q in memory to read persistent clients", confPath)
+	}
+
+	conf := &struct {
+		Clients struct {
+			Persistent []*clientObject `yaml:"persistent"`
+		} `yaml:"clients"`
+	}{}
+
+	err = yaml.Unmarshal(data, conf)
+	if err != nil {
+		return nil, fmt.Errorf("decoding config: %w", err)
+	}
+
+	return conf.Clients.Persistent, nil
+}
+
 // Saves configuration to the YAML file and also saves the user filter contents to a file
 func (c *configuration) write() (err error) {
 	c.Lock()
@@ -646,6 +870,7 @@ func (c *configuration) write() (err error) {
 
 	if Context.auth != nil {
 		config.Users = Context.auth.usersList()
+		config.APITokens = Context.auth.apiTokensList()
 	}
 
 	if Context.tls != nil {
@@ -670,6 +895,11 @@ func (c *configuration) write() (err error) {
 		config.QueryLog.FileEnabled = dc.FileEnabled
 		config.QueryLog.Interval = timeutil.Duration(dc.RotationIvl)
 		config.QueryLog.MemSize = dc.MemSize
+		config.QueryLog.MinFreeSpace = dc.MinFreeSpace
+		config.QueryLog.MinFreeSpacePercent = dc.MinFreeSpacePercent
+		config.QueryLog.AnonymizationPrefixIPv4 = dc.AnonymizationPrefixIPv4
+		config.QueryLog.AnonymizationPrefixIPv6 = dc.AnonymizationPrefixIPv6
+		config.QueryLog.Remote = dc.Remote
 		config.QueryLog.Ignored = dc.Ignored.Values()
 	}
 
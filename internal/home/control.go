@@ -112,6 +112,10 @@ type statusResponse struct {
 	// openapi.yaml declares.
 	IsDHCPAvailable bool `json:"dhcp_available"`
 	IsRunning       bool `json:"running"`
+
+	// QueryLogFilePaused is true if writing the query log to the file is
+	// paused due to the low disk space.
+	QueryLogFilePaused bool `json:"querylog_file_paused"`
 }
 
 func handleStatus(w http.ResponseWriter, r *http.Request) {
@@ -160,6 +164,10 @@ func handleStatus(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
+	if Context.queryLog != nil {
+		resp.QueryLogFilePaused = Context.queryLog.FileLoggingPaused()
+	}
+
 	// IsDHCPAvailable field is now false by default for Windows.
 	if runtime.GOOS != "windows" {
 		resp.IsDHCPAvailable = Context.dhcpServer != nil
@@ -183,6 +191,16 @@ func registerControlHandlers(web *webAPI) {
 	httpRegister(http.MethodGet, "/control/i18n/current_language", handleI18nCurrentLanguage)
 	httpRegister(http.MethodGet, "/control/profile", handleGetProfile)
 	httpRegister(http.MethodPut, "/control/profile/update", handlePutProfile)
+	httpRegister(http.MethodGet, "/metrics", handleMetrics)
+	httpRegister(http.MethodGet, "/control/support_info", handleSupportInfo)
+	httpRegister(http.MethodGet, "/control/cluster/status", handleClusterStatus)
+	httpRegister(http.MethodGet, "/control/cluster/fingerprint", handleClusterFingerprint)
+	httpRegister(http.MethodGet, "/control/notifications", Context.notifications.handleNotifications)
+	httpRegister(
+		http.MethodPost,
+		"/control/notifications/dismiss",
+		Context.notifications.handleNotificationsDismiss,
+	)
 
 	// No auth is necessary for DoH/DoT configurations
 	Context.mux.HandleFunc("/apple/doh.mobileconfig", postInstall(handleMobileConfigDoH))
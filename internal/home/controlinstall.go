@@ -8,16 +8,14 @@ import (
 	"log/slog"
 	"net/http"
 	"net/netip"
-	"os"
-	"os/exec"
 	"path/filepath"
-	"runtime"
 	"time"
 	"unicode/utf8"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghalg"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/AdguardTeam/AdGuardHome/internal/aghos"
 	"github.com/AdguardTeam/AdGuardHome/internal/version"
 	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/logutil/slogutil"
@@ -76,10 +74,52 @@ type checkConfReq struct {
 }
 
 type checkConfRespEnt struct {
+	// PortOccupant is the process occupying the requested port.  It's nil if
+	// the port is free or the process is unknown.
+	PortOccupant *aghnet.PortOccupant `json:"port_occupant,omitempty"`
+
 	Status     string `json:"status"`
 	CanAutofix bool   `json:"can_autofix"`
 }
 
+// setPortError sets the status of e to the error of binding the port of ipp
+// for networks.  If the port is occupied, it also looks for the occupying
+// process.
+func (e *checkConfRespEnt) setPortError(
+	ctx context.Context,
+	l *slog.Logger,
+	err error,
+	ipp netip.AddrPort,
+	networks ...string,
+) {
+	e.Status = err.Error()
+	if !aghnet.IsAddrInUse(err) {
+		return
+	}
+
+	for _, network := range networks {
+		o, findErr := aghnet.FindPortOccupant(network, ipp)
+		if findErr != nil {
+			l.DebugContext(
+				ctx,
+				"finding port occupant",
+				"network", network,
+				slogutil.KeyError, findErr,
+			)
+		} else if o != nil {
+			e.PortOccupant = o
+			e.Status = fmt.Sprintf(
+				"%s; the port is used by %s with pid %d",
+				e.Status,
+				o.Process,
+				o.PID,
+			)
+
+			return
+		}
+	}
+}
+
 type staticIPJSON struct {
 	Static string `json:"static"`
 	IP     string `json:"ip"`
@@ -157,10 +197,11 @@ func (req *checkConfReq) validateDNS(
 	}
 
 	// Try to fix automatically.
-	canAutofix = checkDNSStubListener(ctx, l)
+	stub := newResolvedStub(l)
+	canAutofix = stub.CanDisable(ctx)
 	if canAutofix && req.DNS.Autofix {
-		if derr := disableDNSStubListener(ctx, l); derr != nil {
-			l.ErrorContext(ctx, "disabling DNSStubListener", slogutil.KeyError, err)
+		if derr := stub.Disable(ctx); derr != nil {
+			l.ErrorContext(ctx, "disabling DNSStubListener", slogutil.KeyError, derr)
 		}
 
 		err = aghnet.CheckPort("udp", netip.AddrPortFrom(req.DNS.IP, port))
@@ -181,14 +222,17 @@ func (web *webAPI) handleInstallCheckConfig(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	ctx := r.Context()
 	resp := &checkConfResp{}
 	tcpPorts := aghalg.UniqChecker[tcpPort]{}
 	if err = req.validateWeb(tcpPorts); err != nil {
-		resp.Web.Status = err.Error()
+		webAddr := netip.AddrPortFrom(req.Web.IP, req.Web.Port)
+		resp.Web.setPortError(ctx, web.logger, err, webAddr, "tcp")
 	}
 
-	if resp.DNS.CanAutofix, err = req.validateDNS(r.Context(), web.logger, tcpPorts); err != nil {
-		resp.DNS.Status = err.Error()
+	if resp.DNS.CanAutofix, err = req.validateDNS(ctx, web.logger, tcpPorts); err != nil {
+		dnsAddr := netip.AddrPortFrom(req.DNS.IP, req.DNS.Port)
+		resp.DNS.setPortError(ctx, web.logger, err, dnsAddr, "udp", "tcp")
 	} else if !req.DNS.IP.IsUnspecified() {
 		resp.StaticIP = handleStaticIP(req.DNS.IP, req.SetStaticIP)
 	}
@@ -236,87 +280,19 @@ func handleStaticIP(ip netip.Addr, set bool) staticIPJSON {
 	return resp
 }
 
-// checkDNSStubListener returns true if DNSStubListener is active.
-func checkDNSStubListener(ctx context.Context, l *slog.Logger) (ok bool) {
-	if runtime.GOOS != "linux" {
-		return false
-	}
-
-	cmd := exec.Command("systemctl", "is-enabled", "systemd-resolved")
-	l.DebugContext(ctx, "executing", "cmd", cmd.Path, "args", cmd.Args)
-	_, err := cmd.Output()
-	if err != nil || cmd.ProcessState.ExitCode() != 0 {
-		l.InfoContext(
-			ctx,
-			"execution failed",
-			"cmd", cmd.Path,
-			"code", cmd.ProcessState.ExitCode(),
-			slogutil.KeyError, err,
-		)
-
-		return false
-	}
-
-	cmd = exec.Command("grep", "-E", "#?DNSStubListener=yes", "/etc/systemd/resolved.conf")
-	l.DebugContext(ctx, "executing", "cmd", cmd.Path, "args", cmd.Args)
-	_, err = cmd.Output()
-	if err != nil || cmd.ProcessState.ExitCode() != 0 {
-		l.InfoContext(
-			ctx,
-			"execution failed",
-			"cmd", cmd.Path,
-			"code", cmd.ProcessState.ExitCode(),
-			slogutil.KeyError, err,
-		)
-
-		return false
-	}
-
-	return true
-}
-
-const (
-	resolvedConfPath = "/etc/systemd/resolved.conf.d/adguardhome.conf"
-	resolvedConfData = `[Resolve]
-DNS=127.0.0.1
-DNSStubListener=no
-`
-)
-const resolvConfPath = "/etc/resolv.conf"
-
-// disableDNSStubListener deactivates DNSStubListerner and returns an error, if
-// any.
-func disableDNSStubListener(ctx context.Context, l *slog.Logger) (err error) {
-	dir := filepath.Dir(resolvedConfPath)
-	err = os.MkdirAll(dir, 0o755)
-	if err != nil {
-		return fmt.Errorf("os.MkdirAll: %s: %w", dir, err)
-	}
-
-	err = os.WriteFile(resolvedConfPath, []byte(resolvedConfData), 0o644)
-	if err != nil {
-		return fmt.Errorf("os.WriteFile: %s: %w", resolvedConfPath, err)
-	}
-
-	_ = os.Rename(resolvConfPath, resolvConfPath+".backup")
-	err = os.Symlink("/run/systemd/resolve/resolv.conf", resolvConfPath)
-	if err != nil {
-		_ = os.Remove(resolvedConfPath) // remove the file we've just created
-		return fmt.Errorf("os.Symlink: %s: %w", resolvConfPath, err)
-	}
-
-	cmd := exec.Command("systemctl", "reload-or-restart", "systemd-resolved")
-	l.DebugContext(ctx, "executing", "cmd", cmd.Path, "args", cmd.Args)
-	_, err = cmd.Output()
-	if err != nil {
-		return err
-	}
-	if cmd.ProcessState.ExitCode() != 0 {
-		return fmt.Errorf("process %s exited with an error: %d",
-			cmd.Path, cmd.ProcessState.ExitCode())
-	}
-
-	return nil
+// resolvedStubStateFile is the name of the file in the working directory, in
+// which the changes made to the configuration of systemd-resolved are stored.
+const resolvedStubStateFile = "resolved_stub.json"
+
+// newResolvedStub returns a new manager of the stub listener of
+// systemd-resolved storing the changes into the working directory.
+func newResolvedStub(l *slog.Logger) (r *aghos.ResolvedStub) {
+	return aghos.NewResolvedStub(&aghos.ResolvedStubConfig{
+		Logger:     l.With(slogutil.KeyPrefix, "resolved"),
+		RunCommand: aghos.RunCommand,
+		RootDir:    "/",
+		StatePath:  filepath.Join(Context.workDir, resolvedStubStateFile),
+	})
 }
 
 type applyConfigReqEnt struct {
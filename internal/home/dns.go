@@ -49,7 +49,9 @@ func onConfigModified() {
 // server and initializes it at last.  It also must not be called unless
 // [config] and [Context] are initialized.  baseLogger must not be nil.
 func initDNS(baseLogger *slog.Logger, statsDir, querylogDir string) (err error) {
-	anonymizer := config.anonymizer()
+	// The anonymizer is set by the query log according to its configuration
+	// and used by the DNS server for both the query log and the statistics.
+	anonymizer := aghnet.NewIPMut(nil)
 
 	statsConf := stats.Config{
 		Logger:            baseLogger.With(slogutil.KeyPrefix, "stats"),
@@ -59,6 +61,7 @@ func initDNS(baseLogger *slog.Logger, statsDir, querylogDir string) (err error)
 		HTTPRegister:      httpRegister,
 		Enabled:           config.Stats.Enabled,
 		ShouldCountClient: Context.clients.shouldCountClient,
+		ClientRetention:   Context.clients.statsRetention,
 	}
 
 	engine, err := aghnet.NewIgnoreEngine(config.Stats.Ignored)
@@ -78,12 +81,21 @@ func initDNS(baseLogger *slog.Logger, statsDir, querylogDir string) (err error)
 		ConfigModified:    onConfigModified,
 		HTTPRegister:      httpRegister,
 		FindClient:        Context.clients.findMultiple,
+		ClientRetention:   Context.clients.queryLogRetention,
 		BaseDir:           querylogDir,
 		AnonymizeClientIP: config.DNS.AnonymizeClientIP,
 		RotationIvl:       time.Duration(config.QueryLog.Interval),
 		MemSize:           config.QueryLog.MemSize,
 		Enabled:           config.QueryLog.Enabled,
 		FileEnabled:       config.QueryLog.FileEnabled,
+
+		MinFreeSpace:        config.QueryLog.MinFreeSpace,
+		MinFreeSpacePercent: config.QueryLog.MinFreeSpacePercent,
+
+		AnonymizationPrefixIPv4: config.QueryLog.AnonymizationPrefixIPv4,
+		AnonymizationPrefixIPv6: config.QueryLog.AnonymizationPrefixIPv6,
+
+		Remote: config.QueryLog.Remote,
 	}
 
 	engine, err = aghnet.NewIgnoreEngine(config.QueryLog.Ignored)
@@ -97,6 +109,7 @@ func initDNS(baseLogger *slog.Logger, statsDir, querylogDir string) (err error)
 		return fmt.Errorf("init querylog: %w", err)
 	}
 
+	config.Filtering.QueryLog = Context.queryLog
 	Context.filters, err = filtering.New(config.Filtering, nil)
 	if err != nil {
 		// Don't wrap the error, since it's informative enough as is.
@@ -244,6 +257,8 @@ func newServerConfig(
 		TLSConfig:              newDNSTLSConfig(tlsConf, hosts),
 		TLSAllowUnencryptedDoH: tlsConf.AllowUnencryptedDoH,
 		UpstreamTimeout:        time.Duration(dnsConf.UpstreamTimeout),
+		UpstreamRetries:        dnsConf.UpstreamRetries,
+		UpstreamRetryBackoff:   time.Duration(dnsConf.UpstreamRetryBackoff),
 		TLSv12Roots:            Context.tlsRoots,
 		ConfigModified:         onConfigModified,
 		HTTPRegister:           httpReg,
@@ -251,6 +266,7 @@ func newServerConfig(
 		UseDNS64:               dnsConf.UseDNS64,
 		DNS64Prefixes:          dnsConf.DNS64Prefixes,
 		UsePrivateRDNS:         dnsConf.UsePrivateRDNS,
+		LocalPTRAuthoritative:  dnsConf.LocalPTRAuthoritative,
 		ServeHTTP3:             dnsConf.ServeHTTP3,
 		UseHTTP3Upstreams:      dnsConf.UseHTTP3Upstreams,
 		ServePlainDNS:          dnsConf.ServePlainDNS,
@@ -399,6 +415,19 @@ func getDNSEncryption() (de dnsEncryption) {
 // applyAdditionalFiltering adds additional client information and settings if
 // the client has them.
 func applyAdditionalFiltering(clientIP netip.Addr, clientID string, setts *filtering.Settings) {
+	_, _ = applyClientSettings(clientIP, clientID, setts)
+}
+
+// applyClientSettings adds additional client information and settings if the
+// client has them.  c is the persistent client whose settings have been
+// applied and matchedID is the identifier it's been found by, either clientID
+// or the string form of clientIP.  c is nil if no persistent client has been
+// found.
+func applyClientSettings(
+	clientIP netip.Addr,
+	clientID string,
+	setts *filtering.Settings,
+) (c *client.Persistent, matchedID string) {
 	// pref is a prefix for logging messages around the scope.
 	const pref = "applying filters"
 
@@ -407,19 +436,22 @@ func applyAdditionalFiltering(clientIP netip.Addr, clientID string, setts *filte
 	log.Debug("%s: looking for client with ip %s and clientid %q", pref, clientIP, clientID)
 
 	if !clientIP.IsValid() {
-		return
+		if clientID == "" {
+			return nil, ""
+		}
+	} else {
+		setts.ClientIP = clientIP
 	}
 
-	setts.ClientIP = clientIP
-
-	c, ok := Context.clients.storage.Find(clientID)
+	c, matchedID, ok := findSettingsClient(clientIP, clientID)
 	if !ok {
-		c, ok = Context.clients.storage.Find(clientIP.String())
-		if !ok {
-			log.Debug("%s: no clients with ip %s and clientid %q", pref, clientIP, clientID)
+		log.Debug("%s: no clients with ip %s and clientid %q", pref, clientIP, clientID)
 
-			return
+		if clientIP.IsValid() {
+			setts.ClientTags = Context.clients.storage.RuntimeTags(clientIP)
 		}
+
+		return nil, ""
 	}
 
 	log.Debug("%s: using settings for client %q (%s; %q)", pref, c.Name, clientIP, clientID)
@@ -427,17 +459,27 @@ func applyAdditionalFiltering(clientIP netip.Addr, clientID string, setts *filte
 	if c.UseOwnBlockedServices {
 		// TODO(e.burkov):  Get rid of this crutch.
 		setts.ServicesRules = nil
+		setts.PausedServicesRules = nil
 		svcs := c.BlockedServices.IDs
 		if !c.BlockedServices.Schedule.Contains(time.Now()) {
 			Context.filters.ApplyBlockedServicesList(setts, svcs)
 			log.Debug("%s: services for client %q set: %s", pref, c.Name, svcs)
+		} else {
+			Context.filters.ApplyPausedBlockedServicesList(setts, svcs)
 		}
 	}
 
 	setts.ClientName = c.Name
 	setts.ClientTags = c.Tags
+	setts.ClientRules = c.ClientRules
+	setts.IgnoreQueryLog = c.IgnoreQueryLog
+	setts.IgnoreStatistics = c.IgnoreStatistics
+	if c.UseOwnBlockedSuffixes {
+		setts.BlockedSuffixes = c.BlockedSuffixSet
+	}
+
 	if !c.UseOwnSettings {
-		return
+		return c, matchedID
 	}
 
 	setts.FilteringEnabled = c.FilteringEnabled
@@ -445,6 +487,35 @@ func applyAdditionalFiltering(clientIP netip.Addr, clientID string, setts *filte
 	setts.ClientSafeSearch = c.SafeSearch
 	setts.SafeBrowsingEnabled = c.SafeBrowsingEnabled
 	setts.ParentalEnabled = c.ParentalEnabled
+
+	return c, matchedID
+}
+
+// findSettingsClient returns the persistent client found by clientID or, if
+// there is none, by clientIP.  matchedID is the identifier the client has been
+// found by.
+func findSettingsClient(
+	clientIP netip.Addr,
+	clientID string,
+) (c *client.Persistent, matchedID string, ok bool) {
+	if clientID != "" {
+		c, ok = Context.clients.storage.Find(clientID)
+		if ok {
+			return c, clientID, true
+		}
+	}
+
+	if !clientIP.IsValid() {
+		return nil, "", false
+	}
+
+	matchedID = clientIP.String()
+	c, ok = Context.clients.storage.Find(matchedID)
+	if !ok {
+		return nil, "", false
+	}
+
+	return c, matchedID, true
 }
 
 func startDNSServer() error {
@@ -477,6 +548,20 @@ func startDNSServer() error {
 		return fmt.Errorf("starting query log: %w", err)
 	}
 
+	if Context.safeSearchHealth != nil {
+		err = Context.safeSearchHealth.Start(ctx)
+		if err != nil {
+			return fmt.Errorf("starting safe search health checker: %w", err)
+		}
+	}
+
+	if Context.cluster != nil {
+		err = Context.cluster.Start(ctx)
+		if err != nil {
+			return fmt.Errorf("starting cluster checker: %w", err)
+		}
+	}
+
 	return nil
 }
 
@@ -502,6 +587,20 @@ func stopDNSServer() (err error) {
 		return nil
 	}
 
+	if Context.safeSearchHealth != nil {
+		err = Context.safeSearchHealth.Shutdown(context.TODO())
+		if err != nil {
+			return fmt.Errorf("stopping safe search health checker: %w", err)
+		}
+	}
+
+	if Context.cluster != nil {
+		err = Context.cluster.Shutdown(context.TODO())
+		if err != nil {
+			return fmt.Errorf("stopping cluster checker: %w", err)
+		}
+	}
+
 	err = Context.dnsServer.Stop()
 	if err != nil {
 		return fmt.Errorf("stopping forwarding dns server: %w", err)
@@ -592,3 +691,37 @@ func checkDir(path string) (err error) {
 
 	return nil
 }
+
+// dnsServerResolver is a [filtering.Resolver] that resolves hostnames using the
+// upstream servers of [Context.dnsServer].  It must not be used until
+// [Context.dnsServer] is initialized.
+type dnsServerResolver struct{}
+
+// type check
+var _ filtering.Resolver = dnsServerResolver{}
+
+// LookupIP implements the [filtering.Resolver] interface for
+// dnsServerResolver.
+func (dnsServerResolver) LookupIP(
+	ctx context.Context,
+	network string,
+	host string,
+) (ips []net.IP, err error) {
+	s := Context.dnsServer
+	if s == nil {
+		return nil, errors.Error("dns server is not initialized")
+	}
+
+	addrs, err := s.Resolve(ctx, network, host)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+
+	ips = make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		ips = append(ips, addr.AsSlice())
+	}
+
+	return ips, nil
+}
@@ -1,9 +1,12 @@
 package home
 
 import (
+	"net"
 	"net/netip"
+	"path/filepath"
 	"testing"
 
+	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
 	"github.com/AdguardTeam/AdGuardHome/internal/client"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
 	"github.com/AdguardTeam/AdGuardHome/internal/schedule"
@@ -23,6 +26,7 @@ func newStorage(tb testing.TB, clients []*client.Persistent) (s *client.Storage)
 	ctx := testutil.ContextWithTimeout(tb, testTimeout)
 	s, err := client.NewStorage(ctx, &client.StorageConfig{
 		Logger: slogutil.NewDiscardLogger(),
+		DHCP:   client.EmptyDHCP{},
 	})
 	require.NoError(tb, err)
 
@@ -204,3 +208,165 @@ func TestApplyAdditionalFiltering_blockedServices(t *testing.T) {
 		})
 	}
 }
+
+func TestApplyAdditionalFiltering_geoIP(t *testing.T) {
+	var err error
+
+	Context.filters, err = filtering.New(&filtering.Config{
+		BlockedServices: &filtering.BlockedServices{
+			Schedule: schedule.EmptyWeekly(),
+		},
+	}, nil)
+	require.NoError(t, err)
+
+	ctx := testutil.ContextWithTimeout(t, testTimeout)
+	dbPath := filepath.Join("..", "aghnet", "testdata", "GeoLite2-Country-Test.mmdb")
+
+	Context.clients.storage, err = client.NewStorage(ctx, &client.StorageConfig{
+		Logger: slogutil.NewDiscardLogger(),
+		DHCP:   client.EmptyDHCP{},
+		GeoIP:  aghnet.NewGeoIPTagger(dbPath),
+	})
+	require.NoError(t, err)
+
+	err = Context.clients.storage.Add(ctx, &client.Persistent{
+		Name:      "persistent",
+		UID:       client.MustNewUID(),
+		ClientIDs: []string{"persistent"},
+		Tags:      []string{"user_child"},
+	})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		ip       netip.Addr
+		name     string
+		id       string
+		wantTags []string
+	}{{
+		ip:       testIPv4,
+		name:     "runtime",
+		id:       "",
+		wantTags: []string{client.CountryTagPrefix + "AU"},
+	}, {
+		ip:       netip.MustParseAddr("198.51.100.1"),
+		name:     "runtime_unknown",
+		id:       "",
+		wantTags: nil,
+	}, {
+		ip:       testIPv4,
+		name:     "persistent",
+		id:       "persistent",
+		wantTags: []string{"user_child"},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			setts := &filtering.Settings{}
+
+			applyAdditionalFiltering(tc.ip, tc.id, setts)
+			assert.Equal(t, tc.wantTags, setts.ClientTags)
+		})
+	}
+}
+
+// testDHCP is a [client.DHCP] implementation for tests, which only knows the
+// MAC addresses of the leased IP addresses.
+type testDHCP struct {
+	client.EmptyDHCP
+
+	macs map[netip.Addr]net.HardwareAddr
+}
+
+// MACByIP implements the [client.DHCP] interface for *testDHCP.
+func (d *testDHCP) MACByIP(ip netip.Addr) (mac net.HardwareAddr) {
+	return d.macs[ip]
+}
+
+func TestApplyClientSettings_ignore(t *testing.T) {
+	var err error
+
+	Context.filters, err = filtering.New(&filtering.Config{
+		BlockedServices: &filtering.BlockedServices{
+			Schedule: schedule.EmptyWeekly(),
+		},
+	}, nil)
+	require.NoError(t, err)
+
+	var (
+		ipClientAddr  = netip.MustParseAddr("192.0.2.1")
+		macClientAddr = netip.MustParseAddr("192.0.2.2")
+		otherAddr     = netip.MustParseAddr("192.0.2.3")
+		macClientMAC  = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	)
+
+	ctx := testutil.ContextWithTimeout(t, testTimeout)
+	Context.clients.storage, err = client.NewStorage(ctx, &client.StorageConfig{
+		Logger: slogutil.NewDiscardLogger(),
+		DHCP: &testDHCP{
+			macs: map[netip.Addr]net.HardwareAddr{
+				macClientAddr: macClientMAC,
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	for _, p := range []*client.Persistent{{
+		Name:           "by_client_id",
+		ClientIDs:      []string{"ignored"},
+		IgnoreQueryLog: true,
+	}, {
+		Name:             "by_ip",
+		IPs:              []netip.Addr{ipClientAddr},
+		IgnoreStatistics: true,
+	}, {
+		Name:             "by_mac",
+		MACs:             []net.HardwareAddr{macClientMAC},
+		IgnoreQueryLog:   true,
+		IgnoreStatistics: true,
+	}} {
+		p.UID = client.MustNewUID()
+		require.NoError(t, Context.clients.storage.Add(ctx, p))
+	}
+
+	testCases := []struct {
+		ip              netip.Addr
+		name            string
+		id              string
+		wantIgnoreLog   bool
+		wantIgnoreStats bool
+	}{{
+		ip:              otherAddr,
+		name:            "client_id",
+		id:              "ignored",
+		wantIgnoreLog:   true,
+		wantIgnoreStats: false,
+	}, {
+		ip:              ipClientAddr,
+		name:            "ip",
+		id:              "",
+		wantIgnoreLog:   false,
+		wantIgnoreStats: true,
+	}, {
+		ip:              macClientAddr,
+		name:            "dhcp_mac",
+		id:              "",
+		wantIgnoreLog:   true,
+		wantIgnoreStats: true,
+	}, {
+		ip:              otherAddr,
+		name:            "unknown",
+		id:              "",
+		wantIgnoreLog:   false,
+		wantIgnoreStats: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			setts := &filtering.Settings{}
+
+			applyAdditionalFiltering(tc.ip, tc.id, setts)
+			assert.Equal(t, tc.wantIgnoreLog, setts.IgnoreQueryLog)
+			assert.Equal(t, tc.wantIgnoreStats, setts.IgnoreStatistics)
+		})
+	}
+}
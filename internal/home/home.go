@@ -2,7 +2,9 @@
 package home
 
 import (
+	"bytes"
 	"context"
+	"crypto/ed25519"
 	"crypto/x509"
 	"fmt"
 	"io/fs"
@@ -24,6 +26,8 @@ import (
 	"github.com/AdguardTeam/AdGuardHome/internal/aghos"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghtls"
 	"github.com/AdguardTeam/AdGuardHome/internal/arpdb"
+	"github.com/AdguardTeam/AdGuardHome/internal/cluster"
+	"github.com/AdguardTeam/AdGuardHome/internal/configmigrate"
 	"github.com/AdguardTeam/AdGuardHome/internal/dhcpd"
 	"github.com/AdguardTeam/AdGuardHome/internal/dnsforward"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
@@ -59,6 +63,22 @@ type homeContext struct {
 	web        *webAPI              // Web (HTTP, HTTPS) module
 	tls        *tlsManager          // TLS module
 
+	// blockPage serves the block page for the custom_ip blocking mode.  It's
+	// nil if the block page is disabled.
+	blockPage *blockPageServer
+
+	// safeSearchHealth checks the hosts enforced by safe search.  It's nil if
+	// the check is disabled.
+	safeSearchHealth *safesearch.HealthChecker
+
+	// cluster checks the drift of the other members of the cluster.  It's nil
+	// if there are no peers configured.
+	cluster *cluster.Checker
+
+	// notifications stores the notifications about the important system
+	// events.  It's never nil after the initialization.
+	notifications *NotificationStore
+
 	// etcHosts contains IP-hostname mappings taken from the OS-specific hosts
 	// configuration files, for example /etc/hosts.
 	etcHosts *aghnet.HostsContainer
@@ -84,6 +104,20 @@ type homeContext struct {
 	// firstRun, if true, tells AdGuard Home to only start the web interface
 	// service, and only serve the first-run APIs.
 	firstRun bool
+
+	// configMigrator is used to roll the configuration file back if it has
+	// been upgraded during the current startup.  It's nil if the file hasn't
+	// been upgraded or the rollback is disabled.
+	configMigrator *configmigrate.MigratorWithRollback
+
+	// startTime is the time of the startup.  The failures of the major
+	// subsystems cause the rollback of the configuration only within
+	// [rollbackTimeout] after it.
+	startTime time.Time
+
+	// configRolledBack is true if the configuration file has been rolled back
+	// to the pre-migration backup.  Such configuration isn't upgraded again.
+	configRolledBack bool
 }
 
 // getDataDir returns path to the directory where we store databases and filters
@@ -121,7 +155,10 @@ func Main(clientBuildFS fs.FS) {
 			switch sig {
 			case syscall.SIGHUP:
 				Context.clients.storage.ReloadARP(ctx)
+				Context.clients.reloadGeoIP()
+				reloadClients(ctx)
 				Context.tls.reload()
+				reloadDHCPFingerprints()
 			default:
 				cleanup(ctx)
 				cleanupAlways()
@@ -163,7 +200,7 @@ func setupContext(opts options) (err error) {
 		return nil
 	}
 
-	err = parseConfig()
+	err = parseConfig(!opts.noRollback)
 	if err != nil {
 		log.Error("parsing configuration file: %s", err)
 
@@ -279,6 +316,13 @@ func setupOpts(opts options) (err error) {
 
 // initContextClients initializes Context clients and related fields.
 func initContextClients(ctx context.Context, logger *slog.Logger) (err error) {
+	notificationsPath := filepath.Join(Context.getDataDir(), notificationsFilename)
+	Context.notifications, err = newNotificationStore(notificationsPath)
+	if err != nil {
+		// Don't fail, since the notifications aren't critical.
+		log.Error("initializing notifications: %s", err)
+	}
+
 	err = setupDNSFilteringConf(ctx, logger, config.Filtering)
 	if err != nil {
 		// Don't wrap the error, because it's informative enough as is.
@@ -289,7 +333,9 @@ func initContextClients(ctx context.Context, logger *slog.Logger) (err error) {
 	config.DHCP.WorkDir = Context.workDir
 	config.DHCP.DataDir = Context.getDataDir()
 	config.DHCP.HTTPRegister = httpRegister
+	config.DHCP.RequestUser = requestUserName
 	config.DHCP.ConfigModified = onConfigModified
+	config.DHCP.OnPoolExhausted = onDHCPPoolExhausted
 
 	Context.dhcpServer, err = dhcpd.Create(config.DHCP)
 	if Context.dhcpServer == nil || err != nil {
@@ -316,6 +362,40 @@ func initContextClients(ctx context.Context, logger *slog.Logger) (err error) {
 	)
 }
 
+// reloadClients reloads the persistent clients from the configuration file
+// keeping the runtime ones.  Errors are logged and the current persistent
+// clients are kept in that case.
+func reloadClients(ctx context.Context) {
+	objs, err := readPersistentClients()
+	if err != nil {
+		log.Error("reloading clients: %s", err)
+
+		return
+	}
+
+	err = Context.clients.reload(ctx, objs)
+	if err != nil {
+		log.Error("%s", err)
+
+		return
+	}
+
+	log.Info("reloaded %d persistent clients", len(objs))
+}
+
+// reloadDHCPFingerprints reloads the database of the DHCP fingerprints, if the
+// DHCP server is available.  Errors are logged.
+func reloadDHCPFingerprints() {
+	if Context.dhcpServer == nil {
+		return
+	}
+
+	err := Context.dhcpServer.ReloadFingerprints()
+	if err != nil {
+		log.Error("%s", err)
+	}
+}
+
 // setupBindOpts overrides bind host/port from the opts.
 func setupBindOpts(opts options) (err error) {
 	bindAddr := opts.bindAddr
@@ -382,6 +462,7 @@ func setupDNSFilteringConf(
 
 	conf.ConfigModified = onConfigModified
 	conf.HTTPRegister = httpRegister
+	conf.OnUpdateFailure = onFilterUpdateFailure
 	conf.DataDir = Context.getDataDir()
 	conf.Filters = slices.Clone(config.Filters)
 	conf.WhitelistFilters = slices.Clone(config.WhitelistFilters)
@@ -453,11 +534,22 @@ func setupDNSFilteringConf(
 	}
 
 	logger := baseLogger.With(slogutil.KeyPrefix, safesearch.LogPrefix)
+	if ivl := time.Duration(conf.SafeSearchCheckIvl); ivl > 0 {
+		Context.safeSearchHealth = safesearch.NewHealthChecker(&safesearch.HealthCheckerConfig{
+			Logger:     logger,
+			Resolver:   dnsServerResolver{},
+			Interval:   ivl,
+			FailClosed: conf.SafeSearchFailClosed,
+		})
+		conf.SafeSearchHealth = Context.safeSearchHealth
+	}
+
 	conf.SafeSearch, err = safesearch.NewDefault(ctx, &safesearch.DefaultConfig{
 		Logger:         logger,
 		ServicesConfig: conf.SafeSearchConf,
 		CacheSize:      conf.SafeSearchCacheSize,
 		CacheTTL:       cacheTime,
+		Health:         conf.SafeSearchHealth,
 	})
 	if err != nil {
 		return fmt.Errorf("initializing safesearch: %w", err)
@@ -560,6 +652,9 @@ func initWeb(
 		ReadHeaderTimeout: readHdrTimeout,
 		WriteTimeout:      writeTimeout,
 
+		RateLimitWindow:      time.Duration(config.HTTPConfig.WindowSeconds) * time.Second,
+		RateLimitMaxRequests: config.HTTPConfig.MaxRequests,
+
 		firstRun:         Context.firstRun,
 		disableUpdate:    disableUpdate,
 		runningAsService: opts.runningAsService,
@@ -584,8 +679,26 @@ func fatalOnError(err error) {
 //
 // TODO(e.burkov):  Make opts a pointer.
 func run(opts options, clientBuildFS fs.FS, done chan struct{}) {
+	Context.startTime = time.Now()
+
+	// TODO(s.chzhen):  Use it for the entire initialization process.
+	ctx := context.Background()
+
+	err := runWithRollback(ctx, func(ctx context.Context) (err error) {
+		return initAndStart(ctx, opts, clientBuildFS)
+	})
+	fatalOnError(err)
+
+	// Wait for other goroutines to complete their job.
+	<-done
+}
+
+// initAndStart configures and starts AdGuard Home.  It returns the errors of
+// the initialization of the major subsystems, which may be fixed by rolling the
+// configuration file back, see [runWithRollback].  It exits on other errors.
+func initAndStart(ctx context.Context, opts options, clientBuildFS fs.FS) (err error) {
 	// Configure working dir.
-	err := initWorkingDir(opts)
+	err = initWorkingDir(opts)
 	fatalOnError(err)
 
 	// Configure config filename.
@@ -618,11 +731,18 @@ func run(opts options, clientBuildFS fs.FS, done chan struct{}) {
 	// data first, but also to avoid relying on automatic Go init() function.
 	filtering.InitModule()
 
-	// TODO(s.chzhen):  Use it for the entire initialization process.
-	ctx := context.Background()
+	err = filtering.LoadBlockedServices(Context.getDataDir())
+	if err != nil {
+		log.Error("loading blocked services: %s; using embedded index", err)
+	}
 
 	err = initContextClients(ctx, slogLogger)
-	fatalOnError(err)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	cmdlineImportDnsmasq(ctx, slogLogger, opts)
 
 	err = setupOpts(opts)
 	fatalOnError(err)
@@ -655,7 +775,7 @@ func run(opts options, clientBuildFS fs.FS, done chan struct{}) {
 	GLMode = opts.glinetMode
 
 	// Init auth module.
-	Context.auth, err = initUsers()
+	Context.auth, err = initUsers(slogLogger)
 	fatalOnError(err)
 
 	Context.tls, err = newTLSManager(config.TLS, config.DNS.ServePlainDNS)
@@ -672,6 +792,12 @@ func run(opts options, clientBuildFS fs.FS, done chan struct{}) {
 
 	if !Context.firstRun {
 		err = initDNS(slogLogger, statsDir, querylogDir)
+		if err != nil {
+			// Don't wrap the error since it's informative enough as is.
+			return err
+		}
+
+		err = initCluster(slogLogger)
 		fatalOnError(err)
 
 		Context.tls.start()
@@ -690,6 +816,8 @@ func run(opts options, clientBuildFS fs.FS, done chan struct{}) {
 				log.Error("starting dhcp server: %s", err)
 			}
 		}
+
+		startBlockPage(ctx, slogLogger)
 	}
 
 	if !opts.noPermCheck {
@@ -698,8 +826,37 @@ func run(opts options, clientBuildFS fs.FS, done chan struct{}) {
 
 	Context.web.start(ctx)
 
-	// Wait for other goroutines to complete their job.
-	<-done
+	return nil
+}
+
+// cmdlineImportDnsmasq imports the static DHCP leases from the dnsmasq
+// configuration file, if requested in opts, and exits.
+func cmdlineImportDnsmasq(ctx context.Context, l *slog.Logger, opts options) {
+	path := opts.importDnsmasqLeases
+	if path == "" {
+		return
+	}
+
+	l.InfoContext(ctx, "importing dnsmasq static leases via cli", "path", path)
+
+	// #nosec G304 -- Trust the path explicitly given by the user.
+	data, err := os.ReadFile(path)
+	fatalOnError(errors.Annotate(err, "reading dnsmasq config: %w"))
+
+	added, lineErrs, err := Context.dhcpServer.ImportDnsmasqHosts(bytes.NewReader(data))
+	fatalOnError(err)
+
+	for _, e := range lineErrs {
+		l.ErrorContext(ctx, "importing dnsmasq host", "path", path, slogutil.KeyError, e)
+	}
+
+	l.InfoContext(ctx, "imported dnsmasq static leases", "added", added, "failed", len(lineErrs))
+
+	if len(lineErrs) > 0 {
+		os.Exit(osutil.ExitCodeFailure)
+	}
+
+	os.Exit(osutil.ExitCodeSuccess)
 }
 
 // newUpdater creates a new AdGuard Home updater.  customURL is true if the user
@@ -738,6 +895,12 @@ func newUpdater(
 
 	l.DebugContext(ctx, "creating updater", "config_path", confPath)
 
+	signingKey, skipVerification, err := updateSigningKey(ctx, l, config, version.SigningKey())
+	if err != nil {
+		// Don't exit, since the updates are refused without a key anyway.
+		l.ErrorContext(ctx, "updates are disabled", slogutil.KeyError, err)
+	}
+
 	return updater.NewUpdater(&updater.Config{
 		Client:          config.Filtering.HTTPClient,
 		Version:         version.Version(),
@@ -750,9 +913,56 @@ func newUpdater(
 		ConfName:        confPath,
 		ExecPath:        execPath,
 		VersionCheckURL: versionURL,
+		SigningKey:      signingKey,
+
+		SkipSignatureVerification: skipVerification,
 	}), customURL
 }
 
+// errNoUpdateSigningKey is returned by [updateSigningKey] when there is no key
+// to verify the update packages with.
+const errNoUpdateSigningKey errors.Error = "no update signing key; " +
+	"set update_signing_key or unsafe_skip_update_verification"
+
+// updateSigningKey returns the key used to verify the signatures of the update
+// packages.  embedded is the key built into the binary.  skip is true only if
+// the verification is disabled in the configuration.  If there is no key or it
+// is invalid, err is not nil and the updates must be refused.
+func updateSigningKey(
+	ctx context.Context,
+	l *slog.Logger,
+	config *configuration,
+	embedded string,
+) (key ed25519.PublicKey, skip bool, err error) {
+	if config.UnsafeSkipUpdateVerification {
+		l.WarnContext(
+			ctx,
+			"WARNING: update signature verification is disabled; "+
+				"updates aren't authenticated and may be tampered with",
+		)
+
+		return nil, true, nil
+	}
+
+	keyStr := embedded
+	if config.UpdateSigningKey != "" {
+		l.InfoContext(ctx, "using custom update signing key")
+
+		keyStr = config.UpdateSigningKey
+	}
+
+	if keyStr == "" {
+		return nil, false, errNoUpdateSigningKey
+	}
+
+	key, err = updater.ParseSigningKey(keyStr)
+	if err != nil {
+		return nil, false, fmt.Errorf("parsing update signing key: %w", err)
+	}
+
+	return key, false, nil
+}
+
 // checkPermissions checks and migrates permissions of the files and directories
 // used by AdGuard Home, if needed.
 func checkPermissions(
@@ -773,14 +983,19 @@ func checkPermissions(
 	permcheck.Check(ctx, l, workDir, dataDir, statsDir, querylogDir, confPath)
 }
 
-// initUsers initializes context auth module.  Clears config users field.
-func initUsers() (auth *Auth, err error) {
+// initUsers initializes context auth module.  Clears config users and API
+// tokens fields.
+// baseLogger must not be nil.
+func initUsers(baseLogger *slog.Logger) (auth *Auth, err error) {
 	sessFilename := filepath.Join(Context.getDataDir(), "sessions.db")
 
 	var rateLimiter *authRateLimiter
-	if config.AuthAttempts > 0 && config.AuthBlockMin > 0 {
-		blockDur := time.Duration(config.AuthBlockMin) * time.Minute
-		rateLimiter = newAuthRateLimiter(blockDur, config.AuthAttempts)
+	if authConf := config.Auth; authConf.AttemptsLimit > 0 {
+		rateLimiter = newAuthRateLimiter(
+			baseLogger.With(slogutil.KeyPrefix, "auth"),
+			time.Duration(authConf.BlockDuration),
+			authConf.AttemptsLimit,
+		)
 	} else {
 		log.Info("authratelimiter is disabled")
 	}
@@ -788,25 +1003,24 @@ func initUsers() (auth *Auth, err error) {
 	trustedProxies := netutil.SliceSubnetSet(netutil.UnembedPrefixes(config.DNS.TrustedProxies))
 
 	sessionTTL := time.Duration(config.HTTPConfig.SessionTTL).Seconds()
-	auth = InitAuth(sessFilename, config.Users, uint32(sessionTTL), rateLimiter, trustedProxies)
+	auth = InitAuth(
+		sessFilename,
+		config.Users,
+		config.APITokens,
+		uint32(sessionTTL),
+		rateLimiter,
+		trustedProxies,
+	)
 	if auth == nil {
 		return nil, errors.Error("initializing auth module failed")
 	}
 
 	config.Users = nil
+	config.APITokens = nil
 
 	return auth, nil
 }
 
-func (c *configuration) anonymizer() (ipmut *aghnet.IPMut) {
-	var anonFunc aghnet.IPMutFunc
-	if c.DNS.AnonymizeClientIP {
-		anonFunc = querylog.AnonymizeIP
-	}
-
-	return aghnet.NewIPMut(anonFunc)
-}
-
 // startMods initializes and starts the DNS server after installation.
 // baseLogger must not be nil.
 func startMods(baseLogger *slog.Logger) (err error) {
@@ -933,6 +1147,15 @@ func cleanup(ctx context.Context) {
 		Context.auth = nil
 	}
 
+	if Context.blockPage != nil {
+		err := Context.blockPage.shutdown(ctx)
+		if err != nil {
+			log.Error("stopping block page server: %s", err)
+		}
+
+		Context.blockPage = nil
+	}
+
 	err := stopDNSServer()
 	if err != nil {
 		log.Error("stopping dns server: %s", err)
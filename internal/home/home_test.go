@@ -1,12 +1,90 @@
 package home
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
 	"testing"
 
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
 	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestMain(m *testing.M) {
 	initCmdLineOpts()
 	testutil.DiscardLogOutput(m)
 }
+
+func TestUpdateSigningKey(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	embeddedPub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	embedded := base64.StdEncoding.EncodeToString(embeddedPub)
+
+	testCases := []struct {
+		conf       *configuration
+		wantKey    ed25519.PublicKey
+		name       string
+		embedded   string
+		wantErrMsg string
+		wantSkip   bool
+	}{{
+		conf:       &configuration{},
+		wantKey:    nil,
+		name:       "no_key",
+		embedded:   "",
+		wantErrMsg: string(errNoUpdateSigningKey),
+		wantSkip:   false,
+	}, {
+		conf:       &configuration{},
+		wantKey:    embeddedPub,
+		name:       "embedded_key",
+		embedded:   embedded,
+		wantErrMsg: "",
+		wantSkip:   false,
+	}, {
+		conf: &configuration{
+			UpdateSigningKey: base64.StdEncoding.EncodeToString(pub),
+		},
+		wantKey:    pub,
+		name:       "custom_key",
+		embedded:   embedded,
+		wantErrMsg: "",
+		wantSkip:   false,
+	}, {
+		conf: &configuration{
+			UpdateSigningKey: "!!!",
+		},
+		wantKey:  nil,
+		name:     "bad_key",
+		embedded: embedded,
+		wantErrMsg: "parsing update signing key: decoding signing key: " +
+			"illegal base64 data at input byte 0",
+		wantSkip: false,
+	}, {
+		conf: &configuration{
+			UnsafeSkipUpdateVerification: true,
+		},
+		wantKey:    nil,
+		name:       "disabled",
+		embedded:   "",
+		wantErrMsg: "",
+		wantSkip:   true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := testutil.ContextWithTimeout(t, testTimeout)
+			l := slogutil.NewDiscardLogger()
+			key, skip, keyErr := updateSigningKey(ctx, l, tc.conf, tc.embedded)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, keyErr)
+			assert.Equal(t, tc.wantKey, key)
+			assert.Equal(t, tc.wantSkip, skip)
+		})
+	}
+}
@@ -3,7 +3,10 @@ package home
 import (
 	"cmp"
 	"fmt"
+	"io"
+	stdlog "log"
 	"log/slog"
+	"os"
 	"path/filepath"
 	"runtime"
 
@@ -30,14 +33,29 @@ func newSlogLogger(ls *logSettings) (l *slog.Logger) {
 	}
 
 	return slogutil.New(&slogutil.Config{
+		Output:       io.MultiWriter(os.Stdout, errorLogs),
 		Format:       slogutil.FormatAdGuardLegacy,
 		Level:        lvl,
 		AddTimestamp: true,
 	})
 }
 
-// configureLogger configures logger level and output.
+// configureLogger configures logger level and output.  The error-level lines
+// are additionally written to [errorLogs].
 func configureLogger(ls *logSettings) (err error) {
+	err = setLogOutput(ls)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	stdlog.SetOutput(io.MultiWriter(stdlog.Writer(), errorLogs))
+
+	return nil
+}
+
+// setLogOutput configures logger level and output.
+func setLogOutput(ls *logSettings) (err error) {
 	// Configure logger level.
 	if !ls.Enabled {
 		log.SetLevel(log.OFF)
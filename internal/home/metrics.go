@@ -0,0 +1,203 @@
+package home
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/dhcpd"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/AdGuardHome/internal/stats"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// Names of the exposed metrics.  Keep them stable, since they are used in the
+// users' dashboards and alerts.
+const (
+	// metricQueries is the counter of the DNS queries counted in the
+	// statistics.
+	metricQueries = "adguardhome_dns_queries_total"
+
+	// metricBlocked is the counter of the DNS queries blocked by the filtering
+	// rules, safe browsing, or parental control.
+	metricBlocked = "adguardhome_dns_blocked_queries_total"
+
+	// metricCacheHits is the counter of the DNS queries answered from the
+	// cache.
+	metricCacheHits = "adguardhome_dns_cache_hits_total"
+
+	// metricCacheMisses is the counter of the DNS queries sent upstream
+	// because of a cache miss.
+	metricCacheMisses = "adguardhome_dns_cache_misses_total"
+
+	// metricCacheHitRatio is the gauge of the ratio of the cache hits to all
+	// the queries that could be answered from the cache.
+	metricCacheHitRatio = "adguardhome_dns_cache_hit_ratio"
+
+	// metricUpstreamLatency is the histogram of the durations of the
+	// successful queries to the upstream servers in seconds with the
+	// "upstream" label containing the address of the upstream.
+	metricUpstreamLatency = "adguardhome_dns_upstream_latency_seconds"
+
+	// metricDHCPLeases is the gauge of the number of the DHCP leases,
+	// including the static ones.
+	metricDHCPLeases = "adguardhome_dhcp_leases"
+
+	// metricFilterRules is the gauge of the number of rules in an enabled
+	// filter list with the "id", "name", and "type" labels.  The "type" label
+	// is either "block" or "allow".
+	metricFilterRules = "adguardhome_filter_list_rules"
+)
+
+// hdrValPrometheusText is the value of the Content-Type header for the
+// Prometheus text exposition format.
+const hdrValPrometheusText = "text/plain; version=0.0.4; charset=utf-8"
+
+// metricsSources are the sources of the exposed metrics.  Any of them may be
+// nil, in which case the corresponding metrics are omitted.
+type metricsSources struct {
+	stats   stats.Interface
+	dhcp    dhcpd.Interface
+	filters *filtering.DNSFilter
+}
+
+// handleMetrics is the handler for the GET /metrics HTTP API.  It exposes the
+// metrics in the Prometheus text exposition format.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	src := &metricsSources{
+		stats:   Context.stats,
+		dhcp:    Context.dhcpServer,
+		filters: Context.filters,
+	}
+
+	src.serveHTTP(w, r)
+}
+
+// serveHTTP writes the metrics from src to w.
+func (src *metricsSources) serveHTTP(w http.ResponseWriter, _ *http.Request) {
+	buf := &bytes.Buffer{}
+	src.write(buf)
+
+	w.Header().Set(httphdr.ContentType, hdrValPrometheusText)
+	_, err := w.Write(buf.Bytes())
+	if err != nil {
+		log.Debug("metrics: writing response: %s", err)
+	}
+}
+
+// write writes the metrics from src to w.
+func (src *metricsSources) write(w io.Writer) {
+	if src.stats != nil {
+		writeStatsMetrics(w, src.stats.Metrics())
+	}
+
+	if src.dhcp != nil {
+		writeMetricHeader(w, metricDHCPLeases, "gauge", "The number of DHCP leases.")
+		writeMetric(w, metricDHCPLeases, "", len(src.dhcp.Leases()))
+	}
+
+	if src.filters != nil {
+		writeMetricHeader(
+			w,
+			metricFilterRules,
+			"gauge",
+			"The number of rules in an enabled filter list.",
+		)
+		for _, c := range src.filters.RulesCounts() {
+			typ := "block"
+			if c.Allow {
+				typ = "allow"
+			}
+
+			labels := fmt.Sprintf(
+				`id="%d",name="%s",type="%s"`,
+				c.ID,
+				escapeLabelValue(c.Name),
+				typ,
+			)
+			writeMetric(w, metricFilterRules, labels, c.Count)
+		}
+	}
+}
+
+// writeStatsMetrics writes the DNS metrics from m to w.
+func writeStatsMetrics(w io.Writer, m *stats.Metrics) {
+	writeMetricHeader(w, metricQueries, "counter", "The total number of DNS queries.")
+	writeMetric(w, metricQueries, "", m.Queries)
+
+	writeMetricHeader(w, metricBlocked, "counter", "The number of blocked DNS queries.")
+	writeMetric(w, metricBlocked, "", m.Blocked)
+
+	writeMetricHeader(w, metricCacheHits, "counter", "The number of DNS cache hits.")
+	writeMetric(w, metricCacheHits, "", m.CacheHits)
+
+	writeMetricHeader(w, metricCacheMisses, "counter", "The number of DNS cache misses.")
+	writeMetric(w, metricCacheMisses, "", m.CacheMisses)
+
+	ratio := 0.0
+	if total := m.CacheHits + m.CacheMisses; total > 0 {
+		ratio = float64(m.CacheHits) / float64(total)
+	}
+
+	writeMetricHeader(w, metricCacheHitRatio, "gauge", "The ratio of DNS cache hits.")
+	writeMetric(w, metricCacheHitRatio, "", ratio)
+
+	writeMetricHeader(
+		w,
+		metricUpstreamLatency,
+		"histogram",
+		"The latency of the successful queries to the upstream servers.",
+	)
+
+	addrs := make([]string, 0, len(m.Upstreams))
+	for addr := range m.Upstreams {
+		addrs = append(addrs, addr)
+	}
+	slices.Sort(addrs)
+
+	for _, addr := range addrs {
+		writeHistogram(w, metricUpstreamLatency, addr, m.Upstreams[addr])
+	}
+}
+
+// writeHistogram writes the latency histogram h for upstream addr to w.
+func writeHistogram(w io.Writer, name, addr string, h *stats.Histogram) {
+	upsLabel := fmt.Sprintf(`upstream="%s"`, escapeLabelValue(addr))
+	for i, b := range stats.LatencyBounds {
+		le := strconv.FormatFloat(b.Seconds(), 'g', -1, 64)
+		writeMetric(w, name+"_bucket", upsLabel+`,le="`+le+`"`, h.Buckets[i])
+	}
+
+	writeMetric(w, name+"_bucket", upsLabel+`,le="+Inf"`, h.Count)
+	writeMetric(w, name+"_sum", upsLabel, h.Sum.Seconds())
+	writeMetric(w, name+"_count", upsLabel, h.Count)
+}
+
+// writeMetricHeader writes the HELP and TYPE lines of the metric to w.
+func writeMetricHeader(w io.Writer, name, typ, help string) {
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// writeMetric writes a single sample of the metric with labels to w.  labels
+// may be empty.
+func writeMetric[T int | uint64 | float64](w io.Writer, name, labels string, val T) {
+	if labels != "" {
+		name += "{" + labels + "}"
+	}
+
+	_, _ = fmt.Fprintf(w, "%s %v\n", name, val)
+}
+
+// labelValueReplacer escapes the label values according to the Prometheus text
+// exposition format.
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabelValue returns v escaped for using as a label value.
+func escapeLabelValue(v string) (escaped string) {
+	return labelValueReplacer.Replace(v)
+}
@@ -0,0 +1,95 @@
+package home
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/stats"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testStats is a [stats.Interface] implementation for tests.
+type testStats struct {
+	// Interface is embedded here simply to make testStats a [stats.Interface]
+	// without actually implementing all methods.
+	stats.Interface
+
+	onMetrics func() (m *stats.Metrics)
+}
+
+// Metrics implements the [stats.Interface] interface for *testStats.
+func (s *testStats) Metrics() (m *stats.Metrics) {
+	return s.onMetrics()
+}
+
+func TestMetricsSources_serveHTTP(t *testing.T) {
+	const upsAddr = "udp://192.0.2.1:53"
+
+	h := &stats.Histogram{
+		Buckets: make([]uint64, len(stats.LatencyBounds)),
+		Count:   2,
+		Sum:     300 * time.Millisecond,
+	}
+	for i, b := range stats.LatencyBounds {
+		switch {
+		case b >= 250*time.Millisecond:
+			h.Buckets[i] = 2
+		case b >= 50*time.Millisecond:
+			h.Buckets[i] = 1
+		}
+	}
+
+	src := &metricsSources{
+		stats: &testStats{
+			onMetrics: func() (m *stats.Metrics) {
+				return &stats.Metrics{
+					Upstreams: map[string]*stats.Histogram{
+						upsAddr: h,
+					},
+					Queries:     10,
+					Blocked:     3,
+					CacheHits:   1,
+					CacheMisses: 3,
+				}
+			},
+		},
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(src.serveHTTP))
+	t.Cleanup(srv.Close)
+
+	resp, err := srv.Client().Get(srv.URL)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = resp.Body.Close() })
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, hdrValPrometheusText, resp.Header.Get(httphdr.ContentType))
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	lines := strings.Split(string(body), "\n")
+
+	for _, want := range []string{
+		"# TYPE adguardhome_dns_queries_total counter",
+		"adguardhome_dns_queries_total 10",
+		"adguardhome_dns_blocked_queries_total 3",
+		"adguardhome_dns_cache_hits_total 1",
+		"adguardhome_dns_cache_misses_total 3",
+		"adguardhome_dns_cache_hit_ratio 0.25",
+		"# TYPE adguardhome_dns_upstream_latency_seconds histogram",
+		`adguardhome_dns_upstream_latency_seconds_bucket{upstream="` + upsAddr + `",le="0.01"} 0`,
+		`adguardhome_dns_upstream_latency_seconds_bucket{upstream="` + upsAddr + `",le="0.05"} 1`,
+		`adguardhome_dns_upstream_latency_seconds_bucket{upstream="` + upsAddr + `",le="+Inf"} 2`,
+		`adguardhome_dns_upstream_latency_seconds_sum{upstream="` + upsAddr + `"} 0.3`,
+		`adguardhome_dns_upstream_latency_seconds_count{upstream="` + upsAddr + `"} 2`,
+	} {
+		assert.Contains(t, lines, want)
+	}
+}
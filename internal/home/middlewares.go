@@ -46,7 +46,10 @@ func expectsLargerRequests(r *http.Request) (ok bool) {
 	}
 
 	switch r.URL.Path {
-	case "/control/access/set", "/control/filtering/set_rules":
+	case
+		"/control/access/set",
+		"/control/filtering/set_rules",
+		"/control/rewrite/import":
 		return true
 	default:
 		return false
@@ -0,0 +1,301 @@
+package home
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/AdGuardHome/internal/aghos"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/google/renameio/v2/maybe"
+)
+
+// notificationsFilename is the name of the file within the data directory
+// containing the notifications.
+const notificationsFilename = "notifications.json"
+
+// maxNotifications is the maximum number of the notifications kept in a
+// [NotificationStore].  The oldest ones are removed first.
+const maxNotifications = 100
+
+// NotificationType is the type of the event a [Notification] is about.
+type NotificationType string
+
+// NotificationType values.
+const (
+	// NotificationTypeCertExpiry means that the TLS certificate expires soon
+	// or has already expired.
+	NotificationTypeCertExpiry NotificationType = "cert_expiry"
+
+	// NotificationTypeFilterUpdate means that some filter lists couldn't be
+	// updated.
+	NotificationTypeFilterUpdate NotificationType = "filter_update_failure"
+
+	// NotificationTypeDHCPPoolExhausted means that the DHCPv4 server has no
+	// free addresses left in its range.
+	NotificationTypeDHCPPoolExhausted NotificationType = "dhcp_pool_exhausted"
+)
+
+// NotificationSeverity is the severity of a [Notification].
+type NotificationSeverity string
+
+// NotificationSeverity values.
+const (
+	NotificationSeverityWarning NotificationSeverity = "warning"
+	NotificationSeverityError   NotificationSeverity = "error"
+)
+
+// Notification is a notification about an important system event.
+type Notification struct {
+	// Timestamp is the time of the event.
+	Timestamp time.Time `json:"timestamp"`
+
+	// Type is the type of the event.
+	Type NotificationType `json:"type"`
+
+	// Severity is the severity of the event.
+	Severity NotificationSeverity `json:"severity"`
+
+	// Message is the human-readable description of the event.
+	Message string `json:"message"`
+
+	// ID is the unique identifier of the notification.
+	ID uint64 `json:"id"`
+
+	// Dismissed is true if the notification has been dismissed by the user.
+	Dismissed bool `json:"dismissed"`
+}
+
+// NotificationStore keeps the latest [maxNotifications] notifications in a
+// ring buffer and persists them to a file.  It's safe for concurrent use.
+type NotificationStore struct {
+	// mu protects buf, head, and nextID.
+	mu *sync.Mutex
+
+	// buf is the ring buffer of notifications.  When it's full, head is the
+	// index of the oldest notification.
+	buf []*Notification
+
+	// now returns the current time.  It's replaced in tests.
+	now func() (t time.Time)
+
+	// path is the path to the file the notifications are persisted to.
+	path string
+
+	// head is the index of the oldest notification in buf.
+	head int
+
+	// nextID is the identifier of the next notification.
+	nextID uint64
+}
+
+// newNotificationStore returns a new properly initialized *NotificationStore
+// with the notifications loaded from the file at path.  s is never nil, so that
+// the notifications still work even if the file can't be read.
+func newNotificationStore(path string) (s *NotificationStore, err error) {
+	s = &NotificationStore{
+		mu:     &sync.Mutex{},
+		buf:    make([]*Notification, 0, maxNotifications),
+		now:    time.Now,
+		path:   path,
+		nextID: 1,
+	}
+
+	// #nosec G304 -- Trust the path, since it's constructed from the data
+	// directory.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return s, nil
+		}
+
+		return s, fmt.Errorf("reading notifications: %w", err)
+	}
+
+	var ns []*Notification
+	err = json.Unmarshal(data, &ns)
+	if err != nil {
+		return s, fmt.Errorf("decoding notifications: %w", err)
+	}
+
+	for _, n := range ns {
+		s.push(n)
+		s.nextID = max(s.nextID, n.ID+1)
+	}
+
+	return s, nil
+}
+
+// push adds n to the ring buffer, replacing the oldest notification if it's
+// full.  s.mu is expected to be locked.
+func (s *NotificationStore) push(n *Notification) {
+	if len(s.buf) < maxNotifications {
+		s.buf = append(s.buf, n)
+
+		return
+	}
+
+	s.buf[s.head] = n
+	s.head = (s.head + 1) % maxNotifications
+}
+
+// ordered returns the notifications from the oldest to the newest.  s.mu is
+// expected to be locked.
+func (s *NotificationStore) ordered() (ns []*Notification) {
+	ns = make([]*Notification, 0, len(s.buf))
+	ns = append(ns, s.buf[s.head:]...)
+
+	return append(ns, s.buf[:s.head]...)
+}
+
+// post adds a new notification.  If there is already an undismissed
+// notification of the same type with the same message, post does nothing, so
+// that the recurring events don't push the other notifications out.
+func (s *NotificationStore) post(typ NotificationType, sev NotificationSeverity, msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dup := slices.ContainsFunc(s.buf, func(n *Notification) (ok bool) {
+		return !n.Dismissed && n.Type == typ && n.Message == msg
+	})
+	if dup {
+		return
+	}
+
+	s.push(&Notification{
+		Timestamp: s.now(),
+		Type:      typ,
+		Severity:  sev,
+		Message:   msg,
+		ID:        s.nextID,
+	})
+	s.nextID++
+
+	log.Info("notifications: %s: %s", typ, msg)
+
+	s.store()
+}
+
+// undismissed returns the copies of the undismissed notifications from the
+// newest to the oldest.
+func (s *NotificationStore) undismissed() (ns []*Notification) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ns = []*Notification{}
+	for _, n := range slices.Backward(s.ordered()) {
+		if !n.Dismissed {
+			nc := *n
+			ns = append(ns, &nc)
+		}
+	}
+
+	return ns
+}
+
+// dismiss marks the notification with the given id as dismissed.  If all is
+// true, it marks all notifications as dismissed and id is ignored.  ok is false
+// if there is no notification with such id.
+func (s *NotificationStore) dismiss(id uint64, all bool) (ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, n := range s.buf {
+		if all || n.ID == id {
+			n.Dismissed = true
+			ok = true
+		}
+	}
+
+	if ok {
+		s.store()
+	}
+
+	return ok || all
+}
+
+// store writes the notifications to the file.  s.mu is expected to be locked.
+// The errors are logged, since the notifications are still available until the
+// restart.
+func (s *NotificationStore) store() {
+	data, err := json.Marshal(s.ordered())
+	if err != nil {
+		log.Error("notifications: encoding: %s", err)
+
+		return
+	}
+
+	err = maybe.WriteFile(s.path, data, aghos.DefaultPermFile)
+	if err != nil {
+		log.Error("notifications: writing: %s", err)
+	}
+}
+
+// notificationsJSON is the response for the GET /control/notifications HTTP
+// API.
+type notificationsJSON struct {
+	Notifications []*Notification `json:"notifications"`
+}
+
+// handleNotifications is the handler for the GET /control/notifications HTTP
+// API.  It returns the undismissed notifications from the newest to the
+// oldest.
+func (s *NotificationStore) handleNotifications(w http.ResponseWriter, r *http.Request) {
+	aghhttp.WriteJSONResponseOK(w, r, &notificationsJSON{
+		Notifications: s.undismissed(),
+	})
+}
+
+// notificationDismissJSON is the request for the POST
+// /control/notifications/dismiss HTTP API.
+type notificationDismissJSON struct {
+	// ID is the identifier of the notification to dismiss.  It's ignored if
+	// All is true.
+	ID uint64 `json:"id"`
+
+	// All, if true, means that all notifications must be dismissed.
+	All bool `json:"all"`
+}
+
+// handleNotificationsDismiss is the handler for the POST
+// /control/notifications/dismiss HTTP API.
+func (s *NotificationStore) handleNotificationsDismiss(w http.ResponseWriter, r *http.Request) {
+	req := &notificationDismissJSON{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusBadRequest, "json decode: %s", err)
+
+		return
+	}
+
+	if !s.dismiss(req.ID, req.All) {
+		aghhttp.Error(r, w, http.StatusNotFound, "notification %d not found", req.ID)
+
+		return
+	}
+
+	aghhttp.OK(w)
+}
+
+// onFilterUpdateFailure posts a notification about the filter lists that
+// couldn't be updated.
+func onFilterUpdateFailure(err error) {
+	msg := fmt.Sprintf("updating filter lists: %s", err)
+	Context.notifications.post(NotificationTypeFilterUpdate, NotificationSeverityError, msg)
+}
+
+// onDHCPPoolExhausted posts a notification about the exhausted range of the
+// DHCPv4 server.
+func onDHCPPoolExhausted() {
+	Context.notifications.post(
+		NotificationTypeDHCPPoolExhausted,
+		NotificationSeverityWarning,
+		"dhcpv4 server has no free addresses left in its range",
+	)
+}
@@ -0,0 +1,89 @@
+package home
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotificationStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), notificationsFilename)
+
+	s, err := newNotificationStore(path)
+	require.NoError(t, err)
+
+	now := time.Unix(0, 0).UTC()
+	s.now = func() (t time.Time) { return now }
+
+	s.post(NotificationTypeCertExpiry, NotificationSeverityWarning, "expires soon")
+	s.post(NotificationTypeCertExpiry, NotificationSeverityWarning, "expires soon")
+	s.post(NotificationTypeFilterUpdate, NotificationSeverityError, "failed")
+
+	ns := s.undismissed()
+	require.Len(t, ns, 2)
+
+	assert.Equal(t, &Notification{
+		Timestamp: now,
+		Type:      NotificationTypeFilterUpdate,
+		Severity:  NotificationSeverityError,
+		Message:   "failed",
+		ID:        2,
+	}, ns[0])
+	assert.Equal(t, uint64(1), ns[1].ID)
+
+	t.Run("dismiss", func(t *testing.T) {
+		assert.False(t, s.dismiss(100, false))
+		require.True(t, s.dismiss(1, false))
+
+		ns = s.undismissed()
+		require.Len(t, ns, 1)
+
+		assert.Equal(t, uint64(2), ns[0].ID)
+
+		// The dismissed notification doesn't prevent the new one.
+		s.post(NotificationTypeCertExpiry, NotificationSeverityWarning, "expires soon")
+		assert.Len(t, s.undismissed(), 2)
+	})
+
+	t.Run("persist", func(t *testing.T) {
+		loaded, loadErr := newNotificationStore(path)
+		require.NoError(t, loadErr)
+
+		assert.Equal(t, s.undismissed(), loaded.undismissed())
+		assert.Equal(t, s.nextID, loaded.nextID)
+	})
+
+	t.Run("dismiss_all", func(t *testing.T) {
+		require.True(t, s.dismiss(0, true))
+
+		assert.Empty(t, s.undismissed())
+	})
+}
+
+func TestNotificationStore_bounded(t *testing.T) {
+	s, err := newNotificationStore(filepath.Join(t.TempDir(), notificationsFilename))
+	require.NoError(t, err)
+
+	s.now = func() (t time.Time) { return time.Unix(0, 0).UTC() }
+
+	const extra = 5
+
+	for i := range maxNotifications + extra {
+		s.post(NotificationTypeFilterUpdate, NotificationSeverityError, fmt.Sprint(i))
+	}
+
+	ns := s.undismissed()
+	require.Len(t, ns, maxNotifications)
+
+	assert.Equal(t, uint64(maxNotifications+extra), ns[0].ID)
+	assert.Equal(t, uint64(extra+1), ns[len(ns)-1].ID)
+
+	loaded, err := newNotificationStore(s.path)
+	require.NoError(t, err)
+
+	assert.Equal(t, ns, loaded.undismissed())
+}
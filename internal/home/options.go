@@ -59,6 +59,11 @@ type options struct {
 	// performUpdate, if set, updates AdGuard Home without GUI and exits.
 	performUpdate bool
 
+	// importDnsmasqLeases is the path to the dnsmasq configuration file to
+	// import the static DHCP leases from.  If set, AdGuard Home imports the
+	// leases and exits.
+	importDnsmasqLeases string
+
 	// verbose shows if verbose logging is enabled.
 	verbose bool
 
@@ -83,6 +88,10 @@ type options struct {
 	// noPermCheck disables checking and migration of permissions for the
 	// security-sensitive files.
 	noPermCheck bool
+
+	// noRollback disables rolling the configuration file back to its
+	// pre-migration backup if the startup fails.
+	noRollback bool
 }
 
 // initCmdLineOpts completes initialization of the global command-line option
@@ -256,6 +265,20 @@ var cmdLineOpts = []cmdLineOpt{{
 	description:     "Update the current binary and restart the service in case it's installed.",
 	longName:        "update",
 	shortName:       "",
+}, {
+	updateWithValue: func(o options, v string) (options, error) {
+		o.importDnsmasqLeases = v
+
+		return o, nil
+	},
+	updateNoValue: nil,
+	effect:        nil,
+	// Don't serialize the option, since it's a one-time action.
+	serialize: func(o options) (val string, ok bool) { return "", false },
+	description: "Import static DHCP leases from the dhcp-host options " +
+		"of the dnsmasq configuration file and exit.",
+	longName:  "import-dnsmasq-leases",
+	shortName: "",
 }, {
 	updateWithValue: nil,
 	updateNoValue:   nil,
@@ -319,6 +342,15 @@ var cmdLineOpts = []cmdLineOpt{{
 		"of security-sensitive files.",
 	longName:  "no-permcheck",
 	shortName: "",
+}, {
+	updateWithValue: nil,
+	updateNoValue:   func(o options) (options, error) { o.noRollback = true; return o, nil },
+	effect:          nil,
+	serialize:       func(o options) (val string, ok bool) { return "", o.noRollback },
+	description: "Don't roll the configuration file back to its pre-migration backup " +
+		"if the startup fails.",
+	longName:  "no-rollback",
+	shortName: "",
 }, {
 	updateWithValue: nil,
 	updateNoValue:   nil,
@@ -125,6 +125,16 @@ func TestParsePerformUpdate(t *testing.T) {
 	assert.True(t, testParseOK(t, "--update").performUpdate, "--update is perform update")
 }
 
+func TestParseImportDnsmasqLeases(t *testing.T) {
+	assert.Equal(t, "", testParseOK(t).importDnsmasqLeases, "empty is no import")
+	assert.Equal(
+		t,
+		"path",
+		testParseOK(t, "--import-dnsmasq-leases", "path").importDnsmasqLeases,
+		"--import-dnsmasq-leases is import",
+	)
+}
+
 // TODO(e.burkov):  Remove after v0.108.0.
 func TestParseDisableMemoryOptimization(t *testing.T) {
 	o, eff, err := parseCmdOpts("", []string{"--no-mem-optimization"})
@@ -0,0 +1,72 @@
+package home
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// rollbackTimeout is the time after the startup within which the failure to
+// initialize a major subsystem causes the rollback of the configuration file.
+const rollbackTimeout = 30 * time.Second
+
+// maxRollbacks is the maximum number of times the initialization is restarted
+// after rolling the configuration file back.
+const maxRollbacks = 1
+
+// runWithRollback calls start and, if it fails with an error of the
+// initialization of a major subsystem, such as the DNS filter, the DHCP server,
+// or the DNS server, rolls the configuration file back and calls start again,
+// at most [maxRollbacks] times.  err is the error of the last call to start,
+// joined with the error of the rollback, if any.
+func runWithRollback(ctx context.Context, start func(ctx context.Context) (err error)) (err error) {
+	for rollbacks := 0; ; rollbacks++ {
+		err = start(ctx)
+		if err == nil || rollbacks >= maxRollbacks {
+			return err
+		}
+
+		err = rollbackOnInitError(ctx, err)
+		if err != nil {
+			// Don't wrap the error since it's informative enough as is.
+			return err
+		}
+	}
+}
+
+// rollbackOnInitError handles initErr, the error of the initialization of a
+// major subsystem.  If the configuration file has been upgraded during the
+// current startup less than [rollbackTimeout] ago, and the backup exists, it
+// rolls the file back and stops the subsystems, so that the initialization can
+// be restarted.  Otherwise, it returns initErr.
+func rollbackOnInitError(ctx context.Context, initErr error) (err error) {
+	m := Context.configMigrator
+	if m == nil || time.Since(Context.startTime) > rollbackTimeout {
+		return initErr
+	}
+
+	log.Error("initializing after config upgrade: %s; rolling back to %q", initErr, m.BackupPath())
+
+	body, err := m.Rollback()
+	if err != nil {
+		return errors.Join(initErr, fmt.Errorf("rolling back config: %w", err))
+	}
+
+	// Replace the cached upgraded data, so that the rolled back one is parsed.
+	config.fileData = body
+
+	cleanup(ctx)
+	closeDNSServer()
+
+	// The clients storage isn't started before the DNS server, so it only
+	// needs to be initialized again.
+	Context.clients.storage = nil
+
+	Context.configMigrator = nil
+	Context.configRolledBack = true
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package home
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/configmigrate"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testOldConfig is a configuration file with a schema version older than
+// [configmigrate.LastSchemaVersion].
+const testOldConfig = `schema_version: 33
+http:
+  address: 127.0.0.1:3000
+dns:
+  bind_hosts:
+    - 127.0.0.1
+  port: 53
+filtering:
+  filters_update_interval: 24
+filters:
+  - enabled: true
+    url: https://example.com/filter.txt
+    name: Example
+    id: 1
+`
+
+func TestRunWithRollback(t *testing.T) {
+	prevConfig := config
+	prevWorkDir, prevConfPath := Context.workDir, Context.confFilePath
+	t.Cleanup(func() {
+		config = prevConfig
+		Context.workDir, Context.confFilePath = prevWorkDir, prevConfPath
+		Context.configMigrator, Context.configRolledBack = nil, false
+	})
+
+	config = &configuration{}
+
+	dir := t.TempDir()
+	confPath := filepath.Join(dir, "AdGuardHome.yaml")
+	err := os.WriteFile(confPath, []byte(testOldConfig), 0o600)
+	require.NoError(t, err)
+
+	Context.workDir, Context.confFilePath = dir, confPath
+	Context.startTime = time.Now()
+
+	const errInit errors.Error = "initializing dns: test error"
+
+	opts := options{
+		noEtcHosts: true,
+	}
+
+	// start is a part of the startup, which fails with the upgraded config and
+	// succeeds with the rolled back one.
+	var starts int
+	start := func(_ context.Context) (startErr error) {
+		starts++
+
+		startErr = setupContext(opts)
+		require.NoError(t, startErr)
+
+		if !Context.configRolledBack {
+			return errInit
+		}
+
+		return nil
+	}
+
+	err = runWithRollback(testutil.ContextWithTimeout(t, testTimeout), start)
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, starts)
+	assert.Nil(t, Context.configMigrator)
+
+	// The rolled back config is upgraded again in memory.
+	assert.Equal(t, configmigrate.LastSchemaVersion, config.SchemaVersion)
+	require.Len(t, config.Filters, 1)
+
+	assert.Equal(t, "Custom", config.Filters[0].Category)
+
+	backup, err := os.ReadFile(confPath + configmigrate.BackupExt)
+	require.NoError(t, err)
+
+	assert.Equal(t, testOldConfig, string(backup))
+
+	// The rolled back config file keeps the old schema version after the
+	// restart.
+	got, err := os.ReadFile(confPath)
+	require.NoError(t, err)
+
+	assert.Equal(t, testOldConfig, string(got))
+
+	t.Run("bounded", func(t *testing.T) {
+		err = os.WriteFile(confPath, []byte(testOldConfig), 0o600)
+		require.NoError(t, err)
+
+		config.fileData = nil
+		Context.configRolledBack = false
+
+		// alwaysFail is a part of the startup, which fails with any config.
+		var fails int
+		alwaysFail := func(_ context.Context) (startErr error) {
+			fails++
+
+			require.NoError(t, setupContext(opts))
+
+			return errInit
+		}
+
+		err = runWithRollback(testutil.ContextWithTimeout(t, testTimeout), alwaysFail)
+		assert.ErrorIs(t, err, errInit)
+
+		assert.Equal(t, 1+maxRollbacks, fails)
+	})
+}
@@ -1,6 +1,7 @@
 package home
 
 import (
+	"context"
 	"fmt"
 	"io/fs"
 	"os"
@@ -14,6 +15,7 @@ import (
 	"github.com/AdguardTeam/AdGuardHome/internal/version"
 	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
 	"github.com/AdguardTeam/golibs/netutil/urlutil"
 	"github.com/kardianos/service"
 )
@@ -279,7 +281,12 @@ func handleServiceCommand(s service.Service, action string, opts options) (err e
 
 		handleServiceInstallCommand(s)
 	case "uninstall":
+		if err = initWorkingDir(opts); err != nil {
+			return fmt.Errorf("failed to init working dir: %w", err)
+		}
+
 		handleServiceUninstallCommand(s)
+		restoreResolvedStub(opts)
 	default:
 		if err = svcAction(s, action); err != nil {
 			return fmt.Errorf("executing action %q: %w", action, err)
@@ -373,6 +380,22 @@ func handleServiceUninstallCommand(s service.Service) {
 	}
 }
 
+// restoreResolvedStub reverts the changes made to the configuration of
+// systemd-resolved during the installation, if there are any, and logs the
+// error.
+func restoreResolvedStub(opts options) {
+	ctx := context.Background()
+	l := newSlogLogger(&logSettings{
+		Enabled: true,
+		Verbose: opts.verbose,
+	})
+
+	err := newResolvedStub(l).Restore(ctx)
+	if err != nil && !errors.Is(err, errors.ErrUnsupported) {
+		l.ErrorContext(ctx, "restoring resolved stub listener", slogutil.KeyError, err)
+	}
+}
+
 // configureService defines additional settings of the service
 func configureService(c *service.Config) {
 	c.Option = service.KeyValue{}
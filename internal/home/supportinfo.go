@@ -0,0 +1,431 @@
+package home
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/AdGuardHome/internal/dhcpd"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/AdGuardHome/internal/version"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+)
+
+// supportInfoMaxLogLines is the maximum number of the error-level log lines
+// included into the support information.
+const supportInfoMaxLogLines = 50
+
+// supportInfoFormatMarkdown is the value of the "format" query parameter of
+// the GET /control/support_info HTTP API requesting the Markdown output.
+const supportInfoFormatMarkdown = "markdown"
+
+// hdrValMarkdown is the value of the Content-Type header for Markdown.
+const hdrValMarkdown = "text/markdown; charset=utf-8"
+
+// errorLogs stores the latest error-level log lines for the support
+// information.
+var errorLogs = newErrorLogBuffer(supportInfoMaxLogLines)
+
+// errorLogBuffer is an [io.Writer] that keeps the latest error-level lines
+// written to it.  It's safe for concurrent use.
+type errorLogBuffer struct {
+	// mu protects lines and next.
+	mu *sync.Mutex
+
+	// lines is the ring buffer of the stored lines.
+	lines []string
+
+	// next is the index in lines to write the next line to.
+	next int
+
+	// full is true if lines has been filled at least once.
+	full bool
+}
+
+// type check
+var _ io.Writer = (*errorLogBuffer)(nil)
+
+// newErrorLogBuffer returns a new *errorLogBuffer keeping at most size lines.
+// size must be greater than zero.
+func newErrorLogBuffer(size int) (b *errorLogBuffer) {
+	return &errorLogBuffer{
+		mu:    &sync.Mutex{},
+		lines: make([]string, size),
+	}
+}
+
+// errorLevelMarker is the marker of the error-level lines in the log output of
+// both the legacy and the structured loggers.
+const errorLevelMarker = "[error]"
+
+// Write implements the [io.Writer] interface for *errorLogBuffer.  p is
+// expected to contain whole lines.
+func (b *errorLogBuffer) Write(p []byte) (n int, err error) {
+	if !bytes.Contains(p, []byte(errorLevelMarker)) {
+		return len(p), nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, line := range strings.Split(strings.TrimSpace(string(p)), "\n") {
+		if !strings.Contains(line, errorLevelMarker) {
+			continue
+		}
+
+		b.lines[b.next] = line
+		b.next = (b.next + 1) % len(b.lines)
+		b.full = b.full || b.next == 0
+	}
+
+	return len(p), nil
+}
+
+// latest returns the stored lines from the oldest to the newest.
+func (b *errorLogBuffer) latest() (lines []string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.full {
+		return append([]string{}, b.lines[:b.next]...)
+	}
+
+	return append(append([]string{}, b.lines[b.next:]...), b.lines[:b.next]...)
+}
+
+// supportInfoModules shows which modules of AdGuard Home are enabled.
+type supportInfoModules struct {
+	DHCP     bool `json:"dhcp"`
+	TLS      bool `json:"tls"`
+	DoH      bool `json:"doh"`
+	DoT      bool `json:"dot"`
+	DoQ      bool `json:"doq"`
+	DNSCrypt bool `json:"dnscrypt"`
+	QueryLog bool `json:"querylog"`
+	Stats    bool `json:"stats"`
+}
+
+// supportInfoCounts contains the numbers of various configured entities.
+type supportInfoCounts struct {
+	Clients int `json:"clients"`
+	Filters int `json:"filters"`
+	Rules   int `json:"rules"`
+	Leases  int `json:"leases"`
+}
+
+// supportInfo is the response of the GET /control/support_info HTTP API.  It
+// must not contain any secrets or personal data.
+type supportInfo struct {
+	Version   string             `json:"version"`
+	Channel   string             `json:"channel"`
+	Commit    string             `json:"commit"`
+	GOOS      string             `json:"goos"`
+	GOARCH    string             `json:"goarch"`
+	Container string             `json:"container"`
+	ErrorLogs []string           `json:"error_logs"`
+	Counts    supportInfoCounts  `json:"counts"`
+	Modules   supportInfoModules `json:"modules"`
+	Snap      bool               `json:"snap"`
+}
+
+// supportInfoSources are the sources of the support information.  Any of the
+// modules may be nil, in which case the corresponding data is omitted.
+type supportInfoSources struct {
+	dhcp    dhcpd.Interface
+	filters *filtering.DNSFilter
+
+	// rootFS is the root filesystem used to detect containers.
+	rootFS fs.FS
+
+	// getenv returns the value of the environment variable.
+	getenv func(key string) (val string)
+
+	// logs are the source of the error-level log lines.
+	logs *errorLogBuffer
+
+	// salt is added to the hashed personal data.
+	salt []byte
+
+	// clients is the number of the persistent clients.
+	clients int
+}
+
+// handleSupportInfo is the handler for the GET /control/support_info HTTP API.
+// If the "format" query parameter is "markdown", the information is rendered
+// as Markdown suitable for GitHub issues.
+func handleSupportInfo(w http.ResponseWriter, r *http.Request) {
+	src := &supportInfoSources{
+		dhcp:    Context.dhcpServer,
+		filters: Context.filters,
+		rootFS:  os.DirFS("/"),
+		getenv:  os.Getenv,
+		logs:    errorLogs,
+		salt:    supportInfoSalt,
+	}
+
+	if Context.clients.storage != nil {
+		src.clients = Context.clients.storage.Size()
+	}
+
+	info := src.collect()
+
+	if r.URL.Query().Get("format") != supportInfoFormatMarkdown {
+		aghhttp.WriteJSONResponseOK(w, r, info)
+
+		return
+	}
+
+	w.Header().Set(httphdr.ContentType, hdrValMarkdown)
+	_, err := io.WriteString(w, info.markdown())
+	if err != nil {
+		log.Debug("support info: writing response: %s", err)
+	}
+}
+
+// supportInfoSalt is the salt for hashing personal data in the support
+// information.  It's generated once per process, so that the same data is
+// hashed to the same value within the process and can't be matched across
+// restarts.
+var supportInfoSalt = newSupportInfoSalt()
+
+// newSupportInfoSalt returns a new random salt.
+func newSupportInfoSalt() (salt []byte) {
+	salt = make([]byte, 16)
+
+	// Don't check the error since it's always nil on supported platforms.
+	_, _ = rand.Read(salt)
+
+	return salt
+}
+
+// collect returns the support information from src.
+func (src *supportInfoSources) collect() (info *supportInfo) {
+	info = &supportInfo{
+		Version:   version.Version(),
+		Channel:   version.Channel(),
+		Commit:    version.Revision(),
+		GOOS:      runtime.GOOS,
+		GOARCH:    runtime.GOARCH,
+		Container: detectContainer(src.rootFS, src.getenv),
+		Snap:      src.getenv("SNAP") != "",
+		ErrorLogs: []string{},
+	}
+
+	func() {
+		config.RLock()
+		defer config.RUnlock()
+
+		tlsConf := config.TLS
+		info.Modules = supportInfoModules{
+			TLS:      tlsConf.Enabled,
+			DoH:      tlsConf.Enabled && tlsConf.PortHTTPS != 0,
+			DoT:      tlsConf.Enabled && tlsConf.PortDNSOverTLS != 0,
+			DoQ:      tlsConf.Enabled && tlsConf.PortDNSOverQUIC != 0,
+			DNSCrypt: tlsConf.Enabled && tlsConf.PortDNSCrypt != 0,
+			QueryLog: config.QueryLog.Enabled,
+			Stats:    config.Stats.Enabled,
+		}
+	}()
+
+	info.Counts.Clients = src.clients
+
+	if src.dhcp != nil {
+		info.Modules.DHCP = src.dhcp.Enabled()
+		info.Counts.Leases = len(src.dhcp.Leases())
+	}
+
+	if src.filters != nil {
+		for _, c := range src.filters.RulesCounts() {
+			info.Counts.Filters++
+			info.Counts.Rules += c.Count
+		}
+	}
+
+	if src.logs != nil {
+		for _, line := range src.logs.latest() {
+			info.ErrorLogs = append(info.ErrorLogs, redactLogLine(line, src.salt))
+		}
+	}
+
+	return info
+}
+
+// markdown returns info rendered as Markdown suitable for GitHub issues.
+func (info *supportInfo) markdown() (md string) {
+	b := &strings.Builder{}
+
+	container := info.Container
+	if container == "" {
+		container = "none detected"
+	}
+
+	_, _ = fmt.Fprintf(b, "### Environment\n\n")
+	_, _ = fmt.Fprintf(b, "- Version: `%s` (%s)\n", info.Version, info.Channel)
+	if info.Commit != "" {
+		_, _ = fmt.Fprintf(b, "- Commit: `%s`\n", info.Commit)
+	}
+	_, _ = fmt.Fprintf(b, "- Platform: `%s/%s`\n", info.GOOS, info.GOARCH)
+	_, _ = fmt.Fprintf(b, "- Container: %s\n", container)
+	_, _ = fmt.Fprintf(b, "- Snap: %t\n", info.Snap)
+
+	_, _ = fmt.Fprintf(b, "\n### Modules\n\n")
+	writeMarkdownFields(b, info.Modules)
+
+	_, _ = fmt.Fprintf(b, "\n### Counts\n\n")
+	writeMarkdownFields(b, info.Counts)
+
+	_, _ = fmt.Fprintf(b, "\n### Latest errors\n\n")
+	if len(info.ErrorLogs) == 0 {
+		_, _ = fmt.Fprintf(b, "None.\n")
+
+		return b.String()
+	}
+
+	_, _ = fmt.Fprintf(b, "<details>\n<summary>%d lines</summary>\n\n```\n", len(info.ErrorLogs))
+	for _, line := range info.ErrorLogs {
+		_, _ = fmt.Fprintln(b, strings.ReplaceAll(line, "```", "'''"))
+	}
+	_, _ = fmt.Fprintf(b, "```\n\n</details>\n")
+
+	return b.String()
+}
+
+// writeMarkdownFields writes the fields of a flat JSON-serializable struct v to
+// b as a Markdown list.  The JSON names of the fields are used to keep them in
+// sync with the JSON output.
+func writeMarkdownFields(b *strings.Builder, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		// Should never happen, since v only contains simple fields.
+		panic(fmt.Errorf("marshaling %T: %w", v, err))
+	}
+
+	fields := map[string]any{}
+	err = json.Unmarshal(data, &fields)
+	if err != nil {
+		panic(fmt.Errorf("unmarshaling %T: %w", v, err))
+	}
+
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	for _, k := range keys {
+		_, _ = fmt.Fprintf(b, "- %s: %v\n", k, fields[k])
+	}
+}
+
+// detectContainer returns the name of the container environment AdGuard Home
+// is running in, or an empty string if none is detected.  rootFS is the root
+// filesystem.
+func detectContainer(rootFS fs.FS, getenv func(key string) (val string)) (name string) {
+	if getenv("KUBERNETES_SERVICE_HOST") != "" {
+		return "kubernetes"
+	}
+
+	// Set by podman, LXC, and systemd-nspawn among others.
+	if name = getenv("container"); name != "" {
+		return name
+	}
+
+	if _, err := fs.Stat(rootFS, ".dockerenv"); err == nil {
+		return "docker"
+	}
+
+	if _, err := fs.Stat(rootFS, "run/.containerenv"); err == nil {
+		return "podman"
+	}
+
+	cgroup, err := fs.ReadFile(rootFS, "proc/1/cgroup")
+	if err != nil {
+		return ""
+	}
+
+	for _, marker := range []string{"kubepods", "docker", "lxc", "containerd"} {
+		if bytes.Contains(cgroup, []byte(marker)) {
+			if marker == "kubepods" {
+				return "kubernetes"
+			}
+
+			return marker
+		}
+	}
+
+	return ""
+}
+
+// logTokenRe matches the tokens of log lines which may contain personal data.
+var logTokenRe = regexp.MustCompile(`[^\s"'(),;=<>{}]+`)
+
+// redactLogLine returns line with the IP addresses, domain names, and URLs
+// replaced with their salted hashes.
+func redactLogLine(line string, salt []byte) (redacted string) {
+	return logTokenRe.ReplaceAllStringFunc(line, func(tok string) (res string) {
+		trimmed := strings.TrimRight(tok, ".:")
+		if !isPersonalToken(trimmed) {
+			return tok
+		}
+
+		h := sha256.New()
+		_, _ = h.Write(salt)
+		_, _ = h.Write([]byte(trimmed))
+
+		return "[redacted:" + hex.EncodeToString(h.Sum(nil))[:8] + "]" + tok[len(trimmed):]
+	})
+}
+
+// isPersonalToken returns true if tok is an IP address, an IP address with a
+// port, a URL, or a domain name.
+func isPersonalToken(tok string) (ok bool) {
+	if tok == "" {
+		return false
+	}
+
+	if strings.Contains(tok, "://") {
+		return true
+	}
+
+	if _, err := netip.ParseAddr(strings.Trim(tok, "[]")); err == nil {
+		return true
+	}
+
+	if _, err := netip.ParseAddrPort(tok); err == nil {
+		return true
+	}
+
+	host := tok
+	if h, _, err := net.SplitHostPort(tok); err == nil {
+		host = h
+	}
+
+	if !strings.Contains(host, ".") || netutil.ValidateHostname(host) != nil {
+		return false
+	}
+
+	// Make sure that the top-level domain isn't numeric to skip versions,
+	// timestamps, and such.
+	tld := host[strings.LastIndexByte(host, '.')+1:]
+
+	return strings.IndexFunc(tld, func(r rune) (isLetter bool) {
+		return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+	}) != -1
+}
@@ -0,0 +1,180 @@
+package home
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorLogBuffer(t *testing.T) {
+	const size = 3
+
+	b := newErrorLogBuffer(size)
+	assert.Empty(t, b.latest())
+
+	_, err := b.Write([]byte("2025/01/01 00:00:00.000000 [info] not stored\n"))
+	require.NoError(t, err)
+
+	assert.Empty(t, b.latest())
+
+	for i := range size + 1 {
+		_, err = fmt.Fprintf(b, "2025/01/01 00:00:00.000000 [error] line %d\n", i)
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, []string{
+		"2025/01/01 00:00:00.000000 [error] line 1",
+		"2025/01/01 00:00:00.000000 [error] line 2",
+		"2025/01/01 00:00:00.000000 [error] line 3",
+	}, b.latest())
+}
+
+func TestDetectContainer(t *testing.T) {
+	noEnv := func(_ string) (val string) { return "" }
+
+	testCases := []struct {
+		fsys fstest.MapFS
+		env  map[string]string
+		name string
+		want string
+	}{{
+		fsys: fstest.MapFS{},
+		env:  nil,
+		name: "none",
+		want: "",
+	}, {
+		fsys: fstest.MapFS{},
+		env:  map[string]string{"KUBERNETES_SERVICE_HOST": "10.0.0.1"},
+		name: "kubernetes_env",
+		want: "kubernetes",
+	}, {
+		fsys: fstest.MapFS{},
+		env:  map[string]string{"container": "podman"},
+		name: "container_env",
+		want: "podman",
+	}, {
+		fsys: fstest.MapFS{".dockerenv": &fstest.MapFile{}},
+		env:  nil,
+		name: "dockerenv",
+		want: "docker",
+	}, {
+		fsys: fstest.MapFS{"run/.containerenv": &fstest.MapFile{}},
+		env:  nil,
+		name: "containerenv",
+		want: "podman",
+	}, {
+		fsys: fstest.MapFS{"proc/1/cgroup": &fstest.MapFile{
+			Data: []byte("0::/system.slice/docker-0123456789abcdef.scope\n"),
+		}},
+		env:  nil,
+		name: "cgroup_docker",
+		want: "docker",
+	}, {
+		fsys: fstest.MapFS{"proc/1/cgroup": &fstest.MapFile{
+			Data: []byte("0::/init.scope\n"),
+		}},
+		env:  nil,
+		name: "cgroup_host",
+		want: "",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			getenv := noEnv
+			if tc.env != nil {
+				getenv = func(key string) (val string) { return tc.env[key] }
+			}
+
+			assert.Equal(t, tc.want, detectContainer(tc.fsys, getenv))
+		})
+	}
+}
+
+func TestRedactLogLine(t *testing.T) {
+	salt := []byte("test salt")
+
+	testCases := []struct {
+		name     string
+		in       string
+		wantKept []string
+		wantGone []string
+	}{{
+		name:     "ip",
+		in:       "2025/01/01 12:00:00.123456 [error] client 192.168.1.2: blocked",
+		wantKept: []string{"2025/01/01", "12:00:00.123456", "[error]", "client", "blocked"},
+		wantGone: []string{"192.168.1.2"},
+	}, {
+		name:     "ip_port",
+		in:       `[error] dial "[2001:db8::1]:53": timeout`,
+		wantKept: []string{"[error] dial", "timeout"},
+		wantGone: []string{"2001:db8::1"},
+	}, {
+		name:     "domain",
+		in:       "[error] resolving example.org: no such host",
+		wantKept: []string{"[error] resolving", "no such host"},
+		wantGone: []string{"example.org"},
+	}, {
+		name:     "url",
+		in:       "[error] updating filter from url https://filters.example/list.txt",
+		wantKept: []string{"[error] updating filter from url"},
+		wantGone: []string{"filters.example", "list.txt"},
+	}, {
+		name:     "version",
+		in:       "[error] version v0.108.0 is too old",
+		wantKept: []string{"v0.108.0"},
+		wantGone: nil,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := redactLogLine(tc.in, salt)
+
+			for _, s := range tc.wantKept {
+				assert.Contains(t, got, s)
+			}
+
+			for _, s := range tc.wantGone {
+				assert.NotContains(t, got, s)
+			}
+		})
+	}
+
+	t.Run("stable", func(t *testing.T) {
+		const line = "[error] 192.0.2.1 and 192.0.2.1"
+
+		got := redactLogLine(line, salt)
+		parts := strings.Split(got, " and ")
+		require.Len(t, parts, 2)
+
+		assert.Equal(t, strings.TrimPrefix(parts[0], "[error] "), parts[1])
+		assert.NotEqual(t, got, redactLogLine(line, []byte("other salt")))
+	})
+}
+
+func TestSupportInfo_markdown(t *testing.T) {
+	info := &supportInfo{
+		Version:   "v0.108.0",
+		Channel:   "release",
+		GOOS:      "linux",
+		GOARCH:    "amd64",
+		Container: "docker",
+		ErrorLogs: []string{"[error] something failed"},
+		Counts:    supportInfoCounts{Clients: 2, Filters: 3, Rules: 100, Leases: 4},
+		Modules:   supportInfoModules{DHCP: true, DoT: true, TLS: true},
+	}
+
+	md := info.markdown()
+
+	assert.Contains(t, md, "- Version: `v0.108.0` (release)\n")
+	assert.Contains(t, md, "- Platform: `linux/amd64`\n")
+	assert.Contains(t, md, "- Container: docker\n")
+	assert.Contains(t, md, "- dhcp: true\n")
+	assert.Contains(t, md, "- doh: false\n")
+	assert.Contains(t, md, "- rules: 100\n")
+	assert.Contains(t, md, "[error] something failed\n")
+	assert.NotContains(t, md, "Commit")
+}
@@ -24,6 +24,7 @@ import (
 	"github.com/AdguardTeam/AdGuardHome/internal/dnsforward"
 	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/timeutil"
 	"github.com/google/go-cmp/cmp"
 )
 
@@ -105,6 +106,8 @@ func (m *tlsManager) setCertFileTime() {
 func (m *tlsManager) start() {
 	m.registerWebHandlers()
 
+	go m.checkCertExpiryLoop()
+
 	m.confLock.Lock()
 	tlsConf := m.conf
 	m.confLock.Unlock()
@@ -115,7 +118,8 @@ func (m *tlsManager) start() {
 	Context.web.tlsConfigChanged(context.Background(), tlsConf)
 }
 
-// reload updates the configuration and restarts t.
+// reload updates the configuration and restarts t, if the certificate file has
+// been modified.
 func (m *tlsManager) reload() {
 	m.confLock.Lock()
 	tlsConf := m.conf
@@ -140,29 +144,106 @@ func (m *tlsManager) reload() {
 
 	log.Debug("tls: certificate file is modified")
 
-	m.confLock.Lock()
-	err = m.load()
-	m.confLock.Unlock()
+	tlsConf, err = m.reloadCert()
 	if err != nil {
 		log.Error("tls: reloading: %s", err)
 
 		return
 	}
 
-	m.certLastMod = fi.ModTime().UTC()
-
 	_ = reconfigureDNSServer()
 
-	m.confLock.Lock()
-	tlsConf = m.conf
-	m.confLock.Unlock()
-
 	// The background context is used because the TLSConfigChanged wraps context
 	// with timeout on its own and shuts down the server, which handles current
 	// request.
 	Context.web.tlsConfigChanged(context.Background(), tlsConf)
 }
 
+// errTLSDisabled is returned by [tlsManager.reloadCert] when the encryption is
+// disabled.
+const errTLSDisabled errors.Error = "encryption is disabled"
+
+// reloadCert re-reads the certificate and the private key from the files or the
+// configuration data and replaces the current ones with them.  If they're
+// invalid, the current ones are kept and err is returned.  tlsConf is the
+// updated configuration, which should be applied to the DNS and web servers.
+func (m *tlsManager) reloadCert() (tlsConf tlsConfigSettings, err error) {
+	m.confLock.Lock()
+	tlsConf = m.conf
+	m.confLock.Unlock()
+
+	if !tlsConf.Enabled {
+		return tlsConf, errTLSDisabled
+	}
+
+	status := &tlsConfigStatus{}
+	err = loadTLSConf(&tlsConf, status)
+	if err != nil {
+		return tlsConf, fmt.Errorf("loading config: %w", err)
+	}
+
+	func() {
+		m.confLock.Lock()
+		defer m.confLock.Unlock()
+
+		m.conf.CertificateChainData = tlsConf.CertificateChainData
+		m.conf.PrivateKeyData = tlsConf.PrivateKeyData
+		m.status = status
+
+		m.setCertFileTime()
+	}()
+
+	m.checkCertExpiry(time.Now())
+
+	return tlsConf, nil
+}
+
+// certExpiryWarnPeriod is the period before the expiration of the certificate
+// during which the notifications about it are posted.
+const certExpiryWarnPeriod = 7 * timeutil.Day
+
+// checkCertExpiryLoop checks the expiration of the certificate immediately and
+// then daily.  It is intended to be used as a goroutine.
+func (m *tlsManager) checkCertExpiryLoop() {
+	defer log.OnPanic("tls: checking certificate expiry")
+
+	m.checkCertExpiry(time.Now())
+
+	ticker := time.NewTicker(timeutil.Day)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		m.checkCertExpiry(now)
+	}
+}
+
+// checkCertExpiry posts a notification if the certificate expires within
+// [certExpiryWarnPeriod] from now or has already expired.
+func (m *tlsManager) checkCertExpiry(now time.Time) {
+	m.confLock.Lock()
+	enabled := m.conf.Enabled
+	notAfter := m.status.NotAfter
+	m.confLock.Unlock()
+
+	if !enabled || notAfter.IsZero() {
+		return
+	}
+
+	left := notAfter.Sub(now)
+	if left > certExpiryWarnPeriod {
+		return
+	}
+
+	sev := NotificationSeverityWarning
+	msg := fmt.Sprintf("tls certificate expires on %s", notAfter.Format(time.DateOnly))
+	if left <= 0 {
+		sev = NotificationSeverityError
+		msg = fmt.Sprintf("tls certificate has expired on %s", notAfter.Format(time.DateOnly))
+	}
+
+	Context.notifications.post(NotificationTypeCertExpiry, sev, msg)
+}
+
 // loadTLSConf loads and validates the TLS configuration.  The returned error is
 // also set in status.WarningValidation.
 func loadTLSConf(tlsConf *tlsConfigSettings, status *tlsConfigStatus) (err error) {
@@ -349,6 +430,38 @@ func (m *tlsManager) handleTLSValidate(w http.ResponseWriter, r *http.Request) {
 	marshalTLS(w, r, resp)
 }
 
+// handleTLSReload is the handler for the POST /control/tls/reload HTTP API.
+// It re-reads the certificate and the private key and applies them to the DNS
+// and web servers.
+func (m *tlsManager) handleTLSReload(w http.ResponseWriter, r *http.Request) {
+	tlsConf, err := m.reloadCert()
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusUnprocessableEntity, "reloading certificate: %s", err)
+
+		return
+	}
+
+	err = reconfigureDNSServer()
+	if err != nil {
+		aghhttp.Error(r, w, http.StatusInternalServerError, "%s", err)
+
+		return
+	}
+
+	m.handleTLSStatus(w, r)
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	// The background context is used because the TLSConfigChanged wraps context
+	// with timeout on its own and shuts down the server, which handles current
+	// request.  It is also should be done in a separate goroutine due to the
+	// same reason.
+	go func() {
+		Context.web.tlsConfigChanged(context.Background(), tlsConf)
+	}()
+}
+
 // setConfig updates manager conf with the given one.
 func (m *tlsManager) setConfig(
 	newConf tlsConfigSettings,
@@ -428,6 +541,7 @@ func (m *tlsManager) handleTLSConfigure(w http.ResponseWriter, r *http.Request)
 
 	restartHTTPS := m.setConfig(req.tlsConfigSettings, status, req.ServePlainDNS)
 	m.setCertFileTime()
+	m.checkCertExpiry(time.Now())
 
 	if req.ServePlainDNS != aghalg.NBNull {
 		func() {
@@ -794,4 +908,5 @@ func (m *tlsManager) registerWebHandlers() {
 	httpRegister(http.MethodGet, "/control/tls/status", m.handleTLSStatus)
 	httpRegister(http.MethodPost, "/control/tls/configure", m.handleTLSConfigure)
 	httpRegister(http.MethodPost, "/control/tls/validate", m.handleTLSValidate)
+	httpRegister(http.MethodPost, "/control/tls/reload", m.handleTLSReload)
 }
@@ -1,11 +1,17 @@
 package home
 
 import (
+	"os"
+	"path/filepath"
+	"slices"
 	"testing"
 	"time"
 
+	"github.com/AdguardTeam/AdGuardHome/internal/aghos"
+	"github.com/AdguardTeam/AdGuardHome/internal/dnsforward"
 	"github.com/AdguardTeam/golibs/testutil"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 var testCertChainData = []byte(`-----BEGIN CERTIFICATE-----
@@ -75,3 +81,65 @@ func TestValidateCertificates(t *testing.T) {
 		assert.True(t, status.ValidPair)
 	})
 }
+
+func TestTLSManager_reloadCert(t *testing.T) {
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+
+	writeFile := func(t *testing.T, path string, data []byte) {
+		t.Helper()
+
+		require.NoError(t, os.WriteFile(path, data, aghos.DefaultPermFile))
+	}
+
+	writeFile(t, certPath, testCertChainData)
+	writeFile(t, keyPath, testPrivateKeyData)
+
+	m, err := newTLSManager(tlsConfigSettings{
+		Enabled: true,
+		TLSConfig: dnsforward.TLSConfig{
+			CertificatePath: certPath,
+			PrivateKeyPath:  keyPath,
+		},
+	}, true)
+	require.NoError(t, err)
+
+	t.Run("valid", func(t *testing.T) {
+		// Reformat the certificate to make sure the new data is loaded.
+		newCertData := append(slices.Clone(testCertChainData), '\n')
+		writeFile(t, certPath, newCertData)
+
+		tlsConf, reloadErr := m.reloadCert()
+		require.NoError(t, reloadErr)
+
+		assert.Equal(t, newCertData, tlsConf.CertificateChainData)
+		assert.Equal(t, newCertData, m.conf.CertificateChainData)
+		assert.True(t, m.status.ValidPair)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		prevCertData := m.conf.CertificateChainData
+		prevStatus := m.status
+
+		writeFile(t, certPath, []byte("bad cert"))
+
+		_, reloadErr := m.reloadCert()
+		testutil.AssertErrorMsg(
+			t,
+			"loading config: validating certificate pair: empty certificate",
+			reloadErr,
+		)
+
+		assert.Equal(t, prevCertData, m.conf.CertificateChainData)
+		assert.Same(t, prevStatus, m.status)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		disabled, newErr := newTLSManager(tlsConfigSettings{}, true)
+		require.NoError(t, newErr)
+
+		_, reloadErr := disabled.reloadCert()
+		assert.ErrorIs(t, reloadErr, errTLSDisabled)
+	})
+}
@@ -12,6 +12,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
 	"github.com/AdguardTeam/AdGuardHome/internal/updater"
 	"github.com/AdguardTeam/golibs/errors"
@@ -66,6 +67,15 @@ type webConfig struct {
 	// appropriate field.
 	WriteTimeout time.Duration
 
+	// RateLimitWindow is the duration of the window of the rate limiting of
+	// the unauthenticated requests.
+	RateLimitWindow time.Duration
+
+	// RateLimitMaxRequests is the maximum number of the unauthenticated
+	// requests from a single IP address within RateLimitWindow.  If it's zero,
+	// the rate limiting is disabled.
+	RateLimitMaxRequests int
+
 	firstRun bool
 
 	// disableUpdate, if true, tells AdGuard Home to not check for updates.
@@ -108,6 +118,10 @@ type webAPI struct {
 	// nil.
 	baseLogger *slog.Logger
 
+	// rateLimiter limits the number of the unauthenticated requests.  It's
+	// kept across the restarts of the servers.  It must not be nil.
+	rateLimiter *aghhttp.RateLimiter
+
 	// httpsServer is the server that handles HTTPS traffic.  If it is not nil,
 	// [Web.http3Server] must also not be nil.
 	httpsServer httpsServer
@@ -124,6 +138,13 @@ func newWebAPI(ctx context.Context, conf *webConfig) (w *webAPI) {
 		conf:       conf,
 		logger:     conf.logger,
 		baseLogger: conf.baseLogger,
+		rateLimiter: aghhttp.NewRateLimiter(&aghhttp.RateLimiterConfig{
+			Logger:      conf.baseLogger.With(slogutil.KeyPrefix, "web_ratelimit"),
+			IsExempt:    isRateLimitExempt,
+			RemoteIP:    rateLimitRemoteIP,
+			MaxRequests: conf.RateLimitMaxRequests,
+			Window:      conf.RateLimitWindow,
+		}),
 	}
 
 	clientFS := http.FileServer(http.FS(conf.clientFS))
@@ -224,7 +245,7 @@ func (web *webAPI) start(ctx context.Context) {
 		errs := make(chan error, 2)
 
 		// Use an h2c handler to support unencrypted HTTP/2, e.g. for proxies.
-		hdlr := h2c.NewHandler(withMiddlewares(Context.mux, limitRequestBody), &http2.Server{})
+		hdlr := h2c.NewHandler(web.handler(), &http2.Server{})
 
 		logger := web.baseLogger.With(loggerKeyServer, "plain")
 
@@ -254,6 +275,13 @@ func (web *webAPI) start(ctx context.Context) {
 	}
 }
 
+// handler returns the handler of the web UI and API with the middlewares
+// applied.  The rate limiting is the outermost one, so that it applies before
+// the authentication.
+func (web *webAPI) handler() (h http.Handler) {
+	return withMiddlewares(Context.mux, limitRequestBody, web.rateLimiter.Wrap)
+}
+
 // close gracefully shuts down the HTTP servers.
 func (web *webAPI) close(ctx context.Context) {
 	web.logger.InfoContext(ctx, "stopping http server")
@@ -307,7 +335,7 @@ func (web *webAPI) tlsServerLoop(ctx context.Context) {
 
 		web.httpsServer.server = &http.Server{
 			Addr:    addr,
-			Handler: withMiddlewares(Context.mux, limitRequestBody),
+			Handler: web.handler(),
 			TLSConfig: &tls.Config{
 				Certificates: []tls.Certificate{web.httpsServer.cert},
 				RootCAs:      Context.tlsRoots,
@@ -348,7 +376,7 @@ func (web *webAPI) mustStartHTTP3(ctx context.Context, address string) {
 			CipherSuites: Context.tlsCipherIDs,
 			MinVersion:   tls.VersionTLS12,
 		},
-		Handler: withMiddlewares(Context.mux, limitRequestBody),
+		Handler: web.handler(),
 	}
 
 	web.logger.DebugContext(ctx, "starting http/3 server")
@@ -0,0 +1,84 @@
+package querylog
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/AdguardTeam/golibs/netutil"
+)
+
+const (
+	// DefaultAnonymizationPrefixIPv4 is the default length of the prefix of
+	// the clients' IPv4 addresses kept when anonymizing them.
+	DefaultAnonymizationPrefixIPv4 = 24
+
+	// DefaultAnonymizationPrefixIPv6 is the default length of the prefix of
+	// the clients' IPv6 addresses kept when anonymizing them.
+	DefaultAnonymizationPrefixIPv6 = 48
+)
+
+// setAnonymizationPrefixes sets the default lengths of the anonymization
+// prefixes, if they're zero, and validates them.
+func (c *Config) setAnonymizationPrefixes() (err error) {
+	if c.AnonymizationPrefixIPv4 == 0 {
+		c.AnonymizationPrefixIPv4 = DefaultAnonymizationPrefixIPv4
+	}
+
+	if c.AnonymizationPrefixIPv6 == 0 {
+		c.AnonymizationPrefixIPv6 = DefaultAnonymizationPrefixIPv6
+	}
+
+	if l := c.AnonymizationPrefixIPv4; l < 0 || l > netutil.IPv4BitLen {
+		return fmt.Errorf("anonymization prefix ipv4: out of range: %d", l)
+	}
+
+	if l := c.AnonymizationPrefixIPv6; l < 0 || l > netutil.IPv6BitLen {
+		return fmt.Errorf("anonymization prefix ipv6: out of range: %d", l)
+	}
+
+	return nil
+}
+
+// anonymizeFunc returns the function anonymizing the clients' IP addresses in
+// place according to c.  It returns nil if c.AnonymizeClientIP is false.
+func (c *Config) anonymizeFunc() (f aghnet.IPMutFunc) {
+	if !c.AnonymizeClientIP {
+		return nil
+	}
+
+	pref4, pref6 := c.AnonymizationPrefixIPv4, c.AnonymizationPrefixIPv6
+
+	return func(ip net.IP) {
+		copy(ip, anonymizeIP(ip, pref4, pref6))
+	}
+}
+
+// anonymizeIP returns a copy of ip with the bits beyond the prefix of the
+// length for its family zeroed out.  The length of res is the same as the one
+// of ip.  If ip isn't valid, it's returned as is.
+func anonymizeIP(ip net.IP, pref4, pref6 int) (res net.IP) {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return ip
+	}
+
+	bits := pref6
+	if addr.Is4In6() || addr.Is4() {
+		addr, bits = addr.Unmap(), pref4
+	}
+
+	pref, err := addr.Prefix(bits)
+	if err != nil {
+		// Should never happen, since the lengths are validated.
+		panic(fmt.Errorf("anonymizing %s: %w", addr, err))
+	}
+
+	res = pref.Addr().AsSlice()
+	if len(ip) == net.IPv6len {
+		res = res.To16()
+	}
+
+	return res
+}
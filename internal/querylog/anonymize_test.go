@@ -0,0 +1,162 @@
+package querylog
+
+import (
+	"net"
+	"slices"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryLog_anonymization(t *testing.T) {
+	anonymizer := aghnet.NewIPMut(nil)
+	l, err := newQueryLog(Config{
+		Logger:            slogutil.NewDiscardLogger(),
+		Anonymizer:        anonymizer,
+		Enabled:           true,
+		FileEnabled:       true,
+		AnonymizeClientIP: true,
+		RotationIvl:       timeutil.Day,
+		MemSize:           100,
+		BaseDir:           t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	ctx := testutil.ContextWithTimeout(t, testTimeout)
+
+	var (
+		answer = net.IPv4(1, 1, 1, 1)
+
+		client4     = net.IPv4(1, 2, 3, 4)
+		wantClient4 = net.IPv4(1, 2, 3, 0)
+
+		client6     = net.ParseIP("2001:db8:1:2:3:4:5:6")
+		wantClient6 = net.ParseIP("2001:db8:1::")
+	)
+
+	// The shared anonymizer uses the configured prefixes.
+	ip := slices.Clone(client4)
+	anonymizer.Load()(ip)
+	assert.Equal(t, wantClient4, ip)
+
+	// Add the entries with the addresses not anonymized, as if those were
+	// stored before the anonymization has been enabled.
+	addEntry(l, "disk.example", answer, client4)
+	require.NoError(t, l.flushLogBuffer(ctx))
+
+	addEntry(l, "memory.example", answer, client6)
+
+	entries, oldest := l.search(ctx, newSearchParams())
+	require.Len(t, entries, 2)
+
+	res := l.entriesToJSON(ctx, entries, oldest, l.anonymizer.Load())
+	data := testutil.RequireTypeAssert[[]jobject](t, res["data"])
+	require.Len(t, data, 2)
+
+	assert.Equal(t, wantClient6, data[0]["client"])
+	assert.Equal(t, wantClient4, data[1]["client"])
+}
+
+func TestAnonymizeIP(t *testing.T) {
+	testCases := []struct {
+		ip    net.IP
+		want  net.IP
+		name  string
+		pref4 int
+		pref6 int
+	}{{
+		ip:    net.IP{1, 2, 3, 4},
+		want:  net.IP{1, 2, 3, 0},
+		name:  "v4_default",
+		pref4: DefaultAnonymizationPrefixIPv4,
+		pref6: DefaultAnonymizationPrefixIPv6,
+	}, {
+		ip:    net.IP{1, 2, 3, 4}.To16(),
+		want:  net.IP{1, 2, 0, 0}.To16(),
+		name:  "v4_mapped",
+		pref4: 16,
+		pref6: DefaultAnonymizationPrefixIPv6,
+	}, {
+		ip:    net.ParseIP("2001:db8:1:2:3:4:5:6"),
+		want:  net.ParseIP("2001:db8:1::"),
+		name:  "v6_default",
+		pref4: DefaultAnonymizationPrefixIPv4,
+		pref6: DefaultAnonymizationPrefixIPv6,
+	}, {
+		ip:    net.ParseIP("2001:db8:1:2:3:4:5:6"),
+		want:  net.ParseIP("2001:db8:1:2::"),
+		name:  "v6_64",
+		pref4: DefaultAnonymizationPrefixIPv4,
+		pref6: 64,
+	}, {
+		ip:    net.IP{1, 2, 3},
+		want:  net.IP{1, 2, 3},
+		name:  "invalid",
+		pref4: DefaultAnonymizationPrefixIPv4,
+		pref6: DefaultAnonymizationPrefixIPv6,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, anonymizeIP(tc.ip, tc.pref4, tc.pref6))
+		})
+	}
+}
+
+func TestConfig_setAnonymizationPrefixes(t *testing.T) {
+	testCases := []struct {
+		name       string
+		wantErrMsg string
+		pref4      int
+		pref6      int
+		want4      int
+		want6      int
+	}{{
+		name:       "defaults",
+		wantErrMsg: "",
+		pref4:      0,
+		pref6:      0,
+		want4:      DefaultAnonymizationPrefixIPv4,
+		want6:      DefaultAnonymizationPrefixIPv6,
+	}, {
+		name:       "custom",
+		wantErrMsg: "",
+		pref4:      16,
+		pref6:      64,
+		want4:      16,
+		want6:      64,
+	}, {
+		name:       "bad_ipv4",
+		wantErrMsg: "anonymization prefix ipv4: out of range: 33",
+		pref4:      33,
+		pref6:      0,
+	}, {
+		name:       "bad_ipv6",
+		wantErrMsg: "anonymization prefix ipv6: out of range: -1",
+		pref4:      0,
+		pref6:      -1,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &Config{
+				AnonymizationPrefixIPv4: tc.pref4,
+				AnonymizationPrefixIPv6: tc.pref6,
+			}
+
+			err := c.setAnonymizationPrefixes()
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+			if tc.wantErrMsg != "" {
+				return
+			}
+
+			assert.Equal(t, tc.want4, c.AnonymizationPrefixIPv4)
+			assert.Equal(t, tc.want6, c.AnonymizationPrefixIPv6)
+		})
+	}
+}
@@ -157,6 +157,21 @@ var logEntryHandlers = map[string]logEntryHandler{
 
 		return nil
 	},
+	"UpstreamTier": func(t json.Token, ent *logEntry) error {
+		v, ok := t.(json.Number)
+		if !ok {
+			return nil
+		}
+
+		i, err := v.Int64()
+		if err != nil {
+			return err
+		}
+
+		ent.UpstreamTier = int(i)
+
+		return nil
+	},
 	"Elapsed": func(t json.Token, ent *logEntry) error {
 		v, ok := t.(json.Number)
 		if !ok {
@@ -497,6 +512,24 @@ func (l *queryLog) decodeResultDNSRewriteResult(
 	}
 }
 
+// decodeResultDecisionDetail parses the dec's tokens into logEntry ent
+// interpreting it as the result decision detail.
+func (l *queryLog) decodeResultDecisionDetail(
+	ctx context.Context,
+	dec *json.Decoder,
+	ent *logEntry,
+) {
+	dd := &filtering.DecisionDetail{}
+	err := dec.Decode(dd)
+	if err != nil {
+		l.logger.DebugContext(ctx, "decoding result decision detail", slogutil.KeyError, err)
+
+		return
+	}
+
+	ent.Result.DecisionDetail = dd
+}
+
 // translateResult converts some fields of the ent.Result to the format
 // consistent with current implementation.
 func translateResult(ent *logEntry) {
@@ -701,6 +734,8 @@ func (l *queryLog) resultDecHandler(
 		l.decodeResultRules(ctx, dec, ent)
 	case "DNSRewriteResult":
 		l.decodeResultDNSRewriteResult(ctx, dec, ent)
+	case "DecisionDetail":
+		l.decodeResultDecisionDetail(ctx, dec, ent)
 	default:
 		ok = false
 	}
@@ -56,6 +56,7 @@ func TestDecodeLogEntry(t *testing.T) {
 			`"ServiceName":"example.org",` +
 			`"DNSRewriteResult":{"RCode":0,"Response":{"1":["127.0.0.2"]}}},` +
 			`"Upstream":"https://some.upstream",` +
+			`"UpstreamTier":2,` +
 			`"Elapsed":837429}`
 
 		ans, err := base64.StdEncoding.DecodeString(ansStr)
@@ -95,6 +96,7 @@ func TestDecodeLogEntry(t *testing.T) {
 				IsFiltered: true,
 			},
 			Upstream:          "https://some.upstream",
+			UpstreamTier:      2,
 			Elapsed:           837429,
 			AuthenticatedData: true,
 		}
@@ -110,6 +112,39 @@ func TestDecodeLogEntry(t *testing.T) {
 		assert.Equal(t, want, got)
 	})
 
+	t.Run("decision_detail", func(t *testing.T) {
+		const data = `{"IP":"127.0.0.1",` +
+			`"T":"2020-11-25T18:55:56.519796+03:00",` +
+			`"QH":"ads.example",` +
+			`"QT":"A",` +
+			`"QC":"IN",` +
+			`"Result":{` +
+			`"Reason":1,` +
+			`"Rules":[{"Text":"@@||ads.example^"}],` +
+			`"DecisionDetail":{` +
+			`"CandidateRule":{"FilterListID":1,"Text":"||ads.example^"},` +
+			`"OverridingRule":{"Text":"@@||ads.example^"},` +
+			`"Mechanism":"allowlist_rule"}},` +
+			`"Elapsed":837429}`
+
+		got := &logEntry{}
+		l.decodeLogEntry(ctx, got, data)
+
+		assert.Empty(t, logOutput.String())
+		assert.Equal(t, filtering.NotFilteredAllowList, got.Result.Reason)
+		assert.Equal(t, time.Duration(837429), got.Elapsed)
+		assert.Equal(t, &filtering.DecisionDetail{
+			CandidateRule: &filtering.ResultRule{
+				FilterListID: 1,
+				Text:         "||ads.example^",
+			},
+			OverridingRule: &filtering.ResultRule{
+				Text: "@@||ads.example^",
+			},
+			Mechanism: filtering.OverrideAllowlistRule,
+		}, got.Result.DecisionDetail)
+	})
+
 	testCases := []struct {
 		name string
 		log  string
@@ -273,17 +308,14 @@ func TestDecodeLogEntry_backwardCompatability(t *testing.T) {
 	}
 }
 
-// anonymizeIPSlow masks ip to anonymize the client if the ip is a valid one.
-// It only exists in purposes of benchmark comparison, see BenchmarkAnonymizeIP.
-func anonymizeIPSlow(ip net.IP) {
-	if ip4 := ip.To4(); ip4 != nil {
-		copy(ip4[net.IPv4len-2:net.IPv4len], []byte{0, 0})
-	} else if len(ip) == net.IPv6len {
-		copy(ip[net.IPv6len-10:net.IPv6len], []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+func BenchmarkAnonymizeIP(b *testing.B) {
+	conf := &Config{
+		AnonymizeClientIP:       true,
+		AnonymizationPrefixIPv4: 16,
+		AnonymizationPrefixIPv6: 48,
 	}
-}
+	anonymize := conf.anonymizeFunc()
 
-func BenchmarkAnonymizeIP(b *testing.B) {
 	benchCases := []struct {
 		name string
 		ip   net.IP
@@ -321,17 +353,7 @@ func BenchmarkAnonymizeIP(b *testing.B) {
 			b.ReportAllocs()
 
 			for range b.N {
-				AnonymizeIP(bc.ip)
-			}
-
-			assert.Equal(b, bc.want, bc.ip)
-		})
-
-		b.Run(bc.name+"_slow", func(b *testing.B) {
-			b.ReportAllocs()
-
-			for range b.N {
-				anonymizeIPSlow(bc.ip)
+				anonymize(bc.ip)
 			}
 
 			assert.Equal(b, bc.want, bc.ip)
@@ -0,0 +1,90 @@
+package querylog
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/c2h5oh/datasize"
+)
+
+// DiskSpaceFunc returns the number of bytes available to the current user and
+// the total number of bytes on the filesystem containing path.
+type DiskSpaceFunc func(path string) (avail, total uint64, err error)
+
+// validateMinFreePercent returns an error if p isn't a valid percentage of the
+// free space.
+func validateMinFreePercent(p float64) (err error) {
+	if p < 0 || p >= 100 {
+		return fmt.Errorf("out of range [0, 100): %v", p)
+	}
+
+	return nil
+}
+
+// isLowSpace returns true if avail bytes out of total are below any of the
+// thresholds.  Zero thresholds aren't checked.
+func isLowSpace(avail, total uint64, minFree datasize.ByteSize, minPercent float64) (ok bool) {
+	if minFree > 0 && avail < uint64(minFree) {
+		return true
+	}
+
+	return minPercent > 0 && total > 0 && float64(avail)*100/float64(total) < minPercent
+}
+
+// checkDiskSpace checks the free space on the filesystem containing the log
+// files and pauses or resumes writing to the files accordingly.  The status
+// isn't changed if the free space can't be determined.
+func (l *queryLog) checkDiskSpace(ctx context.Context) {
+	var minFree datasize.ByteSize
+	var minPercent float64
+	var fileEnabled bool
+	func() {
+		l.confMu.RLock()
+		defer l.confMu.RUnlock()
+
+		minFree, minPercent = l.conf.MinFreeSpace, l.conf.MinFreeSpacePercent
+		fileEnabled = l.conf.FileEnabled
+	}()
+
+	if !fileEnabled || (minFree == 0 && minPercent == 0) {
+		l.setFilePaused(ctx, false, 0)
+
+		return
+	}
+
+	dir := filepath.Dir(l.logFile)
+	avail, total, err := l.diskSpace(dir)
+	if err != nil {
+		l.logger.ErrorContext(ctx, "checking disk space", "dir", dir, slogutil.KeyError, err)
+
+		return
+	}
+
+	l.setFilePaused(ctx, isLowSpace(avail, total, minFree, minPercent), avail)
+}
+
+// setFilePaused sets the pause status of writing to the log files and logs the
+// change, if any.  avail is the available space used for logging.
+func (l *queryLog) setFilePaused(ctx context.Context, paused bool, avail uint64) {
+	if l.filePaused.Swap(paused) == paused {
+		return
+	}
+
+	if paused {
+		l.logger.WarnContext(
+			ctx,
+			"low disk space; writing to log files paused, only keeping entries in memory",
+			"dir", filepath.Dir(l.logFile),
+			"avail", datasize.ByteSize(avail),
+		)
+	} else {
+		l.logger.InfoContext(ctx, "writing to log files resumed")
+	}
+}
+
+// FileLoggingPaused implements the [QueryLog] interface for *queryLog.
+func (l *queryLog) FileLoggingPaused() (paused bool) {
+	return l.filePaused.Load()
+}
@@ -0,0 +1,120 @@
+package querylog
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/c2h5oh/datasize"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryLog_checkDiskSpace(t *testing.T) {
+	const total = 10 * datasize.GB
+
+	var (
+		mu      = &sync.Mutex{}
+		avail   = total
+		gotPath string
+	)
+
+	baseDir := t.TempDir()
+	l, err := newQueryLog(Config{
+		Logger:      slogutil.NewDiscardLogger(),
+		Enabled:     true,
+		FileEnabled: true,
+		RotationIvl: timeutil.Day,
+		MemSize:     100,
+		BaseDir:     baseDir,
+		DiskSpace: func(path string) (a, tot uint64, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			gotPath = path
+
+			return uint64(avail), uint64(total), nil
+		},
+		MinFreeSpace:        100 * datasize.MB,
+		MinFreeSpacePercent: 5,
+	})
+	require.NoError(t, err)
+
+	setAvail := func(a datasize.ByteSize) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		avail = a
+	}
+
+	ctx := testutil.ContextWithTimeout(t, testTimeout)
+
+	l.checkDiskSpace(ctx)
+	require.False(t, l.FileLoggingPaused())
+
+	assert.Equal(t, baseDir, gotPath)
+
+	t.Run("absolute", func(t *testing.T) {
+		setAvail(50 * datasize.MB)
+		l.checkDiskSpace(ctx)
+
+		assert.True(t, l.FileLoggingPaused())
+
+		setAvail(total)
+		l.checkDiskSpace(ctx)
+
+		assert.False(t, l.FileLoggingPaused())
+	})
+
+	t.Run("percent", func(t *testing.T) {
+		setAvail(total / 100 * 4)
+		l.checkDiskSpace(ctx)
+
+		assert.True(t, l.FileLoggingPaused())
+
+		setAvail(total / 100 * 6)
+		l.checkDiskSpace(ctx)
+
+		assert.False(t, l.FileLoggingPaused())
+	})
+
+	t.Run("flush_paused", func(t *testing.T) {
+		setAvail(datasize.MB)
+		l.checkDiskSpace(ctx)
+		require.True(t, l.FileLoggingPaused())
+
+		addEntry(l, "example.org", net.IPv4(1, 1, 1, 1), net.IPv4(2, 2, 2, 1))
+		require.NoError(t, l.flushLogBuffer(ctx))
+
+		_, err = os.Stat(filepath.Join(baseDir, queryLogFileName))
+		require.ErrorIs(t, err, os.ErrNotExist)
+
+		assert.Equal(t, uint(1), l.buffer.Len())
+
+		setAvail(total)
+		l.checkDiskSpace(ctx)
+		require.False(t, l.FileLoggingPaused())
+
+		require.NoError(t, l.flushLogBuffer(ctx))
+
+		_, err = os.Stat(filepath.Join(baseDir, queryLogFileName))
+		require.NoError(t, err)
+
+		assert.Zero(t, l.buffer.Len())
+	})
+}
+
+func TestNewQueryLog_minFreeSpacePercent(t *testing.T) {
+	_, err := newQueryLog(Config{
+		Logger:              slogutil.NewDiscardLogger(),
+		RotationIvl:         timeutil.Day,
+		MinFreeSpacePercent: 100,
+	})
+
+	testutil.AssertErrorMsg(t, "min free space percent: out of range [0, 100): 100", err)
+}
@@ -30,6 +30,10 @@ type logEntry struct {
 
 	Upstream string `json:",omitempty"`
 
+	// UpstreamTier is the number of the failover tier of the upstream starting
+	// from 1, if any.
+	UpstreamTier int `json:",omitempty"`
+
 	Answer     []byte `json:",omitempty"`
 	OrigAnswer []byte `json:",omitempty"`
 
@@ -51,6 +55,19 @@ func (e *logEntry) shallowClone() (clone *logEntry) {
 	return &cloneVal
 }
 
+// rcode returns the response code of the response.  ok is false if there is no
+// response.  Only the header of the response is inspected, so the extended
+// response codes aren't supported.
+func (e *logEntry) rcode() (rcode int, ok bool) {
+	// The response code is the lowest four bits of the fourth byte of the
+	// header.  See RFC 1035, section 4.1.1.
+	if len(e.Answer) < 4 {
+		return 0, false
+	}
+
+	return int(e.Answer[3] & 0x0f), true
+}
+
 // addResponse adds data from resp to e.Answer if resp is not nil.  If isOrig is
 // true, addResponse sets the e.OrigAnswer field instead of e.Answer.  Any
 // errors are logged.
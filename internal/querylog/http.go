@@ -5,8 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"math"
-	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"slices"
 	"strconv"
@@ -18,6 +18,7 @@ import (
 	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
 	"github.com/AdguardTeam/golibs/logutil/slogutil"
 	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/miekg/dns"
 	"golang.org/x/net/idna"
 )
 
@@ -92,6 +93,8 @@ func (l *queryLog) handleQueryLog(w http.ResponseWriter, r *http.Request) {
 		entries, oldest = l.search(ctx, params)
 	}()
 
+	// Anonymize the addresses of the entries stored before the anonymization
+	// has been enabled as well.
 	resp := l.entriesToJSON(ctx, entries, oldest, l.anonymizer.Load())
 
 	aghhttp.WriteJSONResponseOK(w, r, resp)
@@ -145,20 +148,6 @@ func (l *queryLog) handleGetQueryLogConfig(w http.ResponseWriter, r *http.Reques
 	aghhttp.WriteJSONResponseOK(w, r, resp)
 }
 
-// AnonymizeIP masks ip to anonymize the client if the ip is a valid one.
-func AnonymizeIP(ip net.IP) {
-	// zeroes is a slice of zero bytes from which the IP address tail is copied.
-	// Using constant string as source of copying is more efficient than byte
-	// slice, see https://github.com/golang/go/issues/49997.
-	const zeroes = "\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"
-
-	if ip4 := ip.To4(); ip4 != nil {
-		copy(ip4[net.IPv4len-2:net.IPv4len], zeroes)
-	} else if len(ip) == net.IPv6len {
-		copy(ip[net.IPv6len-10:net.IPv6len], zeroes)
-	}
-}
-
 // handleQueryLogConfig is the handler for the POST /control/querylog_config
 // HTTP API.
 //
@@ -202,11 +191,7 @@ func (l *queryLog) handleQueryLogConfig(w http.ResponseWriter, r *http.Request)
 
 	if newConf.AnonymizeClientIP != aghalg.NBNull {
 		conf.AnonymizeClientIP = newConf.AnonymizeClientIP == aghalg.NBTrue
-		if conf.AnonymizeClientIP {
-			l.anonymizer.Store(AnonymizeIP)
-		} else {
-			l.anonymizer.Store(nil)
-		}
+		l.anonymizer.Store(conf.anonymizeFunc())
 	}
 
 	l.conf = &conf
@@ -262,11 +247,7 @@ func (l *queryLog) handlePutQueryLogConfig(w http.ResponseWriter, r *http.Reques
 	conf.Enabled = newConf.Enabled == aghalg.NBTrue
 
 	conf.AnonymizeClientIP = newConf.AnonymizeClientIP == aghalg.NBTrue
-	if conf.AnonymizeClientIP {
-		l.anonymizer.Store(AnonymizeIP)
-	} else {
-		l.anonymizer.Store(nil)
-	}
+	l.anonymizer.Store(conf.anonymizeFunc())
 
 	l.conf = &conf
 }
@@ -296,6 +277,9 @@ func (l *queryLog) parseSearchCriterion(
 	strict := getDoubleQuotesEnclosedValue(&val)
 
 	var asciiVal string
+	var addr netip.Addr
+	var elapsed time.Duration
+	var rcodes []int
 	switch ct {
 	case ctTerm:
 		// Decode lowercased value from punycode to make EqualFold and
@@ -314,24 +298,80 @@ func (l *queryLog) parseSearchCriterion(
 		if !slices.Contains(filteringStatusValues, val) {
 			return false, sc, fmt.Errorf("invalid value %s", val)
 		}
+	case ctClient, ctUpstream:
+		// Use the value as is.
+	case ctAnswerIP:
+		addr, err = netip.ParseAddr(val)
+		if err != nil {
+			return false, sc, fmt.Errorf("%s: %w", name, err)
+		}
+
+		addr = addr.Unmap()
+	case ctResponseTimeGTE, ctResponseTimeLTE:
+		var ms uint64
+		ms, err = strconv.ParseUint(val, 10, 32)
+		if err != nil {
+			return false, sc, fmt.Errorf("%s: %w", name, err)
+		}
+
+		elapsed = time.Duration(ms) * time.Millisecond
+	case ctRcode:
+		rcodes, err = parseRcodes(val)
+		if err != nil {
+			return false, sc, fmt.Errorf("%s: %w", name, err)
+		}
 	default:
 		return false, sc, fmt.Errorf(
 			"invalid criterion type %v: should be one of %v",
 			ct,
-			[]criterionType{ctTerm, ctFilteringStatus},
+			[]criterionType{
+				ctTerm,
+				ctFilteringStatus,
+				ctClient,
+				ctUpstream,
+				ctAnswerIP,
+				ctResponseTimeGTE,
+				ctResponseTimeLTE,
+				ctRcode,
+			},
 		)
 	}
 
 	sc = searchCriterion{
-		criterionType: ct,
+		addr:          addr,
 		value:         val,
 		asciiVal:      asciiVal,
+		elapsed:       elapsed,
+		rcodes:        rcodes,
+		criterionType: ct,
 		strict:        strict,
 	}
 
 	return true, sc, nil
 }
 
+// parseRcodes parses the comma-separated list of the DNS response codes.  Each
+// code is either a case-insensitive name, such as "NXDOMAIN", or a number.
+func parseRcodes(val string) (rcodes []int, err error) {
+	for _, s := range strings.Split(val, ",") {
+		s = strings.TrimSpace(s)
+		rcode, ok := dns.StringToRcode[strings.ToUpper(s)]
+		if !ok {
+			var n uint64
+			n, err = strconv.ParseUint(s, 10, 4)
+			if err != nil {
+				return nil, fmt.Errorf("bad rcode %q", s)
+			}
+
+			rcode = int(n)
+		}
+
+		rcodes = append(rcodes, rcode)
+	}
+
+	return rcodes, nil
+}
+
 // parseSearchParams parses search parameters from the HTTP request's query
 // string.
 func (l *queryLog) parseSearchParams(
@@ -372,6 +412,24 @@ func (l *queryLog) parseSearchParams(
 	}, {
 		urlField: "response_status",
 		ct:       ctFilteringStatus,
+	}, {
+		urlField: "client",
+		ct:       ctClient,
+	}, {
+		urlField: "upstream",
+		ct:       ctUpstream,
+	}, {
+		urlField: "answer_ip",
+		ct:       ctAnswerIP,
+	}, {
+		urlField: "response_time_gte",
+		ct:       ctResponseTimeGTE,
+	}, {
+		urlField: "response_time_lte",
+		ct:       ctResponseTimeLTE,
+	}, {
+		urlField: "rcode",
+		ct:       ctRcode,
 	}} {
 		var ok bool
 		var c searchCriterion
@@ -385,5 +443,17 @@ func (l *queryLog) parseSearchParams(
 		}
 	}
 
+	if filterType := q.Get("filter_type"); filterType != "" {
+		status, ok := filterTypeToStatus[filterType]
+		if !ok {
+			return nil, fmt.Errorf("filter_type: invalid value %s", filterType)
+		}
+
+		p.searchCriteria = append(p.searchCriteria, searchCriterion{
+			value:         status,
+			criterionType: ctFilteringStatus,
+		})
+	}
+
 	return p, nil
 }
@@ -19,7 +19,8 @@ import (
 // jobject is a JSON object alias.
 type jobject = map[string]any
 
-// entriesToJSON converts query log entries to JSON.
+// entriesToJSON converts query log entries to JSON.  anonFunc, if not nil,
+// anonymizes the clients' IP addresses.
 func (l *queryLog) entriesToJSON(
 	ctx context.Context,
 	entries []*logEntry,
@@ -70,7 +71,9 @@ func (l *queryLog) entryToJSON(
 	}
 
 	entIP := slices.Clone(entry.IP)
-	anonFunc(entIP)
+	if anonFunc != nil {
+		anonFunc(entIP)
+	}
 
 	jsonEntry = jobject{
 		"reason":       entry.Result.Reason.String(),
@@ -96,6 +99,10 @@ func (l *queryLog) entryToJSON(
 		jsonEntry["ecs"] = entry.ReqECS
 	}
 
+	if entry.UpstreamTier != 0 {
+		jsonEntry["upstream_tier"] = entry.UpstreamTier
+	}
+
 	if len(entry.Result.Rules) > 0 {
 		if r := entry.Result.Rules[0]; len(r.Text) > 0 {
 			jsonEntry["rule"] = r.Text
@@ -107,6 +114,10 @@ func (l *queryLog) entryToJSON(
 		jsonEntry["service_name"] = entry.Result.ServiceName
 	}
 
+	if dd := entry.Result.DecisionDetail; dd != nil {
+		jsonEntry["decision_detail"] = decisionDetailToJSON(dd)
+	}
+
 	l.setMsgData(ctx, entry, jsonEntry)
 	l.setOrigAns(ctx, entry, jsonEntry)
 
@@ -168,15 +179,43 @@ func (l *queryLog) setOrigAns(ctx context.Context, entry *logEntry, jsonEntry jo
 func resultRulesToJSONRules(rules []*filtering.ResultRule) (jsonRules []jobject) {
 	jsonRules = make([]jobject, len(rules))
 	for i, r := range rules {
-		jsonRules[i] = jobject{
-			"filter_list_id": r.FilterListID,
-			"text":           r.Text,
-		}
+		jsonRules[i] = resultRuleToJSON(r)
 	}
 
 	return jsonRules
 }
 
+// resultRuleToJSON converts the rule into an object for the JSON API.  r must
+// not be nil.
+func resultRuleToJSON(r *filtering.ResultRule) (jsonRule jobject) {
+	return jobject{
+		"filter_list_id": r.FilterListID,
+		"text":           r.Text,
+	}
+}
+
+// decisionDetailToJSON converts the decision detail into an object for the JSON
+// API.  dd must not be nil.
+func decisionDetailToJSON(dd *filtering.DecisionDetail) (jsonDD jobject) {
+	jsonDD = jobject{
+		"mechanism": dd.Mechanism,
+	}
+
+	if dd.CandidateRule != nil {
+		jsonDD["candidate_rule"] = resultRuleToJSON(dd.CandidateRule)
+	}
+
+	if dd.OverridingRule != nil {
+		jsonDD["overriding_rule"] = resultRuleToJSON(dd.OverridingRule)
+	}
+
+	if dd.ServiceName != "" {
+		jsonDD["service_name"] = dd.ServiceName
+	}
+
+	return jsonDD
+}
+
 type dnsAnswer struct {
 	Type  string `json:"type"`
 	Value string `json:"value"`
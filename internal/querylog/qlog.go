@@ -7,6 +7,7 @@ import (
 	"log/slog"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
@@ -36,6 +37,14 @@ type queryLog struct {
 
 	findClient func(ids []string) (c *Client, err error)
 
+	// diskSpace returns the free and the total space of the filesystem
+	// containing the log files.
+	diskSpace DiskSpaceFunc
+
+	// remote forwards the entries written to the log files to a remote log
+	// server.  It's nil if the forwarding is disabled.
+	remote *remoteLogWriter
+
 	// buffer contains recent log entries.  The entries in this buffer must not
 	// be modified.
 	buffer *container.RingBuffer[*logEntry]
@@ -50,6 +59,10 @@ type queryLog struct {
 	fileFlushLock sync.Mutex
 	fileWriteLock sync.Mutex
 
+	// filePaused is true if writing to the log files is paused due to the low
+	// disk space.
+	filePaused atomic.Bool
+
 	flushPending bool
 }
 
@@ -93,6 +106,10 @@ func (l *queryLog) Start(ctx context.Context) (err error) {
 
 	go l.periodicRotate(ctx)
 
+	if l.remote != nil {
+		go l.remote.run(ctx)
+	}
+
 	return nil
 }
 
@@ -103,13 +120,15 @@ func (l *queryLog) Shutdown(ctx context.Context) (err error) {
 
 	if l.conf.FileEnabled {
 		err = l.flushLogBuffer(ctx)
-		if err != nil {
-			// Don't wrap the error because it's informative enough as is.
-			return err
-		}
 	}
 
-	return nil
+	if l.remote != nil {
+		// Shut down the remote log after flushing, so that the flushed entries
+		// are sent as well.
+		err = errors.Join(err, l.remote.shutdown(ctx))
+	}
+
+	return err
 }
 
 func checkInterval(ivl time.Duration) (ok bool) {
@@ -194,8 +213,9 @@ func newLogEntry(ctx context.Context, logger *slog.Logger, params *AddParams) (e
 		ClientID:    params.ClientID,
 		ClientProto: params.ClientProto,
 
-		Result:   *params.Result,
-		Upstream: params.Upstream,
+		Result:       *params.Result,
+		Upstream:     params.Upstream,
+		UpstreamTier: params.UpstreamTier,
 
 		IP: params.ClientIP,
 
@@ -252,7 +272,7 @@ func (l *queryLog) Add(params *AddParams) {
 
 	l.buffer.Push(entry)
 
-	if !l.flushPending && fileIsEnabled && l.buffer.Len() >= memSize {
+	if !l.flushPending && fileIsEnabled && !l.filePaused.Load() && l.buffer.Len() >= memSize {
 		l.flushPending = true
 
 		// TODO(s.chzhen):  Fix occasional rewrite of entires.
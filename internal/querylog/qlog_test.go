@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net"
 	"testing"
+	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
@@ -36,7 +37,7 @@ func TestQueryLog(t *testing.T) {
 	require.NoError(t, l.flushLogBuffer(ctx))
 
 	// Start writing to the second file.
-	require.NoError(t, l.rotate(ctx))
+	require.NoError(t, l.rotate(ctx, nil))
 
 	// Add disk entries.
 	addEntry(l, "example.org", net.IPv4(1, 1, 1, 2), net.IPv4(2, 2, 2, 2))
@@ -267,6 +268,57 @@ func TestQueryLogFileDisabled(t *testing.T) {
 	assert.Equal(t, "example2.org", ll[1].QHost)
 }
 
+func TestQueryLog_RangeQueries(t *testing.T) {
+	l, err := newQueryLog(Config{
+		Logger:      slogutil.NewDiscardLogger(),
+		Enabled:     true,
+		FileEnabled: true,
+		RotationIvl: timeutil.Day,
+		MemSize:     100,
+		BaseDir:     t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	ctx := testutil.ContextWithTimeout(t, testTimeout)
+	start := time.Now()
+
+	// Add disk entries.
+	addEntry(l, "example.org", net.IPv4(1, 1, 1, 1), net.IPv4(2, 2, 2, 1))
+	require.NoError(t, l.flushLogBuffer(ctx))
+
+	// Add memory entries.
+	addEntry(l, "example.com", net.IPv4(1, 1, 1, 2), net.IPv4(2, 2, 2, 2))
+	addEntry(l, "example.net", net.IPv4(1, 1, 1, 3), net.IPv4(2, 2, 2, 3))
+
+	type query struct {
+		host   string
+		client string
+	}
+
+	collect := func(since time.Time, limit int) (queries []query) {
+		rangeErr := l.RangeQueries(ctx, since, func(host, client string) (cont bool) {
+			queries = append(queries, query{host: host, client: client})
+
+			return len(queries) < limit
+		})
+		require.NoError(t, rangeErr)
+
+		return queries
+	}
+
+	assert.Equal(t, []query{
+		{host: "example.net", client: "2.2.2.3"},
+		{host: "example.com", client: "2.2.2.2"},
+		{host: "example.org", client: "2.2.2.1"},
+	}, collect(start.Add(-time.Minute), 10))
+
+	assert.Equal(t, []query{
+		{host: "example.net", client: "2.2.2.3"},
+	}, collect(start.Add(-time.Minute), 1))
+
+	assert.Empty(t, collect(time.Now().Add(time.Minute), 10))
+}
+
 func TestQueryLogShouldLog(t *testing.T) {
 	const (
 		ignored1        = "ignor.ed"
@@ -6,6 +6,7 @@ import (
 	"io"
 	"log/slog"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -389,6 +390,26 @@ func readJSONValue(s, prefix string) string {
 	return s[start:end]
 }
 
+// readJSONNumber reads a JSON integer in form of '"key":123'.  prefix must be
+// of the form '"key":'.  ok is false if there is no such key or the value isn't
+// an integer.
+func readJSONNumber(s, prefix string) (v int64, ok bool) {
+	i := strings.Index(s, prefix)
+	if i == -1 {
+		return 0, false
+	}
+
+	start := i + len(prefix)
+	end := start
+	for end < len(s) && (s[end] == '-' || (s[end] >= '0' && s[end] <= '9')) {
+		end++
+	}
+
+	v, err := strconv.ParseInt(s[start:end], 10, 64)
+
+	return v, err == nil
+}
+
 // readQLogTimestamp reads the timestamp field from the query log line.
 func readQLogTimestamp(ctx context.Context, logger *slog.Logger, str string) int64 {
 	val := readJSONValue(str, `"T":"`)
@@ -0,0 +1,98 @@
+package querylog
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+)
+
+// RangeQueries implements the [QueryLog] interface for *queryLog.
+func (l *queryLog) RangeQueries(
+	ctx context.Context,
+	since time.Time,
+	f func(host, client string) (cont bool),
+) (err error) {
+	l.confMu.RLock()
+	defer l.confMu.RUnlock()
+
+	cont := l.rangeMemory(since, f)
+	if !cont || !l.conf.FileEnabled {
+		return nil
+	}
+
+	return l.rangeFiles(ctx, since, f)
+}
+
+// rangeMemory calls f for the entries of the memory buffer logged since the
+// given time.  cont is false if f returned false or the older entries have
+// been found.  l.confMu is expected to be locked.
+func (l *queryLog) rangeMemory(since time.Time, f func(host, client string) (cont bool)) (cont bool) {
+	if l.conf.MemSize == 0 {
+		return true
+	}
+
+	l.bufferLock.Lock()
+	defer l.bufferLock.Unlock()
+
+	cont = true
+	l.buffer.ReverseRange(func(e *logEntry) (ok bool) {
+		if e.Time.Before(since) {
+			cont = false
+		} else {
+			cont = f(e.QHost, entryClient(e))
+		}
+
+		return cont
+	})
+
+	return cont
+}
+
+// rangeFiles calls f for the entries of the log files logged since the given
+// time.  l.confMu is expected to be locked.
+func (l *queryLog) rangeFiles(
+	ctx context.Context,
+	since time.Time,
+	f func(host, client string) (cont bool),
+) (err error) {
+	r, err := l.setQLogReader(ctx, time.Time{})
+	if err != nil {
+		return fmt.Errorf("opening files: %w", err)
+	} else if r == nil {
+		return nil
+	}
+	defer func() { err = errors.WithDeferred(err, r.Close()) }()
+
+	for {
+		var line string
+		line, err = r.ReadNext()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("reading entry: %w", err)
+		}
+
+		e := &logEntry{}
+		l.decodeLogEntry(ctx, e, line)
+		if e.Time.Before(since) {
+			return nil
+		}
+
+		if !f(e.QHost, entryClient(e)) {
+			return nil
+		}
+	}
+}
+
+// entryClient returns the identifier of the client of e, which is the ClientID
+// if there is one or the IP address otherwise.
+func entryClient(e *logEntry) (client string) {
+	if e.ClientID != "" {
+		return e.ClientID
+	}
+
+	return e.IP.String()
+}
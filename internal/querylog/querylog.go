@@ -1,6 +1,7 @@
 package querylog
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"net"
@@ -10,10 +11,12 @@ import (
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
+	"github.com/AdguardTeam/AdGuardHome/internal/aghos"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
 	"github.com/AdguardTeam/golibs/container"
 	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/service"
+	"github.com/c2h5oh/datasize"
 	"github.com/miekg/dns"
 )
 
@@ -28,8 +31,21 @@ type QueryLog interface {
 	// WriteDiskConfig writes the query log configuration to c.
 	WriteDiskConfig(c *Config)
 
+	// FileLoggingPaused returns true if writing to the log files is paused due
+	// to the low disk space.
+	FileLoggingPaused() (paused bool)
+
 	// ShouldLog returns true if request for the host should be logged.
 	ShouldLog(host string, qType, qClass uint16, ids []string) bool
+
+	// RangeQueries calls f for the host and the client of each query logged
+	// since the given time, from the newest to the oldest, until f returns
+	// false.  client is the ClientID, if any, or the IP address of the client.
+	RangeQueries(
+		ctx context.Context,
+		since time.Time,
+		f func(host, client string) (cont bool),
+	) (err error)
 }
 
 // Config is the query log configuration structure.
@@ -44,7 +60,11 @@ type Config struct {
 	// log, and matches them.
 	Ignored *aghnet.IgnoreEngine
 
-	// Anonymizer processes the IP addresses to anonymize those if needed.
+	// Anonymizer processes the IP addresses to anonymize those if needed.  It
+	// is shared with the DNS server, which anonymizes the clients' addresses
+	// before adding the entries, and is set by the query log according to
+	// AnonymizeClientIP and the anonymization prefixes.  If nil, a new one is
+	// used.
 	Anonymizer *aghnet.IPMut
 
 	// ConfigModified is called when the configuration is changed, for example
@@ -57,6 +77,16 @@ type Config struct {
 	// FindClient returns client information by their IDs.
 	FindClient func(ids []string) (c *Client, err error)
 
+	// ClientRetention returns the own retention periods of the clients, which
+	// override RotationIvl.  The entries of such clients are removed once
+	// their retention periods expire and kept in the old log file during
+	// rotation until then.  If nil, only RotationIvl is used.
+	ClientRetention ClientRetentionFunc
+
+	// DiskSpace returns the free and the total space of the filesystem
+	// containing the log files.  If nil, [aghos.DiskSpace] is used.
+	DiskSpace DiskSpaceFunc
+
 	// BaseDir is the base directory for log files.
 	BaseDir string
 
@@ -69,6 +99,31 @@ type Config struct {
 	// flushed to disk.
 	MemSize uint
 
+	// MinFreeSpace is the minimum free space on the filesystem containing the
+	// log files.  When the free space is below it, writing to the files is
+	// paused, and the entries are only kept in memory.  If zero, it isn't
+	// checked.
+	MinFreeSpace datasize.ByteSize
+
+	// MinFreeSpacePercent is the same as MinFreeSpace but in percents of the
+	// total space of the filesystem.  It must be less than 100.  If zero, it
+	// isn't checked.
+	MinFreeSpacePercent float64
+
+	// AnonymizationPrefixIPv4 is the length of the prefix of the clients' IPv4
+	// addresses kept when anonymizing them.  If zero,
+	// [DefaultAnonymizationPrefixIPv4] is used.
+	AnonymizationPrefixIPv4 int
+
+	// AnonymizationPrefixIPv6 is the length of the prefix of the clients' IPv6
+	// addresses kept when anonymizing them.  If zero,
+	// [DefaultAnonymizationPrefixIPv6] is used.
+	AnonymizationPrefixIPv6 int
+
+	// Remote is the configuration of forwarding the entries written to the log
+	// files to a remote log server.
+	Remote RemoteLogConfig
+
 	// Enabled tells if the query log is enabled.
 	Enabled bool
 
@@ -76,7 +131,8 @@ type Config struct {
 	FileEnabled bool
 
 	// AnonymizeClientIP tells if the query log should anonymize clients' IP
-	// addresses.
+	// addresses.  The addresses are anonymized before the entries are added,
+	// so the original ones are never written to the log files.
 	AnonymizeClientIP bool
 }
 
@@ -103,6 +159,10 @@ type AddParams struct {
 	// Upstream is the URL of the upstream DNS server.
 	Upstream string
 
+	// UpstreamTier is the number of the failover tier of the upstream DNS
+	// server starting from 1.  It's zero if the upstream mode isn't failover.
+	UpstreamTier int
+
 	ClientProto ClientProto
 
 	ClientIP net.IP
@@ -154,9 +214,15 @@ func newQueryLog(conf Config) (l *queryLog, err error) {
 		memSize = 1
 	}
 
+	diskSpace := conf.DiskSpace
+	if diskSpace == nil {
+		diskSpace = aghos.DiskSpace
+	}
+
 	l = &queryLog{
 		logger:     conf.Logger,
 		findClient: findClient,
+		diskSpace:  diskSpace,
 
 		buffer: container.NewRingBuffer[*logEntry](memSize),
 
@@ -167,6 +233,10 @@ func newQueryLog(conf Config) (l *queryLog, err error) {
 		anonymizer: conf.Anonymizer,
 	}
 
+	if l.anonymizer == nil {
+		l.anonymizer = aghnet.NewIPMut(nil)
+	}
+
 	*l.conf = conf
 
 	err = validateIvl(conf.RotationIvl)
@@ -174,5 +244,27 @@ func newQueryLog(conf Config) (l *queryLog, err error) {
 		return nil, fmt.Errorf("unsupported interval: %w", err)
 	}
 
+	err = validateMinFreePercent(conf.MinFreeSpacePercent)
+	if err != nil {
+		return nil, fmt.Errorf("min free space percent: %w", err)
+	}
+
+	err = l.conf.setAnonymizationPrefixes()
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+
+	l.anonymizer.Store(l.conf.anonymizeFunc())
+
+	err = conf.Remote.validate()
+	if err != nil {
+		return nil, fmt.Errorf("remote: %w", err)
+	}
+
+	if conf.Remote.Enabled {
+		l.remote = newRemoteLogWriter(conf.Logger.With("remote", conf.Remote.Format), &conf.Remote)
+	}
+
 	return l, nil
 }
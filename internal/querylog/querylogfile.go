@@ -19,27 +19,44 @@ import (
 func (l *queryLog) flushLogBuffer(ctx context.Context) (err error) {
 	defer func() { err = errors.Annotate(err, "flushing log buffer: %w") }()
 
+	if l.filePaused.Load() {
+		// Keep the entries in memory until there is enough disk space.
+		return nil
+	}
+
 	l.fileFlushLock.Lock()
 	defer l.fileFlushLock.Unlock()
 
-	b, err := l.encodeEntries(ctx)
+	b, entries, err := l.encodeEntries(ctx)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+
+	err = l.flushToFile(ctx, b)
 	if err != nil {
 		// Don't wrap the error since it's informative enough as is.
 		return err
 	}
 
-	return l.flushToFile(ctx, b)
+	if l.remote != nil {
+		l.remote.write(ctx, entries)
+	}
+
+	return nil
 }
 
 // encodeEntries returns JSON encoded log entries, logs estimated time, clears
-// the log buffer.
-func (l *queryLog) encodeEntries(ctx context.Context) (b *bytes.Buffer, err error) {
+// the log buffer.  entries are only returned if the remote log is enabled.
+func (l *queryLog) encodeEntries(
+	ctx context.Context,
+) (b *bytes.Buffer, entries []*logEntry, err error) {
 	l.bufferLock.Lock()
 	defer l.bufferLock.Unlock()
 
 	bufLen := l.buffer.Len()
 	if bufLen == 0 {
-		return nil, errors.Error("nothing to write to a file")
+		return nil, nil, errors.Error("nothing to write to a file")
 	}
 
 	start := time.Now()
@@ -49,13 +66,16 @@ func (l *queryLog) encodeEntries(ctx context.Context) (b *bytes.Buffer, err erro
 
 	l.buffer.Range(func(entry *logEntry) (cont bool) {
 		err = e.Encode(entry)
+		if l.remote != nil {
+			entries = append(entries, entry)
+		}
 
 		return err == nil
 	})
 
 	if err != nil {
 		// Don't wrap the error since it's informative enough as is.
-		return nil, err
+		return nil, nil, err
 	}
 
 	size := b.Len()
@@ -73,7 +93,7 @@ func (l *queryLog) encodeEntries(ctx context.Context) (b *bytes.Buffer, err erro
 	l.buffer.Clear()
 	l.flushPending = false
 
-	return b, nil
+	return b, entries, nil
 }
 
 // flushToFile saves the encoded log entries to the query log file.
@@ -100,11 +120,22 @@ func (l *queryLog) flushToFile(ctx context.Context, b *bytes.Buffer) (err error)
 	return nil
 }
 
-func (l *queryLog) rotate(ctx context.Context) error {
+// rotate renames the current log file into the old one.  If r is not nil, the
+// entries of the old file, which own retention periods of their clients haven't
+// expired yet, are moved to the beginning of the new old file.
+func (l *queryLog) rotate(ctx context.Context, r *clientRetentions) (err error) {
 	from := l.logFile
 	to := l.logFile + ".1"
 
-	err := os.Rename(from, to)
+	var retained []string
+	if r != nil {
+		retained, err = retainedLines(to, r)
+		if err != nil {
+			return fmt.Errorf("reading retained entries: %w", err)
+		}
+	}
+
+	err = os.Rename(from, to)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
 			l.logger.DebugContext(ctx, "no log to rotate")
@@ -117,6 +148,17 @@ func (l *queryLog) rotate(ctx context.Context) error {
 
 	l.logger.DebugContext(ctx, "renamed log file", "from", from, "to", to)
 
+	if len(retained) == 0 {
+		return nil
+	}
+
+	err = prependLines(to, retained)
+	if err != nil {
+		return fmt.Errorf("keeping retained entries: %w", err)
+	}
+
+	l.logger.DebugContext(ctx, "kept entries by client retention", "count", len(retained))
+
 	return nil
 }
 
@@ -150,6 +192,7 @@ func (l *queryLog) readFileFirstTimeValue(ctx context.Context) (first time.Time,
 func (l *queryLog) periodicRotate(ctx context.Context) {
 	defer slogutil.RecoverAndLog(ctx, l.logger)
 
+	l.checkDiskSpace(ctx)
 	l.checkAndRotate(ctx)
 
 	// rotationCheckIvl is the period of time between checking the need for
@@ -162,22 +205,47 @@ func (l *queryLog) periodicRotate(ctx context.Context) {
 	rotations := time.NewTicker(rotationCheckIvl)
 	defer rotations.Stop()
 
-	for range rotations.C {
-		l.checkAndRotate(ctx)
+	// diskSpaceCheckIvl is the period of time between checking the free space
+	// on the filesystem containing the log files.
+	const diskSpaceCheckIvl = 1 * time.Minute
+
+	spaceChecks := time.NewTicker(diskSpaceCheckIvl)
+	defer spaceChecks.Stop()
+
+	for {
+		select {
+		case <-rotations.C:
+			l.checkDiskSpace(ctx)
+			l.checkAndRotate(ctx)
+		case <-spaceChecks.C:
+			l.checkDiskSpace(ctx)
+		}
 	}
 }
 
 // checkAndRotate rotates log files if those are older than the specified
-// rotation interval.
+// rotation interval.  It also removes the entries of the clients with expired
+// own retention periods.
 func (l *queryLog) checkAndRotate(ctx context.Context) {
 	var rotationIvl time.Duration
+	var clientRetention ClientRetentionFunc
 	func() {
 		l.confMu.RLock()
 		defer l.confMu.RUnlock()
 
 		rotationIvl = l.conf.RotationIvl
+		clientRetention = l.conf.ClientRetention
 	}()
 
+	l.fileWriteLock.Lock()
+	defer l.fileWriteLock.Unlock()
+
+	var r *clientRetentions
+	if clientRetention != nil {
+		r = newClientRetentions(clientRetention, time.Now())
+		l.pruneClients(ctx, r)
+	}
+
 	oldest, err := l.readFileFirstTimeValue(ctx)
 	if err != nil && !errors.Is(err, os.ErrNotExist) {
 		l.logger.ErrorContext(ctx, "reading oldest record for rotation", slogutil.KeyError, err)
@@ -196,7 +264,7 @@ func (l *queryLog) checkAndRotate(ctx context.Context) {
 		return
 	}
 
-	err = l.rotate(ctx)
+	err = l.rotate(ctx, r)
 	if err != nil {
 		l.logger.ErrorContext(ctx, "rotating", slogutil.KeyError, err)
 
@@ -0,0 +1,628 @@
+package querylog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghhttp"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/httphdr"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/miekg/dns"
+)
+
+// Remote log formats.
+const (
+	// RemoteLogFormatGELF is the Graylog Extended Log Format.  The messages are
+	// sent over TCP, separated by the null bytes, or over UDP, one message per
+	// datagram.
+	RemoteLogFormatGELF = "gelf"
+
+	// RemoteLogFormatLoki is the format of the push HTTP API of Grafana Loki.
+	RemoteLogFormatLoki = "loki"
+)
+
+// Remote log protocols.
+const (
+	RemoteLogProtocolTCP = "tcp"
+	RemoteLogProtocolUDP = "udp"
+)
+
+// DefaultRemoteLogBatchSize is the default number of the entries sent to the
+// remote log server at once.
+const DefaultRemoteLogBatchSize = 100
+
+const (
+	// remoteLogTimeout is the timeout of connecting to and sending a single
+	// batch to the remote log server.
+	remoteLogTimeout = 10 * time.Second
+
+	// remoteLogMaxRetries is the maximum number of retries of sending a batch.
+	remoteLogMaxRetries = 3
+
+	// defaultRemoteLogBackoff is the default interval before the first retry
+	// of sending a batch.  It's doubled for each subsequent retry.
+	defaultRemoteLogBackoff = 1 * time.Second
+
+	// remoteLogFlushIvl is the interval, after which an incomplete batch is
+	// sent anyway.
+	remoteLogFlushIvl = 10 * time.Second
+
+	// remoteLogQueueBatches is the number of the batches the queue of the
+	// entries to send can hold.
+	remoteLogQueueBatches = 10
+
+	// lokiPushPath is the path of the push HTTP API of Grafana Loki.
+	lokiPushPath = "/loki/api/v1/push"
+)
+
+// RemoteLogConfig is the configuration of forwarding the query log entries to
+// a centralized log management system.  The entries are only forwarded after
+// they're written to the log files.
+type RemoteLogConfig struct {
+	// Protocol is the transport protocol, either [RemoteLogProtocolTCP] or
+	// [RemoteLogProtocolUDP].  [RemoteLogFormatLoki] only supports the former.
+	Protocol string `yaml:"protocol"`
+
+	// Address is the address of the server.  For [RemoteLogFormatGELF], it's
+	// the host and the port of the GELF input.  For [RemoteLogFormatLoki], it's
+	// either the host and the port of the HTTP API or the full URL of the push
+	// API.
+	Address string `yaml:"address"`
+
+	// Format is the format of the messages, either [RemoteLogFormatGELF] or
+	// [RemoteLogFormatLoki].
+	Format string `yaml:"format"`
+
+	// BatchSize is the number of the entries sent at once.  If zero,
+	// [DefaultRemoteLogBatchSize] is used.
+	BatchSize int `yaml:"batch_size"`
+
+	// Enabled defines if the entries are forwarded.
+	Enabled bool `yaml:"enabled"`
+}
+
+// validate returns an error if the enabled configuration isn't valid.
+func (c *RemoteLogConfig) validate() (err error) {
+	if !c.Enabled {
+		return nil
+	}
+
+	switch c.Format {
+	case RemoteLogFormatGELF:
+		if c.Protocol != RemoteLogProtocolTCP && c.Protocol != RemoteLogProtocolUDP {
+			return fmt.Errorf("protocol: %w: %q", errors.ErrBadEnumValue, c.Protocol)
+		}
+	case RemoteLogFormatLoki:
+		if c.Protocol != RemoteLogProtocolTCP {
+			return fmt.Errorf("protocol: %q is not supported by format %q", c.Protocol, c.Format)
+		}
+	default:
+		return fmt.Errorf("format: %w: %q", errors.ErrBadEnumValue, c.Format)
+	}
+
+	if c.Address == "" {
+		return fmt.Errorf("address: %w", errors.ErrEmptyValue)
+	} else if c.BatchSize < 0 {
+		return fmt.Errorf("batch_size: %w: %d", errors.ErrNegative, c.BatchSize)
+	}
+
+	return nil
+}
+
+// remoteLogSender sends the batches of the entries to a remote log server.
+type remoteLogSender interface {
+	// send sends the entries of batch.  It must not retain batch.
+	send(ctx context.Context, batch []*logEntry) (err error)
+
+	// close releases the resources of the sender.
+	close() (err error)
+}
+
+// remoteLogWriter forwards the written query log entries to a remote log
+// server in batches.
+type remoteLogWriter struct {
+	// logger is used for logging the operation of the writer.
+	logger *slog.Logger
+
+	// sender sends the batches in the configured format.
+	sender remoteLogSender
+
+	// entries is the queue of the entries to send.
+	entries chan *logEntry
+
+	// stop is closed to stop the sending goroutine.
+	stop chan struct{}
+
+	// done is closed once the sending goroutine has stopped.
+	done chan struct{}
+
+	// backoff is the interval before the first retry of sending a batch.
+	backoff time.Duration
+
+	// flushIvl is the interval, after which an incomplete batch is sent.
+	flushIvl time.Duration
+
+	// batchSize is the number of the entries sent at once.
+	batchSize int
+}
+
+// newRemoteLogWriter returns a new properly initialized *remoteLogWriter.  c
+// must be valid and enabled.
+func newRemoteLogWriter(logger *slog.Logger, c *RemoteLogConfig) (w *remoteLogWriter) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		logger.Debug("getting hostname", slogutil.KeyError, err)
+
+		hostname = "adguardhome"
+	}
+
+	var sender remoteLogSender
+	if c.Format == RemoteLogFormatLoki {
+		sender = &lokiSender{
+			client: &http.Client{
+				Timeout: remoteLogTimeout,
+			},
+			url:      lokiPushURL(c.Address),
+			hostname: hostname,
+		}
+	} else {
+		sender = &gelfSender{
+			network:  c.Protocol,
+			addr:     c.Address,
+			hostname: hostname,
+		}
+	}
+
+	batchSize := c.BatchSize
+	if batchSize == 0 {
+		batchSize = DefaultRemoteLogBatchSize
+	}
+
+	return &remoteLogWriter{
+		logger:    logger,
+		sender:    sender,
+		entries:   make(chan *logEntry, batchSize*remoteLogQueueBatches),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+		backoff:   defaultRemoteLogBackoff,
+		flushIvl:  remoteLogFlushIvl,
+		batchSize: batchSize,
+	}
+}
+
+// lokiPushURL returns the URL of the push API of Grafana Loki from addr.
+func lokiPushURL(addr string) (u string) {
+	if strings.Contains(addr, "://") {
+		return addr
+	}
+
+	return "http://" + addr + lokiPushPath
+}
+
+// write queues entries for sending.  It doesn't block, and the entries, which
+// don't fit into the queue, are dropped.
+func (w *remoteLogWriter) write(ctx context.Context, entries []*logEntry) {
+	dropped := 0
+	for _, e := range entries {
+		select {
+		case w.entries <- e:
+		default:
+			dropped++
+		}
+	}
+
+	if dropped > 0 {
+		w.logger.WarnContext(ctx, "remote log queue is full", "dropped", dropped)
+	}
+}
+
+// run sends the queued entries until the writer is shut down.  It is intended
+// to be used as a goroutine.
+func (w *remoteLogWriter) run(ctx context.Context) {
+	defer slogutil.RecoverAndLog(ctx, w.logger)
+	defer close(w.done)
+
+	flushes := time.NewTicker(w.flushIvl)
+	defer flushes.Stop()
+
+	batch := make([]*logEntry, 0, w.batchSize)
+	for {
+		select {
+		case e := <-w.entries:
+			batch = append(batch, e)
+			if len(batch) >= w.batchSize {
+				w.send(ctx, batch)
+				batch = batch[:0]
+			}
+		case <-flushes.C:
+			if len(batch) > 0 {
+				w.send(ctx, batch)
+				batch = batch[:0]
+			}
+		case <-w.stop:
+			w.flushQueue(ctx, batch)
+
+			return
+		}
+	}
+}
+
+// flushQueue sends batch along with the rest of the queued entries and closes
+// the sender.
+func (w *remoteLogWriter) flushQueue(ctx context.Context, batch []*logEntry) {
+	for drained := false; !drained; {
+		select {
+		case e := <-w.entries:
+			batch = append(batch, e)
+		default:
+			drained = true
+		}
+
+		if len(batch) >= w.batchSize || (drained && len(batch) > 0) {
+			w.send(ctx, batch)
+			batch = batch[:0]
+		}
+	}
+
+	err := w.sender.close()
+	if err != nil {
+		w.logger.DebugContext(ctx, "closing sender", slogutil.KeyError, err)
+	}
+}
+
+// send sends batch retrying the transient errors with exponential backoff.  If
+// all the attempts fail, the batch is dropped.
+func (w *remoteLogWriter) send(ctx context.Context, batch []*logEntry) {
+	var err error
+	backoff := w.backoff
+	for i := 0; ; i++ {
+		err = w.sender.send(ctx, batch)
+		if err == nil {
+			w.logger.DebugContext(ctx, "sent batch", "count", len(batch))
+
+			return
+		} else if i == remoteLogMaxRetries || !isTransientRemoteLogErr(err) {
+			break
+		}
+
+		w.logger.DebugContext(
+			ctx,
+			"sending batch",
+			"attempt", i+1,
+			"backoff", backoff,
+			slogutil.KeyError, err,
+		)
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+
+	w.logger.ErrorContext(ctx, "dropping batch", "count", len(batch), slogutil.KeyError, err)
+}
+
+// shutdown sends the queued entries and stops the sending goroutine.
+func (w *remoteLogWriter) shutdown(ctx context.Context) (err error) {
+	close(w.stop)
+
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("shutting down remote log: %w", ctx.Err())
+	}
+}
+
+// remoteLogStatusError is returned when the remote log server responds with an
+// unexpected HTTP status code.
+type remoteLogStatusError struct {
+	code int
+}
+
+// type check
+var _ error = (*remoteLogStatusError)(nil)
+
+// Error implements the error interface for *remoteLogStatusError.
+func (err *remoteLogStatusError) Error() (msg string) {
+	return fmt.Sprintf("got status code %d", err.code)
+}
+
+// isTransientRemoteLogErr returns true if sending a batch, which has failed
+// with err, may be retried.  Only the client HTTP errors, except for too many
+// requests, are considered permanent.
+func isTransientRemoteLogErr(err error) (ok bool) {
+	statusErr := &remoteLogStatusError{}
+	if !errors.As(err, &statusErr) {
+		return true
+	}
+
+	return statusErr.code == http.StatusTooManyRequests ||
+		statusErr.code >= http.StatusInternalServerError
+}
+
+// remoteLogRecord is the representation of a query log entry sent to the
+// remote log server.
+type remoteLogRecord struct {
+	QHost       string  `json:"qhost"`
+	QType       string  `json:"qtype"`
+	QClass      string  `json:"qclass"`
+	ClientIP    string  `json:"client_ip"`
+	ClientID    string  `json:"client_id,omitempty"`
+	ClientProto string  `json:"client_proto,omitempty"`
+	Upstream    string  `json:"upstream,omitempty"`
+	Rcode       string  `json:"rcode,omitempty"`
+	Reason      string  `json:"reason"`
+	ElapsedMS   float64 `json:"elapsed_ms"`
+	Cached      bool    `json:"cached"`
+	Filtered    bool    `json:"filtered"`
+}
+
+// newRemoteLogRecord returns the remote log record for e.
+func newRemoteLogRecord(e *logEntry) (r *remoteLogRecord) {
+	r = &remoteLogRecord{
+		QHost:       e.QHost,
+		QType:       e.QType,
+		QClass:      e.QClass,
+		ClientIP:    e.IP.String(),
+		ClientID:    e.ClientID,
+		ClientProto: string(e.ClientProto),
+		Upstream:    e.Upstream,
+		Reason:      e.Result.Reason.String(),
+		ElapsedMS:   float64(e.Elapsed) / float64(time.Millisecond),
+		Cached:      e.Cached,
+		Filtered:    e.Result.IsFiltered,
+	}
+
+	if rcode, ok := e.rcode(); ok {
+		r.Rcode = dns.RcodeToString[rcode]
+	}
+
+	return r
+}
+
+// gelfLevelInfo is the syslog severity level of the GELF messages.
+const gelfLevelInfo = 6
+
+// gelfMessage is a message of the Graylog Extended Log Format.  The additional
+// fields are prefixed with an underscore.
+//
+// See https://go2docs.graylog.org/current/getting_in_log_data/gelf.html.
+type gelfMessage struct {
+	Version      string  `json:"version"`
+	Host         string  `json:"host"`
+	ShortMessage string  `json:"short_message"`
+	Timestamp    float64 `json:"timestamp"`
+	Level        int     `json:"level"`
+
+	QHost       string  `json:"_qhost"`
+	QType       string  `json:"_qtype"`
+	QClass      string  `json:"_qclass"`
+	ClientIP    string  `json:"_client_ip"`
+	ClientID    string  `json:"_client_id,omitempty"`
+	ClientProto string  `json:"_client_proto,omitempty"`
+	Upstream    string  `json:"_upstream,omitempty"`
+	Rcode       string  `json:"_rcode,omitempty"`
+	Reason      string  `json:"_reason"`
+	ElapsedMS   float64 `json:"_elapsed_ms"`
+	Cached      bool    `json:"_cached"`
+	Filtered    bool    `json:"_filtered"`
+}
+
+// newGELFMessage returns the GELF message for e sent from the host with the
+// name hostname.
+func newGELFMessage(e *logEntry, hostname string) (msg *gelfMessage) {
+	r := newRemoteLogRecord(e)
+
+	return &gelfMessage{
+		Version:      "1.1",
+		Host:         hostname,
+		ShortMessage: strings.TrimSpace(fmt.Sprintf("%s %s %s", r.QType, r.QHost, r.Rcode)),
+		Timestamp:    float64(e.Time.UnixMicro()) / 1e6,
+		Level:        gelfLevelInfo,
+
+		QHost:       r.QHost,
+		QType:       r.QType,
+		QClass:      r.QClass,
+		ClientIP:    r.ClientIP,
+		ClientID:    r.ClientID,
+		ClientProto: r.ClientProto,
+		Upstream:    r.Upstream,
+		Rcode:       r.Rcode,
+		Reason:      r.Reason,
+		ElapsedMS:   r.ElapsedMS,
+		Cached:      r.Cached,
+		Filtered:    r.Filtered,
+	}
+}
+
+// gelfSender sends the entries as GELF messages.
+type gelfSender struct {
+	// conn is the connection to the server.  It's nil until the first batch is
+	// sent and after a failure.
+	conn net.Conn
+
+	// network is either [RemoteLogProtocolTCP] or [RemoteLogProtocolUDP].
+	network string
+
+	// addr is the address of the GELF input.
+	addr string
+
+	// hostname is the name of the host sending the messages.
+	hostname string
+}
+
+// type check
+var _ remoteLogSender = (*gelfSender)(nil)
+
+// send implements the [remoteLogSender] interface for *gelfSender.
+func (s *gelfSender) send(ctx context.Context, batch []*logEntry) (err error) {
+	if s.conn == nil {
+		d := &net.Dialer{
+			Timeout: remoteLogTimeout,
+		}
+
+		s.conn, err = d.DialContext(ctx, s.network, s.addr)
+		if err != nil {
+			return fmt.Errorf("dialing gelf input: %w", err)
+		}
+	}
+
+	err = s.conn.SetWriteDeadline(time.Now().Add(remoteLogTimeout))
+	if err != nil {
+		return s.reset(fmt.Errorf("setting deadline: %w", err))
+	}
+
+	var buf []byte
+	for _, e := range batch {
+		var msg []byte
+		msg, err = json.Marshal(newGELFMessage(e, s.hostname))
+		if err != nil {
+			return fmt.Errorf("encoding gelf message: %w", err)
+		}
+
+		if s.network == RemoteLogProtocolUDP {
+			_, err = s.conn.Write(msg)
+			if err != nil {
+				return s.reset(fmt.Errorf("writing gelf message: %w", err))
+			}
+
+			continue
+		}
+
+		// GELF messages are separated by the null bytes over TCP.
+		buf = append(buf, msg...)
+		buf = append(buf, 0)
+	}
+
+	if len(buf) == 0 {
+		return nil
+	}
+
+	_, err = s.conn.Write(buf)
+	if err != nil {
+		return s.reset(fmt.Errorf("writing gelf messages: %w", err))
+	}
+
+	return nil
+}
+
+// reset closes the connection, so that it's reestablished during the next
+// sending, and returns err with the closing error, if any.
+func (s *gelfSender) reset(err error) (resErr error) {
+	closeErr := s.conn.Close()
+	s.conn = nil
+
+	return errors.WithDeferred(err, closeErr)
+}
+
+// close implements the [remoteLogSender] interface for *gelfSender.
+func (s *gelfSender) close() (err error) {
+	if s.conn == nil {
+		return nil
+	}
+
+	err = s.conn.Close()
+	s.conn = nil
+
+	return err
+}
+
+// lokiPushRequest is the body of a request to the push API of Grafana Loki.
+//
+// See https://grafana.com/docs/loki/latest/reference/loki-http-api/#ingest-logs.
+type lokiPushRequest struct {
+	Streams []*lokiStream `json:"streams"`
+}
+
+// lokiStream is a stream of log lines with the same labels.
+type lokiStream struct {
+	// Stream are the labels of the stream.
+	Stream map[string]string `json:"stream"`
+
+	// Values are the pairs of the timestamps in nanoseconds and the log lines.
+	Values [][2]string `json:"values"`
+}
+
+// lokiJobLabel is the value of the "job" label of the streams sent to Grafana
+// Loki.
+const lokiJobLabel = "adguardhome"
+
+// lokiSender sends the entries to the push API of Grafana Loki.
+type lokiSender struct {
+	// client is the HTTP client used to send the entries.
+	client *http.Client
+
+	// url is the URL of the push API.
+	url string
+
+	// hostname is the name of the host sending the entries.
+	hostname string
+}
+
+// type check
+var _ remoteLogSender = (*lokiSender)(nil)
+
+// send implements the [remoteLogSender] interface for *lokiSender.
+func (s *lokiSender) send(ctx context.Context, batch []*logEntry) (err error) {
+	values := make([][2]string, 0, len(batch))
+	for _, e := range batch {
+		var line []byte
+		line, err = json.Marshal(newRemoteLogRecord(e))
+		if err != nil {
+			return fmt.Errorf("encoding log line: %w", err)
+		}
+
+		values = append(values, [2]string{strconv.FormatInt(e.Time.UnixNano(), 10), string(line)})
+	}
+
+	body, err := json.Marshal(&lokiPushRequest{
+		Streams: []*lokiStream{{
+			Stream: map[string]string{
+				"job":  lokiJobLabel,
+				"host": s.hostname,
+			},
+			Values: values,
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("encoding push request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+
+	req.Header.Set(httphdr.ContentType, aghhttp.HdrValApplicationJSON)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+	defer func() { err = errors.WithDeferred(err, resp.Body.Close()) }()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return &remoteLogStatusError{
+			code: resp.StatusCode,
+		}
+	}
+
+	return nil
+}
+
+// close implements the [remoteLogSender] interface for *lokiSender.
+func (s *lokiSender) close() (err error) {
+	s.client.CloseIdleConnections()
+
+	return nil
+}
@@ -0,0 +1,339 @@
+package querylog
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newRemoteTestLog returns a new *queryLog forwarding the entries in
+// accordance with c and starts the remote log writer.
+func newRemoteTestLog(t *testing.T, c RemoteLogConfig) (l *queryLog) {
+	t.Helper()
+
+	c.Enabled = true
+
+	l, err := newQueryLog(Config{
+		Logger:      slogutil.NewDiscardLogger(),
+		BaseDir:     t.TempDir(),
+		RotationIvl: timeutil.Day,
+		MemSize:     100,
+		Remote:      c,
+		Enabled:     true,
+		FileEnabled: true,
+	})
+	require.NoError(t, err)
+	require.NotNil(t, l.remote)
+
+	l.remote.backoff = time.Millisecond
+
+	ctx := testutil.ContextWithTimeout(t, testTimeout)
+	go l.remote.run(ctx)
+	testutil.CleanupAndRequireSuccess(t, func() (err error) {
+		return l.remote.shutdown(testutil.ContextWithTimeout(t, testTimeout))
+	})
+
+	return l
+}
+
+// addRemoteTestEntries adds two entries to l and flushes them.
+func addRemoteTestEntries(t *testing.T, l *queryLog) {
+	t.Helper()
+
+	addRcodeEntry(l, "first.example", dns.RcodeSuccess, 10*time.Millisecond)
+	addRcodeEntry(l, "second.example", dns.RcodeServerFailure, 250*time.Millisecond)
+
+	require.NoError(t, l.flushLogBuffer(testutil.ContextWithTimeout(t, testTimeout)))
+}
+
+// assertGELFMessage checks the fields of the GELF message data.
+func assertGELFMessage(t *testing.T, data []byte, host, rcode string, elapsedMS float64) {
+	t.Helper()
+
+	msg := &gelfMessage{}
+	require.NoError(t, json.Unmarshal(data, msg))
+
+	assert.Equal(t, "1.1", msg.Version)
+	assert.NotEmpty(t, msg.Host)
+	assert.Equal(t, "A "+host+" "+rcode, msg.ShortMessage)
+	assert.Positive(t, msg.Timestamp)
+	assert.Equal(t, gelfLevelInfo, msg.Level)
+	assert.Equal(t, host, msg.QHost)
+	assert.Equal(t, "A", msg.QType)
+	assert.Equal(t, "192.0.2.1", msg.ClientIP)
+	assert.Equal(t, rcode, msg.Rcode)
+	assert.Equal(t, "NotFilteredNotFound", msg.Reason)
+	assert.Equal(t, elapsedMS, msg.ElapsedMS)
+}
+
+func TestRemoteLog_gelfTCP(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, lis.Close)
+
+	msgs := make(chan []byte, 2)
+	go func() {
+		conn, accErr := lis.Accept()
+		if accErr != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+
+		r := bufio.NewReader(conn)
+		for range 2 {
+			msg, readErr := r.ReadBytes(0)
+			if readErr != nil {
+				return
+			}
+
+			msgs <- msg[:len(msg)-1]
+		}
+	}()
+
+	l := newRemoteTestLog(t, RemoteLogConfig{
+		Protocol:  RemoteLogProtocolTCP,
+		Address:   lis.Addr().String(),
+		Format:    RemoteLogFormatGELF,
+		BatchSize: 2,
+	})
+
+	addRemoteTestEntries(t, l)
+
+	first, _ := testutil.RequireReceive(t, msgs, testTimeout)
+	assertGELFMessage(t, first, "first.example", "NOERROR", 10)
+
+	second, _ := testutil.RequireReceive(t, msgs, testTimeout)
+	assertGELFMessage(t, second, "second.example", "SERVFAIL", 250)
+}
+
+func TestRemoteLog_gelfUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, conn.Close)
+
+	msgs := make(chan []byte, 2)
+	go func() {
+		for range 2 {
+			buf := make([]byte, 8192)
+			n, _, readErr := conn.ReadFrom(buf)
+			if readErr != nil {
+				return
+			}
+
+			msgs <- buf[:n]
+		}
+	}()
+
+	l := newRemoteTestLog(t, RemoteLogConfig{
+		Protocol:  RemoteLogProtocolUDP,
+		Address:   conn.LocalAddr().String(),
+		Format:    RemoteLogFormatGELF,
+		BatchSize: 2,
+	})
+
+	addRemoteTestEntries(t, l)
+
+	first, _ := testutil.RequireReceive(t, msgs, testTimeout)
+	assertGELFMessage(t, first, "first.example", "NOERROR", 10)
+
+	second, _ := testutil.RequireReceive(t, msgs, testTimeout)
+	assertGELFMessage(t, second, "second.example", "SERVFAIL", 250)
+}
+
+// newLokiTestServer starts an HTTP server responding with the codes from codes
+// in turn and sends the bodies of the push requests to the returned channel.
+func newLokiTestServer(t *testing.T, codes ...int) (addr string, bodies chan []byte) {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	bodies = make(chan []byte, len(codes))
+	reqNum := 0
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, http.MethodPost, r.Method)
+			assert.Equal(t, lokiPushPath, r.URL.Path)
+
+			body, readErr := io.ReadAll(r.Body)
+			assert.NoError(t, readErr)
+
+			bodies <- body
+
+			w.WriteHeader(codes[reqNum])
+			reqNum++
+		}),
+		ReadHeaderTimeout: testTimeout,
+	}
+
+	go func() {
+		srvErr := srv.Serve(lis)
+		assert.ErrorIs(t, srvErr, http.ErrServerClosed)
+	}()
+	testutil.CleanupAndRequireSuccess(t, srv.Close)
+
+	return lis.Addr().String(), bodies
+}
+
+// assertLokiPush checks the body of the push request data.
+func assertLokiPush(t *testing.T, data []byte) {
+	t.Helper()
+
+	req := &lokiPushRequest{}
+	require.NoError(t, json.Unmarshal(data, req))
+	require.Len(t, req.Streams, 1)
+
+	stream := req.Streams[0]
+	assert.Equal(t, lokiJobLabel, stream.Stream["job"])
+	assert.NotEmpty(t, stream.Stream["host"])
+	require.Len(t, stream.Values, 2)
+
+	wantRecs := []*remoteLogRecord{{
+		QHost:     "first.example",
+		QType:     "A",
+		QClass:    "IN",
+		ClientIP:  "192.0.2.1",
+		Rcode:     "NOERROR",
+		Reason:    "NotFilteredNotFound",
+		ElapsedMS: 10,
+	}, {
+		QHost:     "second.example",
+		QType:     "A",
+		QClass:    "IN",
+		ClientIP:  "192.0.2.1",
+		Rcode:     "SERVFAIL",
+		Reason:    "NotFilteredNotFound",
+		ElapsedMS: 250,
+	}}
+
+	for i, v := range stream.Values {
+		assert.NotEmpty(t, v[0])
+
+		rec := &remoteLogRecord{}
+		require.NoError(t, json.Unmarshal([]byte(v[1]), rec))
+
+		assert.Equal(t, wantRecs[i], rec)
+	}
+}
+
+func TestRemoteLog_loki(t *testing.T) {
+	addr, bodies := newLokiTestServer(t, http.StatusNoContent)
+
+	l := newRemoteTestLog(t, RemoteLogConfig{
+		Protocol:  RemoteLogProtocolTCP,
+		Address:   addr,
+		Format:    RemoteLogFormatLoki,
+		BatchSize: 2,
+	})
+
+	addRemoteTestEntries(t, l)
+
+	body, _ := testutil.RequireReceive(t, bodies, testTimeout)
+	assertLokiPush(t, body)
+}
+
+func TestRemoteLog_lokiRetry(t *testing.T) {
+	addr, bodies := newLokiTestServer(
+		t,
+		http.StatusServiceUnavailable,
+		http.StatusTooManyRequests,
+		http.StatusNoContent,
+	)
+
+	l := newRemoteTestLog(t, RemoteLogConfig{
+		Protocol:  RemoteLogProtocolTCP,
+		Address:   "http://" + addr + lokiPushPath,
+		Format:    RemoteLogFormatLoki,
+		BatchSize: 2,
+	})
+
+	addRemoteTestEntries(t, l)
+
+	for range 3 {
+		body, _ := testutil.RequireReceive(t, bodies, testTimeout)
+		assertLokiPush(t, body)
+	}
+}
+
+func TestRemoteLogConfig_validate(t *testing.T) {
+	testCases := []struct {
+		conf       *RemoteLogConfig
+		name       string
+		wantErrMsg string
+	}{{
+		conf:       &RemoteLogConfig{},
+		name:       "disabled",
+		wantErrMsg: "",
+	}, {
+		conf: &RemoteLogConfig{
+			Protocol: RemoteLogProtocolUDP,
+			Address:  "127.0.0.1:12201",
+			Format:   RemoteLogFormatGELF,
+			Enabled:  true,
+		},
+		name:       "gelf_udp",
+		wantErrMsg: "",
+	}, {
+		conf: &RemoteLogConfig{
+			Protocol: "sctp",
+			Address:  "127.0.0.1:12201",
+			Format:   RemoteLogFormatGELF,
+			Enabled:  true,
+		},
+		name:       "bad_protocol",
+		wantErrMsg: `protocol: bad enum value: "sctp"`,
+	}, {
+		conf: &RemoteLogConfig{
+			Protocol: RemoteLogProtocolUDP,
+			Address:  "127.0.0.1:3100",
+			Format:   RemoteLogFormatLoki,
+			Enabled:  true,
+		},
+		name:       "loki_udp",
+		wantErrMsg: `protocol: "udp" is not supported by format "loki"`,
+	}, {
+		conf: &RemoteLogConfig{
+			Protocol: RemoteLogProtocolTCP,
+			Address:  "127.0.0.1:514",
+			Format:   "syslog",
+			Enabled:  true,
+		},
+		name:       "bad_format",
+		wantErrMsg: `format: bad enum value: "syslog"`,
+	}, {
+		conf: &RemoteLogConfig{
+			Protocol: RemoteLogProtocolTCP,
+			Format:   RemoteLogFormatGELF,
+			Enabled:  true,
+		},
+		name:       "no_address",
+		wantErrMsg: "address: empty value",
+	}, {
+		conf: &RemoteLogConfig{
+			Protocol:  RemoteLogProtocolTCP,
+			Address:   "127.0.0.1:12201",
+			Format:    RemoteLogFormatGELF,
+			BatchSize: -1,
+			Enabled:   true,
+		},
+		name:       "negative_batch_size",
+		wantErrMsg: "batch_size: negative value: -1",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, tc.conf.validate())
+		})
+	}
+}
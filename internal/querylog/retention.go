@@ -0,0 +1,237 @@
+package querylog
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghos"
+	"github.com/AdguardTeam/AdGuardHome/internal/aghrenameio"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+)
+
+// ClientRetentionFunc returns the retention period of the log entries of the
+// client with the given identifiers, the ClientID, if any, and the IP address.
+// ivl is zero if the client has no own retention period.
+type ClientRetentionFunc func(ids []string) (ivl time.Duration)
+
+// clientRetentions checks the log entries against the retention periods of
+// their clients.  It caches the retention periods, so it should only be used
+// for a single pass over the log files.
+type clientRetentions struct {
+	// retention returns the retention periods of the clients.
+	retention ClientRetentionFunc
+
+	// cache is the cache of the retention periods by the identifiers of the
+	// clients joined with a space.
+	cache map[string]time.Duration
+
+	// now is the time the ages of the entries are calculated relative to.
+	now time.Time
+}
+
+// newClientRetentions returns a new properly initialized *clientRetentions.
+// retention must not be nil.
+func newClientRetentions(retention ClientRetentionFunc, now time.Time) (r *clientRetentions) {
+	return &clientRetentions{
+		retention: retention,
+		cache:     map[string]time.Duration{},
+		now:       now,
+	}
+}
+
+// ownRetention returns the retention period of the client of the encoded log
+// entry and the age of the entry.  ivl is zero if the client has no own
+// retention period or the entry can't be parsed.
+func (r *clientRetentions) ownRetention(line string) (ivl, age time.Duration) {
+	t, err := time.Parse(time.RFC3339Nano, readJSONValue(line, `"T":"`))
+	if err != nil {
+		return 0, 0
+	}
+
+	ip := readJSONValue(line, `"IP":"`)
+	cid := readJSONValue(line, `"CID":"`)
+
+	key := cid + " " + ip
+	ivl, ok := r.cache[key]
+	if !ok {
+		ids := []string{ip}
+		if cid != "" {
+			ids = []string{cid, ip}
+		}
+
+		ivl = r.retention(ids)
+		r.cache[key] = ivl
+	}
+
+	return ivl, r.now.Sub(t)
+}
+
+// isExpired returns true if the own retention period of the client of the
+// encoded log entry has expired.
+func (r *clientRetentions) isExpired(line string) (ok bool) {
+	ivl, age := r.ownRetention(line)
+
+	return ivl != 0 && age >= ivl
+}
+
+// isRetained returns true if the own retention period of the client of the
+// encoded log entry hasn't expired yet.
+func (r *clientRetentions) isRetained(line string) (ok bool) {
+	ivl, age := r.ownRetention(line)
+
+	return ivl != 0 && age < ivl
+}
+
+// pruneClients removes the entries, which own retention periods of their
+// clients have expired, from the log files.  l.fileWriteLock is expected to be
+// locked.
+func (l *queryLog) pruneClients(ctx context.Context, r *clientRetentions) {
+	for _, filePath := range []string{l.logFile + ".1", l.logFile} {
+		removed, err := filterFile(filePath, func(line string) (keep bool) {
+			return !r.isExpired(line)
+		})
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				l.logger.ErrorContext(
+					ctx,
+					"pruning entries by client retention",
+					"file", filePath,
+					slogutil.KeyError, err,
+				)
+			}
+
+			continue
+		}
+
+		if removed > 0 {
+			l.logger.DebugContext(ctx, "pruned entries", "file", filePath, "count", removed)
+		}
+	}
+}
+
+// filterFile removes the lines, for which keep returns false, from the file at
+// filePath.  The file is only rewritten if there are such lines.
+func filterFile(filePath string, keep func(line string) (ok bool)) (removed int, err error) {
+	all := true
+	err = rangeLines(filePath, func(line string) (cont bool) {
+		all = keep(line)
+
+		return all
+	})
+	if err != nil || all {
+		// Don't wrap the error since it's informative enough as is.
+		return 0, err
+	}
+
+	f, err := aghrenameio.NewPendingFile(filePath, aghos.DefaultPermFile)
+	if err != nil {
+		return 0, fmt.Errorf("creating temp file: %w", err)
+	}
+	defer func() { err = aghrenameio.WithDeferredCleanup(err, f) }()
+
+	var writeErr error
+	err = rangeLines(filePath, func(line string) (cont bool) {
+		if !keep(line) {
+			removed++
+
+			return true
+		}
+
+		_, writeErr = io.WriteString(f, line)
+
+		return writeErr == nil
+	})
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return 0, err
+	} else if writeErr != nil {
+		return 0, fmt.Errorf("writing line: %w", writeErr)
+	}
+
+	return removed, nil
+}
+
+// rangeLines calls f for each line of the file at filePath, including the
+// trailing newline, until f returns false.
+func rangeLines(filePath string, f func(line string) (cont bool)) (err error) {
+	// #nosec G304 -- Trust the path, since it's the path of the log file.
+	file, err := os.Open(filePath)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+	defer func() { err = errors.WithDeferred(err, file.Close()) }()
+
+	r := bufio.NewReader(file)
+	for {
+		var line string
+		line, err = r.ReadString('\n')
+		if strings.TrimSpace(line) != "" && !f(line) {
+			return nil
+		}
+
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("reading %q: %w", filePath, err)
+		}
+	}
+}
+
+// retainedLines returns the lines of the file at filePath with the entries,
+// which own retention periods of their clients haven't expired yet.
+func retainedLines(filePath string, r *clientRetentions) (lines []string, err error) {
+	err = rangeLines(filePath, func(line string) (cont bool) {
+		if r.isRetained(line) {
+			if !strings.HasSuffix(line, "\n") {
+				line += "\n"
+			}
+
+			lines = append(lines, line)
+		}
+
+		return true
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	return lines, err
+}
+
+// prependLines writes lines to the beginning of the file at filePath.
+func prependLines(filePath string, lines []string) (err error) {
+	f, err := aghrenameio.NewPendingFile(filePath, aghos.DefaultPermFile)
+	if err != nil {
+		return fmt.Errorf("creating temp file: %w", err)
+	}
+	defer func() { err = aghrenameio.WithDeferredCleanup(err, f) }()
+
+	for _, line := range lines {
+		_, err = io.WriteString(f, line)
+		if err != nil {
+			return fmt.Errorf("writing line: %w", err)
+		}
+	}
+
+	// #nosec G304 -- Trust the path, since it's the path of the log file.
+	src, err := os.Open(filePath)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return err
+	}
+	defer func() { err = errors.WithDeferred(err, src.Close()) }()
+
+	_, err = io.Copy(f, src)
+	if err != nil {
+		return fmt.Errorf("copying entries: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,127 @@
+package querylog
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghos"
+	"github.com/AdguardTeam/golibs/logutil/slogutil"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/AdguardTeam/golibs/timeutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestEntries writes the log entries to the file at filePath.
+func writeTestEntries(t *testing.T, filePath string, entries ...*logEntry) {
+	t.Helper()
+
+	b := &bytes.Buffer{}
+	enc := json.NewEncoder(b)
+	for _, e := range entries {
+		require.NoError(t, enc.Encode(e))
+	}
+
+	err := os.WriteFile(filePath, b.Bytes(), aghos.DefaultPermFile)
+	require.NoError(t, err)
+}
+
+// readTestHosts returns the hosts of the log entries from the file at
+// filePath.
+func readTestHosts(t *testing.T, filePath string) (hosts []string) {
+	t.Helper()
+
+	err := rangeLines(filePath, func(line string) (cont bool) {
+		hosts = append(hosts, readJSONValue(line, `"QH":"`))
+
+		return true
+	})
+	require.NoError(t, err)
+
+	return hosts
+}
+
+func TestQueryLog_checkAndRotate_clientRetention(t *testing.T) {
+	const (
+		shortCID = "short"
+		longCID  = "long"
+	)
+
+	var (
+		shortIP  = net.IP{192, 0, 2, 1}
+		longIP   = net.IP{192, 0, 2, 2}
+		globalIP = net.IP{192, 0, 2, 3}
+	)
+
+	dir := t.TempDir()
+	l, err := newQueryLog(Config{
+		Logger: slogutil.NewDiscardLogger(),
+		ClientRetention: func(ids []string) (ivl time.Duration) {
+			switch {
+			case slices.Contains(ids, shortCID):
+				return time.Hour
+			case slices.Contains(ids, longCID):
+				return 3 * timeutil.Day
+			default:
+				return 0
+			}
+		},
+		BaseDir:     dir,
+		RotationIvl: timeutil.Day,
+		MemSize:     100,
+		Enabled:     true,
+		FileEnabled: true,
+	})
+	require.NoError(t, err)
+
+	now := time.Now()
+	newEntry := func(host, cid string, ip net.IP, age time.Duration) (e *logEntry) {
+		return &logEntry{
+			Time:     now.Add(-age),
+			QHost:    host,
+			QType:    "A",
+			QClass:   "IN",
+			ClientID: cid,
+			IP:       ip,
+		}
+	}
+
+	writeTestEntries(
+		t,
+		filepath.Join(dir, queryLogFileName+".1"),
+		newEntry("short-oldest.example", shortCID, shortIP, 36*time.Hour),
+		newEntry("long-oldest.example", longCID, longIP, 36*time.Hour),
+		newEntry("global-oldest.example", "", globalIP, 36*time.Hour),
+	)
+	writeTestEntries(
+		t,
+		filepath.Join(dir, queryLogFileName),
+		newEntry("short-old.example", shortCID, shortIP, 25*time.Hour),
+		newEntry("long-old.example", longCID, longIP, 25*time.Hour),
+		newEntry("global-old.example", "", globalIP, 25*time.Hour),
+		newEntry("short-new.example", shortCID, shortIP, 30*time.Minute),
+		newEntry("long-new.example", longCID, longIP, 30*time.Minute),
+		newEntry("global-new.example", "", globalIP, 30*time.Minute),
+	)
+
+	ctx := testutil.ContextWithTimeout(t, testTimeout)
+	l.checkAndRotate(ctx)
+
+	_, err = os.Stat(filepath.Join(dir, queryLogFileName))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	assert.Equal(t, []string{
+		"long-oldest.example",
+		"long-old.example",
+		"global-old.example",
+		"short-new.example",
+		"long-new.example",
+		"global-new.example",
+	}, readTestHosts(t, filepath.Join(dir, queryLogFileName+".1")))
+}
@@ -2,9 +2,13 @@ package querylog
 
 import (
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
 	"testing"
 	"time"
 
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
 	"github.com/AdguardTeam/golibs/logutil/slogutil"
 	"github.com/AdguardTeam/golibs/testutil"
 	"github.com/AdguardTeam/golibs/timeutil"
@@ -100,3 +104,372 @@ func TestQueryLog_Search_findClient(t *testing.T) {
 
 	assert.Equal(t, knownClientName, gotClient.Name)
 }
+
+// addSearchEntry adds an entry with the given parameters and a single A record
+// with answerIP, if any, to l.
+func addSearchEntry(
+	l *queryLog,
+	host string,
+	clientIP net.IP,
+	clientID string,
+	upstream string,
+	elapsed time.Duration,
+	res *filtering.Result,
+	answerIP net.IP,
+) {
+	q := &dns.Msg{
+		Question: []dns.Question{{
+			Name:   host + ".",
+			Qtype:  dns.TypeA,
+			Qclass: dns.ClassINET,
+		}},
+	}
+
+	a := &dns.Msg{
+		Question: q.Question,
+	}
+	if answerIP != nil {
+		a.Answer = []dns.RR{&dns.A{
+			Hdr: dns.RR_Header{
+				Name:   q.Question[0].Name,
+				Rrtype: dns.TypeA,
+				Class:  dns.ClassINET,
+			},
+			A: answerIP,
+		}}
+	}
+
+	l.Add(&AddParams{
+		Question: q,
+		Answer:   a,
+		Result:   res,
+		ClientID: clientID,
+		Upstream: upstream,
+		ClientIP: clientIP,
+		Elapsed:  elapsed,
+	})
+}
+
+func TestQueryLog_Search_criteria(t *testing.T) {
+	const knownClientName = "Known Client"
+
+	knownClientIP := net.IP{192, 0, 2, 2}
+
+	l, err := newQueryLog(Config{
+		Logger: slogutil.NewDiscardLogger(),
+		FindClient: func(ids []string) (c *Client, _ error) {
+			if slices.Contains(ids, knownClientIP.String()) {
+				return &Client{Name: knownClientName}, nil
+			}
+
+			return nil, nil
+		},
+		BaseDir:     t.TempDir(),
+		RotationIvl: timeutil.Day,
+		MemSize:     100,
+		Enabled:     true,
+		FileEnabled: true,
+	})
+	require.NoError(t, err)
+
+	ctx := testutil.ContextWithTimeout(t, testTimeout)
+
+	// Add disk entries.
+	addSearchEntry(
+		l,
+		"blocked.example",
+		net.IP{192, 0, 2, 1},
+		"",
+		"tls://dns.example:853",
+		10*time.Millisecond,
+		&filtering.Result{Reason: filtering.FilteredBlockList, IsFiltered: true},
+		net.IP{0, 0, 0, 0},
+	)
+	addSearchEntry(
+		l,
+		"allowed.example",
+		knownClientIP,
+		"",
+		"https://dns.example/dns-query",
+		50*time.Millisecond,
+		&filtering.Result{Reason: filtering.NotFilteredAllowList},
+		net.IP{198, 51, 100, 1},
+	)
+	require.NoError(t, l.flushLogBuffer(ctx))
+
+	// Add memory entries.
+	addSearchEntry(
+		l,
+		"parental.example",
+		net.IP{192, 0, 2, 1},
+		"",
+		"",
+		100*time.Millisecond,
+		&filtering.Result{Reason: filtering.FilteredParental, IsFiltered: true},
+		nil,
+	)
+	addSearchEntry(
+		l,
+		"plain.example",
+		net.IP{192, 0, 2, 3},
+		"cid-3",
+		"8.8.8.8:53",
+		200*time.Millisecond,
+		&filtering.Result{},
+		net.IP{198, 51, 100, 2},
+	)
+
+	testCases := []struct {
+		name      string
+		query     string
+		wantHosts []string
+	}{{
+		name:      "none",
+		query:     "",
+		wantHosts: []string{"plain.example", "parental.example", "allowed.example", "blocked.example"},
+	}, {
+		name:      "filter_type_all",
+		query:     "filter_type=all",
+		wantHosts: []string{"plain.example", "parental.example", "allowed.example", "blocked.example"},
+	}, {
+		name:      "filter_type_blocked",
+		query:     "filter_type=blocked",
+		wantHosts: []string{"blocked.example"},
+	}, {
+		name:      "filter_type_allowed",
+		query:     "filter_type=allowed",
+		wantHosts: []string{"allowed.example"},
+	}, {
+		name:      "filter_type_parental",
+		query:     "filter_type=parental",
+		wantHosts: []string{"parental.example"},
+	}, {
+		name:      "filter_type_safe_browsing",
+		query:     "filter_type=safe_browsing",
+		wantHosts: nil,
+	}, {
+		name:      "client_ip",
+		query:     "client=192.0.2.1",
+		wantHosts: []string{"parental.example", "blocked.example"},
+	}, {
+		name:      "client_name",
+		query:     "client=known%20client",
+		wantHosts: []string{"allowed.example"},
+	}, {
+		name:      "client_id",
+		query:     "client=cid-3",
+		wantHosts: []string{"plain.example"},
+	}, {
+		name:      "client_partial",
+		query:     "client=192.0.2",
+		wantHosts: nil,
+	}, {
+		name:      "upstream",
+		query:     "upstream=dns.example",
+		wantHosts: []string{"allowed.example", "blocked.example"},
+	}, {
+		name:      "upstream_strict",
+		query:     "upstream=%228.8.8.8:53%22",
+		wantHosts: []string{"plain.example"},
+	}, {
+		name:      "answer_ip",
+		query:     "answer_ip=198.51.100.1",
+		wantHosts: []string{"allowed.example"},
+	}, {
+		name:      "response_time_gte",
+		query:     "response_time_gte=50",
+		wantHosts: []string{"plain.example", "parental.example", "allowed.example"},
+	}, {
+		name:      "response_time_lte",
+		query:     "response_time_lte=50",
+		wantHosts: []string{"allowed.example", "blocked.example"},
+	}, {
+		name:      "response_time_range",
+		query:     "response_time_gte=60&response_time_lte=150",
+		wantHosts: []string{"parental.example"},
+	}, {
+		name:      "client_and_response_time",
+		query:     "client=192.0.2.1&response_time_gte=50",
+		wantHosts: []string{"parental.example"},
+	}, {
+		name:      "upstream_and_filter_type_and_answer_ip",
+		query:     "upstream=dns.example&filter_type=blocked&answer_ip=0.0.0.0",
+		wantHosts: []string{"blocked.example"},
+	}, {
+		name:      "search_and_upstream",
+		query:     "search=example&upstream=8.8.8.8",
+		wantHosts: []string{"plain.example"},
+	}, {
+		name:      "no_intersection",
+		query:     "client=cid-3&filter_type=blocked",
+		wantHosts: nil,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/control/querylog?"+tc.query, nil)
+			params, pErr := l.parseSearchParams(ctx, r)
+			require.NoError(t, pErr)
+
+			entries, _ := l.search(ctx, params)
+
+			var hosts []string
+			for _, e := range entries {
+				hosts = append(hosts, e.QHost)
+			}
+
+			assert.Equal(t, tc.wantHosts, hosts)
+		})
+	}
+}
+
+// addRcodeEntry adds an entry with the given host, response code, and
+// processing time to l.
+func addRcodeEntry(l *queryLog, host string, rcode int, elapsed time.Duration) {
+	q := (&dns.Msg{}).SetQuestion(host+".", dns.TypeA)
+	a := (&dns.Msg{}).SetRcode(q, rcode)
+
+	l.Add(&AddParams{
+		Question: q,
+		Answer:   a,
+		Result:   &filtering.Result{},
+		ClientIP: net.IP{192, 0, 2, 1},
+		Elapsed:  elapsed,
+	})
+}
+
+func TestQueryLog_Search_rcode(t *testing.T) {
+	l, err := newQueryLog(Config{
+		Logger:      slogutil.NewDiscardLogger(),
+		BaseDir:     t.TempDir(),
+		RotationIvl: timeutil.Day,
+		MemSize:     100,
+		Enabled:     true,
+		FileEnabled: true,
+	})
+	require.NoError(t, err)
+
+	ctx := testutil.ContextWithTimeout(t, testTimeout)
+
+	// Add disk entries.
+	addRcodeEntry(l, "fast-servfail.example", dns.RcodeServerFailure, 10*time.Millisecond)
+	addRcodeEntry(l, "slow-nxdomain.example", dns.RcodeNameError, 300*time.Millisecond)
+	addRcodeEntry(l, "slow-noerror.example", dns.RcodeSuccess, 250*time.Millisecond)
+	require.NoError(t, l.flushLogBuffer(ctx))
+
+	// Add memory entries.
+	addRcodeEntry(l, "slow-servfail.example", dns.RcodeServerFailure, 400*time.Millisecond)
+	addRcodeEntry(l, "fast-noerror.example", dns.RcodeSuccess, 5*time.Millisecond)
+	addRcodeEntry(l, "fast-nxdomain.example", dns.RcodeNameError, 20*time.Millisecond)
+
+	testCases := []struct {
+		name      string
+		query     string
+		wantHosts []string
+	}{{
+		name:      "servfail",
+		query:     "rcode=SERVFAIL",
+		wantHosts: []string{"slow-servfail.example", "fast-servfail.example"},
+	}, {
+		name:  "several",
+		query: "rcode=servfail,NXDOMAIN",
+		wantHosts: []string{
+			"fast-nxdomain.example",
+			"slow-servfail.example",
+			"slow-nxdomain.example",
+			"fast-servfail.example",
+		},
+	}, {
+		name:      "number",
+		query:     "rcode=0",
+		wantHosts: []string{"fast-noerror.example", "slow-noerror.example"},
+	}, {
+		name:      "refused",
+		query:     "rcode=REFUSED",
+		wantHosts: nil,
+	}, {
+		name:  "slow",
+		query: "response_time_gte=200",
+		wantHosts: []string{
+			"slow-servfail.example",
+			"slow-noerror.example",
+			"slow-nxdomain.example",
+		},
+	}, {
+		name:      "slow_failures",
+		query:     "rcode=SERVFAIL,NXDOMAIN&response_time_gte=200",
+		wantHosts: []string{"slow-servfail.example", "slow-nxdomain.example"},
+	}, {
+		name:      "failures_limit",
+		query:     "rcode=SERVFAIL,NXDOMAIN&limit=2&offset=0",
+		wantHosts: []string{"fast-nxdomain.example", "slow-servfail.example"},
+	}, {
+		name:      "failures_offset",
+		query:     "rcode=SERVFAIL,NXDOMAIN&limit=2&offset=2",
+		wantHosts: []string{"slow-nxdomain.example", "fast-servfail.example"},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/control/querylog?"+tc.query, nil)
+			params, pErr := l.parseSearchParams(ctx, r)
+			require.NoError(t, pErr)
+
+			entries, _ := l.search(ctx, params)
+
+			var hosts []string
+			for _, e := range entries {
+				hosts = append(hosts, e.QHost)
+			}
+
+			assert.Equal(t, tc.wantHosts, hosts)
+		})
+	}
+}
+
+func TestQueryLog_ParseSearchParams_errors(t *testing.T) {
+	l, err := newQueryLog(Config{
+		Logger:      slogutil.NewDiscardLogger(),
+		BaseDir:     t.TempDir(),
+		RotationIvl: timeutil.Day,
+		MemSize:     100,
+	})
+	require.NoError(t, err)
+
+	ctx := testutil.ContextWithTimeout(t, testTimeout)
+
+	testCases := []struct {
+		name       string
+		query      string
+		wantErrMsg string
+	}{{
+		name:       "bad_filter_type",
+		query:      "filter_type=bad",
+		wantErrMsg: "filter_type: invalid value bad",
+	}, {
+		name:       "bad_answer_ip",
+		query:      "answer_ip=bad",
+		wantErrMsg: `answer_ip: ParseAddr("bad"): unable to parse IP`,
+	}, {
+		name:       "bad_rcode",
+		query:      "rcode=NXDOMAIN,BAD",
+		wantErrMsg: `rcode: bad rcode "BAD"`,
+	}, {
+		name:       "big_rcode",
+		query:      "rcode=16",
+		wantErrMsg: `rcode: bad rcode "16"`,
+	}, {
+		name:  "bad_response_time",
+		query: "response_time_gte=-1",
+		wantErrMsg: `response_time_gte: strconv.ParseUint: parsing "-1": ` +
+			`invalid syntax`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/control/querylog?"+tc.query, nil)
+			_, err = l.parseSearchParams(ctx, r)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, err)
+		})
+	}
+}
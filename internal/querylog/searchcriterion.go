@@ -4,10 +4,14 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"net/netip"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
 	"github.com/AdguardTeam/golibs/stringutil"
+	"github.com/miekg/dns"
 )
 
 type criterionType int
@@ -21,6 +25,24 @@ const (
 	//
 	// See (*searchCriterion).ctFilteringStatusCase for details.
 	ctFilteringStatus
+	// ctClient is for searching by the client's IP address, ClientID, or
+	// name.  The value must be equal to one of those.
+	ctClient
+	// ctUpstream is for searching by the address of the upstream server the
+	// request has been forwarded to.
+	ctUpstream
+	// ctAnswerIP is for searching by the IP address in the A and AAAA records
+	// of the response.
+	ctAnswerIP
+	// ctResponseTimeGTE is for searching the entries with the processing time
+	// greater than or equal to the value.
+	ctResponseTimeGTE
+	// ctResponseTimeLTE is for searching the entries with the processing time
+	// less than or equal to the value.
+	ctResponseTimeLTE
+	// ctRcode is for searching the entries with the response code equal to
+	// one of the values.
+	ctRcode
 )
 
 const (
@@ -45,10 +67,34 @@ var filteringStatusValues = []string{
 	filteringStatusProcessed,
 }
 
+// filterTypeToStatus maps the values of the filter_type query parameter to the
+// corresponding filtering statuses.
+var filterTypeToStatus = map[string]string{
+	"all":           filteringStatusAll,
+	"allowed":       filteringStatusWhitelisted,
+	"blocked":       filteringStatusBlocked,
+	"parental":      filteringStatusBlockedParental,
+	"safe_browsing": filteringStatusBlockedSafebrowsing,
+	"safe_search":   filteringStatusSafeSearch,
+}
+
 // searchCriterion is a search criterion that is used to match a record.
 type searchCriterion struct {
-	value         string
-	asciiVal      string
+	// addr is the IP address to search for.  It's only set for
+	// [ctAnswerIP].
+	addr netip.Addr
+
+	value    string
+	asciiVal string
+
+	// elapsed is the processing time bound.  It's only set for
+	// [ctResponseTimeGTE] and [ctResponseTimeLTE].
+	elapsed time.Duration
+
+	// rcodes are the response codes to search for.  It's only set for
+	// [ctRcode].
+	rcodes []int
+
 	criterionType criterionType
 	// strict, if true, means that the criterion must be applied to the
 	// whole value rather than the part of it.  That is, equality and not
@@ -111,9 +157,29 @@ func (c *searchCriterion) quickMatch(
 		}
 
 		return ctDomainOrClientCaseNonStrict(c.value, c.asciiVal, clientID, name, host, ip)
-	case ctFilteringStatus:
-		// Go on, as we currently don't do quick matches against
-		// filtering statuses.
+	case ctClient:
+		ip := readJSONValue(line, `"IP":"`)
+		clientID := readJSONValue(line, `"CID":"`)
+
+		var name string
+		if cli := findClient(ctx, logger, clientID, ip); cli != nil {
+			name = cli.Name
+		}
+
+		return ctClientCase(c.value, clientID, name, ip)
+	case ctUpstream:
+		return c.ctUpstreamCase(readJSONValue(line, `"Upstream":"`))
+	case ctResponseTimeGTE, ctResponseTimeLTE:
+		elapsed, ok := readJSONNumber(line, `"Elapsed":`)
+		if !ok {
+			// Let the full match decide.
+			return true
+		}
+
+		return c.ctResponseTimeCase(time.Duration(elapsed))
+	case ctFilteringStatus, ctAnswerIP, ctRcode:
+		// Go on, as we currently don't do quick matches against filtering
+		// statuses and the answers, which require decoding.
 		return true
 	default:
 		return true
@@ -127,11 +193,93 @@ func (c *searchCriterion) match(entry *logEntry) bool {
 		return c.ctDomainOrClientCase(entry)
 	case ctFilteringStatus:
 		return c.ctFilteringStatusCase(entry.Result.Reason, entry.Result.IsFiltered)
+	case ctClient:
+		var name string
+		if entry.client != nil {
+			name = entry.client.Name
+		}
+
+		return ctClientCase(c.value, entry.ClientID, name, entry.IP.String())
+	case ctUpstream:
+		return c.ctUpstreamCase(entry.Upstream)
+	case ctAnswerIP:
+		return c.ctAnswerIPCase(entry.Answer)
+	case ctResponseTimeGTE, ctResponseTimeLTE:
+		return c.ctResponseTimeCase(entry.Elapsed)
+	case ctRcode:
+		return c.ctRcodeCase(entry)
+	}
+
+	return false
+}
+
+// ctClientCase returns true if term is equal to the client's IP address,
+// ClientID, or name.
+func ctClientCase(term, clientID, name, ip string) (ok bool) {
+	return ip == term ||
+		(clientID != "" && strings.EqualFold(clientID, term)) ||
+		(name != "" && strings.EqualFold(name, term))
+}
+
+// ctUpstreamCase returns true if upstream matches the value.
+func (c *searchCriterion) ctUpstreamCase(upstream string) (ok bool) {
+	if c.strict {
+		return strings.EqualFold(upstream, c.value)
+	}
+
+	return stringutil.ContainsFold(upstream, c.value)
+}
+
+// ctAnswerIPCase returns true if the packed response contains an A or AAAA
+// record with the address equal to the value.
+func (c *searchCriterion) ctAnswerIPCase(answer []byte) (ok bool) {
+	if len(answer) == 0 {
+		return false
+	}
+
+	msg := &dns.Msg{}
+	if err := msg.Unpack(answer); err != nil {
+		return false
+	}
+
+	for _, rr := range msg.Answer {
+		var ip []byte
+		switch rr := rr.(type) {
+		case *dns.A:
+			ip = rr.A
+		case *dns.AAAA:
+			ip = rr.AAAA
+		default:
+			continue
+		}
+
+		addr, addrOK := netip.AddrFromSlice(ip)
+		if addrOK && addr.Unmap() == c.addr {
+			return true
+		}
 	}
 
 	return false
 }
 
+// ctResponseTimeCase returns true if elapsed is within the bound set by the
+// criterion.
+func (c *searchCriterion) ctResponseTimeCase(elapsed time.Duration) (ok bool) {
+	if c.criterionType == ctResponseTimeGTE {
+		return elapsed >= c.elapsed
+	}
+
+	return elapsed <= c.elapsed
+}
+
+// ctRcodeCase returns true if the response code of the entry is one of the
+// values.
+func (c *searchCriterion) ctRcodeCase(e *logEntry) (ok bool) {
+	rcode, ok := e.rcode()
+
+	return ok && slices.Contains(c.rcodes, rcode)
+}
+
 func (c *searchCriterion) ctDomainOrClientCase(e *logEntry) bool {
 	clientID := e.ClientID
 	host := e.QHost
@@ -160,6 +308,7 @@ func (c *searchCriterion) ctFilteringStatusCase(
 	case filteringStatusFiltered:
 		return isFiltered || reason.In(
 			filtering.NotFilteredAllowList,
+			filtering.NotFilteredConnectivityCheck,
 			filtering.Rewritten,
 			filtering.RewrittenAutoHosts,
 			filtering.RewrittenRule,
@@ -172,7 +321,7 @@ func (c *searchCriterion) ctFilteringStatusCase(
 		filteringStatusSafeSearch:
 		return isFiltered && c.isFilteredWithReason(reason)
 	case filteringStatusWhitelisted:
-		return reason == filtering.NotFilteredAllowList
+		return reason.In(filtering.NotFilteredAllowList, filtering.NotFilteredConnectivityCheck)
 	case filteringStatusRewritten:
 		return reason.In(
 			filtering.Rewritten,
@@ -184,6 +333,7 @@ func (c *searchCriterion) ctFilteringStatusCase(
 			filtering.FilteredBlockList,
 			filtering.FilteredBlockedService,
 			filtering.NotFilteredAllowList,
+			filtering.NotFilteredConnectivityCheck,
 		)
 	default:
 		return false
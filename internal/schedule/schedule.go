@@ -81,6 +81,34 @@ func (w *Weekly) Contains(t time.Time) (ok bool) {
 	return dr.contains(offset)
 }
 
+// NextTransition returns the earliest time after t at which the result of
+// [Weekly.Contains] changes.  ok is false if it never changes, that is, if the
+// schedule is either empty or full.
+func (w *Weekly) NextTransition(t time.Time) (next time.Time, ok bool) {
+	t = t.In(w.location)
+	cur := w.Contains(t)
+
+	y, m, d := t.Date()
+
+	// A transition within the same day range could be up to seven days ahead,
+	// so check the boundaries of eight days starting with the current one.
+	for i := range 8 {
+		day := time.Date(y, m, d+i, 0, 0, 0, 0, w.location)
+		dr := w.days[day.Weekday()]
+		if (dr == dayRange{}) {
+			continue
+		}
+
+		for _, b := range []time.Time{day.Add(dr.start), day.Add(dr.end)} {
+			if b.After(t) && w.Contains(b) != cur {
+				return b, true
+			}
+		}
+	}
+
+	return time.Time{}, false
+}
+
 // type check
 var _ json.Unmarshaler = (*Weekly)(nil)
 
@@ -130,6 +130,80 @@ sun:
 time_zone: Europe/Brussels
 `
 
+func TestWeekly_NextTransition(t *testing.T) {
+	// friday is a Friday.
+	friday := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	fridaySchedule := &Weekly{
+		days: [7]dayRange{
+			time.Friday: {start: 12 * time.Hour, end: 14 * time.Hour},
+		},
+		location: time.UTC,
+	}
+
+	// overnightSchedule is from Friday 22:00 to Saturday 02:00.
+	overnightSchedule := &Weekly{
+		days: [7]dayRange{
+			time.Friday:   {start: 22 * time.Hour, end: 24 * time.Hour},
+			time.Saturday: {start: 0, end: 2 * time.Hour},
+		},
+		location: time.UTC,
+	}
+
+	testCases := []struct {
+		schedule *Weekly
+		t        time.Time
+		want     time.Time
+		name     string
+		wantOK   bool
+	}{{
+		schedule: fridaySchedule,
+		t:        friday.Add(11 * time.Hour),
+		want:     friday.Add(12 * time.Hour),
+		name:     "before_start",
+		wantOK:   true,
+	}, {
+		schedule: fridaySchedule,
+		t:        friday.Add(12 * time.Hour),
+		want:     friday.Add(14 * time.Hour),
+		name:     "at_start",
+		wantOK:   true,
+	}, {
+		schedule: fridaySchedule,
+		t:        friday.Add(15 * time.Hour),
+		want:     friday.Add(7*timeutil.Day + 12*time.Hour),
+		name:     "next_week",
+		wantOK:   true,
+	}, {
+		schedule: overnightSchedule,
+		t:        friday.Add(23 * time.Hour),
+		want:     friday.Add(26 * time.Hour),
+		name:     "overnight",
+		wantOK:   true,
+	}, {
+		schedule: EmptyWeekly(),
+		t:        friday,
+		want:     time.Time{},
+		name:     "empty",
+		wantOK:   false,
+	}, {
+		schedule: FullWeekly(),
+		t:        friday,
+		want:     time.Time{},
+		name:     "full",
+		wantOK:   false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := tc.schedule.NextTransition(tc.t)
+			require.Equal(t, tc.wantOK, ok)
+
+			assert.True(t, tc.want.Equal(got), "want %s, got %s", tc.want, got)
+		})
+	}
+}
+
 func TestWeekly_UnmarshalYAML(t *testing.T) {
 	const (
 		sameTime = `
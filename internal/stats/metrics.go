@@ -0,0 +1,165 @@
+package stats
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// LatencyBounds are the upper bounds of the buckets of the upstream latency
+// histograms.  They must be sorted in ascending order.
+var LatencyBounds = []time.Duration{
+	1 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// Histogram is a snapshot of a latency histogram.
+type Histogram struct {
+	// Buckets are the cumulative numbers of observations less than or equal
+	// to the corresponding bounds in [LatencyBounds].
+	Buckets []uint64
+
+	// Count is the total number of observations.
+	Count uint64
+
+	// Sum is the sum of all observations.
+	Sum time.Duration
+}
+
+// observe adds d to h.
+func (h *Histogram) observe(d time.Duration) {
+	for i, b := range LatencyBounds {
+		if d <= b {
+			h.Buckets[i]++
+		}
+	}
+
+	h.Count++
+	h.Sum += d
+}
+
+// clone returns a deep copy of h.
+func (h *Histogram) clone() (c *Histogram) {
+	return &Histogram{
+		Buckets: slices.Clone(h.Buckets),
+		Count:   h.Count,
+		Sum:     h.Sum,
+	}
+}
+
+// Metrics is a snapshot of the metrics collected since the start of the
+// statistics module.  Unlike the statistics, the metrics are never reset and
+// aren't limited by the retention interval.
+type Metrics struct {
+	// Upstreams are the latency histograms of the successful queries to the
+	// upstream servers keyed by the address of the upstream.
+	Upstreams map[string]*Histogram
+
+	// Queries is the total number of counted queries.
+	Queries uint64
+
+	// Blocked is the number of queries blocked by the filtering rules, safe
+	// browsing, or parental control.
+	Blocked uint64
+
+	// CacheHits is the number of queries answered from the cache.
+	CacheHits uint64
+
+	// CacheMisses is the number of queries sent to the upstream servers,
+	// because there was no answer in the cache.
+	CacheMisses uint64
+}
+
+// metrics collects the [Metrics].  It's safe for concurrent use.
+type metrics struct {
+	// mu protects m.
+	mu *sync.Mutex
+
+	// m are the collected metrics.
+	m *Metrics
+}
+
+// newMetrics returns a new properly initialized *metrics.
+func newMetrics() (m *metrics) {
+	return &metrics{
+		mu: &sync.Mutex{},
+		m: &Metrics{
+			Upstreams: map[string]*Histogram{},
+		},
+	}
+}
+
+// update adds the data from e to m.  e must be valid.
+func (m *metrics) update(e *Entry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.m.Queries++
+	switch e.Result {
+	case RFiltered, RSafeBrowsing, RParental:
+		m.m.Blocked++
+	default:
+		// Go on.
+	}
+
+	if len(e.UpstreamStats) == 0 {
+		return
+	}
+
+	isCached := false
+	for _, s := range e.UpstreamStats {
+		if s.IsCached {
+			isCached = true
+
+			continue
+		} else if s.Error != nil {
+			continue
+		}
+
+		h := m.m.Upstreams[s.Address]
+		if h == nil {
+			h = &Histogram{
+				Buckets: make([]uint64, len(LatencyBounds)),
+			}
+			m.m.Upstreams[s.Address] = h
+		}
+
+		h.observe(s.QueryDuration)
+	}
+
+	if isCached {
+		m.m.CacheHits++
+	} else {
+		m.m.CacheMisses++
+	}
+}
+
+// snapshot returns a deep copy of the collected metrics.
+func (m *metrics) snapshot() (s *Metrics) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s = &Metrics{
+		Upstreams:   make(map[string]*Histogram, len(m.m.Upstreams)),
+		Queries:     m.m.Queries,
+		Blocked:     m.m.Blocked,
+		CacheHits:   m.m.CacheHits,
+		CacheMisses: m.m.CacheMisses,
+	}
+
+	for addr, h := range m.m.Upstreams {
+		s.Upstreams[addr] = h.clone()
+	}
+
+	return s
+}
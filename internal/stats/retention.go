@@ -0,0 +1,177 @@
+package stats
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/AdguardTeam/golibs/container"
+	"go.etcd.io/bbolt"
+)
+
+// retainedClient is the data about a client stored in the units, which is used
+// to find the units to prune.
+type retainedClient struct {
+	// ivl is the retention period of the client applied by the latest pruning.
+	// Zero means that the client has no own retention period.
+	ivl time.Duration
+
+	// lastID is the identifier of the latest unit containing the client.
+	lastID uint32
+}
+
+// pruneClients removes the numbers of requests of the clients, which own
+// retention periods have expired, from the units stored within tx.  curID is
+// the identifier of the current unit and flushed is the unit with flushedID
+// flushed before it.  All the units are only checked on the first call.
+// Subsequent calls only check the units which age has reached the retention
+// period of some client since the previous call, or is within the retention
+// period shortened since then.  confMu is expected to be locked.
+func (s *StatsCtx) pruneClients(
+	tx *bbolt.Tx,
+	curID uint32,
+	limit uint32,
+	flushedID uint32,
+	flushed *unitDB,
+) (err error) {
+	if s.clientRetention == nil {
+		return nil
+	}
+
+	ivls := map[string]time.Duration{}
+	retention := func(client string) (ivl time.Duration) {
+		ivl, ok := ivls[client]
+		if !ok {
+			ivl = s.clientRetention([]string{client})
+			ivls[client] = ivl
+		}
+
+		return ivl
+	}
+
+	var ids []uint32
+	if s.retainedClients == nil {
+		s.retainedClients = map[string]*retainedClient{}
+		for id := curID - limit + 1; id != curID; id++ {
+			ids = append(ids, id)
+		}
+	}
+
+	for _, p := range flushed.Clients {
+		s.retainClient(p.Name, flushedID, retention)
+	}
+
+	if ids == nil {
+		ids = s.unitsToPrune(curID, limit, retention)
+	}
+
+	for _, id := range ids {
+		err = s.pruneUnit(tx, id, curID, retention)
+		if err != nil {
+			return fmt.Errorf("unit %d: %w", id, err)
+		}
+	}
+
+	for client, c := range s.retainedClients {
+		c.ivl = retention(client)
+	}
+
+	s.prunedID = curID
+
+	return nil
+}
+
+// unitsToPrune returns the sorted identifiers of the units to check for the
+// expired clients since the previous pruning.  It also removes the clients no
+// longer stored in the units.  confMu is expected to be locked.
+func (s *StatsCtx) unitsToPrune(
+	curID uint32,
+	limit uint32,
+	retention func(client string) (ivl time.Duration),
+) (ids []uint32) {
+	// passed is the number of hours since the previous pruning.
+	passed := curID - s.prunedID
+
+	toPrune := container.NewMapSet[uint32]()
+	for client, c := range s.retainedClients {
+		if curID-c.lastID >= limit {
+			delete(s.retainedClients, client)
+
+			continue
+		}
+
+		ivl := retention(client)
+		if ivl == 0 {
+			continue
+		}
+
+		// The units of the client older than the previous retention period
+		// have already been pruned.
+		prevAge := limit
+		if c.ivl != 0 {
+			prevAge = min(uint32(c.ivl/time.Hour)+passed, limit)
+		}
+
+		// The older units don't contain the client.
+		for age := max(uint32(ivl/time.Hour), curID-c.lastID); age < prevAge; age++ {
+			toPrune.Add(curID - age)
+		}
+	}
+
+	ids = toPrune.Values()
+	slices.Sort(ids)
+
+	return ids
+}
+
+// pruneUnit removes the expired clients from the unit with id stored within
+// tx.  It also records the clients stored in the unit.  curID is the identifier
+// of the current unit.  confMu is expected to be locked.
+func (s *StatsCtx) pruneUnit(
+	tx *bbolt.Tx,
+	id uint32,
+	curID uint32,
+	retention func(client string) (ivl time.Duration),
+) (err error) {
+	udb := s.loadUnitFromDB(tx, id)
+	if udb == nil {
+		return nil
+	}
+
+	age := time.Duration(curID-id) * time.Hour
+	clients := slices.DeleteFunc(slices.Clone(udb.Clients), func(p countPair) (del bool) {
+		ivl := retention(p.Name)
+
+		return ivl != 0 && age >= ivl
+	})
+
+	for _, p := range clients {
+		s.retainClient(p.Name, id, retention)
+	}
+
+	if len(clients) == len(udb.Clients) {
+		return nil
+	}
+
+	s.logger.Debug("pruning clients", "id", id, "num", len(udb.Clients)-len(clients))
+
+	udb.Clients = clients
+
+	return s.flushUnitToDB(udb, tx, id)
+}
+
+// retainClient records that the unit with id contains client.  The retention
+// period of a new client is considered applied, since its units are newer than
+// the ones checked previously.  confMu is expected to be locked.
+func (s *StatsCtx) retainClient(
+	client string,
+	id uint32,
+	retention func(client string) (ivl time.Duration),
+) {
+	c := s.retainedClients[client]
+	if c == nil {
+		s.retainedClients[client] = &retainedClient{ivl: retention(client), lastID: id}
+	} else {
+		c.lastID = max(c.lastID, id)
+	}
+}
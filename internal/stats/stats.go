@@ -61,6 +61,14 @@ type Config struct {
 	// ShouldCountClient returns client's ignore setting.
 	ShouldCountClient func([]string) bool
 
+	// ClientRetention returns the own retention period of the client with the
+	// given identifiers or zero if the client has no such period.  The numbers
+	// of requests of the client are removed from the stored units once its
+	// retention period expires.  Since the units are removed after Limit, the
+	// retention periods longer than Limit have no effect.  If nil, only Limit
+	// is used.
+	ClientRetention func(ids []string) (ivl time.Duration)
+
 	// HTTPRegister is the function that registers handlers for the stats
 	// endpoints.
 	HTTPRegister aghhttp.RegisterFunc
@@ -98,6 +106,9 @@ type Interface interface {
 
 	// ShouldCount returns true if request for the host should be counted.
 	ShouldCount(host string, qType, qClass uint16, ids []string) bool
+
+	// Metrics returns the snapshot of the metrics collected since the start.
+	Metrics() (m *Metrics)
 }
 
 // StatsCtx collects the statistics and flushes it to the database.  Its default
@@ -126,6 +137,9 @@ type StatsCtx struct {
 	// interface.
 	configModified func()
 
+	// metrics collects the metrics regardless of the configuration.
+	metrics *metrics
+
 	// confMu protects ignored, limit, and enabled.
 	confMu *sync.RWMutex
 
@@ -136,6 +150,19 @@ type StatsCtx struct {
 	// shouldCountClient returns client's ignore setting.
 	shouldCountClient func([]string) bool
 
+	// clientRetention returns the own retention period of the client, if any.
+	// It may be nil.
+	clientRetention func(ids []string) (ivl time.Duration)
+
+	// retainedClients maps the clients stored in the units to their data used
+	// for pruning.  It's nil until the first pruning, which checks all units,
+	// and after a failed one.  It's protected by confMu.
+	retainedClients map[string]*retainedClient
+
+	// prunedID is the identifier of the current unit at the latest pruning.
+	// It's protected by confMu.
+	prunedID uint32
+
 	// filename is the name of database file.
 	filename string
 
@@ -166,10 +193,12 @@ func New(conf Config) (s *StatsCtx, err error) {
 		httpRegister:   conf.HTTPRegister,
 		configModified: conf.ConfigModified,
 		filename:       conf.Filename,
+		metrics:        newMetrics(),
 
 		confMu:            &sync.RWMutex{},
 		ignored:           conf.Ignored,
 		shouldCountClient: conf.ShouldCountClient,
+		clientRetention:   conf.ClientRetention,
 		limit:             conf.Limit,
 		enabled:           conf.Enabled,
 	}
@@ -273,10 +302,6 @@ func (s *StatsCtx) Update(e *Entry) {
 	s.confMu.Lock()
 	defer s.confMu.Unlock()
 
-	if !s.enabled || s.limit == 0 {
-		return
-	}
-
 	err := e.validate()
 	if err != nil {
 		s.logger.Debug("validating entry", slogutil.KeyError, err)
@@ -284,6 +309,12 @@ func (s *StatsCtx) Update(e *Entry) {
 		return
 	}
 
+	s.metrics.update(e)
+
+	if !s.enabled || s.limit == 0 {
+		return
+	}
+
 	s.currMu.Lock()
 	defer s.currMu.Unlock()
 
@@ -296,6 +327,11 @@ func (s *StatsCtx) Update(e *Entry) {
 	s.curr.add(e)
 }
 
+// Metrics implements the [Interface] interface for *StatsCtx.
+func (s *StatsCtx) Metrics() (m *Metrics) {
+	return s.metrics.snapshot()
+}
+
 // WriteDiskConfig implements the [Interface] interface for *StatsCtx.
 func (s *StatsCtx) WriteDiskConfig(dc *Config) {
 	s.confMu.RLock()
@@ -433,20 +469,42 @@ func (s *StatsCtx) flush() (cont bool, sleepFor time.Duration) {
 	return s.flushDB(id, limit, ptr)
 }
 
-// flushDB flushes the unit to the database.  confMu and currMu are expected to
-// be locked.
+// flushDB flushes the unit to the database and prunes the expired clients in
+// a separate transaction.  confMu and currMu are expected to be locked.
 func (s *StatsCtx) flushDB(id, limit uint32, ptr *unit) (cont bool, sleepFor time.Duration) {
 	db := s.db.Load()
 	if db == nil {
 		return true, 0
 	}
 
+	udb := s.flushUnit(db, id, limit, ptr)
+	if udb == nil {
+		return true, 0
+	}
+
+	err := db.Update(func(tx *bbolt.Tx) (updErr error) {
+		return s.pruneClients(tx, id, limit, ptr.id, udb)
+	})
+	if err != nil {
+		s.logger.Error("pruning clients", slogutil.KeyError, err)
+
+		// Check all units next time, since the pruned ones are rolled back.
+		s.retainedClients = nil
+	}
+
+	return true, 0
+}
+
+// flushUnit writes the unit to the database, replacing it with a new one with
+// id, and removes the oldest unit.  udb is the written unit or nil if the
+// transaction couldn't be opened.  confMu and currMu are expected to be locked.
+func (s *StatsCtx) flushUnit(db *bbolt.DB, id, limit uint32, ptr *unit) (udb *unitDB) {
 	isCommitable := true
 	tx, err := db.Begin(true)
 	if err != nil {
 		s.logger.Error("opening transaction", slogutil.KeyError, err)
 
-		return true, 0
+		return nil
 	}
 	defer func() {
 		if err = finishTxn(tx, isCommitable); err != nil {
@@ -456,7 +514,7 @@ func (s *StatsCtx) flushDB(id, limit uint32, ptr *unit) (cont bool, sleepFor tim
 
 	s.curr = newUnit(id)
 
-	udb := ptr.serialize()
+	udb = ptr.serialize()
 	flushErr := s.flushUnitToDB(udb, tx, ptr.id)
 	if flushErr != nil {
 		s.logger.Error("flushing unit", slogutil.KeyError, flushErr)
@@ -477,7 +535,7 @@ func (s *StatsCtx) flushDB(id, limit uint32, ptr *unit) (cont bool, sleepFor tim
 		s.logger.Log(context.TODO(), lvl, "deleting bucket", slogutil.KeyError, delErr)
 	}
 
-	return true, 0
+	return udb
 }
 
 // periodicFlush checks and flushes the unit to the database if the freshly
@@ -3,6 +3,7 @@ package stats
 import (
 	"fmt"
 	"path/filepath"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -13,6 +14,7 @@ import (
 	"github.com/AdguardTeam/golibs/timeutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
 )
 
 func TestStats_races(t *testing.T) {
@@ -172,3 +174,197 @@ func TestStatsCtx_DataFromUnits_month(t *testing.T) {
 		require.NotNil(t, data)
 	}
 }
+
+func TestStatsCtx_pruneClients(t *testing.T) {
+	const (
+		curID = 100
+		limit = 24
+
+		shortClient  = "192.0.2.1"
+		longClient   = "192.0.2.2"
+		globalClient = "192.0.2.3"
+	)
+
+	s, err := New(Config{
+		Logger:            slogutil.NewDiscardLogger(),
+		ShouldCountClient: func([]string) bool { return true },
+		ClientRetention: func(ids []string) (ivl time.Duration) {
+			switch ids[0] {
+			case shortClient:
+				return 3 * time.Hour
+			case longClient:
+				return 10 * timeutil.Day
+			default:
+				return 0
+			}
+		},
+		UnitID:   func() (id uint32) { return curID },
+		Filename: filepath.Join(t.TempDir(), "./stats.db"),
+		Limit:    timeutil.Day,
+	})
+	require.NoError(t, err)
+
+	testutil.CleanupAndRequireSuccess(t, s.Close)
+
+	db := s.db.Load()
+	require.NotNil(t, db)
+
+	err = db.Update(func(tx *bbolt.Tx) (updErr error) {
+		for id := uint32(curID - 5); id < curID; id++ {
+			updErr = s.flushUnitToDB(&unitDB{
+				NResult: make([]uint64, resultLast),
+				Clients: []countPair{
+					{Name: shortClient, Count: 1},
+					{Name: longClient, Count: 2},
+					{Name: globalClient, Count: 3},
+				},
+				NTotal: 6,
+			}, tx, id)
+			if updErr != nil {
+				return updErr
+			}
+		}
+
+		return s.pruneClients(tx, curID, limit, curID-1, &unitDB{})
+	})
+	require.NoError(t, err)
+
+	err = db.View(func(tx *bbolt.Tx) (_ error) {
+		for id := uint32(curID - 5); id < curID; id++ {
+			udb := s.loadUnitFromDB(tx, id)
+			require.NotNil(t, udb)
+
+			names := make([]string, 0, len(udb.Clients))
+			for _, p := range udb.Clients {
+				names = append(names, p.Name)
+			}
+
+			want := []string{longClient, globalClient}
+			if curID-id < 3 {
+				want = []string{shortClient, longClient, globalClient}
+			}
+
+			assert.Equal(t, want, names, "unit %d", id)
+		}
+
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestStatsCtx_pruneClients_incremental(t *testing.T) {
+	const (
+		limit = 24
+
+		shortClient = "192.0.2.1"
+		longClient  = "192.0.2.2"
+	)
+
+	curID := uint32(100)
+	shortIvl := 3 * time.Hour
+
+	s, err := New(Config{
+		Logger:            slogutil.NewDiscardLogger(),
+		ShouldCountClient: func([]string) bool { return true },
+		ClientRetention: func(ids []string) (ivl time.Duration) {
+			switch ids[0] {
+			case shortClient:
+				return shortIvl
+			case longClient:
+				return 10 * time.Hour
+			default:
+				return 0
+			}
+		},
+		UnitID:   func() (id uint32) { return curID },
+		Filename: filepath.Join(t.TempDir(), "./stats.db"),
+		Limit:    timeutil.Day,
+	})
+	require.NoError(t, err)
+
+	testutil.CleanupAndRequireSuccess(t, s.Close)
+
+	db := s.db.Load()
+	require.NotNil(t, db)
+
+	newUnitDB := func() (udb *unitDB) {
+		return &unitDB{
+			NResult: make([]uint64, resultLast),
+			Clients: []countPair{
+				{Name: shortClient, Count: 1},
+				{Name: longClient, Count: 2},
+			},
+			NTotal: 3,
+		}
+	}
+
+	// flush writes the unit with curID-1 and prunes the units.
+	flush := func(t *testing.T) {
+		t.Helper()
+
+		err = db.Update(func(tx *bbolt.Tx) (updErr error) {
+			udb := newUnitDB()
+			updErr = s.flushUnitToDB(udb, tx, curID-1)
+			if updErr != nil {
+				return updErr
+			}
+
+			return s.pruneClients(tx, curID, limit, curID-1, udb)
+		})
+		require.NoError(t, err)
+	}
+
+	// assertShort checks that only the units with the identifiers from want
+	// contain the short client.
+	assertShort := func(t *testing.T, want ...uint32) {
+		t.Helper()
+
+		var got []uint32
+		err = db.View(func(tx *bbolt.Tx) (_ error) {
+			for id := curID - limit + 1; id != curID; id++ {
+				udb := s.loadUnitFromDB(tx, id)
+				if udb != nil && slices.ContainsFunc(udb.Clients, func(p countPair) (ok bool) {
+					return p.Name == shortClient
+				}) {
+					got = append(got, id)
+				}
+			}
+
+			return nil
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, want, got)
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) (updErr error) {
+		for id := curID - 5; id < curID-1; id++ {
+			updErr = s.flushUnitToDB(newUnitDB(), tx, id)
+			if updErr != nil {
+				return updErr
+			}
+		}
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	flush(t)
+	assertShort(t, 98, 99)
+
+	// Put the client back into an old unit to make sure that it isn't checked
+	// anymore.
+	err = db.Update(func(tx *bbolt.Tx) (updErr error) {
+		return s.flushUnitToDB(newUnitDB(), tx, 95)
+	})
+	require.NoError(t, err)
+
+	curID++
+	flush(t)
+	assertShort(t, 95, 99, 100)
+
+	curID++
+	shortIvl = time.Hour
+	flush(t)
+	assertShort(t, 95)
+}
@@ -143,6 +143,32 @@ func TestStats(t *testing.T) {
 		assert.Equal(t, cliIP, topClients[0])
 	})
 
+	t.Run("metrics", func(t *testing.T) {
+		m := s.Metrics()
+		require.NotNil(t, m)
+
+		assert.Equal(t, uint64(2), m.Queries)
+		assert.Equal(t, uint64(1), m.Blocked)
+		assert.Equal(t, uint64(0), m.CacheHits)
+		assert.Equal(t, uint64(2), m.CacheMisses)
+
+		require.Contains(t, m.Upstreams, "upstream")
+
+		h := m.Upstreams["upstream"]
+		assert.Equal(t, uint64(2), h.Count)
+		assert.Equal(t, 2*222222*time.Microsecond, h.Sum)
+
+		require.Len(t, h.Buckets, len(stats.LatencyBounds))
+
+		for i, b := range stats.LatencyBounds {
+			if b < 250*time.Millisecond {
+				assert.Zero(t, h.Buckets[i])
+			} else {
+				assert.Equal(t, uint64(2), h.Buckets[i])
+			}
+		}
+	})
+
 	t.Run("reset", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodPost, "/control/stats_reset", nil)
 		assertSuccessAndUnmarshal(t, nil, handlers["/control/stats_reset"], req)
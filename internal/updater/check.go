@@ -101,6 +101,7 @@ func (u *Updater) parseVersionResponse(data []byte) (VersionInfo, error) {
 
 	u.newVersion = info.NewVersion
 	u.packageURL = packageURL
+	u.packageSignature = versionJSON[key+signatureKeySuffix]
 
 	return info, nil
 }
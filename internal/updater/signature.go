@@ -0,0 +1,80 @@
+package updater
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// signatureKeySuffix is the suffix of the key of the package signature in the
+// version announcement.  For example, the signature of the package from
+// "download_linux_amd64" is in "download_linux_amd64_signature".
+const signatureKeySuffix = "_signature"
+
+// Signature verification errors.
+const (
+	// errNoSigningKey is returned when there is no key to verify the package
+	// signature with.
+	errNoSigningKey errors.Error = "no release signing key"
+
+	// errNoSignature is returned when the version announcement contains no
+	// signature for the package.
+	errNoSignature errors.Error = "package signature is missing"
+
+	// errBadSignature is returned when the signature doesn't match the
+	// package.
+	errBadSignature errors.Error = "package signature doesn't match"
+)
+
+// ParseSigningKey parses the base64-encoded Ed25519 public key used to verify
+// the release packages.
+func ParseSigningKey(s string) (key ed25519.PublicKey, err error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("decoding signing key: %w", err)
+	}
+
+	if l := len(data); l != ed25519.PublicKeySize {
+		return nil, fmt.Errorf(
+			"bad signing key length %d, want %d",
+			l,
+			ed25519.PublicKeySize,
+		)
+	}
+
+	return ed25519.PublicKey(data), nil
+}
+
+// verifyPackage returns an error if data isn't signed with the release signing
+// key.
+func (u *Updater) verifyPackage(data []byte) (err error) {
+	if u.skipSignatureVerification {
+		log.Info("updater: warning: package signature verification is disabled")
+
+		return nil
+	}
+
+	if u.signingKey == nil {
+		return errNoSigningKey
+	}
+
+	if u.packageSignature == "" {
+		return errNoSignature
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(u.packageSignature)
+	if err != nil {
+		return fmt.Errorf("decoding package signature: %w", err)
+	}
+
+	if !ed25519.Verify(u.signingKey, data, sig) {
+		return errBadSignature
+	}
+
+	log.Debug("updater: package signature verified")
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package updater
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSigningKey(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		want       ed25519.PublicKey
+		name       string
+		in         string
+		wantErrMsg string
+	}{{
+		want:       pub,
+		name:       "valid",
+		in:         base64.StdEncoding.EncodeToString(pub),
+		wantErrMsg: "",
+	}, {
+		want:       nil,
+		name:       "bad_base64",
+		in:         "!!!",
+		wantErrMsg: "decoding signing key: illegal base64 data at input byte 0",
+	}, {
+		want:       nil,
+		name:       "bad_length",
+		in:         base64.StdEncoding.EncodeToString(pub[:16]),
+		wantErrMsg: "bad signing key length 16, want 32",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			key, parseErr := ParseSigningKey(tc.in)
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, parseErr)
+
+			assert.Equal(t, tc.want, key)
+		})
+	}
+}
+
+func TestUpdater_verifyPackage(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	data := []byte("package data")
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, data))
+
+	tampered := []byte("package data!")
+
+	testCases := []struct {
+		key        ed25519.PublicKey
+		name       string
+		sig        string
+		wantErrMsg string
+		data       []byte
+		skip       bool
+	}{{
+		key:        pub,
+		name:       "valid",
+		sig:        sig,
+		wantErrMsg: "",
+		data:       data,
+		skip:       false,
+	}, {
+		key:        pub,
+		name:       "missing",
+		sig:        "",
+		wantErrMsg: string(errNoSignature),
+		data:       data,
+		skip:       false,
+	}, {
+		key:        pub,
+		name:       "tampered",
+		sig:        sig,
+		wantErrMsg: string(errBadSignature),
+		data:       tampered,
+		skip:       false,
+	}, {
+		key:  pub,
+		name: "bad_base64",
+		sig:  "!!!",
+		wantErrMsg: "decoding package signature: " +
+			"illegal base64 data at input byte 0",
+		data: data,
+		skip: false,
+	}, {
+		key:        nil,
+		name:       "no_key",
+		sig:        sig,
+		wantErrMsg: string(errNoSigningKey),
+		data:       data,
+		skip:       false,
+	}, {
+		key:        nil,
+		name:       "skip",
+		sig:        "",
+		wantErrMsg: "",
+		data:       tampered,
+		skip:       true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			u := &Updater{
+				signingKey:                tc.key,
+				skipSignatureVerification: tc.skip,
+				packageSignature:          tc.sig,
+			}
+
+			testutil.AssertErrorMsg(t, tc.wantErrMsg, u.verifyPackage(tc.data))
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"archive/tar"
 	"archive/zip"
 	"compress/gzip"
+	"crypto/ed25519"
 	"fmt"
 	"io"
 	"io/fs"
@@ -42,6 +43,14 @@ type Updater struct {
 	execPath        string
 	versionCheckURL string
 
+	// signingKey is the public key used to verify the signatures of the
+	// packages.
+	signingKey ed25519.PublicKey
+
+	// skipSignatureVerification, if true, disables the verification of the
+	// signatures of the packages.
+	skipSignatureVerification bool
+
 	// mu protects all fields below.
 	mu *sync.RWMutex
 
@@ -55,8 +64,9 @@ type Updater struct {
 	updateExeName  string // "workDir/agh-update-v0.103.0/AdGuardHome[.exe]"
 	unpackedFiles  []string
 
-	newVersion string
-	packageURL string
+	newVersion       string
+	packageURL       string
+	packageSignature string
 
 	// Cached fields to prevent too many API requests.
 	prevCheckError  error
@@ -97,6 +107,15 @@ type Config struct {
 
 	// ExecPath is path to the executable file.
 	ExecPath string
+
+	// SigningKey is the public key used to verify the signatures of the
+	// packages.  If it's nil and SkipSignatureVerification is false, updates
+	// are refused.
+	SigningKey ed25519.PublicKey
+
+	// SkipSignatureVerification, if true, disables the verification of the
+	// signatures of the packages.  It's intended for custom builds only.
+	SkipSignatureVerification bool
 }
 
 // NewUpdater creates a new Updater.  conf must not be nil.
@@ -116,6 +135,9 @@ func NewUpdater(conf *Config) *Updater {
 		execPath:        conf.ExecPath,
 		versionCheckURL: conf.VersionCheckURL.String(),
 
+		signingKey:                conf.SigningKey,
+		skipSignatureVerification: conf.SkipSignatureVerification,
+
 		mu: &sync.RWMutex{},
 	}
 }
@@ -129,7 +151,7 @@ func (u *Updater) Update(firstRun bool) (err error) {
 	log.Info("updater: updating")
 	defer func() {
 		if err != nil {
-			log.Info("updater: failed")
+			log.Error("updater: failed: %s", err)
 		} else {
 			log.Info("updater: finished successfully")
 		}
@@ -344,6 +366,11 @@ func (u *Updater) downloadPackageFile() (err error) {
 		return fmt.Errorf("io.ReadAll() failed: %w", err)
 	}
 
+	err = u.verifyPackage(body)
+	if err != nil {
+		return fmt.Errorf("verifying signature: %w", err)
+	}
+
 	_ = os.Mkdir(u.updateDir, aghos.DefaultPermDir)
 
 	log.Debug("updater: saving package to file")
@@ -1,6 +1,9 @@
 package updater
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -26,6 +29,9 @@ func TestUpdater_internal(t *testing.T) {
 	require.NoError(t, os.WriteFile(readmePath, []byte("README.md"), 0o644))
 	require.NoError(t, os.WriteFile(licensePath, []byte("LICENSE.txt"), 0o644))
 
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
 	testCases := []struct {
 		name        string
 		exeName     string
@@ -47,7 +53,8 @@ func TestUpdater_internal(t *testing.T) {
 		exePath := filepath.Join(wd, tc.exeName)
 
 		// Start server for returning package file.
-		pkgData, err := os.ReadFile(filepath.Join("testdata", tc.archiveName))
+		var pkgData []byte
+		pkgData, err = os.ReadFile(filepath.Join("testdata", tc.archiveName))
 		require.NoError(t, err)
 
 		fakeClient, fakeURL := aghtest.StartHTTPServer(t, pkgData)
@@ -63,10 +70,12 @@ func TestUpdater_internal(t *testing.T) {
 			// TODO(e.burkov):  Rewrite the test to use a fake version check
 			// URL with a fake URLs for the package files.
 			VersionCheckURL: &url.URL{},
+			SigningKey:      pub,
 		})
 
 		u.newVersion = "v0.103.1"
 		u.packageURL = fakeURL.String()
+		u.packageSignature = base64.StdEncoding.EncodeToString(ed25519.Sign(priv, pkgData))
 
 		require.NoError(t, u.prepare())
 		require.NoError(t, u.downloadPackageFile())
@@ -1,6 +1,9 @@
 package updater_test
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -28,7 +31,8 @@ func TestUpdater_Update(t *testing.T) {
   "announcement": "AdGuard Home v0.103.0-beta.2 is now available!",
   "announcement_url": "https://github.com/AdguardTeam/AdGuardHome/internal/releases",
   "selfupdate_min_version": "v0.0",
-  "download_linux_amd64": "%s"
+  "download_linux_amd64": "%s",
+  "download_linux_amd64_signature": "%s"
 }`
 
 	const packagePath = "/AdGuardHome.tar.gz"
@@ -48,6 +52,11 @@ func TestUpdater_Update(t *testing.T) {
 	pkgData, err := os.ReadFile("testdata/AdGuardHome_unix.tar.gz")
 	require.NoError(t, err)
 
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, pkgData))
+
 	mux := http.NewServeMux()
 	mux.HandleFunc(packagePath, func(w http.ResponseWriter, _ *http.Request) {
 		_, _ = w.Write(pkgData)
@@ -59,7 +68,7 @@ func TestUpdater_Update(t *testing.T) {
 		u, err = url.JoinPath("http://", r.Host, packagePath)
 		require.NoError(t, err)
 
-		_, _ = fmt.Fprintf(w, jsonData, u)
+		_, _ = fmt.Fprintf(w, jsonData, u, sig)
 	})
 
 	srv := httptest.NewServer(mux)
@@ -80,6 +89,7 @@ func TestUpdater_Update(t *testing.T) {
 		WorkDir:         wd,
 		ExecPath:        exePath,
 		VersionCheckURL: versionCheckURL,
+		SigningKey:      pub,
 	})
 
 	_, err = u.VersionInfo(false)
@@ -21,6 +21,11 @@ const (
 	ChannelRelease     = "release"
 )
 
+// releaseSigningKey is the base64-encoded Ed25519 public key of the AdGuard
+// Home release packages.  It's the default of signingKey, so that the builds
+// made without SIGNING_KEY still refuse the unsigned updates.
+const releaseSigningKey = "9RUMtCmHHuUMElVsRbGGD7Z1S9EbqL/2yi2PvR3oLwg="
+
 // These are set by the linker.  Unfortunately we cannot set constants during
 // linking, and Go doesn't have a concept of immutable variables, so to be
 // thorough we have to only export them through getters.
@@ -33,6 +38,7 @@ var (
 	gomips     string
 	version    string
 	committime string
+	signingKey string = releaseSigningKey
 )
 
 // Channel returns the current AdGuard Home release channel.
@@ -59,11 +65,35 @@ func GOMIPS() (v string) {
 	return gomips
 }
 
+// SigningKey returns the base64-encoded Ed25519 public key used to verify the
+// signatures of the release packages.  It's the key of the AdGuard Home
+// releases unless another one is set by the linker.
+func SigningKey() (k string) {
+	return signingKey
+}
+
 // Version returns the AdGuard Home build version.
 func Version() (v string) {
 	return version
 }
 
+// Revision returns the VCS revision AdGuard Home has been built from, if it's
+// known.  Otherwise, it returns an empty string.
+func Revision() (rev string) {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" {
+			return s.Value
+		}
+	}
+
+	return ""
+}
+
 // fmtModule returns formatted information about module.  The result looks like:
 //
 //	github.com/Username/module@v1.2.3 (sum: someHASHSUM=)
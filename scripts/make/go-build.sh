@@ -85,6 +85,11 @@ if [ "${GOARM:-}" != '' ]; then
 elif [ "${GOMIPS:-}" != '' ]; then
 	ldflags="${ldflags} -X ${version_pkg}.gomips=${GOMIPS}"
 fi
+
+# Embed the public key used to verify the signatures of the updates, if set.
+if [ "${SIGNING_KEY:-}" != '' ]; then
+	ldflags="${ldflags} -X ${version_pkg}.signingKey=${SIGNING_KEY}"
+fi
 readonly ldflags
 
 # Allow users to limit the build's parallelism.